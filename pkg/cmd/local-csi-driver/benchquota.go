@@ -0,0 +1,253 @@
+// Copyright (c) 2023 ScyllaDB.
+
+package driver
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/scylladb/local-csi-driver/pkg/driver/limit"
+	"github.com/scylladb/local-csi-driver/pkg/genericclioptions"
+	"github.com/scylladb/local-csi-driver/pkg/util/fs"
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// benchQuotaLimitBytes is the capacity limits set by the benchmark have, it doesn't affect their timing.
+	benchQuotaLimitBytes = 1 << 30
+)
+
+// benchQuotaPercentiles are the reported latency percentiles, the last one is the maximum.
+var benchQuotaPercentiles = []float64{50, 90, 99, 100}
+
+type BenchQuotaOptions struct {
+	VolumesDir string
+	Count      int
+}
+
+func NewBenchQuotaOptions(_ genericclioptions.IOStreams) *BenchQuotaOptions {
+	return &BenchQuotaOptions{
+		Count: 100,
+	}
+}
+
+func NewBenchQuotaCommand(streams genericclioptions.IOStreams) *cobra.Command {
+	o := NewBenchQuotaOptions(streams)
+
+	cmd := &cobra.Command{
+		Use:   "bench-quota",
+		Short: "Benchmark quota operations of a volumes dir",
+		Long:  `Benchmark quota operations of a volumes dir filesystem using the limiter of the driver. Every cycle creates a limit on a new directory, sets it and removes it, and latency percentiles of each operation are reported. Directories and limits created by the benchmark are removed afterwards. Limits of existing volumes aren't touched, but the benchmark competes with a driver running on the same volumes dir for quota operations.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			err := o.Validate()
+			if err != nil {
+				return err
+			}
+
+			err = o.Run(streams)
+			if err != nil {
+				return err
+			}
+
+			return nil
+		},
+
+		SilenceErrors: true,
+		SilenceUsage:  true,
+	}
+
+	cmd.Flags().StringVarP(&o.VolumesDir, "volumes-dir", "", o.VolumesDir, "Path to the volumes dir whose filesystem quota operations are benchmarked.")
+	cmd.Flags().IntVarP(&o.Count, "count", "", o.Count, "Number of limit create, set and remove cycles.")
+
+	return cmd
+}
+
+func (o *BenchQuotaOptions) Validate() error {
+	var errs []error
+
+	if len(o.VolumesDir) == 0 {
+		errs = append(errs, fmt.Errorf("volumes-dir cannot be empty"))
+	}
+
+	if o.Count <= 0 {
+		errs = append(errs, fmt.Errorf("count must be positive, got %d", o.Count))
+	}
+
+	err := errors.NewAggregate(errs)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (o *BenchQuotaOptions) Run(streams genericclioptions.IOStreams) (err error) {
+	fsType, err := fs.GetFilesystem(o.VolumesDir)
+	if err != nil {
+		return fmt.Errorf("can't get filesystem of volumes dir %q: %w", o.VolumesDir, err)
+	}
+
+	// Existing volumes don't need their limits restored, their IDs are skipped as they're in use on the filesystem.
+	limiter, err := newLimiter(o.VolumesDir, fsType, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		closeErr := limiter.Close()
+		if closeErr != nil {
+			err = errors.NewAggregate([]error{err, fmt.Errorf("can't close limiter: %w", closeErr)})
+		}
+	}()
+
+	b, err := benchmarkQuota(o.VolumesDir, limiter, o.Count)
+	if err != nil {
+		return err
+	}
+
+	err = writeQuotaBenchmark(streams.Out, fsType, b)
+	if err != nil {
+		return fmt.Errorf("can't write benchmark result: %w", err)
+	}
+
+	return nil
+}
+
+// quotaBenchmark holds latencies of every benchmarked quota operation, in the order they were measured.
+type quotaBenchmark struct {
+	newLimit    []time.Duration
+	setLimit    []time.Duration
+	removeLimit []time.Duration
+	cycle       []time.Duration
+	total       time.Duration
+}
+
+// benchmarkQuota times count cycles of creating, setting and removing a limit, each on a new directory created
+// under dir. Directories are removed afterwards, together with limits of a failed cycle.
+func benchmarkQuota(dir string, limiter limit.Limiter, count int) (b *quotaBenchmark, err error) {
+	// Name of the directory isn't a UUID, so the driver never mistakes it for a volume.
+	workDir, err := os.MkdirTemp(dir, "bench-quota-")
+	if err != nil {
+		return nil, fmt.Errorf("can't create benchmark directory in %q: %w", dir, err)
+	}
+	defer func() {
+		rmErr := os.RemoveAll(workDir)
+		if rmErr != nil {
+			err = errors.NewAggregate([]error{err, fmt.Errorf("can't remove benchmark directory %q: %w", workDir, rmErr)})
+		}
+	}()
+
+	b = &quotaBenchmark{}
+	start := time.Now()
+	for i := range count {
+		err = b.runCycle(filepath.Join(workDir, strconv.Itoa(i)), limiter)
+		if err != nil {
+			return nil, fmt.Errorf("cycle %d failed: %w", i, err)
+		}
+	}
+	b.total = time.Since(start)
+
+	return b, nil
+}
+
+func (b *quotaBenchmark) runCycle(path string, limiter limit.Limiter) error {
+	err := os.Mkdir(path, 0770)
+	if err != nil {
+		return fmt.Errorf("can't create directory %q: %w", path, err)
+	}
+
+	start := time.Now()
+	limitID, err := limiter.NewLimit(path)
+	if err != nil {
+		return fmt.Errorf("can't create limit: %w", err)
+	}
+	newLimitDone := time.Now()
+
+	err = limiter.SetLimit(limitID, benchQuotaLimitBytes, 0)
+	if err != nil {
+		rmErr := limiter.RemoveLimit(limitID)
+		if rmErr != nil {
+			klog.ErrorS(rmErr, "Can't remove limit of failed cycle", "limitID", limitID)
+		}
+		return fmt.Errorf("can't set limit %d: %w", limitID, err)
+	}
+	setLimitDone := time.Now()
+
+	err = limiter.RemoveLimit(limitID)
+	if err != nil {
+		return fmt.Errorf("can't remove limit %d: %w", limitID, err)
+	}
+	removeLimitDone := time.Now()
+
+	b.newLimit = append(b.newLimit, newLimitDone.Sub(start))
+	b.setLimit = append(b.setLimit, setLimitDone.Sub(newLimitDone))
+	b.removeLimit = append(b.removeLimit, removeLimitDone.Sub(setLimitDone))
+	b.cycle = append(b.cycle, removeLimitDone.Sub(start))
+
+	return nil
+}
+
+// percentile returns the latency which p percent of latencies don't exceed, using the nearest rank method.
+func percentile(latencies []time.Duration, p float64) time.Duration {
+	if len(latencies) == 0 {
+		return 0
+	}
+
+	sorted := make([]time.Duration, len(latencies))
+	copy(sorted, latencies)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i] < sorted[j]
+	})
+
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	return sorted[max(rank, 1)-1]
+}
+
+func writeQuotaBenchmark(w io.Writer, fsType string, b *quotaBenchmark) error {
+	header := []string{"OPERATION"}
+	for _, p := range benchQuotaPercentiles {
+		if p == 100 {
+			header = append(header, "MAX")
+			continue
+		}
+		header = append(header, fmt.Sprintf("P%g", p))
+	}
+
+	lines := []string{
+		fmt.Sprintf("Filesystem: %s, cycles: %d, total: %v, rate: %.1f cycles/s", fsType, len(b.cycle), b.total, float64(len(b.cycle))/b.total.Seconds()),
+		"",
+		strings.Join(header, "\t"),
+	}
+	for _, op := range []struct {
+		name      string
+		latencies []time.Duration
+	}{
+		{name: "NewLimit", latencies: b.newLimit},
+		{name: "SetLimit", latencies: b.setLimit},
+		{name: "RemoveLimit", latencies: b.removeLimit},
+		{name: "Cycle", latencies: b.cycle},
+	} {
+		row := []string{op.name}
+		for _, p := range benchQuotaPercentiles {
+			row = append(row, percentile(op.latencies, p).String())
+		}
+		lines = append(lines, strings.Join(row, "\t"))
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	_, err := fmt.Fprintln(tw, strings.Join(lines, "\n"))
+	if err != nil {
+		return err
+	}
+
+	return tw.Flush()
+}
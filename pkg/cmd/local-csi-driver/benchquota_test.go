@@ -0,0 +1,181 @@
+// Copyright (c) 2023 ScyllaDB.
+
+package driver
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/scylladb/local-csi-driver/pkg/driver/limit"
+)
+
+// recordingLimiter hands out consecutive limit IDs and records which of them were removed.
+type recordingLimiter struct {
+	limit.NoopLimiter
+
+	lastID        uint32
+	removedLimits []uint32
+	setLimitErr   error
+}
+
+func (l *recordingLimiter) NewLimit(string) (uint32, error) {
+	l.lastID++
+	return l.lastID, nil
+}
+
+func (l *recordingLimiter) SetLimit(uint32, int64, uint64) error {
+	return l.setLimitErr
+}
+
+func (l *recordingLimiter) RemoveLimit(limitID uint32) error {
+	l.removedLimits = append(l.removedLimits, limitID)
+	return nil
+}
+
+func TestBenchmarkQuota(t *testing.T) {
+	t.Parallel()
+
+	tt := []struct {
+		name            string
+		setLimitErr     error
+		expectedErr     error
+		expectedRemoved []uint32
+	}{
+		{
+			name:            "every limit is removed",
+			expectedRemoved: []uint32{1, 2, 3},
+		},
+		{
+			name:            "limit of a failed cycle is removed",
+			setLimitErr:     syscall.EIO,
+			expectedErr:     syscall.EIO,
+			expectedRemoved: []uint32{1},
+		},
+	}
+
+	for i := range tt {
+		tc := tt[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			dir := t.TempDir()
+			limiter := &recordingLimiter{setLimitErr: tc.setLimitErr}
+
+			b, err := benchmarkQuota(dir, limiter, 3)
+			if !errors.Is(err, tc.expectedErr) {
+				t.Fatalf("expected error %v, got %v", tc.expectedErr, err)
+			}
+
+			if !reflect.DeepEqual(limiter.removedLimits, tc.expectedRemoved) {
+				t.Errorf("expected removed limits %v, got %v", tc.expectedRemoved, limiter.removedLimits)
+			}
+
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != 0 {
+				t.Errorf("expected benchmark directories to be removed, got %v", entries)
+			}
+
+			if tc.expectedErr != nil {
+				return
+			}
+
+			for _, latencies := range [][]time.Duration{b.newLimit, b.setLimit, b.removeLimit, b.cycle} {
+				if len(latencies) != 3 {
+					t.Errorf("expected latencies of 3 cycles, got %v", latencies)
+				}
+			}
+
+			out := &bytes.Buffer{}
+			err = writeQuotaBenchmark(out, "xfs", b)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, expected := range []string{"Filesystem: xfs, cycles: 3", "OPERATION", "P99", "MAX", "NewLimit", "SetLimit", "RemoveLimit", "Cycle"} {
+				if !strings.Contains(out.String(), expected) {
+					t.Errorf("expected output to contain %q, got %q", expected, out.String())
+				}
+			}
+		})
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	t.Parallel()
+
+	latencies := []time.Duration{5, 1, 4, 2, 3, 10, 9, 8, 7, 6}
+
+	tt := []struct {
+		percentile float64
+		expected   time.Duration
+	}{
+		{percentile: 0, expected: 1},
+		{percentile: 50, expected: 5},
+		{percentile: 90, expected: 9},
+		{percentile: 99, expected: 10},
+		{percentile: 100, expected: 10},
+	}
+
+	for _, tc := range tt {
+		got := percentile(latencies, tc.percentile)
+		if got != tc.expected {
+			t.Errorf("expected P%g to be %v, got %v", tc.percentile, tc.expected, got)
+		}
+	}
+
+	if got := percentile(nil, 50); got != 0 {
+		t.Errorf("expected percentile of no latencies to be zero, got %v", got)
+	}
+}
+
+func TestBenchQuotaOptionsValidate(t *testing.T) {
+	t.Parallel()
+
+	tt := []struct {
+		name        string
+		volumesDir  string
+		count       int
+		expectedErr bool
+	}{
+		{
+			name:       "valid options",
+			volumesDir: "/mnt/persistent-volumes",
+			count:      100,
+		},
+		{
+			name:        "missing volumes dir",
+			count:       100,
+			expectedErr: true,
+		},
+		{
+			name:        "non-positive count",
+			volumesDir:  "/mnt/persistent-volumes",
+			expectedErr: true,
+		},
+	}
+
+	for i := range tt {
+		tc := tt[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			o := &BenchQuotaOptions{
+				VolumesDir: tc.volumesDir,
+				Count:      tc.count,
+			}
+			err := o.Validate()
+			if tc.expectedErr != (err != nil) {
+				t.Errorf("expected error %v, got %v", tc.expectedErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright (c) 2023 ScyllaDB.
+
+package driver
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/spf13/pflag"
+	"k8s.io/apimachinery/pkg/util/errors"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	configFlagName = "config"
+)
+
+// loadConfigFile sets flags from a YAML file mapping flag names to their values.
+// Flags explicitly set on the command line take precedence over the file.
+func loadConfigFile(flags *pflag.FlagSet, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("can't read config file %q: %w", path, err)
+	}
+
+	jsonData, err := yaml.YAMLToJSON(data)
+	if err != nil {
+		return fmt.Errorf("can't parse config file %q: %w", path, err)
+	}
+
+	// Numbers are kept as they were written, so large integers don't lose precision.
+	config := map[string]any{}
+	decoder := json.NewDecoder(bytes.NewReader(jsonData))
+	decoder.UseNumber()
+	err = decoder.Decode(&config)
+	if err != nil {
+		return fmt.Errorf("can't decode config file %q: %w", path, err)
+	}
+
+	keys := make([]string, 0, len(config))
+	for k := range config {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var errs []error
+	for _, k := range keys {
+		err = setFlagFromConfig(flags, k, config[k])
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	err = errors.NewAggregate(errs)
+	if err != nil {
+		return fmt.Errorf("invalid config file %q: %w", path, err)
+	}
+
+	return nil
+}
+
+func setFlagFromConfig(flags *pflag.FlagSet, name string, value any) error {
+	if name == configFlagName {
+		return fmt.Errorf("config file can't set %q", name)
+	}
+
+	f := flags.Lookup(name)
+	if f == nil {
+		return fmt.Errorf("unknown flag %q", name)
+	}
+
+	if f.Changed {
+		return nil
+	}
+
+	if values, ok := value.([]any); ok {
+		sv, ok := f.Value.(pflag.SliceValue)
+		if !ok {
+			return fmt.Errorf("flag %q doesn't accept a list", name)
+		}
+
+		items := make([]string, 0, len(values))
+		for _, v := range values {
+			items = append(items, fmt.Sprint(v))
+		}
+
+		err := sv.Replace(items)
+		if err != nil {
+			return fmt.Errorf("can't set flag %q: %w", name, err)
+		}
+		f.Changed = true
+
+		return nil
+	}
+
+	var s string
+	switch v := value.(type) {
+	case map[string]any:
+		pairs := make([]string, 0, len(v))
+		for k, pv := range v {
+			pairs = append(pairs, fmt.Sprintf("%s=%v", k, pv))
+		}
+		sort.Strings(pairs)
+		s = strings.Join(pairs, ",")
+	case nil:
+		return fmt.Errorf("flag %q can't be null", name)
+	default:
+		s = fmt.Sprint(v)
+	}
+
+	err := flags.Set(name, s)
+	if err != nil {
+		return fmt.Errorf("can't set flag %q: %w", name, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,114 @@
+// Copyright (c) 2023 ScyllaDB.
+
+package driver
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/scylladb/local-csi-driver/pkg/genericclioptions"
+	"github.com/spf13/pflag"
+)
+
+func TestLoadConfigFile(t *testing.T) {
+	t.Parallel()
+
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	err := os.WriteFile(configPath, []byte(`
+driver-name: local.csi.example.com
+volumes-dir: /mnt/volumes
+listen: /csi/csi.sock
+node-name: from-config
+disable-topology: true
+max-total-provisioned-bytes: 1099511627776
+unmount-retry-delay: 250ms
+filesystem-capacity-reservation-percent:
+  xfs: 1
+  ext4: 3
+`), 0640)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	o := NewLocalDriverOptions(genericclioptions.IOStreams{})
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	o.AddFlags(flags)
+
+	err = flags.Parse([]string{"--config=" + configPath, "--node-name=from-flag"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = loadConfigFile(flags, configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := NewLocalDriverOptions(genericclioptions.IOStreams{})
+	expected.ConfigFile = configPath
+	expected.DriverName = "local.csi.example.com"
+	expected.VolumesDir = []string{"/mnt/volumes"}
+	expected.Listen = "/csi/csi.sock"
+	expected.NodeName = "from-flag"
+	expected.DisableTopology = true
+	expected.MaxTotalProvisionedBytes = 1 << 40
+	expected.UnmountRetryDelay = 250 * time.Millisecond
+	expected.FilesystemCapacityReservationPercent = map[string]int{
+		"xfs":  1,
+		"ext4": 3,
+	}
+
+	if !reflect.DeepEqual(o, expected) {
+		t.Errorf("expected options %#v, got %#v", expected, o)
+	}
+}
+
+func TestLoadConfigFileRejectsInvalidConfig(t *testing.T) {
+	t.Parallel()
+
+	tt := []struct {
+		name   string
+		config string
+	}{
+		{
+			name:   "unknown flag",
+			config: "unknown-flag: value",
+		},
+		{
+			name:   "config file referencing another one",
+			config: "config: /etc/other.yaml",
+		},
+		{
+			name:   "invalid flag value",
+			config: "unmount-retries: many",
+		},
+		{
+			name:   "not a mapping",
+			config: "- driver-name",
+		},
+	}
+
+	for i := range tt {
+		tc := tt[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			configPath := filepath.Join(t.TempDir(), "config.yaml")
+			err := os.WriteFile(configPath, []byte(tc.config), 0640)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+			NewLocalDriverOptions(genericclioptions.IOStreams{}).AddFlags(flags)
+
+			err = loadConfigFile(flags, configPath)
+			if err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
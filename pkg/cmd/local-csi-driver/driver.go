@@ -4,38 +4,156 @@ package driver
 
 import (
 	"context"
+	stderrors "errors"
 	"fmt"
 	"net"
+	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/scylladb/local-csi-driver/pkg/cmdutil"
 	"github.com/scylladb/local-csi-driver/pkg/driver"
+	"github.com/scylladb/local-csi-driver/pkg/driver/audit"
 	"github.com/scylladb/local-csi-driver/pkg/driver/limit"
+	"github.com/scylladb/local-csi-driver/pkg/driver/limit/ext4"
 	"github.com/scylladb/local-csi-driver/pkg/driver/limit/xfs"
+	"github.com/scylladb/local-csi-driver/pkg/driver/policy"
 	"github.com/scylladb/local-csi-driver/pkg/driver/volume"
 	"github.com/scylladb/local-csi-driver/pkg/genericclioptions"
 	"github.com/scylladb/local-csi-driver/pkg/signals"
 	"github.com/scylladb/local-csi-driver/pkg/util/fs"
+	"github.com/scylladb/local-csi-driver/pkg/util/slices"
 	"github.com/scylladb/local-csi-driver/pkg/version"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"go.opentelemetry.io/otel/attribute"
+	sdkresource "go.opentelemetry.io/otel/sdk/resource"
 	"golang.org/x/sync/errgroup"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"k8s.io/apimachinery/pkg/api/resource"
 	"k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
 	cliflag "k8s.io/component-base/cli/flag"
+	"k8s.io/component-base/tracing"
+	tracingapi "k8s.io/component-base/tracing/api/v1"
 	"k8s.io/klog/v2"
 )
 
+// nonEmptyVolumeDirectoryCodes are the codes CreateVolume can fail with when a new volume's directory isn't empty.
+var nonEmptyVolumeDirectoryCodes = []codes.Code{
+	codes.Internal,
+	codes.AlreadyExists,
+}
+
+// defaultShutdownTimeout leaves time for the rest of the shutdown within the default pod termination grace period.
+const defaultShutdownTimeout = 20 * time.Second
+
+var stateBackends = []volume.StateBackendType{
+	volume.FilesStateBackend,
+	volume.BoltStateBackend,
+}
+
+var capacityPolicies = []volume.CapacityPolicy{
+	volume.MaxCapacityPolicy,
+	volume.SumCapacityPolicy,
+}
+
+// failUnrestoredQuotaPolicy makes the driver fail to start when a limit of any volume can't be restored.
+const failUnrestoredQuotaPolicy = "fail"
+
+var unrestoredQuotaPolicies = []string{
+	failUnrestoredQuotaPolicy,
+	string(driver.StrictUnrestoredQuotaPolicy),
+	string(driver.PermissiveUnrestoredQuotaPolicy),
+}
+
 type LocalDriverOptions struct {
-	DriverName string
-	Listen     string
-	VolumesDir string
-	NodeName   string
+	ConfigFile string
+
+	DriverName                  string
+	Listen                      string
+	SocketMode                  string
+	VolumesDir                  []string
+	CapacityPolicy              string
+	NodeName                    string
+	StateBackend                string
+	StateReadDirBatchSize       int
+	SkipCorruptState            bool
+	CompressQuarantinedState    bool
+	QuarantinedStateMaxBytes    int64
+	AdminAddress                string
+	AdminTokenFile              string
+	MetricsAddress              string
+	HealthAddress               string
+	ProbeCacheTTL               time.Duration
+	CapacityCacheTTL            time.Duration
+	FilesystemRetries           int
+	CreateVolumeRetries         int
+	MaxPendingReservations      int
+	FilesystemRetryDelay        time.Duration
+	UnmountRetries              int
+	UnmountRetryDelay           time.Duration
+	LazyUnmount                 bool
+	BytesPerInode               int64
+	DisableTopology             bool
+	TopologyLabels              map[string]string
+	ReservedCapacity            string
+	MaxTotalProvisionedBytes    int64
+	ReconcileOnStartup          bool
+	KubeletPodsDir              string
+	OrphanedMountSweepInterval  time.Duration
+	LeakDetectionInterval       time.Duration
+	LeakGracePeriod             time.Duration
+	CleanupLeakedVolumes        bool
+	NonEmptyVolumeDirectoryCode string
+	MaxSyncedVolumes            int
+	MaxVolumesPerNode           int64
+	CapacityDegradationPercent  int
+	UnrestoredQuotaPolicy       string
+	VolumeIOStats               bool
+	VolumeMountGroup            bool
+	RejectMismatchingFsType     bool
+	RejectNodeNameMismatch      bool
+	AuditLogPath                string
+	ProvisioningPolicyFile      string
+	OTLPEndpoint                string
+	ShutdownTimeout             time.Duration
+
+	FilesystemCapacityReservationPercent map[string]int
+
+	// newLimiter overrides how limiters of volumes dirs are created.
+	newLimiter func(dir, fsType string, volumes []volume.VolumeState) (limit.Limiter, error)
 }
 
 func NewLocalDriverOptions(_ genericclioptions.IOStreams) *LocalDriverOptions {
 	return &LocalDriverOptions{
-		DriverName: "local.csi.scylladb.com",
+		DriverName:                  "local.csi.scylladb.com",
+		StateBackend:                string(volume.FilesStateBackend),
+		StateReadDirBatchSize:       volume.DefaultReadDirBatchSize,
+		SkipCorruptState:            true,
+		ProbeCacheTTL:               driver.DefaultProbeCacheTTL,
+		CapacityCacheTTL:            volume.DefaultCapacityCacheTTL,
+		FilesystemRetries:           volume.DefaultFilesystemRetryBackoff.Steps - 1,
+		FilesystemRetryDelay:        volume.DefaultFilesystemRetryBackoff.Duration,
+		UnmountRetries:              volume.DefaultUnmountRetryBackoff.Steps - 1,
+		UnmountRetryDelay:           volume.DefaultUnmountRetryBackoff.Duration,
+		KubeletPodsDir:              driver.DefaultKubeletPodsDir,
+		LeakGracePeriod:             driver.DefaultLeakGracePeriod,
+		NonEmptyVolumeDirectoryCode: codes.Internal.String(),
+		MaxSyncedVolumes:            driver.DefaultMaxSyncedVolumes,
+		MaxVolumesPerNode:           driver.DefaultMaxVolumesPerNode,
+		CapacityPolicy:              string(volume.MaxCapacityPolicy),
+		UnrestoredQuotaPolicy:       failUnrestoredQuotaPolicy,
+		ShutdownTimeout:             defaultShutdownTimeout,
+
+		FilesystemCapacityReservationPercent: map[string]int{},
 	}
 }
 
@@ -47,6 +165,13 @@ func NewLocalDriverCommand(streams genericclioptions.IOStreams) *cobra.Command {
 		Short: "Run the Local CSI Driver",
 		Long:  `Run the Local CSI Driver.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(o.ConfigFile) != 0 {
+				err := loadConfigFile(cmd.Flags(), o.ConfigFile)
+				if err != nil {
+					return err
+				}
+			}
+
 			err := o.Validate()
 			if err != nil {
 				return err
@@ -69,16 +194,70 @@ func NewLocalDriverCommand(streams genericclioptions.IOStreams) *cobra.Command {
 		SilenceUsage:  true,
 	}
 
-	cmd.Flags().StringVarP(&o.DriverName, "driver-name", "", o.DriverName, "Name of the driver used for registration.")
-	cmd.Flags().StringVarP(&o.VolumesDir, "volumes-dir", "", o.VolumesDir, "Path to directory where driver provisions the volumes.")
-	cmd.Flags().StringVarP(&o.Listen, "listen", "", o.Listen, "Path to the driver socket.")
-	cmd.Flags().StringVarP(&o.NodeName, "node-name", "", o.NodeName, "Name of the node for which the driver is responsible of.")
+	o.AddFlags(cmd.Flags())
+
+	cmd.AddCommand(NewSupportBundleCommand(streams))
+	cmd.AddCommand(NewValidateParamsCommand(streams))
+	cmd.AddCommand(NewBenchQuotaCommand(streams))
 
 	cmdutil.InstallKlog(cmd)
 
 	return cmd
 }
 
+func (o *LocalDriverOptions) AddFlags(flags *pflag.FlagSet) {
+	flags.StringVarP(&o.ConfigFile, configFlagName, "", o.ConfigFile, "Path to a YAML file setting flags keyed by their names. Flags set on the command line take precedence.")
+	flags.StringVarP(&o.DriverName, "driver-name", "", o.DriverName, "Name of the driver used for registration.")
+	flags.StringSliceVarP(&o.VolumesDir, "volumes-dir", "", o.VolumesDir, "Path to directory where driver provisions the volumes. It can be repeated or comma separated to provision volumes on several disks, new volumes land in the directory with the most available capacity. Volume states are kept in the first one.")
+	flags.StringVarP(&o.UnrestoredQuotaPolicy, "unrestored-quota-policy", "", o.UnrestoredQuotaPolicy, fmt.Sprintf("What happens when limits of some volumes can't be restored on startup: fail the startup, refuse publishing them (strict), or publish them unenforced with a warning (permissive). One of: %v.", unrestoredQuotaPolicies))
+	flags.StringVarP(&o.CapacityPolicy, "capacity-policy", "", o.CapacityPolicy, fmt.Sprintf("How available capacity of multiple volumes dirs is reported. One of: %v.", capacityPolicies))
+	flags.StringVarP(&o.Listen, "listen", "", o.Listen, "Path to the driver socket.")
+	flags.StringVarP(&o.SocketMode, "socket-mode", "", o.SocketMode, "Octal permissions of the driver socket, e.g. 0660. When empty, the socket is created with permissions following the umask.")
+	flags.StringVarP(&o.NodeName, "node-name", "", o.NodeName, "Name of the node for which the driver is responsible of.")
+	flags.StringVarP(&o.AdminAddress, "admin-address", "", o.AdminAddress, "Address on which the node local diagnostic HTTP endpoints are served. Disabled when empty.")
+	flags.StringVarP(&o.AdminTokenFile, "admin-token-file", "", o.AdminTokenFile, "Path of a file holding the bearer token which authenticates mutating admin endpoints, like forced volume deletion. They're disabled when empty.")
+	flags.StringVarP(&o.HealthAddress, "health-address", "", o.HealthAddress, "Address on which liveness and readiness probes are served at /healthz and /readyz. Disabled when empty.")
+	flags.StringVarP(&o.MetricsAddress, "metrics-address", "", o.MetricsAddress, "Address on which Prometheus metrics are served at /metrics. Disabled when empty.")
+	flags.StringVarP(&o.StateBackend, "state-backend", "", o.StateBackend, fmt.Sprintf("How volume and snapshot states are persisted in the first volumes dir: a JSON file per state (files), or all of them in a single %q BoltDB file (bolt), which loads faster on nodes with many volumes. Switching backends of an existing volumes dir isn't supported, the driver refuses to start when it holds states of the other one. One of: %v.", volume.BoltStateFileName, stateBackends))
+	flags.IntVarP(&o.StateReadDirBatchSize, "state-read-dir-batch-size", "", o.StateReadDirBatchSize, "Maximum number of volumes directory entries read at once when loading volume state on startup.")
+	flags.StringToStringVarP(&o.TopologyLabels, "topology-labels", "", o.TopologyLabels, fmt.Sprintf("Additional topology segments of the node and volumes created on it, like topology.kubernetes.io/zone=us-east-1a. Kubelet labels the node with them, so they have to be valid labels. %q segment is always set to the node name.", driver.NodeNameTopologyKey))
+	flags.BoolVarP(&o.DisableTopology, "disable-topology", "", o.DisableTopology, "Don't constrain volumes to the node they were created on. Only safe on single node clusters, every node would otherwise provision volumes which can't be accessed from where they are scheduled.")
+	flags.BoolVarP(&o.SkipCorruptState, "skip-corrupt-state", "", o.SkipCorruptState, fmt.Sprintf("Quarantine volume state files which can't be parsed by renaming them with %q suffix, instead of refusing to start.", volume.CorruptStateFileSuffix))
+	flags.BoolVarP(&o.CompressQuarantinedState, "compress-quarantined-state", "", o.CompressQuarantinedState, fmt.Sprintf("Gzip compress state files of the files backend when they're quarantined, adding %q suffix.", volume.CompressedStateFileSuffix))
+	flags.Int64VarP(&o.QuarantinedStateMaxBytes, "quarantined-state-max-bytes", "", o.QuarantinedStateMaxBytes, "Maximum total size of quarantined state files of the files backend. The least recently quarantined ones are removed on startup until the rest fits. Zero means there is no maximum.")
+	flags.DurationVarP(&o.ProbeCacheTTL, "probe-cache-ttl", "", o.ProbeCacheTTL, "For how long the result of a volumes dir health probe is reused by subsequent probes. Zero disables caching.")
+	flags.DurationVarP(&o.CapacityCacheTTL, "capacity-cache-ttl", "", o.CapacityCacheTTL, "For how long statistics of the volumes dir filesystem are reused for computing available capacity. They're checked again after every volume creation and deletion. Zero disables caching.")
+	flags.IntVarP(&o.FilesystemRetries, "filesystem-retries", "", o.FilesystemRetries, "How many times volume directory operations failing with a transient error (EBUSY, EINTR, EAGAIN) are retried.")
+	flags.IntVarP(&o.CreateVolumeRetries, "create-volume-retries", "", o.CreateVolumeRetries, "How many times a volume creation failing with a transient error (EBUSY, EINTR, EAGAIN) is rolled back and attempted again as a whole, on top of retries of its individual directory operations.")
+	flags.IntVarP(&o.MaxPendingReservations, "max-pending-reservations", "", o.MaxPendingReservations, "Maximum number of volumes being created concurrently. Once it's reached, new CreateVolume calls fail with Unavailable so the provisioner retries them later, instead of piling up on the node. Zero means no limit.")
+	flags.DurationVarP(&o.FilesystemRetryDelay, "filesystem-retry-delay", "", o.FilesystemRetryDelay, "Initial delay between retries of volume directory operations, doubled with every retry.")
+	flags.IntVarP(&o.UnmountRetries, "unmount-retries", "", o.UnmountRetries, "How many times unmounts failing because the mount is busy are retried.")
+	flags.DurationVarP(&o.UnmountRetryDelay, "unmount-retry-delay", "", o.UnmountRetryDelay, "Initial delay between retries of busy unmounts, doubled with every retry.")
+	flags.BoolVarP(&o.LazyUnmount, "lazy-unmount", "", o.LazyUnmount, "Detach mounts which are still busy after all unmount retries lazily, leaving their cleanup to the kernel.")
+	flags.Int64VarP(&o.BytesPerInode, "bytes-per-inode", "", o.BytesPerInode, "Limits volumes without an explicit inodeLimit parameter to one inode per this many bytes of their capacity. Zero disables inode limits of such volumes.")
+	flags.StringVarP(&o.ReservedCapacity, "reserved-capacity", "", o.ReservedCapacity, `Capacity of the volumes dir filesystem kept free for the host, either as a quantity like "5Gi", or a percentage of the filesystem size like "10%". It's excluded from available capacity on top of the filesystem capacity reservation.`)
+	flags.BoolVarP(&o.ReconcileOnStartup, "reconcile-on-startup", "", o.ReconcileOnStartup, "Move volume directories without a volume state aside and remove limits not used by any volume on startup. They are leaked when the driver is killed while creating or deleting a volume. Skipped while any state is quarantined.")
+	flags.StringVarP(&o.KubeletPodsDir, "kubelet-pods-dir", "", o.KubeletPodsDir, "Path to the directory where kubelet publishes volumes of pods.")
+	flags.DurationVarP(&o.OrphanedMountSweepInterval, "orphaned-mount-sweep-interval", "", o.OrphanedMountSweepInterval, "How often mounts of no longer existing volumes left in kubelet-pods-dir, e.g. by force deleted pods, are lazily unmounted. Zero disables the sweeper.")
+	flags.DurationVarP(&o.LeakDetectionInterval, "leak-detection-interval", "", o.LeakDetectionInterval, "How often volumes having a state but no directory, and volume directories without a state, are looked for. They're reported in metrics and by the admin endpoint. Zero disables the detection.")
+	flags.DurationVarP(&o.LeakGracePeriod, "leak-grace-period", "", o.LeakGracePeriod, "For how long a volume state and directory have to be inconsistent before the volume is suspected to be leaked.")
+	flags.BoolVarP(&o.CleanupLeakedVolumes, "cleanup-leaked-volumes", "", o.CleanupLeakedVolumes, "Delete volumes suspected to be leaked by the leak detection, instead of only reporting them.")
+	flags.StringVarP(&o.NonEmptyVolumeDirectoryCode, "non-empty-volume-directory-code", "", o.NonEmptyVolumeDirectoryCode, fmt.Sprintf("gRPC code returned when a new volume's directory already exists with unknown data in it, instead of reusing the data. One of: %v.", nonEmptyVolumeDirectoryCodes))
+	flags.StringVarP(&o.AuditLogPath, "audit-log-path", "", o.AuditLogPath, "Path of a file volume creations, deletions, mounts and unmounts are appended to as JSON lines. Disabled when empty.")
+	flags.StringVarP(&o.ProvisioningPolicyFile, "provisioning-policy-file", "", o.ProvisioningPolicyFile, "Path of a YAML file with rules rejecting volumes by their size, namespace and StorageClass before they're provisioned. Rejected volumes fail with FailedPrecondition code. Every volume is admitted when empty.")
+	flags.StringVarP(&o.OTLPEndpoint, "otlp-endpoint", "", o.OTLPEndpoint, "Address of an OpenTelemetry collector, e.g. localhost:4317, spans of CSI requests are exported to over insecure OTLP gRPC. Requests are traced when their caller's span is sampled. Disabled when empty.")
+	flags.DurationVarP(&o.ShutdownTimeout, "shutdown-timeout", "", o.ShutdownTimeout, "For how long in-flight requests, like creations of volumes copying their content source, are waited for on shutdown before they're cut off. New requests are rejected meanwhile.")
+	flags.BoolVarP(&o.VolumeMountGroup, "volume-mount-group", "", o.VolumeMountGroup, "Advertise VOLUME_MOUNT_GROUP capability, so kubelet delegates applying fsGroup of pods to the driver instead of changing ownership of volumes itself.")
+	flags.BoolVarP(&o.VolumeIOStats, "volume-io-stats", "", o.VolumeIOStats, "Report read and write statistics of volumes exposed through their own loop device, i.e. block and loop backed volumes, in metrics and NodeGetVolumeStats logs. Directory backed volumes share the device of the volumes dir and have no statistics of their own.")
+	flags.BoolVarP(&o.RejectMismatchingFsType, "reject-mismatching-fs-type", "", o.RejectMismatchingFsType, "Fail provisioning of directory backed volumes requesting an fsType other than the volumes dir filesystem with ResourceExhausted code, so they're provisioned on another node, instead of ignoring the requested fsType.")
+	flags.BoolVarP(&o.RejectNodeNameMismatch, "reject-node-name-mismatch", "", o.RejectNodeNameMismatch, "Fail to start when any volume was created on a node of a different name than node-name, instead of only warning about it. Topology of such volumes follows the current node name, so it no longer matches their PersistentVolumes.")
+	flags.IntVarP(&o.MaxSyncedVolumes, "max-synced-volumes", "", o.MaxSyncedVolumes, "Maximum number of published volumes whose filesystems are periodically synced because of their syncInterval parameter.")
+	flags.Int64VarP(&o.MaxVolumesPerNode, "max-volumes-per-node", "", o.MaxVolumesPerNode, "Maximum number of volumes provisioned on the node. It's reported to the scheduler, creating more volumes fails with ResourceExhausted code.")
+	flags.IntVarP(&o.CapacityDegradationPercent, "capacity-degradation-threshold-percent", "", o.CapacityDegradationPercent, "Percentage of max-volumes-per-node past which reported available capacity is reduced proportionally to the volumes which can still be provisioned, so the scheduler prefers other nodes before this one hits the limit. Disabled when zero.")
+	flags.Int64VarP(&o.MaxTotalProvisionedBytes, "max-total-provisioned-bytes", "", o.MaxTotalProvisionedBytes, "Maximum sum of sizes of all volumes provisioned on the node, regardless of the volumes dir filesystem size. Zero means there is no maximum.")
+	flags.StringToIntVarP(&o.FilesystemCapacityReservationPercent, "filesystem-capacity-reservation-percent", "", o.FilesystemCapacityReservationPercent, fmt.Sprintf("Percentage of the volumes dir filesystem size which isn't reported as available capacity, keyed by filesystem type. Overrides the defaults: %v.", volume.DefaultFilesystemCapacityReservationPercent))
+}
+
 func (o *LocalDriverOptions) Validate() error {
 	var errs []error
 
@@ -90,19 +269,172 @@ func (o *LocalDriverOptions) Validate() error {
 		errs = append(errs, fmt.Errorf("listen cannot be empty"))
 	}
 
+	if len(o.SocketMode) != 0 {
+		_, err := parseSocketMode(o.SocketMode)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid socket-mode: %w", err))
+		}
+	}
+
 	if len(o.VolumesDir) == 0 {
 		errs = append(errs, fmt.Errorf("volumes-dir cannot be empty"))
 	}
 
-	_, err := os.Stat(o.VolumesDir)
-	if err != nil {
-		errs = append(errs, fmt.Errorf("can't stat volumes-dir: %w", err))
+	// Limiters of volumes dirs sharing a filesystem would allocate the same project IDs, and remove limits
+	// of each other's volumes as unused.
+	devices := make(map[uint64]string, len(o.VolumesDir))
+	for _, dir := range o.VolumesDir {
+		if len(dir) == 0 {
+			errs = append(errs, fmt.Errorf("volumes-dir cannot be empty"))
+			continue
+		}
+
+		fi, err := os.Stat(dir)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("can't stat volumes-dir: %w", err))
+			continue
+		}
+
+		stat, ok := fi.Sys().(*syscall.Stat_t)
+		if !ok {
+			continue
+		}
+		if otherDir, ok := devices[stat.Dev]; ok {
+			errs = append(errs, fmt.Errorf("volumes-dir %q and %q are on the same filesystem, every volumes dir needs a filesystem of its own", otherDir, dir))
+			continue
+		}
+		devices[stat.Dev] = dir
+	}
+
+	if !slices.Contains(capacityPolicies, volume.CapacityPolicy(o.CapacityPolicy)) {
+		errs = append(errs, fmt.Errorf("capacity-policy must be one of %v, got %q", capacityPolicies, o.CapacityPolicy))
+	}
+
+	if !slices.Contains(unrestoredQuotaPolicies, o.UnrestoredQuotaPolicy) {
+		errs = append(errs, fmt.Errorf("unrestored-quota-policy must be one of %v, got %q", unrestoredQuotaPolicies, o.UnrestoredQuotaPolicy))
 	}
 
 	if len(o.NodeName) == 0 {
 		errs = append(errs, fmt.Errorf("node-name cannot be empty"))
 	}
 
+	if !slices.Contains(stateBackends, volume.StateBackendType(o.StateBackend)) {
+		errs = append(errs, fmt.Errorf("state-backend must be one of %v, got %q", stateBackends, o.StateBackend))
+	}
+
+	if o.StateReadDirBatchSize <= 0 {
+		errs = append(errs, fmt.Errorf("state-read-dir-batch-size must be positive, got %d", o.StateReadDirBatchSize))
+	}
+
+	if o.ProbeCacheTTL < 0 {
+		errs = append(errs, fmt.Errorf("probe-cache-ttl can't be negative, got %v", o.ProbeCacheTTL))
+	}
+
+	if o.CapacityCacheTTL < 0 {
+		errs = append(errs, fmt.Errorf("capacity-cache-ttl can't be negative, got %v", o.CapacityCacheTTL))
+	}
+
+	if o.FilesystemRetries < 0 {
+		errs = append(errs, fmt.Errorf("filesystem-retries can't be negative, got %d", o.FilesystemRetries))
+	}
+
+	if o.CreateVolumeRetries < 0 {
+		errs = append(errs, fmt.Errorf("create-volume-retries can't be negative, got %d", o.CreateVolumeRetries))
+	}
+
+	if o.MaxPendingReservations < 0 {
+		errs = append(errs, fmt.Errorf("max-pending-reservations can't be negative, got %d", o.MaxPendingReservations))
+	}
+
+	if o.FilesystemRetryDelay < 0 {
+		errs = append(errs, fmt.Errorf("filesystem-retry-delay can't be negative, got %v", o.FilesystemRetryDelay))
+	}
+
+	if o.UnmountRetries < 0 {
+		errs = append(errs, fmt.Errorf("unmount-retries can't be negative, got %d", o.UnmountRetries))
+	}
+
+	if o.UnmountRetryDelay < 0 {
+		errs = append(errs, fmt.Errorf("unmount-retry-delay can't be negative, got %v", o.UnmountRetryDelay))
+	}
+
+	if o.ShutdownTimeout < 0 {
+		errs = append(errs, fmt.Errorf("shutdown-timeout can't be negative, got %v", o.ShutdownTimeout))
+	}
+
+	if o.OrphanedMountSweepInterval < 0 {
+		errs = append(errs, fmt.Errorf("orphaned-mount-sweep-interval can't be negative, got %v", o.OrphanedMountSweepInterval))
+	}
+
+	if o.LeakDetectionInterval < 0 {
+		errs = append(errs, fmt.Errorf("leak-detection-interval can't be negative, got %v", o.LeakDetectionInterval))
+	}
+
+	if o.LeakGracePeriod < 0 {
+		errs = append(errs, fmt.Errorf("leak-grace-period can't be negative, got %v", o.LeakGracePeriod))
+	}
+
+	if o.CleanupLeakedVolumes && o.LeakDetectionInterval == 0 {
+		errs = append(errs, fmt.Errorf("leak-detection-interval has to be set when cleanup-leaked-volumes is enabled"))
+	}
+
+	if o.OrphanedMountSweepInterval > 0 && len(o.KubeletPodsDir) == 0 {
+		errs = append(errs, fmt.Errorf("kubelet-pods-dir cannot be empty when orphaned mount sweeper is enabled"))
+	}
+
+	if len(o.AdminTokenFile) != 0 && len(o.AdminAddress) == 0 {
+		errs = append(errs, fmt.Errorf("admin-address cannot be empty when admin-token-file is set"))
+	}
+
+	_, err := parseNonEmptyVolumeDirectoryCode(o.NonEmptyVolumeDirectoryCode)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("invalid non-empty-volume-directory-code: %w", err))
+	}
+
+	err = driver.ValidateTopologySegments(o.TopologyLabels)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("invalid topology-labels: %w", err))
+	}
+
+	if o.DisableTopology && len(o.TopologyLabels) != 0 {
+		errs = append(errs, fmt.Errorf("topology-labels can't be used together with disable-topology"))
+	}
+
+	if o.MaxSyncedVolumes <= 0 {
+		errs = append(errs, fmt.Errorf("max-synced-volumes must be positive, got %d", o.MaxSyncedVolumes))
+	}
+
+	if o.MaxVolumesPerNode <= 0 || o.MaxVolumesPerNode > limit.MaxLimits {
+		errs = append(errs, fmt.Errorf("max-volumes-per-node must be within [1, %d] range, got %d", limit.MaxLimits, o.MaxVolumesPerNode))
+	}
+
+	if o.CapacityDegradationPercent < 0 || o.CapacityDegradationPercent > 100 {
+		errs = append(errs, fmt.Errorf("capacity-degradation-threshold-percent must be within [0, 100] range, got %d", o.CapacityDegradationPercent))
+	}
+
+	if o.MaxTotalProvisionedBytes < 0 {
+		errs = append(errs, fmt.Errorf("max-total-provisioned-bytes can't be negative, got %d", o.MaxTotalProvisionedBytes))
+	}
+
+	if o.QuarantinedStateMaxBytes < 0 {
+		errs = append(errs, fmt.Errorf("quarantined-state-max-bytes can't be negative, got %d", o.QuarantinedStateMaxBytes))
+	}
+
+	if o.BytesPerInode < 0 {
+		errs = append(errs, fmt.Errorf("bytes-per-inode can't be negative, got %d", o.BytesPerInode))
+	}
+
+	_, _, err = parseReservedCapacity(o.ReservedCapacity)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("invalid reserved-capacity: %w", err))
+	}
+
+	for fsType, percent := range o.FilesystemCapacityReservationPercent {
+		if percent < 0 || percent >= 100 {
+			errs = append(errs, fmt.Errorf("filesystem-capacity-reservation-percent for %q must be within [0, 100) range, got %d", fsType, percent))
+		}
+	}
+
 	err = errors.NewAggregate(errs)
 	if err != nil {
 		return err
@@ -130,43 +462,151 @@ func (o *LocalDriverOptions) Run(streams genericclioptions.IOStreams, cmd *cobra
 	return o.run(ctx, streams)
 }
 
-func (o *LocalDriverOptions) run(ctx context.Context, _ genericclioptions.IOStreams) error {
-	sm, err := volume.NewStateManager(o.VolumesDir)
+func (o *LocalDriverOptions) run(ctx context.Context, _ genericclioptions.IOStreams) (err error) {
+	var eg errgroup.Group
+
+	// Servers started before the driver is created are stopped when the startup fails.
+	ctx, cancel := context.WithCancel(ctx)
+	defer func() {
+		cancel()
+		_ = eg.Wait()
+	}()
+
+	// Probes are served right away, so the driver is reported as not ready while volume state is loaded.
+	healthHandler := &swappableHandler{}
+	healthHandler.Set(driver.StartingHealthHandler())
+	if len(o.HealthAddress) != 0 {
+		healthServer := &http.Server{
+			Addr:    o.HealthAddress,
+			Handler: healthHandler,
+		}
+
+		eg.Go(func() error {
+			klog.InfoS("Serving health probes", "address", o.HealthAddress)
+			err := healthServer.ListenAndServe()
+			if err != nil && err != http.ErrServerClosed {
+				return fmt.Errorf("can't serve health probes: %w", err)
+			}
+
+			return nil
+		})
+
+		eg.Go(func() error {
+			<-ctx.Done()
+
+			return healthServer.Shutdown(context.Background())
+		})
+	}
+
+	// Volume states of all volumes dirs are kept in the first one.
+	mainVolumesDir := o.VolumesDir[0]
+	sm, err := volume.NewStateManager(
+		mainVolumesDir,
+		volume.WithStateBackend(volume.StateBackendType(o.StateBackend)),
+		volume.WithReadDirBatchSize(o.StateReadDirBatchSize),
+		volume.WithSkipCorruptState(o.SkipCorruptState),
+		volume.WithCompressQuarantinedState(o.CompressQuarantinedState),
+		volume.WithQuarantinedStateMaxBytes(o.QuarantinedStateMaxBytes),
+	)
 	if err != nil {
 		return fmt.Errorf("can't create state manager: %w", err)
 	}
+	defer func() {
+		closeErr := sm.Close()
+		if closeErr != nil {
+			klog.ErrorS(closeErr, "Failed to close the state manager")
+		}
+	}()
 
-	volumeFsType, err := fs.GetFilesystem(o.VolumesDir)
+	// Limits are restored for volumes whose states place them in the volumes dir, which is found by its ID.
+	err = volume.AnchorVolumesDirs(sm, o.VolumesDir)
 	if err != nil {
-		return fmt.Errorf("can't get filesystem of volume dir %q: %w", o.VolumesDir, err)
+		return fmt.Errorf("can't anchor volumes to volumes dirs: %w", err)
 	}
 
-	var limiter limit.Limiter = &limit.NoopLimiter{}
+	volumeFsType, limiter, capacityReservationPercent, unrestoredLimits, err := o.setupVolumesDir(mainVolumesDir, mainVolumesDir, sm.GetVolumes())
+	if err != nil {
+		return err
+	}
+	// Limiters are closed however the run ends, once it's past the startup only after the server stopped,
+	// as there are no more in-flight requests then.
+	defer func() {
+		err = errors.NewAggregate([]error{err, closeLimiter(mainVolumesDir, limiter)})
+	}()
 
-	switch volumeFsType {
-	case "xfs":
-		xl, err := xfs.NewXFSLimiter(o.VolumesDir, sm.GetVolumes())
+	var additionalVolumesDirs []volume.AdditionalVolumesDir
+	for _, dir := range o.VolumesDir[1:] {
+		fsType, dirLimiter, dirCapacityReservationPercent, dirUnrestoredLimits, err := o.setupVolumesDir(dir, mainVolumesDir, sm.GetVolumes())
 		if err != nil {
-			return fmt.Errorf("can't create XFS limiter: %w", err)
+			return err
 		}
-		limiter = xl
-	default:
-		return fmt.Errorf("unsupported volumes dir filesystem %q", volumeFsType)
+		defer func() {
+			err = errors.NewAggregate([]error{err, closeLimiter(dir, dirLimiter)})
+		}()
+		unrestoredLimits = append(unrestoredLimits, dirUnrestoredLimits...)
+
+		additionalVolumesDirs = append(additionalVolumesDirs, volume.AdditionalVolumesDir{
+			Path:                       dir,
+			FsType:                     fsType,
+			Limiter:                    dirLimiter,
+			CapacityReservationPercent: dirCapacityReservationPercent,
+		})
 	}
 
-	vm, err := volume.NewVolumeManager(o.VolumesDir, sm, volume.WithLimiter(limiter))
+	reservedCapacityBytes, reservedCapacityPercent, err := parseReservedCapacity(o.ReservedCapacity)
+	if err != nil {
+		return fmt.Errorf("can't parse reserved capacity: %w", err)
+	}
+
+	vm, err := volume.NewVolumeManager(
+		mainVolumesDir,
+		sm,
+		volume.WithLimiter(limiter),
+		volume.WithCapacityReservationPercent(capacityReservationPercent),
+		volume.WithReservedCapacity(reservedCapacityBytes),
+		volume.WithReservedCapacityPercent(reservedCapacityPercent),
+		volume.WithMaxTotalProvisionedBytes(o.MaxTotalProvisionedBytes),
+		volume.WithReconcileOnStartup(o.ReconcileOnStartup),
+		volume.WithBytesPerInode(o.BytesPerInode),
+		volume.WithCapacityCacheTTL(o.CapacityCacheTTL),
+		volume.WithCreateVolumeRetries(o.CreateVolumeRetries),
+		volume.WithMaxPendingCreations(o.MaxPendingReservations),
+		volume.WithNodeName(o.NodeName),
+		volume.WithVolumesDirFilesystem(volumeFsType),
+		volume.WithRejectMismatchingFsType(o.RejectMismatchingFsType),
+		volume.WithAdditionalVolumesDirs(additionalVolumesDirs...),
+		volume.WithCapacityPolicy(volume.CapacityPolicy(o.CapacityPolicy)),
+		volume.WithFilesystemRetryBackoff(wait.Backoff{
+			Steps:    o.FilesystemRetries + 1,
+			Duration: o.FilesystemRetryDelay,
+			Factor:   volume.DefaultFilesystemRetryBackoff.Factor,
+			Jitter:   volume.DefaultFilesystemRetryBackoff.Jitter,
+		}),
+		volume.WithUnmountRetryBackoff(wait.Backoff{
+			Steps:    o.UnmountRetries + 1,
+			Duration: o.UnmountRetryDelay,
+			Factor:   volume.DefaultUnmountRetryBackoff.Factor,
+			Jitter:   volume.DefaultUnmountRetryBackoff.Jitter,
+		}),
+		volume.WithLazyUnmount(o.LazyUnmount),
+		volume.WithUnrestoredLimits(unrestoredLimits...),
+	)
 	if err != nil {
 		return fmt.Errorf("can't create driver: %w", err)
 	}
 
+	err = checkVolumesNodeName(vm.GetVolumesOfOtherNodes(), o.NodeName, o.RejectNodeNameMismatch)
+	if err != nil {
+		return err
+	}
+
 	if err := os.Remove(o.Listen); err != nil && !os.IsNotExist(err) {
 		return fmt.Errorf("can't remove file at %q: %w", o.Listen, err)
 	}
 
-	lc := net.ListenConfig{}
-	listener, err := lc.Listen(ctx, "unix", o.Listen)
+	listener, err := listenUnixSocket(ctx, o.Listen, o.SocketMode)
 	if err != nil {
-		return fmt.Errorf("can't listen on %q using unix protocol: %w", o.Listen, err)
+		return err
 	}
 
 	defer func() {
@@ -176,19 +616,125 @@ func (o *LocalDriverOptions) run(ctx context.Context, _ genericclioptions.IOStre
 		}
 	}()
 
-	d := driver.NewDriver(o.DriverName, version.Get().String(), o.NodeName, vm)
+	nonEmptyVolumeDirectoryCode, err := parseNonEmptyVolumeDirectoryCode(o.NonEmptyVolumeDirectoryCode)
+	if err != nil {
+		return fmt.Errorf("can't parse non-empty volume directory code: %w", err)
+	}
+
+	var auditLogger *audit.Logger
+	if len(o.AuditLogPath) != 0 {
+		auditLogger, err = audit.NewFileLogger(o.AuditLogPath)
+		if err != nil {
+			return err
+		}
+
+		defer func() {
+			closeErr := auditLogger.Close()
+			if closeErr != nil {
+				klog.ErrorS(closeErr, "Failed to close the audit log", "path", o.AuditLogPath)
+			}
+		}()
+	}
+
+	var provisioningPolicy policy.Policy = policy.NoopPolicy{}
+	if len(o.ProvisioningPolicyFile) != 0 {
+		provisioningPolicy, err = policy.LoadRulesPolicy(o.ProvisioningPolicyFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	var adminToken string
+	if len(o.AdminTokenFile) != 0 {
+		adminToken, err = readAdminToken(o.AdminTokenFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	if o.DisableTopology {
+		klog.Warning("Volume topology is disabled, which is only correct on single node clusters")
+	}
+
+	d := driver.NewDriver(
+		o.DriverName,
+		version.Get().String(),
+		o.NodeName,
+		vm,
+		driver.WithProbeCacheTTL(o.ProbeCacheTTL),
+		driver.WithTopologyDisabled(o.DisableTopology),
+		driver.WithTopologySegments(o.TopologyLabels),
+		driver.WithNonEmptyVolumeDirectoryCode(nonEmptyVolumeDirectoryCode),
+		driver.WithMaxSyncedVolumes(o.MaxSyncedVolumes),
+		driver.WithMaxVolumesPerNode(o.MaxVolumesPerNode),
+		driver.WithCapacityDegradationThresholdPercent(o.CapacityDegradationPercent),
+		driver.WithUnrestoredQuotaPolicy(driver.UnrestoredQuotaPolicy(o.UnrestoredQuotaPolicy)),
+		driver.WithReadinessGate(),
+		driver.WithVolumeIOStats(o.VolumeIOStats),
+		driver.WithVolumeMountGroup(o.VolumeMountGroup),
+		driver.WithAuditLogger(auditLogger),
+		driver.WithProvisioningPolicy(provisioningPolicy),
+		driver.WithAdminToken(adminToken),
+	)
+
+	// In-flight requests are tracked by the outermost interceptor, so the shutdown waits for all the others.
+	// Recovery is the innermost interceptor, so panics are logged and counted as Internal errors.
+	inFlightRequests := driver.NewInFlightRequests()
+	interceptors := []grpc.UnaryServerInterceptor{
+		driver.LoggingUnaryInterceptor(),
+		d.MetricsUnaryInterceptor(),
+		d.ReadinessUnaryInterceptor(),
+		driver.RecoveryUnaryInterceptor(),
+	}
+
+	// Without an endpoint no tracer provider is set up, requests have no spans at all.
+	if len(o.OTLPEndpoint) != 0 {
+		tp, err := tracing.NewProvider(ctx, &tracingapi.TracingConfiguration{Endpoint: &o.OTLPEndpoint}, nil, []sdkresource.Option{
+			sdkresource.WithAttributes(
+				attribute.String("service.name", "local-csi-driver"),
+				attribute.String("host.name", o.NodeName),
+			),
+		})
+		if err != nil {
+			return fmt.Errorf("can't create tracer provider: %w", err)
+		}
+
+		// Provider is shut down after the server stopped, so spans of in-flight requests are exported.
+		// Exporting is bounded by the shutdown timeout, so an unreachable collector doesn't hold up the exit.
+		defer func() {
+			shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), o.ShutdownTimeout)
+			defer shutdownCancel()
+
+			shutdownErr := tp.Shutdown(shutdownCtx)
+			if shutdownErr != nil {
+				klog.ErrorS(shutdownErr, "Failed to shut down the tracer provider")
+			}
+		}()
+
+		interceptors = append([]grpc.UnaryServerInterceptor{driver.TracingUnaryInterceptor(tp)}, interceptors...)
+	}
+	interceptors = append([]grpc.UnaryServerInterceptor{inFlightRequests.UnaryInterceptor()}, interceptors...)
 
-	server := grpc.NewServer()
+	server := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(interceptors...),
+	)
 
 	csi.RegisterIdentityServer(server, d)
 	csi.RegisterControllerServer(server, d)
 	csi.RegisterNodeServer(server, d)
 
-	var eg errgroup.Group
+	d.RestorePublishedTargets(o.KubeletPodsDir)
+	d.ResumeVolumeSyncs(o.KubeletPodsDir)
+
+	healthHandler.Set(d.HealthHandler())
 
+	// grpcStopped is closed once in-flight requests finished, so their metrics are recorded.
+	grpcStopped := make(chan struct{})
+
+	servingListener := newServingListener(listener)
 	eg.Go(func() error {
 		klog.InfoS("Listening for connections", "address", listener.Addr())
-		err = server.Serve(listener)
+		err := server.Serve(servingListener)
 		if err != nil && err != grpc.ErrServerStopped {
 			return fmt.Errorf("can't serve: %w", err)
 		}
@@ -199,15 +745,370 @@ func (o *LocalDriverOptions) run(ctx context.Context, _ genericclioptions.IOStre
 	eg.Go(func() error {
 		<-ctx.Done()
 
-		server.GracefulStop()
+		finished := stopServer(server, inFlightRequests, o.ShutdownTimeout)
+		if !finished {
+			klog.Warningf("In-flight requests didn't finish within %v, the server was stopped forcibly", o.ShutdownTimeout)
+		}
+		close(grpcStopped)
+
+		return nil
+	})
+
+	if o.OrphanedMountSweepInterval > 0 {
+		eg.Go(func() error {
+			d.RunOrphanedMountSweeper(ctx, o.KubeletPodsDir, o.OrphanedMountSweepInterval)
+
+			return nil
+		})
+	}
+
+	if o.LeakDetectionInterval > 0 {
+		eg.Go(func() error {
+			d.RunLeakDetector(ctx, o.LeakDetectionInterval, o.LeakGracePeriod, o.CleanupLeakedVolumes)
+
+			return nil
+		})
+	}
+
+	if len(o.AdminAddress) != 0 {
+		adminServer := &http.Server{
+			Addr:    o.AdminAddress,
+			Handler: d.AdminHandler(),
+		}
+
+		eg.Go(func() error {
+			klog.InfoS("Serving admin endpoints", "address", o.AdminAddress)
+			err := adminServer.ListenAndServe()
+			if err != nil && err != http.ErrServerClosed {
+				return fmt.Errorf("can't serve admin endpoints: %w", err)
+			}
+
+			return nil
+		})
+
+		eg.Go(func() error {
+			<-ctx.Done()
+
+			return adminServer.Shutdown(context.Background())
+		})
+	}
+
+	if len(o.MetricsAddress) != 0 {
+		mux := http.NewServeMux()
+		mux.Handle("GET /metrics", d.MetricsHandler())
+
+		metricsServer := &http.Server{
+			Addr:    o.MetricsAddress,
+			Handler: mux,
+		}
+
+		eg.Go(func() error {
+			klog.InfoS("Serving metrics", "address", o.MetricsAddress)
+			err := metricsServer.ListenAndServe()
+			if err != nil && err != http.ErrServerClosed {
+				return fmt.Errorf("can't serve metrics: %w", err)
+			}
+
+			return nil
+		})
+
+		// Metrics are served until the gRPC server stops, so they can still be scraped during the shutdown.
+		eg.Go(func() error {
+			<-grpcStopped
+
+			return metricsServer.Shutdown(context.Background())
+		})
+	}
+
+	// Volume state is loaded and quotas are restored by the time the server accepts connections.
+	eg.Go(func() error {
+		select {
+		case <-servingListener.serving:
+			d.MarkReady()
+		case <-ctx.Done():
+		}
 
 		return nil
 	})
 
-	err = eg.Wait()
+	return eg.Wait()
+}
+
+// closeLimiter closes the limiter of the volumes dir.
+func closeLimiter(dir string, limiter limit.Limiter) error {
+	err := limiter.Close()
 	if err != nil {
-		return err
+		return fmt.Errorf("can't close limiter of volumes dir %q: %w", dir, err)
+	}
+
+	return nil
+}
+
+// setupVolumesDir creates the limiter of the volumes dir, restoring limits of volumes living in it, and returns it
+// together with the volumes dir filesystem and its capacity reservation.
+func (o *LocalDriverOptions) setupVolumesDir(dir, mainVolumesDir string, volumes []volume.VolumeState) (string, limit.Limiter, int, []string, error) {
+	fsType, err := fs.GetFilesystem(dir)
+	if err != nil {
+		return "", nil, 0, nil, fmt.Errorf("can't get filesystem of volume dir %q: %w", dir, err)
+	}
+
+	var dirVolumes []volume.VolumeState
+	for _, vs := range volumes {
+		if vs.IsInVolumesDir(dir, mainVolumesDir) {
+			dirVolumes = append(dirVolumes, vs)
+		}
+	}
+
+	createLimiter := newLimiter
+	if o.newLimiter != nil {
+		createLimiter = o.newLimiter
+	}
+
+	limiter, err := createLimiter(dir, fsType, dirVolumes)
+	unrestoredLimits, err := o.tolerateRestoreError(dir, err)
+	if err != nil {
+		// Limiter failing to restore some limits is usable, so it has to be closed when the startup fails.
+		if limiter != nil {
+			err = errors.NewAggregate([]error{err, closeLimiter(dir, limiter)})
+		}
+		return "", nil, 0, nil, err
+	}
+
+	capacityReservationPercent, ok := o.FilesystemCapacityReservationPercent[fsType]
+	if !ok {
+		capacityReservationPercent = volume.DefaultFilesystemCapacityReservationPercent[fsType]
+	}
+	klog.V(2).InfoS("Using filesystem capacity reservation", "volumesDir", dir, "filesystem", fsType, "percent", capacityReservationPercent)
+
+	return fsType, limiter, capacityReservationPercent, unrestoredLimits, nil
+}
+
+// newLimiter creates the limiter of the volumes dir filesystem, restoring limits of the volumes. Limiter is returned
+// together with a *limit.RestoreError when limits of some volumes couldn't be restored.
+func newLimiter(dir, fsType string, volumes []volume.VolumeState) (limit.Limiter, error) {
+	switch fsType {
+	case "xfs":
+		xl, err := xfs.NewXFSLimiter(dir, volumes)
+		if err != nil {
+			return limiterOrNil(xl, err), fmt.Errorf("can't create XFS limiter of volumes dir %q: %w", dir, err)
+		}
+		return xl, nil
+	case "ext4":
+		// The whole ext family shares a single magic number, the limiter verifies it's ext4 using the mount table.
+		el, err := ext4.NewExt4Limiter(dir, volumes)
+		if err != nil {
+			return limiterOrNil(el, err), fmt.Errorf("can't create ext4 limiter of volumes dir %q: %w", dir, err)
+		}
+		return el, nil
+	default:
+		return nil, fmt.Errorf("unsupported filesystem %q of volumes dir %q", fsType, dir)
+	}
+}
+
+// limiterOrNil returns the limiter only when it's usable despite the error, avoiding a non-nil interface holding
+// a nil limiter otherwise.
+func limiterOrNil(l limit.Limiter, err error) limit.Limiter {
+	var restoreErr *limit.RestoreError
+	if !stderrors.As(err, &restoreErr) {
+		return nil
+	}
+
+	return l
+}
+
+// tolerateRestoreError returns IDs of volumes whose limits couldn't be restored by a limiter, unless the policy
+// makes the startup fail. Any other limiter error is returned.
+func (o *LocalDriverOptions) tolerateRestoreError(dir string, err error) ([]string, error) {
+	if err == nil {
+		return nil, nil
+	}
+
+	var restoreErr *limit.RestoreError
+	if o.UnrestoredQuotaPolicy == failUnrestoredQuotaPolicy || !stderrors.As(err, &restoreErr) {
+		return nil, err
+	}
+
+	var volumeIDs []string
+	for volID, restoreErr := range restoreErr.Failures {
+		klog.ErrorS(restoreErr, "Can't restore volume limit, its usage isn't enforced", "volumeID", volID, "volumesDir", dir, "policy", o.UnrestoredQuotaPolicy)
+		volumeIDs = append(volumeIDs, volID)
+	}
+
+	return volumeIDs, nil
+}
+
+// parseReservedCapacity parses either a quantity of bytes or a percentage of the filesystem size.
+// Empty value reserves nothing.
+func parseReservedCapacity(value string) (int64, int, error) {
+	if len(value) == 0 {
+		return 0, 0, nil
+	}
+
+	if percentValue, ok := strings.CutSuffix(value, "%"); ok {
+		percent, err := strconv.Atoi(percentValue)
+		if err != nil {
+			return 0, 0, fmt.Errorf("can't parse percentage %q: %w", value, err)
+		}
+
+		if percent < 0 || percent > 100 {
+			return 0, 0, fmt.Errorf("percentage must be within [0, 100] range, got %d", percent)
+		}
+
+		return 0, percent, nil
+	}
+
+	quantity, err := resource.ParseQuantity(value)
+	if err != nil {
+		return 0, 0, fmt.Errorf("can't parse quantity %q: %w", value, err)
+	}
+
+	if quantity.Sign() < 0 {
+		return 0, 0, fmt.Errorf("quantity can't be negative, got %q", value)
+	}
+
+	return quantity.Value(), 0, nil
+}
+
+// parseNonEmptyVolumeDirectoryCode parses the name of a code CreateVolume can fail with when a new volume's
+// directory isn't empty.
+func parseNonEmptyVolumeDirectoryCode(value string) (codes.Code, error) {
+	for _, c := range nonEmptyVolumeDirectoryCodes {
+		if c.String() == value {
+			return c, nil
+		}
+	}
+
+	return 0, fmt.Errorf("must be one of %v, got %q", nonEmptyVolumeDirectoryCodes, value)
+}
+
+// parseSocketMode parses octal permissions of the driver socket.
+func parseSocketMode(value string) (os.FileMode, error) {
+	mode, err := strconv.ParseUint(value, 8, 32)
+	if err != nil {
+		return 0, fmt.Errorf("can't parse octal mode %q: %w", value, err)
+	}
+
+	if mode > 0777 {
+		return 0, fmt.Errorf("mode can only set permission bits, got %q", value)
+	}
+
+	return os.FileMode(mode), nil
+}
+
+// checkVolumesNodeName warns about volumes created on a node of a different name, which most likely means the node
+// was renamed. It fails when reject is set, so the driver doesn't serve volumes whose topology silently shifted.
+func checkVolumesNodeName(volumes []volume.VolumeState, nodeName string, reject bool) error {
+	if len(volumes) == 0 {
+		return nil
+	}
+
+	for _, vs := range volumes {
+		klog.Warningf("Volume %q was created on node %q, but the node is named %q now", vs.ID, vs.NodeName, nodeName)
+	}
+
+	if reject {
+		return fmt.Errorf("%d volume(s) were created on a node of a different name than %q", len(volumes), nodeName)
 	}
 
 	return nil
 }
+
+// stopServer stops the server from accepting new requests and waits at most timeout for the in-flight ones to finish,
+// before stopping it forcibly by closing all connections. It returns whether the in-flight requests finished in time.
+func stopServer(server *grpc.Server, requests *driver.InFlightRequests, timeout time.Duration) bool {
+	gracefullyStopped := make(chan struct{})
+	go func() {
+		server.GracefulStop()
+		close(gracefullyStopped)
+	}()
+
+	finished := requests.Wait(timeout)
+	if finished {
+		<-gracefullyStopped
+		return true
+	}
+
+	// Graceful stop keeps waiting for the handlers, which may outlive the connections closed by Stop.
+	server.Stop()
+
+	return false
+}
+
+// listenUnixSocket listens on the unix socket at path. When socketMode is set, permissions of the socket are
+// changed right after it's created, before any connection is accepted.
+func listenUnixSocket(ctx context.Context, path, socketMode string) (net.Listener, error) {
+	lc := net.ListenConfig{}
+	listener, err := lc.Listen(ctx, "unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("can't listen on %q using unix protocol: %w", path, err)
+	}
+
+	if len(socketMode) == 0 {
+		return listener, nil
+	}
+
+	mode, err := parseSocketMode(socketMode)
+	if err == nil {
+		err = os.Chmod(path, mode)
+	}
+	if err != nil {
+		closeErr := listener.Close()
+		if closeErr != nil {
+			klog.ErrorS(closeErr, "Failed to close the listen socket", "socket", path)
+		}
+		return nil, fmt.Errorf("can't set mode of socket %q: %w", path, err)
+	}
+
+	return listener, nil
+}
+
+// swappableHandler serves requests using the handler set last, so the driver can be probed before it's created.
+type swappableHandler struct {
+	handler atomic.Pointer[http.Handler]
+}
+
+func (h *swappableHandler) Set(handler http.Handler) {
+	h.handler.Store(&handler)
+}
+
+func (h *swappableHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	(*h.handler.Load()).ServeHTTP(w, r)
+}
+
+// servingListener closes serving once the server started accepting connections.
+type servingListener struct {
+	net.Listener
+
+	once    sync.Once
+	serving chan struct{}
+}
+
+func newServingListener(listener net.Listener) *servingListener {
+	return &servingListener{
+		Listener: listener,
+		serving:  make(chan struct{}),
+	}
+}
+
+func (l *servingListener) Accept() (net.Conn, error) {
+	l.once.Do(func() {
+		close(l.serving)
+	})
+
+	return l.Listener.Accept()
+}
+
+// readAdminToken reads the admin token from the file, ignoring surrounding whitespace like a trailing newline.
+func readAdminToken(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("can't read admin token file %q: %w", path, err)
+	}
+
+	token := strings.TrimSpace(string(data))
+	if len(token) == 0 {
+		return "", fmt.Errorf("admin token file %q is empty", path)
+	}
+
+	return token, nil
+}
@@ -0,0 +1,876 @@
+// Copyright (c) 2023 ScyllaDB.
+
+package driver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/scylladb/local-csi-driver/pkg/driver"
+	"github.com/scylladb/local-csi-driver/pkg/driver/limit"
+	"github.com/scylladb/local-csi-driver/pkg/driver/volume"
+	"github.com/scylladb/local-csi-driver/pkg/genericclioptions"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+func TestParseReservedCapacity(t *testing.T) {
+	t.Parallel()
+
+	tt := []struct {
+		name            string
+		value           string
+		expectedBytes   int64
+		expectedPercent int
+		expectedErr     bool
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name:          "binary quantity",
+			value:         "5Gi",
+			expectedBytes: 5 * 1024 * 1024 * 1024,
+		},
+		{
+			name:          "plain bytes",
+			value:         "1000",
+			expectedBytes: 1000,
+		},
+		{
+			name:            "percentage",
+			value:           "10%",
+			expectedPercent: 10,
+		},
+		{
+			name:        "percentage above 100",
+			value:       "101%",
+			expectedErr: true,
+		},
+		{
+			name:        "fractional percentage",
+			value:       "2.5%",
+			expectedErr: true,
+		},
+		{
+			name:        "negative quantity",
+			value:       "-1Gi",
+			expectedErr: true,
+		},
+		{
+			name:        "garbage",
+			value:       "lots",
+			expectedErr: true,
+		},
+	}
+
+	for i := range tt {
+		tc := tt[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			bytes, percent, err := parseReservedCapacity(tc.value)
+			if tc.expectedErr != (err != nil) {
+				t.Fatalf("expected error %v, got %v", tc.expectedErr, err)
+			}
+
+			if bytes != tc.expectedBytes {
+				t.Errorf("expected %d bytes, got %d", tc.expectedBytes, bytes)
+			}
+
+			if percent != tc.expectedPercent {
+				t.Errorf("expected %d percent, got %d", tc.expectedPercent, percent)
+			}
+		})
+	}
+}
+
+func TestParseNonEmptyVolumeDirectoryCode(t *testing.T) {
+	t.Parallel()
+
+	tt := []struct {
+		name         string
+		value        string
+		expectedCode codes.Code
+		expectedErr  bool
+	}{
+		{
+			name:         "internal",
+			value:        "Internal",
+			expectedCode: codes.Internal,
+		},
+		{
+			name:         "already exists",
+			value:        "AlreadyExists",
+			expectedCode: codes.AlreadyExists,
+		},
+		{
+			name:        "code not meant for non-empty directories",
+			value:       "NotFound",
+			expectedErr: true,
+		},
+		{
+			name:        "empty",
+			value:       "",
+			expectedErr: true,
+		},
+	}
+
+	for i := range tt {
+		tc := tt[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			code, err := parseNonEmptyVolumeDirectoryCode(tc.value)
+			if tc.expectedErr != (err != nil) {
+				t.Fatalf("expected error %v, got %v", tc.expectedErr, err)
+			}
+
+			if code != tc.expectedCode {
+				t.Errorf("expected code %v, got %v", tc.expectedCode, code)
+			}
+		})
+	}
+}
+
+func TestTolerateRestoreError(t *testing.T) {
+	t.Parallel()
+
+	restoreErr := &limit.RestoreError{
+		Failures: map[string]error{
+			"volume": fmt.Errorf("can't open volume directory"),
+		},
+	}
+
+	tt := []struct {
+		name              string
+		policy            string
+		err               error
+		expectedVolumeIDs []string
+		expectedErr       bool
+	}{
+		{
+			name:   "no error",
+			policy: failUnrestoredQuotaPolicy,
+		},
+		{
+			name:        "restore error with fail policy",
+			policy:      failUnrestoredQuotaPolicy,
+			err:         restoreErr,
+			expectedErr: true,
+		},
+		{
+			name:              "restore error with strict policy",
+			policy:            string(driver.StrictUnrestoredQuotaPolicy),
+			err:               restoreErr,
+			expectedVolumeIDs: []string{"volume"},
+		},
+		{
+			name:              "wrapped restore error with permissive policy",
+			policy:            string(driver.PermissiveUnrestoredQuotaPolicy),
+			err:               fmt.Errorf("can't create limiter: %w", restoreErr),
+			expectedVolumeIDs: []string{"volume"},
+		},
+		{
+			name:        "other error with permissive policy",
+			policy:      string(driver.PermissiveUnrestoredQuotaPolicy),
+			err:         fmt.Errorf("project quota isn't enforced"),
+			expectedErr: true,
+		},
+	}
+
+	for i := range tt {
+		tc := tt[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			o := NewLocalDriverOptions(genericclioptions.IOStreams{})
+			o.UnrestoredQuotaPolicy = tc.policy
+
+			volumeIDs, err := o.tolerateRestoreError("/mnt/volumes", tc.err)
+			if tc.expectedErr != (err != nil) {
+				t.Fatalf("expected error %v, got %v", tc.expectedErr, err)
+			}
+
+			if !reflect.DeepEqual(volumeIDs, tc.expectedVolumeIDs) {
+				t.Errorf("expected volume IDs %v, got %v", tc.expectedVolumeIDs, volumeIDs)
+			}
+		})
+	}
+}
+
+func TestParseSocketMode(t *testing.T) {
+	t.Parallel()
+
+	tt := []struct {
+		name         string
+		value        string
+		expectedMode os.FileMode
+		expectedErr  bool
+	}{
+		{
+			name:         "owner and group",
+			value:        "0660",
+			expectedMode: 0660,
+		},
+		{
+			name:         "without leading zero",
+			value:        "600",
+			expectedMode: 0600,
+		},
+		{
+			name:        "not octal",
+			value:       "0688",
+			expectedErr: true,
+		},
+		{
+			name:        "setuid bit",
+			value:       "4755",
+			expectedErr: true,
+		},
+	}
+
+	for i := range tt {
+		tc := tt[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			mode, err := parseSocketMode(tc.value)
+			if tc.expectedErr != (err != nil) {
+				t.Fatalf("expected error %v, got %v", tc.expectedErr, err)
+			}
+
+			if mode != tc.expectedMode {
+				t.Errorf("expected mode %v, got %v", tc.expectedMode, mode)
+			}
+		})
+	}
+}
+
+func TestListenUnixSocketSetsSocketMode(t *testing.T) {
+	t.Parallel()
+
+	for _, mode := range []os.FileMode{0600, 0660} {
+		path := filepath.Join(t.TempDir(), "csi.sock")
+		listener, err := listenUnixSocket(context.Background(), path, fmt.Sprintf("%o", mode))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if fi.Mode()&os.ModeSocket == 0 {
+			t.Errorf("expected %q to be a socket, got %v", path, fi.Mode())
+		}
+
+		if fi.Mode().Perm() != mode {
+			t.Errorf("expected socket mode %v, got %v", mode, fi.Mode().Perm())
+		}
+
+		err = listener.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestReadAdminToken(t *testing.T) {
+	t.Parallel()
+
+	tt := []struct {
+		name          string
+		content       string
+		expectedToken string
+		expectedErr   bool
+	}{
+		{
+			name:          "token with trailing newline",
+			content:       "secret\n",
+			expectedToken: "secret",
+		},
+		{
+			name:        "empty file",
+			content:     " \n",
+			expectedErr: true,
+		},
+	}
+
+	for i := range tt {
+		tc := tt[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			path := filepath.Join(t.TempDir(), "token")
+			err := os.WriteFile(path, []byte(tc.content), 0600)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			token, err := readAdminToken(path)
+			if tc.expectedErr != (err != nil) {
+				t.Fatalf("expected error %v, got %v", tc.expectedErr, err)
+			}
+
+			if token != tc.expectedToken {
+				t.Errorf("expected token %q, got %q", tc.expectedToken, token)
+			}
+		})
+	}
+}
+
+func TestCheckVolumesNodeName(t *testing.T) {
+	t.Parallel()
+
+	volumes := []volume.VolumeState{
+		{
+			ID:       "volume-id",
+			NodeName: "old-node-name",
+		},
+	}
+
+	tt := []struct {
+		name        string
+		volumes     []volume.VolumeState
+		reject      bool
+		expectedErr bool
+	}{
+		{
+			name:   "no volumes of other nodes",
+			reject: true,
+		},
+		{
+			name:    "volumes of other nodes are only warned about",
+			volumes: volumes,
+		},
+		{
+			name:        "volumes of other nodes are rejected",
+			volumes:     volumes,
+			reject:      true,
+			expectedErr: true,
+		},
+	}
+
+	for i := range tt {
+		tc := tt[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := checkVolumesNodeName(tc.volumes, "node-name", tc.reject)
+			if tc.expectedErr != (err != nil) {
+				t.Errorf("expected error %v, got %v", tc.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestValidateRejectsVolumesDirsOnTheSameFilesystem(t *testing.T) {
+	t.Parallel()
+
+	o := newTestLocalDriverOptions(t, &limit.NoopLimiter{})
+	err := o.Validate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	otherVolumesDir := filepath.Join(filepath.Dir(o.VolumesDir[0]), "other-volumes")
+	err = os.Mkdir(otherVolumesDir, 0770)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.VolumesDir = append(o.VolumesDir, otherVolumesDir)
+
+	err = o.Validate()
+	if err == nil || !strings.Contains(err.Error(), "same filesystem") {
+		t.Errorf("expected error about volumes dirs on the same filesystem, got %v", err)
+	}
+}
+
+// slowIdentityServer answers probes only once they're released.
+type slowIdentityServer struct {
+	csi.UnimplementedIdentityServer
+
+	started  chan struct{}
+	released chan struct{}
+}
+
+func (s *slowIdentityServer) Probe(context.Context, *csi.ProbeRequest) (*csi.ProbeResponse, error) {
+	close(s.started)
+	<-s.released
+
+	return &csi.ProbeResponse{}, nil
+}
+
+func TestStopServer(t *testing.T) {
+	t.Parallel()
+
+	tt := []struct {
+		name             string
+		releaseAfter     time.Duration
+		timeout          time.Duration
+		expectedFinished bool
+		expectedCode     codes.Code
+	}{
+		{
+			name:             "in-flight request finishing within the timeout",
+			releaseAfter:     100 * time.Millisecond,
+			timeout:          time.Minute,
+			expectedFinished: true,
+			expectedCode:     codes.OK,
+		},
+		{
+			name:             "in-flight request exceeding the timeout",
+			releaseAfter:     time.Minute,
+			timeout:          100 * time.Millisecond,
+			expectedFinished: false,
+			expectedCode:     codes.Unavailable,
+		},
+	}
+
+	for i := range tt {
+		tc := tt[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			path := filepath.Join(t.TempDir(), "csi.sock")
+			listener, err := listenUnixSocket(context.Background(), path, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			requests := driver.NewInFlightRequests()
+			server := grpc.NewServer(grpc.ChainUnaryInterceptor(requests.UnaryInterceptor()))
+			identityServer := &slowIdentityServer{
+				started:  make(chan struct{}),
+				released: make(chan struct{}),
+			}
+			csi.RegisterIdentityServer(server, identityServer)
+
+			served := make(chan error, 1)
+			go func() {
+				served <- server.Serve(listener)
+			}()
+
+			conn, err := grpc.NewClient("unix://"+path, grpc.WithTransportCredentials(insecure.NewCredentials()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			probed := make(chan error, 1)
+			go func() {
+				_, err := csi.NewIdentityClient(conn).Probe(context.Background(), &csi.ProbeRequest{})
+				probed <- err
+			}()
+			<-identityServer.started
+
+			releaseTimer := time.AfterFunc(tc.releaseAfter, func() {
+				close(identityServer.released)
+			})
+			defer func() {
+				if releaseTimer.Stop() {
+					close(identityServer.released)
+				}
+			}()
+
+			finished := stopServer(server, requests, tc.timeout)
+			if finished != tc.expectedFinished {
+				t.Errorf("expected in-flight requests finished %v, got %v", tc.expectedFinished, finished)
+			}
+
+			err = <-probed
+			if status.Code(err) != tc.expectedCode {
+				t.Errorf("expected in-flight request to end with %v code, got %v", tc.expectedCode, err)
+			}
+
+			err = <-served
+			if err != nil {
+				t.Errorf("expected server to stop serving without an error, got %v", err)
+			}
+		})
+	}
+}
+
+// closeCountingLimiter counts how many times it was closed.
+type closeCountingLimiter struct {
+	limit.NoopLimiter
+
+	closeCalls int
+}
+
+func (l *closeCountingLimiter) Close() error {
+	l.closeCalls++
+	return nil
+}
+
+// newTestLocalDriverOptions returns options of a driver serving at a temporary socket, using the limiter in all
+// volumes dirs.
+func newTestLocalDriverOptions(t *testing.T, limiter limit.Limiter) *LocalDriverOptions {
+	t.Helper()
+
+	dir := t.TempDir()
+	volumesDir := filepath.Join(dir, "volumes")
+	err := os.Mkdir(volumesDir, 0770)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	o := NewLocalDriverOptions(genericclioptions.IOStreams{})
+	o.VolumesDir = []string{volumesDir}
+	o.Listen = filepath.Join(dir, "csi.sock")
+	o.NodeName = "node"
+	o.newLimiter = func(string, string, []volume.VolumeState) (limit.Limiter, error) {
+		return limiter, nil
+	}
+
+	return o
+}
+
+// startTestDriver runs the driver until the returned function is called, which shuts it down and returns the error
+// the driver finished with. Unless it's called, the driver finishing on its own is reported to the returned channel.
+func startTestDriver(o *LocalDriverOptions) (func() error, <-chan error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	finished := make(chan error, 1)
+	go func() {
+		finished <- o.run(ctx, genericclioptions.IOStreams{})
+	}()
+
+	stop := func() error {
+		cancel()
+		return <-finished
+	}
+
+	return stop, finished
+}
+
+// runTestDriver starts the driver like startTestDriver and returns once the driver answers probes as ready.
+func runTestDriver(t *testing.T, o *LocalDriverOptions) (*grpc.ClientConn, func() error) {
+	t.Helper()
+
+	stop, finished := startTestDriver(o)
+	ctx := context.Background()
+
+	conn, err := grpc.NewClient("unix://"+o.Listen, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		_ = stop()
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+
+	timeout := time.After(wait.ForeverTestTimeout)
+	for {
+		resp, err := csi.NewIdentityClient(conn).Probe(ctx, &csi.ProbeRequest{})
+		if err == nil && resp.GetReady().GetValue() {
+			return conn, stop
+		}
+
+		select {
+		case err := <-finished:
+			t.Fatalf("driver finished before getting ready: %v", err)
+		case <-timeout:
+			_ = stop()
+			t.Fatalf("driver didn't get ready within %v, last probe error: %v", wait.ForeverTestTimeout, err)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func TestRunClosesLimiterOnShutdown(t *testing.T) {
+	t.Parallel()
+
+	limiter := &closeCountingLimiter{}
+	o := newTestLocalDriverOptions(t, limiter)
+
+	_, stop := runTestDriver(t, o)
+	if limiter.closeCalls != 0 {
+		t.Fatalf("expected limiter not to be closed while the driver runs, got %d calls", limiter.closeCalls)
+	}
+
+	err := stop()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if limiter.closeCalls != 1 {
+		t.Errorf("expected limiter to be closed once on shutdown, got %d calls", limiter.closeCalls)
+	}
+}
+
+func TestRunClosesLimitersWhenStartupFails(t *testing.T) {
+	t.Parallel()
+
+	limiter := &closeCountingLimiter{}
+	o := newTestLocalDriverOptions(t, limiter)
+
+	additionalVolumesDir := filepath.Join(t.TempDir(), "volumes")
+	err := os.Mkdir(additionalVolumesDir, 0770)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.VolumesDir = append(o.VolumesDir, additionalVolumesDir)
+
+	limiterErr := errors.New("can't create limiter")
+	o.newLimiter = func(dir string, _ string, _ []volume.VolumeState) (limit.Limiter, error) {
+		if dir == additionalVolumesDir {
+			return nil, limiterErr
+		}
+		return limiter, nil
+	}
+
+	stop, _ := startTestDriver(o)
+	err = stop()
+	if !errors.Is(err, limiterErr) {
+		t.Fatalf("expected startup to fail with %v, got %v", limiterErr, err)
+	}
+
+	if limiter.closeCalls != 1 {
+		t.Errorf("expected limiter of the main volumes dir to be closed once, got %d calls", limiter.closeCalls)
+	}
+}
+
+// recordingTraceCollector records names of spans exported to it.
+type recordingTraceCollector struct {
+	coltracepb.UnimplementedTraceServiceServer
+
+	mut   sync.Mutex
+	spans []string
+}
+
+func (c *recordingTraceCollector) Export(_ context.Context, req *coltracepb.ExportTraceServiceRequest) (*coltracepb.ExportTraceServiceResponse, error) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	for _, rs := range req.GetResourceSpans() {
+		for _, ss := range rs.GetScopeSpans() {
+			for _, s := range ss.GetSpans() {
+				c.spans = append(c.spans, s.GetName())
+			}
+		}
+	}
+
+	return &coltracepb.ExportTraceServiceResponse{}, nil
+}
+
+func (c *recordingTraceCollector) getSpans() []string {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	return append([]string(nil), c.spans...)
+}
+
+func TestRunFlushesSpansOnShutdown(t *testing.T) {
+	t.Parallel()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	collector := &recordingTraceCollector{}
+	server := grpc.NewServer()
+	coltracepb.RegisterTraceServiceServer(server, collector)
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	defer server.Stop()
+
+	o := newTestLocalDriverOptions(t, &limit.NoopLimiter{})
+	o.OTLPEndpoint = listener.Addr().String()
+
+	conn, stop := runTestDriver(t, o)
+
+	// Only requests sampled by the caller are traced.
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "traceparent", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	_, err = csi.NewIdentityClient(conn).GetPluginInfo(ctx, &csi.GetPluginInfoRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Spans are exported in batches every few seconds, so only the flush exports it right away.
+	err = stop()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedSpans := []string{"csi.v1.Identity/GetPluginInfo"}
+	if got := collector.getSpans(); !reflect.DeepEqual(got, expectedSpans) {
+		t.Errorf("expected spans %v to be exported on shutdown, got %v", expectedSpans, got)
+	}
+}
+
+// blockingLimiterFactory creates limiters only once it's released, signalling it was called first.
+type blockingLimiterFactory struct {
+	called   chan struct{}
+	released chan struct{}
+}
+
+func (f *blockingLimiterFactory) newLimiter(string, string, []volume.VolumeState) (limit.Limiter, error) {
+	close(f.called)
+	<-f.released
+
+	return &limit.NoopLimiter{}, nil
+}
+
+func getProbeStatus(url string) (int, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	return resp.StatusCode, nil
+}
+
+func TestRunReportsNotReadyUntilQuotasAreRestored(t *testing.T) {
+	t.Parallel()
+
+	// The port is only reserved for a moment, so the health server can listen on it.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	healthAddress := listener.Addr().String()
+	err = listener.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	factory := &blockingLimiterFactory{
+		called:   make(chan struct{}),
+		released: make(chan struct{}),
+	}
+	o := newTestLocalDriverOptions(t, nil)
+	o.HealthAddress = healthAddress
+	o.newLimiter = factory.newLimiter
+
+	stop, finished := startTestDriver(o)
+	<-factory.called
+
+	pollProbe := func(path string, expectedStatus int) {
+		t.Helper()
+
+		var lastStatus int
+		var lastErr error
+		err := wait.PollUntilContextTimeout(context.Background(), 10*time.Millisecond, wait.ForeverTestTimeout, true, func(context.Context) (bool, error) {
+			select {
+			case err := <-finished:
+				return false, fmt.Errorf("driver finished: %w", err)
+			default:
+			}
+
+			lastStatus, lastErr = getProbeStatus("http://" + healthAddress + path)
+			return lastErr == nil && lastStatus == expectedStatus, nil
+		})
+		if err != nil {
+			t.Fatalf("expected %s to respond with %d, got %d and error %v: %v", path, expectedStatus, lastStatus, lastErr, err)
+		}
+	}
+
+	pollProbe("/healthz", http.StatusOK)
+	pollProbe("/readyz", http.StatusServiceUnavailable)
+
+	close(factory.released)
+	pollProbe("/readyz", http.StatusOK)
+
+	err = stop()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// recordingLimiterFactory records volumes limiters of every volumes dir are created with.
+type recordingLimiterFactory struct {
+	mut     sync.Mutex
+	volumes map[string][]volume.VolumeState
+}
+
+func (f *recordingLimiterFactory) newLimiter(dir, _ string, volumes []volume.VolumeState) (limit.Limiter, error) {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+
+	f.volumes[dir] = volumes
+
+	return &limit.NoopLimiter{}, nil
+}
+
+func TestRunRestoresLimitsOfVolumesDirMountedElsewhere(t *testing.T) {
+	t.Parallel()
+
+	factory := &recordingLimiterFactory{
+		volumes: map[string][]volume.VolumeState{},
+	}
+	o := newTestLocalDriverOptions(t, nil)
+	o.newLimiter = factory.newLimiter
+
+	// Disk used to be mounted at oldVolumesDir.
+	const volumesDirID = "5a5e9a3c-1447-4c1f-9d4a-2b0c1c23a6a1"
+	oldVolumesDir := filepath.Join(t.TempDir(), "disk")
+	volumesDir := filepath.Join(t.TempDir(), "disk")
+	err := os.Mkdir(volumesDir, 0770)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(volumesDir, volume.VolumesDirIDFileName), []byte(volumesDirID+"\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.VolumesDir = append(o.VolumesDir, volumesDir)
+
+	sm, err := volume.NewStateManager(o.VolumesDir[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = sm.SaveVolumeState(&volume.VolumeState{
+		ID:           "volume-id",
+		Name:         "volume",
+		LimitID:      1,
+		Size:         4096,
+		VolumesDir:   oldVolumesDir,
+		VolumesDirID: volumesDirID,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = sm.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, stop := runTestDriver(t, o)
+	err = stop()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	factory.mut.Lock()
+	defer factory.mut.Unlock()
+
+	volumes := factory.volumes[volumesDir]
+	if len(volumes) != 1 || volumes[0].ID != "volume-id" || volumes[0].VolumesDir != volumesDir {
+		t.Errorf("expected limiter of %q to restore limit of the volume at its new path, got %#v", volumesDir, volumes)
+	}
+
+	if volumes := factory.volumes[o.VolumesDir[0]]; len(volumes) != 0 {
+		t.Errorf("expected limiter of the main volumes dir to restore no limits, got %#v", volumes)
+	}
+}
@@ -0,0 +1,496 @@
+// Copyright (c) 2023 ScyllaDB.
+
+package driver
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	stderrors "errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/scylladb/local-csi-driver/pkg/driver"
+	"github.com/scylladb/local-csi-driver/pkg/driver/volume"
+	"github.com/scylladb/local-csi-driver/pkg/genericclioptions"
+	"github.com/scylladb/local-csi-driver/pkg/util/fs"
+	"github.com/scylladb/local-csi-driver/pkg/version"
+	"github.com/spf13/cobra"
+	"golang.org/x/sys/unix"
+	"k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/klog/v2"
+	"k8s.io/mount-utils"
+)
+
+type SupportBundleOptions struct {
+	VolumesDir   []string
+	AdminAddress string
+	Out          string
+}
+
+func NewSupportBundleOptions(_ genericclioptions.IOStreams) *SupportBundleOptions {
+	return &SupportBundleOptions{
+		Out: "support-bundle.tar.gz",
+	}
+}
+
+func NewSupportBundleCommand(streams genericclioptions.IOStreams) *cobra.Command {
+	o := NewSupportBundleOptions(streams)
+
+	cmd := &cobra.Command{
+		Use:   "support-bundle",
+		Short: "Collect the driver's view of the node into a tarball",
+		Long:  `Collect volume state files, capacity computation, limiter status, mounts and filesystem information into a tarball. Volume data isn't collected.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			err := o.Validate()
+			if err != nil {
+				return err
+			}
+
+			err = o.Complete()
+			if err != nil {
+				return err
+			}
+
+			err = o.Run(streams)
+			if err != nil {
+				return err
+			}
+
+			return nil
+		},
+
+		SilenceErrors: true,
+		SilenceUsage:  true,
+	}
+
+	cmd.Flags().StringSliceVarP(&o.VolumesDir, "volumes-dir", "", o.VolumesDir, "Path to directory where driver provisions the volumes. It can be repeated or comma separated, the same as for the driver. Volume states are read from the first one.")
+	cmd.Flags().StringVarP(&o.AdminAddress, "admin-address", "", o.AdminAddress, "Address of the admin endpoints of the driver running on the node. Volume states and capacity are read from them when the driver holds the state, which is the case for the bolt state backend. Disabled when empty.")
+	cmd.Flags().StringVarP(&o.Out, "out", "", o.Out, "Path of the created tarball.")
+
+	return cmd
+}
+
+func (o *SupportBundleOptions) Validate() error {
+	var errs []error
+
+	if len(o.VolumesDir) == 0 {
+		errs = append(errs, fmt.Errorf("volumes-dir cannot be empty"))
+	}
+
+	for _, dir := range o.VolumesDir {
+		_, err := os.Stat(dir)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("can't stat volumes-dir %q: %w", dir, err))
+		}
+	}
+
+	if len(o.Out) == 0 {
+		errs = append(errs, fmt.Errorf("out cannot be empty"))
+	}
+
+	return errors.NewAggregate(errs)
+}
+
+func (o *SupportBundleOptions) Complete() error {
+	return nil
+}
+
+func (o *SupportBundleOptions) Run(streams genericclioptions.IOStreams) (err error) {
+	f, err := os.Create(o.Out)
+	if err != nil {
+		return fmt.Errorf("can't create support bundle file %q: %w", o.Out, err)
+	}
+	defer func() {
+		closeErr := f.Close()
+		if closeErr != nil {
+			err = errors.NewAggregate([]error{err, closeErr})
+		}
+	}()
+
+	err = writeSupportBundle(f, o.VolumesDir, o.AdminAddress)
+	if err != nil {
+		return fmt.Errorf("can't write support bundle: %w", err)
+	}
+
+	_, err = fmt.Fprintf(streams.Out, "Support bundle written to %q\n", o.Out)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// adminRequestTimeout bounds every request to the admin endpoints of the running driver.
+const adminRequestTimeout = 10 * time.Second
+
+type supportBundleWriter struct {
+	tw  *tar.Writer
+	now time.Time
+}
+
+func (w *supportBundleWriter) writeFile(name string, content []byte) error {
+	err := w.tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    int64(len(content)),
+		ModTime: w.now,
+	})
+	if err != nil {
+		return fmt.Errorf("can't write header of %q: %w", name, err)
+	}
+
+	_, err = w.tw.Write(content)
+	if err != nil {
+		return fmt.Errorf("can't write content of %q: %w", name, err)
+	}
+
+	return nil
+}
+
+func (w *supportBundleWriter) writeJSON(name string, v any) error {
+	content, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("can't encode %q: %w", name, err)
+	}
+
+	return w.writeFile(name, content)
+}
+
+// writeAdminState writes volume states and capacity served by the admin endpoints and returns them. Failures to get
+// them are recorded in the bundle.
+func (w *supportBundleWriter) writeAdminState(adminAddress string) ([]volume.VolumeState, *capacityInfo, error) {
+	client := &http.Client{Timeout: adminRequestTimeout}
+	ci := &capacityInfo{}
+
+	var volumes []volume.VolumeState
+	err := getAdminJSON(client, adminAddress, "/volumes", &volumes)
+	if err != nil {
+		ci.Error = err.Error()
+		return nil, ci, nil
+	}
+
+	err = w.writeJSON(filepath.Join("admin", "volumes.json"), volumes)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cs := &driver.CapacityStatus{}
+	err = getAdminJSON(client, adminAddress, "/capacity", cs)
+	if err != nil {
+		ci.Error = err.Error()
+		return volumes, ci, nil
+	}
+
+	ci.AvailableCapacity = cs.AvailableBytes
+	ci.VolumesCount = cs.VolumesCount
+	ci.TotalVolumesSize = cs.ProvisionedBytes
+	ci.MetadataSize = int64(cs.VolumesCount+1) * volume.MetadataFileMaxSize
+
+	return volumes, ci, nil
+}
+
+func getAdminJSON(client *http.Client, adminAddress, path string, v any) (err error) {
+	url := fmt.Sprintf("http://%s%s", adminAddress, path)
+	resp, err := client.Get(url)
+	if err != nil {
+		return fmt.Errorf("can't get %q: %w", url, err)
+	}
+	defer func() {
+		closeErr := resp.Body.Close()
+		if closeErr != nil {
+			err = errors.NewAggregate([]error{err, closeErr})
+		}
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("can't get %q: unexpected status %q", url, resp.Status)
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(v)
+	if err != nil {
+		return fmt.Errorf("can't decode %q: %w", url, err)
+	}
+
+	return nil
+}
+
+type filesystemInfo struct {
+	Path        string `json:"path"`
+	Type        string `json:"type,omitempty"`
+	Error       string `json:"error,omitempty"`
+	BlockSize   int64  `json:"blockSize"`
+	Blocks      uint64 `json:"blocks"`
+	BlocksFree  uint64 `json:"blocksFree"`
+	BlocksAvail uint64 `json:"blocksAvail"`
+	Files       uint64 `json:"files"`
+	FilesFree   uint64 `json:"filesFree"`
+}
+
+type capacityInfo struct {
+	AvailableCapacity int64 `json:"availableCapacity"`
+	VolumesCount      int   `json:"volumesCount"`
+	TotalVolumesSize  int64 `json:"totalVolumesSize"`
+	MetadataSize      int64 `json:"metadataSize"`
+	// QuarantinedVolumeIDs are volumes whose states couldn't be parsed, their directories still take capacity.
+	QuarantinedVolumeIDs []string `json:"quarantinedVolumeIDs,omitempty"`
+	Error                string   `json:"error,omitempty"`
+}
+
+type limitStatus struct {
+	VolumeID       string `json:"volumeID"`
+	LimitID        uint32 `json:"limitID"`
+	Size           int64  `json:"size"`
+	HardLimitBytes int64  `json:"hardLimitBytes"`
+	InodeLimit     uint64 `json:"inodeLimit"`
+	UsedBytes      int64  `json:"usedBytes"`
+	UsedInodes     uint64 `json:"usedInodes"`
+	Error          string `json:"error,omitempty"`
+}
+
+type limiterInfo struct {
+	VolumesDir string        `json:"volumesDir"`
+	Limiter    string        `json:"limiter"`
+	Limits     []limitStatus `json:"limits,omitempty"`
+	Error      string        `json:"error,omitempty"`
+}
+
+// writeStateFiles writes state files found in the directory, quarantined ones included, under the bundle directory.
+func (w *supportBundleWriter) writeStateFiles(dir, bundleDir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("can't read state directory %q: %w", dir, err)
+	}
+
+	quarantinedSuffix := ".json" + volume.CorruptStateFileSuffix
+	for _, e := range entries {
+		if e.IsDir() || (!strings.HasSuffix(e.Name(), ".json") && !strings.HasSuffix(e.Name(), quarantinedSuffix) && !strings.HasSuffix(e.Name(), quarantinedSuffix+volume.CompressedStateFileSuffix)) {
+			continue
+		}
+
+		statePath := filepath.Join(dir, e.Name())
+		content, err := os.ReadFile(statePath)
+		if err != nil {
+			klog.ErrorS(err, "Can't read state file", "path", statePath)
+			continue
+		}
+
+		err = w.writeFile(filepath.Join(bundleDir, e.Name()), content)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// getFilesystemInfo returns statistics of the volumes dir filesystem, failures to get them are recorded in it.
+func getFilesystemInfo(dir string) *filesystemInfo {
+	fsInfo := &filesystemInfo{
+		Path: dir,
+	}
+
+	var err error
+	fsInfo.Type, err = fs.GetFilesystem(dir)
+	if err != nil {
+		fsInfo.Error = err.Error()
+	}
+
+	var stat unix.Statfs_t
+	err = unix.Statfs(dir, &stat)
+	if err != nil {
+		fsInfo.Error = err.Error()
+	} else {
+		fsInfo.BlockSize = stat.Bsize
+		fsInfo.Blocks = stat.Blocks
+		fsInfo.BlocksFree = stat.Bfree
+		fsInfo.BlocksAvail = stat.Bavail
+		fsInfo.Files = stat.Files
+		fsInfo.FilesFree = stat.Ffree
+	}
+
+	return fsInfo
+}
+
+// getLimiterInfo returns limits of the volumes living in the volumes dir as its limiter sees them. Limiter is created
+// without volumes, so it doesn't restore any limit.
+func getLimiterInfo(fsInfo *filesystemInfo, mainVolumesDir string, volumes []volume.VolumeState) *limiterInfo {
+	li := &limiterInfo{
+		VolumesDir: fsInfo.Path,
+		Limiter:    "noop",
+	}
+	if fsInfo.Type != "xfs" && fsInfo.Type != "ext4" {
+		return li
+	}
+
+	li.Limiter = fsInfo.Type
+	limiter, err := newLimiter(fsInfo.Path, fsInfo.Type, nil)
+	if err != nil {
+		li.Error = err.Error()
+		return li
+	}
+	defer func() {
+		closeErr := limiter.Close()
+		if closeErr != nil {
+			klog.ErrorS(closeErr, "Failed to close the limiter", "volumesDir", fsInfo.Path)
+		}
+	}()
+
+	for _, v := range volumes {
+		if !v.IsInVolumesDir(fsInfo.Path, mainVolumesDir) {
+			continue
+		}
+
+		ls := limitStatus{
+			VolumeID: v.ID,
+			LimitID:  v.LimitID,
+			Size:     v.Size,
+		}
+
+		ls.HardLimitBytes, ls.InodeLimit, err = limiter.GetLimit(v.LimitID)
+		if err == nil {
+			ls.UsedBytes, ls.UsedInodes, err = limiter.GetUsage(v.LimitID)
+		}
+		if err != nil {
+			ls.Error = err.Error()
+		}
+
+		li.Limits = append(li.Limits, ls)
+	}
+
+	return li
+}
+
+// writeSupportBundle writes a gzip compressed tarball with the driver's view of the volumes dirs, the first of which
+// is the main one. Nothing is changed on disk. Failures to collect a particular piece of information are recorded
+// in the bundle instead of failing the collection.
+func writeSupportBundle(f *os.File, volumesDirs []string, adminAddress string) (err error) {
+	gw := gzip.NewWriter(f)
+	tw := tar.NewWriter(gw)
+	defer func() {
+		tarErr := tw.Close()
+		gzipErr := gw.Close()
+		err = errors.NewAggregate([]error{err, tarErr, gzipErr})
+	}()
+
+	w := &supportBundleWriter{
+		tw:  tw,
+		now: time.Now(),
+	}
+
+	err = w.writeJSON("version.json", version.Get())
+	if err != nil {
+		return err
+	}
+
+	volumesDir := volumesDirs[0]
+	err = w.writeStateFiles(volumesDir, "state")
+	if err != nil {
+		return err
+	}
+
+	snapshotsDir := filepath.Join(volumesDir, volume.SnapshotsDirName)
+	_, err = os.Stat(snapshotsDir)
+	if err == nil {
+		err = w.writeStateFiles(snapshotsDir, filepath.Join("state", volume.SnapshotsDirName))
+		if err != nil {
+			return err
+		}
+	} else if !os.IsNotExist(err) {
+		klog.ErrorS(err, "Can't stat snapshots state directory", "path", snapshotsDir)
+	}
+
+	fsInfos := make([]*filesystemInfo, 0, len(volumesDirs))
+	for _, dir := range volumesDirs {
+		fsInfos = append(fsInfos, getFilesystemInfo(dir))
+	}
+
+	err = w.writeJSON("filesystems.json", fsInfos)
+	if err != nil {
+		return err
+	}
+
+	backend := volume.FilesStateBackend
+	_, err = os.Stat(filepath.Join(volumesDir, volume.BoltStateFileName))
+	if err == nil {
+		backend = volume.BoltStateBackend
+	}
+
+	var volumes []volume.VolumeState
+	ci := &capacityInfo{}
+	// Bundle is collected next to a running driver, so the state is only inspected, corrupt states included.
+	sm, err := volume.NewStateManager(volumesDir, volume.WithStateBackend(backend), volume.WithReadOnly(true))
+	if err != nil {
+		ci.Error = err.Error()
+
+		// Running driver holds the state of the bolt backend, so only the driver itself can tell what it is.
+		if stderrors.Is(err, volume.StateLockedErr) && len(adminAddress) != 0 {
+			volumes, ci, err = w.writeAdminState(adminAddress)
+			if err != nil {
+				return err
+			}
+		}
+	} else {
+		defer func() {
+			closeErr := sm.Close()
+			if closeErr != nil {
+				klog.ErrorS(closeErr, "Failed to close the state manager")
+			}
+		}()
+
+		volumes = sm.GetVolumes()
+		ci.VolumesCount = len(volumes)
+		ci.TotalVolumesSize = sm.GetTotalVolumesSize()
+		ci.MetadataSize = int64(len(volumes)+1) * volume.MetadataFileMaxSize
+		ci.QuarantinedVolumeIDs = sm.GetQuarantinedVolumeIDs()
+
+		// Capacity is computed the way the driver does it, with the default reservations of the filesystems.
+		options := []volume.VolumeManagerOption{
+			volume.WithVolumesDirFilesystem(fsInfos[0].Type),
+			volume.WithCapacityReservationPercent(volume.DefaultFilesystemCapacityReservationPercent[fsInfos[0].Type]),
+		}
+		for _, fsInfo := range fsInfos[1:] {
+			options = append(options, volume.WithAdditionalVolumesDirs(volume.AdditionalVolumesDir{
+				Path:                       fsInfo.Path,
+				FsType:                     fsInfo.Type,
+				CapacityReservationPercent: volume.DefaultFilesystemCapacityReservationPercent[fsInfo.Type],
+			}))
+		}
+
+		ci.AvailableCapacity, err = volume.InspectCapacity(volumesDir, sm, options...)
+		if err != nil {
+			ci.Error = err.Error()
+		}
+	}
+
+	err = w.writeJSON("capacity.json", ci)
+	if err != nil {
+		return err
+	}
+
+	limiterInfos := make([]*limiterInfo, 0, len(fsInfos))
+	for _, fsInfo := range fsInfos {
+		limiterInfos = append(limiterInfos, getLimiterInfo(fsInfo, volumesDir, volumes))
+	}
+
+	err = w.writeJSON("limiters.json", limiterInfos)
+	if err != nil {
+		return err
+	}
+
+	mountPoints, err := mount.New("").List()
+	if err != nil {
+		err = w.writeFile("mounts.error", []byte(err.Error()))
+	} else {
+		err = w.writeJSON("mounts.json", mountPoints)
+	}
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
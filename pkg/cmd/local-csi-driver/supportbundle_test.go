@@ -0,0 +1,277 @@
+// Copyright (c) 2023 ScyllaDB.
+
+package driver
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/scylladb/local-csi-driver/pkg/driver"
+	"github.com/scylladb/local-csi-driver/pkg/driver/volume"
+)
+
+func TestWriteSupportBundle(t *testing.T) {
+	t.Parallel()
+
+	volumesDir := t.TempDir()
+	additionalVolumesDir := t.TempDir()
+
+	vs := &volume.VolumeState{
+		Name:    "volume-1",
+		ID:      "volume-1-uuid",
+		LimitID: 1,
+		Size:    1024,
+	}
+	stateContent, err := json.Marshal(vs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.WriteFile(filepath.Join(volumesDir, "volume-1-uuid.json"), stateContent, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	additionalVs := &volume.VolumeState{
+		Name:       "volume-3",
+		ID:         "volume-3-uuid",
+		LimitID:    1,
+		Size:       2048,
+		VolumesDir: additionalVolumesDir,
+	}
+	additionalStateContent, err := json.Marshal(additionalVs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.WriteFile(filepath.Join(volumesDir, "volume-3-uuid.json"), additionalStateContent, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.Mkdir(filepath.Join(additionalVolumesDir, "volume-3-uuid"), 0770)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ss := &volume.SnapshotState{
+		Name:           "snapshot-1",
+		ID:             "snapshot-1-uuid",
+		SourceVolumeID: "volume-1-uuid",
+		Size:           1024,
+		VolumesDir:     volumesDir,
+	}
+	snapshotStateContent, err := json.Marshal(ss)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.Mkdir(filepath.Join(volumesDir, volume.SnapshotsDirName), 0770)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.WriteFile(filepath.Join(volumesDir, volume.SnapshotsDirName, "snapshot-1-uuid.json"), snapshotStateContent, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	corruptStateContent := []byte("not a json")
+	err = os.WriteFile(filepath.Join(volumesDir, "volume-2-uuid.json"), corruptStateContent, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.Mkdir(filepath.Join(volumesDir, "volume-1-uuid"), 0770)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.WriteFile(filepath.Join(volumesDir, "volume-1-uuid", "data"), []byte("volume data"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := writeTestSupportBundle(t, []string{volumesDir, additionalVolumesDir}, "")
+	names := sortedFileNames(files)
+
+	expectedNames := []string{
+		"capacity.json",
+		"filesystems.json",
+		"limiters.json",
+		"mounts.json",
+		"state/snapshots/snapshot-1-uuid.json",
+		"state/volume-1-uuid.json",
+		"state/volume-2-uuid.json",
+		"state/volume-3-uuid.json",
+		"version.json",
+	}
+	if !reflect.DeepEqual(names, expectedNames) {
+		t.Fatalf("expected bundle files %q, got %q", expectedNames, names)
+	}
+
+	if !reflect.DeepEqual(files["state/volume-1-uuid.json"], stateContent) {
+		t.Errorf("expected state file content %q, got %q", stateContent, files["state/volume-1-uuid.json"])
+	}
+
+	if !reflect.DeepEqual(files["state/snapshots/snapshot-1-uuid.json"], snapshotStateContent) {
+		t.Errorf("expected snapshot state file content %q, got %q", snapshotStateContent, files["state/snapshots/snapshot-1-uuid.json"])
+	}
+
+	// Volumes dirs aren't assigned IDs, as that's up to the driver.
+	for _, dir := range []string{volumesDir, additionalVolumesDir} {
+		_, err = os.Stat(filepath.Join(dir, volume.VolumesDirIDFileName))
+		if !os.IsNotExist(err) {
+			t.Errorf("expected no ID file in volumes dir %q, got %v", dir, err)
+		}
+	}
+
+	// Bundle only inspects the state, so the corrupt state is left for the driver to quarantine.
+	_, err = os.Stat(filepath.Join(volumesDir, "volume-2-uuid.json"))
+	if err != nil {
+		t.Errorf("expected corrupt state file to be left in place: %v", err)
+	}
+	if !reflect.DeepEqual(files["state/volume-2-uuid.json"], corruptStateContent) {
+		t.Errorf("expected corrupt state file content %q, got %q", corruptStateContent, files["state/volume-2-uuid.json"])
+	}
+
+	ci := &capacityInfo{}
+	err = json.Unmarshal(files["capacity.json"], ci)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ci.VolumesCount != 2 || ci.TotalVolumesSize != vs.Size+additionalVs.Size || ci.AvailableCapacity == 0 || !reflect.DeepEqual(ci.QuarantinedVolumeIDs, []string{"volume-2-uuid"}) || len(ci.Error) != 0 {
+		t.Errorf("unexpected capacity info %#v", ci)
+	}
+}
+
+func TestWriteSupportBundleReadsLockedStateThroughAdminEndpoints(t *testing.T) {
+	t.Parallel()
+
+	volumesDir := t.TempDir()
+
+	// Running driver holds the bolt state for as long as it runs.
+	sm, err := volume.NewStateManager(volumesDir, volume.WithStateBackend(volume.BoltStateBackend))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sm.Close()
+
+	volumes := []volume.VolumeState{
+		{
+			Name:    "volume-1",
+			ID:      "volume-1-uuid",
+			LimitID: 1,
+			Size:    1024,
+		},
+	}
+	capacityStatus := &driver.CapacityStatus{
+		AvailableBytes:   4096,
+		ProvisionedBytes: 1024,
+		VolumesCount:     1,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /volumes", func(w http.ResponseWriter, _ *http.Request) {
+		_ = json.NewEncoder(w).Encode(volumes)
+	})
+	mux.HandleFunc("GET /capacity", func(w http.ResponseWriter, _ *http.Request) {
+		_ = json.NewEncoder(w).Encode(capacityStatus)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	files := writeTestSupportBundle(t, []string{volumesDir}, strings.TrimPrefix(server.URL, "http://"))
+
+	var gotVolumes []volume.VolumeState
+	err = json.Unmarshal(files["admin/volumes.json"], &gotVolumes)
+	if err != nil {
+		t.Fatalf("can't decode volumes of %q: %v", sortedFileNames(files), err)
+	}
+	if !reflect.DeepEqual(gotVolumes, volumes) {
+		t.Errorf("expected volumes %#v, got %#v", volumes, gotVolumes)
+	}
+
+	ci := &capacityInfo{}
+	err = json.Unmarshal(files["capacity.json"], ci)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ci.AvailableCapacity != capacityStatus.AvailableBytes || ci.VolumesCount != 1 || ci.TotalVolumesSize != 1024 || len(ci.Error) != 0 {
+		t.Errorf("unexpected capacity info %#v", ci)
+	}
+}
+
+// writeTestSupportBundle writes the support bundle of the volumes dirs and returns its files by their names.
+func writeTestSupportBundle(t *testing.T, volumesDirs []string, adminAddress string) map[string][]byte {
+	t.Helper()
+
+	bundlePath := filepath.Join(t.TempDir(), "bundle.tar.gz")
+	f, err := os.Create(bundlePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = writeSupportBundle(f, volumesDirs, adminAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err = os.Open(bundlePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string][]byte{}
+	tr := tar.NewReader(gr)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[h.Name] = content
+	}
+
+	return files
+}
+
+func sortedFileNames(files map[string][]byte) []string {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
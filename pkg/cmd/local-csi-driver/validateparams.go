@@ -0,0 +1,135 @@
+// Copyright (c) 2023 ScyllaDB.
+
+package driver
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/scylladb/local-csi-driver/pkg/driver"
+	"github.com/scylladb/local-csi-driver/pkg/genericclioptions"
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/util/errors"
+)
+
+type ValidateParamsOptions struct {
+	Params []string
+
+	parameters map[string]string
+}
+
+func NewValidateParamsOptions(_ genericclioptions.IOStreams) *ValidateParamsOptions {
+	return &ValidateParamsOptions{}
+}
+
+func NewValidateParamsCommand(streams genericclioptions.IOStreams) *cobra.Command {
+	o := NewValidateParamsOptions(streams)
+
+	cmd := &cobra.Command{
+		Use:   "validate-params",
+		Short: "Validate StorageClass parameters",
+		Long:  `Validate StorageClass parameters using the same rules the driver applies to CreateVolume requests, reporting which of them are accepted and which rejected.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			err := o.Validate()
+			if err != nil {
+				return err
+			}
+
+			err = o.Complete()
+			if err != nil {
+				return err
+			}
+
+			err = o.Run(streams)
+			if err != nil {
+				return err
+			}
+
+			return nil
+		},
+
+		SilenceErrors: true,
+		SilenceUsage:  true,
+	}
+
+	cmd.Flags().StringArrayVarP(&o.Params, "param", "", o.Params, "StorageClass parameter in key=value form. Can be repeated.")
+
+	return cmd
+}
+
+func (o *ValidateParamsOptions) Validate() error {
+	var errs []error
+
+	keys := make(map[string]struct{}, len(o.Params))
+	for _, p := range o.Params {
+		k, _, ok := strings.Cut(p, "=")
+		if !ok {
+			errs = append(errs, fmt.Errorf("param %q must be in key=value form", p))
+			continue
+		}
+
+		if _, ok := keys[k]; ok {
+			errs = append(errs, fmt.Errorf("param %q is specified more than once", k))
+		}
+		keys[k] = struct{}{}
+	}
+
+	err := errors.NewAggregate(errs)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (o *ValidateParamsOptions) Complete() error {
+	o.parameters = make(map[string]string, len(o.Params))
+	for _, p := range o.Params {
+		k, v, _ := strings.Cut(p, "=")
+		o.parameters[k] = v
+	}
+
+	return nil
+}
+
+func (o *ValidateParamsOptions) Run(streams genericclioptions.IOStreams) error {
+	rejected, err := writeParametersValidation(streams.Out, o.parameters)
+	if err != nil {
+		return fmt.Errorf("can't write validation result: %w", err)
+	}
+
+	if rejected != 0 {
+		return fmt.Errorf("%d of %d parameters were rejected", rejected, len(o.parameters))
+	}
+
+	return nil
+}
+
+// writeParametersValidation reports validation result of every parameter, sorted by key,
+// and returns how many of them were rejected.
+func writeParametersValidation(w io.Writer, parameters map[string]string) (int, error) {
+	keys := make([]string, 0, len(parameters))
+	for k := range parameters {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	rejected := 0
+	for _, k := range keys {
+		var err error
+		validationErr := driver.ValidateVolumeParameter(k, parameters[k])
+		if validationErr != nil {
+			rejected++
+			_, err = fmt.Fprintf(w, "rejected\t%s: %v\n", k, validationErr)
+		} else {
+			_, err = fmt.Fprintf(w, "accepted\t%s\n", k)
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return rejected, nil
+}
@@ -0,0 +1,88 @@
+// Copyright (c) 2023 ScyllaDB.
+
+package driver
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/scylladb/local-csi-driver/pkg/driver"
+)
+
+func TestWriteParametersValidation(t *testing.T) {
+	t.Parallel()
+
+	parameters := map[string]string{
+		driver.StorageClassNameParameterKey: "scylladb-local-xfs",
+		driver.InodeLimitParameterKey:       "0",
+		"unknown":                           "value",
+	}
+
+	out := &bytes.Buffer{}
+	rejected, err := writeParametersValidation(out, parameters)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rejected != 2 {
+		t.Errorf("expected 2 rejected parameters, got %d", rejected)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	expectedPrefixes := []string{
+		"rejected\tinodeLimit: ",
+		"accepted\tstorageClassName",
+		"rejected\tunknown: ",
+	}
+	if len(lines) != len(expectedPrefixes) {
+		t.Fatalf("expected %d lines, got %q", len(expectedPrefixes), out.String())
+	}
+	for i, prefix := range expectedPrefixes {
+		if !strings.HasPrefix(lines[i], prefix) {
+			t.Errorf("expected line %d to start with %q, got %q", i, prefix, lines[i])
+		}
+	}
+}
+
+func TestValidateParamsOptionsValidate(t *testing.T) {
+	t.Parallel()
+
+	tt := []struct {
+		name        string
+		params      []string
+		expectedErr bool
+	}{
+		{
+			name:   "key value pairs",
+			params: []string{"storageClassName=fast", "inodeLimit=1000"},
+		},
+		{
+			name:   "empty value",
+			params: []string{"storageClassName="},
+		},
+		{
+			name:        "missing separator",
+			params:      []string{"storageClassName"},
+			expectedErr: true,
+		},
+		{
+			name:        "duplicate key",
+			params:      []string{"inodeLimit=1", "inodeLimit=2"},
+			expectedErr: true,
+		},
+	}
+
+	for i := range tt {
+		tc := tt[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			o := &ValidateParamsOptions{Params: tc.params}
+			err := o.Validate()
+			if tc.expectedErr != (err != nil) {
+				t.Errorf("expected error %v, got %v", tc.expectedErr, err)
+			}
+		})
+	}
+}
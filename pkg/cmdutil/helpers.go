@@ -6,16 +6,21 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
 	"k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/klog/v2"
 )
 
 const (
 	FlagLogLevelKey   = "loglevel"
 	flagLogLevelUsage = "Set the level of log output (0-10)."
+
+	minLogLevel = 0
+	maxLogLevel = 10
 )
 
 func NormalizeNameForEnvVar(name string) string {
@@ -56,9 +61,18 @@ func ReadFlagsFromEnv(prefix string, cmd *cobra.Command) error {
 type proxyFlag struct {
 	parentFlag flag.Value
 	flagType   string
+	normalize  func(string) (string, error)
 }
 
 func (v *proxyFlag) Set(value string) error {
+	if v.normalize != nil {
+		var err error
+		value, err = v.normalize(value)
+		if err != nil {
+			return err
+		}
+	}
+
 	return v.parentFlag.Set(value)
 }
 
@@ -71,21 +85,77 @@ func (v *proxyFlag) Type() string {
 }
 
 // InstallKlog registers a "loglevel" flag which value propagates into "v" flag used by underlying logger.
+// Flags which are already registered, e.g. by a test framework the command is embedded in, are kept as they are.
+// A "v" flag which doesn't set the logger verbosity is left visible with a warning, as only "loglevel" sets it then.
 func InstallKlog(cmd *cobra.Command) {
-	vFlag := flag.CommandLine.Lookup("v")
-	if vFlag == nil {
-		panic("'v' flag is not installed")
-	}
-
 	logLevelFlagProxy := &proxyFlag{
-		parentFlag: vFlag.Value,
+		parentFlag: klogVerbosityFlag(),
 		flagType:   "int32",
+		normalize:  normalizeLogLevel,
+	}
+
+	// Redefining a flag panics.
+	if cmd.PersistentFlags().Lookup(FlagLogLevelKey) == nil {
+		cmd.PersistentFlags().Var(logLevelFlagProxy, FlagLogLevelKey, flagLogLevelUsage)
 	}
-	cmd.PersistentFlags().Var(logLevelFlagProxy, FlagLogLevelKey, flagLogLevelUsage)
 
 	// v might not be registered in cobra yet
-	if cmd.PersistentFlags().Lookup("v") == nil {
+	vFlag := cmd.PersistentFlags().Lookup("v")
+	if vFlag == nil {
 		cmd.PersistentFlags().Var(logLevelFlagProxy, "v", flagLogLevelUsage)
+		vFlag = cmd.PersistentFlags().Lookup("v")
+	}
+
+	if !isKlogVerbosityFlag(vFlag) {
+		klog.Warningf("Flag %q is already registered and doesn't set the log level, use %q flag instead", "v", FlagLogLevelKey)
+		return
 	}
-	cmd.PersistentFlags().Lookup("v").Hidden = true
+	vFlag.Hidden = true
+}
+
+// isKlogVerbosityFlag returns whether the flag sets the klog verbosity, either through the log level proxy,
+// or being the klog "v" flag of go flags added to cobra.
+func isKlogVerbosityFlag(f *pflag.Flag) bool {
+	_, ok := f.Value.(*proxyFlag)
+	if ok {
+		return true
+	}
+
+	// Go flags added to cobra are wrapped, so the klog flag is only recognized by its type and usage.
+	fs := flag.NewFlagSet("klog", flag.ContinueOnError)
+	klog.InitFlags(fs)
+
+	return f.Value.Type() == "Level" && f.Usage == fs.Lookup("v").Usage
+}
+
+// klogVerbosityFlag returns the value of klog "v" flag. It's registered in go flags by the main package, but
+// it can be missing there or registered by someone else, then it's bound to the logger on a flag set of its own.
+func klogVerbosityFlag() flag.Value {
+	vFlag := flag.CommandLine.Lookup("v")
+	if vFlag != nil {
+		_, ok := vFlag.Value.(*klog.Level)
+		if ok {
+			return vFlag.Value
+		}
+	}
+
+	fs := flag.NewFlagSet("klog", flag.ContinueOnError)
+	klog.InitFlags(fs)
+
+	return fs.Lookup("v").Value
+}
+
+// normalizeLogLevel validates that the log level is a number within the documented bounds
+// and returns its canonical representation.
+func normalizeLogLevel(value string) (string, error) {
+	level, err := strconv.ParseInt(strings.TrimSpace(value), 10, 32)
+	if err != nil {
+		return "", fmt.Errorf("can't parse log level %q: %w", value, err)
+	}
+
+	if level < minLogLevel || level > maxLogLevel {
+		return "", fmt.Errorf("log level %d is out of range [%d, %d]", level, minLogLevel, maxLogLevel)
+	}
+
+	return strconv.FormatInt(level, 10), nil
 }
@@ -0,0 +1,158 @@
+// Copyright (c) 2023 ScyllaDB.
+
+package cmdutil
+
+import (
+	"flag"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"k8s.io/klog/v2"
+)
+
+type fakeFlagValue struct {
+	value string
+}
+
+func (f *fakeFlagValue) String() string {
+	return f.value
+}
+
+func (f *fakeFlagValue) Set(value string) error {
+	f.value = value
+	return nil
+}
+
+func TestLogLevelProxyFlag(t *testing.T) {
+	t.Parallel()
+
+	tt := []struct {
+		name          string
+		value         string
+		expectedValue string
+		expectedErr   bool
+	}{
+		{
+			name:          "minimal log level",
+			value:         "0",
+			expectedValue: "0",
+		},
+		{
+			name:          "maximal log level",
+			value:         "10",
+			expectedValue: "10",
+		},
+		{
+			name:          "value is normalized",
+			value:         " 04 ",
+			expectedValue: "4",
+		},
+		{
+			name:          "log level above range",
+			value:         "99",
+			expectedValue: "2",
+			expectedErr:   true,
+		},
+		{
+			name:          "negative log level",
+			value:         "-1",
+			expectedValue: "2",
+			expectedErr:   true,
+		},
+		{
+			name:          "not a number",
+			value:         "verbose",
+			expectedValue: "2",
+			expectedErr:   true,
+		},
+	}
+
+	for i := range tt {
+		tc := tt[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			parent := &fakeFlagValue{value: "2"}
+			pf := &proxyFlag{
+				parentFlag: parent,
+				flagType:   "int32",
+				normalize:  normalizeLogLevel,
+			}
+
+			err := pf.Set(tc.value)
+			if tc.expectedErr && err == nil {
+				t.Errorf("expected error, got nil")
+			}
+			if !tc.expectedErr && err != nil {
+				t.Errorf("expected no error, got %v", err)
+			}
+
+			if parent.value != tc.expectedValue {
+				t.Errorf("expected parent flag value %q, got %q", tc.expectedValue, parent.value)
+			}
+		})
+	}
+}
+
+func TestInstallKlog(t *testing.T) {
+	t.Parallel()
+
+	tt := []struct {
+		name          string
+		registerFlags func(fs *pflag.FlagSet)
+		// expectedVHidden is whether the "v" flag is hidden, which is only when it sets the log level.
+		expectedVHidden bool
+	}{
+		{
+			name:            "no flags registered",
+			registerFlags:   func(*pflag.FlagSet) {},
+			expectedVHidden: true,
+		},
+		{
+			name: "v flag registered",
+			registerFlags: func(fs *pflag.FlagSet) {
+				fs.Bool("v", false, "Verbose output of a test framework.")
+			},
+			expectedVHidden: false,
+		},
+		{
+			name: "klog v flag registered",
+			registerFlags: func(fs *pflag.FlagSet) {
+				goFlags := flag.NewFlagSet("klog", flag.ContinueOnError)
+				klog.InitFlags(goFlags)
+				fs.AddGoFlag(goFlags.Lookup("v"))
+			},
+			expectedVHidden: true,
+		},
+		{
+			name: "loglevel flag registered",
+			registerFlags: func(fs *pflag.FlagSet) {
+				fs.String(FlagLogLevelKey, "", "Log level of a test framework.")
+			},
+			expectedVHidden: true,
+		},
+	}
+
+	for i := range tt {
+		tc := tt[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			cmd := &cobra.Command{}
+			tc.registerFlags(cmd.PersistentFlags())
+
+			InstallKlog(cmd)
+
+			for _, name := range []string{"v", FlagLogLevelKey} {
+				if cmd.PersistentFlags().Lookup(name) == nil {
+					t.Errorf("expected %q flag to be registered", name)
+				}
+			}
+
+			if hidden := cmd.PersistentFlags().Lookup("v").Hidden; hidden != tc.expectedVHidden {
+				t.Errorf("expected %q flag to be hidden: %t, got %t", "v", tc.expectedVHidden, hidden)
+			}
+		})
+	}
+}
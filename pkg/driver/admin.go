@@ -0,0 +1,209 @@
+// Copyright (c) 2023 ScyllaDB.
+
+package driver
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	stderrors "errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/scylladb/local-csi-driver/pkg/driver/volume"
+	"k8s.io/klog/v2"
+)
+
+type CapacityStatus struct {
+	AvailableBytes   int64                    `json:"availableBytes"`
+	Breakdown        volume.CapacityBreakdown `json:"breakdown"`
+	ProvisionedBytes int64                    `json:"provisionedBytes"`
+	VolumesCount     int                      `json:"volumesCount"`
+	TopologySegments map[string]string        `json:"topologySegments"`
+	Quotas           []volume.QuotaStats      `json:"quotas,omitempty"`
+}
+
+// AdminHandler returns a handler serving node local diagnostic endpoints.
+func (d *driver) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /capacity", d.serveCapacity)
+	mux.HandleFunc("GET /volumes", d.serveVolumes)
+	mux.HandleFunc("GET /volumes/{id}", d.serveVolume)
+	mux.HandleFunc("GET /volumes/{id}/extents", d.serveVolumeExtents)
+	mux.HandleFunc("POST /volumes/{id}/delete", d.authenticateAdmin(d.serveForceDeleteVolume))
+	mux.HandleFunc("GET /leaks", d.serveSuspectedLeaks)
+	mux.Handle("GET /metrics", d.MetricsHandler())
+	return mux
+}
+
+func (d *driver) serveCapacity(w http.ResponseWriter, _ *http.Request) {
+	breakdown, err := d.volumeManager.GetCapacityBreakdown()
+	if err != nil {
+		klog.ErrorS(err, "Can't get available capacity")
+		http.Error(w, "can't get available capacity", http.StatusInternalServerError)
+		return
+	}
+
+	capacityStatus := &CapacityStatus{
+		AvailableBytes:   max(0, breakdown.AvailableBytes()),
+		Breakdown:        breakdown,
+		ProvisionedBytes: d.volumeManager.GetTotalVolumesSize(),
+		VolumesCount:     len(d.volumeManager.GetVolumes()),
+		TopologySegments: d.getNodeAccessibleTopology().Segments,
+	}
+
+	// Quota statistics are only informative, so capacity is served without them.
+	quotas, err := d.volumeManager.GetQuotaStats()
+	if err != nil {
+		klog.ErrorS(err, "Can't get quota statistics")
+	} else {
+		capacityStatus.Quotas = quotas
+	}
+
+	writeJSON(w, capacityStatus)
+}
+
+func (d *driver) serveVolumes(w http.ResponseWriter, _ *http.Request) {
+	volumes := d.volumeManager.GetVolumes()
+	sort.Slice(volumes, func(i, j int) bool {
+		return volumes[i].ID < volumes[j].ID
+	})
+
+	writeJSON(w, volumes)
+}
+
+func (d *driver) serveVolume(w http.ResponseWriter, r *http.Request) {
+	vs := d.volumeManager.GetVolumeStateByID(r.PathValue("id"))
+	if vs == nil {
+		http.Error(w, "volume not found", http.StatusNotFound)
+		return
+	}
+
+	writeJSON(w, vs)
+}
+
+// serveVolumeExtents reports fragmentation of files of the volume. Extent maps of all files are read,
+// so only one request is served at a time and others are rejected meanwhile.
+func (d *driver) serveVolumeExtents(w http.ResponseWriter, r *http.Request) {
+	volumeID := r.PathValue("id")
+	if d.volumeManager.GetVolumeStateByID(volumeID) == nil {
+		http.Error(w, "volume not found", http.StatusNotFound)
+		return
+	}
+
+	if !d.extentStatsMut.TryLock() {
+		http.Error(w, "extent statistics of another volume are being collected", http.StatusTooManyRequests)
+		return
+	}
+	defer d.extentStatsMut.Unlock()
+
+	stats, err := d.volumeManager.GetVolumeExtentStats(volumeID)
+	if err != nil {
+		if stderrors.Is(err, volume.ExtentMappingNotSupportedErr) {
+			http.Error(w, err.Error(), http.StatusNotImplemented)
+			return
+		}
+		klog.ErrorS(err, "Can't get volume extent statistics", "volumeID", volumeID)
+		http.Error(w, "can't get volume extent statistics", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, stats)
+}
+
+// authenticateAdmin serves only requests bearing the admin token, mutating endpoints are disabled without one.
+func (d *driver) authenticateAdmin(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if len(d.adminToken) == 0 {
+			http.Error(w, "admin token isn't configured", http.StatusForbidden)
+			return
+		}
+
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(d.adminToken)) != 1 {
+			klog.Warningf("Rejecting unauthenticated admin request %s %s from %s", r.Method, r.URL.Path, r.RemoteAddr)
+			http.Error(w, "invalid admin token", http.StatusUnauthorized)
+			return
+		}
+
+		handler(w, r)
+	}
+}
+
+// serveSuspectedLeaks reports volumes whose state and directory were found inconsistent by the last leak detection.
+func (d *driver) serveSuspectedLeaks(w http.ResponseWriter, _ *http.Request) {
+	leaks, ok := d.getSuspectedLeaks()
+	if !ok {
+		http.Error(w, "leak detection hasn't run", http.StatusServiceUnavailable)
+		return
+	}
+
+	writeJSON(w, leaks)
+}
+
+// serveForceDeleteVolume removes everything left of the volume, regardless of failures of the individual steps.
+// It's a break-glass for volumes Kubernetes can't delete, bypassing the CSI flow.
+func (d *driver) serveForceDeleteVolume(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("force") != "true" {
+		http.Error(w, "only forced deletion is supported, force=true is required", http.StatusBadRequest)
+		return
+	}
+
+	volumeID := r.PathValue("id")
+	err := volume.ValidateVolumeID(volumeID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Forced deletion must not interleave with a creation of a volume having the same name.
+	vs := d.volumeManager.GetVolumeStateByID(volumeID)
+	if vs != nil {
+		d.volumeNameLocks.LockKey(vs.Name)
+		defer func() {
+			_ = d.volumeNameLocks.UnlockKey(vs.Name)
+		}()
+	}
+
+	d.volumeIDLocks.LockKey(volumeID)
+	defer func() {
+		_ = d.volumeIDLocks.UnlockKey(volumeID)
+	}()
+
+	// Deleting a published volume pulls the data from under the running pod.
+	targetPaths := d.publishedTargets.Get(volumeID)
+	if len(targetPaths) != 0 && r.URL.Query().Get("allowPublished") != "true" {
+		http.Error(w, fmt.Sprintf("volume %q is published to %q, allowPublished=true is required to delete it anyway", volumeID, targetPaths), http.StatusConflict)
+		return
+	}
+
+	klog.Warningf("Force deleting volume %q through admin endpoint on request from %s, bypassing CSI", volumeID, r.RemoteAddr)
+	result, err := d.volumeManager.ForceDeleteVolume(volumeID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !result.Succeeded() {
+		klog.ErrorS(nil, "Forced deletion of volume didn't complete", "volumeID", volumeID, "steps", result.Steps)
+		writeJSONWithStatus(w, http.StatusInternalServerError, result)
+		return
+	}
+
+	klog.Warningf("Volume %q force deleted, state found: %v", volumeID, result.HadState)
+	writeJSON(w, result)
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	writeJSONWithStatus(w, http.StatusOK, v)
+}
+
+func writeJSONWithStatus(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		klog.ErrorS(err, "Can't encode admin response")
+	}
+}
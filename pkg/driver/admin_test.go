@@ -0,0 +1,264 @@
+// Copyright (c) 2023 ScyllaDB.
+
+package driver
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/scylladb/local-csi-driver/pkg/driver/volume"
+	"github.com/scylladb/local-csi-driver/pkg/util/fs"
+)
+
+func TestAdminCapacity(t *testing.T) {
+	t.Parallel()
+
+	d := newTestDriver(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/capacity", nil)
+	rec := httptest.NewRecorder()
+	d.AdminHandler().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	capacityStatus := &CapacityStatus{}
+	err := json.NewDecoder(rec.Body).Decode(capacityStatus)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedSegments := map[string]string{
+		NodeNameTopologyKey: "node-name",
+	}
+	if !reflect.DeepEqual(capacityStatus.TopologySegments, expectedSegments) {
+		t.Errorf("expected topology segments %v, got %v", expectedSegments, capacityStatus.TopologySegments)
+	}
+
+	if capacityStatus.AvailableBytes <= 0 {
+		t.Errorf("expected positive available capacity, got %d", capacityStatus.AvailableBytes)
+	}
+
+	if capacityStatus.Breakdown.AvailableBytes() != capacityStatus.AvailableBytes {
+		t.Errorf("expected capacity breakdown to add up to %d available bytes, got %d", capacityStatus.AvailableBytes, capacityStatus.Breakdown.AvailableBytes())
+	}
+}
+
+func TestAdminVolumes(t *testing.T) {
+	t.Parallel()
+
+	d := newTestDriver(t)
+
+	req := newCreateVolumeRequest("volume", 1024)
+	req.Parameters = map[string]string{
+		StorageClassNameParameterKey: "scylladb-local-xfs",
+	}
+	resp, err := d.CreateVolume(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	volumeID := resp.Volume.VolumeId
+
+	rec := httptest.NewRecorder()
+	d.AdminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/volumes", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	var volumes []volume.VolumeState
+	err = json.NewDecoder(rec.Body).Decode(&volumes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(volumes) != 1 || volumes[0].ID != volumeID || volumes[0].StorageClassName != "scylladb-local-xfs" {
+		t.Errorf("expected volume %q attributed to storage class, got %#v", volumeID, volumes)
+	}
+
+	rec = httptest.NewRecorder()
+	d.AdminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/volumes/"+volumeID, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	d.AdminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/volumes/unknown", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected status %d, got %d", http.StatusNotFound, rec.Code)
+	}
+}
+
+func TestAdminVolumeExtents(t *testing.T) {
+	t.Parallel()
+
+	d := newTestDriver(t)
+
+	resp, err := d.CreateVolume(context.Background(), newCreateVolumeRequest("volume", 1024*1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+	volumeID := resp.Volume.VolumeId
+
+	err = os.WriteFile(filepath.Join(d.volumeManager.VolumesDir(), volumeID, "data"), make([]byte, 4096), 0660)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	d.AdminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/volumes/unknown/extents", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected status %d, got %d", http.StatusNotFound, rec.Code)
+	}
+
+	// Collection is rejected while another one is in progress.
+	d.extentStatsMut.Lock()
+	rec = httptest.NewRecorder()
+	d.AdminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/volumes/"+volumeID+"/extents", nil))
+	d.extentStatsMut.Unlock()
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("expected status %d, got %d", http.StatusTooManyRequests, rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	d.AdminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/volumes/"+volumeID+"/extents", nil))
+	if rec.Code == http.StatusNotImplemented {
+		t.Skipf("filesystem of %q doesn't support FIEMAP", d.volumeManager.VolumesDir())
+	}
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	stats := &fs.ExtentStats{}
+	err = json.NewDecoder(rec.Body).Decode(stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stats.Files != 1 {
+		t.Errorf("expected extents of a single file, got %#v", stats)
+	}
+}
+
+func TestAdminForceDeleteVolume(t *testing.T) {
+	t.Parallel()
+
+	const token = "secret"
+
+	tt := []struct {
+		name           string
+		adminToken     string
+		authorization  string
+		query          string
+		published      bool
+		expectedStatus int
+		expectDeleted  bool
+	}{
+		{
+			name:           "disabled without admin token",
+			authorization:  "Bearer " + token,
+			query:          "?force=true",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "missing token",
+			adminToken:     token,
+			query:          "?force=true",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "invalid token",
+			adminToken:     token,
+			authorization:  "Bearer other",
+			query:          "?force=true",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "not forced",
+			adminToken:     token,
+			authorization:  "Bearer " + token,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "forced",
+			adminToken:     token,
+			authorization:  "Bearer " + token,
+			query:          "?force=true",
+			expectedStatus: http.StatusOK,
+			expectDeleted:  true,
+		},
+		{
+			name:           "published volume",
+			adminToken:     token,
+			authorization:  "Bearer " + token,
+			query:          "?force=true",
+			published:      true,
+			expectedStatus: http.StatusConflict,
+		},
+		{
+			name:           "published volume with override",
+			adminToken:     token,
+			authorization:  "Bearer " + token,
+			query:          "?force=true&allowPublished=true",
+			published:      true,
+			expectedStatus: http.StatusOK,
+			expectDeleted:  true,
+		},
+	}
+
+	for i := range tt {
+		tc := tt[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			d := newTestDriver(t)
+			WithAdminToken(tc.adminToken)(d)
+
+			resp, err := d.CreateVolume(context.Background(), newCreateVolumeRequest("volume", 1024))
+			if err != nil {
+				t.Fatal(err)
+			}
+			volumeID := resp.Volume.VolumeId
+
+			if tc.published {
+				d.publishedTargets.Restore(volumeID, "/target")
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/volumes/"+volumeID+"/delete"+tc.query, nil)
+			if len(tc.authorization) != 0 {
+				req.Header.Set("Authorization", tc.authorization)
+			}
+			rec := httptest.NewRecorder()
+			d.AdminHandler().ServeHTTP(rec, req)
+
+			if rec.Code != tc.expectedStatus {
+				t.Fatalf("expected status %d, got %d: %s", tc.expectedStatus, rec.Code, rec.Body.String())
+			}
+
+			deleted := d.volumeManager.GetVolumeStateByID(volumeID) == nil
+			if deleted != tc.expectDeleted {
+				t.Errorf("expected volume deletion to be %v, got %v", tc.expectDeleted, deleted)
+			}
+
+			if !tc.expectDeleted {
+				return
+			}
+
+			result := &volume.ForceDeleteResult{}
+			err = json.NewDecoder(rec.Body).Decode(result)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if result.VolumeID != volumeID || !result.HadState || !result.Succeeded() {
+				t.Errorf("expected successful deletion of volume %q with state, got %#v", volumeID, result)
+			}
+		})
+	}
+}
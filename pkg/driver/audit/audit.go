@@ -0,0 +1,111 @@
+// Copyright (c) 2023 ScyllaDB.
+
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+)
+
+// Operation is a volume lifecycle operation recorded in the audit log.
+type Operation string
+
+const (
+	CreateVolumeOperation  Operation = "CreateVolume"
+	DeleteVolumeOperation  Operation = "DeleteVolume"
+	MountVolumeOperation   Operation = "Mount"
+	UnmountVolumeOperation Operation = "Unmount"
+)
+
+// Record is a single audit log entry, it's written as one JSON line.
+type Record struct {
+	Time            time.Time `json:"time"`
+	Operation       Operation `json:"operation"`
+	VolumeID        string    `json:"volumeID,omitempty"`
+	VolumeName      string    `json:"volumeName,omitempty"`
+	SizeBytes       int64     `json:"sizeBytes,omitempty"`
+	TargetPath      string    `json:"targetPath,omitempty"`
+	DurationSeconds float64   `json:"durationSeconds"`
+	// Code is the gRPC code the operation finished with.
+	Code  string `json:"code"`
+	Error string `json:"error,omitempty"`
+}
+
+// Logger writes audit records. Nil Logger discards them, so callers don't need to check whether auditing is enabled.
+type Logger struct {
+	// mut serializes writes, so lines of concurrent operations don't interleave.
+	mut    sync.Mutex
+	w      io.Writer
+	closer io.Closer
+	now    func() time.Time
+}
+
+// NewLogger returns a Logger writing records to w.
+func NewLogger(w io.Writer) *Logger {
+	return &Logger{
+		w:   w,
+		now: time.Now,
+	}
+}
+
+// NewFileLogger returns a Logger appending records to the file at path, which is created when it doesn't exist.
+func NewFileLogger(path string) (*Logger, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return nil, fmt.Errorf("can't open audit log %q: %w", path, err)
+	}
+
+	l := NewLogger(f)
+	l.closer = f
+
+	return l, nil
+}
+
+// Log records the operation which started at start and finished with err. Every record is written at once without
+// buffering, so it's in the log as soon as Log returns. Failed writes are only logged, auditing never fails operations.
+func (l *Logger) Log(r Record, start time.Time, err error) {
+	if l == nil {
+		return
+	}
+
+	r.Time = start.UTC()
+	r.DurationSeconds = l.now().Sub(start).Seconds()
+	r.Code = status.Code(err).String()
+	if err != nil {
+		r.Error = status.Convert(err).Message()
+	}
+
+	line, err := json.Marshal(r)
+	if err != nil {
+		klog.ErrorS(err, "Can't encode audit record", "operation", r.Operation, "volumeID", r.VolumeID)
+		return
+	}
+	line = append(line, '\n')
+
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	_, err = l.w.Write(line)
+	if err != nil {
+		klog.ErrorS(err, "Can't write audit record", "operation", r.Operation, "volumeID", r.VolumeID)
+	}
+}
+
+// Close closes the file records are written to, if any.
+func (l *Logger) Close() error {
+	if l == nil || l.closer == nil {
+		return nil
+	}
+
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	return l.closer.Close()
+}
@@ -0,0 +1,76 @@
+// Copyright (c) 2023 ScyllaDB.
+
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestLoggerWritesRecordPerLine(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	l := NewLogger(&buf)
+
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	l.now = func() time.Time {
+		return start.Add(1500 * time.Millisecond)
+	}
+
+	l.Log(Record{Operation: CreateVolumeOperation, VolumeID: "id", SizeBytes: 1024}, start, nil)
+	l.Log(Record{Operation: DeleteVolumeOperation, VolumeID: "id"}, start, status.Error(codes.Internal, "can't remove volume"))
+
+	expected := []Record{
+		{
+			Time:            start,
+			Operation:       CreateVolumeOperation,
+			VolumeID:        "id",
+			SizeBytes:       1024,
+			DurationSeconds: 1.5,
+			Code:            codes.OK.String(),
+		},
+		{
+			Time:            start,
+			Operation:       DeleteVolumeOperation,
+			VolumeID:        "id",
+			DurationSeconds: 1.5,
+			Code:            codes.Internal.String(),
+			Error:           "can't remove volume",
+		},
+	}
+
+	lines := bytes.Split(bytes.TrimSuffix(buf.Bytes(), []byte("\n")), []byte("\n"))
+	if len(lines) != len(expected) {
+		t.Fatalf("expected %d lines, got %q", len(expected), buf.String())
+	}
+
+	for i, line := range lines {
+		var r Record
+		err := json.Unmarshal(line, &r)
+		if err != nil {
+			t.Fatalf("can't decode record %q: %v", line, err)
+		}
+
+		if r != expected[i] {
+			t.Errorf("expected record %#v, got %#v", expected[i], r)
+		}
+	}
+}
+
+func TestNilLoggerDiscardsRecords(t *testing.T) {
+	t.Parallel()
+
+	var l *Logger
+	l.Log(Record{Operation: CreateVolumeOperation}, time.Now(), nil)
+
+	err := l.Close()
+	if err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+}
@@ -4,25 +4,45 @@ package driver
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"os"
+	"time"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
-	"github.com/kubernetes-csi/csi-lib-utils/protosanitizer"
+	"github.com/scylladb/local-csi-driver/pkg/driver/audit"
+	"github.com/scylladb/local-csi-driver/pkg/driver/policy"
 	"github.com/scylladb/local-csi-driver/pkg/driver/volume"
 	"github.com/scylladb/local-csi-driver/pkg/util/slices"
 	"github.com/scylladb/local-csi-driver/pkg/util/uuid"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
-	"k8s.io/apimachinery/pkg/api/equality"
+	"google.golang.org/protobuf/types/known/timestamppb"
 	"k8s.io/klog/v2"
 )
 
 func (d *driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest) (*csi.CreateVolumeResponse, error) {
-	klog.V(4).InfoS("New request", "server", "controller", "function", "CreateVolume", "request", protosanitizer.StripSecrets(req))
+	start := time.Now()
+	resp, err := d.createVolume(ctx, req)
+	d.auditLogger.Log(audit.Record{
+		Operation:  audit.CreateVolumeOperation,
+		VolumeID:   resp.GetVolume().GetVolumeId(),
+		VolumeName: req.GetName(),
+		SizeBytes:  req.GetCapacityRange().GetRequiredBytes(),
+	}, start, err)
+
+	return resp, err
+}
 
+func (d *driver) createVolume(ctx context.Context, req *csi.CreateVolumeRequest) (*csi.CreateVolumeResponse, error) {
 	if len(req.GetName()) == 0 {
 		return nil, status.Error(codes.InvalidArgument, "Name missing in request")
 	}
+
+	// Fail fast when the volume is meant for another node, before doing any capacity work.
+	if !d.satisfiesAccessibilityRequirements(req.GetAccessibilityRequirements()) {
+		return nil, status.Errorf(codes.ResourceExhausted, "Accessibility requirements can't be satisfied, volumes can only be accessible from node %q", d.nodeName)
+	}
 	caps := req.GetVolumeCapabilities()
 	if caps == nil {
 		return nil, status.Error(codes.InvalidArgument, "Volume Capabilities missing in request")
@@ -34,7 +54,7 @@ func (d *driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest)
 	}
 
 	parameters := req.GetParameters()
-	err = d.validateVolumeParameters(parameters)
+	err = ValidateVolumeParameters(parameters)
 	if err != nil {
 		return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("Unsupported volume parameters: %v", err))
 	}
@@ -69,26 +89,98 @@ func (d *driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest)
 		return nil, status.Errorf(codes.InvalidArgument, "Unsupported filesystem: %q", requestedFilesystem)
 	}
 
-	if req.AccessibilityRequirements != nil && len(req.AccessibilityRequirements.Requisite) > 0 {
-		volumeAccessibleTopology := d.getVolumeAccessibleTopology()
+	// Parameters were already validated.
+	backingMode, _ := getBackingMode(parameters)
+	if backingMode == volume.LoopBacking && requestedAccessType == volume.BlockAccess {
+		return nil, status.Errorf(codes.InvalidArgument, "Block volumes can't be %q backed", backingMode)
+	}
+
+	// Parameters were already validated.
+	encrypted, _ := getEncrypted(parameters)
+	extentSizeHint, _ := getExtentSizeHint(parameters)
+	var encryptionKey []byte
+	if encrypted {
+		if requestedAccessType != volume.MountAccess || backingMode != volume.DirectoryBacking {
+			return nil, status.Errorf(codes.InvalidArgument, "Only directory backed mount volumes can be encrypted")
+		}
+
+		encryptionKey, err = getEncryptionKey(req.GetSecrets())
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "Invalid secrets: %v", err)
+		}
+	}
+
+	capacity := req.GetCapacityRange().GetRequiredBytes()
+	limitBytes := req.GetCapacityRange().GetLimitBytes()
+	if capacity < 0 || limitBytes < 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "Required bytes %d and limit bytes %d can't be negative", capacity, limitBytes)
+	}
+
+	// Volume can't be smaller than required, so the limit isn't satisfiable.
+	if limitBytes > 0 && capacity > limitBytes {
+		return nil, status.Errorf(codes.InvalidArgument, "Required bytes %d exceed limit bytes %d", capacity, limitBytes)
+	}
 
-		var accessibleTopologySegments []map[string]string
-		var requiredTopologySegments []map[string]string
+	var contentSource volume.ContentSource
+	if snapshotSource := req.GetVolumeContentSource().GetSnapshot(); snapshotSource != nil {
+		ss := d.volumeManager.GetSnapshotStateByID(snapshotSource.GetSnapshotId())
+		if ss == nil {
+			return nil, status.Errorf(codes.NotFound, "Snapshot with SnapshotID %q does not exists", snapshotSource.GetSnapshotId())
+		}
 
-		for _, accessibleTopology := range volumeAccessibleTopology {
-			accessibleTopologySegments = append(accessibleTopologySegments, accessibleTopology.Segments)
+		// Volume without a required capacity is as big as the snapshot.
+		if capacity == 0 {
+			capacity = ss.Size
 		}
 
-		for _, requiredTopology := range req.AccessibilityRequirements.Requisite {
-			requiredTopologySegments = append(requiredTopologySegments, requiredTopology.Segments)
+		if capacity < ss.Size {
+			return nil, status.Errorf(codes.OutOfRange, "Requested capacity is smaller than snapshot size: %d", ss.Size)
 		}
 
-		if !equality.Semantic.DeepEqual(requiredTopologySegments, accessibleTopologySegments) {
-			return nil, status.Errorf(codes.ResourceExhausted, "Cannot satisfy accessibility requirements")
+		err = ss.CheckRestorable(capacity, requestedAccessType, backingMode)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "Volume can't be provisioned from snapshot %q: %v", ss.ID, err)
 		}
+
+		contentSource.SnapshotID = ss.ID
 	}
 
-	capacity := req.GetCapacityRange().GetRequiredBytes()
+	if volumeSource := req.GetVolumeContentSource().GetVolume(); volumeSource != nil {
+		sourceVs := d.volumeManager.GetVolumeStateByID(volumeSource.GetVolumeId())
+		if sourceVs == nil {
+			return nil, status.Errorf(codes.NotFound, "Volume with VolumeID %q does not exists", volumeSource.GetVolumeId())
+		}
+
+		// Volume without a required capacity is as big as the source volume.
+		if capacity == 0 {
+			capacity = sourceVs.Size
+		}
+
+		if capacity < sourceVs.Size {
+			return nil, status.Errorf(codes.OutOfRange, "Requested capacity is smaller than source volume size: %d", sourceVs.Size)
+		}
+
+		err = sourceVs.CheckClonable(capacity, requestedAccessType, backingMode)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "Volume can't be cloned from volume %q: %v", sourceVs.ID, err)
+		}
+
+		contentSource.VolumeID = sourceVs.ID
+	}
+
+	// Volume of zero size would have a zero quota, failing every write.
+	if capacity == 0 {
+		return nil, status.Error(codes.InvalidArgument, "Required bytes must be positive for volumes without a content source")
+	}
+
+	if limitBytes > 0 && capacity > limitBytes {
+		return nil, status.Errorf(codes.OutOfRange, "Size %d of the content source exceeds limit bytes %d", capacity, limitBytes)
+	}
+
+	d.volumeNameLocks.LockKey(req.GetName())
+	defer func() {
+		_ = d.volumeNameLocks.UnlockKey(req.GetName())
+	}()
 
 	vs := d.volumeManager.GetVolumeStateByName(req.GetName())
 	if vs != nil {
@@ -96,16 +188,82 @@ func (d *driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest)
 			return nil, status.Errorf(codes.AlreadyExists, "Volume with %q name but with different size already exist", req.GetName())
 		}
 
+		if vs.AccessType != requestedAccessType {
+			return nil, status.Errorf(codes.AlreadyExists, "Volume with %q name but with different access type already exist", req.GetName())
+		}
+
+		if vs.IsLoopBacked() != (backingMode == volume.LoopBacking) {
+			return nil, status.Errorf(codes.AlreadyExists, "Volume with %q name but with different backing mode already exist", req.GetName())
+		}
+
+		if !vs.MatchesFsType(requestedFilesystem) {
+			return nil, status.Errorf(codes.AlreadyExists, "Volume with %q name but with different filesystem already exist", req.GetName())
+		}
+
+		if vs.IsEncrypted() != encrypted {
+			return nil, status.Errorf(codes.AlreadyExists, "Volume with %q name but with different encryption already exist", req.GetName())
+		}
+
+		if vs.ExtentSizeHint != extentSizeHint {
+			return nil, status.Errorf(codes.AlreadyExists, "Volume with %q name but with different extent size hint already exist", req.GetName())
+		}
+
+		if vs.SourceSnapshotID != contentSource.SnapshotID || vs.SourceVolumeID != contentSource.VolumeID {
+			return nil, status.Errorf(codes.AlreadyExists, "Volume with %q name but with different content source already exist", req.GetName())
+		}
+
 		return &csi.CreateVolumeResponse{
 			Volume: &csi.Volume{
 				VolumeId:           vs.ID,
 				CapacityBytes:      capacity,
+				VolumeContext:      getVolumeContext(parameters),
 				ContentSource:      req.GetVolumeContentSource(),
 				AccessibleTopology: d.getVolumeAccessibleTopology(),
 			},
 		}, nil
 	}
 
+	// Existing volumes were admitted when they were created, so only new ones are subject to the policy.
+	attributes := getVolumeAttributes(parameters)
+	err = d.provisioningPolicy.Admit(&policy.Volume{
+		Name:             req.GetName(),
+		SizeBytes:        capacity,
+		StorageClassName: attributes.StorageClassName,
+		PVCName:          attributes.PVCName,
+		PVCNamespace:     attributes.PVCNamespace,
+	})
+	if err != nil {
+		var rejection *policy.RejectionError
+		if errors.As(err, &rejection) {
+			return nil, status.Errorf(codes.FailedPrecondition, "Volume %q %v", req.GetName(), rejection)
+		}
+		return nil, status.Errorf(codes.Internal, "Can't evaluate provisioning policy: %v", err)
+	}
+
+	// Source volume mustn't be deleted while it's being copied.
+	if len(contentSource.VolumeID) != 0 {
+		d.volumeIDLocks.LockKey(contentSource.VolumeID)
+		defer func() {
+			_ = d.volumeIDLocks.UnlockKey(contentSource.VolumeID)
+		}()
+
+		if d.volumeManager.GetVolumeStateByID(contentSource.VolumeID) == nil {
+			return nil, status.Errorf(codes.NotFound, "Volume with VolumeID %q does not exists", contentSource.VolumeID)
+		}
+	}
+
+	// Source snapshot mustn't be deleted while it's being restored.
+	if len(contentSource.SnapshotID) != 0 {
+		d.snapshotIDLocks.LockKey(contentSource.SnapshotID)
+		defer func() {
+			_ = d.snapshotIDLocks.UnlockKey(contentSource.SnapshotID)
+		}()
+
+		if d.volumeManager.GetSnapshotStateByID(contentSource.SnapshotID) == nil {
+			return nil, status.Errorf(codes.NotFound, "Snapshot with SnapshotID %q does not exists", contentSource.SnapshotID)
+		}
+	}
+
 	volumeID := uuid.MustRandom().String()
 
 	// Serialize volume creation to ensure we won't allocate more than we actually can, as
@@ -113,6 +271,15 @@ func (d *driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest)
 	d.mut.Lock()
 	defer d.mut.Unlock()
 
+	if d.exceedsMaxVolumesPerNode() {
+		return nil, status.Errorf(codes.ResourceExhausted, "Node already has the maximum number of volumes: %d", d.maxVolumesPerNode)
+	}
+
+	exceedsBudget, remainingBudget := d.volumeManager.ExceedsProvisioningBudget(capacity)
+	if exceedsBudget {
+		return nil, status.Errorf(codes.ResourceExhausted, "Requested capacity exceeds remaining provisioning budget: %d", remainingBudget)
+	}
+
 	availableCapacity, err := d.volumeManager.GetAvailableCapacity()
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "Cannot check node capacity: %v", err)
@@ -122,15 +289,51 @@ func (d *driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest)
 		return nil, status.Errorf(codes.OutOfRange, "Requested capacity is bigger than available: %d", availableCapacity)
 	}
 
-	err = d.volumeManager.CreateVolume(volumeID, req.GetName(), capacity, requestedAccessType)
+	// Parameters were already validated.
+	inodeLimit, _ := getInodeLimit(parameters)
+
+	klog.V(2).InfoS("Creating volume", "volumeID", volumeID, "name", req.GetName(), "storageClass", attributes.StorageClassName, "pvc", klog.KRef(attributes.PVCNamespace, attributes.PVCName))
+	_, span := startSpan(ctx, "volume.CreateVolume", volumeIDAttributeKey.String(volumeID), volumeSizeAttributeKey.Int64(capacity))
+	err = d.volumeManager.CreateVolume(volumeID, req.GetName(), capacity, &volume.CreateVolumeOptions{
+		AccessType:     requestedAccessType,
+		BackingMode:    backingMode,
+		FsType:         requestedFilesystem,
+		InodeLimit:     inodeLimit,
+		ExtentSizeHint: extentSizeHint,
+		Attributes:     attributes,
+		EncryptionKey:  encryptionKey,
+		Source:         contentSource,
+	})
+	endSpan(span, err)
 	if err != nil {
+		if errors.Is(err, volume.VolumeDirectoryNotEmptyErr) {
+			return nil, status.Errorf(d.nonEmptyVolumeDirectoryCode, "Can't create volume: %s", err)
+		}
+		// Snapshot and source volume are locked, so they can only disappear outside of the CSI flow.
+		if errors.Is(err, volume.SnapshotNotFoundErr) || errors.Is(err, volume.SourceVolumeNotFoundErr) {
+			return nil, status.Errorf(codes.NotFound, "Can't create volume: %s", err)
+		}
+		// Volumes with another filesystem can still be provisioned on nodes which have it.
+		if errors.Is(err, volume.MismatchingFsTypeErr) || errors.Is(err, volume.EncryptionNotSupportedErr) || errors.Is(err, volume.ExtentSizeHintNotSupportedErr) {
+			return nil, status.Errorf(codes.ResourceExhausted, "Can't create volume: %s", err)
+		}
+		// Available capacity summed over multiple volumes dirs, or of volumes dirs with another filesystem, can exceed
+		// what fits in a suitable one.
+		if errors.Is(err, volume.InsufficientCapacityErr) {
+			return nil, status.Errorf(codes.ResourceExhausted, "Can't create volume: %s", err)
+		}
+		// Creation can be retried once some of the pending ones finish.
+		if errors.Is(err, volume.TooManyPendingCreationsErr) {
+			return nil, status.Errorf(codes.Unavailable, "Can't create volume: %s", err)
+		}
 		return nil, status.Errorf(codes.Internal, "Can't create volume: %s", err)
 	}
 
 	return &csi.CreateVolumeResponse{
 		Volume: &csi.Volume{
 			VolumeId:           volumeID,
-			CapacityBytes:      req.GetCapacityRange().GetRequiredBytes(),
+			CapacityBytes:      capacity,
+			VolumeContext:      getVolumeContext(parameters),
 			ContentSource:      req.GetVolumeContentSource(),
 			AccessibleTopology: d.getVolumeAccessibleTopology(),
 		},
@@ -138,13 +341,50 @@ func (d *driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest)
 }
 
 func (d *driver) DeleteVolume(ctx context.Context, req *csi.DeleteVolumeRequest) (*csi.DeleteVolumeResponse, error) {
-	klog.V(4).InfoS("New request", "server", "controller", "function", "DeleteVolume", "request", protosanitizer.StripSecrets(req))
+	record := audit.Record{
+		Operation: audit.DeleteVolumeOperation,
+		VolumeID:  req.GetVolumeId(),
+	}
+	// State is gone once the volume is deleted.
+	vs := d.volumeManager.GetVolumeStateByID(req.GetVolumeId())
+	if vs != nil {
+		record.VolumeName = vs.Name
+		record.SizeBytes = vs.Size
+	}
+
+	start := time.Now()
+	resp, err := d.deleteVolume(ctx, req)
+	d.auditLogger.Log(record, start, err)
+
+	return resp, err
+}
+
+func (d *driver) deleteVolume(ctx context.Context, req *csi.DeleteVolumeRequest) (*csi.DeleteVolumeResponse, error) {
 	volID := req.GetVolumeId()
 	if volID == "" {
 		return nil, status.Error(codes.InvalidArgument, "Volume ID not provided")
 	}
 
-	err := d.volumeManager.DeleteVolume(volID)
+	err := volume.ValidateVolumeID(volID)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "Invalid volume ID: %v", err)
+	}
+
+	// Volume deletion must not interleave with a creation of a volume having the same name.
+	vs := d.volumeManager.GetVolumeStateByID(volID)
+	if vs != nil {
+		d.volumeNameLocks.LockKey(vs.Name)
+		defer func() {
+			_ = d.volumeNameLocks.UnlockKey(vs.Name)
+		}()
+	}
+
+	d.volumeIDLocks.LockKey(volID)
+	defer func() {
+		_ = d.volumeIDLocks.UnlockKey(volID)
+	}()
+
+	err = d.volumeManager.DeleteVolume(volID)
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "Failed to delete volume: %v", err)
 	}
@@ -152,35 +392,191 @@ func (d *driver) DeleteVolume(ctx context.Context, req *csi.DeleteVolumeRequest)
 	return &csi.DeleteVolumeResponse{}, nil
 }
 
+func (d *driver) ControllerExpandVolume(ctx context.Context, req *csi.ControllerExpandVolumeRequest) (*csi.ControllerExpandVolumeResponse, error) {
+	volumeID := req.GetVolumeId()
+	if len(volumeID) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "Volume ID not provided")
+	}
+
+	err := volume.ValidateVolumeID(volumeID)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "Invalid volume ID: %v", err)
+	}
+
+	capacityRange := req.GetCapacityRange()
+	if capacityRange == nil {
+		return nil, status.Error(codes.InvalidArgument, "Capacity range not provided")
+	}
+
+	capacity := capacityRange.GetRequiredBytes()
+	limitBytes := capacityRange.GetLimitBytes()
+	if limitBytes > 0 && capacity > limitBytes {
+		return nil, status.Errorf(codes.InvalidArgument, "Required bytes %d exceed limit bytes %d", capacity, limitBytes)
+	}
+
+	vs := d.volumeManager.GetVolumeStateByID(volumeID)
+	if vs == nil {
+		return nil, status.Errorf(codes.NotFound, "Volume with VolumeID %q does not exists", volumeID)
+	}
+
+	d.volumeNameLocks.LockKey(vs.Name)
+	defer func() {
+		_ = d.volumeNameLocks.UnlockKey(vs.Name)
+	}()
+
+	// Share capacity serialization with volume creation, so concurrent operations can't overcommit.
+	d.mut.Lock()
+	defer d.mut.Unlock()
+
+	// Volume might have been changed or removed while waiting for the locks.
+	vs = d.volumeManager.GetVolumeStateByID(volumeID)
+	if vs == nil {
+		return nil, status.Errorf(codes.NotFound, "Volume with VolumeID %q does not exists", volumeID)
+	}
+
+	if capacity < vs.Size {
+		return nil, status.Errorf(codes.FailedPrecondition, "Volume can't be shrunk from %d to %d bytes", vs.Size, capacity)
+	}
+
+	// Size of the backing file and of the loop device exposing it would stay the same, regardless of the limit.
+	if vs.IsLoopBacked() {
+		return nil, status.Errorf(codes.FailedPrecondition, "Loop backed volume %q can't be expanded", volumeID)
+	}
+
+	if vs.AccessType == volume.BlockAccess {
+		return nil, status.Errorf(codes.FailedPrecondition, "Block volume %q can't be expanded", volumeID)
+	}
+
+	if capacity == vs.Size {
+		return &csi.ControllerExpandVolumeResponse{
+			CapacityBytes:         vs.Size,
+			NodeExpansionRequired: false,
+		}, nil
+	}
+
+	availableCapacity, err := d.volumeManager.GetAvailableCapacity()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Cannot check node capacity: %v", err)
+	}
+
+	if capacity-vs.Size > availableCapacity {
+		return nil, status.Errorf(codes.OutOfRange, "Requested capacity increase is bigger than available: %d", availableCapacity)
+	}
+
+	_, span := startSpan(ctx, "volume.SetLimit", volumeIDAttributeKey.String(volumeID), volumeSizeAttributeKey.Int64(capacity))
+	err = d.volumeManager.ExpandVolume(volumeID, capacity)
+	endSpan(span, err)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Can't expand volume: %s", err)
+	}
+
+	// Volumes are bind mounted directories, so the new limit is effective without any node side action.
+	return &csi.ControllerExpandVolumeResponse{
+		CapacityBytes:         capacity,
+		NodeExpansionRequired: false,
+	}, nil
+}
+
 func (d *driver) GetCapacity(ctx context.Context, req *csi.GetCapacityRequest) (*csi.GetCapacityResponse, error) {
-	klog.V(4).InfoS("New request", "server", "controller", "function", "GetCapacity", "request", protosanitizer.StripSecrets(req))
+	// Volumes of other topology segments, or with capabilities the driver doesn't support, can't be placed here,
+	// so no capacity is available for them. Such requests don't describe the node, so they're not observed.
+	if !d.topologyDisabled && req.GetAccessibleTopology() != nil && !d.isNodeTopology(req.GetAccessibleTopology()) {
+		klog.V(4).InfoS("Reporting no capacity for another topology segment", "topology", req.GetAccessibleTopology().GetSegments())
+		return &csi.GetCapacityResponse{}, nil
+	}
+
+	err := d.validateVolumeCapabilities(req.GetVolumeCapabilities())
+	if err != nil {
+		klog.V(4).InfoS("Reporting no capacity for unsupported volume capabilities", "error", err)
+		return &csi.GetCapacityResponse{}, nil
+	}
 
 	capacity, err := d.volumeManager.GetAvailableCapacity()
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "Cannot check node capacity: %v", err)
 	}
 
+	capacity = d.degradeCapacity(capacity)
+
+	// Published capacity comes from GetCapacity calls, so their time tells how stale it is.
+	d.metrics.observeCapacityComputation(capacity, time.Now())
+
 	return &csi.GetCapacityResponse{
 		AvailableCapacity: capacity,
 	}, nil
 }
 
-func (d *driver) ValidateVolumeCapabilities(ctx context.Context, req *csi.ValidateVolumeCapabilitiesRequest) (*csi.ValidateVolumeCapabilitiesResponse, error) {
-	klog.V(4).InfoS("New request", "server", "controller", "function", "ValidateVolumeCapabilities", "request", protosanitizer.StripSecrets(req))
+func (d *driver) ControllerGetVolume(ctx context.Context, req *csi.ControllerGetVolumeRequest) (*csi.ControllerGetVolumeResponse, error) {
+	volumeID := req.GetVolumeId()
+	if len(volumeID) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "Volume ID not provided")
+	}
+
+	err := volume.ValidateVolumeID(volumeID)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "Invalid volume ID: %v", err)
+	}
+
+	vs := d.volumeManager.GetVolumeStateByID(volumeID)
+	if vs == nil {
+		return nil, status.Errorf(codes.NotFound, "Volume with VolumeID %q does not exists", volumeID)
+	}
+
+	return &csi.ControllerGetVolumeResponse{
+		Volume: &csi.Volume{
+			VolumeId:           vs.ID,
+			CapacityBytes:      vs.Size,
+			AccessibleTopology: d.getVolumeAccessibleTopology(),
+		},
+		Status: &csi.ControllerGetVolumeResponse_VolumeStatus{
+			VolumeCondition: d.getVolumeCondition(vs),
+		},
+	}, nil
+}
+
+// getVolumeCondition reports the volume as abnormal when its directory can't be found on disk.
+func (d *driver) getVolumeCondition(vs *volume.VolumeState) *csi.VolumeCondition {
+	path := vs.VolumePath(d.volumeManager.VolumesDir())
+	_, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &csi.VolumeCondition{
+				Abnormal: true,
+				Message:  fmt.Sprintf("Volume directory %q doesn't exist", path),
+			}
+		}
+
+		return &csi.VolumeCondition{
+			Abnormal: true,
+			Message:  fmt.Sprintf("Can't stat volume directory %q: %v", path, err),
+		}
+	}
+
+	return &csi.VolumeCondition{
+		Abnormal: false,
+		Message:  "Volume is healthy",
+	}
+}
 
+func (d *driver) ValidateVolumeCapabilities(ctx context.Context, req *csi.ValidateVolumeCapabilitiesRequest) (*csi.ValidateVolumeCapabilitiesResponse, error) {
 	volumeID := req.GetVolumeId()
 	if len(volumeID) == 0 {
 		return nil, status.Error(codes.InvalidArgument, "VolumeID is missing in request")
 	}
 
+	err := volume.ValidateVolumeID(volumeID)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "Invalid volume ID: %v", err)
+	}
+
 	v := d.volumeManager.GetVolumeStateByID(volumeID)
 	if v == nil {
 		return nil, status.Errorf(codes.NotFound, "Volume with VolumeID %q does not exists", volumeID)
 	}
 
-	volumeContext := req.GetVolumeContext()
-	if len(volumeContext) != 0 {
-		return nil, status.Errorf(codes.InvalidArgument, "Expected VolumeContext to be empty but got %v", volumeContext)
+	err = validateVolumeContext(req.GetVolumeContext())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "Unsupported volume context: %v", err)
 	}
 
 	caps := req.GetVolumeCapabilities()
@@ -188,13 +584,13 @@ func (d *driver) ValidateVolumeCapabilities(ctx context.Context, req *csi.Valida
 		return nil, status.Error(codes.InvalidArgument, "Volume Capabilities are missing in request")
 	}
 
-	err := d.validateVolumeCapabilities(caps)
+	err = d.validateVolumeCapabilities(caps)
 	if err != nil {
 		return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("Unsupported volume capabilites: %s", err))
 	}
 
 	parameters := req.GetParameters()
-	err = d.validateVolumeParameters(parameters)
+	err = ValidateVolumeParameters(parameters)
 	if err != nil {
 		return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("Unsupported volume parameters: %s", err))
 	}
@@ -208,10 +604,125 @@ func (d *driver) ValidateVolumeCapabilities(ctx context.Context, req *csi.Valida
 	}, nil
 }
 
+func (d *driver) CreateSnapshot(ctx context.Context, req *csi.CreateSnapshotRequest) (*csi.CreateSnapshotResponse, error) {
+	if len(req.GetName()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "Name missing in request")
+	}
+
+	sourceVolumeID := req.GetSourceVolumeId()
+	if len(sourceVolumeID) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "Source volume ID not provided")
+	}
+
+	err := volume.ValidateVolumeID(sourceVolumeID)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "Invalid source volume ID: %v", err)
+	}
+
+	d.snapshotNameLocks.LockKey(req.GetName())
+	defer func() {
+		_ = d.snapshotNameLocks.UnlockKey(req.GetName())
+	}()
+
+	ss := d.volumeManager.GetSnapshotStateByName(req.GetName())
+	if ss != nil {
+		if ss.SourceVolumeID != sourceVolumeID {
+			return nil, status.Errorf(codes.AlreadyExists, "Snapshot with %q name but of a different volume already exist", req.GetName())
+		}
+
+		return &csi.CreateSnapshotResponse{
+			Snapshot: newCSISnapshot(ss),
+		}, nil
+	}
+
+	vs := d.volumeManager.GetVolumeStateByID(sourceVolumeID)
+	if vs == nil {
+		return nil, status.Errorf(codes.NotFound, "Volume with VolumeID %q does not exists", sourceVolumeID)
+	}
+
+	// Source volume mustn't be deleted while it's being copied.
+	d.volumeNameLocks.LockKey(vs.Name)
+	defer func() {
+		_ = d.volumeNameLocks.UnlockKey(vs.Name)
+	}()
+
+	vs = d.volumeManager.GetVolumeStateByID(sourceVolumeID)
+	if vs == nil {
+		return nil, status.Errorf(codes.NotFound, "Volume with VolumeID %q does not exists", sourceVolumeID)
+	}
+
+	if vs.IsEncrypted() {
+		return nil, status.Errorf(codes.FailedPrecondition, "Encrypted volume %q can't be snapshotted", sourceVolumeID)
+	}
+
+	snapshotID := uuid.MustRandom().String()
+
+	klog.V(2).InfoS("Creating snapshot", "snapshotID", snapshotID, "name", req.GetName(), "volumeID", sourceVolumeID)
+	_, span := startSpan(ctx, "volume.CreateSnapshot", volumeIDAttributeKey.String(sourceVolumeID), volumeSizeAttributeKey.Int64(vs.Size))
+	ss, err = d.volumeManager.CreateSnapshot(snapshotID, req.GetName(), sourceVolumeID)
+	endSpan(span, err)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Can't create snapshot: %s", err)
+	}
+
+	return &csi.CreateSnapshotResponse{
+		Snapshot: newCSISnapshot(ss),
+	}, nil
+}
+
+func (d *driver) DeleteSnapshot(ctx context.Context, req *csi.DeleteSnapshotRequest) (*csi.DeleteSnapshotResponse, error) {
+	snapshotID := req.GetSnapshotId()
+	if len(snapshotID) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "Snapshot ID not provided")
+	}
+
+	err := volume.ValidateVolumeID(snapshotID)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "Invalid snapshot ID: %v", err)
+	}
+
+	// Snapshot deletion must not interleave with a creation of a snapshot having the same name.
+	ss := d.volumeManager.GetSnapshotStateByID(snapshotID)
+	if ss != nil {
+		d.snapshotNameLocks.LockKey(ss.Name)
+		defer func() {
+			_ = d.snapshotNameLocks.UnlockKey(ss.Name)
+		}()
+	}
+
+	d.snapshotIDLocks.LockKey(snapshotID)
+	defer func() {
+		_ = d.snapshotIDLocks.UnlockKey(snapshotID)
+	}()
+
+	err = d.volumeManager.DeleteSnapshot(snapshotID)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Failed to delete snapshot: %v", err)
+	}
+
+	return &csi.DeleteSnapshotResponse{}, nil
+}
+
+// newCSISnapshot describes the snapshot. Snapshots are copied synchronously, so they're ready as soon as they exist.
+func newCSISnapshot(ss *volume.SnapshotState) *csi.Snapshot {
+	return &csi.Snapshot{
+		SnapshotId:     ss.ID,
+		SourceVolumeId: ss.SourceVolumeID,
+		SizeBytes:      ss.Size,
+		CreationTime:   timestamppb.New(ss.CreationTime),
+		ReadyToUse:     true,
+	}
+}
+
 func (d *driver) ControllerGetCapabilities(ctx context.Context, request *csi.ControllerGetCapabilitiesRequest) (*csi.ControllerGetCapabilitiesResponse, error) {
 	cs := []csi.ControllerServiceCapability_RPC_Type{
 		csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
 		csi.ControllerServiceCapability_RPC_GET_CAPACITY,
+		csi.ControllerServiceCapability_RPC_EXPAND_VOLUME,
+		csi.ControllerServiceCapability_RPC_GET_VOLUME,
+		csi.ControllerServiceCapability_RPC_VOLUME_CONDITION,
+		csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT,
+		csi.ControllerServiceCapability_RPC_CLONE_VOLUME,
 	}
 
 	var csc []*csi.ControllerServiceCapability
@@ -0,0 +1,1578 @@
+// Copyright (c) 2023 ScyllaDB.
+
+package driver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/scylladb/local-csi-driver/pkg/driver/audit"
+	"github.com/scylladb/local-csi-driver/pkg/driver/policy"
+	"github.com/scylladb/local-csi-driver/pkg/driver/volume"
+	"golang.org/x/sys/unix"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func newCreateVolumeRequest(name string, capacity int64) *csi.CreateVolumeRequest {
+	return &csi.CreateVolumeRequest{
+		Name: name,
+		CapacityRange: &csi.CapacityRange{
+			RequiredBytes: capacity,
+		},
+		VolumeCapabilities: []*csi.VolumeCapability{
+			{
+				AccessType: &csi.VolumeCapability_Mount{
+					Mount: &csi.VolumeCapability_MountVolume{},
+				},
+				AccessMode: &csi.VolumeCapability_AccessMode{
+					Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+				},
+			},
+		},
+	}
+}
+
+func TestCreateVolumeRecordsVolumeAttributes(t *testing.T) {
+	t.Parallel()
+
+	d := newTestDriver(t)
+
+	req := newCreateVolumeRequest("volume", 1024)
+	req.Parameters = map[string]string{
+		StorageClassNameParameterKey: "scylladb-local-xfs",
+		PVCNameParameterKey:          "data-scylla-0",
+		PVCNamespaceParameterKey:     "scylla",
+		PVNameParameterKey:           "pvc-1",
+	}
+
+	resp, err := d.CreateVolume(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedAttributes := volume.VolumeAttributes{
+		StorageClassName: "scylladb-local-xfs",
+		PVCName:          "data-scylla-0",
+		PVCNamespace:     "scylla",
+		PVName:           "pvc-1",
+	}
+
+	vs := d.volumeManager.GetVolumeStateByID(resp.Volume.VolumeId)
+	if vs == nil {
+		t.Fatalf("expected volume %q state to exist", resp.Volume.VolumeId)
+	}
+
+	if !reflect.DeepEqual(vs.VolumeAttributes, expectedAttributes) {
+		t.Errorf("expected attributes %#v, got %#v", expectedAttributes, vs.VolumeAttributes)
+	}
+}
+
+func TestConcurrentDeleteAndCreateOfTheSameName(t *testing.T) {
+	t.Parallel()
+
+	d := newTestDriver(t)
+	ctx := context.Background()
+
+	const (
+		volumeName = "volume"
+		iterations = 50
+	)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	go func() {
+		defer wg.Done()
+		for i := 0; i < iterations; i++ {
+			_, err := d.CreateVolume(ctx, newCreateVolumeRequest(volumeName, 1024))
+			if err != nil {
+				t.Errorf("can't create volume: %v", err)
+			}
+		}
+	}()
+
+	go func() {
+		defer wg.Done()
+		for i := 0; i < iterations; i++ {
+			vs := d.volumeManager.GetVolumeStateByName(volumeName)
+			if vs == nil {
+				continue
+			}
+
+			_, err := d.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: vs.ID})
+			if err != nil {
+				t.Errorf("can't delete volume: %v", err)
+			}
+		}
+	}()
+
+	wg.Wait()
+
+	volumes := d.volumeManager.GetVolumes()
+	if len(volumes) > 1 {
+		t.Fatalf("expected at most one volume, got %d", len(volumes))
+	}
+
+	entries, err := os.ReadDir(d.volumeManager.VolumesDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var volumeDirs, stateFiles []string
+	for _, e := range entries {
+		if e.IsDir() {
+			volumeDirs = append(volumeDirs, e.Name())
+		} else if strings.HasSuffix(e.Name(), ".json") {
+			stateFiles = append(stateFiles, e.Name())
+		}
+	}
+
+	if len(stateFiles) != len(volumes) {
+		t.Fatalf("expected %d state files, got %q", len(volumes), stateFiles)
+	}
+
+	if len(volumeDirs) != len(volumes) {
+		t.Fatalf("expected %d volume directories, got %q", len(volumes), volumeDirs)
+	}
+
+	for _, vs := range volumes {
+		if vs.Name != volumeName {
+			t.Errorf("expected volume name %q, got %q", volumeName, vs.Name)
+		}
+
+		_, err := os.Stat(filepath.Join(d.volumeManager.VolumesDir(), vs.ID))
+		if err != nil {
+			t.Errorf("expected volume directory of %q to exist: %v", vs.ID, err)
+		}
+
+		nameVs := d.volumeManager.GetVolumeStateByName(volumeName)
+		if nameVs == nil || nameVs.ID != vs.ID {
+			t.Errorf("expected name %q to resolve to volume %q, got %#v", volumeName, vs.ID, nameVs)
+		}
+	}
+}
+
+func TestConcurrentCreateOfTheSameName(t *testing.T) {
+	t.Parallel()
+
+	d := newTestDriver(t)
+	ctx := context.Background()
+
+	const (
+		volumeName = "volume"
+		callers    = 20
+	)
+
+	volumeIDs := make([]string, callers)
+	var wg sync.WaitGroup
+	wg.Add(callers)
+	for i := 0; i < callers; i++ {
+		go func(i int) {
+			defer wg.Done()
+			resp, err := d.CreateVolume(ctx, newCreateVolumeRequest(volumeName, 1024))
+			if err != nil {
+				t.Errorf("can't create volume: %v", err)
+				return
+			}
+			volumeIDs[i] = resp.GetVolume().GetVolumeId()
+		}(i)
+	}
+	wg.Wait()
+
+	volumes := d.volumeManager.GetVolumes()
+	if len(volumes) != 1 {
+		t.Fatalf("expected exactly one volume, got %d", len(volumes))
+	}
+
+	for i, id := range volumeIDs {
+		if id != volumes[0].ID {
+			t.Errorf("expected call %d to return volume %q, got %q", i, volumes[0].ID, id)
+		}
+	}
+
+	if got := d.volumeManager.GetTotalVolumesSize(); got != 1024 {
+		t.Errorf("expected total volumes size of a single volume, got %d", got)
+	}
+}
+
+func TestCreateVolumeValidatesCapacityRange(t *testing.T) {
+	t.Parallel()
+
+	tt := []struct {
+		name             string
+		capacityRange    *csi.CapacityRange
+		expectedCode     codes.Code
+		expectedCapacity int64
+	}{
+		{
+			name:         "missing capacity range",
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			name:          "zero required bytes",
+			capacityRange: &csi.CapacityRange{},
+			expectedCode:  codes.InvalidArgument,
+		},
+		{
+			name: "zero required bytes with limit bytes",
+			capacityRange: &csi.CapacityRange{
+				LimitBytes: 1024,
+			},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			name: "negative required bytes",
+			capacityRange: &csi.CapacityRange{
+				RequiredBytes: -1,
+			},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			name: "negative limit bytes",
+			capacityRange: &csi.CapacityRange{
+				RequiredBytes: 1024,
+				LimitBytes:    -1,
+			},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			name: "required bytes exceeding limit bytes",
+			capacityRange: &csi.CapacityRange{
+				RequiredBytes: 2048,
+				LimitBytes:    1024,
+			},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			name: "required bytes without limit bytes",
+			capacityRange: &csi.CapacityRange{
+				RequiredBytes: 1024,
+			},
+			expectedCode:     codes.OK,
+			expectedCapacity: 1024,
+		},
+		{
+			name: "required bytes equal to limit bytes",
+			capacityRange: &csi.CapacityRange{
+				RequiredBytes: 1024,
+				LimitBytes:    1024,
+			},
+			expectedCode:     codes.OK,
+			expectedCapacity: 1024,
+		},
+		{
+			name: "required bytes below limit bytes",
+			capacityRange: &csi.CapacityRange{
+				RequiredBytes: 1024,
+				LimitBytes:    4096,
+			},
+			expectedCode:     codes.OK,
+			expectedCapacity: 1024,
+		},
+	}
+
+	for i := range tt {
+		tc := tt[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			d := newTestDriver(t)
+
+			req := newCreateVolumeRequest("volume", 0)
+			req.CapacityRange = tc.capacityRange
+
+			resp, err := d.CreateVolume(context.Background(), req)
+			if status.Code(err) != tc.expectedCode {
+				t.Fatalf("expected %v code, got %v", tc.expectedCode, err)
+			}
+
+			if err != nil {
+				if vs := d.volumeManager.GetVolumeStateByName("volume"); vs != nil {
+					t.Errorf("expected rejected volume not to be created, got %#v", vs)
+				}
+				return
+			}
+
+			if resp.Volume.CapacityBytes != tc.expectedCapacity {
+				t.Errorf("expected capacity %d, got %d", tc.expectedCapacity, resp.Volume.CapacityBytes)
+			}
+		})
+	}
+}
+
+func TestCreateVolumeRespectsProvisioningBudget(t *testing.T) {
+	t.Parallel()
+
+	const budget = 4096
+
+	d := newTestDriverWithOptions(t, volume.WithMaxTotalProvisionedBytes(budget))
+	ctx := context.Background()
+
+	getCapacity := func() int64 {
+		t.Helper()
+
+		resp, err := d.GetCapacity(ctx, &csi.GetCapacityRequest{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.GetAvailableCapacity()
+	}
+
+	if got := getCapacity(); got != budget {
+		t.Errorf("expected available capacity to be capped at %d, got %d", budget, got)
+	}
+
+	_, err := d.CreateVolume(ctx, newCreateVolumeRequest("volume-1", budget-1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := getCapacity(); got != 1024 {
+		t.Errorf("expected available capacity of 1024, got %d", got)
+	}
+
+	_, err = d.CreateVolume(ctx, newCreateVolumeRequest("volume-2", 1025))
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("expected %v code for volume exceeding the budget, got %v", codes.ResourceExhausted, err)
+	}
+
+	_, err = d.CreateVolume(ctx, newCreateVolumeRequest("volume-2", 1024))
+	if err != nil {
+		t.Fatalf("expected volume exactly filling the budget to be created: %v", err)
+	}
+
+	if got := getCapacity(); got != 0 {
+		t.Errorf("expected no available capacity, got %d", got)
+	}
+
+	_, err = d.CreateVolume(ctx, newCreateVolumeRequest("volume-3", 1))
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("expected %v code once the budget is used up, got %v", codes.ResourceExhausted, err)
+	}
+}
+
+func TestCreateVolumeRespectsProvisioningPolicy(t *testing.T) {
+	t.Parallel()
+
+	maxSize := resource.MustParse("4Ki")
+	p, err := policy.NewRulesPolicy([]policy.Rule{
+		{
+			Name:       "dev-size",
+			Namespaces: []string{"dev"},
+			MaxSize:    &maxSize,
+			Message:    "Dev volumes can't be bigger than 4Ki.",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := newTestDriver(t)
+	d.provisioningPolicy = p
+	ctx := context.Background()
+
+	newRequest := func(name string, size int64, namespace string) *csi.CreateVolumeRequest {
+		req := newCreateVolumeRequest(name, size)
+		req.Parameters = map[string]string{
+			PVCNamespaceParameterKey: namespace,
+		}
+		return req
+	}
+
+	_, err = d.CreateVolume(ctx, newRequest("volume-1", 8192, "dev"))
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("expected %v code for volume rejected by the policy, got %v", codes.FailedPrecondition, err)
+	}
+	if !strings.Contains(err.Error(), "Dev volumes can't be bigger than 4Ki.") {
+		t.Errorf("expected error to contain the rule message, got %v", err)
+	}
+
+	if vs := d.volumeManager.GetVolumeStateByName("volume-1"); vs != nil {
+		t.Errorf("expected rejected volume not to be created, got %#v", vs)
+	}
+
+	_, err = d.CreateVolume(ctx, newRequest("volume-1", 4096, "dev"))
+	if err != nil {
+		t.Errorf("expected volume within the policy limit to be created: %v", err)
+	}
+
+	_, err = d.CreateVolume(ctx, newRequest("volume-2", 8192, "prod"))
+	if err != nil {
+		t.Errorf("expected volume not matching the policy rule to be created: %v", err)
+	}
+}
+
+func TestCreateVolumeRespectsMaxVolumesPerNode(t *testing.T) {
+	t.Parallel()
+
+	const maxVolumes = 3
+
+	d := newTestDriver(t)
+	WithMaxVolumesPerNode(maxVolumes)(d)
+	ctx := context.Background()
+
+	nodeInfo, err := d.NodeGetInfo(ctx, &csi.NodeGetInfoRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nodeInfo.GetMaxVolumesPerNode() != maxVolumes {
+		t.Errorf("expected max volumes per node %d, got %d", maxVolumes, nodeInfo.GetMaxVolumesPerNode())
+	}
+
+	for i := 1; i <= maxVolumes; i++ {
+		_, err := d.CreateVolume(ctx, newCreateVolumeRequest(fmt.Sprintf("volume-%d", i), 1024))
+		if err != nil {
+			t.Fatalf("expected volume %d to be created: %v", i, err)
+		}
+	}
+
+	_, err = d.CreateVolume(ctx, newCreateVolumeRequest(fmt.Sprintf("volume-%d", maxVolumes+1), 1024))
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("expected %v code for volume exceeding max volumes per node, got %v", codes.ResourceExhausted, err)
+	}
+
+	// Requests of existing volumes are idempotent regardless of the limit.
+	_, err = d.CreateVolume(ctx, newCreateVolumeRequest("volume-1", 1024))
+	if err != nil {
+		t.Errorf("expected existing volume to be returned: %v", err)
+	}
+}
+
+func TestGetCapacityDegradesPastVolumeCountThreshold(t *testing.T) {
+	t.Parallel()
+
+	const maxVolumes = 10
+
+	tt := []struct {
+		name             string
+		thresholdPercent int
+		volumes          int
+		// loweredMaxVolumes is the max volumes per node set once the volumes are created, unless it's zero.
+		loweredMaxVolumes int64
+		// expectedFraction is the expected share of available capacity reported, in percent.
+		expectedFraction int64
+	}{
+		{
+			name:             "disabled threshold",
+			thresholdPercent: 0,
+			volumes:          9,
+			expectedFraction: 100,
+		},
+		{
+			name:             "below threshold",
+			thresholdPercent: 50,
+			volumes:          4,
+			expectedFraction: 100,
+		},
+		{
+			name:             "at threshold",
+			thresholdPercent: 50,
+			volumes:          5,
+			expectedFraction: 100,
+		},
+		{
+			name:             "past threshold",
+			thresholdPercent: 50,
+			volumes:          8,
+			expectedFraction: 40,
+		},
+		{
+			name:             "at max volumes per node",
+			thresholdPercent: 50,
+			volumes:          maxVolumes,
+			expectedFraction: 0,
+		},
+		{
+			name:              "past max volumes per node with threshold at max",
+			thresholdPercent:  100,
+			volumes:           maxVolumes,
+			loweredMaxVolumes: maxVolumes - 2,
+			expectedFraction:  0,
+		},
+	}
+
+	for i := range tt {
+		tc := tt[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			d := newTestDriver(t)
+			WithMaxVolumesPerNode(maxVolumes)(d)
+			WithCapacityDegradationThresholdPercent(tc.thresholdPercent)(d)
+			ctx := context.Background()
+
+			for i := 0; i < tc.volumes; i++ {
+				_, err := d.CreateVolume(ctx, newCreateVolumeRequest(fmt.Sprintf("volume-%d", i), 1024))
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if tc.loweredMaxVolumes != 0 {
+				WithMaxVolumesPerNode(tc.loweredMaxVolumes)(d)
+			}
+
+			availableCapacity, err := d.volumeManager.GetAvailableCapacity()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			resp, err := d.GetCapacity(ctx, &csi.GetCapacityRequest{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			expectedCapacity := int64(float64(availableCapacity) * float64(tc.expectedFraction) / 100)
+			if resp.GetAvailableCapacity() != expectedCapacity {
+				t.Errorf("expected capacity %d, got %d", expectedCapacity, resp.GetAvailableCapacity())
+			}
+		})
+	}
+}
+
+func TestGetCapacityHonorsTopologyAndCapabilities(t *testing.T) {
+	t.Parallel()
+
+	mountCapability := func(fsType string, mode csi.VolumeCapability_AccessMode_Mode) *csi.VolumeCapability {
+		return &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{
+				Mount: &csi.VolumeCapability_MountVolume{
+					FsType: fsType,
+				},
+			},
+			AccessMode: &csi.VolumeCapability_AccessMode{
+				Mode: mode,
+			},
+		}
+	}
+
+	tt := []struct {
+		name              string
+		topologyDisabled  bool
+		topology          *csi.Topology
+		capabilities      []*csi.VolumeCapability
+		expectedAvailable bool
+	}{
+		{
+			name:              "request without topology and capabilities",
+			expectedAvailable: true,
+		},
+		{
+			name: "topology of this node",
+			topology: &csi.Topology{
+				Segments: map[string]string{NodeNameTopologyKey: "node-name"},
+			},
+			expectedAvailable: true,
+		},
+		{
+			name: "topology of another node",
+			topology: &csi.Topology{
+				Segments: map[string]string{NodeNameTopologyKey: "other-node"},
+			},
+			expectedAvailable: false,
+		},
+		{
+			name: "topology with a segment this node doesn't have",
+			topology: &csi.Topology{
+				Segments: map[string]string{
+					NodeNameTopologyKey:           "node-name",
+					"topology.kubernetes.io/zone": "zone",
+				},
+			},
+			expectedAvailable: false,
+		},
+		{
+			name:             "topology of another node with topology disabled",
+			topologyDisabled: true,
+			topology: &csi.Topology{
+				Segments: map[string]string{NodeNameTopologyKey: "other-node"},
+			},
+			expectedAvailable: true,
+		},
+		{
+			name: "supported capabilities",
+			capabilities: []*csi.VolumeCapability{
+				mountCapability("", csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER),
+			},
+			expectedAvailable: true,
+		},
+		{
+			name: "unsupported access mode",
+			capabilities: []*csi.VolumeCapability{
+				mountCapability("", csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER),
+			},
+			expectedAvailable: false,
+		},
+		{
+			name: "unsupported filesystem",
+			capabilities: []*csi.VolumeCapability{
+				mountCapability("ntfs", csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER),
+			},
+			expectedAvailable: false,
+		},
+	}
+
+	for i := range tt {
+		tc := tt[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			d := newTestDriver(t)
+			WithTopologyDisabled(tc.topologyDisabled)(d)
+
+			availableCapacity, err := d.volumeManager.GetAvailableCapacity()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if availableCapacity == 0 {
+				t.Fatal("expected capacity to be available")
+			}
+
+			resp, err := d.GetCapacity(context.Background(), &csi.GetCapacityRequest{
+				AccessibleTopology: tc.topology,
+				VolumeCapabilities: tc.capabilities,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			expectedCapacity := int64(0)
+			if tc.expectedAvailable {
+				expectedCapacity = availableCapacity
+			}
+			if resp.GetAvailableCapacity() != expectedCapacity {
+				t.Errorf("expected capacity %d, got %d", expectedCapacity, resp.GetAvailableCapacity())
+			}
+		})
+	}
+}
+
+func TestVolumeLifecycleIsAudited(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	d := newTestDriver(t)
+	WithAuditLogger(audit.NewLogger(&buf))(d)
+	ctx := context.Background()
+
+	resp, err := d.CreateVolume(ctx, newCreateVolumeRequest("volume", 1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+	volumeID := resp.GetVolume().GetVolumeId()
+
+	_, err = d.CreateVolume(ctx, newCreateVolumeRequest("volume", 2048))
+	if status.Code(err) != codes.AlreadyExists {
+		t.Fatalf("expected %v code, got %v", codes.AlreadyExists, err)
+	}
+
+	_, err = d.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: volumeID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []audit.Record{
+		{Operation: audit.CreateVolumeOperation, VolumeID: volumeID, VolumeName: "volume", SizeBytes: 1024, Code: codes.OK.String()},
+		{Operation: audit.CreateVolumeOperation, VolumeName: "volume", SizeBytes: 2048, Code: codes.AlreadyExists.String()},
+		{Operation: audit.DeleteVolumeOperation, VolumeID: volumeID, VolumeName: "volume", SizeBytes: 1024, Code: codes.OK.String()},
+	}
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != len(expected) {
+		t.Fatalf("expected %d audit records, got %q", len(expected), buf.String())
+	}
+
+	for i, line := range lines {
+		var r audit.Record
+		err := json.Unmarshal([]byte(line), &r)
+		if err != nil {
+			t.Fatalf("can't decode audit record %q: %v", line, err)
+		}
+
+		if r.Time.IsZero() || r.DurationSeconds < 0 {
+			t.Errorf("expected record %d to have its time and duration set, got %#v", i, r)
+		}
+		if r.Code != codes.OK.String() && len(r.Error) == 0 {
+			t.Errorf("expected failed record %d to have an error, got %#v", i, r)
+		}
+
+		r.Time = time.Time{}
+		r.DurationSeconds = 0
+		r.Error = ""
+		if r != expected[i] {
+			t.Errorf("expected audit record %#v, got %#v", expected[i], r)
+		}
+	}
+}
+
+func TestHandlersRejectInvalidVolumeID(t *testing.T) {
+	t.Parallel()
+
+	d := newTestDriver(t)
+	ctx := context.Background()
+
+	const volumeID = "../volume"
+
+	_, err := d.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: volumeID})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected %v code from DeleteVolume, got %v", codes.InvalidArgument, err)
+	}
+
+	_, err = d.ControllerExpandVolume(ctx, &csi.ControllerExpandVolumeRequest{
+		VolumeId:      volumeID,
+		CapacityRange: &csi.CapacityRange{RequiredBytes: 1024},
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected %v code from ControllerExpandVolume, got %v", codes.InvalidArgument, err)
+	}
+
+	_, err = d.NodeGetVolumeStats(ctx, &csi.NodeGetVolumeStatsRequest{VolumeId: volumeID, VolumePath: t.TempDir()})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected %v code from NodeGetVolumeStats, got %v", codes.InvalidArgument, err)
+	}
+}
+
+func TestControllerExpandVolume(t *testing.T) {
+	t.Parallel()
+
+	const initialSize = 1024
+
+	tt := []struct {
+		name          string
+		volumeID      func(existingID string) string
+		block         bool
+		requiredBytes int64
+		expectedCode  codes.Code
+		expectedSize  int64
+	}{
+		{
+			name:          "expands volume",
+			requiredBytes: 2 * initialSize,
+			expectedCode:  codes.OK,
+			expectedSize:  2 * initialSize,
+		},
+		{
+			name:          "expansion to the same size is idempotent",
+			requiredBytes: initialSize,
+			expectedCode:  codes.OK,
+			expectedSize:  initialSize,
+		},
+		{
+			name:          "rejects shrinking",
+			requiredBytes: initialSize / 2,
+			expectedCode:  codes.FailedPrecondition,
+			expectedSize:  initialSize,
+		},
+		{
+			name:          "rejects expansion beyond available capacity",
+			requiredBytes: math.MaxInt64,
+			expectedCode:  codes.OutOfRange,
+			expectedSize:  initialSize,
+		},
+		{
+			name: "unknown volume",
+			volumeID: func(string) string {
+				return "unknown"
+			},
+			requiredBytes: 2 * initialSize,
+			expectedCode:  codes.NotFound,
+			expectedSize:  initialSize,
+		},
+		{
+			name:          "rejects expansion of block volume",
+			block:         true,
+			requiredBytes: 2 * initialSize,
+			expectedCode:  codes.FailedPrecondition,
+			expectedSize:  initialSize,
+		},
+	}
+
+	for i := range tt {
+		tc := tt[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			d := newTestDriver(t)
+			ctx := context.Background()
+
+			req := newCreateVolumeRequest("volume", initialSize)
+			if tc.block {
+				req.VolumeCapabilities[0].AccessType = &csi.VolumeCapability_Block{
+					Block: &csi.VolumeCapability_BlockVolume{},
+				}
+			}
+			resp, err := d.CreateVolume(ctx, req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			volumeID := resp.Volume.VolumeId
+
+			requestVolumeID := volumeID
+			if tc.volumeID != nil {
+				requestVolumeID = tc.volumeID(volumeID)
+			}
+
+			totalSizeBefore := d.volumeManager.GetTotalVolumesSize()
+
+			expandResp, err := d.ControllerExpandVolume(ctx, &csi.ControllerExpandVolumeRequest{
+				VolumeId: requestVolumeID,
+				CapacityRange: &csi.CapacityRange{
+					RequiredBytes: tc.requiredBytes,
+				},
+			})
+			if status.Code(err) != tc.expectedCode {
+				t.Fatalf("expected code %v, got %v", tc.expectedCode, err)
+			}
+
+			if err == nil {
+				if expandResp.CapacityBytes != tc.expectedSize {
+					t.Errorf("expected capacity %d, got %d", tc.expectedSize, expandResp.CapacityBytes)
+				}
+				if expandResp.NodeExpansionRequired {
+					t.Errorf("expected node expansion not to be required")
+				}
+			}
+
+			vs := d.volumeManager.GetVolumeStateByID(volumeID)
+			if vs.Size != tc.expectedSize {
+				t.Errorf("expected persisted size %d, got %d", tc.expectedSize, vs.Size)
+			}
+
+			expectedTotalSize := totalSizeBefore + tc.expectedSize - initialSize
+			if d.volumeManager.GetTotalVolumesSize() != expectedTotalSize {
+				t.Errorf("expected total volumes size %d, got %d", expectedTotalSize, d.volumeManager.GetTotalVolumesSize())
+			}
+
+			// Loop device of a block volume is as big as its backing file.
+			if tc.block {
+				fi, err := os.Stat(filepath.Join(d.volumeManager.VolumesDir(), volumeID, "block"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if fi.Size() != tc.expectedSize {
+					t.Errorf("expected backing file of %d bytes, got %d", tc.expectedSize, fi.Size())
+				}
+			}
+		})
+	}
+}
+
+func TestCreateBlockVolume(t *testing.T) {
+	t.Parallel()
+
+	d := newTestDriver(t)
+	ctx := context.Background()
+
+	req := newCreateVolumeRequest("volume", 1024)
+	req.VolumeCapabilities[0].AccessType = &csi.VolumeCapability_Block{
+		Block: &csi.VolumeCapability_BlockVolume{},
+	}
+
+	resp, err := d.CreateVolume(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vs := d.volumeManager.GetVolumeStateByID(resp.Volume.VolumeId)
+	if vs == nil {
+		t.Fatalf("expected volume %q state to exist", resp.Volume.VolumeId)
+	}
+
+	if vs.AccessType != volume.BlockAccess {
+		t.Errorf("expected block access type, got %v", vs.AccessType)
+	}
+
+	_, err = d.CreateVolume(ctx, newCreateVolumeRequest("volume", 1024))
+	if status.Code(err) != codes.AlreadyExists {
+		t.Errorf("expected %v code creating mount volume with name of block volume, got %v", codes.AlreadyExists, err)
+	}
+}
+
+func TestCreateVolumeAccessibilityRequirements(t *testing.T) {
+	t.Parallel()
+
+	nodeTopology := func(nodeName string) *csi.Topology {
+		return &csi.Topology{
+			Segments: map[string]string{
+				NodeNameTopologyKey: nodeName,
+			},
+		}
+	}
+
+	tt := []struct {
+		name         string
+		requirements *csi.TopologyRequirement
+		expectedCode codes.Code
+	}{
+		{
+			name:         "no requirements",
+			requirements: nil,
+			expectedCode: codes.OK,
+		},
+		{
+			name: "requisite matching node",
+			requirements: &csi.TopologyRequirement{
+				Requisite: []*csi.Topology{nodeTopology("node-name")},
+			},
+			expectedCode: codes.OK,
+		},
+		{
+			name: "node is not the first requisite topology",
+			requirements: &csi.TopologyRequirement{
+				Requisite: []*csi.Topology{nodeTopology("other-node"), nodeTopology("node-name")},
+				Preferred: []*csi.Topology{nodeTopology("other-node"), nodeTopology("node-name")},
+			},
+			expectedCode: codes.OK,
+		},
+		{
+			name: "requisite targeting other node",
+			requirements: &csi.TopologyRequirement{
+				Requisite: []*csi.Topology{nodeTopology("other-node")},
+			},
+			expectedCode: codes.ResourceExhausted,
+		},
+		{
+			name: "requisite with unknown segments",
+			requirements: &csi.TopologyRequirement{
+				Requisite: []*csi.Topology{
+					{
+						Segments: map[string]string{
+							NodeNameTopologyKey:           "node-name",
+							"topology.kubernetes.io/zone": "a",
+						},
+					},
+				},
+			},
+			expectedCode: codes.ResourceExhausted,
+		},
+	}
+
+	for i := range tt {
+		tc := tt[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			d := newTestDriver(t)
+
+			// Capacity which can't be satisfied makes sure topology mismatch fails before capacity is checked.
+			capacity := int64(1024)
+			if tc.expectedCode != codes.OK {
+				capacity = math.MaxInt64
+			}
+
+			req := newCreateVolumeRequest("volume", capacity)
+			req.AccessibilityRequirements = tc.requirements
+
+			_, err := d.CreateVolume(context.Background(), req)
+			if status.Code(err) != tc.expectedCode {
+				t.Errorf("expected %v code, got %v", tc.expectedCode, err)
+			}
+		})
+	}
+}
+
+func TestCreateVolumeRejectsLoopBackedBlockVolume(t *testing.T) {
+	t.Parallel()
+
+	d := newTestDriver(t)
+
+	req := newCreateVolumeRequest("volume", 1024)
+	req.VolumeCapabilities[0].AccessType = &csi.VolumeCapability_Block{
+		Block: &csi.VolumeCapability_BlockVolume{},
+	}
+	req.Parameters = map[string]string{
+		BackingModeParameterKey: string(volume.LoopBacking),
+	}
+
+	_, err := d.CreateVolume(context.Background(), req)
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected %v code, got %v", codes.InvalidArgument, err)
+	}
+}
+
+func TestCreateVolumeRejectsInvalidEncryptedVolume(t *testing.T) {
+	t.Parallel()
+
+	tt := []struct {
+		name        string
+		secrets     map[string]string
+		blockAccess bool
+	}{
+		{
+			name: "missing encryption key",
+		},
+		{
+			name: "too short encryption key",
+			secrets: map[string]string{
+				EncryptionKeySecretKey: strings.Repeat("k", 32),
+			},
+		},
+		{
+			name: "block volume",
+			secrets: map[string]string{
+				EncryptionKeySecretKey: strings.Repeat("k", 64),
+			},
+			blockAccess: true,
+		},
+	}
+
+	for i := range tt {
+		tc := tt[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			d := newTestDriver(t)
+
+			req := newCreateVolumeRequest("volume", 1024)
+			req.Parameters = map[string]string{
+				EncryptedParameterKey: "true",
+			}
+			req.Secrets = tc.secrets
+			if tc.blockAccess {
+				req.VolumeCapabilities[0].AccessType = &csi.VolumeCapability_Block{
+					Block: &csi.VolumeCapability_BlockVolume{},
+				}
+			}
+
+			_, err := d.CreateVolume(context.Background(), req)
+			if status.Code(err) != codes.InvalidArgument {
+				t.Errorf("expected %v code, got %v", codes.InvalidArgument, err)
+			}
+		})
+	}
+}
+
+func TestCreateVolumeMatchesVolumesDirFilesystem(t *testing.T) {
+	t.Parallel()
+
+	tt := []struct {
+		name             string
+		volumesDirFsType string
+		fsType           string
+		expectedCode     codes.Code
+		expectedFsType   string
+	}{
+		{
+			name:             "matching fsType on xfs",
+			volumesDirFsType: "xfs",
+			fsType:           "xfs",
+			expectedCode:     codes.OK,
+			expectedFsType:   "xfs",
+		},
+		{
+			name:             "default fsType resolves to volumes dir filesystem",
+			volumesDirFsType: "ext4",
+			fsType:           "",
+			expectedCode:     codes.OK,
+			expectedFsType:   "ext4",
+		},
+		{
+			name:             "xfs requested on ext4",
+			volumesDirFsType: "ext4",
+			fsType:           "xfs",
+			expectedCode:     codes.ResourceExhausted,
+		},
+		{
+			name:             "ext4 requested on xfs",
+			volumesDirFsType: "xfs",
+			fsType:           "ext4",
+			expectedCode:     codes.ResourceExhausted,
+		},
+	}
+
+	for i := range tt {
+		tc := tt[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			d := newTestDriverWithOptions(t, volume.WithVolumesDirFilesystem(tc.volumesDirFsType), volume.WithRejectMismatchingFsType(true))
+
+			req := newCreateVolumeRequest("volume", 1024*1024)
+			req.VolumeCapabilities[0].GetMount().FsType = tc.fsType
+
+			resp, err := d.CreateVolume(context.Background(), req)
+			if status.Code(err) != tc.expectedCode {
+				t.Fatalf("expected %v code, got %v", tc.expectedCode, err)
+			}
+
+			if tc.expectedCode != codes.OK {
+				if d.volumeManager.GetVolumeStateByName("volume") != nil {
+					t.Errorf("expected no volume to be created")
+				}
+				return
+			}
+
+			vs := d.volumeManager.GetVolumeStateByID(resp.Volume.VolumeId)
+			if vs.FsType != tc.expectedFsType {
+				t.Errorf("expected volume with %q filesystem, got %q", tc.expectedFsType, vs.FsType)
+			}
+		})
+	}
+}
+
+func TestCreateVolumeSelectsVolumesDirOfRequestedFilesystem(t *testing.T) {
+	t.Parallel()
+
+	const blockSize = 4096
+
+	tt := []struct {
+		name           string
+		fsType         string
+		capacity       int64
+		expectedCode   codes.Code
+		expectedFsType string
+	}{
+		{
+			name:           "xfs volume lands in the xfs volumes dir",
+			fsType:         "xfs",
+			capacity:       blockSize,
+			expectedCode:   codes.OK,
+			expectedFsType: "xfs",
+		},
+		{
+			name:           "ext4 volume lands in the ext4 volumes dir",
+			fsType:         "ext4",
+			capacity:       blockSize,
+			expectedCode:   codes.OK,
+			expectedFsType: "ext4",
+		},
+		{
+			name:         "xfs volume fitting only the ext4 volumes dir exhausts resources",
+			fsType:       "xfs",
+			capacity:     1500 * blockSize,
+			expectedCode: codes.ResourceExhausted,
+		},
+	}
+
+	for i := range tt {
+		tc := tt[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			// The xfs volumes dir has less capacity available than the ext4 one.
+			additionalVolumesDir := t.TempDir()
+			statfs := func(path string, buf *unix.Statfs_t) error {
+				blocks := uint64(1000)
+				if path == additionalVolumesDir || filepath.Dir(path) == additionalVolumesDir {
+					blocks = 2000
+				}
+				*buf = unix.Statfs_t{Bsize: blockSize, Blocks: blocks, Bfree: blocks, Bavail: blocks}
+				return nil
+			}
+
+			d := newTestDriverWithOptions(t,
+				volume.WithVolumesDirFilesystem("xfs"),
+				volume.WithAdditionalVolumesDirs(volume.AdditionalVolumesDir{
+					Path:   additionalVolumesDir,
+					FsType: "ext4",
+				}),
+				volume.WithStatfs(statfs),
+			)
+
+			req := newCreateVolumeRequest("volume", tc.capacity)
+			req.VolumeCapabilities[0].GetMount().FsType = tc.fsType
+
+			resp, err := d.CreateVolume(context.Background(), req)
+			if status.Code(err) != tc.expectedCode {
+				t.Fatalf("expected %v code, got %v", tc.expectedCode, err)
+			}
+
+			if tc.expectedCode != codes.OK {
+				if d.volumeManager.GetVolumeStateByName("volume") != nil {
+					t.Errorf("expected no volume to be created")
+				}
+				return
+			}
+
+			vs := d.volumeManager.GetVolumeStateByID(resp.Volume.VolumeId)
+			if vs.FsType != tc.expectedFsType {
+				t.Errorf("expected volume with %q filesystem, got %q", tc.expectedFsType, vs.FsType)
+			}
+		})
+	}
+}
+
+func TestControllerGetVolume(t *testing.T) {
+	t.Parallel()
+
+	tt := []struct {
+		name             string
+		volumeID         func(t *testing.T, d *driver) string
+		expectedCode     codes.Code
+		expectedAbnormal bool
+	}{
+		{
+			name: "existing volume",
+			volumeID: func(t *testing.T, d *driver) string {
+				resp, err := d.CreateVolume(context.Background(), newCreateVolumeRequest("volume", 1024))
+				if err != nil {
+					t.Fatal(err)
+				}
+				return resp.Volume.VolumeId
+			},
+			expectedCode:     codes.OK,
+			expectedAbnormal: false,
+		},
+		{
+			name: "volume without state",
+			volumeID: func(t *testing.T, d *driver) string {
+				return "00000000-0000-0000-0000-000000000000"
+			},
+			expectedCode: codes.NotFound,
+		},
+		{
+			name: "volume with missing directory",
+			volumeID: func(t *testing.T, d *driver) string {
+				resp, err := d.CreateVolume(context.Background(), newCreateVolumeRequest("volume", 1024))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				err = os.RemoveAll(filepath.Join(d.volumeManager.VolumesDir(), resp.Volume.VolumeId))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				return resp.Volume.VolumeId
+			},
+			expectedCode:     codes.OK,
+			expectedAbnormal: true,
+		},
+	}
+
+	for i := range tt {
+		tc := tt[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			d := newTestDriver(t)
+			volumeID := tc.volumeID(t, d)
+
+			resp, err := d.ControllerGetVolume(context.Background(), &csi.ControllerGetVolumeRequest{
+				VolumeId: volumeID,
+			})
+			if status.Code(err) != tc.expectedCode {
+				t.Fatalf("expected %v code, got %v", tc.expectedCode, err)
+			}
+
+			if tc.expectedCode != codes.OK {
+				return
+			}
+
+			if resp.Volume.VolumeId != volumeID {
+				t.Errorf("expected volume ID %q, got %q", volumeID, resp.Volume.VolumeId)
+			}
+
+			if resp.Volume.CapacityBytes != 1024 {
+				t.Errorf("expected capacity of %d bytes, got %d", 1024, resp.Volume.CapacityBytes)
+			}
+
+			condition := resp.GetStatus().GetVolumeCondition()
+			if condition.GetAbnormal() != tc.expectedAbnormal {
+				t.Errorf("expected abnormal condition to be %v, got %v: %q", tc.expectedAbnormal, condition.GetAbnormal(), condition.GetMessage())
+			}
+		})
+	}
+}
+
+func TestCreateSnapshot(t *testing.T) {
+	t.Parallel()
+
+	d := newTestDriver(t)
+	ctx := context.Background()
+
+	resp, err := d.CreateVolume(ctx, newCreateVolumeRequest("volume", 1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+	volumeID := resp.Volume.VolumeId
+
+	err = os.WriteFile(filepath.Join(d.volumeManager.VolumesDir(), volumeID, "data"), []byte("data"), 0660)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	snapshotResp, err := d.CreateSnapshot(ctx, &csi.CreateSnapshotRequest{
+		Name:           "snapshot",
+		SourceVolumeId: volumeID,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	snapshot := snapshotResp.GetSnapshot()
+	if !snapshot.GetReadyToUse() {
+		t.Errorf("expected snapshot to be ready to use")
+	}
+	if snapshot.GetSizeBytes() != 1024 {
+		t.Errorf("expected snapshot size of %d bytes, got %d", 1024, snapshot.GetSizeBytes())
+	}
+	if snapshot.GetSourceVolumeId() != volumeID {
+		t.Errorf("expected source volume %q, got %q", volumeID, snapshot.GetSourceVolumeId())
+	}
+
+	snapshotPath := filepath.Join(d.volumeManager.VolumesDir(), volume.SnapshotsDirName, snapshot.GetSnapshotId())
+	data, err := os.ReadFile(filepath.Join(snapshotPath, "data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "data" {
+		t.Errorf("expected snapshot to hold %q, got %q", "data", data)
+	}
+
+	repeatedResp, err := d.CreateSnapshot(ctx, &csi.CreateSnapshotRequest{
+		Name:           "snapshot",
+		SourceVolumeId: volumeID,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if repeatedResp.GetSnapshot().GetSnapshotId() != snapshot.GetSnapshotId() {
+		t.Errorf("expected repeated request to return snapshot %q, got %q", snapshot.GetSnapshotId(), repeatedResp.GetSnapshot().GetSnapshotId())
+	}
+
+	otherResp, err := d.CreateVolume(ctx, newCreateVolumeRequest("other", 1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = d.CreateSnapshot(ctx, &csi.CreateSnapshotRequest{
+		Name:           "snapshot",
+		SourceVolumeId: otherResp.Volume.VolumeId,
+	})
+	if status.Code(err) != codes.AlreadyExists {
+		t.Errorf("expected %v code for snapshot of another volume, got %v", codes.AlreadyExists, err)
+	}
+
+	_, err = d.CreateSnapshot(ctx, &csi.CreateSnapshotRequest{
+		Name:           "missing",
+		SourceVolumeId: "00000000-0000-0000-0000-000000000000",
+	})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("expected %v code for snapshot of missing volume, got %v", codes.NotFound, err)
+	}
+
+	// Deletion is idempotent.
+	for range 2 {
+		_, err = d.DeleteSnapshot(ctx, &csi.DeleteSnapshotRequest{SnapshotId: snapshot.GetSnapshotId()})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err = os.Stat(snapshotPath)
+	if !os.IsNotExist(err) {
+		t.Errorf("expected snapshot directory to be removed, got %v", err)
+	}
+
+	_, err = d.DeleteSnapshot(ctx, &csi.DeleteSnapshotRequest{SnapshotId: "../snapshot"})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected %v code for invalid snapshot ID, got %v", codes.InvalidArgument, err)
+	}
+}
+
+func TestCreateVolumeFromSnapshot(t *testing.T) {
+	t.Parallel()
+
+	d := newTestDriver(t)
+	ctx := context.Background()
+
+	resp, err := d.CreateVolume(ctx, newCreateVolumeRequest("volume", 1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.WriteFile(filepath.Join(d.volumeManager.VolumesDir(), resp.Volume.VolumeId, "data"), []byte("data"), 0660)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	snapshotResp, err := d.CreateSnapshot(ctx, &csi.CreateSnapshotRequest{
+		Name:           "snapshot",
+		SourceVolumeId: resp.Volume.VolumeId,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newRequest := func(name string, capacity int64, snapshotID string) *csi.CreateVolumeRequest {
+		req := newCreateVolumeRequest(name, capacity)
+		req.VolumeContentSource = &csi.VolumeContentSource{
+			Type: &csi.VolumeContentSource_Snapshot{
+				Snapshot: &csi.VolumeContentSource_SnapshotSource{
+					SnapshotId: snapshotID,
+				},
+			},
+		}
+		return req
+	}
+
+	_, err = d.CreateVolume(ctx, newRequest("missing", 1024, "00000000-0000-0000-0000-000000000000"))
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("expected %v code for missing snapshot, got %v", codes.NotFound, err)
+	}
+
+	_, err = d.CreateVolume(ctx, newRequest("small", 512, snapshotResp.Snapshot.SnapshotId))
+	if status.Code(err) != codes.OutOfRange {
+		t.Errorf("expected %v code for volume smaller than snapshot, got %v", codes.OutOfRange, err)
+	}
+
+	restoredResp, err := d.CreateVolume(ctx, newRequest("restored", 0, snapshotResp.Snapshot.SnapshotId))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if restoredResp.Volume.CapacityBytes != 1024 {
+		t.Errorf("expected volume of snapshot size %d, got %d", 1024, restoredResp.Volume.CapacityBytes)
+	}
+
+	data, err := os.ReadFile(filepath.Join(d.volumeManager.VolumesDir(), restoredResp.Volume.VolumeId, "data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "data" {
+		t.Errorf("expected restored volume to hold %q, got %q", "data", data)
+	}
+
+	_, err = d.CreateVolume(ctx, newCreateVolumeRequest("restored", 1024))
+	if status.Code(err) != codes.AlreadyExists {
+		t.Errorf("expected %v code for volume of the same name without content source, got %v", codes.AlreadyExists, err)
+	}
+}
+
+func TestDeleteSnapshotWaitsForRestoreToFinish(t *testing.T) {
+	t.Parallel()
+
+	d := newTestDriver(t)
+	ctx := context.Background()
+
+	resp, err := d.CreateVolume(ctx, newCreateVolumeRequest("volume", 1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	snapshotResp, err := d.CreateSnapshot(ctx, &csi.CreateSnapshotRequest{
+		Name:           "snapshot",
+		SourceVolumeId: resp.Volume.VolumeId,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshotID := snapshotResp.Snapshot.SnapshotId
+
+	// Restore holds the lock of the snapshot until the volume is populated.
+	d.snapshotIDLocks.LockKey(snapshotID)
+
+	deleted := make(chan error, 1)
+	go func() {
+		_, err := d.DeleteSnapshot(ctx, &csi.DeleteSnapshotRequest{SnapshotId: snapshotID})
+		deleted <- err
+	}()
+
+	select {
+	case err := <-deleted:
+		t.Fatalf("expected snapshot deletion to wait for the restore, got %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	err = d.snapshotIDLocks.UnlockKey(snapshotID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = <-deleted
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := newCreateVolumeRequest("restored", 1024)
+	req.VolumeContentSource = &csi.VolumeContentSource{
+		Type: &csi.VolumeContentSource_Snapshot{
+			Snapshot: &csi.VolumeContentSource_SnapshotSource{
+				SnapshotId: snapshotID,
+			},
+		},
+	}
+	_, err = d.CreateVolume(ctx, req)
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("expected %v code restoring deleted snapshot, got %v", codes.NotFound, err)
+	}
+}
+
+func TestCreateVolumeFromVolume(t *testing.T) {
+	t.Parallel()
+
+	d := newTestDriver(t)
+	ctx := context.Background()
+
+	resp, err := d.CreateVolume(ctx, newCreateVolumeRequest("source", 1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.WriteFile(filepath.Join(d.volumeManager.VolumesDir(), resp.Volume.VolumeId, "data"), []byte("data"), 0660)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newRequest := func(name string, capacity int64, sourceVolumeID string) *csi.CreateVolumeRequest {
+		req := newCreateVolumeRequest(name, capacity)
+		req.VolumeContentSource = &csi.VolumeContentSource{
+			Type: &csi.VolumeContentSource_Volume{
+				Volume: &csi.VolumeContentSource_VolumeSource{
+					VolumeId: sourceVolumeID,
+				},
+			},
+		}
+		return req
+	}
+
+	_, err = d.CreateVolume(ctx, newRequest("missing", 1024, "00000000-0000-0000-0000-000000000000"))
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("expected %v code for missing source volume, got %v", codes.NotFound, err)
+	}
+
+	_, err = d.CreateVolume(ctx, newRequest("small", 512, resp.Volume.VolumeId))
+	if status.Code(err) != codes.OutOfRange {
+		t.Errorf("expected %v code for volume smaller than source volume, got %v", codes.OutOfRange, err)
+	}
+
+	cloneResp, err := d.CreateVolume(ctx, newRequest("clone", 0, resp.Volume.VolumeId))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cloneResp.Volume.CapacityBytes != 1024 {
+		t.Errorf("expected volume of source volume size %d, got %d", 1024, cloneResp.Volume.CapacityBytes)
+	}
+
+	if cloneResp.Volume.ContentSource.GetVolume().GetVolumeId() != resp.Volume.VolumeId {
+		t.Errorf("expected content source of volume %q, got %v", resp.Volume.VolumeId, cloneResp.Volume.ContentSource)
+	}
+
+	data, err := os.ReadFile(filepath.Join(d.volumeManager.VolumesDir(), cloneResp.Volume.VolumeId, "data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "data" {
+		t.Errorf("expected cloned volume to hold %q, got %q", "data", data)
+	}
+
+	_, err = d.CreateVolume(ctx, newCreateVolumeRequest("clone", 1024))
+	if status.Code(err) != codes.AlreadyExists {
+		t.Errorf("expected %v code for volume of the same name without content source, got %v", codes.AlreadyExists, err)
+	}
+}
@@ -4,12 +4,24 @@ package driver
 
 import (
 	"fmt"
+	"maps"
+	"strconv"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/scylladb/local-csi-driver/pkg/driver/audit"
+	"github.com/scylladb/local-csi-driver/pkg/driver/policy"
 	"github.com/scylladb/local-csi-driver/pkg/driver/volume"
+	"github.com/scylladb/local-csi-driver/pkg/util/fs"
 	"github.com/scylladb/local-csi-driver/pkg/util/slices"
+	"google.golang.org/grpc/codes"
+	"k8s.io/apimachinery/pkg/api/resource"
 	"k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/keymutex"
 )
 
 type driver struct {
@@ -22,6 +34,182 @@ type driver struct {
 	nodeName      string
 	volumeManager *volume.VolumeManager
 	mut           sync.Mutex
+
+	// volumeNameLocks serializes operations on volumes having the same name.
+	volumeNameLocks keymutex.KeyMutex
+	// snapshotNameLocks serializes operations on snapshots having the same name.
+	snapshotNameLocks keymutex.KeyMutex
+	// volumeIDLocks serializes deletion of volumes with cloning them. Volume being created already holds a lock
+	// of volumeNameLocks, taking another one of the same hashed mutex for the source volume could deadlock.
+	volumeIDLocks keymutex.KeyMutex
+	// snapshotIDLocks serializes deletion of snapshots with restoring them, for the same reason volumeIDLocks is
+	// separate from volumeNameLocks.
+	snapshotIDLocks keymutex.KeyMutex
+
+	// publishedTargets prevents publishing single writer volumes to more than one target path.
+	publishedTargets *publishedTargets
+
+	maxSyncedVolumes int
+	syncer           *volumeSyncer
+
+	// maxVolumesPerNode bounds how many volumes can be provisioned on the node.
+	maxVolumesPerNode int64
+	// capacityDegradationThresholdPercent is the share of maxVolumesPerNode past which reported capacity is reduced,
+	// zero disables the reduction.
+	capacityDegradationThresholdPercent int
+
+	probeCacheTTL time.Duration
+	prober        *cachedProber
+	metrics       *driverMetrics
+
+	// topologyDisabled makes volumes accessible regardless of topology, which is only safe on single node clusters.
+	topologyDisabled bool
+	// topologySegments are published in the topology of the node, and of volumes created on it, next to the node name.
+	topologySegments map[string]string
+
+	// volumeIOStatsEnabled makes IO statistics of volumes exposed through loop devices logged and exported.
+	volumeIOStatsEnabled bool
+
+	// volumeMountGroupEnabled advertises VOLUME_MOUNT_GROUP, so kubelet delegates applying fsGroup to the driver.
+	volumeMountGroupEnabled bool
+
+	// auditLogger records volume lifecycle operations, it's nil when auditing is disabled.
+	auditLogger *audit.Logger
+
+	// provisioningPolicy decides whether new volumes can be provisioned.
+	provisioningPolicy policy.Policy
+
+	// adminToken authenticates mutating admin endpoints, they're disabled when it's empty.
+	adminToken string
+	// extentStatsMut bounds collection of costly volume extent statistics to a single request at a time.
+	extentStatsMut sync.Mutex
+
+	// suspectedLeaks holds the result of the last leak detection, it's nil until the detection runs.
+	suspectedLeaks    []volume.SuspectedLeak
+	suspectedLeaksMut sync.Mutex
+
+	// unrestoredQuotaPolicy selects how volumes whose limits couldn't be restored on startup are published.
+	unrestoredQuotaPolicy UnrestoredQuotaPolicy
+
+	// nonEmptyVolumeDirectoryCode is returned when a new volume's directory already exists with unknown data.
+	nonEmptyVolumeDirectoryCode codes.Code
+
+	// ready is false until startup completes, mutating RPCs are rejected meanwhile.
+	ready atomic.Bool
+}
+
+type DriverOption func(d *driver)
+
+// UnrestoredQuotaPolicy selects how volumes whose limits couldn't be restored on startup are published.
+type UnrestoredQuotaPolicy string
+
+const (
+	// StrictUnrestoredQuotaPolicy refuses publishing volumes which wouldn't be enforced.
+	StrictUnrestoredQuotaPolicy UnrestoredQuotaPolicy = "strict"
+	// PermissiveUnrestoredQuotaPolicy publishes them anyway, warning about every such publish.
+	PermissiveUnrestoredQuotaPolicy UnrestoredQuotaPolicy = "permissive"
+)
+
+// WithUnrestoredQuotaPolicy sets how volumes whose limits couldn't be restored on startup are published.
+func WithUnrestoredQuotaPolicy(policy UnrestoredQuotaPolicy) func(*driver) {
+	return func(d *driver) {
+		d.unrestoredQuotaPolicy = policy
+	}
+}
+
+// WithProbeCacheTTL sets for how long the result of a volumes dir health probe is reused.
+func WithProbeCacheTTL(ttl time.Duration) func(*driver) {
+	return func(d *driver) {
+		d.probeCacheTTL = ttl
+	}
+}
+
+// WithNonEmptyVolumeDirectoryCode sets the code returned when a new volume's directory already exists
+// with unknown data in it.
+func WithNonEmptyVolumeDirectoryCode(code codes.Code) func(*driver) {
+	return func(d *driver) {
+		d.nonEmptyVolumeDirectoryCode = code
+	}
+}
+
+// WithMaxSyncedVolumes bounds how many published volumes can be periodically synced at once.
+func WithMaxSyncedVolumes(n int) func(*driver) {
+	return func(d *driver) {
+		d.maxSyncedVolumes = n
+	}
+}
+
+// WithMaxVolumesPerNode bounds how many volumes can be provisioned on the node. It's reported in NodeGetInfo.
+func WithMaxVolumesPerNode(n int64) func(*driver) {
+	return func(d *driver) {
+		d.maxVolumesPerNode = n
+	}
+}
+
+// WithCapacityDegradationThresholdPercent makes reported capacity reduced once the number of volumes exceeds
+// the percentage of max volumes per node.
+func WithCapacityDegradationThresholdPercent(percent int) func(*driver) {
+	return func(d *driver) {
+		d.capacityDegradationThresholdPercent = percent
+	}
+}
+
+// WithReadinessGate makes the driver start as not ready, rejecting mutating RPCs with Unavailable code
+// until MarkReady is called.
+func WithReadinessGate() func(*driver) {
+	return func(d *driver) {
+		d.ready.Store(false)
+	}
+}
+
+// WithVolumeIOStats enables reporting IO statistics of volumes which have their own loop device.
+func WithVolumeIOStats(enabled bool) func(*driver) {
+	return func(d *driver) {
+		d.volumeIOStatsEnabled = enabled
+	}
+}
+
+// WithVolumeMountGroup makes kubelet delegate applying fsGroup of pods to the driver.
+func WithVolumeMountGroup(enabled bool) func(*driver) {
+	return func(d *driver) {
+		d.volumeMountGroupEnabled = enabled
+	}
+}
+
+// WithTopologyDisabled stops constraining volumes to the topology of the node they were created on.
+func WithTopologyDisabled(disabled bool) func(*driver) {
+	return func(d *driver) {
+		d.topologyDisabled = disabled
+	}
+}
+
+// WithTopologySegments adds the segments, e.g. zone and region of the node, to the topology of the node and volumes
+// created on it. They have to be valid, see ValidateTopologySegments.
+func WithTopologySegments(segments map[string]string) func(*driver) {
+	return func(d *driver) {
+		d.topologySegments = segments
+	}
+}
+
+// WithAuditLogger makes volume creations, deletions, mounts and unmounts recorded by the audit logger.
+func WithAuditLogger(l *audit.Logger) func(*driver) {
+	return func(d *driver) {
+		d.auditLogger = l
+	}
+}
+
+// WithProvisioningPolicy makes volumes admitted by the policy before they're provisioned.
+func WithProvisioningPolicy(p policy.Policy) func(*driver) {
+	return func(d *driver) {
+		d.provisioningPolicy = p
+	}
+}
+
+// WithAdminToken enables mutating admin endpoints, like forced volume deletion, for requests bearing the token.
+func WithAdminToken(token string) func(*driver) {
+	return func(d *driver) {
+		d.adminToken = token
+	}
 }
 
 var _ csi.IdentityServer = &driver{}
@@ -30,34 +218,204 @@ var _ csi.ControllerServer = &driver{}
 
 const (
 	NodeNameTopologyKey = "local.csi.scylladb.com/node"
+
+	// StorageClassNameParameterKey allows StorageClasses to attribute provisioned volumes to themselves.
+	StorageClassNameParameterKey = "storageClassName"
+
+	// Keys of parameters passed by external-provisioner when run with --extra-create-metadata.
+	PVCNameParameterKey      = "csi.storage.k8s.io/pvc/name"
+	PVCNamespaceParameterKey = "csi.storage.k8s.io/pvc/namespace"
+	PVNameParameterKey       = "csi.storage.k8s.io/pv/name"
+
+	// InodeLimitParameterKey sets the maximum number of inodes a volume can use.
+	InodeLimitParameterKey = "inodeLimit"
+
+	// SyncIntervalParameterKey makes filesystems of published volumes periodically synced at the given interval.
+	SyncIntervalParameterKey = "local.csi.scylladb.com/syncInterval"
+
+	// VolumeMountGroupParameterKey makes only the root directory of published volumes owned by the pod fsGroup,
+	// instead of all of their content.
+	VolumeMountGroupParameterKey = "local.csi.scylladb.com/volumeMountGroup"
+
+	// BackingModeParameterKey selects how data of mount volumes is stored, volumes are directories by default.
+	BackingModeParameterKey = "backingMode"
+
+	// EncryptedParameterKey makes volume directories encrypted at rest using fscrypt.
+	EncryptedParameterKey = "encrypted"
+
+	// ExtentSizeHintParameterKey sets the XFS extent size hint of volume directories, inherited by files created in them.
+	ExtentSizeHintParameterKey = "extentSizeHint"
+
+	// EncryptionKeySecretKey is the key of the raw encryption key in provisioner and node publish secrets
+	// of encrypted volumes.
+	EncryptionKeySecretKey = "encryptionKey"
+
+	DefaultProbeCacheTTL = 5 * time.Second
+
+	// DefaultMaxVolumesPerNode is how many volumes can be provisioned on a node by default.
+	DefaultMaxVolumesPerNode = 1024
+)
+
+const (
+	// Bounds of extent size hints, XFS caps them well above the upper one.
+	minExtentSizeHint = 4 * 1024
+	maxExtentSizeHint = 1024 * 1024 * 1024
 )
 
 var (
 	volumeCapAccessModes = []csi.VolumeCapability_AccessMode_Mode{
 		csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+		csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY,
+	}
+
+	readOnlyAccessModes = []csi.VolumeCapability_AccessMode_Mode{
+		csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY,
+		csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY,
 	}
 )
 
-func NewDriver(name, version, nodeName string, volumeManager *volume.VolumeManager) *driver {
-	return &driver{
+func NewDriver(name, version, nodeName string, volumeManager *volume.VolumeManager, options ...DriverOption) *driver {
+	d := &driver{
 		name:     name,
 		version:  version,
 		nodeName: nodeName,
 
 		volumeManager: volumeManager,
 		mut:           sync.Mutex{},
+
+		volumeNameLocks:   keymutex.NewHashed(0),
+		snapshotNameLocks: keymutex.NewHashed(0),
+		volumeIDLocks:     keymutex.NewHashed(0),
+		snapshotIDLocks:   keymutex.NewHashed(0),
+		publishedTargets:  newPublishedTargets(),
+		probeCacheTTL:     DefaultProbeCacheTTL,
+		metrics:           newDriverMetrics(volumeManager),
+
+		nonEmptyVolumeDirectoryCode: codes.Internal,
+		maxSyncedVolumes:            DefaultMaxSyncedVolumes,
+		maxVolumesPerNode:           DefaultMaxVolumesPerNode,
+		unrestoredQuotaPolicy:       StrictUnrestoredQuotaPolicy,
+		provisioningPolicy:          policy.NoopPolicy{},
+	}
+
+	d.ready.Store(true)
+
+	for _, option := range options {
+		option(d)
 	}
+
+	if d.volumeIOStatsEnabled {
+		d.metrics.registry.MustRegister(newVolumeIOCollector(volumeManager))
+	}
+
+	volumeManager.SetMountObserver(d.metrics)
+
+	d.prober = newCachedProber(volumeManager.Probe, d.probeCacheTTL)
+	d.syncer = newVolumeSyncer(d.maxSyncedVolumes)
+
+	return d
+}
+
+// exceedsMaxVolumesPerNode returns whether no more volumes can be provisioned on the node.
+func (d *driver) exceedsMaxVolumesPerNode() bool {
+	return int64(len(d.volumeManager.GetVolumes())) >= d.maxVolumesPerNode
+}
+
+// degradeCapacity reduces the capacity once the number of volumes exceeds the degradation threshold, proportionally
+// to the volumes which can still be provisioned, down to zero at max volumes per node. The scheduler then steers
+// new volumes to other nodes before this one runs out of its volumes and their limit IDs.
+func (d *driver) degradeCapacity(capacity int64) int64 {
+	if d.capacityDegradationThresholdPercent == 0 {
+		return capacity
+	}
+
+	threshold := d.maxVolumesPerNode * int64(d.capacityDegradationThresholdPercent) / 100
+	volumes := int64(len(d.volumeManager.GetVolumes()))
+	if volumes <= threshold {
+		return capacity
+	}
+
+	// Threshold at max volumes per node leaves no volumes to degrade the capacity over.
+	degradedVolumes := d.maxVolumesPerNode - threshold
+	if degradedVolumes <= 0 {
+		return 0
+	}
+
+	left := max(0, d.maxVolumesPerNode-volumes)
+	degraded := max(0, int64(float64(capacity)*float64(left)/float64(degradedVolumes)))
+	klog.V(4).InfoS("Reporting degraded capacity", "volumes", volumes, "maxVolumesPerNode", d.maxVolumesPerNode, "capacity", capacity, "degradedCapacity", degraded)
+
+	return degraded
 }
 
 func (d *driver) getNodeAccessibleTopology() *csi.Topology {
+	segments := make(map[string]string, len(d.topologySegments)+1)
+	maps.Copy(segments, d.topologySegments)
+	segments[NodeNameTopologyKey] = d.nodeName
+
 	return &csi.Topology{
-		Segments: map[string]string{
-			NodeNameTopologyKey: d.nodeName,
-		},
+		Segments: segments,
 	}
 }
 
+// ValidateTopologySegments validates additional topology segments, which become labels of the node. Node name segment
+// is always set by the driver, so it can't be overridden.
+func ValidateTopologySegments(segments map[string]string) error {
+	var errs []error
+	for k, v := range segments {
+		if k == NodeNameTopologyKey {
+			errs = append(errs, fmt.Errorf("topology segment %q is reserved for the node name", k))
+			continue
+		}
+
+		for _, msg := range validation.IsQualifiedName(k) {
+			errs = append(errs, fmt.Errorf("invalid topology segment key %q: %s", k, msg))
+		}
+
+		for _, msg := range validation.IsValidLabelValue(v) {
+			errs = append(errs, fmt.Errorf("invalid %q topology segment value %q: %s", k, v, msg))
+		}
+	}
+
+	err := errors.NewAggregate(errs)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// satisfiesAccessibilityRequirements returns whether volumes accessible from this node satisfy the requisite topology.
+// Requisite topologies are alternatives, so it's enough when any of them matches the node, regardless of their order.
+func (d *driver) satisfiesAccessibilityRequirements(requirements *csi.TopologyRequirement) bool {
+	if d.topologyDisabled {
+		return true
+	}
+
+	requisite := requirements.GetRequisite()
+	if len(requisite) == 0 {
+		return true
+	}
+
+	for _, t := range requisite {
+		if d.isNodeTopology(t) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isNodeTopology returns whether the topology is the one volumes accessible from this node have.
+func (d *driver) isNodeTopology(t *csi.Topology) bool {
+	return maps.Equal(t.GetSegments(), d.getNodeAccessibleTopology().GetSegments())
+}
+
 func (d *driver) getVolumeAccessibleTopology() []*csi.Topology {
+	if d.topologyDisabled {
+		return nil
+	}
+
 	return []*csi.Topology{
 		d.getNodeAccessibleTopology(),
 	}
@@ -71,8 +429,8 @@ func (d *driver) validateVolumeCapabilities(volCaps []*csi.VolumeCapability) err
 			errs = append(errs, fmt.Errorf("unsupported access mode %q", volCap.AccessMode.GetMode().String()))
 		}
 
-		if volCap.GetMount() == nil {
-			errs = append(errs, fmt.Errorf("only filesystem volumes are supported"))
+		if volCap.GetMount() == nil && volCap.GetBlock() == nil {
+			errs = append(errs, fmt.Errorf("only filesystem and block volumes are supported"))
 		}
 
 		if volCap.GetMount() != nil && !slices.Contains(d.volumeManager.SupportedFilesystems(), volCap.GetMount().FsType) {
@@ -88,12 +446,264 @@ func (d *driver) validateVolumeCapabilities(volCaps []*csi.VolumeCapability) err
 	return nil
 }
 
-func (d *driver) validateVolumeParameters(parameters map[string]string) error {
+// isReadOnlyAccessMode returns whether the access mode allows only reading from the volume.
+func isReadOnlyAccessMode(mode csi.VolumeCapability_AccessMode_Mode) bool {
+	return slices.Contains(readOnlyAccessModes, mode)
+}
+
+// validateMountFlags rejects mount flags contradicting the requested read-only publishing.
+func validateMountFlags(mountFlags []string, readOnly bool) error {
+	var errs []error
+
+	hasRW := slices.Contains(mountFlags, "rw")
+	if hasRW && slices.Contains(mountFlags, "ro") {
+		errs = append(errs, fmt.Errorf("mount flags can't contain both %q and %q", "ro", "rw"))
+	}
+
+	if hasRW && readOnly {
+		errs = append(errs, fmt.Errorf("mount flag %q conflicts with read-only volume", "rw"))
+	}
+
+	err := errors.NewAggregate(errs)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ValidateVolumeParameters validates parameters of CreateVolume requests, which come from StorageClasses.
+func ValidateVolumeParameters(parameters map[string]string) error {
+	var errs []error
+	for k, v := range parameters {
+		err := ValidateVolumeParameter(k, v)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	err := errors.NewAggregate(errs)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ValidateVolumeParameter validates a single volume parameter.
+func ValidateVolumeParameter(key, value string) error {
 	var errs []error
-	for k := range parameters {
+	switch key {
+	case StorageClassNameParameterKey, PVCNameParameterKey, PVNameParameterKey:
+		for _, msg := range validation.IsDNS1123Subdomain(value) {
+			errs = append(errs, fmt.Errorf("invalid %q volume parameter value %q: %s", key, value, msg))
+		}
+	case PVCNamespaceParameterKey:
+		for _, msg := range validation.IsDNS1123Label(value) {
+			errs = append(errs, fmt.Errorf("invalid %q volume parameter value %q: %s", key, value, msg))
+		}
+	case InodeLimitParameterKey:
+		_, err := parseInodeLimit(value)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	case SyncIntervalParameterKey:
+		_, err := parseSyncInterval(value)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	case VolumeMountGroupParameterKey:
+		_, err := parseVolumeMountGroup(value)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	case BackingModeParameterKey:
+		_, err := parseBackingMode(value)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	case EncryptedParameterKey:
+		_, err := parseEncrypted(value)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	case ExtentSizeHintParameterKey:
+		_, err := parseExtentSizeHint(value)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unsupported volume parameter key: %q", key))
+	}
+
+	err := errors.NewAggregate(errs)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// getInodeLimit returns the inode limit requested in volume parameters, or zero when it's not set.
+func getInodeLimit(parameters map[string]string) (uint64, error) {
+	v, ok := parameters[InodeLimitParameterKey]
+	if !ok {
+		return 0, nil
+	}
+
+	return parseInodeLimit(v)
+}
+
+func parseInodeLimit(v string) (uint64, error) {
+	inodeLimit, err := strconv.ParseUint(v, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %q volume parameter value %q: %w", InodeLimitParameterKey, v, err)
+	}
+
+	if inodeLimit == 0 {
+		return 0, fmt.Errorf("invalid %q volume parameter value %q: must be positive", InodeLimitParameterKey, v)
+	}
+
+	return inodeLimit, nil
+}
+
+// getBackingMode returns the backing mode requested in volume parameters, volumes are directory backed by default.
+func getBackingMode(parameters map[string]string) (volume.BackingMode, error) {
+	v, ok := parameters[BackingModeParameterKey]
+	if !ok {
+		return volume.DirectoryBacking, nil
+	}
+
+	return parseBackingMode(v)
+}
+
+func parseBackingMode(v string) (volume.BackingMode, error) {
+	switch mode := volume.BackingMode(v); mode {
+	case volume.DirectoryBacking, volume.LoopBacking:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("invalid %q volume parameter value %q: must be one of %q", BackingModeParameterKey, v, []volume.BackingMode{volume.DirectoryBacking, volume.LoopBacking})
+	}
+}
+
+// getEncrypted returns whether volume parameters request an encrypted volume, volumes aren't encrypted by default.
+func getEncrypted(parameters map[string]string) (bool, error) {
+	v, ok := parameters[EncryptedParameterKey]
+	if !ok {
+		return false, nil
+	}
+
+	return parseEncrypted(v)
+}
+
+func parseEncrypted(v string) (bool, error) {
+	encrypted, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("invalid %q volume parameter value %q: %w", EncryptedParameterKey, v, err)
+	}
+
+	return encrypted, nil
+}
+
+// getExtentSizeHint returns the extent size hint requested in volume parameters, or zero when it's not set.
+func getExtentSizeHint(parameters map[string]string) (uint32, error) {
+	v, ok := parameters[ExtentSizeHintParameterKey]
+	if !ok {
+		return 0, nil
+	}
+
+	return parseExtentSizeHint(v)
+}
+
+func parseExtentSizeHint(v string) (uint32, error) {
+	q, err := resource.ParseQuantity(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %q volume parameter value %q: %w", ExtentSizeHintParameterKey, v, err)
+	}
+
+	// Hints have to be a multiple of the filesystem block size, hints which aren't are rejected by the kernel
+	// when the volume is created.
+	size := q.Value()
+	if size < minExtentSizeHint || size > maxExtentSizeHint || size&(size-1) != 0 {
+		return 0, fmt.Errorf("invalid %q volume parameter value %q: must be a power of two within [%d, %d] bytes", ExtentSizeHintParameterKey, v, minExtentSizeHint, maxExtentSizeHint)
+	}
+
+	return uint32(size), nil
+}
+
+// getEncryptionKey returns the raw encryption key held in secrets.
+func getEncryptionKey(secrets map[string]string) ([]byte, error) {
+	v, ok := secrets[EncryptionKeySecretKey]
+	if !ok {
+		return nil, fmt.Errorf("%q secret is required for encrypted volumes", EncryptionKeySecretKey)
+	}
+
+	if len(v) != fs.EncryptionKeySize {
+		return nil, fmt.Errorf("%q secret must be %d bytes long, got %d", EncryptionKeySecretKey, fs.EncryptionKeySize, len(v))
+	}
+
+	return []byte(v), nil
+}
+
+// getSyncInterval returns the sync interval set in volume context, or zero when volume isn't periodically synced.
+func getSyncInterval(volumeContext map[string]string) (time.Duration, error) {
+	v, ok := volumeContext[SyncIntervalParameterKey]
+	if !ok {
+		return 0, nil
+	}
+
+	return parseSyncInterval(v)
+}
+
+func parseSyncInterval(v string) (time.Duration, error) {
+	interval, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %q volume parameter value %q: %w", SyncIntervalParameterKey, v, err)
+	}
+
+	if interval <= 0 {
+		return 0, fmt.Errorf("invalid %q volume parameter value %q: must be positive", SyncIntervalParameterKey, v)
+	}
+
+	return interval, nil
+}
+
+// getVolumeMountGroup returns whether only the root directory of the volume is made owned by the pod fsGroup.
+func getVolumeMountGroup(volumeContext map[string]string) (bool, error) {
+	v, ok := volumeContext[VolumeMountGroupParameterKey]
+	if !ok {
+		return false, nil
+	}
+
+	return parseVolumeMountGroup(v)
+}
+
+func parseVolumeMountGroup(v string) (bool, error) {
+	volumeMountGroup, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("invalid %q volume parameter value %q: %w", VolumeMountGroupParameterKey, v, err)
+	}
+
+	return volumeMountGroup, nil
+}
+
+// validateVolumeContext verifies volume context holds only what CreateVolume puts into it.
+func validateVolumeContext(volumeContext map[string]string) error {
+	var errs []error
+	for k, v := range volumeContext {
 		switch k {
+		case SyncIntervalParameterKey:
+			_, err := parseSyncInterval(v)
+			if err != nil {
+				errs = append(errs, err)
+			}
+		case VolumeMountGroupParameterKey:
+			_, err := parseVolumeMountGroup(v)
+			if err != nil {
+				errs = append(errs, err)
+			}
 		default:
-			errs = append(errs, fmt.Errorf("unsupported volume parameter key: %q", k))
+			errs = append(errs, fmt.Errorf("unsupported volume context key: %q", k))
 		}
 	}
 
@@ -104,3 +714,34 @@ func (d *driver) validateVolumeParameters(parameters map[string]string) error {
 
 	return nil
 }
+
+// getVolumeContext returns parameters which are needed when the volume is published.
+func getVolumeContext(parameters map[string]string) map[string]string {
+	var volumeContext map[string]string
+	for _, k := range []string{SyncIntervalParameterKey, VolumeMountGroupParameterKey} {
+		v, ok := parameters[k]
+		if !ok {
+			continue
+		}
+
+		if volumeContext == nil {
+			volumeContext = map[string]string{}
+		}
+		volumeContext[k] = v
+	}
+
+	return volumeContext
+}
+
+// getVolumeAttributes returns attributes of a volume created using the parameters, which were already validated.
+func getVolumeAttributes(parameters map[string]string) volume.VolumeAttributes {
+	syncInterval, _ := getSyncInterval(parameters)
+
+	return volume.VolumeAttributes{
+		StorageClassName: parameters[StorageClassNameParameterKey],
+		PVCName:          parameters[PVCNameParameterKey],
+		PVCNamespace:     parameters[PVCNamespaceParameterKey],
+		PVName:           parameters[PVNameParameterKey],
+		SyncInterval:     syncInterval,
+	}
+}
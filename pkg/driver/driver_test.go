@@ -0,0 +1,412 @@
+// Copyright (c) 2023 ScyllaDB.
+
+package driver
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/scylladb/local-csi-driver/pkg/driver/limit"
+	"github.com/scylladb/local-csi-driver/pkg/driver/volume"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/mount-utils"
+)
+
+func newTestDriver(t *testing.T) *driver {
+	t.Helper()
+
+	return newTestDriverWithMounter(t, mount.NewFakeMounter(nil))
+}
+
+func newTestDriverWithMounter(t *testing.T, mounter mount.Interface) *driver {
+	t.Helper()
+
+	return newTestDriverWithOptions(t, volume.WithMounter(mounter))
+}
+
+// newKubeletPodMount lays out the directory kubelet publishes the volume of the pod to and returns its target path.
+func newKubeletPodMount(t *testing.T, podsDir, podUID, volumeID string) string {
+	t.Helper()
+
+	volumeDir := filepath.Join(podsDir, podUID, "volumes", "kubernetes.io~csi", "pv")
+	err := os.MkdirAll(filepath.Join(volumeDir, "mount"), 0770)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := fmt.Sprintf(`{"driverName":"local-csi-driver","volumeHandle":%q}`, volumeID)
+	err = os.WriteFile(filepath.Join(volumeDir, "vol_data.json"), []byte(data), 0640)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return filepath.Join(volumeDir, "mount")
+}
+
+func newTestDriverWithOptions(t *testing.T, options ...volume.VolumeManagerOption) *driver {
+	t.Helper()
+
+	volumesDir := t.TempDir()
+
+	sm, err := volume.NewStateManager(volumesDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	options = append([]volume.VolumeManagerOption{
+		volume.WithMounter(mount.NewFakeMounter(nil)),
+		volume.WithLimiter(&limit.NoopLimiter{}),
+	}, options...)
+	vm, err := volume.NewVolumeManager(volumesDir, sm, options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return NewDriver("local-csi-driver", "0.0.0-unit-tests", "node-name", vm)
+}
+
+func TestValidateVolumeParameters(t *testing.T) {
+	t.Parallel()
+
+	tt := []struct {
+		name        string
+		parameters  map[string]string
+		expectedErr bool
+	}{
+		{
+			name:       "no parameters",
+			parameters: nil,
+		},
+		{
+			name: "attribution parameters",
+			parameters: map[string]string{
+				StorageClassNameParameterKey: "scylladb-local-xfs",
+				PVCNameParameterKey:          "data-scylla-0",
+				PVCNamespaceParameterKey:     "scylla",
+				PVNameParameterKey:           "pvc-8b6f3c1e-5c3a-4a4e-9a57-0b0e6c1f7f1a",
+			},
+		},
+		{
+			name: "invalid storage class name",
+			parameters: map[string]string{
+				StorageClassNameParameterKey: "Not_A_Name",
+			},
+			expectedErr: true,
+		},
+		{
+			name: "too long namespace",
+			parameters: map[string]string{
+				PVCNamespaceParameterKey: strings.Repeat("a", 64),
+			},
+			expectedErr: true,
+		},
+		{
+			name: "inode limit",
+			parameters: map[string]string{
+				InodeLimitParameterKey: "1000",
+			},
+		},
+		{
+			name: "zero inode limit",
+			parameters: map[string]string{
+				InodeLimitParameterKey: "0",
+			},
+			expectedErr: true,
+		},
+		{
+			name: "non-numeric inode limit",
+			parameters: map[string]string{
+				InodeLimitParameterKey: "1k",
+			},
+			expectedErr: true,
+		},
+		{
+			name: "sync interval",
+			parameters: map[string]string{
+				SyncIntervalParameterKey: "30s",
+			},
+		},
+		{
+			name: "zero sync interval",
+			parameters: map[string]string{
+				SyncIntervalParameterKey: "0s",
+			},
+			expectedErr: true,
+		},
+		{
+			name: "loop backing mode",
+			parameters: map[string]string{
+				BackingModeParameterKey: "loop",
+			},
+		},
+		{
+			name: "unknown backing mode",
+			parameters: map[string]string{
+				BackingModeParameterKey: "file",
+			},
+			expectedErr: true,
+		},
+		{
+			name: "encrypted",
+			parameters: map[string]string{
+				EncryptedParameterKey: "true",
+			},
+		},
+		{
+			name: "non-boolean encrypted",
+			parameters: map[string]string{
+				EncryptedParameterKey: "yes",
+			},
+			expectedErr: true,
+		},
+		{
+			name: "volume mount group",
+			parameters: map[string]string{
+				VolumeMountGroupParameterKey: "true",
+			},
+		},
+		{
+			name: "non-boolean volume mount group",
+			parameters: map[string]string{
+				VolumeMountGroupParameterKey: "root",
+			},
+			expectedErr: true,
+		},
+		{
+			name: "extent size hint",
+			parameters: map[string]string{
+				ExtentSizeHintParameterKey: "1Mi",
+			},
+		},
+		{
+			name: "extent size hint which isn't a power of two",
+			parameters: map[string]string{
+				ExtentSizeHintParameterKey: "3Mi",
+			},
+			expectedErr: true,
+		},
+		{
+			name: "extent size hint out of range",
+			parameters: map[string]string{
+				ExtentSizeHintParameterKey: "2Gi",
+			},
+			expectedErr: true,
+		},
+		{
+			name: "unknown parameter",
+			parameters: map[string]string{
+				"foo": "bar",
+			},
+			expectedErr: true,
+		},
+	}
+
+	for i := range tt {
+		tc := tt[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := ValidateVolumeParameters(tc.parameters)
+			if tc.expectedErr && err == nil {
+				t.Errorf("expected error, got nil")
+			}
+			if !tc.expectedErr && err != nil {
+				t.Errorf("expected no error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestDisabledTopology(t *testing.T) {
+	t.Parallel()
+
+	d := newTestDriver(t)
+	WithTopologyDisabled(true)(d)
+
+	pluginCaps, err := d.GetPluginCapabilities(context.Background(), &csi.GetPluginCapabilitiesRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range pluginCaps.GetCapabilities() {
+		if c.GetService().GetType() == csi.PluginCapability_Service_VOLUME_ACCESSIBILITY_CONSTRAINTS {
+			t.Errorf("expected %q capability not to be advertised", csi.PluginCapability_Service_VOLUME_ACCESSIBILITY_CONSTRAINTS)
+		}
+	}
+
+	nodeInfo, err := d.NodeGetInfo(context.Background(), &csi.NodeGetInfoRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nodeInfo.GetAccessibleTopology() != nil {
+		t.Errorf("expected no node accessible topology, got %v", nodeInfo.GetAccessibleTopology())
+	}
+
+	req := newCreateVolumeRequest("volume", 1024)
+	req.AccessibilityRequirements = &csi.TopologyRequirement{
+		Requisite: []*csi.Topology{
+			{
+				Segments: map[string]string{
+					NodeNameTopologyKey: "other-node",
+				},
+			},
+		},
+	}
+
+	resp, err := d.CreateVolume(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.GetVolume().GetAccessibleTopology() != nil {
+		t.Errorf("expected no volume accessible topology, got %v", resp.GetVolume().GetAccessibleTopology())
+	}
+}
+
+func TestEnabledTopology(t *testing.T) {
+	t.Parallel()
+
+	d := newTestDriver(t)
+
+	pluginCaps, err := d.GetPluginCapabilities(context.Background(), &csi.GetPluginCapabilitiesRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, c := range pluginCaps.GetCapabilities() {
+		if c.GetService().GetType() == csi.PluginCapability_Service_VOLUME_ACCESSIBILITY_CONSTRAINTS {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("expected %q capability to be advertised", csi.PluginCapability_Service_VOLUME_ACCESSIBILITY_CONSTRAINTS)
+	}
+
+	resp, err := d.CreateVolume(context.Background(), newCreateVolumeRequest("volume", 1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedTopology := d.getVolumeAccessibleTopology()
+	if len(resp.GetVolume().GetAccessibleTopology()) != 1 || !reflect.DeepEqual(resp.GetVolume().GetAccessibleTopology()[0].GetSegments(), expectedTopology[0].GetSegments()) {
+		t.Errorf("expected volume accessible topology %v, got %v", expectedTopology, resp.GetVolume().GetAccessibleTopology())
+	}
+}
+
+func TestTopologySegments(t *testing.T) {
+	t.Parallel()
+
+	d := newTestDriver(t)
+	WithTopologySegments(map[string]string{
+		"topology.kubernetes.io/zone":   "us-east-1a",
+		"topology.kubernetes.io/region": "us-east-1",
+	})(d)
+
+	expectedSegments := map[string]string{
+		NodeNameTopologyKey:             "node-name",
+		"topology.kubernetes.io/zone":   "us-east-1a",
+		"topology.kubernetes.io/region": "us-east-1",
+	}
+
+	nodeInfo, err := d.NodeGetInfo(context.Background(), &csi.NodeGetInfoRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(nodeInfo.GetAccessibleTopology().GetSegments(), expectedSegments) {
+		t.Errorf("expected node accessible topology segments %v, got %v", expectedSegments, nodeInfo.GetAccessibleTopology().GetSegments())
+	}
+
+	req := newCreateVolumeRequest("volume", 1024)
+	req.AccessibilityRequirements = &csi.TopologyRequirement{
+		Requisite: []*csi.Topology{
+			{
+				Segments: expectedSegments,
+			},
+		},
+	}
+
+	resp, err := d.CreateVolume(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.GetVolume().GetAccessibleTopology()) != 1 || !reflect.DeepEqual(resp.GetVolume().GetAccessibleTopology()[0].GetSegments(), expectedSegments) {
+		t.Errorf("expected volume accessible topology segments %v, got %v", expectedSegments, resp.GetVolume().GetAccessibleTopology())
+	}
+
+	// Matching node name alone isn't enough, volumes of another zone can't be placed on the node.
+	req = newCreateVolumeRequest("other-zone-volume", 1024)
+	req.AccessibilityRequirements = &csi.TopologyRequirement{
+		Requisite: []*csi.Topology{
+			{
+				Segments: map[string]string{
+					NodeNameTopologyKey:             "node-name",
+					"topology.kubernetes.io/zone":   "us-east-1b",
+					"topology.kubernetes.io/region": "us-east-1",
+				},
+			},
+		},
+	}
+
+	_, err = d.CreateVolume(context.Background(), req)
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("expected %v code for volume of another zone, got %v", codes.ResourceExhausted, err)
+	}
+}
+
+func TestValidateTopologySegments(t *testing.T) {
+	t.Parallel()
+
+	tt := []struct {
+		name        string
+		segments    map[string]string
+		expectedErr bool
+	}{
+		{
+			name: "no segments",
+		},
+		{
+			name: "valid segments",
+			segments: map[string]string{
+				"topology.kubernetes.io/zone":   "us-east-1a",
+				"topology.kubernetes.io/region": "us-east-1",
+			},
+		},
+		{
+			name: "node name segment",
+			segments: map[string]string{
+				NodeNameTopologyKey: "other-node",
+			},
+			expectedErr: true,
+		},
+		{
+			name: "invalid key",
+			segments: map[string]string{
+				"topology.kubernetes.io/zone/": "us-east-1a",
+			},
+			expectedErr: true,
+		},
+		{
+			name: "invalid value",
+			segments: map[string]string{
+				"topology.kubernetes.io/zone": "us east",
+			},
+			expectedErr: true,
+		},
+	}
+
+	for i := range tt {
+		tc := tt[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := ValidateTopologySegments(tc.segments)
+			if tc.expectedErr != (err != nil) {
+				t.Errorf("expected error %v, got %v", tc.expectedErr, err)
+			}
+		})
+	}
+}
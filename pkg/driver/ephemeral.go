@@ -0,0 +1,170 @@
+// Copyright (c) 2023 ScyllaDB.
+
+package driver
+
+import (
+	stderrors "errors"
+	"fmt"
+	"strings"
+
+	"github.com/scylladb/local-csi-driver/pkg/driver/volume"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// EphemeralContextKey is set by kubelet in volume context of CSI ephemeral inline volumes
+	// when the driver is registered with podInfoOnMount.
+	EphemeralContextKey = "csi.storage.k8s.io/ephemeral"
+
+	// podInfoContextKeyPrefix prefixes keys of pod information kubelet passes in volume context on publish.
+	podInfoContextKeyPrefix = "csi.storage.k8s.io/"
+	podNameContextKey       = podInfoContextKeyPrefix + "pod.name"
+	podNamespaceContextKey  = podInfoContextKeyPrefix + "pod.namespace"
+
+	// SizeParameterKey sets the capacity of an ephemeral inline volume.
+	SizeParameterKey = "size"
+)
+
+// isEphemeralVolume returns whether the volume context belongs to an ephemeral inline volume,
+// which lives only as long as the pod it's published to.
+func isEphemeralVolume(volumeContext map[string]string) bool {
+	return volumeContext[EphemeralContextKey] == "true"
+}
+
+// validateEphemeralVolumeContext verifies attributes of an ephemeral inline volume set in a pod spec.
+func validateEphemeralVolumeContext(volumeContext map[string]string) error {
+	var errs []error
+	for k, v := range volumeContext {
+		switch k {
+		case SizeParameterKey:
+			_, err := parseSize(v)
+			if err != nil {
+				errs = append(errs, err)
+			}
+		case InodeLimitParameterKey:
+			_, err := parseInodeLimit(v)
+			if err != nil {
+				errs = append(errs, err)
+			}
+		case SyncIntervalParameterKey:
+			_, err := parseSyncInterval(v)
+			if err != nil {
+				errs = append(errs, err)
+			}
+		case VolumeMountGroupParameterKey:
+			_, err := parseVolumeMountGroup(v)
+			if err != nil {
+				errs = append(errs, err)
+			}
+		default:
+			if !strings.HasPrefix(k, podInfoContextKeyPrefix) {
+				errs = append(errs, fmt.Errorf("unsupported ephemeral volume attribute key: %q", k))
+			}
+		}
+	}
+
+	if _, ok := volumeContext[SizeParameterKey]; !ok {
+		errs = append(errs, fmt.Errorf("ephemeral volume attribute %q is required", SizeParameterKey))
+	}
+
+	err := errors.NewAggregate(errs)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func parseSize(v string) (int64, error) {
+	q, err := resource.ParseQuantity(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %q volume parameter value %q: %w", SizeParameterKey, v, err)
+	}
+
+	if q.Sign() <= 0 {
+		return 0, fmt.Errorf("invalid %q volume parameter value %q: must be positive", SizeParameterKey, v)
+	}
+
+	return q.Value(), nil
+}
+
+// ensureEphemeralVolume creates the directory backed ephemeral inline volume unless it already exists.
+// It returns whether the volume was created, so it can be removed when publishing fails.
+func (d *driver) ensureEphemeralVolume(volumeID string, volumeContext map[string]string) (bool, error) {
+	err := validateEphemeralVolumeContext(volumeContext)
+	if err != nil {
+		return false, status.Errorf(codes.InvalidArgument, "Invalid ephemeral volume attributes: %v", err)
+	}
+
+	// Ephemeral volumes have no name of their own, so their ID is used as one.
+	d.volumeNameLocks.LockKey(volumeID)
+	defer func() {
+		_ = d.volumeNameLocks.UnlockKey(volumeID)
+	}()
+
+	vs := d.volumeManager.GetVolumeStateByID(volumeID)
+	if vs != nil {
+		if !vs.Ephemeral {
+			return false, status.Errorf(codes.FailedPrecondition, "Volume %q isn't an ephemeral volume", volumeID)
+		}
+
+		return false, nil
+	}
+
+	// Attributes were already validated.
+	capacity, _ := parseSize(volumeContext[SizeParameterKey])
+	inodeLimit, _ := getInodeLimit(volumeContext)
+	syncInterval, _ := getSyncInterval(volumeContext)
+
+	d.mut.Lock()
+	defer d.mut.Unlock()
+
+	if d.exceedsMaxVolumesPerNode() {
+		return false, status.Errorf(codes.ResourceExhausted, "Node already has the maximum number of volumes: %d", d.maxVolumesPerNode)
+	}
+
+	exceedsBudget, remainingBudget := d.volumeManager.ExceedsProvisioningBudget(capacity)
+	if exceedsBudget {
+		return false, status.Errorf(codes.ResourceExhausted, "Requested capacity exceeds remaining provisioning budget: %d", remainingBudget)
+	}
+
+	availableCapacity, err := d.volumeManager.GetAvailableCapacity()
+	if err != nil {
+		return false, status.Errorf(codes.Internal, "Cannot check node capacity: %v", err)
+	}
+
+	if capacity > availableCapacity {
+		return false, status.Errorf(codes.ResourceExhausted, "Requested capacity is bigger than available: %d", availableCapacity)
+	}
+
+	klog.V(2).InfoS("Creating ephemeral volume", "volumeID", volumeID, "pod", klog.KRef(volumeContext[podNamespaceContextKey], volumeContext[podNameContextKey]))
+	err = d.volumeManager.CreateVolume(volumeID, volumeID, capacity, &volume.CreateVolumeOptions{InodeLimit: inodeLimit, Attributes: volume.VolumeAttributes{Ephemeral: true, SyncInterval: syncInterval}})
+	if err != nil {
+		if stderrors.Is(err, volume.InsufficientCapacityErr) {
+			return false, status.Errorf(codes.ResourceExhausted, "Can't create ephemeral volume: %s", err)
+		}
+		return false, status.Errorf(codes.Internal, "Can't create ephemeral volume: %s", err)
+	}
+
+	return true, nil
+}
+
+// deleteEphemeralVolume removes directory, limit and state of the ephemeral volume.
+func (d *driver) deleteEphemeralVolume(volumeID string) error {
+	d.volumeNameLocks.LockKey(volumeID)
+	defer func() {
+		_ = d.volumeNameLocks.UnlockKey(volumeID)
+	}()
+
+	klog.V(2).InfoS("Deleting ephemeral volume", "volumeID", volumeID)
+	err := d.volumeManager.DeleteVolume(volumeID)
+	if err != nil {
+		return fmt.Errorf("can't delete ephemeral volume %q: %w", volumeID, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,72 @@
+// Copyright (c) 2023 ScyllaDB.
+
+package driver
+
+import (
+	"fmt"
+	"net/http"
+
+	"k8s.io/klog/v2"
+)
+
+// HealthHandler returns a handler serving liveness and readiness probes.
+func (d *driver) HealthHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", d.serveHealthz)
+	mux.HandleFunc("GET /readyz", d.serveReadyz)
+	return mux
+}
+
+// StartingHealthHandler returns a handler serving probes while the driver is being created. The driver is alive,
+// but not ready until its volume state is loaded and quotas are restored.
+func StartingHealthHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, _ *http.Request) {
+		writeProbeResult(w, nil)
+	})
+	mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, _ *http.Request) {
+		writeProbeResult(w, fmt.Errorf("volume state is still being loaded"))
+	})
+	return mux
+}
+
+func (d *driver) serveHealthz(w http.ResponseWriter, _ *http.Request) {
+	writeProbeResult(w, nil)
+}
+
+func (d *driver) serveReadyz(w http.ResponseWriter, _ *http.Request) {
+	writeProbeResult(w, d.checkReadiness())
+}
+
+// checkReadiness verifies the driver completed its startup, the volumes dir filesystem can be inspected
+// and the limiter works.
+func (d *driver) checkReadiness() error {
+	if !d.IsReady() {
+		return fmt.Errorf("volume state is still being loaded")
+	}
+
+	_, err := d.volumeManager.GetAvailableCapacity()
+	if err != nil {
+		return fmt.Errorf("can't get available capacity: %w", err)
+	}
+
+	err = d.volumeManager.CheckLimiter()
+	if err != nil {
+		return fmt.Errorf("limiter isn't functional: %w", err)
+	}
+
+	return nil
+}
+
+func writeProbeResult(w http.ResponseWriter, err error) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+
+	if err != nil {
+		klog.ErrorS(err, "Probe failed")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = fmt.Fprintln(w, err.Error())
+		return
+	}
+
+	_, _ = fmt.Fprintln(w, "ok")
+}
@@ -0,0 +1,114 @@
+// Copyright (c) 2023 ScyllaDB.
+
+package driver
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/scylladb/local-csi-driver/pkg/driver/limit"
+	"github.com/scylladb/local-csi-driver/pkg/driver/volume"
+)
+
+type brokenLimiter struct {
+	limit.NoopLimiter
+}
+
+func (l *brokenLimiter) GetLimitID(string) (uint32, error) {
+	return 0, fmt.Errorf("quotactl failed")
+}
+
+func TestHealthHandler(t *testing.T) {
+	t.Parallel()
+
+	tt := []struct {
+		name           string
+		newDriver      func(t *testing.T) *driver
+		path           string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "healthz",
+			newDriver:      newTestDriver,
+			path:           "/healthz",
+			expectedStatus: http.StatusOK,
+			expectedBody:   "ok",
+		},
+		{
+			name:           "readyz of healthy driver",
+			newDriver:      newTestDriver,
+			path:           "/readyz",
+			expectedStatus: http.StatusOK,
+			expectedBody:   "ok",
+		},
+		{
+			name: "readyz before startup completes",
+			newDriver: func(t *testing.T) *driver {
+				d := newTestDriver(t)
+				WithReadinessGate()(d)
+				return d
+			},
+			path:           "/readyz",
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedBody:   "volume state is still being loaded",
+		},
+		{
+			name: "readyz with volumes dir which can't be statfs-ed",
+			newDriver: func(t *testing.T) *driver {
+				d := newTestDriver(t)
+				err := os.RemoveAll(d.volumeManager.VolumesDir())
+				if err != nil {
+					t.Fatal(err)
+				}
+				return d
+			},
+			path:           "/readyz",
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedBody:   "can't get available capacity",
+		},
+		{
+			name: "readyz with broken limiter",
+			newDriver: func(t *testing.T) *driver {
+				return newTestDriverWithOptions(t, volume.WithLimiter(&brokenLimiter{}))
+			},
+			path:           "/readyz",
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedBody:   "limiter isn't functional",
+		},
+		{
+			name: "healthz with broken limiter",
+			newDriver: func(t *testing.T) *driver {
+				return newTestDriverWithOptions(t, volume.WithLimiter(&brokenLimiter{}))
+			},
+			path:           "/healthz",
+			expectedStatus: http.StatusOK,
+			expectedBody:   "ok",
+		},
+	}
+
+	for i := range tt {
+		tc := tt[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			d := tc.newDriver(t)
+
+			req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			rec := httptest.NewRecorder()
+			d.HealthHandler().ServeHTTP(rec, req)
+
+			if rec.Code != tc.expectedStatus {
+				t.Errorf("expected status %d, got %d: %s", tc.expectedStatus, rec.Code, rec.Body.String())
+			}
+
+			if !strings.Contains(rec.Body.String(), tc.expectedBody) {
+				t.Errorf("expected body to contain %q, got %q", tc.expectedBody, rec.Body.String())
+			}
+		})
+	}
+}
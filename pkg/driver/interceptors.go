@@ -0,0 +1,127 @@
+// Copyright (c) 2023 ScyllaDB.
+
+package driver
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/kubernetes-csi/csi-lib-utils/protosanitizer"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+)
+
+// LoggingUnaryInterceptor logs every request with secrets stripped, and its duration and resulting code.
+func LoggingUnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if !klog.V(4).Enabled() {
+			return handler(ctx, req)
+		}
+
+		klog.V(4).InfoS("New request", "method", info.FullMethod, "request", protosanitizer.StripSecrets(req))
+
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		klog.V(4).InfoS("Request handled", "method", info.FullMethod, "duration", time.Since(start), "code", status.Code(err), "err", err)
+
+		return resp, err
+	}
+}
+
+// RecoveryUnaryInterceptor converts panics of request handlers into Internal errors, so they don't crash the server.
+func RecoveryUnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
+		defer func() {
+			r := recover()
+			if r != nil {
+				klog.ErrorS(fmt.Errorf("%v", r), "Recovered from panic while handling request", "method", info.FullMethod, "stack", string(debug.Stack()))
+				resp = nil
+				err = status.Errorf(codes.Internal, "Panic while handling %s: %v", info.FullMethod, r)
+			}
+		}()
+
+		return handler(ctx, req)
+	}
+}
+
+// InFlightRequests tracks requests which are being handled, so the shutdown can wait for them to finish.
+// Once the shutdown started waiting, new requests are rejected, so they can't extend it.
+type InFlightRequests struct {
+	mut      sync.Mutex
+	count    int
+	draining bool
+	// idle is closed once the last request finishes while draining.
+	idle chan struct{}
+}
+
+func NewInFlightRequests() *InFlightRequests {
+	return &InFlightRequests{}
+}
+
+// UnaryInterceptor tracks requests for as long as their handlers run. It has to be the outermost interceptor,
+// so the shutdown waits for the rest of them too.
+func (r *InFlightRequests) UnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if !r.start() {
+			return nil, status.Errorf(codes.Unavailable, "Driver is shutting down")
+		}
+		defer r.finish()
+
+		return handler(ctx, req)
+	}
+}
+
+func (r *InFlightRequests) start() bool {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	if r.draining {
+		return false
+	}
+
+	r.count++
+
+	return true
+}
+
+func (r *InFlightRequests) finish() {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	r.count--
+	if r.count == 0 && r.idle != nil {
+		close(r.idle)
+		r.idle = nil
+	}
+}
+
+// Wait rejects new requests and waits at most timeout for in-flight requests to finish. It returns whether they did.
+func (r *InFlightRequests) Wait(timeout time.Duration) bool {
+	r.mut.Lock()
+	r.draining = true
+	if r.count == 0 {
+		r.mut.Unlock()
+		return true
+	}
+
+	if r.idle == nil {
+		r.idle = make(chan struct{})
+	}
+	idle := r.idle
+	r.mut.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-idle:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright (c) 2023 ScyllaDB.
+
+package driver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+func TestRecoveryUnaryInterceptor(t *testing.T) {
+	t.Parallel()
+
+	interceptor := RecoveryUnaryInterceptor()
+	info := &grpc.UnaryServerInfo{
+		FullMethod: "/csi.v1.Node/NodePublishVolume",
+	}
+
+	resp, err := interceptor(context.Background(), nil, info, func(ctx context.Context, req any) (any, error) {
+		panic("malformed request")
+	})
+	if resp != nil {
+		t.Errorf("expected nil response, got %v", resp)
+	}
+	if status.Code(err) != codes.Internal {
+		t.Errorf("expected %v code, got %v", codes.Internal, err)
+	}
+
+	resp, err = interceptor(context.Background(), nil, info, func(ctx context.Context, req any) (any, error) {
+		return "response", status.Error(codes.NotFound, "not found")
+	})
+	if resp != "response" {
+		t.Errorf("expected handler response to be passed through, got %v", resp)
+	}
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("expected %v code, got %v", codes.NotFound, err)
+	}
+}
+
+func TestInFlightRequestsRejectsRequestsWhileDraining(t *testing.T) {
+	t.Parallel()
+
+	requests := NewInFlightRequests()
+	interceptor := requests.UnaryInterceptor()
+	info := &grpc.UnaryServerInfo{
+		FullMethod: "/csi.v1.Controller/CreateVolume",
+	}
+
+	started := make(chan struct{})
+	released := make(chan struct{})
+	handled := make(chan error, 1)
+	go func() {
+		_, err := interceptor(context.Background(), nil, info, func(ctx context.Context, req any) (any, error) {
+			close(started)
+			<-released
+			return nil, nil
+		})
+		handled <- err
+	}()
+	<-started
+
+	waited := make(chan bool, 1)
+	go func() {
+		waited <- requests.Wait(wait.ForeverTestTimeout)
+	}()
+
+	// Draining starts as soon as Wait is called, new requests are rejected from then on.
+	err := wait.PollUntilContextTimeout(context.Background(), 10*time.Millisecond, wait.ForeverTestTimeout, true, func(context.Context) (bool, error) {
+		_, err := interceptor(context.Background(), nil, info, func(ctx context.Context, req any) (any, error) {
+			return nil, nil
+		})
+		return status.Code(err) == codes.Unavailable, nil
+	})
+	if err != nil {
+		t.Fatalf("expected new requests to be rejected while draining: %v", err)
+	}
+
+	select {
+	case <-waited:
+		t.Fatal("expected Wait to wait for the in-flight request")
+	default:
+	}
+
+	close(released)
+
+	err = <-handled
+	if err != nil {
+		t.Errorf("expected in-flight request to finish, got %v", err)
+	}
+
+	if finished := <-waited; !finished {
+		t.Errorf("expected in-flight requests to finish in time")
+	}
+}
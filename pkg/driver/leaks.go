@@ -0,0 +1,112 @@
+// Copyright (c) 2023 ScyllaDB.
+
+package driver
+
+import (
+	"context"
+	"time"
+
+	"github.com/scylladb/local-csi-driver/pkg/driver/volume"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+)
+
+const (
+	DefaultLeakGracePeriod = time.Hour
+)
+
+// RunLeakDetector periodically looks for volumes whose state and directory have been inconsistent for at least
+// gracePeriod, until the context is cancelled. Suspected leaks are deleted only when cleanup is set, otherwise
+// they're just reported for operators to investigate.
+func (d *driver) RunLeakDetector(ctx context.Context, interval, gracePeriod time.Duration, cleanup bool) {
+	klog.InfoS("Starting leak detector", "interval", interval, "gracePeriod", gracePeriod, "cleanup", cleanup)
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		d.detectLeaks(gracePeriod, cleanup)
+	}, interval)
+}
+
+func (d *driver) detectLeaks(gracePeriod time.Duration, cleanup bool) {
+	// Leaks found before a failure are still reported.
+	leaks, err := d.volumeManager.FindSuspectedLeaks(gracePeriod)
+	if err != nil {
+		klog.ErrorS(err, "Can't detect all leaked volumes")
+	}
+
+	// Volumes dir whose disk isn't mounted makes every state look leaked, and a lost workspace every directory.
+	// When most volumes are inconsistent, the cause is most likely on the node, so they're left to an operator.
+	volumesCount := len(d.volumeManager.GetVolumes())
+	for _, leak := range leaks {
+		if leak.Kind == volume.DirectoryWithoutStateLeak {
+			volumesCount++
+		}
+	}
+	if cleanup && 2*len(leaks) > volumesCount {
+		klog.Errorf("Skipping cleanup of suspected leaked volumes, %d out of %d volumes are inconsistent", len(leaks), volumesCount)
+		cleanup = false
+	}
+
+	remainingLeaks := make([]volume.SuspectedLeak, 0, len(leaks))
+	for i := range leaks {
+		leak := &leaks[i]
+		if !cleanup {
+			klog.Warningf("Volume %q is suspected to be leaked: %s since %v", leak.VolumeID, leak.Kind, leak.Since)
+			remainingLeaks = append(remainingLeaks, *leak)
+			continue
+		}
+
+		deleted, err := d.deleteSuspectedLeak(leak)
+		if err != nil {
+			klog.ErrorS(err, "Can't delete suspected leaked volume", "volumeID", leak.VolumeID, "kind", leak.Kind)
+			remainingLeaks = append(remainingLeaks, *leak)
+			continue
+		}
+
+		if deleted {
+			klog.InfoS("Deleted suspected leaked volume", "volumeID", leak.VolumeID, "kind", leak.Kind, "since", leak.Since)
+			d.metrics.suspectedLeakedVolumesDeleted.Inc()
+		}
+	}
+
+	for _, kind := range volume.LeakKinds {
+		count := 0
+		for _, leak := range remainingLeaks {
+			if leak.Kind == kind {
+				count++
+			}
+		}
+		d.metrics.suspectedLeakedVolumes.WithLabelValues(string(kind)).Set(float64(count))
+	}
+
+	d.suspectedLeaksMut.Lock()
+	defer d.suspectedLeaksMut.Unlock()
+	d.suspectedLeaks = remainingLeaks
+}
+
+func (d *driver) deleteSuspectedLeak(leak *volume.SuspectedLeak) (bool, error) {
+	// Deletion must not interleave with a creation of a volume having the same name.
+	if len(leak.Name) != 0 {
+		d.volumeNameLocks.LockKey(leak.Name)
+		defer func() {
+			_ = d.volumeNameLocks.UnlockKey(leak.Name)
+		}()
+	}
+
+	d.volumeIDLocks.LockKey(leak.VolumeID)
+	defer func() {
+		_ = d.volumeIDLocks.UnlockKey(leak.VolumeID)
+	}()
+
+	return d.volumeManager.DeleteSuspectedLeak(leak)
+}
+
+// getSuspectedLeaks returns the leaks found by the last leak detection, and whether it ran at all.
+func (d *driver) getSuspectedLeaks() ([]volume.SuspectedLeak, bool) {
+	d.suspectedLeaksMut.Lock()
+	defer d.suspectedLeaksMut.Unlock()
+
+	if d.suspectedLeaks == nil {
+		return nil, false
+	}
+
+	return append([]volume.SuspectedLeak{}, d.suspectedLeaks...), true
+}
@@ -0,0 +1,140 @@
+// Copyright (c) 2023 ScyllaDB.
+
+package driver
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/scylladb/local-csi-driver/pkg/driver/volume"
+)
+
+func TestDetectLeaks(t *testing.T) {
+	t.Parallel()
+
+	d := newTestDriver(t)
+
+	getSuspectedLeaks := func() []volume.SuspectedLeak {
+		t.Helper()
+
+		rec := httptest.NewRecorder()
+		d.AdminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/leaks", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+		}
+
+		var leaks []volume.SuspectedLeak
+		err := json.NewDecoder(rec.Body).Decode(&leaks)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return leaks
+	}
+
+	rec := httptest.NewRecorder()
+	d.AdminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/leaks", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected status %d before leak detection ran, got %d", http.StatusServiceUnavailable, rec.Code)
+	}
+
+	// Cleanup is refused when most volumes look leaked, so the leaked one is among consistent ones.
+	var volumeIDs []string
+	for _, name := range []string{"volume", "consistent-1", "consistent-2"} {
+		resp, err := d.CreateVolume(context.Background(), newCreateVolumeRequest(name, 1024))
+		if err != nil {
+			t.Fatal(err)
+		}
+		volumeIDs = append(volumeIDs, resp.Volume.VolumeId)
+	}
+	volumeID := volumeIDs[0]
+
+	d.detectLeaks(0, false)
+	if leaks := getSuspectedLeaks(); len(leaks) != 0 {
+		t.Errorf("expected no suspected leaks, got %#v", leaks)
+	}
+
+	vs := d.volumeManager.GetVolumeStateByID(volumeID)
+	err := os.RemoveAll(vs.VolumePath(vs.VolumesDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Without cleanup, leaks are only reported.
+	d.detectLeaks(0, false)
+	leaks := getSuspectedLeaks()
+	if len(leaks) != 1 || leaks[0].VolumeID != volumeID || leaks[0].Kind != volume.StateWithoutDirectoryLeak {
+		t.Errorf("expected volume %q to be suspected leaked, got %#v", volumeID, leaks)
+	}
+
+	if got := testutil.ToFloat64(d.metrics.suspectedLeakedVolumes.WithLabelValues(string(volume.StateWithoutDirectoryLeak))); got != 1 {
+		t.Errorf("expected 1 suspected leaked volume without directory, got %v", got)
+	}
+
+	if d.volumeManager.GetVolumeStateByID(volumeID) == nil {
+		t.Errorf("expected state of volume %q to be kept without cleanup", volumeID)
+	}
+
+	d.detectLeaks(0, true)
+	if leaks := getSuspectedLeaks(); len(leaks) != 0 {
+		t.Errorf("expected no suspected leaks after cleanup, got %#v", leaks)
+	}
+
+	if d.volumeManager.GetVolumeStateByID(volumeID) != nil {
+		t.Errorf("expected state of volume %q to be deleted by cleanup", volumeID)
+	}
+
+	if got := testutil.ToFloat64(d.metrics.suspectedLeakedVolumes.WithLabelValues(string(volume.StateWithoutDirectoryLeak))); got != 0 {
+		t.Errorf("expected no suspected leaked volumes after cleanup, got %v", got)
+	}
+
+	if got := testutil.ToFloat64(d.metrics.suspectedLeakedVolumesDeleted); got != 1 {
+		t.Errorf("expected 1 deleted suspected leaked volume, got %v", got)
+	}
+}
+
+func TestDetectLeaksRefusesCleanupWhenMostVolumesAreInconsistent(t *testing.T) {
+	t.Parallel()
+
+	d := newTestDriver(t)
+
+	var volumeIDs []string
+	for _, name := range []string{"volume-1", "volume-2", "volume-3"} {
+		resp, err := d.CreateVolume(context.Background(), newCreateVolumeRequest(name, 1024))
+		if err != nil {
+			t.Fatal(err)
+		}
+		volumeIDs = append(volumeIDs, resp.Volume.VolumeId)
+	}
+
+	// Like a volumes dir whose disk isn't mounted, most volumes lose their directories.
+	for _, volumeID := range volumeIDs[:2] {
+		vs := d.volumeManager.GetVolumeStateByID(volumeID)
+		err := os.RemoveAll(vs.VolumePath(vs.VolumesDir))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	d.detectLeaks(0, true)
+
+	for _, volumeID := range volumeIDs {
+		if d.volumeManager.GetVolumeStateByID(volumeID) == nil {
+			t.Errorf("expected state of volume %q to be kept", volumeID)
+		}
+	}
+
+	leaks, _ := d.getSuspectedLeaks()
+	if len(leaks) != 2 {
+		t.Errorf("expected 2 suspected leaks to be reported, got %#v", leaks)
+	}
+
+	if got := testutil.ToFloat64(d.metrics.suspectedLeakedVolumesDeleted); got != 0 {
+		t.Errorf("expected no deleted suspected leaked volumes, got %v", got)
+	}
+}
@@ -0,0 +1,362 @@
+// Copyright (c) 2023 ScyllaDB.
+
+package ext4
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path"
+	"sync"
+
+	"github.com/scylladb/local-csi-driver/pkg/driver/limit"
+	"github.com/scylladb/local-csi-driver/pkg/driver/limit/xfs/fxattrs"
+	"github.com/scylladb/local-csi-driver/pkg/driver/limit/xfs/quotactl"
+	"github.com/scylladb/local-csi-driver/pkg/driver/volume"
+	"github.com/scylladb/local-csi-driver/pkg/util/fs"
+	"github.com/scylladb/local-csi-driver/pkg/util/slices"
+	"k8s.io/klog/v2"
+)
+
+type ext4Limiter struct {
+	volumesDir string
+	mut        sync.Mutex
+	// projectIDs tracks project IDs in use by volumes.
+	// Generic quota reports an empty quota for any ID, so used IDs can't be told apart from free ones by the kernel alone.
+	projectIDs *limit.IDAllocator
+	// projectDirs maps project IDs to directories they were set on, so removed projects can be detached from them.
+	projectDirs map[uint32]string
+}
+
+var _ limit.Limiter = &ext4Limiter{}
+
+// NewExt4Limiter returns a limiter of the volumes dir restoring limits of the volumes. When some of them can't be
+// restored, the limiter is returned together with *limit.RestoreError.
+func NewExt4Limiter(volumesDir string, volumes []volume.VolumeState) (*ext4Limiter, error) {
+	volumesDir = path.Clean(volumesDir)
+
+	entry, err := fs.GetBackingMountEntry(volumesDir)
+	if err != nil {
+		return nil, fmt.Errorf("can't get mount entry of %q: %w", volumesDir, err)
+	}
+
+	if entry.Type != "ext4" {
+		return nil, fmt.Errorf("expected %q filesystem at %q mount point, got %q", "ext4", volumesDir, entry.Type)
+	}
+
+	if !slices.Contains(entry.Opts, "prjquota") {
+		return nil, fmt.Errorf("ext4 path %q was not mounted with prjquota - opts: %q", volumesDir, entry.Opts)
+	}
+
+	quotaStat, err := quotactl.GetQuotaStat(volumesDir)
+	if err != nil {
+		return nil, fmt.Errorf("can't get quota state of device %q backing %q: %w", entry.Device, volumesDir, err)
+	}
+
+	if !quotaStat.ProjectQuotaEnforced() {
+		return nil, fmt.Errorf("device %q backing %q doesn't enforce project quota", entry.Device, volumesDir)
+	}
+
+	el := &ext4Limiter{
+		volumesDir:  volumesDir,
+		projectIDs:  limit.NewIDAllocator(),
+		projectDirs: map[uint32]string{},
+	}
+
+	// Volumes are restored independently, so a single broken one doesn't leave others unenforced.
+	restoreErr := &limit.RestoreError{Failures: map[string]error{}}
+	for _, v := range volumes {
+		err = el.restoreVolumeQuota(v)
+		if err != nil {
+			restoreErr.Failures[v.ID] = err
+		}
+	}
+
+	if len(restoreErr.Failures) != 0 {
+		return el, restoreErr
+	}
+
+	return el, nil
+}
+
+func (el *ext4Limiter) restoreVolumeQuota(v volume.VolumeState) error {
+	volumePath := v.VolumePath(el.volumesDir)
+	vd, err := os.Open(volumePath)
+	if err != nil {
+		return fmt.Errorf("can't open file %q: %w", volumePath, err)
+	}
+	defer func() {
+		closeErr := vd.Close()
+		if closeErr != nil {
+			klog.ErrorS(closeErr, "Failed to close volume path", "directory", volumePath)
+		}
+	}()
+
+	projectID, err := fxattrs.GetProjectID(vd)
+	if err != nil {
+		return fmt.Errorf("can't determine project ID of %q: %w", volumePath, err)
+	}
+
+	if projectID != v.LimitID {
+		return fmt.Errorf("found tempered directory %q, expected %d project ID, got %d", volumePath, v.LimitID, projectID)
+	}
+
+	el.projectIDs.Reserve(v.LimitID)
+	el.mut.Lock()
+	el.projectDirs[v.LimitID] = volumePath
+	el.mut.Unlock()
+
+	err = el.SetLimit(v.LimitID, v.Size, v.InodeLimit)
+	if err != nil {
+		return fmt.Errorf("error restoring quota for volume %q: %w", v.ID, err)
+	}
+
+	return nil
+}
+
+func (el *ext4Limiter) NewLimit(directory string) (uint32, error) {
+	el.mut.Lock()
+	defer el.mut.Unlock()
+
+	klog.V(4).InfoS("Generating project ID")
+	projectID, err := el.projectIDs.Allocate(el.isProjectIDFree)
+	if err != nil {
+		return 0, fmt.Errorf("can't generate project ID: %w", err)
+	}
+
+	v, err := os.Open(directory)
+	if err != nil {
+		return 0, fmt.Errorf("can't open path %q: %w", directory, err)
+	}
+	defer func() {
+		closeErr := v.Close()
+		if closeErr != nil {
+			klog.ErrorS(closeErr, "Failed to close volume directory", "directory", directory)
+		}
+	}()
+
+	err = fxattrs.SetProjectID(v, projectID)
+	if err != nil {
+		el.projectIDs.Release(projectID)
+		return 0, fmt.Errorf("can't set quota properties on %q directory: %w", directory, err)
+	}
+	el.projectDirs[projectID] = directory
+
+	return projectID, nil
+}
+
+func (el *ext4Limiter) SetLimit(projectID uint32, capacityBytes int64, inodeLimit uint64) error {
+	el.mut.Lock()
+	defer el.mut.Unlock()
+
+	return el.setLimit(projectID, capacityBytes, inodeLimit)
+}
+
+func (el *ext4Limiter) setLimit(projectID uint32, capacityBytes int64, inodeLimit uint64) error {
+	klog.V(4).InfoS("Setting project", "projectID", projectID, "capacity", capacityBytes, "inodeLimit", inodeLimit)
+
+	err := quotactl.SetGenericQuota(el.volumesDir, quotactl.QuotaTypeProject, projectID, &quotactl.GenericDiskQuota{
+		BlkHardLimit:   bytesToBlocks(capacityBytes),
+		InodeHardLimit: inodeLimit,
+		Valid:          quotactl.QIF_LIMITS,
+	})
+	if err != nil {
+		return fmt.Errorf("can't set quota on %d projectID: %w", projectID, err)
+	}
+
+	return nil
+}
+
+func (el *ext4Limiter) GetLimit(limitID uint32) (int64, uint64, error) {
+	quota, err := quotactl.GetGenericQuota(el.volumesDir, quotactl.QuotaTypeProject, limitID)
+	if err != nil {
+		return 0, 0, fmt.Errorf("can't get quota for id %d: %w", limitID, err)
+	}
+
+	return int64(quota.BlkHardLimit * quotactl.QIF_DQBLKSIZE), quota.InodeHardLimit, nil
+}
+
+func (el *ext4Limiter) GetUsage(limitID uint32) (int64, uint64, error) {
+	quota, err := quotactl.GetGenericQuota(el.volumesDir, quotactl.QuotaTypeProject, limitID)
+	if err != nil {
+		return 0, 0, fmt.Errorf("can't get quota for id %d: %w", limitID, err)
+	}
+
+	return int64(quota.CurSpace), quota.CurInodes, nil
+}
+
+func (el *ext4Limiter) GetLimitID(directory string) (uint32, error) {
+	d, err := os.Open(directory)
+	if err != nil {
+		return 0, fmt.Errorf("can't open path %q: %w", directory, err)
+	}
+	defer func() {
+		closeErr := d.Close()
+		if closeErr != nil {
+			klog.ErrorS(closeErr, "Failed to close directory", "directory", directory)
+		}
+	}()
+
+	projectID, err := fxattrs.GetProjectID(d)
+	if err != nil {
+		return 0, fmt.Errorf("can't determine project ID of %q: %w", directory, err)
+	}
+
+	return projectID, nil
+}
+
+// RemoveLimit zeroes limits of the project and detaches it from its directory and everything within it, when the
+// directory still exists, so the project ID can be reused without charging a stale tree. Usage which can't be
+// detached, like of special files, keeps the project ID reserved. Directories of projects not created nor restored
+// by this limiter, like leaked ones, aren't known, so only their limits are zeroed.
+func (el *ext4Limiter) RemoveLimit(limitID uint32) error {
+	el.mut.Lock()
+	defer el.mut.Unlock()
+
+	err := el.setLimit(limitID, 0, 0)
+	if err != nil {
+		return err
+	}
+
+	directory, ok := el.projectDirs[limitID]
+	if ok {
+		err = fxattrs.DetachProject(directory, limitID)
+		if err != nil {
+			return err
+		}
+		delete(el.projectDirs, limitID)
+	}
+
+	quota, err := quotactl.GetGenericQuota(el.volumesDir, quotactl.QuotaTypeProject, limitID)
+	if err != nil && !errors.Is(err, quotactl.IDNotFoundErr) {
+		return fmt.Errorf("can't get quota for id %d: %w", limitID, err)
+	}
+	if err == nil && (quota.CurSpace != 0 || quota.CurInodes != 0) {
+		klog.InfoS("Keeping project ID reserved, it's still used", "projectID", limitID, "bytes", quota.CurSpace, "inodes", quota.CurInodes)
+		return nil
+	}
+
+	el.projectIDs.Release(limitID)
+
+	return nil
+}
+
+func (el *ext4Limiter) DiscardLimit(limitID uint32, directory string) error {
+	el.mut.Lock()
+	defer el.mut.Unlock()
+
+	d, err := os.Open(directory)
+	if err != nil {
+		return fmt.Errorf("can't open path %q: %w", directory, err)
+	}
+	defer func() {
+		closeErr := d.Close()
+		if closeErr != nil {
+			klog.ErrorS(closeErr, "Failed to close directory", "directory", directory)
+		}
+	}()
+
+	projectID, err := fxattrs.GetProjectID(d)
+	if err != nil {
+		return fmt.Errorf("can't determine project ID of %q: %w", directory, err)
+	}
+
+	if projectID == limitID {
+		err = fxattrs.ClearProjectID(d)
+		if err != nil {
+			return fmt.Errorf("can't detach project %d from %q directory: %w", limitID, directory, err)
+		}
+	}
+
+	if el.projectDirs[limitID] == directory {
+		delete(el.projectDirs, limitID)
+	}
+	el.projectIDs.Release(limitID)
+
+	return nil
+}
+
+// EnforcementMode is hard, as limits are set as hard quotas.
+func (el *ext4Limiter) EnforcementMode() limit.EnforcementMode {
+	return limit.HardEnforcement
+}
+
+func (el *ext4Limiter) ListLimitIDs() ([]uint32, error) {
+	var ids []uint32
+	for id := uint32(0); ; id++ {
+		quota, err := quotactl.GetNextGenericQuota(el.volumesDir, quotactl.QuotaTypeProject, id)
+		if err != nil {
+			if errors.Is(err, quotactl.IDNotFoundErr) {
+				return ids, nil
+			}
+			return nil, fmt.Errorf("can't get next quota after id %d: %w", id, err)
+		}
+
+		// Project ID 0 is the default project of every inode, it's never a limit.
+		if quota.ID != 0 && (quota.BlkHardLimit != 0 || quota.InodeHardLimit != 0) {
+			ids = append(ids, quota.ID)
+		}
+
+		id = quota.ID
+		if id == math.MaxUint32 {
+			return ids, nil
+		}
+	}
+}
+
+func (el *ext4Limiter) QuotaStats() (*limit.QuotaStats, error) {
+	quotaStat, err := quotactl.GetQuotaStat(el.volumesDir)
+	if err != nil {
+		return nil, fmt.Errorf("can't get quota state: %w", err)
+	}
+
+	stats := &limit.QuotaStats{
+		IncoreQuotas: quotaStat.IncoreDquots,
+	}
+	for id := uint32(0); ; id++ {
+		quota, err := quotactl.GetNextGenericQuota(el.volumesDir, quotactl.QuotaTypeProject, id)
+		if err != nil {
+			if errors.Is(err, quotactl.IDNotFoundErr) {
+				return stats, nil
+			}
+			return nil, fmt.Errorf("can't get next quota after id %d: %w", id, err)
+		}
+
+		if quota.ID != 0 && (quota.BlkHardLimit != 0 || quota.InodeHardLimit != 0) {
+			stats.Projects++
+			stats.LimitedBytes += int64(quota.BlkHardLimit * quotactl.QIF_DQBLKSIZE)
+			// Generic quota accounts space in bytes.
+			stats.UsedBytes += int64(quota.CurSpace)
+		}
+
+		id = quota.ID
+		if id == math.MaxUint32 {
+			return stats, nil
+		}
+	}
+}
+
+// Close is a no-op, ext4 limiter doesn't keep any resources open in between calls.
+func (el *ext4Limiter) Close() error {
+	return nil
+}
+
+// Generic quota block limits are in units of 1KiB.
+// Capacity is rounded up, so the volume can always use at least the requested bytes.
+func bytesToBlocks(capacity int64) uint64 {
+	return (uint64(capacity) + quotactl.QIF_DQBLKSIZE - 1) / quotactl.QIF_DQBLKSIZE
+}
+
+// isProjectIDFree returns whether the project ID isn't used by anyone else on the filesystem.
+func (el *ext4Limiter) isProjectIDFree(id uint32) (bool, error) {
+	quota, err := quotactl.GetGenericQuota(el.volumesDir, quotactl.QuotaTypeProject, id)
+	if err != nil {
+		if errors.Is(err, quotactl.IDNotFoundErr) {
+			return true, nil
+		}
+		return false, fmt.Errorf("can't get quota for id %d: %w", id, err)
+	}
+
+	return quota.CurInodes == 0 && quota.CurSpace == 0 && quota.BlkHardLimit == 0 && quota.BlkSoftLimit == 0, nil
+}
@@ -0,0 +1,422 @@
+// Copyright (c) 2023 ScyllaDB.
+
+package ext4
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/scylladb/local-csi-driver/pkg/driver/limit/xfs/fxattrs"
+	"github.com/scylladb/local-csi-driver/pkg/driver/limit/xfs/quotactl"
+	"github.com/scylladb/local-csi-driver/pkg/util/fs"
+	"golang.org/x/sys/unix"
+)
+
+// mountProjectQuotaExt4 mounts a new ext4 filesystem enforcing project quota at a temporary directory.
+func mountProjectQuotaExt4(t *testing.T) string {
+	t.Helper()
+
+	if os.Geteuid() != 0 {
+		t.Skip("mounting filesystems requires root")
+	}
+
+	mkfs, err := exec.LookPath("mkfs.ext4")
+	if err != nil {
+		t.Skipf("mkfs.ext4 isn't available: %v", err)
+	}
+
+	image := filepath.Join(t.TempDir(), "image")
+	err = os.WriteFile(image, nil, 0660)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.Truncate(image, 64<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Project IDs are kept in the extra space of inodes bigger than 128B, which small filesystems don't get by default.
+	out, err := exec.Command(mkfs, "-q", "-I", "256", "-O", "quota,project", image).CombinedOutput()
+	if err != nil {
+		t.Fatalf("can't create ext4 filesystem: %v, output: %s", err, out)
+	}
+
+	device, err := fs.AttachLoopDevice(image)
+	if err != nil {
+		t.Skipf("can't attach loop device: %v", err)
+	}
+	t.Cleanup(func() {
+		err := fs.DetachLoopDevices(image)
+		if err != nil {
+			t.Error(err)
+		}
+	})
+
+	mountPoint := filepath.Join(t.TempDir(), "mnt")
+	err = os.Mkdir(mountPoint, 0770)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = unix.Mount(device, mountPoint, "ext4", 0, "prjquota")
+	if err != nil {
+		t.Skipf("can't mount ext4 filesystem with project quota: %v", err)
+	}
+	t.Cleanup(func() {
+		err := unix.Unmount(mountPoint, 0)
+		if err != nil {
+			t.Error(err)
+		}
+	})
+
+	return mountPoint
+}
+
+func TestBytesToBlocks(t *testing.T) {
+	t.Parallel()
+
+	tt := []struct {
+		name           string
+		capacity       int64
+		expectedBlocks uint64
+	}{
+		{
+			name:           "zero",
+			capacity:       0,
+			expectedBlocks: 0,
+		},
+		{
+			name:           "sub-block size is rounded up to a whole block",
+			capacity:       1000,
+			expectedBlocks: 1,
+		},
+		{
+			name:           "block aligned size",
+			capacity:       1024,
+			expectedBlocks: 1,
+		},
+		{
+			name:           "non-block-aligned size is rounded up",
+			capacity:       1024*1024 + 1,
+			expectedBlocks: 1025,
+		},
+	}
+
+	for i := range tt {
+		tc := tt[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			blocks := bytesToBlocks(tc.capacity)
+			if blocks != tc.expectedBlocks {
+				t.Errorf("expected %d blocks, got %d", tc.expectedBlocks, blocks)
+			}
+		})
+	}
+}
+
+func TestRemoveLimitReleasesProject(t *testing.T) {
+	t.Parallel()
+
+	volumesDir := mountProjectQuotaExt4(t)
+
+	el, err := NewExt4Limiter(volumesDir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := filepath.Join(volumesDir, "volume")
+	err = os.Mkdir(dir, 0770)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	projectID, err := el.NewLimit(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = el.SetLimit(projectID, 1<<20, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = el.RemoveLimit(projectID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	quota, err := quotactl.GetGenericQuota(volumesDir, quotactl.QuotaTypeProject, projectID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if quota.BlkHardLimit != 0 || quota.InodeHardLimit != 0 || quota.CurSpace != 0 || quota.CurInodes != 0 {
+		t.Errorf("expected removed project to have neither limits nor usage, got %#v", quota)
+	}
+
+	d, err := os.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	attrs, err := fxattrs.Get(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if attrs.ProjectID != 0 || attrs.Flags&fxattrs.FlagProjectInherit != 0 {
+		t.Errorf("expected directory to be detached from project, got project ID %d and flags %#x", attrs.ProjectID, attrs.Flags)
+	}
+
+	ids, err := el.ListLimitIDs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 0 {
+		t.Errorf("expected no limits, got %v", ids)
+	}
+
+	// Released project ID is reused.
+	otherDir := filepath.Join(volumesDir, "other")
+	err = os.Mkdir(otherDir, 0770)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	otherProjectID, err := el.NewLimit(otherDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if otherProjectID != projectID {
+		t.Errorf("expected released project ID %d to be reused, got %d", projectID, otherProjectID)
+	}
+}
+
+func TestRemoveLimitDetachesProjectFromDirectoryContent(t *testing.T) {
+	t.Parallel()
+
+	volumesDir := mountProjectQuotaExt4(t)
+
+	el, err := NewExt4Limiter(volumesDir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := filepath.Join(volumesDir, "volume")
+	err = os.Mkdir(dir, 0770)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	projectID, err := el.NewLimit(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = el.SetLimit(projectID, 16<<20, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Files created within the directory inherit its project.
+	nestedFile := filepath.Join(dir, "nested", "data")
+	err = os.Mkdir(filepath.Dir(nestedFile), 0770)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(nestedFile, make([]byte, 1<<20), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = el.RemoveLimit(projectID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Detached tree isn't charged to the project anymore.
+	usedBytes, usedInodes, err := el.GetUsage(projectID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usedBytes != 0 || usedInodes != 0 {
+		t.Errorf("expected removed project not to be used, got %d bytes and %d inodes", usedBytes, usedInodes)
+	}
+
+	for _, p := range []string{dir, filepath.Dir(nestedFile), nestedFile} {
+		gotID, err := fxattrs.GetProjectIDOf(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if gotID != 0 {
+			t.Errorf("expected %q to be detached from project, got project ID %d", p, gotID)
+		}
+	}
+}
+
+func TestRemoveLimitKeepsProjectWithRemainingUsageReserved(t *testing.T) {
+	t.Parallel()
+
+	volumesDir := mountProjectQuotaExt4(t)
+
+	el, err := NewExt4Limiter(volumesDir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := filepath.Join(volumesDir, "volume")
+	err = os.Mkdir(dir, 0770)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	projectID, err := el.NewLimit(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Special files aren't opened to be detached, so they keep using the project.
+	err = unix.Mkfifo(filepath.Join(dir, "fifo"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = el.RemoveLimit(projectID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	otherDir := filepath.Join(volumesDir, "other")
+	err = os.Mkdir(otherDir, 0770)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	otherProjectID, err := el.NewLimit(otherDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if otherProjectID == projectID {
+		t.Errorf("expected project %d still having usage not to be reused", projectID)
+	}
+}
+
+func TestDiscardLimitLeavesLimitInPlace(t *testing.T) {
+	t.Parallel()
+
+	volumesDir := mountProjectQuotaExt4(t)
+
+	el, err := NewExt4Limiter(volumesDir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := filepath.Join(volumesDir, "volume")
+	err = os.Mkdir(dir, 0770)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	projectID, err := el.NewLimit(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = el.SetLimit(projectID, 1<<20, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = el.DiscardLimit(projectID, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if d, ok := el.projectDirs[projectID]; ok {
+		t.Errorf("expected discarded project %d not to be tracked, got directory %q", projectID, d)
+	}
+
+	gotID, err := fxattrs.GetProjectIDOf(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotID != 0 {
+		t.Errorf("expected directory to be detached from project, got project ID %d", gotID)
+	}
+
+	quota, err := quotactl.GetGenericQuota(volumesDir, quotactl.QuotaTypeProject, projectID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if quota.BlkHardLimit != bytesToBlocks(1<<20) {
+		t.Errorf("expected block limit %d to be left in place, got %d", bytesToBlocks(1<<20), quota.BlkHardLimit)
+	}
+}
+
+func TestGetLimitAndUsage(t *testing.T) {
+	t.Parallel()
+
+	const capacity = 16 << 20
+
+	volumesDir := mountProjectQuotaExt4(t)
+
+	el, err := NewExt4Limiter(volumesDir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := filepath.Join(volumesDir, "volume")
+	err = os.Mkdir(dir, 0770)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	projectID, err := el.NewLimit(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = el.SetLimit(projectID, capacity, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.WriteFile(filepath.Join(dir, "data"), make([]byte, 1<<20), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	limitBytes, limitInodes, err := el.GetLimit(projectID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if limitBytes != capacity || limitInodes != 100 {
+		t.Errorf("expected limit of %d bytes and %d inodes, got %d bytes and %d inodes", capacity, 100, limitBytes, limitInodes)
+	}
+
+	usedBytes, usedInodes, err := el.GetUsage(projectID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usedBytes < 1<<20 || usedBytes > capacity {
+		t.Errorf("expected usage of at least %d bytes, got %d", 1<<20, usedBytes)
+	}
+	if usedInodes != 2 {
+		t.Errorf("expected %d used inodes, got %d", 2, usedInodes)
+	}
+
+	// Generic quota reports projects nobody uses as neither limited nor used.
+	limitBytes, limitInodes, err = el.GetLimit(projectID + 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if limitBytes != 0 || limitInodes != 0 {
+		t.Errorf("expected unknown project not to be limited, got %d bytes and %d inodes", limitBytes, limitInodes)
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright (c) 2023 ScyllaDB.
+
+package limit
+
+import (
+	"fmt"
+)
+
+const (
+	// maxAllocationAttempts bounds how many candidates rejected by the filesystem are tried by a single allocation.
+	maxAllocationAttempts = 1000
+)
+
+// IDAllocator hands out limit IDs sequentially, skipping IDs which are in use.
+// It's not safe for concurrent use, limiters serialize access to it.
+type IDAllocator struct {
+	used map[uint32]struct{}
+	last uint32
+}
+
+func NewIDAllocator() *IDAllocator {
+	return &IDAllocator{
+		used: map[uint32]struct{}{},
+	}
+}
+
+// Reserve marks an already existing ID as used. Allocation continues after the highest reserved ID.
+func (a *IDAllocator) Reserve(id uint32) {
+	a.used[id] = struct{}{}
+	a.last = max(a.last, id)
+}
+
+// Allocate returns the next ID which isn't used and is reported as free by isFree, and marks it as used.
+// ID 0 is never allocated, it's the default project of every inode.
+func (a *IDAllocator) Allocate(isFree func(id uint32) (bool, error)) (uint32, error) {
+	id := a.last
+	for attempts := 0; attempts < maxAllocationAttempts; {
+		id++
+		if id == 0 {
+			continue
+		}
+
+		if _, ok := a.used[id]; ok {
+			continue
+		}
+
+		free, err := isFree(id)
+		if err != nil {
+			return 0, fmt.Errorf("can't check whether id %d is free: %w", id, err)
+		}
+
+		attempts++
+		if !free {
+			continue
+		}
+
+		a.used[id] = struct{}{}
+		a.last = id
+
+		return id, nil
+	}
+
+	a.last = id
+
+	return 0, fmt.Errorf("unable to find a free ID within %d attempts", maxAllocationAttempts)
+}
+
+// Release marks the ID as no longer used.
+func (a *IDAllocator) Release(id uint32) {
+	delete(a.used, id)
+}
@@ -0,0 +1,174 @@
+// Copyright (c) 2023 ScyllaDB.
+
+package limit
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"testing"
+)
+
+func alwaysFree(uint32) (bool, error) {
+	return true, nil
+}
+
+func TestIDAllocatorAllocatesUniqueIDs(t *testing.T) {
+	t.Parallel()
+
+	const reservedID = 42
+
+	a := NewIDAllocator()
+	a.Reserve(reservedID)
+
+	// Every other ID is reported as used by someone else on the filesystem.
+	isFree := func(id uint32) (bool, error) {
+		return id%2 == 0, nil
+	}
+
+	allocated := map[uint32]struct{}{}
+	for i := 0; i < 5000; i++ {
+		id, err := a.Allocate(isFree)
+		if err != nil {
+			t.Fatalf("allocation #%d: %v", i, err)
+		}
+
+		if id == 0 || id == reservedID || id%2 != 0 {
+			t.Fatalf("allocation #%d: got ID %d which isn't free", i, id)
+		}
+
+		if _, ok := allocated[id]; ok {
+			t.Fatalf("allocation #%d: got duplicate ID %d", i, id)
+		}
+		allocated[id] = struct{}{}
+	}
+}
+
+func TestIDAllocatorReusesReleasedIDsAfterWrapAround(t *testing.T) {
+	t.Parallel()
+
+	a := NewIDAllocator()
+	a.Reserve(1)
+	a.Reserve(math.MaxUint32 - 1)
+	a.Release(1)
+
+	expectedIDs := []uint32{math.MaxUint32, 1, 2}
+	for _, expectedID := range expectedIDs {
+		id, err := a.Allocate(alwaysFree)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id != expectedID {
+			t.Errorf("expected ID %d, got %d", expectedID, id)
+		}
+	}
+}
+
+func TestIDAllocatorFailures(t *testing.T) {
+	t.Parallel()
+
+	tt := []struct {
+		name           string
+		isFree         func(uint32) (bool, error)
+		expectedNextID uint32
+	}{
+		{
+			name: "no free ID within attempts",
+			isFree: func(uint32) (bool, error) {
+				return false, nil
+			},
+			expectedNextID: maxAllocationAttempts + 1,
+		},
+		{
+			name: "checking whether ID is free fails",
+			isFree: func(uint32) (bool, error) {
+				return false, fmt.Errorf("quotactl failed")
+			},
+			expectedNextID: 1,
+		},
+	}
+
+	for i := range tt {
+		tc := tt[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			a := NewIDAllocator()
+			_, err := a.Allocate(tc.isFree)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+
+			// IDs reported as used aren't tried again, those which couldn't be checked are.
+			id, err := a.Allocate(alwaysFree)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if id != tc.expectedNextID {
+				t.Errorf("expected ID %d, got %d", tc.expectedNextID, id)
+			}
+		})
+	}
+}
+
+// BenchmarkIDAllocation compares allocating IDs from the in-memory set with probing random IDs on the filesystem,
+// the way project IDs used to be allocated, on a node with 10k existing volumes. Every filesystem check stands
+// for a quotactl call, their number per allocation is reported as checks/op.
+func BenchmarkIDAllocation(b *testing.B) {
+	const existingVolumes = 10000
+
+	newFilesystem := func() (map[uint32]struct{}, *IDAllocator) {
+		used := make(map[uint32]struct{}, existingVolumes)
+		a := NewIDAllocator()
+		for id := uint32(1); id <= existingVolumes; id++ {
+			used[id] = struct{}{}
+			a.Reserve(id)
+		}
+		return used, a
+	}
+
+	b.Run("allocator", func(b *testing.B) {
+		used, a := newFilesystem()
+		checks := 0
+		isFree := func(id uint32) (bool, error) {
+			checks++
+			_, ok := used[id]
+			return !ok, nil
+		}
+
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			id, err := a.Allocate(isFree)
+			if err != nil {
+				b.Fatal(err)
+			}
+			used[id] = struct{}{}
+		}
+
+		b.ReportMetric(float64(checks)/float64(b.N), "checks/op")
+	})
+
+	b.Run("random probe", func(b *testing.B) {
+		used, _ := newFilesystem()
+		rng := rand.New(rand.NewPCG(1, 2))
+		checks := 0
+
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			for {
+				id := rng.Uint32()
+				if id == 0 {
+					continue
+				}
+
+				checks++
+				if _, ok := used[id]; !ok {
+					used[id] = struct{}{}
+					break
+				}
+			}
+		}
+
+		b.ReportMetric(float64(checks)/float64(b.N), "checks/op")
+	})
+}
@@ -2,19 +2,93 @@
 
 package limit
 
-import "math"
+import (
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"strings"
+)
 
 const (
 	MaxLimits = math.MaxUint32 - 1
 )
 
+// RestoreError is returned together with a usable limiter when limits of some volumes couldn't be restored.
+// Limits of the other volumes are restored and enforced.
+type RestoreError struct {
+	// Failures holds errors of restoring limits keyed by volume ID.
+	Failures map[string]error
+}
+
+func (e *RestoreError) Error() string {
+	var msgs []string
+	for _, volID := range slices.Sorted(maps.Keys(e.Failures)) {
+		msgs = append(msgs, fmt.Sprintf("volume %q: %v", volID, e.Failures[volID]))
+	}
+
+	return fmt.Sprintf("can't restore limits of %d volumes: %s", len(e.Failures), strings.Join(msgs, ", "))
+}
+
+// EnforcementMode describes how limits restrict usage of a volume.
+type EnforcementMode string
+
+const (
+	// HardEnforcement fails writes exceeding the limit.
+	HardEnforcement EnforcementMode = "hard"
+	// SoftEnforcement only accounts usage exceeding the limit, writes don't fail until a grace period passes.
+	SoftEnforcement EnforcementMode = "soft"
+	// NoEnforcement doesn't restrict usage at all.
+	NoEnforcement EnforcementMode = "none"
+)
+
+// QuotaStats is the project quota accounting of a filesystem as reported by the kernel.
+type QuotaStats struct {
+	// Projects is the number of projects having a limit set, excluding the default project.
+	Projects int `json:"projects"`
+	// LimitedBytes is the sum of block limits of the projects.
+	LimitedBytes int64 `json:"limitedBytes"`
+	// UsedBytes is the sum of space accounted to the projects.
+	UsedBytes int64 `json:"usedBytes"`
+	// IncoreQuotas is the number of quota structures the kernel keeps in memory.
+	IncoreQuotas uint32 `json:"incoreQuotas"`
+}
+
 type Limiter interface {
 	// NewLimit creates a new limit on provided directory path.
 	NewLimit(directory string) (uint32, error)
 
-	// SetLimit sets new limit of capacityBytes on provided limitID.
-	SetLimit(limitID uint32, capacityBytes int64) error
+	// SetLimit sets new limit of capacityBytes and inodeLimit inodes on provided limitID.
+	// Zero inodeLimit means the number of inodes isn't limited.
+	SetLimit(limitID uint32, capacityBytes int64, inodeLimit uint64) error
+
+	// GetLimit returns the capacity in bytes and the number of inodes limitID allows, zero means unlimited.
+	GetLimit(limitID uint32) (capacityBytes int64, inodeLimit uint64, err error)
+
+	// GetUsage returns the bytes and the number of inodes accounted to limitID.
+	GetUsage(limitID uint32) (usedBytes int64, usedInodes uint64, err error)
+
+	// GetLimitID returns ID of the limit set on provided directory path, or zero when there is none.
+	GetLimitID(directory string) (uint32, error)
 
 	// RemoveLimit removes a limit having limitID.
 	RemoveLimit(limitID uint32) error
+
+	// DiscardLimit undoes NewLimit which created limitID on provided directory path, when the limit isn't going to
+	// be used. The directory is detached from it and its ID is released, but the limit itself is left in place,
+	// as it may belong to someone else.
+	DiscardLimit(limitID uint32, directory string) error
+
+	// EnforcementMode returns how limits set by the limiter are enforced.
+	EnforcementMode() EnforcementMode
+
+	// ListLimitIDs returns IDs of all limits set on the filesystem, including those not created by the limiter.
+	ListLimitIDs() ([]uint32, error)
+
+	// QuotaStats returns the quota accounting of the filesystem, or nil when it doesn't account quotas.
+	QuotaStats() (*QuotaStats, error)
+
+	// Close releases resources held by the limiter. It's called once on shutdown,
+	// after no more limits are going to be managed.
+	Close() error
 }
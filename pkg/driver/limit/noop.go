@@ -11,10 +11,42 @@ func (l *NoopLimiter) NewLimit(directory string) (uint32, error) {
 	return 0, nil
 }
 
-func (l *NoopLimiter) SetLimit(limitID uint32, capacityBytes int64) error {
+func (l *NoopLimiter) SetLimit(limitID uint32, capacityBytes int64, inodeLimit uint64) error {
 	return nil
 }
 
+func (l *NoopLimiter) GetLimit(limitID uint32) (int64, uint64, error) {
+	return 0, 0, nil
+}
+
+func (l *NoopLimiter) GetUsage(limitID uint32) (int64, uint64, error) {
+	return 0, 0, nil
+}
+
+func (l *NoopLimiter) GetLimitID(directory string) (uint32, error) {
+	return 0, nil
+}
+
 func (l *NoopLimiter) RemoveLimit(limitID uint32) error {
 	return nil
 }
+
+func (l *NoopLimiter) DiscardLimit(limitID uint32, directory string) error {
+	return nil
+}
+
+func (l *NoopLimiter) EnforcementMode() EnforcementMode {
+	return NoEnforcement
+}
+
+func (l *NoopLimiter) ListLimitIDs() ([]uint32, error) {
+	return nil, nil
+}
+
+func (l *NoopLimiter) QuotaStats() (*QuotaStats, error) {
+	return nil, nil
+}
+
+func (l *NoopLimiter) Close() error {
+	return nil
+}
@@ -93,3 +93,40 @@ func SetProjectID(file *os.File, projectID uint32) error {
 
 	return nil
 }
+
+// ClearProjectID moves the file back to the default project and stops files created in it from inheriting
+// the project.
+func ClearProjectID(file *os.File) error {
+	fxattrs, err := Get(file)
+	if err != nil {
+		return fmt.Errorf("can't get file attributes of %q: %w", file.Name(), err)
+	}
+
+	fxattrs.ProjectID = 0
+	fxattrs.Flags &^= FlagProjectInherit
+
+	err = Set(file, fxattrs)
+	if err != nil {
+		return fmt.Errorf("can't set file attributes on %q: %w", file.Name(), err)
+	}
+
+	return nil
+}
+
+// SetExtentSizeHint sets the extent size hint of a directory, which is inherited by files created in it.
+func SetExtentSizeHint(file *os.File, extentSize uint32) error {
+	fxattrs, err := Get(file)
+	if err != nil {
+		return fmt.Errorf("can't get file attributes of %q: %w", file.Name(), err)
+	}
+
+	fxattrs.ExtentSize = extentSize
+	fxattrs.Flags |= FlagExtentSizeInherit
+
+	err = Set(file, fxattrs)
+	if err != nil {
+		return fmt.Errorf("can't set file attributes on %q: %w", file.Name(), err)
+	}
+
+	return nil
+}
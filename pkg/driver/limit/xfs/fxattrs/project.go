@@ -0,0 +1,93 @@
+// Copyright (c) 2023 ScyllaDB.
+
+package fxattrs
+
+import (
+	"fmt"
+	iofs "io/fs"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/unix"
+	"k8s.io/klog/v2"
+)
+
+// DetachProject clears the project of the directory and of directories and regular files within it, unless the
+// directory was already removed or reassigned to another project. Files of other projects are left alone.
+func DetachProject(directory string, projectID uint32) error {
+	currentID, err := GetProjectIDOf(directory)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	if currentID != projectID {
+		return nil
+	}
+
+	err = filepath.WalkDir(directory, func(p string, d iofs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		// Opening anything else could block, like fifos, or have side effects, like devices.
+		if !d.IsDir() && !d.Type().IsRegular() {
+			return nil
+		}
+
+		return ClearProjectIDOf(p, projectID)
+	})
+	if err != nil {
+		return fmt.Errorf("can't detach project %d from %q directory: %w", projectID, directory, err)
+	}
+
+	return nil
+}
+
+// GetProjectIDOf returns the project of the file at path, without following symlinks.
+func GetProjectIDOf(path string) (uint32, error) {
+	f, err := os.OpenFile(path, os.O_RDONLY|unix.O_NOFOLLOW, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		closeErr := f.Close()
+		if closeErr != nil {
+			klog.ErrorS(closeErr, "Failed to close file", "path", path)
+		}
+	}()
+
+	projectID, err := GetProjectID(f)
+	if err != nil {
+		return 0, fmt.Errorf("can't determine project ID of %q: %w", path, err)
+	}
+
+	return projectID, nil
+}
+
+// ClearProjectIDOf moves the file to the default project, when it's in the project.
+func ClearProjectIDOf(path string, projectID uint32) error {
+	f, err := os.OpenFile(path, os.O_RDONLY|unix.O_NOFOLLOW, 0)
+	if err != nil {
+		return fmt.Errorf("can't open %q: %w", path, err)
+	}
+	defer func() {
+		closeErr := f.Close()
+		if closeErr != nil {
+			klog.ErrorS(closeErr, "Failed to close file", "path", path)
+		}
+	}()
+
+	currentID, err := GetProjectID(f)
+	if err != nil {
+		return fmt.Errorf("can't determine project ID of %q: %w", path, err)
+	}
+
+	if currentID != projectID {
+		return nil
+	}
+
+	return ClearProjectID(f)
+}
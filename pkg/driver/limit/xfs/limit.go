@@ -4,7 +4,7 @@ package xfs
 
 import (
 	"fmt"
-	"math/rand"
+	"math"
 	"os"
 	"path"
 	"sync"
@@ -17,16 +17,20 @@ import (
 	"github.com/scylladb/local-csi-driver/pkg/util/fs"
 	"github.com/scylladb/local-csi-driver/pkg/util/slices"
 	"k8s.io/klog/v2"
-	"k8s.io/mount-utils"
 )
 
 type xfsLimiter struct {
 	volumesDir string
 	mut        sync.Mutex
+	projectIDs *limit.IDAllocator
+	// projectDirs maps project IDs to directories they were set on, so removed projects can be detached from them.
+	projectDirs map[uint32]string
 }
 
 var _ limit.Limiter = &xfsLimiter{}
 
+// NewXFSLimiter returns a limiter of the volumes dir restoring limits of the volumes. When some of them can't be
+// restored, the limiter is returned together with *limit.RestoreError.
 func NewXFSLimiter(volumesDir string, volumes []volume.VolumeState) (*xfsLimiter, error) {
 	volumesDir = path.Clean(volumesDir)
 
@@ -39,7 +43,7 @@ func NewXFSLimiter(volumesDir string, volumes []volume.VolumeState) (*xfsLimiter
 		return nil, fmt.Errorf("volumes path %q is not XFS filesystem", volumesDir)
 	}
 
-	entry, err := getMountEntry(volumesDir)
+	entry, err := fs.GetBackingMountEntry(volumesDir)
 	if err != nil {
 		return nil, fmt.Errorf("can't get mount entry of %q: %w", volumesDir, err)
 	}
@@ -52,17 +56,34 @@ func NewXFSLimiter(volumesDir string, volumes []volume.VolumeState) (*xfsLimiter
 		return nil, fmt.Errorf("xfs path %q was not mounted with pquota nor prjquota - opts: %q", volumesDir, entry.Opts)
 	}
 
+	quotaStat, err := quotactl.GetQuotaStat(volumesDir)
+	if err != nil {
+		return nil, fmt.Errorf("can't get quota state of device %q backing %q: %w", entry.Device, volumesDir, err)
+	}
+
+	if !quotaStat.ProjectQuotaEnforced() {
+		return nil, fmt.Errorf("device %q backing %q doesn't enforce project quota", entry.Device, volumesDir)
+	}
+
 	xl := &xfsLimiter{
-		volumesDir: volumesDir,
+		volumesDir:  volumesDir,
+		projectIDs:  limit.NewIDAllocator(),
+		projectDirs: map[uint32]string{},
 	}
 
+	// Volumes are restored independently, so a single broken one doesn't leave others unenforced.
+	restoreErr := &limit.RestoreError{Failures: map[string]error{}}
 	for _, v := range volumes {
 		err = xl.restoreVolumeQuota(v)
 		if err != nil {
-			return nil, err
+			restoreErr.Failures[v.ID] = err
 		}
 	}
 
+	if len(restoreErr.Failures) != 0 {
+		return xl, restoreErr
+	}
+
 	return xl, nil
 }
 
@@ -88,7 +109,12 @@ func (xl *xfsLimiter) restoreVolumeQuota(v volume.VolumeState) error {
 		return fmt.Errorf("found tempered directory %q, expected %d project ID, got %d", volumePath, v.LimitID, projectID)
 	}
 
-	err = xl.SetLimit(v.LimitID, v.Size)
+	xl.projectIDs.Reserve(v.LimitID)
+	xl.mut.Lock()
+	xl.projectDirs[v.LimitID] = volumePath
+	xl.mut.Unlock()
+
+	err = xl.SetLimit(v.LimitID, v.Size, v.InodeLimit)
 	if err != nil {
 		return fmt.Errorf("error restoring quota for volume %q: %w", v.ID, err)
 	}
@@ -101,7 +127,7 @@ func (xl *xfsLimiter) NewLimit(directory string) (uint32, error) {
 	defer xl.mut.Unlock()
 
 	klog.V(4).InfoS("Generating project ID")
-	projectID, err := findFreeProjectID(xl.volumesDir)
+	projectID, err := xl.projectIDs.Allocate(xl.isProjectIDFree)
 	if err != nil {
 		return 0, fmt.Errorf("can't generate project ID: %w", err)
 	}
@@ -119,24 +145,27 @@ func (xl *xfsLimiter) NewLimit(directory string) (uint32, error) {
 
 	err = fxattrs.SetProjectID(v, projectID)
 	if err != nil {
+		xl.projectIDs.Release(projectID)
 		return 0, fmt.Errorf("can't set quota properties on %q directory: %w", directory, err)
 	}
+	xl.projectDirs[projectID] = directory
 
 	return projectID, nil
 }
 
-func (xl *xfsLimiter) SetLimit(projectID uint32, capacityBytes int64) error {
+func (xl *xfsLimiter) SetLimit(projectID uint32, capacityBytes int64, inodeLimit uint64) error {
 	xl.mut.Lock()
 	defer xl.mut.Unlock()
 
-	klog.V(4).InfoS("Setting project", "projectID", projectID, "capacity", capacityBytes)
+	klog.V(4).InfoS("Setting project", "projectID", projectID, "capacity", capacityBytes, "inodeLimit", inodeLimit)
 
 	err := quotactl.SetQuota(xl.volumesDir, quotactl.QuotaTypeProject, &quotactl.DiskQuota{
-		Version:      quotactl.FS_DQUOT_VERSION,
-		ID:           projectID,
-		Flags:        int8(quotactl.QuotaTypeProject),
-		FieldMask:    quotactl.FS_DQ_BHARD,
-		BlkHardLimit: bytesToBlocks(capacityBytes),
+		Version:        quotactl.FS_DQUOT_VERSION,
+		ID:             projectID,
+		Flags:          int8(quotactl.QuotaTypeProject),
+		FieldMask:      quotactl.FS_DQ_BHARD | quotactl.FS_DQ_IHARD,
+		BlkHardLimit:   bytesToBlocks(capacityBytes),
+		InodeHardLimit: inodeLimit,
 	})
 	if err != nil {
 		return fmt.Errorf("can't set quota on %d projectID: %w", projectID, err)
@@ -145,42 +174,195 @@ func (xl *xfsLimiter) SetLimit(projectID uint32, capacityBytes int64) error {
 	return nil
 }
 
-func (xl *xfsLimiter) RemoveLimit(limitID uint32) error {
-	return xl.SetLimit(limitID, 0)
+// GetLimit returns zeroes for projects XFS doesn't know, they aren't limited.
+func (xl *xfsLimiter) GetLimit(limitID uint32) (int64, uint64, error) {
+	quota, err := xl.getQuota(limitID)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return blocksToBytes(quota.BlkHardLimit), quota.InodeHardLimit, nil
 }
 
-// XFS Quota block units are in BBs (Basic Blocks) of 512 bytes.
-func bytesToBlocks(capacity int64) uint64 {
-	return uint64(capacity >> 9)
+// GetUsage returns zeroes for projects XFS doesn't know, they don't account any usage.
+func (xl *xfsLimiter) GetUsage(limitID uint32) (int64, uint64, error) {
+	quota, err := xl.getQuota(limitID)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return blocksToBytes(quota.BlocksCount), quota.InodeCount, nil
 }
 
-func getMountEntry(mountPoint string) (mount.MountPoint, error) {
-	entries, err := mount.New("").List()
+func (xl *xfsLimiter) getQuota(projectID uint32) (*quotactl.DiskQuota, error) {
+	quota, err := quotactl.GetQuota(xl.volumesDir, quotactl.QuotaTypeProject, projectID)
 	if err != nil {
-		return mount.MountPoint{}, fmt.Errorf("can't list mount points at %q: %w", mountPoint, err)
+		if errors.Is(err, quotactl.IDNotFoundErr) {
+			return &quotactl.DiskQuota{ID: projectID}, nil
+		}
+		return nil, fmt.Errorf("can't get quota for id %d: %w", projectID, err)
 	}
 
-	for _, e := range entries {
-		if e.Path == mountPoint {
-			return e, nil
+	return quota, nil
+}
+
+func (xl *xfsLimiter) GetLimitID(directory string) (uint32, error) {
+	d, err := os.Open(directory)
+	if err != nil {
+		return 0, fmt.Errorf("can't open path %q: %w", directory, err)
+	}
+	defer func() {
+		closeErr := d.Close()
+		if closeErr != nil {
+			klog.ErrorS(closeErr, "Failed to close directory", "directory", directory)
 		}
+	}()
+
+	projectID, err := fxattrs.GetProjectID(d)
+	if err != nil {
+		return 0, fmt.Errorf("can't determine project ID of %q: %w", directory, err)
+	}
+
+	return projectID, nil
+}
+
+// RemoveLimit zeroes limits of the project and detaches it from its directory and everything within it, when the
+// directory still exists. XFS drops a project having neither limits nor usage, so the project ID can be reused
+// without inheriting stale state. Usage which can't be detached, like of special files, keeps the project ID reserved.
+// Directories of projects not created nor restored by this limiter, like leaked ones, aren't known, so only their
+// limits are zeroed.
+func (xl *xfsLimiter) RemoveLimit(limitID uint32) error {
+	err := xl.SetLimit(limitID, 0, 0)
+	if err != nil {
+		return err
+	}
+
+	xl.mut.Lock()
+	defer xl.mut.Unlock()
+
+	directory, ok := xl.projectDirs[limitID]
+	if ok {
+		err = fxattrs.DetachProject(directory, limitID)
+		if err != nil {
+			return err
+		}
+		delete(xl.projectDirs, limitID)
+	}
+
+	quota, err := quotactl.GetQuota(xl.volumesDir, quotactl.QuotaTypeProject, limitID)
+	if err != nil && !errors.Is(err, quotactl.IDNotFoundErr) {
+		return fmt.Errorf("can't get quota for id %d: %w", limitID, err)
+	}
+	if err == nil && (quota.BlocksCount != 0 || quota.InodeCount != 0) {
+		klog.InfoS("Keeping project ID reserved, it's still used", "projectID", limitID, "blocks", quota.BlocksCount, "inodes", quota.InodeCount)
+		return nil
+	}
+
+	xl.projectIDs.Release(limitID)
+
+	return nil
+}
+
+func (xl *xfsLimiter) DiscardLimit(limitID uint32, directory string) error {
+	xl.mut.Lock()
+	defer xl.mut.Unlock()
+
+	err := fxattrs.ClearProjectIDOf(directory, limitID)
+	if err != nil {
+		return fmt.Errorf("can't detach project %d from %q directory: %w", limitID, directory, err)
 	}
 
-	return mount.MountPoint{}, fmt.Errorf("mount entry for mountPoint %q not found", mountPoint)
+	if xl.projectDirs[limitID] == directory {
+		delete(xl.projectDirs, limitID)
+	}
+	xl.projectIDs.Release(limitID)
+
+	return nil
+}
+
+// EnforcementMode is hard, as limits are set as hard quotas.
+func (xl *xfsLimiter) EnforcementMode() limit.EnforcementMode {
+	return limit.HardEnforcement
 }
 
-func findFreeProjectID(volumesDir string) (uint32, error) {
-	const maxRetries = 1000
-	for retries := 0; retries < maxRetries; retries++ {
-		id := rand.Uint32()
-		_, err := quotactl.GetQuota(volumesDir, quotactl.QuotaTypeProject, id)
+func (xl *xfsLimiter) ListLimitIDs() ([]uint32, error) {
+	var ids []uint32
+	for id := uint32(0); ; id++ {
+		quota, err := quotactl.GetNextQuota(xl.volumesDir, quotactl.QuotaTypeProject, id)
 		if err != nil {
 			if errors.Is(err, quotactl.IDNotFoundErr) {
-				return id, nil
+				return ids, nil
 			}
-			return 0, fmt.Errorf("can't get quota for id %d: %w", id, err)
+			return nil, fmt.Errorf("can't get next quota after id %d: %w", id, err)
+		}
+
+		// Project ID 0 is the default project of every inode, it's never a limit.
+		if quota.ID != 0 && (quota.BlkHardLimit != 0 || quota.InodeHardLimit != 0) {
+			ids = append(ids, quota.ID)
+		}
+
+		id = quota.ID
+		if id == math.MaxUint32 {
+			return ids, nil
+		}
+	}
+}
+
+func (xl *xfsLimiter) QuotaStats() (*limit.QuotaStats, error) {
+	quotaStat, err := quotactl.GetQuotaStat(xl.volumesDir)
+	if err != nil {
+		return nil, fmt.Errorf("can't get quota state: %w", err)
+	}
+
+	stats := &limit.QuotaStats{
+		IncoreQuotas: quotaStat.IncoreDquots,
+	}
+	for id := uint32(0); ; id++ {
+		quota, err := quotactl.GetNextQuota(xl.volumesDir, quotactl.QuotaTypeProject, id)
+		if err != nil {
+			if errors.Is(err, quotactl.IDNotFoundErr) {
+				return stats, nil
+			}
+			return nil, fmt.Errorf("can't get next quota after id %d: %w", id, err)
+		}
+
+		if quota.ID != 0 && (quota.BlkHardLimit != 0 || quota.InodeHardLimit != 0) {
+			stats.Projects++
+			stats.LimitedBytes += blocksToBytes(quota.BlkHardLimit)
+			stats.UsedBytes += blocksToBytes(quota.BlocksCount)
+		}
+
+		id = quota.ID
+		if id == math.MaxUint32 {
+			return stats, nil
+		}
+	}
+}
+
+// Close is a no-op, xfs limiter doesn't keep any resources open in between calls.
+func (xl *xfsLimiter) Close() error {
+	return nil
+}
+
+// XFS Quota block units are in BBs (Basic Blocks) of 512 bytes.
+// Capacity is rounded up, so the volume can always use at least the requested bytes.
+func bytesToBlocks(capacity int64) uint64 {
+	return (uint64(capacity) + 511) >> 9
+}
+
+func blocksToBytes(blocks uint64) int64 {
+	return int64(blocks << 9)
+}
+
+// isProjectIDFree returns whether the project ID isn't used by anyone else on the filesystem.
+func (xl *xfsLimiter) isProjectIDFree(id uint32) (bool, error) {
+	_, err := quotactl.GetQuota(xl.volumesDir, quotactl.QuotaTypeProject, id)
+	if err != nil {
+		if errors.Is(err, quotactl.IDNotFoundErr) {
+			return true, nil
 		}
+		return false, fmt.Errorf("can't get quota for id %d: %w", id, err)
 	}
 
-	return 0, fmt.Errorf("unable to generate free project ID with %d retries", maxRetries)
+	return false, nil
 }
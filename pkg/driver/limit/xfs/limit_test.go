@@ -0,0 +1,405 @@
+// Copyright (c) 2023 ScyllaDB.
+
+package xfs
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/scylladb/local-csi-driver/pkg/driver/limit/xfs/fxattrs"
+	"github.com/scylladb/local-csi-driver/pkg/driver/limit/xfs/quotactl"
+	"github.com/scylladb/local-csi-driver/pkg/util/fs"
+	"golang.org/x/sys/unix"
+)
+
+// mountProjectQuotaXFS mounts a new XFS filesystem enforcing project quota at a temporary directory.
+func mountProjectQuotaXFS(t *testing.T) string {
+	t.Helper()
+
+	if os.Geteuid() != 0 {
+		t.Skip("mounting filesystems requires root")
+	}
+
+	mkfs, err := exec.LookPath("mkfs.xfs")
+	if err != nil {
+		t.Skipf("mkfs.xfs isn't available: %v", err)
+	}
+
+	image := filepath.Join(t.TempDir(), "image")
+	err = os.WriteFile(image, nil, 0660)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 300MiB is the minimal size of XFS filesystem.
+	err = os.Truncate(image, 300<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := exec.Command(mkfs, image).CombinedOutput()
+	if err != nil {
+		t.Fatalf("can't create xfs filesystem: %v, output: %s", err, out)
+	}
+
+	device, err := fs.AttachLoopDevice(image)
+	if err != nil {
+		t.Skipf("can't attach loop device: %v", err)
+	}
+	t.Cleanup(func() {
+		err := fs.DetachLoopDevices(image)
+		if err != nil {
+			t.Error(err)
+		}
+	})
+
+	mountPoint := filepath.Join(t.TempDir(), "mnt")
+	err = os.Mkdir(mountPoint, 0770)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = unix.Mount(device, mountPoint, "xfs", 0, "prjquota")
+	if err != nil {
+		t.Skipf("can't mount xfs filesystem with project quota: %v", err)
+	}
+	t.Cleanup(func() {
+		err := unix.Unmount(mountPoint, 0)
+		if err != nil {
+			t.Error(err)
+		}
+	})
+
+	return mountPoint
+}
+
+func TestBytesToBlocks(t *testing.T) {
+	t.Parallel()
+
+	tt := []struct {
+		name           string
+		capacity       int64
+		expectedBlocks uint64
+	}{
+		{
+			name:           "zero",
+			capacity:       0,
+			expectedBlocks: 0,
+		},
+		{
+			name:           "sub-block size is rounded up to a whole block",
+			capacity:       1000,
+			expectedBlocks: 2,
+		},
+		{
+			name:           "block aligned size",
+			capacity:       1024,
+			expectedBlocks: 2,
+		},
+		{
+			name:           "non-block-aligned size is rounded up",
+			capacity:       1024*1024 + 1,
+			expectedBlocks: 2049,
+		},
+	}
+
+	for i := range tt {
+		tc := tt[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			blocks := bytesToBlocks(tc.capacity)
+			if blocks != tc.expectedBlocks {
+				t.Errorf("expected %d blocks, got %d", tc.expectedBlocks, blocks)
+			}
+		})
+	}
+}
+
+func TestRemoveLimitReleasesProject(t *testing.T) {
+	t.Parallel()
+
+	volumesDir := mountProjectQuotaXFS(t)
+
+	xl, err := NewXFSLimiter(volumesDir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := filepath.Join(volumesDir, "volume")
+	err = os.Mkdir(dir, 0770)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	projectID, err := xl.NewLimit(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = xl.SetLimit(projectID, 1<<20, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = quotactl.GetQuota(volumesDir, quotactl.QuotaTypeProject, projectID)
+	if err != nil {
+		t.Fatalf("expected project %d to exist, got %v", projectID, err)
+	}
+
+	err = xl.RemoveLimit(projectID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = quotactl.GetQuota(volumesDir, quotactl.QuotaTypeProject, projectID)
+	if !errors.Is(err, quotactl.IDNotFoundErr) {
+		t.Errorf("expected %v getting quota of removed project, got %v", quotactl.IDNotFoundErr, err)
+	}
+
+	d, err := os.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	attrs, err := fxattrs.Get(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if attrs.ProjectID != 0 || attrs.Flags&fxattrs.FlagProjectInherit != 0 {
+		t.Errorf("expected directory to be detached from project, got project ID %d and flags %#x", attrs.ProjectID, attrs.Flags)
+	}
+
+	ids, err := xl.ListLimitIDs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 0 {
+		t.Errorf("expected no limits, got %v", ids)
+	}
+}
+
+func TestRemoveLimitDetachesProjectFromDirectoryContent(t *testing.T) {
+	t.Parallel()
+
+	volumesDir := mountProjectQuotaXFS(t)
+
+	xl, err := NewXFSLimiter(volumesDir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := filepath.Join(volumesDir, "volume")
+	err = os.Mkdir(dir, 0770)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	projectID, err := xl.NewLimit(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = xl.SetLimit(projectID, 16<<20, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Files created within the directory inherit its project.
+	nestedFile := filepath.Join(dir, "nested", "data")
+	err = os.Mkdir(filepath.Dir(nestedFile), 0770)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(nestedFile, make([]byte, 1<<20), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = xl.RemoveLimit(projectID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = quotactl.GetQuota(volumesDir, quotactl.QuotaTypeProject, projectID)
+	if !errors.Is(err, quotactl.IDNotFoundErr) {
+		t.Errorf("expected %v getting quota of removed project with content, got %v", quotactl.IDNotFoundErr, err)
+	}
+
+	for _, p := range []string{dir, filepath.Dir(nestedFile), nestedFile} {
+		gotID, err := fxattrs.GetProjectIDOf(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if gotID != 0 {
+			t.Errorf("expected %q to be detached from project, got project ID %d", p, gotID)
+		}
+	}
+}
+
+func TestRemoveLimitKeepsProjectWithRemainingUsageReserved(t *testing.T) {
+	t.Parallel()
+
+	volumesDir := mountProjectQuotaXFS(t)
+
+	xl, err := NewXFSLimiter(volumesDir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := filepath.Join(volumesDir, "volume")
+	err = os.Mkdir(dir, 0770)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	projectID, err := xl.NewLimit(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Special files aren't opened to be detached, so they keep using the project.
+	err = unix.Mkfifo(filepath.Join(dir, "fifo"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = xl.RemoveLimit(projectID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	otherDir := filepath.Join(volumesDir, "other")
+	err = os.Mkdir(otherDir, 0770)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	otherProjectID, err := xl.NewLimit(otherDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if otherProjectID == projectID {
+		t.Errorf("expected project %d still having usage not to be reused", projectID)
+	}
+}
+
+func TestDiscardLimitLeavesLimitInPlace(t *testing.T) {
+	t.Parallel()
+
+	volumesDir := mountProjectQuotaXFS(t)
+
+	xl, err := NewXFSLimiter(volumesDir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := filepath.Join(volumesDir, "volume")
+	err = os.Mkdir(dir, 0770)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	projectID, err := xl.NewLimit(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = xl.SetLimit(projectID, 1<<20, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = xl.DiscardLimit(projectID, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if d, ok := xl.projectDirs[projectID]; ok {
+		t.Errorf("expected discarded project %d not to be tracked, got directory %q", projectID, d)
+	}
+
+	gotID, err := fxattrs.GetProjectIDOf(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotID != 0 {
+		t.Errorf("expected directory to be detached from project, got project ID %d", gotID)
+	}
+
+	quota, err := quotactl.GetQuota(volumesDir, quotactl.QuotaTypeProject, projectID)
+	if err != nil {
+		t.Fatalf("expected discarded project %d to be left in place, got %v", projectID, err)
+	}
+	if quota.BlkHardLimit != bytesToBlocks(1<<20) {
+		t.Errorf("expected block limit %d to be left in place, got %d", bytesToBlocks(1<<20), quota.BlkHardLimit)
+	}
+}
+
+func TestGetLimitAndUsage(t *testing.T) {
+	t.Parallel()
+
+	const capacity = 16 << 20
+
+	volumesDir := mountProjectQuotaXFS(t)
+
+	xl, err := NewXFSLimiter(volumesDir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := filepath.Join(volumesDir, "volume")
+	err = os.Mkdir(dir, 0770)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	projectID, err := xl.NewLimit(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = xl.SetLimit(projectID, capacity, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.WriteFile(filepath.Join(dir, "data"), make([]byte, 1<<20), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	limitBytes, limitInodes, err := xl.GetLimit(projectID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if limitBytes != capacity || limitInodes != 100 {
+		t.Errorf("expected limit of %d bytes and %d inodes, got %d bytes and %d inodes", capacity, 100, limitBytes, limitInodes)
+	}
+
+	usedBytes, usedInodes, err := xl.GetUsage(projectID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usedBytes < 1<<20 || usedBytes > capacity {
+		t.Errorf("expected usage of at least %d bytes, got %d", 1<<20, usedBytes)
+	}
+	if usedInodes != 2 {
+		t.Errorf("expected %d used inodes, got %d", 2, usedInodes)
+	}
+
+	// Projects XFS doesn't know are neither limited nor used.
+	limitBytes, limitInodes, err = xl.GetLimit(projectID + 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if limitBytes != 0 || limitInodes != 0 {
+		t.Errorf("expected unknown project not to be limited, got %d bytes and %d inodes", limitBytes, limitInodes)
+	}
+}
@@ -3,13 +3,15 @@
 package quotactl
 
 import (
+	"bytes"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"syscall"
 	"unsafe"
 
+	"github.com/scylladb/local-csi-driver/pkg/util/fs"
 	"golang.org/x/sys/unix"
-	"k8s.io/mount-utils"
 )
 
 type QuotaType uint
@@ -58,8 +60,65 @@ const (
 	FS_DQ_ICOUNT     = 1 << 13
 	FS_DQ_RTBCOUNT   = 1 << 14
 	FS_DQ_ACCT_MASK  = FS_DQ_BCOUNT | FS_DQ_ICOUNT | FS_DQ_RTBCOUNT
+
+	FS_QUOTA_UDQ_ACCT = 1 << 0
+	FS_QUOTA_UDQ_ENFD = 1 << 1
+	FS_QUOTA_GDQ_ACCT = 1 << 2
+	FS_QUOTA_GDQ_ENFD = 1 << 3
+	FS_QUOTA_PDQ_ACCT = 1 << 4
+	FS_QUOTA_PDQ_ENFD = 1 << 5
+)
+
+const (
+	// https://github.com/torvalds/linux/blob/master/include/uapi/linux/quota.h
+
+	Q_GETQUOTA     = 0x800007
+	Q_SETQUOTA     = 0x800008
+	Q_GETNEXTQUOTA = 0x800009
+
+	QIF_BLIMITS = 1 << 0
+	QIF_SPACE   = 1 << 1
+	QIF_ILIMITS = 1 << 2
+	QIF_INODES  = 1 << 3
+	QIF_BTIME   = 1 << 4
+	QIF_ITIME   = 1 << 5
+	QIF_LIMITS  = QIF_BLIMITS | QIF_ILIMITS
+	QIF_USAGE   = QIF_SPACE | QIF_INODES
+	QIF_TIMES   = QIF_BTIME | QIF_ITIME
+	QIF_ALL     = QIF_LIMITS | QIF_USAGE | QIF_TIMES
+
+	// QIF_DQBLKSIZE is the size of block units used by generic quota limits.
+	QIF_DQBLKSIZE = 1 << 10
 )
 
+// GenericDiskQuota is a generic (VFS) quota structure used by filesystems other than XFS.
+type GenericDiskQuota struct {
+	BlkHardLimit   uint64
+	BlkSoftLimit   uint64
+	CurSpace       uint64
+	InodeHardLimit uint64
+	InodeSoftLimit uint64
+	CurInodes      uint64
+	BlockTime      uint64
+	InodeTime      uint64
+	Valid          uint32
+	_              uint32
+}
+
+// GenericNextDiskQuota is a generic (VFS) quota structure returned when looking up the next ID having a quota.
+type GenericNextDiskQuota struct {
+	BlkHardLimit   uint64
+	BlkSoftLimit   uint64
+	CurSpace       uint64
+	InodeHardLimit uint64
+	InodeSoftLimit uint64
+	CurInodes      uint64
+	BlockTime      uint64
+	InodeTime      uint64
+	Valid          uint32
+	ID             uint32
+}
+
 type DiskQuota struct {
 	Version          int8
 	Flags            int8
@@ -85,6 +144,38 @@ type DiskQuota struct {
 	_                [8]byte
 }
 
+type QuotaFileStat struct {
+	Inode   uint64
+	Blocks  uint64
+	Extents uint32
+	_       uint32
+}
+
+// QuotaStat describes the state of quotas of a filesystem.
+type QuotaStat struct {
+	Version          int8
+	_                uint8
+	Flags            uint16
+	IncoreDquots     uint32
+	UserQuota        QuotaFileStat
+	GroupQuota       QuotaFileStat
+	ProjectQuota     QuotaFileStat
+	BlockTimeLimit   int32
+	InodeTimeLimit   int32
+	RTBlockTimeLimit int32
+	BlockWarnLimit   uint16
+	InodeWarnLimit   uint16
+	RTBlockWarnLimit uint16
+	_                uint16
+	_                uint32
+	_                [7]uint64
+}
+
+// ProjectQuotaEnforced returns whether project quota limits are accounted and enforced.
+func (qs *QuotaStat) ProjectQuotaEnforced() bool {
+	return qs.Flags&FS_QUOTA_PDQ_ACCT != 0 && qs.Flags&FS_QUOTA_PDQ_ENFD != 0
+}
+
 var (
 	IDNotFoundErr = errors.New("id not found")
 )
@@ -111,6 +202,29 @@ func GetQuota(fsPath string, quotaType QuotaType, id uint32) (*DiskQuota, error)
 	return &quota, nil
 }
 
+// GetNextQuota returns quota information of the first ID greater than or equal to the provided one having a quota.
+// IDNotFoundErr is returned when there is no such ID.
+func GetNextQuota(fsPath string, quotaType QuotaType, id uint32) (*DiskQuota, error) {
+	device, err := getMountDevice(fsPath)
+	if err != nil {
+		return nil, fmt.Errorf("can't get block device backing file %q: %w", fsPath, err)
+	}
+
+	quota := DiskQuota{
+		Version: FS_DQUOT_VERSION,
+	}
+
+	// https://github.com/torvalds/linux/blob/master/include/uapi/linux/dqblk_xfs.h
+	cmd := Q_XGETNEXTQUOTA | (quotaType & 0x00ff)
+
+	_, _, errno := unix.Syscall6(unix.SYS_QUOTACTL, uintptr(cmd), uintptr(unsafe.Pointer(device)), uintptr(id), uintptr(unsafe.Pointer(&quota)), 0, 0)
+	if errno != 0 {
+		return nil, transformErrno(errno)
+	}
+
+	return &quota, nil
+}
+
 // SetQuota sets disk quota limits.
 func SetQuota(fsPath string, quotaType QuotaType, dq *DiskQuota) error {
 	device, err := getMountDevice(fsPath)
@@ -129,24 +243,116 @@ func SetQuota(fsPath string, quotaType QuotaType, dq *DiskQuota) error {
 	return nil
 }
 
-func getMountDevice(mountPoint string) (*byte, error) {
-	entries, err := mount.New("").List()
+// GetQuotaStat returns the state of quotas of the filesystem backing the provided path.
+// Besides XFS, it's supported by filesystems using generic quota.
+func GetQuotaStat(fsPath string) (*QuotaStat, error) {
+	device, err := getMountDevice(fsPath)
 	if err != nil {
-		return nil, fmt.Errorf("can't list mount points at %q: %w", mountPoint, err)
+		return nil, fmt.Errorf("can't get block device backing file %q: %w", fsPath, err)
 	}
 
-	for _, e := range entries {
-		if e.Path == mountPoint {
-			deviceArg, err := unix.BytePtrFromString(e.Device)
-			if err != nil {
-				return nil, fmt.Errorf("can't create byte ptr from string %q: %w", e.Device, err)
-			}
+	// Kernel fills the buffer only up to the size of the version it's asked for.
+	buf := make([]byte, unsafe.Sizeof(QuotaStat{}))
+	buf[0] = FS_QSTATV_VERSION1
+
+	// https://github.com/torvalds/linux/blob/master/include/uapi/linux/dqblk_xfs.h
+	cmd := Q_XGETQSTATV | (QuotaTypeProject & 0x00ff)
+
+	_, _, errno := unix.Syscall6(unix.SYS_QUOTACTL, uintptr(cmd), uintptr(unsafe.Pointer(device)), 0, uintptr(unsafe.Pointer(&buf[0])), 0, 0)
+	if errno != 0 {
+		return nil, transformErrno(errno)
+	}
 
-			return deviceArg, nil
-		}
+	return parseQuotaStat(buf)
+}
+
+// parseQuotaStat decodes struct fs_quota_statv filled by the kernel.
+func parseQuotaStat(buf []byte) (*QuotaStat, error) {
+	stat := &QuotaStat{}
+	err := binary.Read(bytes.NewReader(buf), binary.NativeEndian, stat)
+	if err != nil {
+		return nil, fmt.Errorf("can't decode quota stat: %w", err)
+	}
+
+	if stat.Version != FS_QSTATV_VERSION1 {
+		return nil, fmt.Errorf("unsupported quota stat version %d", stat.Version)
+	}
+
+	return stat, nil
+}
+
+// GetGenericQuota returns generic quota information for the provided ID and quota type.
+func GetGenericQuota(fsPath string, quotaType QuotaType, id uint32) (*GenericDiskQuota, error) {
+	device, err := getMountDevice(fsPath)
+	if err != nil {
+		return nil, fmt.Errorf("can't get block device backing file %q: %w", fsPath, err)
+	}
+
+	quota := GenericDiskQuota{}
+
+	// https://github.com/torvalds/linux/blob/master/include/uapi/linux/quota.h
+	cmd := Q_GETQUOTA<<8 | (quotaType & 0x00ff)
+
+	_, _, errno := unix.Syscall6(unix.SYS_QUOTACTL, uintptr(cmd), uintptr(unsafe.Pointer(device)), uintptr(id), uintptr(unsafe.Pointer(&quota)), 0, 0)
+	if errno != 0 {
+		return nil, transformErrno(errno)
+	}
+
+	return &quota, nil
+}
+
+// GetNextGenericQuota returns generic quota information of the first ID greater than or equal to the provided one
+// having a quota. IDNotFoundErr is returned when there is no such ID.
+func GetNextGenericQuota(fsPath string, quotaType QuotaType, id uint32) (*GenericNextDiskQuota, error) {
+	device, err := getMountDevice(fsPath)
+	if err != nil {
+		return nil, fmt.Errorf("can't get block device backing file %q: %w", fsPath, err)
+	}
+
+	quota := GenericNextDiskQuota{}
+
+	// https://github.com/torvalds/linux/blob/master/include/uapi/linux/quota.h
+	cmd := Q_GETNEXTQUOTA<<8 | (quotaType & 0x00ff)
+
+	_, _, errno := unix.Syscall6(unix.SYS_QUOTACTL, uintptr(cmd), uintptr(unsafe.Pointer(device)), uintptr(id), uintptr(unsafe.Pointer(&quota)), 0, 0)
+	if errno != 0 {
+		return nil, transformErrno(errno)
+	}
+
+	return &quota, nil
+}
+
+// SetGenericQuota sets generic quota limits of the provided ID and quota type.
+func SetGenericQuota(fsPath string, quotaType QuotaType, id uint32, dq *GenericDiskQuota) error {
+	device, err := getMountDevice(fsPath)
+	if err != nil {
+		return fmt.Errorf("can't get device of mount point %q: %w", fsPath, err)
+	}
+
+	// https://github.com/torvalds/linux/blob/master/include/uapi/linux/quota.h
+	cmd := Q_SETQUOTA<<8 | (quotaType & 0x00ff)
+
+	_, _, errno := unix.Syscall6(unix.SYS_QUOTACTL, uintptr(cmd), uintptr(unsafe.Pointer(device)), uintptr(id), uintptr(unsafe.Pointer(dq)), 0, 0)
+	if errno != 0 {
+		return transformErrno(errno)
+	}
+
+	return nil
+}
+
+// getMountDevice returns the device backing the mount point, which is resolved through bind mounts.
+func getMountDevice(mountPoint string) (*byte, error) {
+	entry, err := fs.GetBackingMountEntry(mountPoint)
+	if err != nil {
+		return nil, fmt.Errorf("cannot find device of mount point %q: %w", mountPoint, err)
+	}
+
+	deviceArg, err := unix.BytePtrFromString(entry.Device)
+	if err != nil {
+		return nil, fmt.Errorf("can't create byte ptr from string %q: %w", entry.Device, err)
 	}
 
-	return nil, fmt.Errorf("cannot find device of mount point %q", mountPoint)
+	return deviceArg, nil
 }
 
 func transformErrno(err syscall.Errno) error {
@@ -0,0 +1,81 @@
+// Copyright (c) 2023 ScyllaDB.
+
+package quotactl
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+	"unsafe"
+)
+
+func TestQuotaStructSizes(t *testing.T) {
+	t.Parallel()
+
+	// Sizes of struct fs_disk_quota, struct if_dqblk and struct if_nextdqblk from <uapi/linux/dqblk_xfs.h> and <uapi/linux/quota.h>.
+	if size := unsafe.Sizeof(DiskQuota{}); size != 112 {
+		t.Errorf("expected DiskQuota to be 112 bytes, got %d", size)
+	}
+
+	if size := unsafe.Sizeof(GenericDiskQuota{}); size != 72 {
+		t.Errorf("expected GenericDiskQuota to be 72 bytes, got %d", size)
+	}
+
+	if size := unsafe.Sizeof(GenericNextDiskQuota{}); size != 72 {
+		t.Errorf("expected GenericNextDiskQuota to be 72 bytes, got %d", size)
+	}
+
+	// Size of struct fs_quota_statv from <uapi/linux/dqblk_xfs.h>.
+	if size := unsafe.Sizeof(QuotaStat{}); size != 160 {
+		t.Errorf("expected QuotaStat to be 160 bytes, got %d", size)
+	}
+}
+
+func TestParseQuotaStat(t *testing.T) {
+	t.Parallel()
+
+	// Offsets of struct fs_quota_statv fields from <uapi/linux/dqblk_xfs.h>.
+	newBuf := func(version int8) []byte {
+		buf := make([]byte, 160)
+		buf[0] = byte(version)
+		binary.NativeEndian.PutUint16(buf[2:], FS_QUOTA_PDQ_ACCT|FS_QUOTA_PDQ_ENFD)
+		binary.NativeEndian.PutUint32(buf[4:], 42)
+		binary.NativeEndian.PutUint64(buf[56:], 131)
+		binary.NativeEndian.PutUint64(buf[64:], 8)
+		binary.NativeEndian.PutUint32(buf[72:], 2)
+		binary.NativeEndian.PutUint32(buf[80:], 604800)
+		binary.NativeEndian.PutUint16(buf[96:], 5)
+		return buf
+	}
+
+	stat, err := parseQuotaStat(newBuf(FS_QSTATV_VERSION1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := QuotaStat{
+		Version:          FS_QSTATV_VERSION1,
+		Flags:            FS_QUOTA_PDQ_ACCT | FS_QUOTA_PDQ_ENFD,
+		IncoreDquots:     42,
+		ProjectQuota:     QuotaFileStat{Inode: 131, Blocks: 8, Extents: 2},
+		BlockTimeLimit:   604800,
+		RTBlockWarnLimit: 5,
+	}
+	if !reflect.DeepEqual(*stat, expected) {
+		t.Errorf("expected %#v, got %#v", expected, *stat)
+	}
+
+	if !stat.ProjectQuotaEnforced() {
+		t.Errorf("expected project quota to be enforced")
+	}
+
+	_, err = parseQuotaStat(newBuf(2))
+	if err == nil {
+		t.Errorf("expected error on unsupported version")
+	}
+
+	_, err = parseQuotaStat(newBuf(FS_QSTATV_VERSION1)[:100])
+	if err == nil {
+		t.Errorf("expected error on truncated buffer")
+	}
+}
@@ -0,0 +1,421 @@
+// Copyright (c) 2023 ScyllaDB.
+
+package driver
+
+import (
+	"context"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/scylladb/local-csi-driver/pkg/driver/volume"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+)
+
+const (
+	metricsNamespace = "local_csi"
+)
+
+// csiOperationDurationBuckets are the buckets kubelet uses for its csi_operations_seconds metric.
+var csiOperationDurationBuckets = []float64{.1, .25, .5, 1, 2.5, 5, 10, 15, 25, 50, 120, 300, 600}
+
+type driverMetrics struct {
+	registry *prometheus.Registry
+
+	capacityComputedTimestamp prometheus.Gauge
+	capacityComputedBytes     prometheus.Gauge
+
+	grpcRequests        *prometheus.CounterVec
+	grpcRequestDuration *prometheus.HistogramVec
+
+	// csiOperationDuration follows the convention of kubelet and CSI sidecars, so CSI dashboards can use it as is.
+	csiOperationDuration *prometheus.HistogramVec
+
+	orphanedMountsSwept prometheus.Counter
+
+	suspectedLeakedVolumes        *prometheus.GaugeVec
+	suspectedLeakedVolumesDeleted prometheus.Counter
+
+	unenforcedVolumesPublished prometheus.Counter
+
+	mountDuration   *prometheus.HistogramVec
+	unmountDuration *prometheus.HistogramVec
+}
+
+var _ volume.MountObserver = &driverMetrics{}
+
+func newDriverMetrics(volumeManager *volume.VolumeManager) *driverMetrics {
+	m := &driverMetrics{
+		registry: prometheus.NewRegistry(),
+
+		capacityComputedTimestamp: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "capacity",
+			Name:      "computed_timestamp_seconds",
+			Help:      "Unix timestamp of the last available capacity computation requested through GetCapacity.",
+		}),
+		capacityComputedBytes: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "capacity",
+			Name:      "computed_bytes",
+			Help:      "Available capacity returned by the last GetCapacity call.",
+		}),
+		grpcRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "grpc",
+			Name:      "requests_total",
+			Help:      "Number of handled CSI requests by method and resulting gRPC code.",
+		}, []string{"method", "code"}),
+		grpcRequestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "grpc",
+			Name:      "request_duration_seconds",
+			Help:      "Duration of handling CSI requests by method.",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 4, 8),
+		}, []string{"method"}),
+		csiOperationDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Subsystem: "csi",
+			Name:      "operations_seconds",
+			Help:      "Duration of CSI operations by driver, full gRPC method name and resulting gRPC code, in the format used by kubelet.",
+			Buckets:   csiOperationDurationBuckets,
+		}, []string{"driver_name", "method_name", "grpc_status_code", "migrated"}),
+		orphanedMountsSwept: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "mounts",
+			Name:      "orphaned_swept_total",
+			Help:      "Number of orphaned mounts of no longer existing volumes unmounted by the sweeper.",
+		}),
+		suspectedLeakedVolumes: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "suspected_leaked_volumes",
+			Help:      "Number of volumes by kind of inconsistency between their state and directory lasting past the grace period, as found by the last leak detection.",
+		}, []string{"kind"}),
+		suspectedLeakedVolumesDeleted: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "suspected_leaked_volumes_deleted_total",
+			Help:      "Number of suspected leaked volumes deleted by the leak detection.",
+		}),
+		unenforcedVolumesPublished: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "volumes",
+			Name:      "unenforced_published_total",
+			Help:      "Number of publishes of volumes whose limits couldn't be restored on startup, so they aren't enforced.",
+		}),
+		mountDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "mount_duration_seconds",
+			Help:      "Duration of mounting volumes by result, excluding the rest of request handling.",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 4, 8),
+		}, []string{"result"}),
+		unmountDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "unmount_duration_seconds",
+			Help:      "Duration of single unmount attempts of volumes by result, excluding retries of busy mounts.",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 4, 8),
+		}, []string{"result"}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.capacityComputedTimestamp,
+		m.capacityComputedBytes,
+		m.grpcRequests,
+		m.grpcRequestDuration,
+		m.csiOperationDuration,
+		m.orphanedMountsSwept,
+		m.suspectedLeakedVolumes,
+		m.suspectedLeakedVolumesDeleted,
+		m.unenforcedVolumesPublished,
+		m.mountDuration,
+		m.unmountDuration,
+		newVolumeCollector(volumeManager),
+	)
+
+	return m
+}
+
+func (m *driverMetrics) observeCapacityComputation(capacity int64, now time.Time) {
+	m.capacityComputedTimestamp.Set(float64(now.UnixNano()) / float64(time.Second))
+	m.capacityComputedBytes.Set(float64(capacity))
+}
+
+func (m *driverMetrics) ObserveMount(duration time.Duration, err error) {
+	m.mountDuration.WithLabelValues(operationResult(err)).Observe(duration.Seconds())
+}
+
+func (m *driverMetrics) ObserveUnmount(duration time.Duration, err error) {
+	m.unmountDuration.WithLabelValues(operationResult(err)).Observe(duration.Seconds())
+}
+
+func operationResult(err error) string {
+	if err != nil {
+		return "error"
+	}
+
+	return "success"
+}
+
+// MetricsHandler returns a handler serving driver metrics in Prometheus format.
+func (d *driver) MetricsHandler() http.Handler {
+	return promhttp.HandlerFor(d.metrics.registry, promhttp.HandlerOpts{})
+}
+
+// MetricsUnaryInterceptor records count, resulting code and duration of every handled request, both as the driver's
+// own metrics and as the standard csi_operations_seconds one.
+func (d *driver) MetricsUnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		method := path.Base(info.FullMethod)
+
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		duration := time.Since(start).Seconds()
+		code := status.Code(err).String()
+		d.metrics.grpcRequestDuration.WithLabelValues(method).Observe(duration)
+		d.metrics.grpcRequests.WithLabelValues(method, code).Inc()
+		// Driver doesn't serve volumes migrated from in-tree plugins.
+		d.metrics.csiOperationDuration.WithLabelValues(d.name, info.FullMethod, code, "false").Observe(duration)
+
+		return resp, err
+	}
+}
+
+// volumeCollector collects volume metrics at scrape time, so they're never stale.
+type volumeCollector struct {
+	volumeManager *volume.VolumeManager
+
+	volumesDesc              *prometheus.Desc
+	provisionedBytesDesc     *prometheus.Desc
+	volumesByEnforcementDesc *prometheus.Desc
+	volumesOfOtherNodesDesc  *prometheus.Desc
+	availableCapacityDesc    *prometheus.Desc
+
+	quotaProjectsDesc             *prometheus.Desc
+	quotaLimitedBytesDesc         *prometheus.Desc
+	quotaUsedBytesDesc            *prometheus.Desc
+	quotaIncoreDesc               *prometheus.Desc
+	quotaExpectedProjectsDesc     *prometheus.Desc
+	quotaExpectedLimitedBytesDesc *prometheus.Desc
+}
+
+var _ prometheus.Collector = &volumeCollector{}
+
+func newVolumeCollector(volumeManager *volume.VolumeManager) *volumeCollector {
+	return &volumeCollector{
+		volumeManager: volumeManager,
+
+		volumesDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(metricsNamespace, "volumes", "count"),
+			"Number of provisioned volumes by StorageClass they were provisioned for.",
+			[]string{"storage_class"},
+			nil,
+		),
+		provisionedBytesDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(metricsNamespace, "volumes", "provisioned_bytes"),
+			"Total size of provisioned volumes by StorageClass they were provisioned for.",
+			[]string{"storage_class"},
+			nil,
+		),
+		volumesByEnforcementDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(metricsNamespace, "volumes", "enforcement_count"),
+			"Number of provisioned volumes by how their limits are enforced.",
+			[]string{"enforcement_mode"},
+			nil,
+		),
+		volumesOfOtherNodesDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(metricsNamespace, "volumes", "node_name_mismatch"),
+			"Number of volumes created on a node of a different name than the current one, e.g. before the node was renamed. Their topology no longer matches the node they were provisioned for.",
+			nil,
+			nil,
+		),
+		availableCapacityDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(metricsNamespace, "capacity", "available_bytes"),
+			"Capacity available for new volumes.",
+			nil,
+			nil,
+		),
+		quotaProjectsDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(metricsNamespace, "quota", "projects"),
+			"Number of projects having a limit on the filesystem of the volumes dir, as reported by the kernel.",
+			[]string{"volumes_dir"},
+			nil,
+		),
+		quotaLimitedBytesDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(metricsNamespace, "quota", "limited_bytes"),
+			"Sum of block limits of projects on the filesystem of the volumes dir, as reported by the kernel.",
+			[]string{"volumes_dir"},
+			nil,
+		),
+		quotaUsedBytesDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(metricsNamespace, "quota", "used_bytes"),
+			"Sum of space accounted to projects having a limit on the filesystem of the volumes dir, as reported by the kernel.",
+			[]string{"volumes_dir"},
+			nil,
+		),
+		quotaIncoreDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(metricsNamespace, "quota", "incore_count"),
+			"Number of quota structures the kernel keeps in memory for the filesystem of the volumes dir.",
+			[]string{"volumes_dir"},
+			nil,
+		),
+		quotaExpectedProjectsDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(metricsNamespace, "quota", "expected_projects"),
+			"Number of volumes of the volumes dir having a limit according to their states.",
+			[]string{"volumes_dir"},
+			nil,
+		),
+		quotaExpectedLimitedBytesDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(metricsNamespace, "quota", "expected_limited_bytes"),
+			"Sum of sizes of volumes of the volumes dir having a limit according to their states.",
+			[]string{"volumes_dir"},
+			nil,
+		),
+	}
+}
+
+func (c *volumeCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.volumesDesc
+	ch <- c.provisionedBytesDesc
+	ch <- c.volumesByEnforcementDesc
+	ch <- c.volumesOfOtherNodesDesc
+	ch <- c.availableCapacityDesc
+	ch <- c.quotaProjectsDesc
+	ch <- c.quotaLimitedBytesDesc
+	ch <- c.quotaUsedBytesDesc
+	ch <- c.quotaIncoreDesc
+	ch <- c.quotaExpectedProjectsDesc
+	ch <- c.quotaExpectedLimitedBytesDesc
+}
+
+func (c *volumeCollector) Collect(ch chan<- prometheus.Metric) {
+	volumes := map[string]int{}
+	provisionedBytes := map[string]int64{}
+	volumesByEnforcement := map[string]int{}
+	for _, vs := range c.volumeManager.GetVolumes() {
+		volumes[vs.StorageClassName]++
+		provisionedBytes[vs.StorageClassName] += vs.Size
+
+		// Enforcement mode wasn't recorded for volumes created by older versions.
+		enforcementMode := string(vs.EnforcementMode)
+		if len(enforcementMode) == 0 {
+			enforcementMode = "unknown"
+		}
+		volumesByEnforcement[enforcementMode]++
+	}
+
+	for storageClass, count := range volumes {
+		ch <- prometheus.MustNewConstMetric(c.volumesDesc, prometheus.GaugeValue, float64(count), storageClass)
+		ch <- prometheus.MustNewConstMetric(c.provisionedBytesDesc, prometheus.GaugeValue, float64(provisionedBytes[storageClass]), storageClass)
+	}
+
+	for enforcementMode, count := range volumesByEnforcement {
+		ch <- prometheus.MustNewConstMetric(c.volumesByEnforcementDesc, prometheus.GaugeValue, float64(count), enforcementMode)
+	}
+
+	ch <- prometheus.MustNewConstMetric(c.volumesOfOtherNodesDesc, prometheus.GaugeValue, float64(len(c.volumeManager.GetVolumesOfOtherNodes())))
+
+	c.collectQuotaStats(ch)
+
+	availableCapacity, err := c.volumeManager.GetAvailableCapacity()
+	if err != nil {
+		klog.ErrorS(err, "Can't get available capacity")
+		ch <- prometheus.NewInvalidMetric(c.availableCapacityDesc, err)
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(c.availableCapacityDesc, prometheus.GaugeValue, float64(availableCapacity))
+}
+
+func (c *volumeCollector) collectQuotaStats(ch chan<- prometheus.Metric) {
+	quotaStats, err := c.volumeManager.GetQuotaStats()
+	if err != nil {
+		klog.ErrorS(err, "Can't get quota statistics")
+		ch <- prometheus.NewInvalidMetric(c.quotaProjectsDesc, err)
+		return
+	}
+
+	for _, qs := range quotaStats {
+		ch <- prometheus.MustNewConstMetric(c.quotaExpectedProjectsDesc, prometheus.GaugeValue, float64(qs.LimitedVolumes), qs.VolumesDir)
+		ch <- prometheus.MustNewConstMetric(c.quotaExpectedLimitedBytesDesc, prometheus.GaugeValue, float64(qs.LimitedVolumesBytes), qs.VolumesDir)
+
+		// Filesystems without quotas have no accounting to compare with.
+		if qs.Kernel == nil {
+			continue
+		}
+
+		ch <- prometheus.MustNewConstMetric(c.quotaProjectsDesc, prometheus.GaugeValue, float64(qs.Kernel.Projects), qs.VolumesDir)
+		ch <- prometheus.MustNewConstMetric(c.quotaLimitedBytesDesc, prometheus.GaugeValue, float64(qs.Kernel.LimitedBytes), qs.VolumesDir)
+		ch <- prometheus.MustNewConstMetric(c.quotaUsedBytesDesc, prometheus.GaugeValue, float64(qs.Kernel.UsedBytes), qs.VolumesDir)
+		ch <- prometheus.MustNewConstMetric(c.quotaIncoreDesc, prometheus.GaugeValue, float64(qs.Kernel.IncoreQuotas), qs.VolumesDir)
+	}
+}
+
+// volumeIOCollector collects IO statistics of volumes exposed through loop devices at scrape time.
+type volumeIOCollector struct {
+	volumeManager *volume.VolumeManager
+
+	readOperationsDesc  *prometheus.Desc
+	readBytesDesc       *prometheus.Desc
+	writeOperationsDesc *prometheus.Desc
+	writeBytesDesc      *prometheus.Desc
+}
+
+var _ prometheus.Collector = &volumeIOCollector{}
+
+func newVolumeIOCollector(volumeManager *volume.VolumeManager) *volumeIOCollector {
+	return &volumeIOCollector{
+		volumeManager: volumeManager,
+
+		readOperationsDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(metricsNamespace, "volume", "io_read_operations_total"),
+			"Number of read operations completed by the loop device of the volume since it was attached.",
+			[]string{"volume_id"},
+			nil,
+		),
+		readBytesDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(metricsNamespace, "volume", "io_read_bytes_total"),
+			"Number of bytes read by the loop device of the volume since it was attached.",
+			[]string{"volume_id"},
+			nil,
+		),
+		writeOperationsDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(metricsNamespace, "volume", "io_write_operations_total"),
+			"Number of write operations completed by the loop device of the volume since it was attached.",
+			[]string{"volume_id"},
+			nil,
+		),
+		writeBytesDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(metricsNamespace, "volume", "io_write_bytes_total"),
+			"Number of bytes written by the loop device of the volume since it was attached.",
+			[]string{"volume_id"},
+			nil,
+		),
+	}
+}
+
+func (c *volumeIOCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.readOperationsDesc
+	ch <- c.readBytesDesc
+	ch <- c.writeOperationsDesc
+	ch <- c.writeBytesDesc
+}
+
+func (c *volumeIOCollector) Collect(ch chan<- prometheus.Metric) {
+	// Directory backed volumes and volumes without an attached loop device have no statistics of their own.
+	volumesStats, err := c.volumeManager.GetVolumesIOStats()
+	if err != nil {
+		klog.ErrorS(err, "Can't get IO statistics of some volumes")
+	}
+
+	for volumeID, stats := range volumesStats {
+		ch <- prometheus.MustNewConstMetric(c.readOperationsDesc, prometheus.CounterValue, float64(stats.ReadOperations), volumeID)
+		ch <- prometheus.MustNewConstMetric(c.readBytesDesc, prometheus.CounterValue, float64(stats.ReadBytes), volumeID)
+		ch <- prometheus.MustNewConstMetric(c.writeOperationsDesc, prometheus.CounterValue, float64(stats.WriteOperations), volumeID)
+		ch <- prometheus.MustNewConstMetric(c.writeBytesDesc, prometheus.CounterValue, float64(stats.WriteBytes), volumeID)
+	}
+}
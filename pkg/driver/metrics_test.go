@@ -0,0 +1,345 @@
+// Copyright (c) 2023 ScyllaDB.
+
+package driver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/scylladb/local-csi-driver/pkg/driver/limit"
+	"github.com/scylladb/local-csi-driver/pkg/driver/volume"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/mount-utils"
+)
+
+func TestGetCapacityRecordsComputation(t *testing.T) {
+	t.Parallel()
+
+	d := newTestDriver(t)
+
+	before := time.Now()
+	resp, err := d.GetCapacity(context.Background(), &csi.GetCapacityRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	timestamp := testutil.ToFloat64(d.metrics.capacityComputedTimestamp)
+	if timestamp < float64(before.Unix()) || timestamp > float64(time.Now().Unix()+1) {
+		t.Errorf("expected computation timestamp around %v, got %v", before.Unix(), timestamp)
+	}
+
+	capacity := testutil.ToFloat64(d.metrics.capacityComputedBytes)
+	if capacity != float64(resp.AvailableCapacity) {
+		t.Errorf("expected computed capacity %d, got %v", resp.AvailableCapacity, capacity)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	rec := httptest.NewRecorder()
+	d.AdminHandler().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	if !strings.Contains(rec.Body.String(), "local_csi_capacity_computed_timestamp_seconds ") {
+		t.Errorf("expected capacity computation timestamp metric to be served, got %q", rec.Body.String())
+	}
+}
+
+func TestMetricsUnaryInterceptor(t *testing.T) {
+	t.Parallel()
+
+	d := newTestDriver(t)
+	interceptor := d.MetricsUnaryInterceptor()
+	info := &grpc.UnaryServerInfo{
+		FullMethod: "/csi.v1.Controller/CreateVolume",
+	}
+
+	for _, err := range []error{nil, status.Error(codes.OutOfRange, "too big"), status.Error(codes.OutOfRange, "too big")} {
+		_, _ = interceptor(context.Background(), nil, info, func(ctx context.Context, req any) (any, error) {
+			return nil, err
+		})
+	}
+
+	if got := testutil.ToFloat64(d.metrics.grpcRequests.WithLabelValues("CreateVolume", codes.OK.String())); got != 1 {
+		t.Errorf("expected 1 successful request, got %v", got)
+	}
+
+	if got := testutil.ToFloat64(d.metrics.grpcRequests.WithLabelValues("CreateVolume", codes.OutOfRange.String())); got != 2 {
+		t.Errorf("expected 2 failed requests, got %v", got)
+	}
+
+	if got := testutil.CollectAndCount(d.metrics.grpcRequestDuration); got != 1 {
+		t.Errorf("expected single duration histogram, got %d", got)
+	}
+
+	if got := testutil.CollectAndCount(d.metrics.csiOperationDuration, "csi_operations_seconds"); got != 2 {
+		t.Errorf("expected CSI operation histograms of 2 codes, got %d", got)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	rec := httptest.NewRecorder()
+	d.MetricsHandler().ServeHTTP(rec, req)
+
+	for _, code := range []codes.Code{codes.OK, codes.OutOfRange} {
+		expected := fmt.Sprintf(`csi_operations_seconds_count{driver_name="local-csi-driver",grpc_status_code="%s",method_name="/csi.v1.Controller/CreateVolume",migrated="false"} `, code)
+		if !strings.Contains(rec.Body.String(), expected) {
+			t.Errorf("expected %q metric to be served, got %q", expected, rec.Body.String())
+		}
+	}
+}
+
+func TestMountDurationMetrics(t *testing.T) {
+	t.Parallel()
+
+	mounter := mount.NewFakeMounter(nil)
+	d := newTestDriverWithMounter(t, mounter)
+	ctx := context.Background()
+
+	resp, err := d.CreateVolume(ctx, newCreateVolumeRequest("volume", 1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stagingPath := filepath.Join(t.TempDir(), "staging")
+	stageReq := &csi.NodeStageVolumeRequest{
+		VolumeId:          resp.Volume.VolumeId,
+		StagingTargetPath: stagingPath,
+		VolumeCapability:  newCreateVolumeRequest("volume", 1024).VolumeCapabilities[0],
+	}
+	_, err = d.NodeStageVolume(ctx, stageReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unstageReq := &csi.NodeUnstageVolumeRequest{
+		VolumeId:          resp.Volume.VolumeId,
+		StagingTargetPath: stagingPath,
+	}
+	mounter.UnmountFunc = func(string) error {
+		return fmt.Errorf("unmount failed")
+	}
+	_, err = d.NodeUnstageVolume(ctx, unstageReq)
+	if err == nil {
+		t.Fatal("expected unstaging to fail")
+	}
+
+	mounter.UnmountFunc = nil
+	_, err = d.NodeUnstageVolume(ctx, unstageReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	metricFamilies, err := d.metrics.registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]uint64{
+		"local_csi_mount_duration_seconds/success":   1,
+		"local_csi_unmount_duration_seconds/error":   1,
+		"local_csi_unmount_duration_seconds/success": 1,
+	}
+	got := map[string]uint64{}
+	for _, mf := range metricFamilies {
+		if mf.GetName() != "local_csi_mount_duration_seconds" && mf.GetName() != "local_csi_unmount_duration_seconds" {
+			continue
+		}
+
+		for _, m := range mf.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if l.GetName() == "result" {
+					got[mf.GetName()+"/"+l.GetValue()] = m.GetHistogram().GetSampleCount()
+				}
+			}
+		}
+	}
+
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected observed mounts %v, got %v", expected, got)
+	}
+}
+
+func TestVolumeCollector(t *testing.T) {
+	t.Parallel()
+
+	d := newTestDriver(t)
+
+	for _, name := range []string{"a", "b"} {
+		req := newCreateVolumeRequest(name, 1024)
+		req.Parameters = map[string]string{
+			StorageClassNameParameterKey: "scylladb-local-xfs",
+		}
+		_, err := d.CreateVolume(context.Background(), req)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	metricFamilies, err := d.metrics.registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]float64{
+		"local_csi_volumes_count":             2,
+		"local_csi_volumes_provisioned_bytes": 2048,
+	}
+	got := map[string]float64{}
+	for _, mf := range metricFamilies {
+		if _, ok := expected[mf.GetName()]; !ok {
+			continue
+		}
+
+		for _, m := range mf.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if l.GetName() == "storage_class" && l.GetValue() == "scylladb-local-xfs" {
+					got[mf.GetName()] = m.GetGauge().GetValue()
+				}
+			}
+		}
+	}
+
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected metrics %v, got %v", expected, got)
+	}
+
+	expectedByEnforcement := map[string]float64{
+		string(limit.NoEnforcement): 2,
+	}
+	gotByEnforcement := map[string]float64{}
+	for _, mf := range metricFamilies {
+		if mf.GetName() != "local_csi_volumes_enforcement_count" {
+			continue
+		}
+
+		for _, m := range mf.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if l.GetName() == "enforcement_mode" {
+					gotByEnforcement[l.GetValue()] = m.GetGauge().GetValue()
+				}
+			}
+		}
+	}
+
+	if !reflect.DeepEqual(gotByEnforcement, expectedByEnforcement) {
+		t.Errorf("expected volumes by enforcement mode %v, got %v", expectedByEnforcement, gotByEnforcement)
+	}
+
+	// Volumes of this node don't mismatch its name.
+	var gotMismatching []float64
+	for _, mf := range metricFamilies {
+		if mf.GetName() != "local_csi_volumes_node_name_mismatch" {
+			continue
+		}
+
+		for _, m := range mf.GetMetric() {
+			gotMismatching = append(gotMismatching, m.GetGauge().GetValue())
+		}
+	}
+
+	if expectedMismatching := []float64{0}; !reflect.DeepEqual(gotMismatching, expectedMismatching) {
+		t.Errorf("expected volumes of other nodes %v, got %v", expectedMismatching, gotMismatching)
+	}
+}
+
+type quotaStatsLimiter struct {
+	limit.NoopLimiter
+
+	quotaStats *limit.QuotaStats
+}
+
+func (l *quotaStatsLimiter) NewLimit(string) (uint32, error) {
+	return 1, nil
+}
+
+func (l *quotaStatsLimiter) QuotaStats() (*limit.QuotaStats, error) {
+	return l.quotaStats, nil
+}
+
+func TestVolumeCollectorQuotaStats(t *testing.T) {
+	t.Parallel()
+
+	d := newTestDriverWithOptions(t, volume.WithLimiter(&quotaStatsLimiter{
+		quotaStats: &limit.QuotaStats{
+			Projects:     2,
+			LimitedBytes: 4096,
+			UsedBytes:    512,
+			IncoreQuotas: 3,
+		},
+	}))
+
+	_, err := d.CreateVolume(context.Background(), newCreateVolumeRequest("volume", 1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	metricFamilies, err := d.metrics.registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]float64{
+		"local_csi_quota_projects":               2,
+		"local_csi_quota_limited_bytes":          4096,
+		"local_csi_quota_used_bytes":             512,
+		"local_csi_quota_incore_count":           3,
+		"local_csi_quota_expected_projects":      1,
+		"local_csi_quota_expected_limited_bytes": 1024,
+	}
+	got := map[string]float64{}
+	for _, mf := range metricFamilies {
+		if _, ok := expected[mf.GetName()]; !ok {
+			continue
+		}
+
+		for _, m := range mf.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if l.GetName() == "volumes_dir" && l.GetValue() == d.volumeManager.VolumesDir() {
+					got[mf.GetName()] = m.GetGauge().GetValue()
+				}
+			}
+		}
+	}
+
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected metrics %v, got %v", expected, got)
+	}
+}
+
+func TestVolumeIOCollector(t *testing.T) {
+	t.Parallel()
+
+	for _, enabled := range []bool{false, true} {
+		d := newTestDriver(t)
+		d = NewDriver(d.name, d.version, d.nodeName, d.volumeManager, WithVolumeIOStats(enabled))
+
+		_, err := d.CreateVolume(context.Background(), newCreateVolumeRequest("volume", 1024))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		metricFamilies, err := d.metrics.registry.Gather()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Directory backed volumes have no IO statistics of their own.
+		for _, mf := range metricFamilies {
+			if strings.HasPrefix(mf.GetName(), "local_csi_volume_io_") {
+				t.Errorf("expected no IO metrics of directory backed volume with IO stats enabled=%v, got %q", enabled, mf.GetName())
+			}
+		}
+	}
+}
@@ -4,115 +4,525 @@ package driver
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
-	"github.com/kubernetes-csi/csi-lib-utils/protosanitizer"
-	"github.com/scylladb/local-csi-driver/pkg/driver/limit"
+	"github.com/scylladb/local-csi-driver/pkg/driver/audit"
+	"github.com/scylladb/local-csi-driver/pkg/driver/volume"
+	"github.com/scylladb/local-csi-driver/pkg/util/fs"
 	"github.com/scylladb/local-csi-driver/pkg/util/slices"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	"k8s.io/klog/v2"
 )
 
+// allowedMountFlags are flags of the bind mount of the volume to its target path which can be requested,
+// e.g. using StorageClass mountOptions. Filesystem specific options don't apply to bind mounts.
+var allowedMountFlags = []string{
+	"bind",
+	"ro",
+	"rw",
+	"exec",
+	"noexec",
+	"suid",
+	"nosuid",
+	"dev",
+	"nodev",
+	"atime",
+	"noatime",
+	"diratime",
+	"nodiratime",
+	"relatime",
+	"norelatime",
+	"strictatime",
+	"nostrictatime",
+}
+
+// seLinuxContextMountFlagPrefix prefixes the mount flag kubelet passes the SELinux context of the pod in,
+// when the CSIDriver has seLinuxMount enabled.
+const seLinuxContextMountFlagPrefix = "context="
+
+// isSELinuxContextMountFlag returns whether the mount flag holds a single quoted SELinux context, the way kubelet
+// passes it. Commas separate mount options, so an unquoted context could smuggle in other options.
+func isSELinuxContextMountFlag(mountFlag string) bool {
+	seLinuxContext, ok := strings.CutPrefix(mountFlag, seLinuxContextMountFlagPrefix)
+	if !ok || len(seLinuxContext) < 3 || !strings.HasPrefix(seLinuxContext, `"`) || !strings.HasSuffix(seLinuxContext, `"`) {
+		return false
+	}
+
+	return !strings.Contains(seLinuxContext[1:len(seLinuxContext)-1], `"`)
+}
+
+// validateAllowedMountFlags rejects mount flags which aren't allowed.
+func validateAllowedMountFlags(mountFlags []string) error {
+	var unknown []string
+	for _, mf := range mountFlags {
+		if !slices.Contains(allowedMountFlags, mf) && !isSELinuxContextMountFlag(mf) {
+			unknown = append(unknown, mf)
+		}
+	}
+
+	if len(unknown) != 0 {
+		return fmt.Errorf("unsupported mount flags %q, supported are %q and %q", unknown, allowedMountFlags, seLinuxContextMountFlagPrefix)
+	}
+
+	return nil
+}
+
+// getSELinuxContextMountFlag returns the SELinux context mount flag, or an empty string when there is none.
+// Repeated flags are accepted as long as they hold the same context.
+func getSELinuxContextMountFlag(mountFlags []string) (string, error) {
+	var contexts []string
+	for _, mf := range mountFlags {
+		if strings.HasPrefix(mf, seLinuxContextMountFlagPrefix) && !slices.Contains(contexts, mf) {
+			contexts = append(contexts, mf)
+		}
+	}
+
+	switch len(contexts) {
+	case 0:
+		return "", nil
+	case 1:
+		if !isSELinuxContextMountFlag(contexts[0]) {
+			return "", fmt.Errorf("mount flag %q doesn't hold a quoted SELinux context", contexts[0])
+		}
+		return contexts[0], nil
+	default:
+		return "", fmt.Errorf("mount flags can't hold more than one SELinux context, got %q", contexts)
+	}
+}
+
 func (d *driver) NodeGetCapabilities(ctx context.Context, request *csi.NodeGetCapabilitiesRequest) (*csi.NodeGetCapabilitiesResponse, error) {
-	return &csi.NodeGetCapabilitiesResponse{
-		Capabilities: []*csi.NodeServiceCapability{
-			{
-				Type: &csi.NodeServiceCapability_Rpc{
-					Rpc: &csi.NodeServiceCapability_RPC{
-						Type: csi.NodeServiceCapability_RPC_GET_VOLUME_STATS,
-					},
+	capabilities := []*csi.NodeServiceCapability{
+		{
+			Type: &csi.NodeServiceCapability_Rpc{
+				Rpc: &csi.NodeServiceCapability_RPC{
+					Type: csi.NodeServiceCapability_RPC_STAGE_UNSTAGE_VOLUME,
 				},
 			},
 		},
+		{
+			Type: &csi.NodeServiceCapability_Rpc{
+				Rpc: &csi.NodeServiceCapability_RPC{
+					Type: csi.NodeServiceCapability_RPC_GET_VOLUME_STATS,
+				},
+			},
+		},
+	}
+
+	// Kubelet stops applying fsGroup itself once the driver advertises it, which changes behavior of existing volumes.
+	if d.volumeMountGroupEnabled {
+		capabilities = append(capabilities, &csi.NodeServiceCapability{
+			Type: &csi.NodeServiceCapability_Rpc{
+				Rpc: &csi.NodeServiceCapability_RPC{
+					Type: csi.NodeServiceCapability_RPC_VOLUME_MOUNT_GROUP,
+				},
+			},
+		})
+	}
+
+	return &csi.NodeGetCapabilitiesResponse{
+		Capabilities: capabilities,
 	}, nil
 }
 
+func (d *driver) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRequest) (*csi.NodeStageVolumeResponse, error) {
+	volumeID := req.GetVolumeId()
+	if len(volumeID) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "Volume ID not provided")
+	}
+
+	err := volume.ValidateVolumeID(volumeID)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "Invalid volume ID: %v", err)
+	}
+
+	stagingPath := req.GetStagingTargetPath()
+	if len(stagingPath) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "Staging target path not provided")
+	}
+
+	volCap := req.GetVolumeCapability()
+	if volCap == nil {
+		return nil, status.Error(codes.InvalidArgument, "Volume capability not provided")
+	}
+
+	err = d.validateVolumeCapabilities([]*csi.VolumeCapability{volCap})
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("Volume capability not supported: %s", err))
+	}
+
+	vs := d.volumeManager.GetVolumeStateByID(volumeID)
+	if vs == nil {
+		return nil, status.Errorf(codes.NotFound, "Volume with VolumeID %q does not exists", volumeID)
+	}
+
+	// Block volumes are published straight from their backing file, there is nothing to stage.
+	if volCap.GetBlock() != nil {
+		return &csi.NodeStageVolumeResponse{}, nil
+	}
+
+	seLinuxContext, err := getSELinuxContextMountFlag(volCap.GetMount().GetMountFlags())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "Invalid mount flags: %v", err)
+	}
+
+	// Context applies to filesystems of loop backed volumes only, bind mounts share the context of their source.
+	var stageMountOptions []string
+	if len(seLinuxContext) != 0 {
+		stageMountOptions = append(stageMountOptions, seLinuxContext)
+	}
+
+	_, span := d.startMountSpan(ctx, volumeID)
+	err = d.volumeManager.Stage(volumeID, stagingPath, stageMountOptions)
+	endSpan(span, err)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Failed to stage volume: %v", err)
+	}
+
+	return &csi.NodeStageVolumeResponse{}, nil
+}
+
+func (d *driver) NodeUnstageVolume(ctx context.Context, req *csi.NodeUnstageVolumeRequest) (*csi.NodeUnstageVolumeResponse, error) {
+	volumeID := req.GetVolumeId()
+	if len(volumeID) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "Volume ID not provided")
+	}
+
+	err := volume.ValidateVolumeID(volumeID)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "Invalid volume ID: %v", err)
+	}
+
+	stagingPath := req.GetStagingTargetPath()
+	if len(stagingPath) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "Staging target path not provided")
+	}
+
+	err = d.volumeManager.Unstage(stagingPath)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Failed to unstage volume at path %q: %v", stagingPath, err)
+	}
+
+	return &csi.NodeUnstageVolumeResponse{}, nil
+}
+
 func (d *driver) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolumeRequest) (*csi.NodePublishVolumeResponse, error) {
-	klog.V(4).InfoS("New request", "server", "node", "function", "NodePublishVolume", "request", protosanitizer.StripSecrets(req))
+	start := time.Now()
+	resp, err := d.nodePublishVolume(ctx, req)
+	d.auditLogger.Log(d.newVolumeAuditRecord(audit.MountVolumeOperation, req.GetVolumeId(), req.GetTargetPath()), start, err)
+
+	return resp, err
+}
 
+func (d *driver) nodePublishVolume(ctx context.Context, req *csi.NodePublishVolumeRequest) (*csi.NodePublishVolumeResponse, error) {
 	volumeID := req.GetVolumeId()
-	targetPath := req.GetTargetPath()
+	if len(volumeID) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "Volume ID not provided")
+	}
+
+	err := volume.ValidateVolumeID(volumeID)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "Invalid volume ID: %v", err)
+	}
 
+	targetPath := req.GetTargetPath()
 	if len(targetPath) == 0 {
 		return nil, status.Error(codes.InvalidArgument, "Target path not provided")
 	}
 
+	// Ephemeral inline volumes aren't staged, they're created when published.
+	ephemeral := isEphemeralVolume(req.GetVolumeContext())
+
+	stagingPath := req.GetStagingTargetPath()
+	if len(stagingPath) == 0 && !ephemeral {
+		return nil, status.Error(codes.InvalidArgument, "Staging target path not provided")
+	}
+
 	volCap := req.GetVolumeCapability()
 	if volCap == nil {
 		return nil, status.Error(codes.InvalidArgument, "Volume capability not provided")
 	}
 
-	err := d.validateVolumeCapabilities([]*csi.VolumeCapability{volCap})
+	err = d.validateVolumeCapabilities([]*csi.VolumeCapability{volCap})
 	if err != nil {
 		return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("Volume capability not supported: %s", err))
 	}
 
-	if volCap.GetMount() == nil {
-		return nil, status.Error(codes.InvalidArgument, "Volume capability access type must be mount")
+	if ephemeral && volCap.GetBlock() != nil {
+		return nil, status.Error(codes.InvalidArgument, "Ephemeral volumes can't have block access type")
+	}
+
+	readOnly := req.GetReadonly() || isReadOnlyAccessMode(volCap.GetAccessMode().GetMode())
+
+	syncInterval, err := getSyncInterval(req.GetVolumeContext())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "Invalid volume context: %v", err)
+	}
+
+	rootOnlyMountGroup, err := getVolumeMountGroup(req.GetVolumeContext())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "Invalid volume context: %v", err)
+	}
+
+	// Kubelet delegates applying fsGroup to the driver and doesn't change ownership of the volume itself.
+	mountGroup := -1
+	if len(volCap.GetMount().GetVolumeMountGroup()) != 0 {
+		mountGroup, err = strconv.Atoi(volCap.GetMount().GetVolumeMountGroup())
+		if err != nil || mountGroup < 0 {
+			return nil, status.Errorf(codes.InvalidArgument, "Invalid volume mount group %q", volCap.GetMount().GetVolumeMountGroup())
+		}
+	}
+
+	added, err := d.publishedTargets.Add(volumeID, targetPath, volCap.GetAccessMode().GetMode())
+	if err != nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "Can't publish volume to %q: %v", targetPath, err)
+	}
+
+	published := false
+	defer func() {
+		if added && !published {
+			d.publishedTargets.Remove(volumeID, targetPath)
+		}
+	}()
+
+	if ephemeral {
+		created, err := d.ensureEphemeralVolume(volumeID, req.GetVolumeContext())
+		if err != nil {
+			return nil, err
+		}
+
+		defer func() {
+			if created && !published {
+				err := d.deleteEphemeralVolume(volumeID)
+				if err != nil {
+					klog.ErrorS(err, "Can't clean up ephemeral volume which failed to be published", "volumeID", volumeID)
+				}
+			}
+		}()
+	}
+
+	if d.volumeManager.HasUnrestoredLimit(volumeID) {
+		if d.unrestoredQuotaPolicy != PermissiveUnrestoredQuotaPolicy {
+			return nil, status.Errorf(codes.FailedPrecondition, "Volume limit couldn't be restored on startup, publishing it would leave it unenforced")
+		}
+
+		klog.Warningf("Publishing volume %q to %q whose limit couldn't be restored on startup, its usage isn't enforced", volumeID, targetPath)
+		d.metrics.unenforcedVolumesPublished.Inc()
+	}
+
+	// Ephemeral volumes are never encrypted.
+	if !ephemeral {
+		err = d.provisionEncryptionKey(volumeID, req.GetSecrets())
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	mountOptions := []string{"bind"}
-	if req.GetReadonly() {
+	if readOnly {
 		mountOptions = append(mountOptions, "ro")
 	}
 
-	for _, mf := range volCap.GetMount().MountFlags {
-		mountOptions = append(mountOptions, mf)
+	if volCap.GetBlock() != nil {
+		_, span := d.startMountSpan(ctx, volumeID)
+		err = d.volumeManager.PublishBlockVolume(volumeID, targetPath, mountOptions)
+		endSpan(span, err)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "Failed to publish block volume: %v", err)
+		}
+
+		published = true
+		return &csi.NodePublishVolumeResponse{}, nil
+	}
+
+	err = validateAllowedMountFlags(volCap.GetMount().MountFlags)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "Invalid mount flags: %v", err)
 	}
 
-	mountOptions = slices.Unique(mountOptions)
+	err = validateMountFlags(volCap.GetMount().MountFlags, readOnly)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "Mount flags conflict with access mode: %v", err)
+	}
 
-	err = d.volumeManager.Mount(volumeID, targetPath, volCap.GetMount().FsType, mountOptions)
+	_, err = getSELinuxContextMountFlag(volCap.GetMount().MountFlags)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "Invalid mount flags: %v", err)
+	}
+
+	mountOptions = slices.Unique(append(mountOptions, volCap.GetMount().MountFlags...))
+
+	// Ephemeral volumes aren't staged, their directory is published directly.
+	_, span := d.startMountSpan(ctx, volumeID)
+	if ephemeral {
+		err = d.volumeManager.PublishVolumeDirectory(volumeID, targetPath, mountOptions)
+	} else {
+		err = d.volumeManager.Publish(stagingPath, targetPath, volCap.GetMount().FsType, mountOptions)
+	}
+	endSpan(span, err)
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "Failed to publish volume: %v", err)
 	}
 
+	// Kubelet doesn't apply fsGroup to read-only volumes either. Unless the volume opted into changing only
+	// its root directory, ownership of its content is changed the same way kubelet would change it.
+	if mountGroup >= 0 && !readOnly {
+		err = fs.SetGroupOwnership(targetPath, mountGroup, !rootOnlyMountGroup)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "Can't apply volume mount group: %v", err)
+		}
+	}
+
+	// Only filesystem volumes are synced, syncfs of a block device node would sync the filesystem holding the node.
+	// Syncing starts once the volume is mounted, so it never syncs the filesystem holding the target path.
+	if syncInterval > 0 {
+		_, err = d.syncer.Start(targetPath, syncInterval)
+		if err != nil {
+			unmountErr := d.volumeManager.Unmount(targetPath)
+			if unmountErr != nil {
+				klog.ErrorS(unmountErr, "Can't unmount volume which can't be periodically synced", "volumeID", volumeID, "targetPath", targetPath)
+			}
+			return nil, status.Errorf(codes.ResourceExhausted, "Can't periodically sync volume: %v", err)
+		}
+	}
+
+	published = true
 	return &csi.NodePublishVolumeResponse{}, nil
 }
 
+// provisionEncryptionKey makes data of an encrypted volume accessible using the key held in node publish secrets.
+func (d *driver) provisionEncryptionKey(volumeID string, secrets map[string]string) error {
+	vs := d.volumeManager.GetVolumeStateByID(volumeID)
+	if vs == nil || !vs.IsEncrypted() {
+		return nil
+	}
+
+	key, err := getEncryptionKey(secrets)
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "Invalid secrets: %v", err)
+	}
+
+	err = d.volumeManager.ProvisionEncryptionKey(volumeID, key)
+	if err != nil {
+		if errors.Is(err, volume.EncryptionKeyMismatchErr) {
+			return status.Errorf(codes.InvalidArgument, "Can't provision encryption key: %v", err)
+		}
+		return status.Errorf(codes.Internal, "Can't provision encryption key: %v", err)
+	}
+
+	return nil
+}
+
+// newVolumeAuditRecord returns an audit record of the operation on a published volume.
+func (d *driver) newVolumeAuditRecord(operation audit.Operation, volumeID, targetPath string) audit.Record {
+	record := audit.Record{
+		Operation:  operation,
+		VolumeID:   volumeID,
+		TargetPath: targetPath,
+	}
+
+	vs := d.volumeManager.GetVolumeStateByID(volumeID)
+	if vs != nil {
+		record.VolumeName = vs.Name
+		record.SizeBytes = vs.Size
+	}
+
+	return record
+}
+
 func (d *driver) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpublishVolumeRequest) (*csi.NodeUnpublishVolumeResponse, error) {
-	klog.V(4).InfoS("New request", "server", "node", "function", "NodeUnpublishVolume", "request", protosanitizer.StripSecrets(req))
+	// Ephemeral volumes are deleted on unpublish, so the record is made before.
+	record := d.newVolumeAuditRecord(audit.UnmountVolumeOperation, req.GetVolumeId(), req.GetTargetPath())
+
+	start := time.Now()
+	resp, err := d.nodeUnpublishVolume(ctx, req)
+	d.auditLogger.Log(record, start, err)
 
+	return resp, err
+}
+
+func (d *driver) nodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpublishVolumeRequest) (*csi.NodeUnpublishVolumeResponse, error) {
 	targetPath := req.GetTargetPath()
 	if len(targetPath) == 0 {
 		return nil, status.Error(codes.InvalidArgument, "Target path not provided")
 	}
 
+	d.syncer.Stop(targetPath)
+
 	err := d.volumeManager.Unmount(targetPath)
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "Failed to unmount volume at path %q: %v", targetPath, err)
 	}
 
+	d.publishedTargets.Remove(req.GetVolumeId(), targetPath)
+
+	// Unlike persistent volumes, ephemeral volumes don't outlive their pod.
+	vs := d.volumeManager.GetVolumeStateByID(req.GetVolumeId())
+	if vs != nil && vs.Ephemeral {
+		err = d.deleteEphemeralVolume(vs.ID)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "Failed to delete ephemeral volume: %v", err)
+		}
+	}
+
 	return &csi.NodeUnpublishVolumeResponse{}, nil
 }
 
 func (d *driver) NodeGetInfo(ctx context.Context, req *csi.NodeGetInfoRequest) (*csi.NodeGetInfoResponse, error) {
-	klog.V(4).InfoS("New request", "server", "node", "function", "NodeGetInfo", "request", protosanitizer.StripSecrets(req))
+	var accessibleTopology *csi.Topology
+	if !d.topologyDisabled {
+		accessibleTopology = d.getNodeAccessibleTopology()
+	}
 
 	return &csi.NodeGetInfoResponse{
 		NodeId:             d.nodeName,
-		MaxVolumesPerNode:  int64(limit.MaxLimits),
-		AccessibleTopology: d.getNodeAccessibleTopology(),
+		MaxVolumesPerNode:  d.maxVolumesPerNode,
+		AccessibleTopology: accessibleTopology,
 	}, nil
 }
 
-func (d *driver) NodeGetVolumeStats(ctx context.Context, req *csi.NodeGetVolumeStatsRequest) (*csi.NodeGetVolumeStatsResponse, error) {
-	klog.V(4).InfoS("New request", "server", "node", "function", "NodeGetVolumeStats", "request", protosanitizer.StripSecrets(req))
+// logVolumeIOStats logs IO statistics of the volume when they are enabled and the volume has its own loop device.
+func (d *driver) logVolumeIOStats(volumeID string) {
+	if !d.volumeIOStatsEnabled || !klog.V(4).Enabled() {
+		return
+	}
+
+	stats, err := d.volumeManager.GetVolumeIOStats(volumeID)
+	if err != nil {
+		klog.ErrorS(err, "Can't get volume IO statistics", "volumeID", volumeID)
+		return
+	}
 
+	if stats == nil {
+		return
+	}
+
+	klog.V(4).InfoS("Volume IO", "volumeID", volumeID, "readOperations", stats.ReadOperations, "readBytes", stats.ReadBytes, "writeOperations", stats.WriteOperations, "writeBytes", stats.WriteBytes)
+}
+
+func (d *driver) NodeGetVolumeStats(ctx context.Context, req *csi.NodeGetVolumeStatsRequest) (*csi.NodeGetVolumeStatsResponse, error) {
 	volumeID := req.GetVolumeId()
 	if len(volumeID) == 0 {
 		return nil, status.Errorf(codes.InvalidArgument, "VolumeID not provided")
 	}
 
+	err := volume.ValidateVolumeID(volumeID)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "Invalid volume ID: %v", err)
+	}
+
 	volumePath := req.GetVolumePath()
 	if len(volumePath) == 0 {
 		return nil, status.Errorf(codes.InvalidArgument, "VolumePath not provided")
 	}
 
-	_, err := os.Lstat(volumePath)
+	_, err = os.Lstat(volumePath)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return nil, status.Errorf(codes.NotFound, "volume path %q does not exist", volumePath)
@@ -120,11 +530,37 @@ func (d *driver) NodeGetVolumeStats(ctx context.Context, req *csi.NodeGetVolumeS
 		return nil, status.Errorf(codes.Internal, "Failed to stat volume %q: %v", volumePath, err)
 	}
 
+	// Statfs of a device node reports the filesystem holding the node rather than the volume.
+	vs := d.volumeManager.GetVolumeStateByID(volumeID)
+	if vs != nil && vs.AccessType == volume.BlockAccess {
+		d.logVolumeIOStats(volumeID)
+
+		return &csi.NodeGetVolumeStatsResponse{
+			Usage: []*csi.VolumeUsage{
+				{
+					Total: vs.Size,
+					Unit:  csi.VolumeUsage_BYTES,
+				},
+			},
+		}, nil
+	}
+
 	volumeStats, err := d.volumeManager.GetVolumeStatistics(volumePath)
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "Failed to get volume %q statistics: %v", volumeID, err)
 	}
 
+	// Inodes are shared by all volumes on the filesystem unless the volume has its own limit.
+	if vs != nil && vs.InodeLimit > 0 && volumeStats.TotalInodes > int64(vs.InodeLimit) {
+		volumeStats.TotalInodes = int64(vs.InodeLimit)
+		volumeStats.AvailableInodes = max(0, volumeStats.TotalInodes-volumeStats.UsedInodes)
+	}
+
+	if vs != nil {
+		klog.V(4).InfoS("Volume usage", "volumeID", volumeID, "limitID", vs.LimitID, "volumePath", volumePath, "usedBytes", volumeStats.UsedBytes, "totalBytes", volumeStats.TotalBytes, "usedInodes", volumeStats.UsedInodes, "totalInodes", volumeStats.TotalInodes)
+		d.logVolumeIOStats(volumeID)
+	}
+
 	return &csi.NodeGetVolumeStatsResponse{
 		Usage: []*csi.VolumeUsage{
 			{
@@ -0,0 +1,1063 @@
+// Copyright (c) 2023 ScyllaDB.
+
+package driver
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"sync/atomic"
+	"syscall"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/scylladb/local-csi-driver/pkg/driver/volume"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/mount-utils"
+)
+
+func TestNodePublishVolumeMountFlagsConflictingWithAccessMode(t *testing.T) {
+	t.Parallel()
+
+	tt := []struct {
+		name         string
+		accessMode   csi.VolumeCapability_AccessMode_Mode
+		readOnly     bool
+		mountFlags   []string
+		expectedCode codes.Code
+	}{
+		{
+			name:         "read-write volume with rw flag",
+			accessMode:   csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+			mountFlags:   []string{"rw"},
+			expectedCode: codes.OK,
+		},
+		{
+			name:         "read-only access mode without flags",
+			accessMode:   csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY,
+			expectedCode: codes.OK,
+		},
+		{
+			name:         "read-only access mode with ro flag",
+			accessMode:   csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY,
+			mountFlags:   []string{"ro", "noatime"},
+			expectedCode: codes.OK,
+		},
+		{
+			name:         "read-only access mode with rw flag",
+			accessMode:   csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY,
+			mountFlags:   []string{"noatime", "rw"},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			name:         "readonly publish with rw flag",
+			accessMode:   csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+			readOnly:     true,
+			mountFlags:   []string{"rw"},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			name:         "both ro and rw flags",
+			accessMode:   csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+			mountFlags:   []string{"ro", "rw"},
+			expectedCode: codes.InvalidArgument,
+		},
+	}
+
+	for i := range tt {
+		tc := tt[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			d := newTestDriver(t)
+			ctx := context.Background()
+
+			resp, err := d.CreateVolume(ctx, newCreateVolumeRequest("volume", 1024))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = d.NodePublishVolume(ctx, &csi.NodePublishVolumeRequest{
+				VolumeId:          resp.Volume.VolumeId,
+				StagingTargetPath: filepath.Join(t.TempDir(), "staging"),
+				TargetPath:        filepath.Join(t.TempDir(), "target"),
+				Readonly:          tc.readOnly,
+				VolumeCapability: &csi.VolumeCapability{
+					AccessType: &csi.VolumeCapability_Mount{
+						Mount: &csi.VolumeCapability_MountVolume{
+							MountFlags: tc.mountFlags,
+						},
+					},
+					AccessMode: &csi.VolumeCapability_AccessMode{
+						Mode: tc.accessMode,
+					},
+				},
+			})
+			if status.Code(err) != tc.expectedCode {
+				t.Errorf("expected %v code, got %v", tc.expectedCode, err)
+			}
+		})
+	}
+}
+
+func TestNodePublishVolumeMountFlags(t *testing.T) {
+	t.Parallel()
+
+	tt := []struct {
+		name            string
+		mountFlags      []string
+		expectedCode    codes.Code
+		expectedOptions []string
+	}{
+		{
+			name:            "allowed flag",
+			mountFlags:      []string{"noexec"},
+			expectedCode:    codes.OK,
+			expectedOptions: []string{"bind", "noexec"},
+		},
+		{
+			name:         "rejected flag",
+			mountFlags:   []string{"nodev", "data=journal"},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			name:            "duplicate bind flags",
+			mountFlags:      []string{"bind", "nosuid", "bind", "nosuid"},
+			expectedCode:    codes.OK,
+			expectedOptions: []string{"bind", "nosuid"},
+		},
+		{
+			name:            "SELinux context passed by kubelet",
+			mountFlags:      []string{`context="system_u:object_r:container_file_t:s0:c1,c2"`, "noatime", `context="system_u:object_r:container_file_t:s0:c1,c2"`},
+			expectedCode:    codes.OK,
+			expectedOptions: []string{"bind", `context="system_u:object_r:container_file_t:s0:c1,c2"`, "noatime"},
+		},
+		{
+			name:         "conflicting SELinux contexts",
+			mountFlags:   []string{`context="system_u:object_r:container_file_t:s0:c1,c2"`, `context="system_u:object_r:container_file_t:s0:c3,c4"`},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			name:         "empty SELinux context",
+			mountFlags:   []string{"context="},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			name:         "empty quoted SELinux context",
+			mountFlags:   []string{`context=""`},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			name:         "unquoted SELinux context smuggling another mount option",
+			mountFlags:   []string{"context=x,remount"},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			name:         "quoted SELinux context followed by another mount option",
+			mountFlags:   []string{`context="x",remount`},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			name:         "SELinux context with quotes inside",
+			mountFlags:   []string{`context="x","remount"`},
+			expectedCode: codes.InvalidArgument,
+		},
+	}
+
+	for i := range tt {
+		tc := tt[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			mounter := mount.NewFakeMounter(nil)
+			d := newTestDriverWithMounter(t, mounter)
+			ctx := context.Background()
+
+			resp, err := d.CreateVolume(ctx, newCreateVolumeRequest("volume", 1024))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			volCap := newCreateVolumeRequest("volume", 1024).VolumeCapabilities[0]
+			stagingPath := filepath.Join(t.TempDir(), "staging")
+			_, err = d.NodeStageVolume(ctx, &csi.NodeStageVolumeRequest{
+				VolumeId:          resp.Volume.VolumeId,
+				StagingTargetPath: stagingPath,
+				VolumeCapability:  volCap,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			volCap.GetMount().MountFlags = tc.mountFlags
+			targetPath := filepath.Join(t.TempDir(), "target")
+			_, err = d.NodePublishVolume(ctx, &csi.NodePublishVolumeRequest{
+				VolumeId:          resp.Volume.VolumeId,
+				StagingTargetPath: stagingPath,
+				TargetPath:        targetPath,
+				VolumeCapability:  volCap,
+			})
+			if status.Code(err) != tc.expectedCode {
+				t.Fatalf("expected %v code, got %v", tc.expectedCode, err)
+			}
+
+			mountPoints, err := mounter.List()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var targetMountPoint *mount.MountPoint
+			for i := range mountPoints {
+				if mountPoints[i].Path == targetPath {
+					targetMountPoint = &mountPoints[i]
+				}
+			}
+
+			if tc.expectedOptions == nil {
+				if targetMountPoint != nil {
+					t.Errorf("expected target not to be mounted, got %#v", targetMountPoint)
+				}
+				return
+			}
+
+			if targetMountPoint == nil {
+				t.Fatalf("expected target to be mounted, got %#v", mountPoints)
+			}
+
+			if !reflect.DeepEqual(targetMountPoint.Opts, tc.expectedOptions) {
+				t.Errorf("expected mount options %q, got %q", tc.expectedOptions, targetMountPoint.Opts)
+			}
+		})
+	}
+}
+
+func TestNodeGetCapabilitiesAdvertisesVolumeMountGroupWhenEnabled(t *testing.T) {
+	t.Parallel()
+
+	tt := []struct {
+		name     string
+		enabled  bool
+		expected bool
+	}{
+		{
+			name:     "disabled by default",
+			expected: false,
+		},
+		{
+			name:     "enabled",
+			enabled:  true,
+			expected: true,
+		},
+	}
+
+	for i := range tt {
+		tc := tt[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			d := newTestDriver(t)
+			WithVolumeMountGroup(tc.enabled)(d)
+
+			resp, err := d.NodeGetCapabilities(context.Background(), &csi.NodeGetCapabilitiesRequest{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			advertised := false
+			for _, c := range resp.GetCapabilities() {
+				if c.GetRpc().GetType() == csi.NodeServiceCapability_RPC_VOLUME_MOUNT_GROUP {
+					advertised = true
+				}
+			}
+			if advertised != tc.expected {
+				t.Errorf("expected VOLUME_MOUNT_GROUP to be advertised %v, got %v", tc.expected, advertised)
+			}
+		})
+	}
+}
+
+func TestNodePublishVolumeAppliesVolumeMountGroup(t *testing.T) {
+	t.Parallel()
+
+	tt := []struct {
+		name                 string
+		parameters           map[string]string
+		readOnly             bool
+		rootMode             os.FileMode
+		expectedRootGroup    bool
+		expectedContentGroup bool
+	}{
+		{
+			name:                 "recursively by default",
+			expectedRootGroup:    true,
+			expectedContentGroup: true,
+		},
+		{
+			name: "only root directory when volume opts in",
+			parameters: map[string]string{
+				VolumeMountGroupParameterKey: "true",
+			},
+			expectedRootGroup: true,
+		},
+		{
+			name:     "not at all for read-only volumes",
+			readOnly: true,
+		},
+		{
+			name:              "not recursively when root directory already has the group",
+			rootMode:          0770 | os.ModeSetgid,
+			expectedRootGroup: true,
+		},
+	}
+
+	for i := range tt {
+		tc := tt[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			d := newTestDriver(t)
+			ctx := context.Background()
+
+			req := newCreateVolumeRequest("volume", 1024)
+			req.Parameters = tc.parameters
+			resp, err := d.CreateVolume(ctx, req)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			volCap := newCreateVolumeRequest("volume", 1024).VolumeCapabilities[0]
+			stagingPath := filepath.Join(t.TempDir(), "staging")
+			_, err = d.NodeStageVolume(ctx, &csi.NodeStageVolumeRequest{
+				VolumeId:          resp.Volume.VolumeId,
+				StagingTargetPath: stagingPath,
+				VolumeCapability:  volCap,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Fake mounter doesn't mount, so content is created at the target directly.
+			targetPath := filepath.Join(t.TempDir(), "target")
+			err = os.Mkdir(targetPath, 0700)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.rootMode != 0 {
+				err = os.Chmod(targetPath, tc.rootMode)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			filePath := filepath.Join(targetPath, "file")
+			err = os.WriteFile(filePath, nil, 0600)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			gid := os.Getgid()
+			volCap.GetMount().VolumeMountGroup = strconv.Itoa(gid)
+			_, err = d.NodePublishVolume(ctx, &csi.NodePublishVolumeRequest{
+				VolumeId:          resp.Volume.VolumeId,
+				StagingTargetPath: stagingPath,
+				TargetPath:        targetPath,
+				Readonly:          tc.readOnly,
+				VolumeCapability:  volCap,
+				VolumeContext:     resp.Volume.VolumeContext,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			fi, err := os.Stat(targetPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rootGroup := fi.Sys().(*syscall.Stat_t).Gid == uint32(gid) && fi.Mode()&os.ModeSetgid != 0 && fi.Mode().Perm() == 0770
+			if rootGroup != tc.expectedRootGroup {
+				t.Errorf("expected root directory owned by group %d %v, got %v mode", gid, tc.expectedRootGroup, fi.Mode())
+			}
+
+			fi, err = os.Stat(filePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			contentGroup := fi.Mode().Perm() == 0660
+			if contentGroup != tc.expectedContentGroup {
+				t.Errorf("expected content owned by group %d %v, got %v mode", gid, tc.expectedContentGroup, fi.Mode())
+			}
+		})
+	}
+}
+
+func TestNodePublishVolumeRejectsInvalidVolumeMountGroup(t *testing.T) {
+	t.Parallel()
+
+	d := newTestDriver(t)
+	ctx := context.Background()
+
+	resp, err := d.CreateVolume(ctx, newCreateVolumeRequest("volume", 1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	volCap := newCreateVolumeRequest("volume", 1024).VolumeCapabilities[0]
+	volCap.GetMount().VolumeMountGroup = "group"
+	_, err = d.NodePublishVolume(ctx, &csi.NodePublishVolumeRequest{
+		VolumeId:          resp.Volume.VolumeId,
+		StagingTargetPath: filepath.Join(t.TempDir(), "staging"),
+		TargetPath:        filepath.Join(t.TempDir(), "target"),
+		VolumeCapability:  volCap,
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected %v code, got %v", codes.InvalidArgument, err)
+	}
+}
+
+func TestNodeStageAndUnstageVolumeAreIdempotent(t *testing.T) {
+	t.Parallel()
+
+	mounter := mount.NewFakeMounter(nil)
+	d := newTestDriverWithMounter(t, mounter)
+	ctx := context.Background()
+
+	resp, err := d.CreateVolume(ctx, newCreateVolumeRequest("volume", 1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	volumeID := resp.Volume.VolumeId
+	stagingPath := filepath.Join(t.TempDir(), "staging")
+	stageReq := &csi.NodeStageVolumeRequest{
+		VolumeId:          volumeID,
+		StagingTargetPath: stagingPath,
+		VolumeCapability:  newCreateVolumeRequest("volume", 1024).VolumeCapabilities[0],
+	}
+
+	for i := 0; i < 2; i++ {
+		_, err = d.NodeStageVolume(ctx, stageReq)
+		if err != nil {
+			t.Fatalf("stage #%d: %v", i, err)
+		}
+	}
+
+	mountPoints, err := mounter.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(mountPoints) != 1 || mountPoints[0].Path != stagingPath {
+		t.Errorf("expected single mount point at %q, got %#v", stagingPath, mountPoints)
+	}
+
+	targetPath := filepath.Join(t.TempDir(), "target")
+	_, err = d.NodePublishVolume(ctx, &csi.NodePublishVolumeRequest{
+		VolumeId:          volumeID,
+		StagingTargetPath: stagingPath,
+		TargetPath:        targetPath,
+		VolumeCapability:  stageReq.VolumeCapability,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mountPoints, err = mounter.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Fake mounter resolves bind mount sources to the underlying device.
+	if len(mountPoints) != 2 || mountPoints[1].Device != mountPoints[0].Device || mountPoints[1].Path != targetPath {
+		t.Errorf("expected target path to be bind mounted from staged volume, got %#v", mountPoints)
+	}
+
+	for i := 0; i < 2; i++ {
+		_, err = d.NodeUnstageVolume(ctx, &csi.NodeUnstageVolumeRequest{
+			VolumeId:          volumeID,
+			StagingTargetPath: stagingPath,
+		})
+		if err != nil {
+			t.Fatalf("unstage #%d: %v", i, err)
+		}
+	}
+
+	mountPoints, err = mounter.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, mp := range mountPoints {
+		if mp.Path == stagingPath {
+			t.Errorf("expected staging path to be unmounted, got %#v", mountPoints)
+		}
+	}
+}
+
+func TestNodeStageVolumeOfUnknownVolume(t *testing.T) {
+	t.Parallel()
+
+	d := newTestDriver(t)
+
+	_, err := d.NodeStageVolume(context.Background(), &csi.NodeStageVolumeRequest{
+		VolumeId:          "unknown",
+		StagingTargetPath: filepath.Join(t.TempDir(), "staging"),
+		VolumeCapability:  newCreateVolumeRequest("volume", 1024).VolumeCapabilities[0],
+	})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("expected %v code, got %v", codes.NotFound, err)
+	}
+}
+
+func TestNodePublishAndUnpublishVolumeAreIdempotent(t *testing.T) {
+	t.Parallel()
+
+	mounter := mount.NewFakeMounter(nil)
+	d := newTestDriverWithMounter(t, mounter)
+	ctx := context.Background()
+
+	resp, err := d.CreateVolume(ctx, newCreateVolumeRequest("volume", 1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	volumeID := resp.Volume.VolumeId
+	volCap := newCreateVolumeRequest("volume", 1024).VolumeCapabilities[0]
+	stagingPath := filepath.Join(t.TempDir(), "staging")
+	_, err = d.NodeStageVolume(ctx, &csi.NodeStageVolumeRequest{
+		VolumeId:          volumeID,
+		StagingTargetPath: stagingPath,
+		VolumeCapability:  volCap,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	targetPath := filepath.Join(t.TempDir(), "target")
+	publishReq := &csi.NodePublishVolumeRequest{
+		VolumeId:          volumeID,
+		StagingTargetPath: stagingPath,
+		TargetPath:        targetPath,
+		VolumeCapability:  volCap,
+	}
+	for i := 0; i < 2; i++ {
+		_, err = d.NodePublishVolume(ctx, publishReq)
+		if err != nil {
+			t.Fatalf("publish #%d: %v", i, err)
+		}
+	}
+
+	mountPoints, err := mounter.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mountPoints) != 2 {
+		t.Errorf("expected staging and a single target mount point, got %#v", mountPoints)
+	}
+
+	for i := 0; i < 2; i++ {
+		_, err = d.NodeUnpublishVolume(ctx, &csi.NodeUnpublishVolumeRequest{
+			VolumeId:   volumeID,
+			TargetPath: targetPath,
+		})
+		if err != nil {
+			t.Fatalf("unpublish #%d: %v", i, err)
+		}
+	}
+
+	mountPoints, err = mounter.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mountPoints) != 1 || mountPoints[0].Path != stagingPath {
+		t.Errorf("expected only staging mount point to be left, got %#v", mountPoints)
+	}
+
+	_, err = os.Stat(targetPath)
+	if !os.IsNotExist(err) {
+		t.Errorf("expected target path to be removed, got %v", err)
+	}
+}
+
+func TestNodePublishVolumeWithUnrestoredLimit(t *testing.T) {
+	t.Parallel()
+
+	tt := []struct {
+		name                      string
+		policy                    UnrestoredQuotaPolicy
+		expectedCode              codes.Code
+		expectedUnenforcedPublish float64
+	}{
+		{
+			name:         "strict policy refuses publishing",
+			policy:       StrictUnrestoredQuotaPolicy,
+			expectedCode: codes.FailedPrecondition,
+		},
+		{
+			name:                      "permissive policy publishes and counts it",
+			policy:                    PermissiveUnrestoredQuotaPolicy,
+			expectedCode:              codes.OK,
+			expectedUnenforcedPublish: 1,
+		},
+	}
+
+	for i := range tt {
+		tc := tt[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			mounter := mount.NewFakeMounter(nil)
+			d := newTestDriverWithMounter(t, mounter)
+			WithUnrestoredQuotaPolicy(tc.policy)(d)
+			ctx := context.Background()
+
+			resp, err := d.CreateVolume(ctx, newCreateVolumeRequest("volume", 1024))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			volumeID := resp.Volume.VolumeId
+			volume.WithUnrestoredLimits(volumeID)(d.volumeManager)
+
+			volCap := newCreateVolumeRequest("volume", 1024).VolumeCapabilities[0]
+			stagingPath := filepath.Join(t.TempDir(), "staging")
+			_, err = d.NodeStageVolume(ctx, &csi.NodeStageVolumeRequest{
+				VolumeId:          volumeID,
+				StagingTargetPath: stagingPath,
+				VolumeCapability:  volCap,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			targetPath := filepath.Join(t.TempDir(), "target")
+			_, err = d.NodePublishVolume(ctx, &csi.NodePublishVolumeRequest{
+				VolumeId:          volumeID,
+				StagingTargetPath: stagingPath,
+				TargetPath:        targetPath,
+				VolumeCapability:  volCap,
+			})
+			if status.Code(err) != tc.expectedCode {
+				t.Fatalf("expected %v code, got %v", tc.expectedCode, err)
+			}
+
+			isMountPoint, err := mounter.IsMountPoint(targetPath)
+			if tc.expectedCode == codes.OK && (err != nil || !isMountPoint) {
+				t.Errorf("expected target path to be mounted, got %v", err)
+			}
+			if tc.expectedCode != codes.OK && isMountPoint {
+				t.Errorf("expected target path not to be mounted")
+			}
+
+			unenforcedPublish := testutil.ToFloat64(d.metrics.unenforcedVolumesPublished)
+			if unenforcedPublish != tc.expectedUnenforcedPublish {
+				t.Errorf("expected %v unenforced publishes, got %v", tc.expectedUnenforcedPublish, unenforcedPublish)
+			}
+		})
+	}
+}
+
+func TestNodePublishVolumeRejectsTargetMountedFromElsewhere(t *testing.T) {
+	t.Parallel()
+
+	mounter := mount.NewFakeMounter(nil)
+	d := newTestDriverWithMounter(t, mounter)
+	ctx := context.Background()
+
+	resp, err := d.CreateVolume(ctx, newCreateVolumeRequest("volume", 1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	volumeID := resp.Volume.VolumeId
+	volCap := newCreateVolumeRequest("volume", 1024).VolumeCapabilities[0]
+	stagingPath := filepath.Join(t.TempDir(), "staging")
+	_, err = d.NodeStageVolume(ctx, &csi.NodeStageVolumeRequest{
+		VolumeId:          volumeID,
+		StagingTargetPath: stagingPath,
+		VolumeCapability:  volCap,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	targetPath := filepath.Join(t.TempDir(), "target")
+	err = os.Mkdir(targetPath, 0770)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = mounter.Mount("/dev/other", targetPath, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = d.NodePublishVolume(ctx, &csi.NodePublishVolumeRequest{
+		VolumeId:          volumeID,
+		StagingTargetPath: stagingPath,
+		TargetPath:        targetPath,
+		VolumeCapability:  volCap,
+	})
+	if status.Code(err) != codes.Internal {
+		t.Errorf("expected %v code, got %v", codes.Internal, err)
+	}
+}
+
+func TestNodePublishVolumeRejectsSecondTargetOfSingleWriterVolume(t *testing.T) {
+	t.Parallel()
+
+	mounter := mount.NewFakeMounter(nil)
+	d := newTestDriverWithMounter(t, mounter)
+	ctx := context.Background()
+
+	resp, err := d.CreateVolume(ctx, newCreateVolumeRequest("volume", 1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	volumeID := resp.Volume.VolumeId
+	volCap := newCreateVolumeRequest("volume", 1024).VolumeCapabilities[0]
+	stagingPath := filepath.Join(t.TempDir(), "staging")
+	_, err = d.NodeStageVolume(ctx, &csi.NodeStageVolumeRequest{
+		VolumeId:          volumeID,
+		StagingTargetPath: stagingPath,
+		VolumeCapability:  volCap,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newPublishRequest := func(targetPath string) *csi.NodePublishVolumeRequest {
+		return &csi.NodePublishVolumeRequest{
+			VolumeId:          volumeID,
+			StagingTargetPath: stagingPath,
+			TargetPath:        targetPath,
+			VolumeCapability:  volCap,
+		}
+	}
+
+	firstTargetPath := filepath.Join(t.TempDir(), "target")
+	_, err = d.NodePublishVolume(ctx, newPublishRequest(firstTargetPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secondTargetPath := filepath.Join(t.TempDir(), "target")
+	_, err = d.NodePublishVolume(ctx, newPublishRequest(secondTargetPath))
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("expected %v code, got %v", codes.FailedPrecondition, err)
+	}
+
+	_, err = os.Stat(secondTargetPath)
+	if !os.IsNotExist(err) {
+		t.Errorf("expected second target path not to be created, got %v", err)
+	}
+
+	_, err = d.NodeUnpublishVolume(ctx, &csi.NodeUnpublishVolumeRequest{
+		VolumeId:   volumeID,
+		TargetPath: firstTargetPath,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = d.NodePublishVolume(ctx, newPublishRequest(secondTargetPath))
+	if err != nil {
+		t.Errorf("expected volume to be published once unpublished from the first target, got %v", err)
+	}
+}
+
+func TestNodePublishVolumeSyncsVolumesWithSyncInterval(t *testing.T) {
+	t.Parallel()
+
+	mounter := mount.NewFakeMounter(nil)
+	d := newTestDriverWithMounter(t, mounter)
+	d.syncer = newVolumeSyncer(1)
+	var syncedUnmounted atomic.Bool
+	d.syncer.syncfs = func(path string) error {
+		if !isFakeMountPoint(mounter, path) {
+			syncedUnmounted.Store(true)
+		}
+		return nil
+	}
+	ctx := context.Background()
+
+	newPublishRequest := func(name string) *csi.NodePublishVolumeRequest {
+		req := newCreateVolumeRequest(name, 1024)
+		req.Parameters = map[string]string{
+			SyncIntervalParameterKey: "10ms",
+		}
+		resp, err := d.CreateVolume(ctx, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if resp.Volume.VolumeContext[SyncIntervalParameterKey] != "10ms" {
+			t.Fatalf("expected sync interval in volume context, got %v", resp.Volume.VolumeContext)
+		}
+
+		stagingPath := filepath.Join(t.TempDir(), "staging")
+		_, err = d.NodeStageVolume(ctx, &csi.NodeStageVolumeRequest{
+			VolumeId:          resp.Volume.VolumeId,
+			StagingTargetPath: stagingPath,
+			VolumeCapability:  req.VolumeCapabilities[0],
+			VolumeContext:     resp.Volume.VolumeContext,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return &csi.NodePublishVolumeRequest{
+			VolumeId:          resp.Volume.VolumeId,
+			StagingTargetPath: stagingPath,
+			TargetPath:        filepath.Join(t.TempDir(), "target"),
+			VolumeCapability:  req.VolumeCapabilities[0],
+			VolumeContext:     resp.Volume.VolumeContext,
+		}
+	}
+
+	firstReq := newPublishRequest("first")
+	_, err := d.NodePublishVolume(ctx, firstReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !d.syncer.IsSynced(firstReq.TargetPath) {
+		t.Errorf("expected target path %q to be synced", firstReq.TargetPath)
+	}
+
+	secondReq := newPublishRequest("second")
+	_, err = d.NodePublishVolume(ctx, secondReq)
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("expected %v code, got %v", codes.ResourceExhausted, err)
+	}
+
+	if d.syncer.IsSynced(secondReq.TargetPath) {
+		t.Errorf("expected target path %q not to be synced", secondReq.TargetPath)
+	}
+
+	if isFakeMountPoint(mounter, secondReq.TargetPath) {
+		t.Errorf("expected target path %q which can't be synced to be unmounted", secondReq.TargetPath)
+	}
+
+	_, err = d.NodeUnpublishVolume(ctx, &csi.NodeUnpublishVolumeRequest{
+		VolumeId:   firstReq.VolumeId,
+		TargetPath: firstReq.TargetPath,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if d.syncer.IsSynced(firstReq.TargetPath) {
+		t.Errorf("expected target path %q not to be synced once unpublished", firstReq.TargetPath)
+	}
+
+	_, err = d.NodePublishVolume(ctx, secondReq)
+	if err != nil {
+		t.Errorf("expected volume to be published once another one stopped being synced, got %v", err)
+	}
+
+	if syncedUnmounted.Load() {
+		t.Errorf("expected volumes to be synced only once they're mounted")
+	}
+}
+
+func isFakeMountPoint(mounter *mount.FakeMounter, path string) bool {
+	mountPoints, err := mounter.List()
+	if err != nil {
+		return false
+	}
+
+	for _, mp := range mountPoints {
+		if mp.Path == path {
+			return true
+		}
+	}
+
+	return false
+}
+
+func TestNodeUnpublishVolumeDeletesOnlyEphemeralVolumes(t *testing.T) {
+	t.Parallel()
+
+	mounter := mount.NewFakeMounter(nil)
+	d := newTestDriverWithMounter(t, mounter)
+	ctx := context.Background()
+	volCap := newCreateVolumeRequest("volume", 1024).VolumeCapabilities[0]
+
+	resp, err := d.CreateVolume(ctx, newCreateVolumeRequest("persistent", 1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	persistentID := resp.Volume.VolumeId
+	stagingPath := filepath.Join(t.TempDir(), "staging")
+	_, err = d.NodeStageVolume(ctx, &csi.NodeStageVolumeRequest{
+		VolumeId:          persistentID,
+		StagingTargetPath: stagingPath,
+		VolumeCapability:  volCap,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	persistentTarget := filepath.Join(t.TempDir(), "persistent")
+	_, err = d.NodePublishVolume(ctx, &csi.NodePublishVolumeRequest{
+		VolumeId:          persistentID,
+		StagingTargetPath: stagingPath,
+		TargetPath:        persistentTarget,
+		VolumeCapability:  volCap,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const ephemeralID = "csi-ephemeral"
+	ephemeralTarget := filepath.Join(t.TempDir(), "ephemeral")
+	ephemeralReq := &csi.NodePublishVolumeRequest{
+		VolumeId:         ephemeralID,
+		TargetPath:       ephemeralTarget,
+		VolumeCapability: volCap,
+		VolumeContext: map[string]string{
+			EphemeralContextKey:    "true",
+			podNameContextKey:      "pod",
+			podNamespaceContextKey: "default",
+			SizeParameterKey:       "1Mi",
+		},
+	}
+	for i := 0; i < 2; i++ {
+		_, err = d.NodePublishVolume(ctx, ephemeralReq)
+		if err != nil {
+			t.Fatalf("publish #%d: %v", i, err)
+		}
+	}
+
+	vs := d.volumeManager.GetVolumeStateByID(ephemeralID)
+	if vs == nil {
+		t.Fatalf("expected ephemeral volume to be created")
+	}
+	if !vs.Ephemeral || vs.Size != 1024*1024 {
+		t.Errorf("expected ephemeral volume of 1Mi, got %#v", vs)
+	}
+
+	ephemeralPath := vs.VolumePath(d.volumeManager.VolumesDir())
+	mountPoints, err := mounter.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	published := false
+	for _, mp := range mountPoints {
+		if mp.Path == ephemeralTarget && mp.Device == ephemeralPath {
+			published = true
+		}
+	}
+	if !published {
+		t.Errorf("expected ephemeral volume directory to be mounted at %q, got %#v", ephemeralTarget, mountPoints)
+	}
+
+	for _, tc := range []struct {
+		volumeID   string
+		targetPath string
+	}{
+		{volumeID: persistentID, targetPath: persistentTarget},
+		{volumeID: ephemeralID, targetPath: ephemeralTarget},
+	} {
+		_, err = d.NodeUnpublishVolume(ctx, &csi.NodeUnpublishVolumeRequest{
+			VolumeId:   tc.volumeID,
+			TargetPath: tc.targetPath,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if d.volumeManager.GetVolumeStateByID(persistentID) == nil {
+		t.Errorf("expected persistent volume to outlive its publication")
+	}
+
+	_, err = os.Stat(filepath.Join(d.volumeManager.VolumesDir(), persistentID))
+	if err != nil {
+		t.Errorf("expected persistent volume directory to be kept, got %v", err)
+	}
+
+	if d.volumeManager.GetVolumeStateByID(ephemeralID) != nil {
+		t.Errorf("expected ephemeral volume state to be removed")
+	}
+
+	_, err = os.Stat(ephemeralPath)
+	if !os.IsNotExist(err) {
+		t.Errorf("expected ephemeral volume directory to be removed, got %v", err)
+	}
+}
+
+func TestNodePublishVolumeRejectsInvalidEphemeralVolume(t *testing.T) {
+	t.Parallel()
+
+	tt := []struct {
+		name          string
+		volumeContext map[string]string
+		block         bool
+	}{
+		{
+			name: "missing size",
+			volumeContext: map[string]string{
+				EphemeralContextKey: "true",
+			},
+		},
+		{
+			name: "invalid size",
+			volumeContext: map[string]string{
+				EphemeralContextKey: "true",
+				SizeParameterKey:    "-1Gi",
+			},
+		},
+		{
+			name: "unsupported attribute",
+			volumeContext: map[string]string{
+				EphemeralContextKey:     "true",
+				SizeParameterKey:        "1Gi",
+				BackingModeParameterKey: "loop",
+			},
+		},
+		{
+			name: "block access type",
+			volumeContext: map[string]string{
+				EphemeralContextKey: "true",
+				SizeParameterKey:    "1Gi",
+			},
+			block: true,
+		},
+	}
+
+	for i := range tt {
+		tc := tt[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			d := newTestDriver(t)
+
+			volCap := newCreateVolumeRequest("volume", 1024).VolumeCapabilities[0]
+			if tc.block {
+				volCap = &csi.VolumeCapability{
+					AccessType: &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}},
+					AccessMode: volCap.AccessMode,
+				}
+			}
+
+			_, err := d.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+				VolumeId:         "csi-ephemeral",
+				TargetPath:       filepath.Join(t.TempDir(), "target"),
+				VolumeCapability: volCap,
+				VolumeContext:    tc.volumeContext,
+			})
+			if status.Code(err) != codes.InvalidArgument {
+				t.Errorf("expected %v code, got %v", codes.InvalidArgument, err)
+			}
+
+			if d.volumeManager.GetVolumeStateByID("csi-ephemeral") != nil {
+				t.Errorf("expected no volume to be created")
+			}
+		})
+	}
+}
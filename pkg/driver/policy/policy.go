@@ -0,0 +1,188 @@
+// Copyright (c) 2023 ScyllaDB.
+
+package policy
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/scylladb/local-csi-driver/pkg/util/slices"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/errors"
+	"sigs.k8s.io/yaml"
+)
+
+// Volume describes a volume which is about to be provisioned.
+type Volume struct {
+	Name             string
+	SizeBytes        int64
+	StorageClassName string
+	// PVC attributes are known only when external-provisioner runs with --extra-create-metadata.
+	PVCName      string
+	PVCNamespace string
+}
+
+// Policy decides whether volumes can be provisioned.
+type Policy interface {
+	// Admit returns a *RejectionError when the volume mustn't be provisioned.
+	Admit(v *Volume) error
+}
+
+// RejectionError is returned by policies rejecting a volume.
+type RejectionError struct {
+	Rule    string
+	Message string
+}
+
+func (e *RejectionError) Error() string {
+	return fmt.Sprintf("rejected by %q provisioning policy rule: %s", e.Rule, e.Message)
+}
+
+// NoopPolicy admits every volume.
+type NoopPolicy struct{}
+
+func (NoopPolicy) Admit(*Volume) error {
+	return nil
+}
+
+var _ Policy = NoopPolicy{}
+
+// Rule rejects volumes it matches, either all of them or only those exceeding a size.
+// Volume matches a rule when it belongs to any of its namespaces and StorageClasses, empty lists match any.
+type Rule struct {
+	Name           string             `json:"name"`
+	Namespaces     []string           `json:"namespaces,omitempty"`
+	StorageClasses []string           `json:"storageClasses,omitempty"`
+	MaxSize        *resource.Quantity `json:"maxSize,omitempty"`
+	Deny           bool               `json:"deny,omitempty"`
+	// Message is returned to the provisioner when the rule rejects a volume.
+	Message string `json:"message"`
+}
+
+// Config is the content of a provisioning policy file.
+type Config struct {
+	Rules []Rule `json:"rules"`
+}
+
+func (r *Rule) matches(v *Volume) bool {
+	if len(r.Namespaces) != 0 && !slices.Contains(r.Namespaces, v.PVCNamespace) {
+		return false
+	}
+
+	if len(r.StorageClasses) != 0 && !slices.Contains(r.StorageClasses, v.StorageClassName) {
+		return false
+	}
+
+	return true
+}
+
+func (r *Rule) rejects(v *Volume) bool {
+	if !r.matches(v) {
+		return false
+	}
+
+	if r.Deny {
+		return true
+	}
+
+	return v.SizeBytes > r.MaxSize.Value()
+}
+
+func (r *Rule) validate() error {
+	var errs []error
+
+	if len(r.Message) == 0 {
+		errs = append(errs, fmt.Errorf("message cannot be empty"))
+	}
+
+	switch {
+	case r.Deny && r.MaxSize != nil:
+		errs = append(errs, fmt.Errorf("deny and maxSize are mutually exclusive"))
+	case !r.Deny && r.MaxSize == nil:
+		errs = append(errs, fmt.Errorf("either deny or maxSize has to be set"))
+	case r.MaxSize != nil && r.MaxSize.Sign() <= 0:
+		errs = append(errs, fmt.Errorf("maxSize must be positive, got %q", r.MaxSize.String()))
+	}
+
+	err := errors.NewAggregate(errs)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// RulesPolicy rejects volumes violating any of its rules, which are evaluated in order.
+type RulesPolicy struct {
+	rules []Rule
+}
+
+var _ Policy = &RulesPolicy{}
+
+// NewRulesPolicy returns a policy evaluating the rules, which have to be valid and uniquely named.
+func NewRulesPolicy(rules []Rule) (*RulesPolicy, error) {
+	var errs []error
+	names := map[string]struct{}{}
+	for i := range rules {
+		r := &rules[i]
+		if len(r.Name) == 0 {
+			errs = append(errs, fmt.Errorf("rule %d: name cannot be empty", i))
+			continue
+		}
+
+		if _, ok := names[r.Name]; ok {
+			errs = append(errs, fmt.Errorf("rule %q: name is already used by another rule", r.Name))
+		}
+		names[r.Name] = struct{}{}
+
+		err := r.validate()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("rule %q: %w", r.Name, err))
+		}
+	}
+
+	err := errors.NewAggregate(errs)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RulesPolicy{
+		rules: rules,
+	}, nil
+}
+
+// LoadRulesPolicy returns a policy evaluating the rules of the YAML policy file at path.
+func LoadRulesPolicy(path string) (*RulesPolicy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("can't read provisioning policy file %q: %w", path, err)
+	}
+
+	// Unknown fields are most likely misspelled rule conditions, which would make rules match more volumes.
+	config := &Config{}
+	err = yaml.UnmarshalStrict(data, config)
+	if err != nil {
+		return nil, fmt.Errorf("can't parse provisioning policy file %q: %w", path, err)
+	}
+
+	p, err := NewRulesPolicy(config.Rules)
+	if err != nil {
+		return nil, fmt.Errorf("invalid provisioning policy file %q: %w", path, err)
+	}
+
+	return p, nil
+}
+
+func (p *RulesPolicy) Admit(v *Volume) error {
+	for i := range p.rules {
+		r := &p.rules[i]
+		if r.rejects(v) {
+			return &RejectionError{
+				Rule:    r.Name,
+				Message: r.Message,
+			}
+		}
+	}
+
+	return nil
+}
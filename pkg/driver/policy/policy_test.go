@@ -0,0 +1,224 @@
+// Copyright (c) 2023 ScyllaDB.
+
+package policy
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testPolicy = `
+rules:
+- name: dev-size
+  namespaces: [dev, staging]
+  maxSize: 10Gi
+  message: Volumes of dev namespaces can't be bigger than 10Gi.
+- name: legacy-class
+  storageClasses: [legacy]
+  deny: true
+  message: Legacy StorageClass is deprecated.
+- name: scylla-size
+  namespaces: [scylla]
+  storageClasses: [scylladb-local-xfs]
+  maxSize: 1Ti
+  message: Scylla volumes can't be bigger than 1Ti.
+`
+
+func TestRulesPolicyAdmit(t *testing.T) {
+	t.Parallel()
+
+	policyFile := filepath.Join(t.TempDir(), "policy.yaml")
+	err := os.WriteFile(policyFile, []byte(testPolicy), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := LoadRulesPolicy(policyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tt := []struct {
+		name         string
+		volume       Volume
+		expectedRule string
+	}{
+		{
+			name: "volume matching no rule",
+			volume: Volume{
+				SizeBytes:        100 << 40,
+				StorageClassName: "scylladb-local-xfs",
+				PVCNamespace:     "prod",
+			},
+		},
+		{
+			name: "volume within size of a matching rule",
+			volume: Volume{
+				SizeBytes:    10 << 30,
+				PVCNamespace: "staging",
+			},
+		},
+		{
+			name: "volume exceeding size of a matching rule",
+			volume: Volume{
+				SizeBytes:    10<<30 + 1,
+				PVCNamespace: "dev",
+			},
+			expectedRule: "dev-size",
+		},
+		{
+			name: "volume of a denied StorageClass in any namespace",
+			volume: Volume{
+				SizeBytes:        1,
+				StorageClassName: "legacy",
+			},
+			expectedRule: "legacy-class",
+		},
+		{
+			name: "volume matching only one of the rule conditions",
+			volume: Volume{
+				SizeBytes:        2 << 40,
+				StorageClassName: "scylladb-local-xfs",
+				PVCNamespace:     "dev",
+			},
+			expectedRule: "dev-size",
+		},
+		{
+			name: "volume violating multiple rules is rejected by the first one",
+			volume: Volume{
+				SizeBytes:        20 << 30,
+				StorageClassName: "legacy",
+				PVCNamespace:     "dev",
+			},
+			expectedRule: "dev-size",
+		},
+		{
+			name: "volume exceeding size of a rule matching both conditions",
+			volume: Volume{
+				SizeBytes:        2 << 40,
+				StorageClassName: "scylladb-local-xfs",
+				PVCNamespace:     "scylla",
+			},
+			expectedRule: "scylla-size",
+		},
+	}
+
+	for i := range tt {
+		tc := tt[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := p.Admit(&tc.volume)
+			if len(tc.expectedRule) == 0 {
+				if err != nil {
+					t.Errorf("expected volume to be admitted, got %v", err)
+				}
+				return
+			}
+
+			var rejection *RejectionError
+			if !errors.As(err, &rejection) {
+				t.Fatalf("expected rejection, got %v", err)
+			}
+
+			if rejection.Rule != tc.expectedRule {
+				t.Errorf("expected rejection by %q rule, got %q", tc.expectedRule, rejection.Rule)
+			}
+		})
+	}
+}
+
+func TestLoadRulesPolicyRejectsInvalidRules(t *testing.T) {
+	t.Parallel()
+
+	tt := []struct {
+		name   string
+		policy string
+	}{
+		{
+			name: "rule without name",
+			policy: `
+rules:
+- maxSize: 1Gi
+  message: message
+`,
+		},
+		{
+			name: "duplicate rule names",
+			policy: `
+rules:
+- name: rule
+  maxSize: 1Gi
+  message: message
+- name: rule
+  deny: true
+  message: message
+`,
+		},
+		{
+			name: "rule without message",
+			policy: `
+rules:
+- name: rule
+  maxSize: 1Gi
+`,
+		},
+		{
+			name: "rule without condition",
+			policy: `
+rules:
+- name: rule
+  message: message
+`,
+		},
+		{
+			name: "rule with both deny and maxSize",
+			policy: `
+rules:
+- name: rule
+  deny: true
+  maxSize: 1Gi
+  message: message
+`,
+		},
+		{
+			name: "rule with non-positive maxSize",
+			policy: `
+rules:
+- name: rule
+  maxSize: "0"
+  message: message
+`,
+		},
+		{
+			name: "rule with misspelled field",
+			policy: `
+rules:
+- name: rule
+  namespace: dev
+  maxSize: 1Gi
+  message: message
+`,
+		},
+	}
+
+	for i := range tt {
+		tc := tt[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			policyFile := filepath.Join(t.TempDir(), "policy.yaml")
+			err := os.WriteFile(policyFile, []byte(tc.policy), 0600)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = LoadRulesPolicy(policyFile)
+			if err == nil {
+				t.Errorf("expected error")
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+// Copyright (c) 2023 ScyllaDB.
+
+package driver
+
+import (
+	"sync"
+	"time"
+)
+
+// cachedProber memoizes the result of a probe for a TTL. Once the result expires,
+// the last known result is returned while the probe is refreshed in the background.
+type cachedProber struct {
+	probe func() error
+	ttl   time.Duration
+	now   func() time.Time
+
+	mut        sync.Mutex
+	probed     bool
+	refreshing bool
+	lastProbe  time.Time
+	lastErr    error
+}
+
+func newCachedProber(probe func() error, ttl time.Duration) *cachedProber {
+	return &cachedProber{
+		probe: probe,
+		ttl:   ttl,
+		now:   time.Now,
+	}
+}
+
+func (p *cachedProber) Probe() error {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+
+	if !p.probed || p.ttl <= 0 {
+		p.lastErr = p.probe()
+		p.lastProbe = p.now()
+		p.probed = true
+		return p.lastErr
+	}
+
+	if p.now().Sub(p.lastProbe) >= p.ttl && !p.refreshing {
+		p.refreshing = true
+		go p.refresh()
+	}
+
+	return p.lastErr
+}
+
+func (p *cachedProber) refresh() {
+	err := p.probe()
+
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	p.lastErr = err
+	p.lastProbe = p.now()
+	p.refreshing = false
+}
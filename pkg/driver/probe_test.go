@@ -0,0 +1,125 @@
+// Copyright (c) 2023 ScyllaDB.
+
+package driver
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeProbe struct {
+	mut   sync.Mutex
+	calls int
+	err   error
+}
+
+func (p *fakeProbe) Probe() error {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	p.calls++
+	return p.err
+}
+
+func (p *fakeProbe) Calls() int {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	return p.calls
+}
+
+func (p *fakeProbe) SetErr(err error) {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	p.err = err
+}
+
+func TestCachedProberHonorsTTL(t *testing.T) {
+	t.Parallel()
+
+	const ttl = 10 * time.Second
+
+	fp := &fakeProbe{}
+	cp := newCachedProber(fp.Probe, ttl)
+
+	var nowMut sync.Mutex
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	cp.now = func() time.Time {
+		nowMut.Lock()
+		defer nowMut.Unlock()
+		return now
+	}
+	advance := func(d time.Duration) {
+		nowMut.Lock()
+		defer nowMut.Unlock()
+		now = now.Add(d)
+	}
+
+	err := cp.Probe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fp.Calls() != 1 {
+		t.Fatalf("expected first probe to be synchronous, got %d calls", fp.Calls())
+	}
+
+	fp.SetErr(fmt.Errorf("read-only filesystem"))
+
+	advance(ttl / 2)
+	for i := 0; i < 10; i++ {
+		err = cp.Probe()
+		if err != nil {
+			t.Fatalf("expected cached result within TTL, got %v", err)
+		}
+	}
+	if fp.Calls() != 1 {
+		t.Fatalf("expected no probes within TTL, got %d calls", fp.Calls())
+	}
+
+	advance(ttl)
+	err = cp.Probe()
+	if err != nil {
+		t.Fatalf("expected stale result while refreshing, got %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		cp.mut.Lock()
+		refreshing := cp.refreshing
+		cp.mut.Unlock()
+		if !refreshing {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for background refresh")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if fp.Calls() != 2 {
+		t.Fatalf("expected one background refresh, got %d calls", fp.Calls())
+	}
+
+	err = cp.Probe()
+	if err == nil {
+		t.Fatal("expected refreshed probe error, got nil")
+	}
+}
+
+func TestCachedProberWithoutTTLAlwaysProbes(t *testing.T) {
+	t.Parallel()
+
+	fp := &fakeProbe{}
+	cp := newCachedProber(fp.Probe, 0)
+
+	for i := 0; i < 3; i++ {
+		err := cp.Probe()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if fp.Calls() != 3 {
+		t.Errorf("expected 3 probes, got %d", fp.Calls())
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright (c) 2023 ScyllaDB.
+
+package driver
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/scylladb/local-csi-driver/pkg/util/slices"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog/v2"
+)
+
+var (
+	// singleTargetAccessModes allow a volume to be published to at most one target path at a time.
+	singleTargetAccessModes = []csi.VolumeCapability_AccessMode_Mode{
+		csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+		csi.VolumeCapability_AccessMode_SINGLE_NODE_SINGLE_WRITER,
+	}
+)
+
+// allowsMultipleTargets returns whether a volume with the access mode can be published to more than one target path.
+func allowsMultipleTargets(mode csi.VolumeCapability_AccessMode_Mode) bool {
+	return !slices.Contains(singleTargetAccessModes, mode)
+}
+
+// publishedTargets keeps track of target paths volumes are published to.
+type publishedTargets struct {
+	mut     sync.Mutex
+	targets map[string]sets.Set[string]
+}
+
+func newPublishedTargets() *publishedTargets {
+	return &publishedTargets{
+		targets: map[string]sets.Set[string]{},
+	}
+}
+
+// Add records the volume is published to the target path and returns whether it wasn't recorded before.
+// It fails when the access mode allows a single target and the volume is already published to a different one.
+func (p *publishedTargets) Add(volumeID, targetPath string, mode csi.VolumeCapability_AccessMode_Mode) (bool, error) {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+
+	targets, ok := p.targets[volumeID]
+	if !ok {
+		targets = sets.New[string]()
+		p.targets[volumeID] = targets
+	}
+
+	if targets.Has(targetPath) {
+		return false, nil
+	}
+
+	if targets.Len() > 0 && !allowsMultipleTargets(mode) {
+		return false, fmt.Errorf("volume %q with access mode %q is already published to %q", volumeID, mode.String(), sets.List(targets))
+	}
+
+	targets.Insert(targetPath)
+
+	return true, nil
+}
+
+// Get returns the sorted target paths the volume is published to.
+func (p *publishedTargets) Get(volumeID string) []string {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+
+	return sets.List(p.targets[volumeID])
+}
+
+// Restore records the volume is published to the target path, regardless of its access mode.
+func (p *publishedTargets) Restore(volumeID, targetPath string) {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+
+	targets, ok := p.targets[volumeID]
+	if !ok {
+		targets = sets.New[string]()
+		p.targets[volumeID] = targets
+	}
+
+	targets.Insert(targetPath)
+}
+
+// Remove forgets the volume is published to the target path.
+func (p *publishedTargets) Remove(volumeID, targetPath string) {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+
+	targets, ok := p.targets[volumeID]
+	if !ok {
+		return
+	}
+
+	targets.Delete(targetPath)
+	if targets.Len() == 0 {
+		delete(p.targets, volumeID)
+	}
+}
+
+// RestorePublishedTargets records target paths volumes were published to before the driver started, as they're
+// tracked only in memory.
+func (d *driver) RestorePublishedTargets(podsDir string) {
+	targetPaths, err := d.volumeManager.GetPublishedTargetPaths(podsDir, d.name)
+	if err != nil {
+		klog.ErrorS(err, "Can't find all published volumes, some of them won't be tracked", "podsDir", podsDir)
+	}
+
+	for volumeID, paths := range targetPaths {
+		for _, targetPath := range paths {
+			d.publishedTargets.Restore(volumeID, targetPath)
+		}
+	}
+}
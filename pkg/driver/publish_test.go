@@ -0,0 +1,109 @@
+// Copyright (c) 2023 ScyllaDB.
+
+package driver
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"k8s.io/mount-utils"
+)
+
+func TestPublishedTargets(t *testing.T) {
+	t.Parallel()
+
+	tt := []struct {
+		name        string
+		mode        csi.VolumeCapability_AccessMode_Mode
+		expectedErr bool
+	}{
+		{
+			name:        "single node writer volume can't be published to a second target",
+			mode:        csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+			expectedErr: true,
+		},
+		{
+			name:        "single node single writer volume can't be published to a second target",
+			mode:        csi.VolumeCapability_AccessMode_SINGLE_NODE_SINGLE_WRITER,
+			expectedErr: true,
+		},
+		{
+			name:        "single node multi writer volume can be published to a second target",
+			mode:        csi.VolumeCapability_AccessMode_SINGLE_NODE_MULTI_WRITER,
+			expectedErr: false,
+		},
+		{
+			name:        "multi node multi writer volume can be published to a second target",
+			mode:        csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+			expectedErr: false,
+		},
+	}
+
+	for i := range tt {
+		tc := tt[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			p := newPublishedTargets()
+
+			added, err := p.Add("volume", "/target-1", tc.mode)
+			if err != nil || !added {
+				t.Fatalf("expected first target to be added, got %v, %v", added, err)
+			}
+
+			added, err = p.Add("volume", "/target-1", tc.mode)
+			if err != nil || added {
+				t.Errorf("expected republishing to the same target to be a no-op, got %v, %v", added, err)
+			}
+
+			_, err = p.Add("other-volume", "/target-3", tc.mode)
+			if err != nil {
+				t.Errorf("expected other volumes not to be affected, got %v", err)
+			}
+
+			added, err = p.Add("volume", "/target-2", tc.mode)
+			if tc.expectedErr != (err != nil) {
+				t.Errorf("expected error %v, got %v", tc.expectedErr, err)
+			}
+			if added == tc.expectedErr {
+				t.Errorf("expected second target added %v, got %v", !tc.expectedErr, added)
+			}
+
+			p.Remove("volume", "/target-1")
+			p.Remove("volume", "/target-2")
+
+			added, err = p.Add("volume", "/target-2", tc.mode)
+			if err != nil || !added {
+				t.Errorf("expected target to be added once others are removed, got %v, %v", added, err)
+			}
+		})
+	}
+}
+
+func TestRestorePublishedTargets(t *testing.T) {
+	t.Parallel()
+
+	mounter := mount.NewFakeMounter(nil)
+	d := newTestDriverWithMounter(t, mounter)
+
+	resp, err := d.CreateVolume(context.Background(), newCreateVolumeRequest("volume", 1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+	volumeID := resp.Volume.VolumeId
+
+	podsDir := t.TempDir()
+	targetPath := newKubeletPodMount(t, podsDir, "pod", volumeID)
+	mounter.MountPoints = []mount.MountPoint{
+		{Device: "/dev/volumes", Path: targetPath},
+	}
+
+	d.RestorePublishedTargets(podsDir)
+
+	expectedTargets := []string{targetPath}
+	if got := d.publishedTargets.Get(volumeID); !reflect.DeepEqual(got, expectedTargets) {
+		t.Errorf("expected %v published targets, got %v", expectedTargets, got)
+	}
+}
@@ -0,0 +1,49 @@
+// Copyright (c) 2023 ScyllaDB.
+
+package driver
+
+import (
+	"context"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/scylladb/local-csi-driver/pkg/util/slices"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+)
+
+// mutatingMethods change volumes or their mounts, so they mustn't run before volume state and quotas are restored.
+var mutatingMethods = []string{
+	csi.Controller_CreateVolume_FullMethodName,
+	csi.Controller_DeleteVolume_FullMethodName,
+	csi.Controller_ControllerExpandVolume_FullMethodName,
+	csi.Node_NodeStageVolume_FullMethodName,
+	csi.Node_NodeUnstageVolume_FullMethodName,
+	csi.Node_NodePublishVolume_FullMethodName,
+	csi.Node_NodeUnpublishVolume_FullMethodName,
+	csi.Node_NodeExpandVolume_FullMethodName,
+}
+
+// MarkReady opens the readiness gate once volume state is loaded and quotas are restored.
+func (d *driver) MarkReady() {
+	if !d.ready.Swap(true) {
+		klog.InfoS("Driver is ready")
+	}
+}
+
+// IsReady returns whether the driver completed its startup.
+func (d *driver) IsReady() bool {
+	return d.ready.Load()
+}
+
+// ReadinessUnaryInterceptor rejects mutating requests with Unavailable code until the driver is ready.
+func (d *driver) ReadinessUnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if !d.IsReady() && slices.Contains(mutatingMethods, info.FullMethod) {
+			return nil, status.Errorf(codes.Unavailable, "Driver isn't ready yet, volume state is still being loaded")
+		}
+
+		return handler(ctx, req)
+	}
+}
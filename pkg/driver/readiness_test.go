@@ -0,0 +1,65 @@
+// Copyright (c) 2023 ScyllaDB.
+
+package driver
+
+import (
+	"context"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestReadinessUnaryInterceptorRejectsMutatingRequestsUntilReady(t *testing.T) {
+	t.Parallel()
+
+	d := newTestDriver(t)
+	WithReadinessGate()(d)
+	interceptor := d.ReadinessUnaryInterceptor()
+
+	handled := 0
+	handler := func(ctx context.Context, req any) (any, error) {
+		handled++
+		return "response", nil
+	}
+
+	for _, method := range mutatingMethods {
+		_, err := interceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: method}, handler)
+		if status.Code(err) != codes.Unavailable {
+			t.Errorf("expected %v code for %s before driver is ready, got %v", codes.Unavailable, method, err)
+		}
+	}
+
+	if handled != 0 {
+		t.Errorf("expected no mutating request to be handled before driver is ready, %d were", handled)
+	}
+
+	_, err := interceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: csi.Identity_GetPluginInfo_FullMethodName}, handler)
+	if err != nil {
+		t.Errorf("expected non-mutating request to be handled before driver is ready, got %v", err)
+	}
+
+	probeResp, err := d.Probe(context.Background(), &csi.ProbeRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if probeResp.GetReady().GetValue() {
+		t.Errorf("expected probe to report driver isn't ready")
+	}
+
+	d.MarkReady()
+
+	handled = 0
+	for _, method := range mutatingMethods {
+		_, err = interceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: method}, handler)
+		if err != nil {
+			t.Errorf("expected %s to be handled once driver is ready, got %v", method, err)
+		}
+	}
+
+	if handled != len(mutatingMethods) {
+		t.Errorf("expected %d handled requests, got %d", len(mutatingMethods), handled)
+	}
+}
@@ -0,0 +1,35 @@
+// Copyright (c) 2023 ScyllaDB.
+
+package driver
+
+import (
+	"context"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+)
+
+const (
+	DefaultKubeletPodsDir = "/var/lib/kubelet/pods"
+)
+
+// RunOrphanedMountSweeper periodically unmounts mounts of no longer existing volumes left behind
+// in the kubelet pods dir, until the context is cancelled.
+func (d *driver) RunOrphanedMountSweeper(ctx context.Context, podsDir string, interval time.Duration) {
+	klog.InfoS("Starting orphaned mount sweeper", "podsDir", podsDir, "interval", interval)
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		d.sweepOrphanedMounts(podsDir)
+	}, interval)
+}
+
+func (d *driver) sweepOrphanedMounts(podsDir string) {
+	swept, err := d.volumeManager.SweepOrphanedMounts(podsDir, d.name)
+	d.metrics.orphanedMountsSwept.Add(float64(swept))
+	if err != nil {
+		klog.ErrorS(err, "Can't sweep orphaned mounts", "podsDir", podsDir)
+	}
+	if swept > 0 {
+		klog.V(2).InfoS("Swept orphaned mounts", "podsDir", podsDir, "count", swept)
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright (c) 2023 ScyllaDB.
+
+package driver
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/scylladb/local-csi-driver/pkg/driver/volume"
+	"github.com/scylladb/local-csi-driver/pkg/util/fs"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+)
+
+const (
+	DefaultMaxSyncedVolumes = 64
+)
+
+// volumeSyncer periodically syncs filesystems of published durability-critical volumes.
+type volumeSyncer struct {
+	maxSyncers int
+	syncfs     func(path string) error
+
+	mut     sync.Mutex
+	syncers map[string]context.CancelFunc
+}
+
+func newVolumeSyncer(maxSyncers int) *volumeSyncer {
+	return &volumeSyncer{
+		maxSyncers: maxSyncers,
+		syncfs:     fs.Syncfs,
+		syncers:    map[string]context.CancelFunc{},
+	}
+}
+
+// Start begins syncing the target path at the interval until it's stopped. Starting an already synced
+// target path is a no-op, it returns whether syncing was started by this call.
+func (s *volumeSyncer) Start(targetPath string, interval time.Duration) (bool, error) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	_, ok := s.syncers[targetPath]
+	if ok {
+		return false, nil
+	}
+
+	if len(s.syncers) >= s.maxSyncers {
+		return false, fmt.Errorf("maximum number of %d synced volumes reached", s.maxSyncers)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.syncers[targetPath] = cancel
+
+	klog.V(2).InfoS("Starting periodic volume sync", "targetPath", targetPath, "interval", interval)
+	go wait.UntilWithContext(ctx, func(ctx context.Context) {
+		err := s.syncfs(targetPath)
+		if err != nil {
+			klog.ErrorS(err, "Can't sync volume", "targetPath", targetPath)
+		}
+	}, interval)
+
+	return true, nil
+}
+
+// Stop stops syncing the target path. Stopping a target path which isn't synced is a no-op.
+func (s *volumeSyncer) Stop(targetPath string) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	cancel, ok := s.syncers[targetPath]
+	if !ok {
+		return
+	}
+
+	klog.V(2).InfoS("Stopping periodic volume sync", "targetPath", targetPath)
+	cancel()
+	delete(s.syncers, targetPath)
+}
+
+// ResumeVolumeSyncs starts periodic syncs of volumes which were published before the driver started, as syncs
+// don't outlive the driver. Volumes created before their sync interval was recorded are synced once republished.
+func (d *driver) ResumeVolumeSyncs(podsDir string) {
+	targetPaths, err := d.volumeManager.GetPublishedTargetPaths(podsDir, d.name)
+	if err != nil {
+		klog.ErrorS(err, "Can't find all published volumes, some of them won't be synced", "podsDir", podsDir)
+	}
+
+	for volumeID, paths := range targetPaths {
+		vs := d.volumeManager.GetVolumeStateByID(volumeID)
+		if vs == nil || vs.SyncInterval <= 0 || vs.AccessType == volume.BlockAccess {
+			continue
+		}
+
+		for _, targetPath := range paths {
+			_, err = d.syncer.Start(targetPath, vs.SyncInterval)
+			if err != nil {
+				klog.ErrorS(err, "Can't resume periodic volume sync", "volumeID", volumeID, "targetPath", targetPath)
+			}
+		}
+	}
+}
+
+// IsSynced returns whether the target path is being synced.
+func (s *volumeSyncer) IsSynced(targetPath string) bool {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	_, ok := s.syncers[targetPath]
+	return ok
+}
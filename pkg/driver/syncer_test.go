@@ -0,0 +1,108 @@
+// Copyright (c) 2023 ScyllaDB.
+
+package driver
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/mount-utils"
+)
+
+func TestVolumeSyncer(t *testing.T) {
+	t.Parallel()
+
+	var syncs atomic.Int64
+	s := newVolumeSyncer(1)
+	s.syncfs = func(string) error {
+		syncs.Add(1)
+		return nil
+	}
+
+	started, err := s.Start("/target", time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !started {
+		t.Errorf("expected syncing to be started")
+	}
+
+	started, err = s.Start("/target", time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if started {
+		t.Errorf("expected starting already synced target path to be a no-op")
+	}
+
+	_, err = s.Start("/other-target", time.Millisecond)
+	if err == nil {
+		t.Errorf("expected error when maximum number of synced volumes is reached")
+	}
+
+	err = wait.PollUntilContextTimeout(t.Context(), time.Millisecond, wait.ForeverTestTimeout, true, func(context.Context) (bool, error) {
+		return syncs.Load() > 1, nil
+	})
+	if err != nil {
+		t.Fatalf("expected target path to be synced periodically: %v", err)
+	}
+
+	s.Stop("/target")
+	if s.IsSynced("/target") {
+		t.Errorf("expected target path not to be synced once stopped")
+	}
+
+	_, err = s.Start("/other-target", time.Millisecond)
+	if err != nil {
+		t.Errorf("expected syncing to be started once another target path was stopped, got %v", err)
+	}
+	s.Stop("/other-target")
+}
+
+func TestResumeVolumeSyncs(t *testing.T) {
+	t.Parallel()
+
+	podsDir := t.TempDir()
+	mounter := mount.NewFakeMounter(nil)
+	d := newTestDriverWithMounter(t, mounter)
+	d.syncer = newVolumeSyncer(2)
+	d.syncer.syncfs = func(string) error {
+		return nil
+	}
+
+	ctx := context.Background()
+	createVolume := func(name string, parameters map[string]string) string {
+		req := newCreateVolumeRequest(name, 1024)
+		req.Parameters = parameters
+		resp, err := d.CreateVolume(ctx, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.Volume.VolumeId
+	}
+
+	syncedVolumeID := createVolume("synced", map[string]string{SyncIntervalParameterKey: "10ms"})
+	notSyncedVolumeID := createVolume("not-synced", nil)
+
+	syncedTargetPath := newKubeletPodMount(t, podsDir, "synced", syncedVolumeID)
+	notSyncedTargetPath := newKubeletPodMount(t, podsDir, "not-synced", notSyncedVolumeID)
+	mounter.MountPoints = []mount.MountPoint{
+		{Device: "/dev/volumes", Path: syncedTargetPath},
+		{Device: "/dev/volumes", Path: notSyncedTargetPath},
+	}
+
+	d.ResumeVolumeSyncs(podsDir)
+	t.Cleanup(func() {
+		d.syncer.Stop(syncedTargetPath)
+	})
+
+	if !d.syncer.IsSynced(syncedTargetPath) {
+		t.Errorf("expected target path %q of volume with sync interval to be synced", syncedTargetPath)
+	}
+	if d.syncer.IsSynced(notSyncedTargetPath) {
+		t.Errorf("expected target path %q of volume without sync interval not to be synced", notSyncedTargetPath)
+	}
+}
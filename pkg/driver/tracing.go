@@ -0,0 +1,112 @@
+// Copyright (c) 2023 ScyllaDB.
+
+package driver
+
+import (
+	"context"
+	"path"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"k8s.io/component-base/tracing"
+)
+
+const tracerName = "github.com/scylladb/local-csi-driver/pkg/driver"
+
+// Attributes of spans of operations on a single volume.
+const (
+	volumeIDAttributeKey   = attribute.Key("csi.volume.id")
+	volumeSizeAttributeKey = attribute.Key("csi.volume.size_bytes")
+)
+
+// TracingUnaryInterceptor makes every request a server span, child of the span propagated by the caller in request
+// metadata, so requests of external-provisioner and kubelet are traced together with them.
+func TracingUnaryInterceptor(tp trace.TracerProvider) grpc.UnaryServerInterceptor {
+	tracer := tp.Tracer(tracerName)
+	propagator := tracing.Propagators()
+
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		ctx = propagator.Extract(ctx, metadataCarrier(md))
+
+		ctx, span := tracer.Start(ctx, strings.TrimPrefix(info.FullMethod, "/"),
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("rpc.system", "grpc"),
+				attribute.String("rpc.method", path.Base(info.FullMethod)),
+			),
+		)
+		defer span.End()
+
+		resp, err := handler(ctx, req)
+		span.SetAttributes(attribute.Int64("rpc.grpc.status_code", int64(status.Code(err))))
+		if err != nil {
+			span.SetStatus(otelcodes.Error, status.Convert(err).Message())
+		}
+
+		return resp, err
+	}
+}
+
+// metadataCarrier exposes gRPC metadata to propagators.
+type metadataCarrier metadata.MD
+
+var _ propagation.TextMapCarrier = metadataCarrier{}
+
+func (c metadataCarrier) Get(key string) string {
+	values := metadata.MD(c).Get(key)
+	if len(values) == 0 {
+		return ""
+	}
+
+	return values[0]
+}
+
+func (c metadataCarrier) Set(key, value string) {
+	metadata.MD(c).Set(key, value)
+}
+
+func (c metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+
+	return keys
+}
+
+// startSpan starts a child span of the request span in ctx. Requests have no span when tracing is disabled,
+// the child span is then a no-op.
+func startSpan(ctx context.Context, name string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	return trace.SpanFromContext(ctx).TracerProvider().Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attributes...))
+}
+
+// startMountSpan starts a child span of mounting the volume.
+func (d *driver) startMountSpan(ctx context.Context, volumeID string) (context.Context, trace.Span) {
+	attributes := []attribute.KeyValue{volumeIDAttributeKey.String(volumeID)}
+	// Volume state isn't looked up for spans which aren't recorded.
+	if trace.SpanFromContext(ctx).IsRecording() {
+		vs := d.volumeManager.GetVolumeStateByID(volumeID)
+		if vs != nil {
+			attributes = append(attributes, volumeSizeAttributeKey.Int64(vs.Size))
+		}
+	}
+
+	return startSpan(ctx, "volume.Mount", attributes...)
+}
+
+// endSpan ends the span, marking it failed when the operation returned an error.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(otelcodes.Error, err.Error())
+	}
+
+	span.End()
+}
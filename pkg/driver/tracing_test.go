@@ -0,0 +1,93 @@
+// Copyright (c) 2023 ScyllaDB.
+
+package driver
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+type fakeSpanExporter struct {
+	mut   sync.Mutex
+	spans []sdktrace.ReadOnlySpan
+}
+
+var _ sdktrace.SpanExporter = &fakeSpanExporter{}
+
+func (e *fakeSpanExporter) ExportSpans(_ context.Context, spans []sdktrace.ReadOnlySpan) error {
+	e.mut.Lock()
+	defer e.mut.Unlock()
+
+	e.spans = append(e.spans, spans...)
+
+	return nil
+}
+
+func (e *fakeSpanExporter) Shutdown(context.Context) error {
+	return nil
+}
+
+func TestTracingUnaryInterceptorContinuesPropagatedTrace(t *testing.T) {
+	t.Parallel()
+
+	const (
+		traceID      = "4bf92f3577b34da6a3ce929d0e0e4736"
+		parentSpanID = "00f067aa0ba902b7"
+	)
+
+	exporter := &fakeSpanExporter{}
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+
+	d := newTestDriver(t)
+	interceptor := TracingUnaryInterceptor(tp)
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("traceparent", "00-"+traceID+"-"+parentSpanID+"-01"))
+	info := &grpc.UnaryServerInfo{FullMethod: "/csi.v1.Controller/CreateVolume"}
+	_, err := interceptor(ctx, newCreateVolumeRequest("volume", 1024), info, func(ctx context.Context, req any) (any, error) {
+		return d.CreateVolume(ctx, req.(*csi.CreateVolumeRequest))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, s := range exporter.spans {
+		spans[s.Name()] = s
+	}
+
+	serverSpan, ok := spans["csi.v1.Controller/CreateVolume"]
+	if !ok {
+		t.Fatalf("expected request span, got %v", spans)
+	}
+	if serverSpan.SpanKind() != trace.SpanKindServer {
+		t.Errorf("expected server span, got %v", serverSpan.SpanKind())
+	}
+	if serverSpan.SpanContext().TraceID().String() != traceID || serverSpan.Parent().SpanID().String() != parentSpanID {
+		t.Errorf("expected request span to continue trace %s of span %s, got %v", traceID, parentSpanID, serverSpan.Parent())
+	}
+
+	createSpan, ok := spans["volume.CreateVolume"]
+	if !ok {
+		t.Fatalf("expected volume creation span, got %v", spans)
+	}
+	if createSpan.Parent().SpanID() != serverSpan.SpanContext().SpanID() {
+		t.Errorf("expected volume creation span to be a child of the request span")
+	}
+
+	sizeRecorded := false
+	for _, a := range createSpan.Attributes() {
+		if a.Key == volumeSizeAttributeKey && a.Value.AsInt64() == 1024 {
+			sizeRecorded = true
+		}
+	}
+	if !sizeRecorded {
+		t.Errorf("expected volume size attribute, got %v", createSpan.Attributes())
+	}
+}
@@ -0,0 +1,323 @@
+// Copyright (c) 2023 ScyllaDB.
+
+package volume
+
+import (
+	stderrors "errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	berrors "go.etcd.io/bbolt/errors"
+	"k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// BoltStateFileName is the name of the file within the workspace the bolt backend keeps all states in.
+	BoltStateFileName = "state.db"
+
+	// boltOpenTimeout bounds waiting for the lock of the state file, which is held by another running driver.
+	boltOpenTimeout = 10 * time.Second
+	// boltReadOnlyOpenTimeout bounds waiting for the lock when the state is only inspected. Running driver holds
+	// the lock as long as it runs, so waiting longer doesn't help.
+	boltReadOnlyOpenTimeout = 1 * time.Second
+)
+
+// StateLockedErr is returned when the state file is held by another process, e.g. by a running driver.
+var StateLockedErr = stderrors.New("state is locked by another process")
+
+var (
+	volumesBucket   = []byte("volumes")
+	snapshotsBucket = []byte("snapshots")
+)
+
+// boltStateBackend keeps all states in a single BoltDB file, states are keyed by their IDs.
+type boltStateBackend struct {
+	*stateIndex
+
+	db               *bolt.DB
+	skipCorruptState bool
+	readOnly         bool
+}
+
+var _ StateBackend = &boltStateBackend{}
+
+func newBoltStateBackend(workspacePath string, o *stateManagerOptions) (*boltStateBackend, error) {
+	dbPath := filepath.Join(workspacePath, BoltStateFileName)
+	_, err := os.Stat(dbPath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return nil, fmt.Errorf("can't stat %q: %w", dbPath, err)
+		}
+
+		// Volumes of the other backend would be mistaken for orphans.
+		hasStateFiles, err := hasFilesBackendState(workspacePath)
+		if err != nil {
+			return nil, err
+		}
+		if hasStateFiles {
+			return nil, fmt.Errorf("workspace %q holds state of %q backend", workspacePath, FilesStateBackend)
+		}
+	}
+
+	boltOptions := &bolt.Options{Timeout: boltOpenTimeout}
+	if o.readOnly {
+		boltOptions = &bolt.Options{ReadOnly: true, Timeout: boltReadOnlyOpenTimeout}
+	}
+
+	db, err := bolt.Open(dbPath, 0600, boltOptions)
+	if err != nil {
+		if stderrors.Is(err, berrors.ErrTimeout) {
+			return nil, fmt.Errorf("can't open state file %q within %v: %w", dbPath, boltOptions.Timeout, StateLockedErr)
+		}
+		return nil, fmt.Errorf("can't open state file %q: %w", dbPath, err)
+	}
+
+	s := &boltStateBackend{
+		stateIndex:       newStateIndex(),
+		db:               db,
+		skipCorruptState: o.skipCorruptState,
+		readOnly:         o.readOnly,
+	}
+
+	err = s.load()
+	if err != nil {
+		closeErr := db.Close()
+		if closeErr != nil {
+			err = errors.NewAggregate([]error{err, closeErr})
+		}
+		return nil, fmt.Errorf("can't read states from %q: %w", dbPath, err)
+	}
+
+	return s, nil
+}
+
+// hasFilesBackendState returns whether the workspace holds any volume or snapshot state file.
+func hasFilesBackendState(workspacePath string) (bool, error) {
+	for _, dir := range []string{workspacePath, filepath.Join(workspacePath, SnapshotsDirName)} {
+		found, err := hasStateFile(dir)
+		if err != nil {
+			return false, err
+		}
+		if found {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func hasStateFile(dir string) (_ bool, err error) {
+	d, err := os.Open(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("can't open directory %q: %w", dir, err)
+	}
+	defer func() {
+		closeErr := d.Close()
+		if closeErr != nil {
+			err = errors.NewAggregate([]error{err, closeErr})
+		}
+	}()
+
+	for {
+		entries, readErr := d.ReadDir(DefaultReadDirBatchSize)
+		for _, e := range entries {
+			if !e.IsDir() && path.Ext(e.Name()) == fmt.Sprintf(".%s", volumeStateFileExtension) {
+				return true, nil
+			}
+		}
+
+		if readErr == io.EOF {
+			return false, nil
+		}
+		if readErr != nil {
+			return false, fmt.Errorf("can't read directory %q: %w", dir, readErr)
+		}
+	}
+}
+
+func (s *boltStateBackend) load() error {
+	if s.readOnly {
+		return s.db.View(s.loadBuckets)
+	}
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{volumesBucket, snapshotsBucket} {
+			_, err := tx.CreateBucketIfNotExists(bucket)
+			if err != nil {
+				return fmt.Errorf("can't create %q bucket: %w", bucket, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.db.View(s.loadBuckets)
+}
+
+// loadBuckets reads the states of both buckets, buckets which don't exist yet are empty.
+func (s *boltStateBackend) loadBuckets(tx *bolt.Tx) error {
+	volumes := tx.Bucket(volumesBucket)
+	if volumes != nil {
+		err := volumes.ForEach(func(k, v []byte) error {
+			vs := &VolumeState{}
+			err := decodeState(v, vs)
+			if err != nil {
+				err = s.handleCorruptState(volumesBucket, k, err)
+				if err != nil {
+					return err
+				}
+				s.quarantineVolume(string(k))
+				return nil
+			}
+
+			if vs.IsEmpty() {
+				klog.Warningf("Ignoring %q state because it doesn't contain volume information", k)
+				return nil
+			}
+
+			s.putVolume(vs)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	snapshots := tx.Bucket(snapshotsBucket)
+	if snapshots == nil {
+		return nil
+	}
+
+	return snapshots.ForEach(func(k, v []byte) error {
+		ss := &SnapshotState{}
+		err := decodeState(v, ss)
+		if err != nil {
+			err = s.handleCorruptState(snapshotsBucket, k, err)
+			if err != nil {
+				return err
+			}
+			s.quarantineSnapshot(string(k))
+			return nil
+		}
+
+		if ss.IsEmpty() {
+			klog.Warningf("Ignoring %q state because it doesn't contain snapshot information", k)
+			return nil
+		}
+
+		s.putSnapshot(ss)
+		return nil
+	})
+}
+
+// handleCorruptState ignores the state which couldn't be parsed, unless it should fail the loading.
+// The state is left in place, so it can be inspected, and its ID is recorded as quarantined.
+func (s *boltStateBackend) handleCorruptState(bucket, key []byte, err error) error {
+	if !s.skipCorruptState {
+		return fmt.Errorf("can't parse %q state in %q bucket: %w", key, bucket, err)
+	}
+
+	klog.ErrorS(err, "Ignoring corrupt state", "bucket", string(bucket), "key", string(key))
+	return nil
+}
+
+func (s *boltStateBackend) put(bucket []byte, id string, state any) error {
+	if s.readOnly {
+		return StateReadOnlyErr
+	}
+
+	data, err := encodeState(state)
+	if err != nil {
+		return fmt.Errorf("can't encode state %q: %w", id, err)
+	}
+
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).Put([]byte(id), data)
+	})
+	if err != nil {
+		return fmt.Errorf("can't save state %q in %q bucket: %w", id, bucket, err)
+	}
+
+	return nil
+}
+
+func (s *boltStateBackend) delete(bucket []byte, id string) error {
+	if s.readOnly {
+		return StateReadOnlyErr
+	}
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).Delete([]byte(id))
+	})
+	if err != nil {
+		return fmt.Errorf("can't delete state %q from %q bucket: %w", id, bucket, err)
+	}
+
+	return nil
+}
+
+// SaveVolumeState persists the volume state, the change is durable once it returns.
+func (s *boltStateBackend) SaveVolumeState(volume *VolumeState) error {
+	err := s.put(volumesBucket, volume.ID, volume)
+	if err != nil {
+		return err
+	}
+
+	s.putVolume(volume)
+
+	return nil
+}
+
+func (s *boltStateBackend) DeleteVolumeState(id string) error {
+	err := s.delete(volumesBucket, id)
+	if err != nil {
+		return err
+	}
+
+	s.removeVolume(id)
+
+	return nil
+}
+
+// SaveSnapshotState persists the snapshot state, the change is durable once it returns.
+func (s *boltStateBackend) SaveSnapshotState(snapshot *SnapshotState) error {
+	err := s.put(snapshotsBucket, snapshot.ID, snapshot)
+	if err != nil {
+		return err
+	}
+
+	s.putSnapshot(snapshot)
+
+	return nil
+}
+
+func (s *boltStateBackend) DeleteSnapshotState(id string) error {
+	err := s.delete(snapshotsBucket, id)
+	if err != nil {
+		return err
+	}
+
+	s.removeSnapshot(id)
+
+	return nil
+}
+
+func (s *boltStateBackend) Close() error {
+	err := s.db.Close()
+	if err != nil {
+		return fmt.Errorf("can't close state file %q: %w", s.db.Path(), err)
+	}
+
+	return nil
+}
@@ -0,0 +1,201 @@
+// Copyright (c) 2023 ScyllaDB.
+
+package volume
+
+import (
+	"errors"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestStateBackendsRefuseStateOfTheOtherBackend(t *testing.T) {
+	t.Parallel()
+
+	tt := []struct {
+		name          string
+		existingState func(dir string) error
+		backend       StateBackendType
+	}{
+		{
+			name: "bolt backend refuses volume state files",
+			existingState: func(dir string) error {
+				return writeVolumeState(path.Join(dir, "volume-1-uuid.json"), newVolumeState("volume-1-uuid", "volume-1"))
+			},
+			backend: BoltStateBackend,
+		},
+		{
+			name: "bolt backend refuses snapshot state files",
+			existingState: func(dir string) error {
+				err := os.Mkdir(filepath.Join(dir, SnapshotsDirName), 0770)
+				if err != nil {
+					return err
+				}
+				return os.WriteFile(filepath.Join(dir, SnapshotsDirName, "snapshot-1-uuid.json"), []byte(`{"name":"snapshot-1","id":"snapshot-1-uuid"}`), 0600)
+			},
+			backend: BoltStateBackend,
+		},
+		{
+			name: "files backend refuses bolt state file",
+			existingState: func(dir string) error {
+				sm, err := NewStateManager(dir, WithStateBackend(BoltStateBackend))
+				if err != nil {
+					return err
+				}
+				return sm.Close()
+			},
+			backend: FilesStateBackend,
+		},
+	}
+
+	for i := range tt {
+		tc := tt[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			dir := t.TempDir()
+			err := tc.existingState(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			sm, err := NewStateManager(dir, WithStateBackend(tc.backend))
+			if err == nil {
+				_ = sm.Close()
+				t.Fatalf("expected %q backend to refuse state of the other backend", tc.backend)
+			}
+		})
+	}
+}
+
+func TestBoltStateBackendHandlesCorruptStates(t *testing.T) {
+	t.Parallel()
+
+	tt := []struct {
+		name             string
+		skipCorruptState bool
+		expectedErr      bool
+	}{
+		{
+			name:             "corrupt state is ignored",
+			skipCorruptState: true,
+		},
+		{
+			name:             "corrupt state fails the loading",
+			skipCorruptState: false,
+			expectedErr:      true,
+		},
+	}
+
+	for i := range tt {
+		tc := tt[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			dir := t.TempDir()
+			sm, err := NewStateManager(dir, WithStateBackend(BoltStateBackend))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = sm.SaveVolumeState(newVolumeState("volume-1-uuid", "volume-1"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = sm.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			db, err := bolt.Open(filepath.Join(dir, BoltStateFileName), 0600, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = db.Update(func(tx *bolt.Tx) error {
+				return tx.Bucket(volumesBucket).Put([]byte("volume-2-uuid"), []byte("{"))
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = db.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			sm, err = NewStateManager(dir, WithStateBackend(BoltStateBackend), WithSkipCorruptState(tc.skipCorruptState))
+			if tc.expectedErr {
+				if err == nil {
+					_ = sm.Close()
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer sm.Close()
+
+			if vs := sm.GetVolumeStateByID("volume-1-uuid"); vs == nil {
+				t.Errorf("expected healthy volume to be loaded")
+			}
+
+			if volumes := sm.GetVolumes(); len(volumes) != 1 {
+				t.Errorf("expected only the healthy volume to be loaded, got %#v", volumes)
+			}
+
+			expectedQuarantined := []string{"volume-2-uuid"}
+			if got := sm.GetQuarantinedVolumeIDs(); !reflect.DeepEqual(got, expectedQuarantined) {
+				t.Errorf("expected quarantined volumes %v, got %v", expectedQuarantined, got)
+			}
+		})
+	}
+}
+
+func TestBoltStateBackendReadOnly(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	sm := openTestStateBackend(t, dir, BoltStateBackend)
+
+	vs := newVolumeState("volume-1-uuid", "volume-1")
+	err := sm.SaveVolumeState(vs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Running driver holds the state file as long as it runs.
+	_, err = NewStateManager(dir, WithStateBackend(BoltStateBackend), WithReadOnly(true))
+	if !errors.Is(err, StateLockedErr) {
+		t.Fatalf("expected %v opening state held by another backend, got %v", StateLockedErr, err)
+	}
+
+	err = sm.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ro, err := NewStateManager(dir, WithStateBackend(BoltStateBackend), WithReadOnly(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ro.Close()
+
+	if got := ro.GetVolumeStateByID(vs.ID); !reflect.DeepEqual(got, vs) {
+		t.Errorf("expected volume state %#v, got %#v", vs, got)
+	}
+
+	err = ro.SaveVolumeState(newVolumeState("volume-2-uuid", "volume-2"))
+	if !errors.Is(err, StateReadOnlyErr) {
+		t.Errorf("expected %v saving a volume state, got %v", StateReadOnlyErr, err)
+	}
+
+	err = ro.DeleteVolumeState(vs.ID)
+	if !errors.Is(err, StateReadOnlyErr) {
+		t.Errorf("expected %v deleting a volume state, got %v", StateReadOnlyErr, err)
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright (c) 2023 ScyllaDB.
+
+package volume
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/scylladb/local-csi-driver/pkg/util/fs"
+	"k8s.io/klog/v2"
+)
+
+// ContentSource is the data a new volume is populated with, the volume is created empty when it's zero.
+// At most one of the sources can be set.
+type ContentSource struct {
+	// SnapshotID is the snapshot whose data is copied into the volume.
+	SnapshotID string
+	// VolumeID is the volume whose data is copied into the volume.
+	VolumeID string
+}
+
+// populatingSource is a directory whose content is copied into a new volume.
+type populatingSource struct {
+	// description names the source in logs and errors.
+	description string
+	path        string
+	size        int64
+}
+
+// getPopulatingSource returns the directory the new volume is populated from, or nil when it's created empty.
+func (v *VolumeManager) getPopulatingSource(source ContentSource, capacity int64, volAccessType AccessType, backingMode BackingMode) (*populatingSource, error) {
+	switch {
+	case len(source.SnapshotID) != 0 && len(source.VolumeID) != 0:
+		return nil, fmt.Errorf("volume can't be populated from both snapshot %q and volume %q", source.SnapshotID, source.VolumeID)
+
+	case len(source.SnapshotID) != 0:
+		ss, err := v.getSourceSnapshot(source.SnapshotID, capacity, volAccessType, backingMode)
+		if err != nil {
+			return nil, err
+		}
+
+		return &populatingSource{
+			description: fmt.Sprintf("snapshot %q", ss.ID),
+			path:        ss.SnapshotPath(),
+			size:        ss.Size,
+		}, nil
+
+	case len(source.VolumeID) != 0:
+		vs, err := v.getSourceVolume(source.VolumeID, capacity, volAccessType, backingMode)
+		if err != nil {
+			return nil, err
+		}
+
+		return &populatingSource{
+			description: fmt.Sprintf("volume %q", vs.ID),
+			path:        vs.VolumePath(v.volumesDir),
+			size:        vs.Size,
+		}, nil
+
+	default:
+		return nil, nil
+	}
+}
+
+func (v *VolumeManager) getSourceSnapshot(snapshotID string, capacity int64, volAccessType AccessType, backingMode BackingMode) (*SnapshotState, error) {
+	ss := v.state.GetSnapshotStateByID(snapshotID)
+	if ss == nil {
+		return nil, fmt.Errorf("can't populate volume from snapshot %q: %w", snapshotID, SnapshotNotFoundErr)
+	}
+
+	if v.getVolumesDirOfSnapshot(ss) == nil {
+		return nil, fmt.Errorf("can't populate volume from snapshot %q in volumes dir %q which isn't configured: %w", snapshotID, ss.VolumesDir, VolumesDirUnavailableErr)
+	}
+
+	err := ss.CheckRestorable(capacity, volAccessType, backingMode)
+	if err != nil {
+		return nil, fmt.Errorf("can't populate volume from snapshot %q: %w", snapshotID, err)
+	}
+
+	return ss, nil
+}
+
+// getSourceVolume returns the volume a new volume is cloned from. Callers make sure the source volume isn't
+// deleted until the clone is populated.
+func (v *VolumeManager) getSourceVolume(volumeID string, capacity int64, volAccessType AccessType, backingMode BackingMode) (*VolumeState, error) {
+	vs := v.state.GetVolumeStateByID(volumeID)
+	if vs == nil {
+		return nil, fmt.Errorf("can't clone volume %q: %w", volumeID, SourceVolumeNotFoundErr)
+	}
+
+	err := v.checkVolumesDirAvailable(volumeID)
+	if err != nil {
+		return nil, fmt.Errorf("can't clone volume %q: %w", volumeID, err)
+	}
+
+	err = vs.CheckClonable(capacity, volAccessType, backingMode)
+	if err != nil {
+		return nil, fmt.Errorf("can't clone volume %q: %w", volumeID, err)
+	}
+
+	return vs, nil
+}
+
+// populateVolume copies the source data into the new volume directory. Backing file of a block volume
+// bigger than the source is extended to the volume capacity.
+func (v *VolumeManager) populateVolume(path string, src *populatingSource, volAccessType AccessType, capacity int64) error {
+	klog.V(2).InfoS("Copying volume content source to volume directory", "source", src.description, "path", path)
+	err := fs.CopyDirectoryContent(src.path, path)
+	if err != nil {
+		return fmt.Errorf("can't copy directory %q: %w", src.path, err)
+	}
+
+	if volAccessType == BlockAccess && capacity > src.size {
+		blockFilePath := filepath.Join(path, blockFileName)
+		err = os.Truncate(blockFilePath, capacity)
+		if err != nil {
+			return fmt.Errorf("can't extend backing file %q to %d bytes: %w", blockFilePath, capacity, err)
+		}
+	}
+
+	return nil
+}
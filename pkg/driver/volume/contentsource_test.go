@@ -0,0 +1,128 @@
+// Copyright (c) 2023 ScyllaDB.
+
+package volume
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCreateVolumeFromVolume(t *testing.T) {
+	t.Parallel()
+
+	tt := []struct {
+		name               string
+		accessType         AccessType
+		capacity           int64
+		sourceVolumeID     string
+		expectedErr        error
+		expectedErrPresent bool
+		expectedFile       string
+		expectedFileSize   int64
+	}{
+		{
+			name:           "mount volume",
+			accessType:     MountAccess,
+			capacity:       4096,
+			sourceVolumeID: "mount-volume-id",
+			expectedFile:   "data",
+		},
+		{
+			name:             "block volume bigger than the source",
+			accessType:       BlockAccess,
+			capacity:         8192,
+			sourceVolumeID:   "block-volume-id",
+			expectedFile:     blockFileName,
+			expectedFileSize: 8192,
+		},
+		{
+			name:               "volume smaller than the source",
+			accessType:         MountAccess,
+			capacity:           1024,
+			sourceVolumeID:     "mount-volume-id",
+			expectedErrPresent: true,
+		},
+		{
+			name:               "mount volume from block volume",
+			accessType:         MountAccess,
+			capacity:           4096,
+			sourceVolumeID:     "block-volume-id",
+			expectedErrPresent: true,
+		},
+		{
+			name:               "missing source volume",
+			accessType:         MountAccess,
+			capacity:           4096,
+			sourceVolumeID:     "missing-volume-id",
+			expectedErr:        SourceVolumeNotFoundErr,
+			expectedErrPresent: true,
+		},
+	}
+
+	for i := range tt {
+		tc := tt[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			vm := newTestVolumeManager(t)
+
+			err := vm.CreateVolume("mount-volume-id", "mount-volume", 4096, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = os.WriteFile(filepath.Join(vm.VolumesDir(), "mount-volume-id", "data"), []byte("data"), 0660)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = vm.CreateVolume("block-volume-id", "block-volume", 4096, &CreateVolumeOptions{AccessType: BlockAccess})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = vm.CreateVolume("volume-id", "volume", tc.capacity, &CreateVolumeOptions{AccessType: tc.accessType, Source: ContentSource{VolumeID: tc.sourceVolumeID}})
+			if tc.expectedErrPresent != (err != nil) {
+				t.Fatalf("expected error %v, got %v", tc.expectedErrPresent, err)
+			}
+			if tc.expectedErr != nil && !errors.Is(err, tc.expectedErr) {
+				t.Fatalf("expected error %v, got %v", tc.expectedErr, err)
+			}
+
+			volumePath := filepath.Join(vm.VolumesDir(), "volume-id")
+			if tc.expectedErrPresent {
+				_, err = os.Stat(volumePath)
+				if !os.IsNotExist(err) {
+					t.Errorf("expected no volume directory, got %v", err)
+				}
+				return
+			}
+
+			vs := vm.GetVolumeStateByID("volume-id")
+			if vs == nil || vs.SourceVolumeID != tc.sourceVolumeID {
+				t.Fatalf("expected volume cloned from %q, got %#v", tc.sourceVolumeID, vs)
+			}
+
+			fi, err := os.Stat(filepath.Join(volumePath, tc.expectedFile))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tc.expectedFileSize != 0 && fi.Size() != tc.expectedFileSize {
+				t.Errorf("expected %q to have %d bytes, got %d", tc.expectedFile, tc.expectedFileSize, fi.Size())
+			}
+
+			// Source volume is left intact.
+			fi, err = os.Stat(filepath.Join(vm.VolumesDir(), tc.sourceVolumeID, tc.expectedFile))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tc.expectedFileSize != 0 && fi.Size() != 4096 {
+				t.Errorf("expected source %q to have %d bytes, got %d", tc.expectedFile, 4096, fi.Size())
+			}
+		})
+	}
+}
@@ -0,0 +1,223 @@
+// Copyright (c) 2023 ScyllaDB.
+
+package volume
+
+import (
+	stderrors "errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/scylladb/local-csi-driver/pkg/util/slices"
+	"github.com/scylladb/local-csi-driver/pkg/util/uuid"
+	"k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/klog/v2"
+)
+
+type LeakKind string
+
+const (
+	// StateWithoutDirectoryLeak is a volume having a state whose directory doesn't exist.
+	StateWithoutDirectoryLeak LeakKind = "StateWithoutDirectory"
+	// DirectoryWithoutStateLeak is a volume directory without a state.
+	DirectoryWithoutStateLeak LeakKind = "DirectoryWithoutState"
+)
+
+// UnknownLeakAgeErr is returned when a suspected leak is to be deleted, but it can't be told for how long it's been
+// inconsistent. It could've been inconsistent only for a moment, so it's left to an operator.
+var UnknownLeakAgeErr = stderrors.New("volume was created before its creation time was recorded")
+
+var LeakKinds = []LeakKind{
+	StateWithoutDirectoryLeak,
+	DirectoryWithoutStateLeak,
+}
+
+// SuspectedLeak is a volume whose state and directory have been inconsistent for longer than a grace period.
+type SuspectedLeak struct {
+	VolumeID string   `json:"volumeID"`
+	Kind     LeakKind `json:"kind"`
+	Path     string   `json:"path"`
+	// Name is known only for volumes having a state.
+	Name string `json:"name,omitempty"`
+	// Since is the creation time of volumes having a state, or the modification time of directories without one.
+	// It's zero for volumes created before their creation time was recorded.
+	Since time.Time `json:"since,omitzero"`
+}
+
+// startCreatingVolume records the volume as being created, unless the limit of concurrent creations is reached.
+func (v *VolumeManager) startCreatingVolume(volID string) error {
+	v.creatingVolumesMut.Lock()
+	defer v.creatingVolumesMut.Unlock()
+
+	if v.maxPendingCreations > 0 && len(v.creatingVolumes) >= v.maxPendingCreations {
+		return fmt.Errorf("can't create volume %q, %d volumes are being created already: %w", volID, len(v.creatingVolumes), TooManyPendingCreationsErr)
+	}
+
+	v.creatingVolumes[volID] = struct{}{}
+	return nil
+}
+
+func (v *VolumeManager) finishCreatingVolume(volID string) {
+	v.creatingVolumesMut.Lock()
+	defer v.creatingVolumesMut.Unlock()
+
+	delete(v.creatingVolumes, volID)
+}
+
+func (v *VolumeManager) isCreatingVolume(volID string) bool {
+	v.creatingVolumesMut.Lock()
+	defer v.creatingVolumesMut.Unlock()
+
+	_, ok := v.creatingVolumes[volID]
+	return ok
+}
+
+// FindSuspectedLeaks returns volumes having a state but no directory, and volume directories without a state,
+// which have been so for at least gracePeriod. Volumes being created are skipped, their directories exist before
+// their states are saved, and so are volumes with quarantined states. Volumes created before their creation time
+// was recorded are always past the grace period.
+func (v *VolumeManager) FindSuspectedLeaks(gracePeriod time.Duration) ([]SuspectedLeak, error) {
+	now := v.now()
+	isPastGracePeriod := func(since time.Time) bool {
+		return since.IsZero() || now.Sub(since) >= gracePeriod
+	}
+
+	var leaks []SuspectedLeak
+	var errs []error
+
+	// Directories of volumes having a state are never suspected, even when the state places them in another volumes dir.
+	volumes := v.state.GetVolumes()
+	volumeIDs := make(map[string]struct{}, len(volumes))
+	for _, id := range v.state.GetQuarantinedVolumeIDs() {
+		volumeIDs[id] = struct{}{}
+	}
+	for _, vs := range volumes {
+		volumeIDs[vs.ID] = struct{}{}
+
+		// Volumes of volumes dirs which aren't configured can't be told apart from volumes of removed disks.
+		if v.getVolumesDirOf(&vs) == nil {
+			continue
+		}
+
+		path := vs.VolumePath(v.volumesDir)
+		_, err := os.Stat(path)
+		if err == nil {
+			continue
+		}
+		if !os.IsNotExist(err) {
+			errs = append(errs, fmt.Errorf("can't stat directory of volume %q: %w", vs.ID, err))
+			continue
+		}
+
+		if !isPastGracePeriod(vs.CreationTime) {
+			continue
+		}
+
+		leaks = append(leaks, SuspectedLeak{
+			VolumeID: vs.ID,
+			Kind:     StateWithoutDirectoryLeak,
+			Path:     path,
+			Name:     vs.Name,
+			Since:    vs.CreationTime,
+		})
+	}
+
+	for _, d := range v.volumesDirs {
+		entries, err := os.ReadDir(d.path)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("can't read directory %q: %w", d.path, err))
+			continue
+		}
+
+		for _, e := range entries {
+			if !e.IsDir() {
+				continue
+			}
+
+			if _, ok := volumeIDs[e.Name()]; ok {
+				continue
+			}
+
+			// Volume IDs are UUIDs, anything else in the volumes dir doesn't belong to the driver.
+			_, err = uuid.Parse(e.Name())
+			if err != nil {
+				continue
+			}
+
+			if v.isCreatingVolume(e.Name()) {
+				continue
+			}
+
+			info, err := e.Info()
+			if err != nil {
+				// Directory removed meanwhile isn't a leak anymore.
+				if os.IsNotExist(err) {
+					continue
+				}
+				errs = append(errs, fmt.Errorf("can't stat volume directory %q: %w", e.Name(), err))
+				continue
+			}
+
+			if !isPastGracePeriod(info.ModTime()) {
+				continue
+			}
+
+			leaks = append(leaks, SuspectedLeak{
+				VolumeID: e.Name(),
+				Kind:     DirectoryWithoutStateLeak,
+				Path:     filepath.Join(d.path, e.Name()),
+				Since:    info.ModTime(),
+			})
+		}
+	}
+
+	sort.Slice(leaks, func(i, j int) bool {
+		return leaks[i].VolumeID < leaks[j].VolumeID
+	})
+
+	return leaks, errors.NewAggregate(errs)
+}
+
+// DeleteSuspectedLeak deletes the volume of the leak, unless it's no longer inconsistent the way it was found in,
+// e.g. because its creation completed meanwhile. It returns whether the volume was deleted. Volumes created before
+// their creation time was recorded are never deleted, UnknownLeakAgeErr is returned instead.
+func (v *VolumeManager) DeleteSuspectedLeak(leak *SuspectedLeak) (bool, error) {
+	if v.isCreatingVolume(leak.VolumeID) {
+		return false, nil
+	}
+
+	vs := v.state.GetVolumeStateByID(leak.VolumeID)
+	switch leak.Kind {
+	case StateWithoutDirectoryLeak:
+		if vs == nil {
+			return false, nil
+		}
+
+		_, err := os.Stat(vs.VolumePath(v.volumesDir))
+		if !os.IsNotExist(err) {
+			return false, nil
+		}
+
+		if vs.CreationTime.IsZero() {
+			return false, fmt.Errorf("can't delete suspected leaked volume %q: %w", leak.VolumeID, UnknownLeakAgeErr)
+		}
+
+	case DirectoryWithoutStateLeak:
+		if vs != nil || slices.Contains(v.state.GetQuarantinedVolumeIDs(), leak.VolumeID) {
+			return false, nil
+		}
+
+	default:
+		return false, fmt.Errorf("unsupported leak kind %q", leak.Kind)
+	}
+
+	klog.V(2).InfoS("Deleting suspected leaked volume", "volumeID", leak.VolumeID, "kind", leak.Kind, "since", leak.Since)
+	err := v.DeleteVolume(leak.VolumeID)
+	if err != nil {
+		return false, fmt.Errorf("can't delete suspected leaked volume %q: %w", leak.VolumeID, err)
+	}
+
+	return true, nil
+}
@@ -0,0 +1,240 @@
+// Copyright (c) 2023 ScyllaDB.
+
+package volume
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const (
+	consistentVolumeID = "0b8ab4ad-6d23-4c0a-9b1e-5f3e0f0f7f01"
+	stateOnlyVolumeID  = "0b8ab4ad-6d23-4c0a-9b1e-5f3e0f0f7f02"
+	orphanedVolumeID   = "0b8ab4ad-6d23-4c0a-9b1e-5f3e0f0f7f03"
+)
+
+// newTestVolumeManagerWithLeaks returns a volume manager having a consistent volume, a volume without a directory
+// and a directory without a state. All of them were created at the returned time.
+func newTestVolumeManagerWithLeaks(t *testing.T) (*VolumeManager, time.Time) {
+	t.Helper()
+
+	vm := newTestVolumeManager(t)
+	createdAt := time.Now().Truncate(time.Second)
+	vm.now = func() time.Time {
+		return createdAt
+	}
+
+	for _, volumeID := range []string{consistentVolumeID, stateOnlyVolumeID} {
+		err := vm.CreateVolume(volumeID, volumeID, 4096, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := os.RemoveAll(filepath.Join(vm.volumesDir, stateOnlyVolumeID))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	orphanedPath := filepath.Join(vm.volumesDir, orphanedVolumeID)
+	err = os.Mkdir(orphanedPath, 0770)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.Chtimes(orphanedPath, createdAt, createdAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return vm, createdAt
+}
+
+func TestFindSuspectedLeaks(t *testing.T) {
+	t.Parallel()
+
+	const gracePeriod = time.Hour
+
+	tt := []struct {
+		name          string
+		age           time.Duration
+		expectedLeaks []LeakKind
+	}{
+		{
+			name: "inconsistencies within the grace period",
+			age:  gracePeriod - time.Second,
+		},
+		{
+			name:          "inconsistencies past the grace period",
+			age:           gracePeriod,
+			expectedLeaks: []LeakKind{StateWithoutDirectoryLeak, DirectoryWithoutStateLeak},
+		},
+	}
+
+	for i := range tt {
+		tc := tt[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			vm, createdAt := newTestVolumeManagerWithLeaks(t)
+			vm.now = func() time.Time {
+				return createdAt.Add(tc.age)
+			}
+
+			leaks, err := vm.FindSuspectedLeaks(gracePeriod)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var leakKinds []LeakKind
+			for _, leak := range leaks {
+				if !leak.Since.Equal(createdAt) {
+					t.Errorf("expected leak %q to be inconsistent since %v, got %v", leak.VolumeID, createdAt, leak.Since)
+				}
+				leakKinds = append(leakKinds, leak.Kind)
+			}
+
+			if !reflect.DeepEqual(leakKinds, tc.expectedLeaks) {
+				t.Errorf("expected leaks %v, got %#v", tc.expectedLeaks, leaks)
+			}
+		})
+	}
+}
+
+func TestFindSuspectedLeaksSkipsVolumesBeingCreated(t *testing.T) {
+	t.Parallel()
+
+	vm, _ := newTestVolumeManagerWithLeaks(t)
+	err := vm.startCreatingVolume(orphanedVolumeID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	leaks, err := vm.FindSuspectedLeaks(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(leaks) != 1 || leaks[0].VolumeID != stateOnlyVolumeID {
+		t.Errorf("expected only volume %q to be suspected, got %#v", stateOnlyVolumeID, leaks)
+	}
+
+	deleted, err := vm.DeleteSuspectedLeak(&SuspectedLeak{VolumeID: orphanedVolumeID, Kind: DirectoryWithoutStateLeak})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if deleted {
+		t.Errorf("expected directory of volume being created not to be deleted")
+	}
+}
+
+func TestDeleteSuspectedLeak(t *testing.T) {
+	t.Parallel()
+
+	vm, _ := newTestVolumeManagerWithLeaks(t)
+
+	leaks, err := vm.FindSuspectedLeaks(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(leaks) != 2 {
+		t.Fatalf("expected 2 suspected leaks, got %#v", leaks)
+	}
+
+	for i := range leaks {
+		deleted, err := vm.DeleteSuspectedLeak(&leaks[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !deleted {
+			t.Errorf("expected volume %q to be deleted", leaks[i].VolumeID)
+		}
+	}
+
+	if vm.GetVolumeStateByID(stateOnlyVolumeID) != nil {
+		t.Errorf("expected state of volume %q to be deleted", stateOnlyVolumeID)
+	}
+
+	_, err = os.Stat(filepath.Join(vm.volumesDir, orphanedVolumeID))
+	if !os.IsNotExist(err) {
+		t.Errorf("expected directory of volume %q to be deleted, got %v", orphanedVolumeID, err)
+	}
+
+	if vm.GetVolumeStateByID(consistentVolumeID) == nil {
+		t.Errorf("expected consistent volume %q to be kept", consistentVolumeID)
+	}
+
+	// Volume which is already consistent isn't deleted, even when it's passed as a leak.
+	deleted, err := vm.DeleteSuspectedLeak(&SuspectedLeak{VolumeID: consistentVolumeID, Kind: StateWithoutDirectoryLeak})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if deleted || vm.GetVolumeStateByID(consistentVolumeID) == nil {
+		t.Errorf("expected consistent volume %q not to be deleted", consistentVolumeID)
+	}
+}
+
+func TestFindSuspectedLeaksSkipsQuarantinedVolumes(t *testing.T) {
+	t.Parallel()
+
+	vm, _ := newTestVolumeManagerWithLeaks(t)
+
+	// Directory of a volume whose state can't be read looks the same as a directory without a state.
+	err := os.WriteFile(filepath.Join(vm.volumesDir, orphanedVolumeID+".json"), []byte("not a json"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm.state, err = NewStateManager(vm.volumesDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	leaks, err := vm.FindSuspectedLeaks(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(leaks) != 1 || leaks[0].VolumeID != stateOnlyVolumeID {
+		t.Errorf("expected only volume %q to be suspected, got %#v", stateOnlyVolumeID, leaks)
+	}
+
+	deleted, err := vm.DeleteSuspectedLeak(&SuspectedLeak{VolumeID: orphanedVolumeID, Kind: DirectoryWithoutStateLeak})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if deleted {
+		t.Errorf("expected directory of quarantined volume not to be deleted")
+	}
+}
+
+func TestDeleteSuspectedLeakKeepsVolumesOfUnknownAge(t *testing.T) {
+	t.Parallel()
+
+	vm, _ := newTestVolumeManagerWithLeaks(t)
+
+	// Volume created before its creation time was recorded.
+	vs := vm.GetVolumeStateByID(stateOnlyVolumeID)
+	vs.CreationTime = time.Time{}
+	err := vm.state.SaveVolumeState(vs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deleted, err := vm.DeleteSuspectedLeak(&SuspectedLeak{VolumeID: stateOnlyVolumeID, Kind: StateWithoutDirectoryLeak})
+	if !errors.Is(err, UnknownLeakAgeErr) {
+		t.Errorf("expected %v, got %v", UnknownLeakAgeErr, err)
+	}
+
+	if deleted || vm.GetVolumeStateByID(stateOnlyVolumeID) == nil {
+		t.Errorf("expected state of volume %q of unknown age to be kept", stateOnlyVolumeID)
+	}
+}
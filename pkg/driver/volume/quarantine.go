@@ -0,0 +1,157 @@
+// Copyright (c) 2023 ScyllaDB.
+
+package volume
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/klog/v2"
+)
+
+// CompressedStateFileSuffix follows CorruptStateFileSuffix in names of quarantined state files which are compressed.
+const CompressedStateFileSuffix = ".gz"
+
+// quarantinedStateFile is a state file of the files backend which was moved aside because it couldn't be parsed.
+type quarantinedStateFile struct {
+	path string
+	size int64
+	// quarantineTime is the modification time of the file, which is reset when the file is quarantined.
+	quarantineTime time.Time
+}
+
+// trackQuarantinedStateFile records the quarantined state file for the eviction, it's a no-op without a size cap.
+func (s *filesStateBackend) trackQuarantinedStateFile(path string) {
+	if s.quarantinedStateMaxBytes == 0 {
+		return
+	}
+
+	fi, err := os.Lstat(path)
+	if err != nil {
+		klog.ErrorS(err, "Can't stat quarantined state file", "path", path)
+		return
+	}
+
+	s.quarantinedFiles[path] = quarantinedStateFile{
+		path:           path,
+		size:           fi.Size(),
+		quarantineTime: fi.ModTime(),
+	}
+}
+
+// moveToQuarantine moves the corrupt state file to quarantinePath, compressing it when the path is the one
+// of a compressed file. Modification time of the quarantined file is the time of its quarantine.
+func (s *filesStateBackend) moveToQuarantine(fpath, quarantinePath string) error {
+	if filepath.Ext(quarantinePath) != CompressedStateFileSuffix {
+		err := os.Rename(fpath, quarantinePath)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		return os.Chtimes(quarantinePath, now, now)
+	}
+
+	err := compressStateFile(fpath, quarantinePath)
+	if err != nil {
+		return err
+	}
+
+	// Corrupt state file left behind by a crash is quarantined again on the next start.
+	return os.Remove(fpath)
+}
+
+// compressStateFile writes gzip compressed content of the state file to dst, replacing it atomically.
+func compressStateFile(fpath, dst string) (err error) {
+	src, err := os.Open(fpath)
+	if err != nil {
+		return fmt.Errorf("can't open state file %q: %w", fpath, err)
+	}
+	defer src.Close()
+
+	// Temporary files named after the state file are removed on the next start when the driver crashes.
+	dir := filepath.Dir(dst)
+	f, err := os.CreateTemp(dir, filepath.Base(fpath)+tempStateFileInfix+"*")
+	if err != nil {
+		return fmt.Errorf("can't create temporary file for %q: %w", dst, err)
+	}
+	defer func() {
+		if err != nil {
+			removeErr := os.Remove(f.Name())
+			if removeErr != nil && !os.IsNotExist(removeErr) {
+				err = errors.NewAggregate([]error{err, removeErr})
+			}
+		}
+	}()
+
+	gw := gzip.NewWriter(f)
+	gw.Name = filepath.Base(fpath)
+	_, err = io.Copy(gw, src)
+	gzipErr := gw.Close()
+	if err == nil {
+		err = gzipErr
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	closeErr := f.Close()
+	if err != nil || closeErr != nil {
+		return fmt.Errorf("can't write temporary file %q: %w", f.Name(), errors.NewAggregate([]error{err, closeErr}))
+	}
+
+	err = os.Rename(f.Name(), dst)
+	if err != nil {
+		return fmt.Errorf("can't rename temporary file %q to %q: %w", f.Name(), dst, err)
+	}
+
+	err = syncDir(dir)
+	if err != nil {
+		return fmt.Errorf("can't sync state directory: %w", err)
+	}
+
+	return nil
+}
+
+// evictQuarantinedStateFiles removes the oldest quarantined state files until all of them together fit
+// in the size cap. IDs of evicted states stay quarantined until the driver restarts, then their directories
+// are orphans.
+func (s *filesStateBackend) evictQuarantinedStateFiles() error {
+	files := make([]quarantinedStateFile, 0, len(s.quarantinedFiles))
+	var total int64
+	for _, f := range s.quarantinedFiles {
+		files = append(files, f)
+		total += f.size
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		if !files[i].quarantineTime.Equal(files[j].quarantineTime) {
+			return files[i].quarantineTime.Before(files[j].quarantineTime)
+		}
+		return files[i].path < files[j].path
+	})
+
+	var errs []error
+	for _, f := range files {
+		if total <= s.quarantinedStateMaxBytes {
+			break
+		}
+
+		klog.InfoS("Evicting quarantined state file", "path", f.path, "size", f.size, "quarantineTime", f.quarantineTime)
+		err := os.Remove(f.path)
+		if err != nil && !os.IsNotExist(err) {
+			errs = append(errs, fmt.Errorf("can't remove quarantined state file %q: %w", f.path, err))
+			continue
+		}
+
+		delete(s.quarantinedFiles, f.path)
+		total -= f.size
+	}
+
+	return errors.NewAggregate(errs)
+}
@@ -0,0 +1,139 @@
+// Copyright (c) 2023 ScyllaDB.
+
+package volume
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestStateManagerCompressesQuarantinedStateFiles(t *testing.T) {
+	t.Parallel()
+
+	tempDir := t.TempDir()
+	content := []byte(`{"name":"volume-1"`)
+	statePath := filepath.Join(tempDir, "volume-1-uuid.json")
+	err := os.WriteFile(statePath, content, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = NewStateManager(tempDir, WithCompressQuarantinedState(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = os.Stat(statePath)
+	if !os.IsNotExist(err) {
+		t.Errorf("expected corrupt state file to be moved away, got %v", err)
+	}
+
+	_, err = os.Stat(statePath + CorruptStateFileSuffix)
+	if !os.IsNotExist(err) {
+		t.Errorf("expected no uncompressed quarantined state file, got %v", err)
+	}
+
+	f, err := os.Open(statePath + CorruptStateFileSuffix + CompressedStateFileSuffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(gr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(data, content) {
+		t.Errorf("expected quarantined state file to hold %q, got %q", content, data)
+	}
+
+	// Compressed quarantined files are recognized on subsequent loads.
+	sm, err := NewStateManager(tempDir, WithSkipCorruptState(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedQuarantined := []string{"volume-1-uuid"}
+	if got := sm.GetQuarantinedVolumeIDs(); !reflect.DeepEqual(got, expectedQuarantined) {
+		t.Errorf("expected quarantined volumes %v, got %v", expectedQuarantined, got)
+	}
+}
+
+func TestStateManagerEvictsOldestQuarantinedStateFiles(t *testing.T) {
+	t.Parallel()
+
+	tempDir := t.TempDir()
+	err := os.Mkdir(filepath.Join(tempDir, SnapshotsDirName), 0770)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every quarantined file takes 100 bytes, the cap fits two of them.
+	now := time.Now()
+	quarantined := []struct {
+		path           string
+		quarantineTime time.Time
+	}{
+		{
+			path:           filepath.Join(tempDir, "volume-1-uuid.json"+CorruptStateFileSuffix),
+			quarantineTime: now.Add(-3 * time.Hour),
+		},
+		{
+			path:           filepath.Join(tempDir, SnapshotsDirName, "snapshot-1-uuid.json"+CorruptStateFileSuffix+CompressedStateFileSuffix),
+			quarantineTime: now.Add(-2 * time.Hour),
+		},
+		{
+			path:           filepath.Join(tempDir, "volume-2-uuid.json"+CorruptStateFileSuffix),
+			quarantineTime: now.Add(-1 * time.Hour),
+		},
+	}
+	for _, q := range quarantined {
+		err = os.WriteFile(q.path, make([]byte, 100), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.Chtimes(q.path, q.quarantineTime, q.quarantineTime)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Newly quarantined file is the most recent one.
+	err = os.WriteFile(filepath.Join(tempDir, "volume-3-uuid.json"), append([]byte(`{"name":"volume-3"`), make([]byte, 82)...), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sm, err := NewStateManager(tempDir, WithQuarantinedStateMaxBytes(200))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{quarantined[0].path, quarantined[1].path} {
+		_, err = os.Stat(path)
+		if !os.IsNotExist(err) {
+			t.Errorf("expected quarantined state file %q to be evicted, got %v", path, err)
+		}
+	}
+
+	for _, path := range []string{quarantined[2].path, filepath.Join(tempDir, "volume-3-uuid.json"+CorruptStateFileSuffix)} {
+		_, err = os.Stat(path)
+		if err != nil {
+			t.Errorf("expected quarantined state file %q to be kept: %v", path, err)
+		}
+	}
+
+	// Evicted states stay quarantined until restart, so their directories aren't taken for orphans.
+	expectedQuarantined := []string{"volume-1-uuid", "volume-2-uuid", "volume-3-uuid"}
+	if got := sm.GetQuarantinedVolumeIDs(); !reflect.DeepEqual(got, expectedQuarantined) {
+		t.Errorf("expected quarantined volumes %v, got %v", expectedQuarantined, got)
+	}
+}
@@ -0,0 +1,192 @@
+// Copyright (c) 2023 ScyllaDB.
+
+package volume
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/scylladb/local-csi-driver/pkg/util/fs"
+	"github.com/scylladb/local-csi-driver/pkg/util/uuid"
+	"k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/klog/v2"
+)
+
+// CreateSnapshot copies the volume directory of the source volume into the snapshots directory of the volumes dir
+// the volume lives in. Files are reflinked when the filesystem supports it, which makes the copy instant and
+// the snapshot takes space only as the volume diverges from it. Copies of files which are being written to
+// aren't consistent, so the volume should be quiesced.
+func (v *VolumeManager) CreateSnapshot(snapshotID, name, sourceVolumeID string) (*SnapshotState, error) {
+	// Snapshot directory is removed recursively, so the ID is held to the same rules as volume IDs.
+	err := ValidateVolumeID(snapshotID)
+	if err != nil {
+		return nil, err
+	}
+
+	vs := v.state.GetVolumeStateByID(sourceVolumeID)
+	if vs == nil {
+		return nil, fmt.Errorf("source volume %q doesn't exist", sourceVolumeID)
+	}
+
+	// Snapshot isn't encrypted, so copying the volume would leave its data in plain text.
+	if vs.IsEncrypted() {
+		return nil, fmt.Errorf("encrypted volume %q can't be snapshotted", sourceVolumeID)
+	}
+
+	dir := v.getVolumesDirOf(vs)
+	if dir == nil {
+		return nil, fmt.Errorf("volume %q is in volumes dir %q which isn't configured: %w", sourceVolumeID, vs.VolumesDir, VolumesDirUnavailableErr)
+	}
+
+	defer v.invalidateStatfsCache()
+
+	snapshotsDir := filepath.Join(dir.path, SnapshotsDirName)
+	err = v.retryFilesystemOperation(func() error {
+		return os.MkdirAll(snapshotsDir, 0770)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("can't create snapshots directory at %q: %w", snapshotsDir, err)
+	}
+
+	// Block volumes and volumes created before loop backed volumes were supported have no backing mode recorded.
+	backingMode := vs.BackingMode
+	if len(backingMode) == 0 {
+		backingMode = DirectoryBacking
+	}
+
+	snapshotState := &SnapshotState{
+		Name:           name,
+		ID:             snapshotID,
+		SourceVolumeID: sourceVolumeID,
+		Size:           vs.Size,
+		CreationTime:   v.now().UTC(),
+		VolumesDir:     dir.path,
+		VolumesDirID:   dir.id,
+		AccessType:     vs.AccessType,
+		BackingMode:    backingMode,
+	}
+
+	path := snapshotState.SnapshotPath()
+	klog.V(2).InfoS("Copying volume directory to snapshot", "volumeID", sourceVolumeID, "snapshotID", snapshotID, "path", path)
+	err = fs.CopyDirectory(vs.VolumePath(v.volumesDir), path)
+	if err != nil {
+		errs := []error{
+			fmt.Errorf("can't copy volume directory: %w", err),
+		}
+
+		rmErr := v.removeVolumeDirectory(path)
+		if rmErr != nil {
+			errs = append(errs, fmt.Errorf("can't remove snapshot directory: %w", rmErr))
+		}
+
+		return nil, errors.NewAggregate(errs)
+	}
+
+	err = v.state.SaveSnapshotState(snapshotState)
+	if err != nil {
+		errs := []error{
+			fmt.Errorf("can't save snapshot state: %w", err),
+		}
+
+		rmErr := v.removeVolumeDirectory(path)
+		if rmErr != nil {
+			errs = append(errs, fmt.Errorf("can't remove snapshot directory: %w", rmErr))
+		}
+
+		return nil, errors.NewAggregate(errs)
+	}
+
+	return snapshotState, nil
+}
+
+// DeleteSnapshot removes the snapshot data and its state. Snapshots which don't exist are ignored.
+func (v *VolumeManager) DeleteSnapshot(snapshotID string) error {
+	err := ValidateVolumeID(snapshotID)
+	if err != nil {
+		return err
+	}
+
+	defer v.invalidateStatfsCache()
+
+	// Snapshot data without a state is left behind by an interrupted creation and can be in any volumes dir.
+	var paths []string
+	ss := v.state.GetSnapshotStateByID(snapshotID)
+	if ss != nil {
+		paths = append(paths, ss.SnapshotPath())
+	} else {
+		for _, d := range v.volumesDirs {
+			paths = append(paths, filepath.Join(d.path, SnapshotsDirName, snapshotID))
+		}
+	}
+
+	for _, path := range paths {
+		err = v.removeVolumeDirectory(path)
+		if err != nil {
+			return fmt.Errorf("can't remove directory of snapshot %q at %q: %w", snapshotID, path, err)
+		}
+	}
+	klog.V(2).InfoS("Removed snapshot directory", "snapshotID", snapshotID)
+
+	err = v.state.DeleteSnapshotState(snapshotID)
+	if err != nil {
+		return fmt.Errorf("can't delete state of snapshot %q: %w", snapshotID, err)
+	}
+
+	return nil
+}
+
+// reconcileSnapshots moves snapshot directories without a state aside.
+func (v *VolumeManager) reconcileSnapshots(d *volumesDirectory) error {
+	snapshotsDir := filepath.Join(d.path, SnapshotsDirName)
+	entries, err := os.ReadDir(snapshotsDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("can't read directory %q: %w", snapshotsDir, err)
+	}
+
+	quarantinedIDs := map[string]struct{}{}
+	for _, id := range v.state.GetQuarantinedSnapshotIDs() {
+		quarantinedIDs[id] = struct{}{}
+	}
+
+	var errs []error
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+
+		if v.state.GetSnapshotStateByID(e.Name()) != nil {
+			continue
+		}
+
+		if _, ok := quarantinedIDs[e.Name()]; ok {
+			continue
+		}
+
+		// Snapshot IDs are UUIDs, anything else in the snapshots dir doesn't belong to the driver.
+		_, err = uuid.Parse(e.Name())
+		if err != nil {
+			klog.V(2).InfoS("Skipping unknown directory in snapshots dir", "name", e.Name())
+			continue
+		}
+
+		path := filepath.Join(snapshotsDir, e.Name())
+		err = v.moveOrphanedDirectory(d, path)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("can't move orphaned snapshot directory %q aside: %w", path, err))
+		}
+	}
+
+	return errors.NewAggregate(errs)
+}
+
+func (v *VolumeManager) GetSnapshotStateByID(id string) *SnapshotState {
+	return v.state.GetSnapshotStateByID(id)
+}
+
+func (v *VolumeManager) GetSnapshotStateByName(name string) *SnapshotState {
+	return v.state.GetSnapshotStateByName(name)
+}
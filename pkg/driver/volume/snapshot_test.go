@@ -0,0 +1,269 @@
+// Copyright (c) 2023 ScyllaDB.
+
+package volume
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"k8s.io/mount-utils"
+)
+
+func TestCreateSnapshotCopiesVolumeDirectory(t *testing.T) {
+	t.Parallel()
+
+	vm := newTestVolumeManager(t)
+
+	err := vm.CreateVolume("volume-id", "volume", 4096, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dataPath := filepath.Join(vm.VolumesDir(), "volume-id", "data")
+	err = os.WriteFile(dataPath, []byte("data"), 0660)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ss, err := vm.CreateSnapshot("snapshot-id", "snapshot", "volume-id")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ss.SourceVolumeID != "volume-id" || ss.Size != 4096 {
+		t.Errorf("expected snapshot of 4096 bytes of volume %q, got %#v", "volume-id", ss)
+	}
+
+	// Volume can keep changing after it's snapshotted.
+	err = os.WriteFile(dataPath, []byte("changed"), 0660)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	snapshotDataPath := filepath.Join(vm.VolumesDir(), SnapshotsDirName, "snapshot-id", "data")
+	data, err := os.ReadFile(snapshotDataPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "data" {
+		t.Errorf("expected snapshot to hold %q, got %q", "data", data)
+	}
+
+	sm, err := NewStateManager(vm.VolumesDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := sm.GetSnapshotStateByName("snapshot")
+	if loaded == nil || *loaded != *ss {
+		t.Errorf("expected snapshot state %#v to be persisted, got %#v", ss, loaded)
+	}
+
+	// Deletion is idempotent.
+	for range 2 {
+		err = vm.DeleteSnapshot("snapshot-id")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err = os.Stat(filepath.Dir(snapshotDataPath))
+	if !os.IsNotExist(err) {
+		t.Errorf("expected snapshot directory to be removed, got %v", err)
+	}
+
+	if vm.GetSnapshotStateByID("snapshot-id") != nil {
+		t.Errorf("expected snapshot state to be removed")
+	}
+}
+
+func TestCreateSnapshotOfEncryptedVolumeFails(t *testing.T) {
+	t.Parallel()
+
+	vm := newTestVolumeManager(t)
+
+	err := vm.state.SaveVolumeState(&VolumeState{
+		Name:                    "volume",
+		ID:                      "volume-id",
+		Size:                    4096,
+		EncryptionKeyIdentifier: "identifier",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = vm.CreateSnapshot("snapshot-id", "snapshot", "volume-id")
+	if err == nil {
+		t.Fatal("expected error")
+	}
+
+	_, err = os.Stat(filepath.Join(vm.VolumesDir(), SnapshotsDirName, "snapshot-id"))
+	if !os.IsNotExist(err) {
+		t.Errorf("expected no snapshot directory, got %v", err)
+	}
+}
+
+func TestNewVolumeManagerReconcilesSnapshots(t *testing.T) {
+	t.Parallel()
+
+	volumesDir := t.TempDir()
+
+	sm, err := NewStateManager(volumesDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ss := &SnapshotState{
+		Name:       "snapshot",
+		ID:         "8b6f3c1e-5c3a-4a4e-9a57-0b0e6c1f7f1a",
+		VolumesDir: volumesDir,
+	}
+	err = sm.SaveSnapshotState(ss)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	snapshotPath := ss.SnapshotPath()
+	orphanedPath := filepath.Join(volumesDir, SnapshotsDirName, "0e2b5a3c-7f8d-4c1e-b6a9-3d4e5f6a7b8c")
+	unknownPath := filepath.Join(volumesDir, SnapshotsDirName, "unknown")
+	for _, p := range []string{snapshotPath, orphanedPath, unknownPath} {
+		err = os.Mkdir(p, 0770)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err = NewVolumeManager(volumesDir, sm, WithMounter(mount.NewFakeMounter(nil)), WithReconcileOnStartup(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, p := range []string{snapshotPath, unknownPath} {
+		_, err = os.Stat(p)
+		if err != nil {
+			t.Errorf("expected %q to be kept, got %v", p, err)
+		}
+	}
+
+	_, err = os.Stat(orphanedPath)
+	if !os.IsNotExist(err) {
+		t.Errorf("expected orphaned snapshot directory to be removed, got %v", err)
+	}
+}
+
+func TestCreateVolumeFromSnapshot(t *testing.T) {
+	t.Parallel()
+
+	tt := []struct {
+		name               string
+		accessType         AccessType
+		capacity           int64
+		snapshotID         string
+		expectedErr        error
+		expectedErrPresent bool
+		expectedFile       string
+		expectedFileSize   int64
+	}{
+		{
+			name:         "mount volume",
+			accessType:   MountAccess,
+			capacity:     4096,
+			snapshotID:   "mount-snapshot-id",
+			expectedFile: "data",
+		},
+		{
+			name:             "block volume bigger than the snapshot",
+			accessType:       BlockAccess,
+			capacity:         8192,
+			snapshotID:       "block-snapshot-id",
+			expectedFile:     blockFileName,
+			expectedFileSize: 8192,
+		},
+		{
+			name:               "volume smaller than the snapshot",
+			accessType:         MountAccess,
+			capacity:           1024,
+			snapshotID:         "mount-snapshot-id",
+			expectedErrPresent: true,
+		},
+		{
+			name:               "mount volume from snapshot of block volume",
+			accessType:         MountAccess,
+			capacity:           4096,
+			snapshotID:         "block-snapshot-id",
+			expectedErrPresent: true,
+		},
+		{
+			name:               "missing snapshot",
+			accessType:         MountAccess,
+			capacity:           4096,
+			snapshotID:         "missing-snapshot-id",
+			expectedErr:        SnapshotNotFoundErr,
+			expectedErrPresent: true,
+		},
+	}
+
+	for i := range tt {
+		tc := tt[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			vm := newTestVolumeManager(t)
+
+			err := vm.CreateVolume("mount-volume-id", "mount-volume", 4096, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = os.WriteFile(filepath.Join(vm.VolumesDir(), "mount-volume-id", "data"), []byte("data"), 0660)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = vm.CreateVolume("block-volume-id", "block-volume", 4096, &CreateVolumeOptions{AccessType: BlockAccess})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for volumeID, snapshotID := range map[string]string{"mount-volume-id": "mount-snapshot-id", "block-volume-id": "block-snapshot-id"} {
+				_, err = vm.CreateSnapshot(snapshotID, snapshotID, volumeID)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err = vm.CreateVolume("volume-id", "volume", tc.capacity, &CreateVolumeOptions{AccessType: tc.accessType, Source: ContentSource{SnapshotID: tc.snapshotID}})
+			if tc.expectedErrPresent != (err != nil) {
+				t.Fatalf("expected error %v, got %v", tc.expectedErrPresent, err)
+			}
+			if tc.expectedErr != nil && !errors.Is(err, tc.expectedErr) {
+				t.Fatalf("expected error %v, got %v", tc.expectedErr, err)
+			}
+
+			volumePath := filepath.Join(vm.VolumesDir(), "volume-id")
+			if tc.expectedErrPresent {
+				_, err = os.Stat(volumePath)
+				if !os.IsNotExist(err) {
+					t.Errorf("expected no volume directory, got %v", err)
+				}
+				return
+			}
+
+			vs := vm.GetVolumeStateByID("volume-id")
+			if vs == nil || vs.SourceSnapshotID != tc.snapshotID {
+				t.Fatalf("expected volume populated from snapshot %q, got %#v", tc.snapshotID, vs)
+			}
+
+			fi, err := os.Stat(filepath.Join(volumePath, tc.expectedFile))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tc.expectedFileSize != 0 && fi.Size() != tc.expectedFileSize {
+				t.Errorf("expected %q to have %d bytes, got %d", tc.expectedFile, tc.expectedFileSize, fi.Size())
+			}
+		})
+	}
+}
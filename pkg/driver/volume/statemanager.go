@@ -3,23 +3,53 @@
 package volume
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	stderrors "errors"
 	"fmt"
+	"io"
 	"io/fs"
 	"os"
 	"path"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 
+	"github.com/scylladb/local-csi-driver/pkg/driver/limit"
 	"k8s.io/apimachinery/pkg/util/errors"
 	"k8s.io/klog/v2"
 )
 
 const (
 	volumeStateFileExtension = "json"
-	MetadataFileMaxSize      = 4 * 1024
+	// tempStateFileInfix follows the state file name in names of temporary files the state is written to.
+	tempStateFileInfix = ".tmp-"
+	// CorruptStateFileSuffix is appended to names of state files which couldn't be parsed.
+	CorruptStateFileSuffix = ".corrupt"
+	MetadataFileMaxSize    = 4 * 1024
+	// SnapshotsDirName is the name of the directory within a volumes dir holding snapshot data. Snapshot state files
+	// are kept in the directory of the same name within the workspace.
+	SnapshotsDirName = "snapshots"
+
+	DefaultReadDirBatchSize = 1024
 )
 
+// StateChecksumMismatchErr is returned when a state doesn't match its checksum, e.g. after bit rot or a partial
+// overwrite which still parses.
+var StateChecksumMismatchErr = stderrors.New("state checksum mismatch")
+
+// StateReadOnlyErr is returned when a state is changed through a backend opened read-only.
+var StateReadOnlyErr = stderrors.New("state is read-only")
+
+// stateChecksumSuffix matches the checksum which ends encoded states. It's SHA-256 of the state encoded without it.
+// Any value is matched, so a corrupted checksum isn't mistaken for a state written before states were checksummed.
+var stateChecksumSuffix = regexp.MustCompile(`,"checksum":"([^"]*)"}\s*$`)
+
 type AccessType int
 
 const (
@@ -27,145 +57,888 @@ const (
 	BlockAccess
 )
 
+type BackingMode string
+
+const (
+	// DirectoryBacking volumes are directories on the volumes dir filesystem.
+	DirectoryBacking BackingMode = "directory"
+	// LoopBacking volumes are filesystems of their own, formatted on a sparse file within the volume directory
+	// and mounted through a loop device.
+	LoopBacking BackingMode = "loop"
+)
+
+// VolumeAttributes holds information about what the volume was provisioned for.
+type VolumeAttributes struct {
+	StorageClassName string `json:"storageClassName,omitempty"`
+	PVCName          string `json:"pvcName,omitempty"`
+	PVCNamespace     string `json:"pvcNamespace,omitempty"`
+	PVName           string `json:"pvName,omitempty"`
+	// Ephemeral marks inline volumes of a pod, which are removed as soon as they're unpublished.
+	Ephemeral bool `json:"ephemeral,omitempty"`
+	// SyncInterval is how often filesystems of the published volume are synced, so syncing can be resumed after
+	// a restart. Zero means they aren't synced, or that the volume was created before it was recorded.
+	SyncInterval time.Duration `json:"syncInterval,omitempty"`
+}
+
 type VolumeState struct {
 	Name    string `json:"name"`
 	ID      string `json:"id"`
 	LimitID uint32 `json:"limitID"`
 	Size    int64  `json:"size"`
+	// AccessType is MountAccess for volumes created before block volumes were supported.
+	AccessType AccessType `json:"accessType,omitempty"`
+	// InodeLimit is the maximum number of inodes the volume can use, zero means it isn't limited.
+	InodeLimit uint64 `json:"inodeLimit,omitempty"`
+	// EnforcementMode is how the volume limits are enforced, it's empty for volumes created before it was recorded.
+	EnforcementMode limit.EnforcementMode `json:"enforcementMode,omitempty"`
+	// BackingMode is empty for volumes created before loop backed volumes were supported, they are directory backed.
+	BackingMode BackingMode `json:"backingMode,omitempty"`
+	// FsType is the filesystem loop backed volumes are formatted with, or the filesystem of the volumes dir
+	// for directory backed mount volumes. It's empty for volumes created before it was recorded.
+	FsType string `json:"fsType,omitempty"`
+	// VolumesDir is the directory the volume lives in. It's empty for volumes created before multiple volumes dirs
+	// were supported, they live in the main volumes dir.
+	VolumesDir string `json:"volumesDir,omitempty"`
+	// VolumesDirID is the ID of the volumes dir the volume lives in, which finds it when the volumes dir is mounted
+	// at another path. It's empty for volumes created before it was recorded.
+	VolumesDirID string `json:"volumesDirID,omitempty"`
+	// EncryptionKeyIdentifier identifies the fscrypt key the volume directory is encrypted with,
+	// it's empty for volumes which aren't encrypted.
+	EncryptionKeyIdentifier string `json:"encryptionKeyIdentifier,omitempty"`
+	// ExtentSizeHint is the XFS extent size hint of the volume directory, zero means it isn't set.
+	ExtentSizeHint uint32 `json:"extentSizeHint,omitempty"`
+	// SourceSnapshotID is the snapshot the volume was populated from, it's empty for volumes created empty.
+	SourceSnapshotID string `json:"sourceSnapshotID,omitempty"`
+	// SourceVolumeID is the volume the volume was cloned from, it's empty for volumes not created as a clone.
+	SourceVolumeID string `json:"sourceVolumeID,omitempty"`
+	// CreationTime is zero for volumes created before it was recorded.
+	CreationTime time.Time `json:"creationTime,omitzero"`
+	// NodeName is the name of the node the volume was created on, which its topology is derived from.
+	// It's empty for volumes created before it was recorded.
+	NodeName string `json:"nodeName,omitempty"`
+
+	VolumeAttributes
 }
 
+// VolumePath returns the path of the volume directory, volumesDir is the main volumes dir.
 func (vs *VolumeState) VolumePath(volumesDir string) string {
+	if len(vs.VolumesDir) != 0 {
+		volumesDir = vs.VolumesDir
+	}
+
 	return filepath.Join(volumesDir, vs.ID)
 }
 
+// IsInVolumesDir returns whether the volume lives in the volumes dir, mainVolumesDir is the main volumes dir.
+func (vs *VolumeState) IsInVolumesDir(volumesDir, mainVolumesDir string) bool {
+	dir := vs.VolumesDir
+	if len(dir) == 0 {
+		dir = mainVolumesDir
+	}
+
+	return filepath.Clean(dir) == filepath.Clean(volumesDir)
+}
+
+// IsLoopBacked returns whether the volume is a filesystem of its own mounted through a loop device.
+func (vs *VolumeState) IsLoopBacked() bool {
+	return vs.AccessType == MountAccess && vs.BackingMode == LoopBacking
+}
+
+// IsEncrypted returns whether the volume directory is encrypted using fscrypt.
+func (vs *VolumeState) IsEncrypted() bool {
+	return len(vs.EncryptionKeyIdentifier) != 0
+}
+
+// HasBackingFile returns whether the volume data is kept in a sparse file within the volume directory.
+func (vs *VolumeState) HasBackingFile() bool {
+	return vs.AccessType == BlockAccess || vs.IsLoopBacked()
+}
+
+// MatchesFsType returns whether a volume requesting fsType would have the same filesystem as the volume. Only loop
+// backed volumes are formatted with the requested filesystem, the others have the one of their volumes dir. Volumes
+// created before their filesystem was recorded match any.
+func (vs *VolumeState) MatchesFsType(fsType string) bool {
+	if !vs.IsLoopBacked() || len(vs.FsType) == 0 {
+		return true
+	}
+
+	if len(fsType) == 0 {
+		fsType = DefaultLoopBackingFsType
+	}
+
+	return fsType == vs.FsType
+}
+
+// CheckClonable returns an error when a volume of the given capacity, access type and backing mode
+// can't be cloned from the volume.
+func (vs *VolumeState) CheckClonable(capacity int64, volAccessType AccessType, backingMode BackingMode) error {
+	// Copy of an encrypted volume would leave its data in plain text.
+	if vs.IsEncrypted() {
+		return fmt.Errorf("encrypted volume can't be cloned")
+	}
+
+	if capacity < vs.Size {
+		return fmt.Errorf("volume capacity %d is smaller than source volume size %d", capacity, vs.Size)
+	}
+
+	// Volumes created before loop backed volumes were supported have no backing mode recorded.
+	sourceBackingMode := vs.BackingMode
+	if len(sourceBackingMode) == 0 {
+		sourceBackingMode = DirectoryBacking
+	}
+
+	if volAccessType != vs.AccessType || backingMode != sourceBackingMode {
+		return fmt.Errorf("source volume access type %v and backing mode %q don't match volume access type %v and backing mode %q", vs.AccessType, sourceBackingMode, volAccessType, backingMode)
+	}
+
+	// Filesystem within the backing file isn't grown.
+	if backingMode == LoopBacking && capacity != vs.Size {
+		return fmt.Errorf("loop backed volume capacity %d has to match source volume size %d", capacity, vs.Size)
+	}
+
+	return nil
+}
+
 func (vs *VolumeState) IsEmpty() bool {
 	return len(vs.Name) == 0 || len(vs.ID) == 0
 }
 
-type StateManager struct {
-	workspacePath string
+// SnapshotState describes a point-in-time copy of a volume directory.
+type SnapshotState struct {
+	Name           string `json:"name"`
+	ID             string `json:"id"`
+	SourceVolumeID string `json:"sourceVolumeID"`
+	// Size is the size of the source volume when the snapshot was taken.
+	Size         int64     `json:"size"`
+	CreationTime time.Time `json:"creationTime"`
+	// VolumesDir is the volumes dir the snapshot data lives in, the same as the one of the source volume.
+	VolumesDir string `json:"volumesDir"`
+	// VolumesDirID is the ID of the volumes dir, it's empty for snapshots created before it was recorded.
+	VolumesDirID string `json:"volumesDirID,omitempty"`
+	// AccessType and BackingMode are the ones of the source volume, volumes restored from the snapshot need the same.
+	AccessType  AccessType  `json:"accessType,omitempty"`
+	BackingMode BackingMode `json:"backingMode,omitempty"`
+}
 
+// SnapshotPath returns the path of the directory holding the snapshot data.
+func (ss *SnapshotState) SnapshotPath() string {
+	return filepath.Join(ss.VolumesDir, SnapshotsDirName, ss.ID)
+}
+
+// CheckRestorable returns an error when a volume of the given capacity, access type and backing mode
+// can't be populated from the snapshot.
+func (ss *SnapshotState) CheckRestorable(capacity int64, volAccessType AccessType, backingMode BackingMode) error {
+	if capacity < ss.Size {
+		return fmt.Errorf("volume capacity %d is smaller than snapshot size %d", capacity, ss.Size)
+	}
+
+	if volAccessType != ss.AccessType || backingMode != ss.BackingMode {
+		return fmt.Errorf("snapshot access type %v and backing mode %q don't match volume access type %v and backing mode %q", ss.AccessType, ss.BackingMode, volAccessType, backingMode)
+	}
+
+	// Filesystem within the backing file isn't grown.
+	if backingMode == LoopBacking && capacity != ss.Size {
+		return fmt.Errorf("loop backed volume capacity %d has to match snapshot size %d", capacity, ss.Size)
+	}
+
+	return nil
+}
+
+func (ss *SnapshotState) IsEmpty() bool {
+	return len(ss.Name) == 0 || len(ss.ID) == 0
+}
+
+// StateBackend persists volume and snapshot states. States are served from memory, so lookups are cheap.
+type StateBackend interface {
+	GetVolumeStateByName(name string) *VolumeState
+	GetVolumeStateByID(id string) *VolumeState
+	SaveVolumeState(volume *VolumeState) error
+	DeleteVolumeState(id string) error
+	GetTotalVolumesSize() int64
+	GetVolumes() []VolumeState
+	GetSnapshotStateByName(name string) *SnapshotState
+	GetSnapshotStateByID(id string) *SnapshotState
+	SaveSnapshotState(snapshot *SnapshotState) error
+	DeleteSnapshotState(id string) error
+	GetSnapshots() []SnapshotState
+	// GetQuarantinedVolumeIDs returns IDs of volumes whose states couldn't be loaded, their directories and limits
+	// mustn't be mistaken for leftovers.
+	GetQuarantinedVolumeIDs() []string
+	// GetQuarantinedSnapshotIDs returns IDs of snapshots whose states couldn't be loaded.
+	GetQuarantinedSnapshotIDs() []string
+	// Close releases resources of the backend, it can't be used afterwards.
+	Close() error
+}
+
+// StateBackendType selects how states are persisted.
+type StateBackendType string
+
+const (
+	// FilesStateBackend keeps the state of every volume and snapshot in a JSON file of its own within the workspace.
+	FilesStateBackend StateBackendType = "files"
+	// BoltStateBackend keeps all states in a single BoltDB file within the workspace,
+	// which is faster to load on nodes with many volumes.
+	BoltStateBackend StateBackendType = "bolt"
+)
+
+// stateIndex holds states in memory, backends update it once a state change is persisted.
+type stateIndex struct {
 	mut              sync.RWMutex
 	volumes          map[string]*VolumeState
 	volumeNameToID   map[string]string
 	volumesTotalSize int64
+	snapshots        map[string]*SnapshotState
+	snapshotNameToID map[string]string
+
+	quarantinedVolumes   map[string]struct{}
+	quarantinedSnapshots map[string]struct{}
 }
 
-func NewStateManager(workspacePath string) (*StateManager, error) {
-	volumes := map[string]*VolumeState{}
-	volumeNameToID := map[string]string{}
-	var volumesTotalSize int64
+func newStateIndex() *stateIndex {
+	return &stateIndex{
+		mut:              sync.RWMutex{},
+		volumes:          map[string]*VolumeState{},
+		volumeNameToID:   map[string]string{},
+		snapshots:        map[string]*SnapshotState{},
+		snapshotNameToID: map[string]string{},
 
-	err := filepath.WalkDir(workspacePath, func(fpath string, d fs.DirEntry, err error) error {
+		quarantinedVolumes:   map[string]struct{}{},
+		quarantinedSnapshots: map[string]struct{}{},
+	}
+}
+
+type stateManagerOptions struct {
+	backend                  StateBackendType
+	readDirBatchSize         int
+	skipCorruptState         bool
+	readOnly                 bool
+	compressQuarantinedState bool
+	quarantinedStateMaxBytes int64
+}
+
+type StateManagerOption func(o *stateManagerOptions)
+
+// WithStateBackend sets how states are persisted.
+func WithStateBackend(backend StateBackendType) func(*stateManagerOptions) {
+	return func(o *stateManagerOptions) {
+		o.backend = backend
+	}
+}
+
+// WithReadDirBatchSize sets the maximum number of directory entries read at once when loading the state
+// of the files backend.
+func WithReadDirBatchSize(batchSize int) func(*stateManagerOptions) {
+	return func(o *stateManagerOptions) {
+		o.readDirBatchSize = batchSize
+	}
+}
+
+// WithSkipCorruptState controls whether states which can't be parsed are quarantined
+// instead of failing the state loading.
+func WithSkipCorruptState(skip bool) func(*stateManagerOptions) {
+	return func(o *stateManagerOptions) {
+		o.skipCorruptState = skip
+	}
+}
+
+// WithCompressQuarantinedState makes corrupt state files of the files backend gzip compressed when they're quarantined.
+func WithCompressQuarantinedState(compress bool) func(*stateManagerOptions) {
+	return func(o *stateManagerOptions) {
+		o.compressQuarantinedState = compress
+	}
+}
+
+// WithQuarantinedStateMaxBytes caps the total size of quarantined state files of the files backend, the least recently
+// quarantined ones are removed on startup until the rest fits. Zero means there is no cap.
+func WithQuarantinedStateMaxBytes(maxBytes int64) func(*stateManagerOptions) {
+	return func(o *stateManagerOptions) {
+		o.quarantinedStateMaxBytes = maxBytes
+	}
+}
+
+// WithReadOnly opens the state without changing anything on disk, e.g. to inspect the state of a running driver.
+// Corrupt states are skipped without being quarantined, and saving or deleting a state fails.
+func WithReadOnly(readOnly bool) func(*stateManagerOptions) {
+	return func(o *stateManagerOptions) {
+		o.readOnly = readOnly
+	}
+}
+
+// NewStateManager returns the state backend of the workspace, which is the files backend unless set otherwise.
+func NewStateManager(workspacePath string, options ...StateManagerOption) (StateBackend, error) {
+	o := &stateManagerOptions{
+		backend:          FilesStateBackend,
+		readDirBatchSize: DefaultReadDirBatchSize,
+		skipCorruptState: true,
+	}
+
+	for _, option := range options {
+		option(o)
+	}
+
+	switch o.backend {
+	case FilesStateBackend:
+		s, err := newFilesStateBackend(workspacePath, o)
 		if err != nil {
-			return err
+			return nil, err
 		}
+		return s, nil
 
-		if fpath != workspacePath && d.IsDir() {
-			return filepath.SkipDir
+	case BoltStateBackend:
+		s, err := newBoltStateBackend(workspacePath, o)
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+
+	default:
+		return nil, fmt.Errorf("unknown state backend %q", o.backend)
+	}
+}
+
+// filesStateBackend keeps every state in a file of its own, which is replaced atomically on every change.
+type filesStateBackend struct {
+	*stateIndex
+
+	workspacePath            string
+	readDirBatchSize         int
+	skipCorruptState         bool
+	readOnly                 bool
+	compressQuarantinedState bool
+	quarantinedStateMaxBytes int64
+
+	// quarantinedFiles are the quarantined state files found while loading, keyed by their paths.
+	// They're tracked only when their total size is capped.
+	quarantinedFiles map[string]quarantinedStateFile
+}
+
+var _ StateBackend = &filesStateBackend{}
+
+func newFilesStateBackend(workspacePath string, o *stateManagerOptions) (*filesStateBackend, error) {
+	if o.readDirBatchSize <= 0 {
+		return nil, fmt.Errorf("read dir batch size must be positive, got %d", o.readDirBatchSize)
+	}
+
+	if o.quarantinedStateMaxBytes < 0 {
+		return nil, fmt.Errorf("quarantined state max bytes can't be negative, got %d", o.quarantinedStateMaxBytes)
+	}
+
+	// Volumes of the other backend would be mistaken for orphans.
+	boltFile := filepath.Join(workspacePath, BoltStateFileName)
+	_, err := os.Stat(boltFile)
+	if err == nil {
+		return nil, fmt.Errorf("workspace %q holds state of %q backend in %q", workspacePath, BoltStateBackend, boltFile)
+	}
+	if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("can't stat %q: %w", boltFile, err)
+	}
+
+	s := &filesStateBackend{
+		stateIndex:       newStateIndex(),
+		workspacePath:    workspacePath,
+		readDirBatchSize: o.readDirBatchSize,
+		skipCorruptState: o.skipCorruptState,
+		readOnly:         o.readOnly,
+
+		compressQuarantinedState: o.compressQuarantinedState,
+		quarantinedStateMaxBytes: o.quarantinedStateMaxBytes,
+		quarantinedFiles:         map[string]quarantinedStateFile{},
+	}
+
+	err = s.load()
+	if err != nil {
+		return nil, fmt.Errorf("can't read volume state files at %q: %w", workspacePath, err)
+	}
+
+	err = s.loadSnapshots()
+	if err != nil {
+		return nil, fmt.Errorf("can't read snapshot state files at %q: %w", s.snapshotsStatePath(), err)
+	}
+
+	// Quarantine is only for inspection, so failing to shrink it mustn't prevent serving the volumes.
+	if s.quarantinedStateMaxBytes > 0 && !s.readOnly {
+		err = s.evictQuarantinedStateFiles()
+		if err != nil {
+			klog.ErrorS(err, "Can't evict quarantined state files")
 		}
+	}
+
+	return s, nil
+}
 
-		if path.Ext(fpath) == fmt.Sprintf(".%s", volumeStateFileExtension) {
-			vs, err := parseVolumeStateFile(fpath)
+// load reads the volume state files from the workspace in batches,
+// so that memory usage stays bounded regardless of the number of volumes.
+func (s *filesStateBackend) load() (err error) {
+	d, err := os.Open(s.workspacePath)
+	if err != nil {
+		return fmt.Errorf("can't open directory %q: %w", s.workspacePath, err)
+	}
+	defer func() {
+		closeErr := d.Close()
+		if closeErr != nil {
+			err = errors.NewAggregate([]error{err, closeErr})
+		}
+	}()
+
+	for {
+		entries, readErr := d.ReadDir(s.readDirBatchSize)
+		for _, e := range entries {
+			err = s.loadEntry(e)
 			if err != nil {
-				return fmt.Errorf("can't parse volume state file at %q: %w", fpath, err)
+				return err
 			}
+		}
 
-			if vs.IsEmpty() {
-				klog.Warningf("Ignoring %q state file because it doesn't contain volume information", fpath)
-				return nil
-			}
+		if readErr == io.EOF {
+			return nil
+		}
+		if readErr != nil {
+			return fmt.Errorf("can't read directory %q: %w", s.workspacePath, readErr)
+		}
+	}
+}
+
+func (s *filesStateBackend) loadEntry(e fs.DirEntry) error {
+	if e.IsDir() {
+		return nil
+	}
+
+	id, ok := quarantinedStateID(e.Name())
+	if ok {
+		s.quarantineVolume(id)
+		s.trackQuarantinedStateFile(filepath.Join(s.workspacePath, e.Name()))
+		return nil
+	}
+
+	fpath := filepath.Join(s.workspacePath, e.Name())
+	isStateFile, err := s.checkStateFile(fpath)
+	if err != nil || !isStateFile {
+		return err
+	}
 
-			volumes[vs.ID] = vs
-			volumeNameToID[vs.Name] = vs.ID
-			volumesTotalSize += vs.Size
+	vs, err := parseVolumeStateFile(fpath)
+	if err != nil {
+		err = s.handleCorruptStateFile(fpath, err)
+		if err != nil {
+			return err
 		}
+		s.quarantineVolume(stateFileID(e.Name()))
+		return nil
+	}
 
+	if vs.IsEmpty() {
+		klog.Warningf("Ignoring %q state file because it doesn't contain volume information", fpath)
 		return nil
-	})
+	}
+
+	s.putVolume(vs)
+
+	return nil
+}
+
+// checkStateFile returns whether fpath is a state file. Temporary state files are removed on the way,
+// unless the backend is read-only.
+func (s *filesStateBackend) checkStateFile(fpath string) (bool, error) {
+	// Temporary state files are left behind only when the driver crashed before renaming them.
+	if strings.Contains(filepath.Base(fpath), fmt.Sprintf(".%s%s", volumeStateFileExtension, tempStateFileInfix)) {
+		if s.readOnly {
+			return false, nil
+		}
+
+		klog.InfoS("Removing leftover temporary state file", "path", fpath)
+		err := os.Remove(fpath)
+		if err != nil && !os.IsNotExist(err) {
+			return false, fmt.Errorf("can't remove temporary state file %q: %w", fpath, err)
+		}
+		return false, nil
+	}
+
+	return path.Ext(fpath) == fmt.Sprintf(".%s", volumeStateFileExtension), nil
+}
+
+// stateFileID returns the ID of the volume or snapshot the state file name belongs to.
+func stateFileID(name string) string {
+	return strings.TrimSuffix(name, fmt.Sprintf(".%s", volumeStateFileExtension))
+}
+
+// quarantinedStateID returns the ID of the volume or snapshot whose state was quarantined under the name,
+// which may be compressed.
+func quarantinedStateID(name string) (string, bool) {
+	stateName, ok := strings.CutSuffix(strings.TrimSuffix(name, CompressedStateFileSuffix), CorruptStateFileSuffix)
+	if !ok || path.Ext(stateName) != fmt.Sprintf(".%s", volumeStateFileExtension) {
+		return "", false
+	}
+
+	return stateFileID(stateName), true
+}
+
+// handleCorruptStateFile quarantines the state file which couldn't be parsed, unless it should fail the loading.
+func (s *filesStateBackend) handleCorruptStateFile(fpath string, err error) error {
+	if !s.skipCorruptState {
+		return fmt.Errorf("can't parse state file at %q: %w", fpath, err)
+	}
+
+	if s.readOnly {
+		klog.ErrorS(err, "Skipping corrupt state file", "path", fpath)
+		return nil
+	}
+
+	// A single corrupt file mustn't prevent serving all the healthy volumes.
+	quarantinePath := fpath + CorruptStateFileSuffix
+	if s.compressQuarantinedState {
+		quarantinePath += CompressedStateFileSuffix
+	}
+	klog.ErrorS(err, "Quarantining corrupt state file", "path", fpath, "quarantinePath", quarantinePath)
+	quarantineErr := s.moveToQuarantine(fpath, quarantinePath)
+	if quarantineErr != nil {
+		return fmt.Errorf("can't quarantine corrupt state file %q: %w", fpath, errors.NewAggregate([]error{err, quarantineErr}))
+	}
+	s.trackQuarantinedStateFile(quarantinePath)
+
+	return nil
+}
+
+// loadSnapshots reads the snapshot state files. The directory holding them is created with the first snapshot.
+func (s *filesStateBackend) loadSnapshots() error {
+	entries, err := os.ReadDir(s.snapshotsStatePath())
 	if err != nil {
-		return nil, fmt.Errorf("can't read volume state files at %q: %w", workspacePath, err)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("can't read directory %q: %w", s.snapshotsStatePath(), err)
 	}
 
-	return &StateManager{
-		workspacePath:    workspacePath,
-		mut:              sync.RWMutex{},
-		volumes:          volumes,
-		volumeNameToID:   volumeNameToID,
-		volumesTotalSize: volumesTotalSize,
-	}, nil
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+
+		id, ok := quarantinedStateID(e.Name())
+		if ok {
+			s.quarantineSnapshot(id)
+			s.trackQuarantinedStateFile(filepath.Join(s.snapshotsStatePath(), e.Name()))
+			continue
+		}
+
+		fpath := filepath.Join(s.snapshotsStatePath(), e.Name())
+		isStateFile, err := s.checkStateFile(fpath)
+		if err != nil {
+			return err
+		}
+		if !isStateFile {
+			continue
+		}
+
+		ss := &SnapshotState{}
+		err = parseStateFile(fpath, ss)
+		if err != nil {
+			err = s.handleCorruptStateFile(fpath, err)
+			if err != nil {
+				return err
+			}
+			s.quarantineSnapshot(stateFileID(e.Name()))
+			continue
+		}
+
+		if ss.IsEmpty() {
+			klog.Warningf("Ignoring %q state file because it doesn't contain snapshot information", fpath)
+			continue
+		}
+
+		s.putSnapshot(ss)
+	}
+
+	return nil
 }
 
-func (s *StateManager) getVolumeStatePath(id string) string {
+func (s *filesStateBackend) snapshotsStatePath() string {
+	return filepath.Join(s.workspacePath, SnapshotsDirName)
+}
+
+func (s *filesStateBackend) getSnapshotStatePath(id string) string {
+	return filepath.Join(s.snapshotsStatePath(), fmt.Sprintf("%s.%s", id, volumeStateFileExtension))
+}
+
+func (s *stateIndex) GetSnapshotStateByName(name string) *SnapshotState {
+	s.mut.RLock()
+	defer s.mut.RUnlock()
+	return s.snapshots[s.snapshotNameToID[name]]
+}
+
+func (s *stateIndex) GetSnapshotStateByID(id string) *SnapshotState {
+	s.mut.RLock()
+	defer s.mut.RUnlock()
+	return s.snapshots[id]
+}
+
+// SaveSnapshotState persists the snapshot state, replacing the state file atomically.
+func (s *filesStateBackend) SaveSnapshotState(snapshot *SnapshotState) error {
+	if s.readOnly {
+		return StateReadOnlyErr
+	}
+
+	err := os.Mkdir(s.snapshotsStatePath(), 0770)
+	if err == nil {
+		err = syncDir(s.workspacePath)
+		if err != nil {
+			return fmt.Errorf("can't sync state directory: %w", err)
+		}
+	} else if !os.IsExist(err) {
+		return fmt.Errorf("can't create snapshot state directory %q: %w", s.snapshotsStatePath(), err)
+	}
+
+	err = s.writeStateFile(s.getSnapshotStatePath(snapshot.ID), snapshot)
+	if err != nil {
+		return err
+	}
+
+	s.putSnapshot(snapshot)
+
+	return nil
+}
+
+func (s *filesStateBackend) DeleteSnapshotState(id string) error {
+	if s.readOnly {
+		return StateReadOnlyErr
+	}
+
+	statePath := s.getSnapshotStatePath(id)
+	err := os.Remove(statePath)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("can't remove snapshot state file at %q: %w", statePath, err)
+	}
+
+	s.removeSnapshot(id)
+
+	return nil
+}
+
+func (s *stateIndex) GetSnapshots() []SnapshotState {
+	s.mut.RLock()
+	defer s.mut.RUnlock()
+
+	snapshots := make([]SnapshotState, 0, len(s.snapshots))
+	for _, ss := range s.snapshots {
+		snapshots = append(snapshots, *ss)
+	}
+
+	return snapshots
+}
+
+func (s *filesStateBackend) getVolumeStatePath(id string) string {
 	volumePath := path.Join(s.workspacePath, id)
 	return fmt.Sprintf("%s.%s", volumePath, volumeStateFileExtension)
 }
 
-func (s *StateManager) GetVolumeStateByName(name string) *VolumeState {
+func (s *stateIndex) GetVolumeStateByName(name string) *VolumeState {
 	s.mut.RLock()
 	defer s.mut.RUnlock()
 	return s.volumes[s.volumeNameToID[name]]
 }
 
-func (s *StateManager) GetVolumeStateByID(id string) *VolumeState {
+func (s *stateIndex) GetVolumeStateByID(id string) *VolumeState {
 	s.mut.RLock()
 	defer s.mut.RUnlock()
 	return s.volumes[id]
 }
 
-func (s *StateManager) SaveVolumeState(volume *VolumeState) (err error) {
+// SaveVolumeState persists the volume state. The state file is replaced atomically,
+// so a crash leaves either the previous or the new complete state behind.
+func (s *filesStateBackend) SaveVolumeState(volume *VolumeState) error {
+	if s.readOnly {
+		return StateReadOnlyErr
+	}
+
 	statePath := s.getVolumeStatePath(volume.ID)
 
-	f, err := os.Create(statePath)
+	err := s.writeStateFile(statePath, volume)
 	if err != nil {
-		return fmt.Errorf("can't open state file %q: %w", statePath, err)
+		return err
+	}
+
+	s.putVolume(volume)
+
+	return nil
+}
+
+func (s *filesStateBackend) writeStateFile(statePath string, state any) (err error) {
+	stateDir := filepath.Dir(statePath)
+	f, err := os.CreateTemp(stateDir, filepath.Base(statePath)+tempStateFileInfix+"*")
+	if err != nil {
+		return fmt.Errorf("can't create temporary state file for %q: %w", statePath, err)
 	}
 
 	defer func() {
-		closeErr := f.Close()
-		err = errors.NewAggregate([]error{err, closeErr})
+		if err != nil {
+			removeErr := os.Remove(f.Name())
+			if removeErr != nil && !os.IsNotExist(removeErr) {
+				err = errors.NewAggregate([]error{err, removeErr})
+			}
+		}
 	}()
 
-	err = json.NewEncoder(f).Encode(volume)
+	data, err := encodeState(state)
+	if err == nil {
+		_, err = f.Write(data)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	closeErr := f.Close()
+	if err != nil || closeErr != nil {
+		return fmt.Errorf("can't write temporary state file %q: %w", f.Name(), errors.NewAggregate([]error{err, closeErr}))
+	}
+
+	err = os.Rename(f.Name(), statePath)
 	if err != nil {
-		return fmt.Errorf("can't encode state file %q: %w", statePath, err)
+		return fmt.Errorf("can't rename temporary state file %q to %q: %w", f.Name(), statePath, err)
 	}
 
-	s.mut.Lock()
-	defer s.mut.Unlock()
-	s.volumes[volume.ID] = volume
-	s.volumeNameToID[volume.Name] = volume.ID
-	s.volumesTotalSize += volume.Size
+	// Rename is durable only once the directory entry is synced.
+	err = syncDir(stateDir)
+	if err != nil {
+		return fmt.Errorf("can't sync state directory: %w", err)
+	}
+
+	return nil
+}
+
+func syncDir(path string) (err error) {
+	d, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("can't open directory %q: %w", path, err)
+	}
+	defer func() {
+		closeErr := d.Close()
+		if closeErr != nil {
+			err = errors.NewAggregate([]error{err, closeErr})
+		}
+	}()
+
+	err = d.Sync()
+	if err != nil {
+		return fmt.Errorf("can't sync directory %q: %w", path, err)
+	}
 
 	return nil
 }
 
-func (s *StateManager) DeleteVolumeState(id string) error {
+func (s *filesStateBackend) DeleteVolumeState(id string) error {
+	if s.readOnly {
+		return StateReadOnlyErr
+	}
+
 	statePath := s.getVolumeStatePath(id)
 	err := os.Remove(statePath)
 	if err != nil && !os.IsNotExist(err) {
 		return fmt.Errorf("can't remove volume state file at %q: %w", statePath, err)
 	}
 
+	s.removeVolume(id)
+
+	return nil
+}
+
+// Close is a no-op, files backend doesn't keep any resources open in between calls.
+func (s *filesStateBackend) Close() error {
+	return nil
+}
+
+func (s *stateIndex) putVolume(volume *VolumeState) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	old, ok := s.volumes[volume.ID]
+	if ok {
+		forgetName(s.volumeNameToID, old.Name, old.ID)
+		s.volumesTotalSize -= old.Size
+	}
+	s.volumes[volume.ID] = volume
+	s.volumeNameToID[volume.Name] = volume.ID
+	s.volumesTotalSize += volume.Size
+}
+
+func (s *stateIndex) removeVolume(id string) {
 	s.mut.Lock()
 	defer s.mut.Unlock()
 	v, ok := s.volumes[id]
 	if ok {
-		delete(s.volumeNameToID, v.Name)
+		forgetName(s.volumeNameToID, v.Name, v.ID)
 		delete(s.volumes, id)
 		s.volumesTotalSize -= v.Size
 	}
+}
 
-	return nil
+func (s *stateIndex) putSnapshot(snapshot *SnapshotState) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	old, ok := s.snapshots[snapshot.ID]
+	if ok {
+		forgetName(s.snapshotNameToID, old.Name, old.ID)
+	}
+	s.snapshots[snapshot.ID] = snapshot
+	s.snapshotNameToID[snapshot.Name] = snapshot.ID
 }
 
-func (s *StateManager) GetTotalVolumesSize() int64 {
+func (s *stateIndex) removeSnapshot(id string) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	ss, ok := s.snapshots[id]
+	if ok {
+		forgetName(s.snapshotNameToID, ss.Name, ss.ID)
+		delete(s.snapshots, id)
+	}
+}
+
+func (s *stateIndex) quarantineVolume(id string) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.quarantinedVolumes[id] = struct{}{}
+}
+
+func (s *stateIndex) quarantineSnapshot(id string) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.quarantinedSnapshots[id] = struct{}{}
+}
+
+func (s *stateIndex) GetQuarantinedVolumeIDs() []string {
+	s.mut.RLock()
+	defer s.mut.RUnlock()
+	return sortedKeys(s.quarantinedVolumes)
+}
+
+func (s *stateIndex) GetQuarantinedSnapshotIDs() []string {
+	s.mut.RLock()
+	defer s.mut.RUnlock()
+	return sortedKeys(s.quarantinedSnapshots)
+}
+
+func sortedKeys(m map[string]struct{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// forgetName removes the name from the index, unless the name already belongs to another ID. A volume recreated
+// under the same name can be saved before the state of the deleted one is removed, and the latter mustn't hide
+// the current volume from name lookups.
+func forgetName(nameToID map[string]string, name, id string) {
+	if nameToID[name] == id {
+		delete(nameToID, name)
+	}
+}
+
+func (s *stateIndex) GetTotalVolumesSize() int64 {
 	s.mut.RLock()
 	defer s.mut.RUnlock()
 	return s.volumesTotalSize
 }
 
-func (s *StateManager) GetVolumes() []VolumeState {
+func (s *stateIndex) GetVolumes() []VolumeState {
 	s.mut.RLock()
 	defer s.mut.RUnlock()
 
@@ -177,23 +950,68 @@ func (s *StateManager) GetVolumes() []VolumeState {
 	return volumes
 }
 
-func parseVolumeStateFile(path string) (vs *VolumeState, err error) {
-	f, err := os.Open(path)
+func parseVolumeStateFile(path string) (*VolumeState, error) {
+	vs := &VolumeState{}
+	err := parseStateFile(path, vs)
 	if err != nil {
-		return nil, fmt.Errorf("can't open file at %q: %w", path, err)
+		return nil, err
 	}
-	defer func() {
-		closeErr := f.Close()
-		if closeErr != nil {
-			err = errors.NewAggregate([]error{err, closeErr})
-		}
-	}()
 
-	vs = &VolumeState{}
-	err = json.NewDecoder(f).Decode(vs)
+	return vs, nil
+}
+
+func parseStateFile(path string, state any) error {
+	data, err := os.ReadFile(path)
 	if err != nil {
-		return nil, fmt.Errorf("can't parse file at %q: %w", path, err)
+		return fmt.Errorf("can't read file at %q: %w", path, err)
 	}
 
-	return vs, nil
+	err = decodeState(data, state)
+	if err != nil {
+		return fmt.Errorf("can't parse file at %q: %w", path, err)
+	}
+
+	return nil
+}
+
+// encodeState returns JSON encoding of the state ending with its checksum. Checksum is the last field of the object,
+// so it covers exactly the bytes written before it, and readers which don't know it just ignore it.
+func encodeState(state any) ([]byte, error) {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return nil, fmt.Errorf("can't encode state: %w", err)
+	}
+
+	if len(data) <= len("{}") || data[len(data)-1] != '}' {
+		return nil, fmt.Errorf("state %q isn't a non-empty JSON object", data)
+	}
+
+	checksum := sha256.Sum256(data)
+	encoded := &bytes.Buffer{}
+	encoded.Write(data[:len(data)-1])
+	fmt.Fprintf(encoded, `,"checksum":"%x"}`+"\n", checksum)
+
+	return encoded.Bytes(), nil
+}
+
+// decodeState parses the state, verifying its checksum. States written before they were checksummed have none,
+// they're trusted as they are.
+func decodeState(data []byte, state any) error {
+	match := stateChecksumSuffix.FindSubmatchIndex(data)
+	if match == nil && bytes.Contains(data, []byte(`"checksum":`)) {
+		return fmt.Errorf("%w: checksum doesn't end the state", StateChecksumMismatchErr)
+	}
+	if match != nil {
+		content := make([]byte, 0, match[0]+1)
+		content = append(content, data[:match[0]]...)
+		content = append(content, '}')
+
+		checksum := sha256.Sum256(content)
+		expected := string(data[match[2]:match[3]])
+		if hex.EncodeToString(checksum[:]) != expected {
+			return fmt.Errorf("%w: expected %s, got %x", StateChecksumMismatchErr, expected, checksum)
+		}
+	}
+
+	return json.Unmarshal(data, state)
 }
@@ -3,12 +3,17 @@
 package volume
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path"
 	"reflect"
 	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
 )
 
 func writeVolumeState(path string, volumeState *VolumeState) error {
@@ -41,18 +46,18 @@ func TestStateManager(t *testing.T) {
 	tt := []struct {
 		name          string
 		existingFiles map[string]*VolumeState
-		trigger       func(sm *StateManager) error
-		check         func(dir string, st *StateManager) error
+		trigger       func(sm StateBackend) error
+		check         func(dir string, st StateBackend) error
 	}{
 		{
 			name: "existing state is preloaded",
 			existingFiles: map[string]*VolumeState{
 				"volume-1-uuid.json": newVolumeState("volume-1-uuid", "volume-1"),
 			},
-			trigger: func(sm *StateManager) error {
+			trigger: func(sm StateBackend) error {
 				return nil
 			},
-			check: func(dir string, sm *StateManager) error {
+			check: func(dir string, sm StateBackend) error {
 				expectedState := newVolumeState("volume-1-uuid", "volume-1")
 				vs := sm.GetVolumeStateByID("volume-1-uuid")
 				if !reflect.DeepEqual(vs, expectedState) {
@@ -64,10 +69,10 @@ func TestStateManager(t *testing.T) {
 		{
 			name:          "returns nil state when volume is unknown",
 			existingFiles: map[string]*VolumeState{},
-			trigger: func(sm *StateManager) error {
+			trigger: func(sm StateBackend) error {
 				return nil
 			},
-			check: func(dir string, st *StateManager) error {
+			check: func(dir string, st StateBackend) error {
 				vs := st.GetVolumeStateByID("volume-1-uuid")
 				if vs != nil {
 					return fmt.Errorf("expected nil, got %#v", vs)
@@ -85,10 +90,10 @@ func TestStateManager(t *testing.T) {
 			existingFiles: map[string]*VolumeState{
 				"volume-1-uuid.json": newVolumeState("volume-1-uuid", "volume-1"),
 			},
-			trigger: func(sm *StateManager) error {
+			trigger: func(sm StateBackend) error {
 				return sm.DeleteVolumeState("volume-1-uuid")
 			},
-			check: func(dir string, sm *StateManager) error {
+			check: func(dir string, sm StateBackend) error {
 				vs := sm.GetVolumeStateByID("volume-1-uuid")
 				if vs != nil {
 					return fmt.Errorf("expected nil volume state, got %#v", vs)
@@ -108,12 +113,12 @@ func TestStateManager(t *testing.T) {
 			existingFiles: map[string]*VolumeState{
 				"volume-1-uuid.json": newVolumeState("volume-1-uuid", "volume-1"),
 			},
-			trigger: func(sm *StateManager) error {
+			trigger: func(sm StateBackend) error {
 				vs := newVolumeState("volume-1-uuid", "volume-1")
 				vs.Size = 666
 				return sm.SaveVolumeState(vs)
 			},
-			check: func(dir string, sm *StateManager) error {
+			check: func(dir string, sm StateBackend) error {
 				expectedVs := newVolumeState("volume-1-uuid", "volume-1")
 				expectedVs.Size = 666
 
@@ -146,15 +151,33 @@ func TestStateManager(t *testing.T) {
 				return nil
 			},
 		},
+		{
+			name: "overwriting state keeps total volumes size accurate",
+			existingFiles: map[string]*VolumeState{
+				"volume-1-uuid.json": newVolumeState("volume-1-uuid", "volume-1"),
+			},
+			trigger: func(sm StateBackend) error {
+				vs := newVolumeState("volume-1-uuid", "volume-1")
+				vs.Size = 4096
+				return sm.SaveVolumeState(vs)
+			},
+			check: func(dir string, sm StateBackend) error {
+				totalSize := sm.GetTotalVolumesSize()
+				if totalSize != 4096 {
+					return fmt.Errorf("expected total volumes size %d, got %d", 4096, totalSize)
+				}
+				return nil
+			},
+		},
 		{
 			name: "GetVolumeStateByName queries volume state by name",
 			existingFiles: map[string]*VolumeState{
 				"volume-1-uuid.json": newVolumeState("volume-1-uuid", "volume-1"),
 			},
-			trigger: func(st *StateManager) error {
+			trigger: func(st StateBackend) error {
 				return nil
 			},
-			check: func(dir string, sm *StateManager) error {
+			check: func(dir string, sm StateBackend) error {
 				expectedState := newVolumeState("volume-1-uuid", "volume-1")
 				vs := sm.GetVolumeStateByName("volume-1")
 				if !reflect.DeepEqual(vs, expectedState) {
@@ -169,10 +192,10 @@ func TestStateManager(t *testing.T) {
 				"volume-1-uuid.json": newVolumeState("volume-1-uuid", "volume-1"),
 				"volume-2-uuid.json": newVolumeState("volume-2-uuid", "volume-2"),
 			},
-			trigger: func(st *StateManager) error {
+			trigger: func(st StateBackend) error {
 				return nil
 			},
-			check: func(dir string, sm *StateManager) error {
+			check: func(dir string, sm StateBackend) error {
 				totalSize := sm.GetTotalVolumesSize()
 				expectedTotalSize := newVolumeState("", "").Size * 2
 				if totalSize != expectedTotalSize {
@@ -216,3 +239,841 @@ func TestStateManager(t *testing.T) {
 		})
 	}
 }
+
+func TestStateManagerReadDirBatching(t *testing.T) {
+	t.Parallel()
+
+	tempDir := t.TempDir()
+
+	const volumesCount = 10
+	for i := 0; i < volumesCount; i++ {
+		id := fmt.Sprintf("volume-%d-uuid", i)
+		err := writeVolumeState(path.Join(tempDir, fmt.Sprintf("%s.json", id)), newVolumeState(id, fmt.Sprintf("volume-%d", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := os.Mkdir(path.Join(tempDir, "volume-0-uuid"), 0770)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, batchSize := range []int{1, 3, volumesCount, 2 * volumesCount} {
+		sm, err := NewStateManager(tempDir, WithReadDirBatchSize(batchSize))
+		if err != nil {
+			t.Fatalf("batch size %d: %v", batchSize, err)
+		}
+
+		volumes := sm.GetVolumes()
+		if len(volumes) != volumesCount {
+			t.Errorf("batch size %d: expected %d volumes, got %d", batchSize, volumesCount, len(volumes))
+		}
+
+		expectedTotalSize := newVolumeState("", "").Size * volumesCount
+		if sm.GetTotalVolumesSize() != expectedTotalSize {
+			t.Errorf("batch size %d: expected total volumes size %d, got %d", batchSize, expectedTotalSize, sm.GetTotalVolumesSize())
+		}
+	}
+
+	_, err = NewStateManager(tempDir, WithReadDirBatchSize(0))
+	if err == nil {
+		t.Errorf("expected error for non-positive batch size, got nil")
+	}
+}
+
+func TestStateManagerSurvivesInterruptedSave(t *testing.T) {
+	t.Parallel()
+
+	tempDir := t.TempDir()
+
+	sm, err := NewStateManager(tempDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vs := newVolumeState("volume-1-uuid", "volume-1")
+	err = sm.SaveVolumeState(vs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := os.ReadDir(tempDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "volume-1-uuid.json" {
+		t.Fatalf("expected only the state file to be left after save, got %v", entries)
+	}
+
+	// Simulate a crash in the middle of writing an updated state.
+	expandedVs := *vs
+	expandedVs.Size = 2048
+	content, err := json.Marshal(&expandedVs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tempStatePath := path.Join(tempDir, "volume-1-uuid.json"+tempStateFileInfix+"123456")
+	err = os.WriteFile(tempStatePath, content[:len(content)/2], 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sm, err = NewStateManager(tempDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loadedVs := sm.GetVolumeStateByID("volume-1-uuid")
+	if !reflect.DeepEqual(loadedVs, vs) {
+		t.Errorf("expected previous state %#v, got %#v", vs, loadedVs)
+	}
+
+	_, err = os.Stat(tempStatePath)
+	if !os.IsNotExist(err) {
+		t.Errorf("expected leftover temporary state file to be removed, got %v", err)
+	}
+}
+
+func TestStateManagerResolvesRecreatedVolumeName(t *testing.T) {
+	t.Parallel()
+
+	sm, err := NewStateManager(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var previous *VolumeState
+	for i := range 50 {
+		current := newVolumeState(fmt.Sprintf("volume-1-uuid-%d", i), "volume-1")
+		err = sm.SaveVolumeState(current)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// State of the previous volume is removed only after the volume is recreated, which happens when
+		// the deletion is retried or completes late.
+		deleteErr := make(chan error, 1)
+		if previous != nil {
+			go func(id string) {
+				deleteErr <- sm.DeleteVolumeState(id)
+			}(previous.ID)
+		} else {
+			deleteErr <- nil
+		}
+
+		for done := false; !done; {
+			select {
+			case err = <-deleteErr:
+				if err != nil {
+					t.Fatal(err)
+				}
+				done = true
+			default:
+			}
+
+			vs := sm.GetVolumeStateByName("volume-1")
+			if vs == nil || vs.ID != current.ID {
+				t.Fatalf("expected name to resolve to volume %q, got %#v", current.ID, vs)
+			}
+		}
+
+		previous = current
+	}
+
+	err = sm.DeleteVolumeState(previous.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vs := sm.GetVolumeStateByName("volume-1")
+	if vs != nil {
+		t.Errorf("expected name of deleted volume not to resolve, got %#v", vs)
+	}
+}
+
+func TestStateManagerDetectsChecksumMismatch(t *testing.T) {
+	t.Parallel()
+
+	tt := []struct {
+		name             string
+		corrupt          func(content []byte) []byte
+		skipCorruptState bool
+		expectedErr      error
+		expectedLoaded   bool
+	}{
+		{
+			name: "intact state is loaded",
+			corrupt: func(content []byte) []byte {
+				return content
+			},
+			expectedLoaded: true,
+		},
+		{
+			name: "flipped byte of size fails the loading",
+			corrupt: func(content []byte) []byte {
+				i := bytes.Index(content, []byte(`"size":1024`)) + len(`"size":1`)
+				content[i] ^= 0x01
+				return content
+			},
+			expectedErr: StateChecksumMismatchErr,
+		},
+		{
+			name: "flipped byte of name fails the loading",
+			corrupt: func(content []byte) []byte {
+				i := bytes.Index(content, []byte(`"volume-1"`)) + 1
+				content[i] ^= 0x20
+				return content
+			},
+			expectedErr: StateChecksumMismatchErr,
+		},
+		{
+			name: "flipped byte of checksum is quarantined",
+			corrupt: func(content []byte) []byte {
+				i := bytes.Index(content, []byte(`"checksum":"`)) + len(`"checksum":"`)
+				content[i] = 'x'
+				return content
+			},
+			skipCorruptState: true,
+		},
+		{
+			name: "flipped byte of size is quarantined",
+			corrupt: func(content []byte) []byte {
+				i := bytes.Index(content, []byte(`"size":1024`)) + len(`"size":1`)
+				content[i] ^= 0x01
+				return content
+			},
+			skipCorruptState: true,
+		},
+		{
+			name: "state without checksum is trusted",
+			corrupt: func(content []byte) []byte {
+				i := bytes.Index(content, []byte(`,"checksum":"`))
+				return append(content[:i:i], []byte("}\n")...)
+			},
+			expectedLoaded: true,
+		},
+	}
+
+	for i := range tt {
+		tc := tt[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			tempDir := t.TempDir()
+			sm, err := NewStateManager(tempDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = sm.SaveVolumeState(newVolumeState("volume-1-uuid", "volume-1"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			statePath := path.Join(tempDir, "volume-1-uuid.json")
+			content, err := os.ReadFile(statePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			corrupted := tc.corrupt(content)
+			if tc.expectedErr != nil || !tc.expectedLoaded {
+				// Corruption has to keep the state parsable, otherwise it's detected without the checksum.
+				err = json.Unmarshal(corrupted, &VolumeState{})
+				if err != nil {
+					t.Fatalf("expected corrupted state to still parse, got %v", err)
+				}
+			}
+
+			err = os.WriteFile(statePath, corrupted, 0600)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			sm, err = NewStateManager(tempDir, WithSkipCorruptState(tc.skipCorruptState))
+			if !errors.Is(err, tc.expectedErr) {
+				t.Fatalf("expected error %v, got %v", tc.expectedErr, err)
+			}
+			if err != nil {
+				return
+			}
+
+			vs := sm.GetVolumeStateByID("volume-1-uuid")
+			if tc.expectedLoaded != (vs != nil) {
+				t.Fatalf("expected volume to be loaded: %v, got %#v", tc.expectedLoaded, vs)
+			}
+			if vs != nil && !reflect.DeepEqual(vs, newVolumeState("volume-1-uuid", "volume-1")) {
+				t.Errorf("expected loaded state to match the saved one, got %#v", vs)
+			}
+
+			if !tc.expectedLoaded {
+				_, err = os.Stat(statePath + CorruptStateFileSuffix)
+				if err != nil {
+					t.Errorf("expected corrupt state file to be quarantined, got %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestStateManagerRestoresAccessTypeAndFilesystem(t *testing.T) {
+	t.Parallel()
+
+	tempDir := t.TempDir()
+
+	// State written by a version predating access types, backing modes and filesystems.
+	err := os.WriteFile(path.Join(tempDir, "legacy-uuid.json"), []byte(`{"name":"legacy","id":"legacy-uuid","limitID":1,"size":1024}`), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sm, err := NewStateManager(tempDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, vs := range []*VolumeState{
+		{ID: "block-uuid", Name: "block", LimitID: 2, Size: 1024, AccessType: BlockAccess, BackingMode: DirectoryBacking},
+		{ID: "loop-uuid", Name: "loop", LimitID: 3, Size: 1024, AccessType: MountAccess, BackingMode: LoopBacking, FsType: "xfs"},
+	} {
+		err = sm.SaveVolumeState(vs)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sm, err = NewStateManager(tempDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tt := []struct {
+		id                  string
+		expectedAccessType  AccessType
+		expectedBackingMode BackingMode
+		expectedFsType      string
+	}{
+		{
+			id:                 "legacy-uuid",
+			expectedAccessType: MountAccess,
+		},
+		{
+			id:                  "block-uuid",
+			expectedAccessType:  BlockAccess,
+			expectedBackingMode: DirectoryBacking,
+		},
+		{
+			id:                  "loop-uuid",
+			expectedAccessType:  MountAccess,
+			expectedBackingMode: LoopBacking,
+			expectedFsType:      "xfs",
+		},
+	}
+
+	for _, tc := range tt {
+		vs := sm.GetVolumeStateByID(tc.id)
+		if vs == nil {
+			t.Fatalf("expected state of volume %q to be restored", tc.id)
+		}
+
+		if vs.AccessType != tc.expectedAccessType || vs.BackingMode != tc.expectedBackingMode || vs.FsType != tc.expectedFsType {
+			t.Errorf("expected volume %q with access type %v, backing mode %q and filesystem %q, got %#v", tc.id, tc.expectedAccessType, tc.expectedBackingMode, tc.expectedFsType, vs)
+		}
+	}
+}
+
+func TestVolumeStateMatchesFsType(t *testing.T) {
+	t.Parallel()
+
+	tt := []struct {
+		name     string
+		vs       VolumeState
+		fsType   string
+		expected bool
+	}{
+		{
+			name:     "loop backed volume of the same filesystem",
+			vs:       VolumeState{BackingMode: LoopBacking, FsType: "xfs"},
+			fsType:   "xfs",
+			expected: true,
+		},
+		{
+			name:     "loop backed volume of another filesystem",
+			vs:       VolumeState{BackingMode: LoopBacking, FsType: "xfs"},
+			fsType:   "ext4",
+			expected: false,
+		},
+		{
+			name:     "loop backed volume of the default filesystem",
+			vs:       VolumeState{BackingMode: LoopBacking, FsType: DefaultLoopBackingFsType},
+			expected: true,
+		},
+		{
+			name:     "loop backed volume without a recorded filesystem",
+			vs:       VolumeState{BackingMode: LoopBacking},
+			fsType:   "xfs",
+			expected: true,
+		},
+		{
+			name:     "directory backed volume having the volumes dir filesystem",
+			vs:       VolumeState{BackingMode: DirectoryBacking, FsType: "xfs"},
+			fsType:   "ext4",
+			expected: true,
+		},
+	}
+
+	for i := range tt {
+		tc := tt[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			got := tc.vs.MatchesFsType(tc.fsType)
+			if got != tc.expected {
+				t.Errorf("expected %v, got %v", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestStateManagerQuarantinesCorruptStateFiles(t *testing.T) {
+	t.Parallel()
+
+	tempDir := t.TempDir()
+
+	validVolumes := []*VolumeState{
+		newVolumeState("volume-1-uuid", "volume-1"),
+		newVolumeState("volume-2-uuid", "volume-2"),
+	}
+	for _, vs := range validVolumes {
+		err := writeVolumeState(path.Join(tempDir, vs.ID+".json"), vs)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	corruptFiles := map[string][]byte{
+		"garbage-uuid.json":   []byte("not a json"),
+		"empty-uuid.json":     {},
+		"truncated-uuid.json": []byte(`{"name":"truncated","id":"trunc`),
+	}
+	for name, content := range corruptFiles {
+		err := os.WriteFile(path.Join(tempDir, name), content, 0666)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err := NewStateManager(tempDir, WithSkipCorruptState(false))
+	if err == nil {
+		t.Fatal("expected an error loading corrupt state files when skipping them is disabled")
+	}
+
+	sm, err := NewStateManager(tempDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var expectedTotalSize int64
+	for _, vs := range validVolumes {
+		expectedTotalSize += vs.Size
+
+		if got := sm.GetVolumeStateByID(vs.ID); !reflect.DeepEqual(got, vs) {
+			t.Errorf("expected volume state %#v, got %#v", vs, got)
+		}
+		if got := sm.GetVolumeStateByName(vs.Name); !reflect.DeepEqual(got, vs) {
+			t.Errorf("expected volume state %#v for name %q, got %#v", vs, vs.Name, got)
+		}
+	}
+
+	if got := len(sm.GetVolumes()); got != len(validVolumes) {
+		t.Errorf("expected %d volumes, got %d", len(validVolumes), got)
+	}
+	if got := sm.GetTotalVolumesSize(); got != expectedTotalSize {
+		t.Errorf("expected total volumes size %d, got %d", expectedTotalSize, got)
+	}
+
+	expectedQuarantined := []string{"empty-uuid", "garbage-uuid", "truncated-uuid"}
+	if got := sm.GetQuarantinedVolumeIDs(); !reflect.DeepEqual(got, expectedQuarantined) {
+		t.Errorf("expected quarantined volumes %v, got %v", expectedQuarantined, got)
+	}
+
+	for name := range corruptFiles {
+		_, err = os.Stat(path.Join(tempDir, name))
+		if !os.IsNotExist(err) {
+			t.Errorf("expected corrupt state file %q to be moved away, got %v", name, err)
+		}
+
+		_, err = os.Stat(path.Join(tempDir, name+CorruptStateFileSuffix))
+		if err != nil {
+			t.Errorf("expected corrupt state file %q to be quarantined: %v", name, err)
+		}
+	}
+
+	// Quarantined files don't affect subsequent loads.
+	sm, err = NewStateManager(tempDir, WithSkipCorruptState(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := len(sm.GetVolumes()); got != len(validVolumes) {
+		t.Errorf("expected %d volumes after reload, got %d", len(validVolumes), got)
+	}
+	if got := sm.GetQuarantinedVolumeIDs(); !reflect.DeepEqual(got, expectedQuarantined) {
+		t.Errorf("expected quarantined volumes %v after reload, got %v", expectedQuarantined, got)
+	}
+}
+
+func TestStateManagerReadOnly(t *testing.T) {
+	t.Parallel()
+
+	tempDir := t.TempDir()
+
+	vs := newVolumeState("volume-1-uuid", "volume-1")
+	err := writeVolumeState(path.Join(tempDir, vs.ID+".json"), vs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unchangedFiles := map[string][]byte{
+		"garbage-uuid.json":                             []byte("not a json"),
+		"volume-1-uuid.json" + tempStateFileInfix + "1": []byte(`{"name":"volume-1"`),
+	}
+	for name, content := range unchangedFiles {
+		err = os.WriteFile(path.Join(tempDir, name), content, 0666)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sm, err := NewStateManager(tempDir, WithReadOnly(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := sm.GetVolumeStateByID(vs.ID); !reflect.DeepEqual(got, vs) {
+		t.Errorf("expected volume state %#v, got %#v", vs, got)
+	}
+
+	expectedQuarantined := []string{"garbage-uuid"}
+	if got := sm.GetQuarantinedVolumeIDs(); !reflect.DeepEqual(got, expectedQuarantined) {
+		t.Errorf("expected quarantined volumes %v, got %v", expectedQuarantined, got)
+	}
+
+	for name := range unchangedFiles {
+		_, err = os.Stat(path.Join(tempDir, name))
+		if err != nil {
+			t.Errorf("expected %q to be left in place: %v", name, err)
+		}
+	}
+
+	err = sm.SaveVolumeState(newVolumeState("volume-2-uuid", "volume-2"))
+	if !errors.Is(err, StateReadOnlyErr) {
+		t.Errorf("expected %v saving a volume state, got %v", StateReadOnlyErr, err)
+	}
+
+	err = sm.DeleteVolumeState(vs.ID)
+	if !errors.Is(err, StateReadOnlyErr) {
+		t.Errorf("expected %v deleting a volume state, got %v", StateReadOnlyErr, err)
+	}
+
+	err = sm.SaveSnapshotState(newSnapshotState("snapshot-1-uuid", "snapshot-1", vs.ID))
+	if !errors.Is(err, StateReadOnlyErr) {
+		t.Errorf("expected %v saving a snapshot state, got %v", StateReadOnlyErr, err)
+	}
+
+	_, err = os.Stat(path.Join(tempDir, vs.ID+".json"))
+	if err != nil {
+		t.Errorf("expected volume state file to be left in place: %v", err)
+	}
+}
+
+// openTestStateBackend returns the backend of the workspace, which is closed at the end of the test
+// unless the test closes it itself.
+func openTestStateBackend(t *testing.T, dir string, backend StateBackendType) StateBackend {
+	t.Helper()
+
+	sm, err := NewStateManager(dir, WithStateBackend(backend))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = sm.Close()
+	})
+
+	return sm
+}
+
+// reopenTestStateBackend closes the backend and loads its states again.
+func reopenTestStateBackend(t *testing.T, sm StateBackend, dir string, backend StateBackendType) StateBackend {
+	t.Helper()
+
+	err := sm.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return openTestStateBackend(t, dir, backend)
+}
+
+func newSnapshotState(id, name, sourceVolumeID string) *SnapshotState {
+	return &SnapshotState{
+		ID:             id,
+		Name:           name,
+		SourceVolumeID: sourceVolumeID,
+		Size:           1024,
+		CreationTime:   time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+}
+
+// TestStateBackendConformance checks behavior every state backend has to provide.
+func TestStateBackendConformance(t *testing.T) {
+	t.Parallel()
+
+	tt := []struct {
+		name  string
+		check func(t *testing.T, dir string, backend StateBackendType)
+	}{
+		{
+			name: "saved volume is found by ID and name after reopening",
+			check: func(t *testing.T, dir string, backend StateBackendType) {
+				sm := openTestStateBackend(t, dir, backend)
+
+				vs := newVolumeState("volume-1-uuid", "volume-1")
+				vs.AccessType = BlockAccess
+				vs.CreationTime = time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+				err := sm.SaveVolumeState(vs)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				for _, sm := range []StateBackend{sm, reopenTestStateBackend(t, sm, dir, backend)} {
+					for _, got := range []*VolumeState{sm.GetVolumeStateByID(vs.ID), sm.GetVolumeStateByName(vs.Name)} {
+						if !reflect.DeepEqual(got, vs) {
+							t.Errorf("expected %#v, got %#v", vs, got)
+						}
+					}
+
+					volumes := sm.GetVolumes()
+					if !reflect.DeepEqual(volumes, []VolumeState{*vs}) {
+						t.Errorf("expected only %#v volume, got %#v", vs, volumes)
+					}
+				}
+			},
+		},
+		{
+			name: "updated volume replaces the previous state",
+			check: func(t *testing.T, dir string, backend StateBackendType) {
+				sm := openTestStateBackend(t, dir, backend)
+
+				err := sm.SaveVolumeState(newVolumeState("volume-1-uuid", "volume-1"))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				updated := newVolumeState("volume-1-uuid", "volume-1-renamed")
+				updated.Size = 4096
+				err = sm.SaveVolumeState(updated)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				for _, sm := range []StateBackend{sm, reopenTestStateBackend(t, sm, dir, backend)} {
+					if vs := sm.GetVolumeStateByName("volume-1"); vs != nil {
+						t.Errorf("expected previous name not to resolve, got %#v", vs)
+					}
+
+					if vs := sm.GetVolumeStateByName("volume-1-renamed"); !reflect.DeepEqual(vs, updated) {
+						t.Errorf("expected %#v, got %#v", updated, vs)
+					}
+
+					if totalSize := sm.GetTotalVolumesSize(); totalSize != updated.Size {
+						t.Errorf("expected total volumes size %d, got %d", updated.Size, totalSize)
+					}
+				}
+			},
+		},
+		{
+			name: "deleted volume is gone after reopening",
+			check: func(t *testing.T, dir string, backend StateBackendType) {
+				sm := openTestStateBackend(t, dir, backend)
+
+				for _, vs := range []*VolumeState{newVolumeState("volume-1-uuid", "volume-1"), newVolumeState("volume-2-uuid", "volume-2")} {
+					err := sm.SaveVolumeState(vs)
+					if err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				err := sm.DeleteVolumeState("volume-1-uuid")
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				err = sm.DeleteVolumeState("unknown-uuid")
+				if err != nil {
+					t.Errorf("expected deleting unknown volume to succeed, got %v", err)
+				}
+
+				for _, sm := range []StateBackend{sm, reopenTestStateBackend(t, sm, dir, backend)} {
+					if vs := sm.GetVolumeStateByID("volume-1-uuid"); vs != nil {
+						t.Errorf("expected deleted volume not to be found, got %#v", vs)
+					}
+					if vs := sm.GetVolumeStateByName("volume-1"); vs != nil {
+						t.Errorf("expected name of deleted volume not to resolve, got %#v", vs)
+					}
+					if vs := sm.GetVolumeStateByID("volume-2-uuid"); vs == nil {
+						t.Errorf("expected remaining volume to be found")
+					}
+
+					expectedTotalSize := newVolumeState("", "").Size
+					if totalSize := sm.GetTotalVolumesSize(); totalSize != expectedTotalSize {
+						t.Errorf("expected total volumes size %d, got %d", expectedTotalSize, totalSize)
+					}
+				}
+			},
+		},
+		{
+			name: "name of recreated volume resolves to the current volume",
+			check: func(t *testing.T, dir string, backend StateBackendType) {
+				sm := openTestStateBackend(t, dir, backend)
+
+				for _, vs := range []*VolumeState{newVolumeState("volume-1-uuid", "volume-1"), newVolumeState("volume-1-new-uuid", "volume-1")} {
+					err := sm.SaveVolumeState(vs)
+					if err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				err := sm.DeleteVolumeState("volume-1-uuid")
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				for _, sm := range []StateBackend{sm, reopenTestStateBackend(t, sm, dir, backend)} {
+					vs := sm.GetVolumeStateByName("volume-1")
+					if vs == nil || vs.ID != "volume-1-new-uuid" {
+						t.Errorf("expected name to resolve to the recreated volume, got %#v", vs)
+					}
+				}
+			},
+		},
+		{
+			name: "snapshots are saved and deleted",
+			check: func(t *testing.T, dir string, backend StateBackendType) {
+				sm := openTestStateBackend(t, dir, backend)
+
+				ss := newSnapshotState("snapshot-1-uuid", "snapshot-1", "volume-1-uuid")
+				for _, ss := range []*SnapshotState{ss, newSnapshotState("snapshot-2-uuid", "snapshot-2", "volume-1-uuid")} {
+					err := sm.SaveSnapshotState(ss)
+					if err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				err := sm.DeleteSnapshotState("snapshot-2-uuid")
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				for _, sm := range []StateBackend{sm, reopenTestStateBackend(t, sm, dir, backend)} {
+					for _, got := range []*SnapshotState{sm.GetSnapshotStateByID(ss.ID), sm.GetSnapshotStateByName(ss.Name)} {
+						if !reflect.DeepEqual(got, ss) {
+							t.Errorf("expected %#v, got %#v", ss, got)
+						}
+					}
+
+					snapshots := sm.GetSnapshots()
+					if !reflect.DeepEqual(snapshots, []SnapshotState{*ss}) {
+						t.Errorf("expected only %#v snapshot, got %#v", ss, snapshots)
+					}
+
+					if volumes := sm.GetVolumes(); len(volumes) != 0 {
+						t.Errorf("expected snapshots not to be loaded as volumes, got %#v", volumes)
+					}
+				}
+			},
+		},
+	}
+
+	for _, backend := range []StateBackendType{FilesStateBackend, BoltStateBackend} {
+		for i := range tt {
+			tc := tt[i]
+			t.Run(fmt.Sprintf("%s/%s", backend, tc.name), func(t *testing.T) {
+				t.Parallel()
+
+				tc.check(t, t.TempDir(), backend)
+			})
+		}
+	}
+}
+
+func BenchmarkNewStateManager(b *testing.B) {
+	const volumesCount = 50000
+
+	filesDir := b.TempDir()
+	for i := 0; i < volumesCount; i++ {
+		id := fmt.Sprintf("volume-%d-uuid", i)
+		err := writeVolumeState(path.Join(filesDir, fmt.Sprintf("%s.json", id)), newVolumeState(id, fmt.Sprintf("volume-%d", i)))
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	boltDir := b.TempDir()
+	sm, err := NewStateManager(boltDir, WithStateBackend(BoltStateBackend))
+	if err != nil {
+		b.Fatal(err)
+	}
+	// Volumes are stored in a single transaction, saving them one by one would sync the file for each of them.
+	bs := sm.(*boltStateBackend)
+	err = bs.db.Update(func(tx *bolt.Tx) error {
+		for i := 0; i < volumesCount; i++ {
+			id := fmt.Sprintf("volume-%d-uuid", i)
+			data, err := encodeState(newVolumeState(id, fmt.Sprintf("volume-%d", i)))
+			if err != nil {
+				return err
+			}
+
+			err = tx.Bucket(volumesBucket).Put([]byte(id), data)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+	err = sm.Close()
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	for _, batchSize := range []int{64, DefaultReadDirBatchSize, volumesCount} {
+		b.Run(fmt.Sprintf("files/batch-size-%d", batchSize), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				sm, err := NewStateManager(filesDir, WithReadDirBatchSize(batchSize))
+				if err != nil {
+					b.Fatal(err)
+				}
+				_ = sm.Close()
+			}
+		})
+	}
+
+	b.Run("bolt", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			sm, err := NewStateManager(boltDir, WithStateBackend(BoltStateBackend))
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			err = sm.Close()
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -3,18 +3,68 @@
 package volume
 
 import (
+	"encoding/json"
+	stderrors "errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/scylladb/local-csi-driver/pkg/driver/limit"
+	"github.com/scylladb/local-csi-driver/pkg/driver/limit/xfs/fxattrs"
+	"github.com/scylladb/local-csi-driver/pkg/util/fs"
 	"github.com/scylladb/local-csi-driver/pkg/util/slices"
+	"github.com/scylladb/local-csi-driver/pkg/util/uuid"
 	"golang.org/x/sys/unix"
 	"k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/klog/v2"
 	"k8s.io/mount-utils"
 )
 
+var (
+	// VolumeDirectoryNotEmptyErr is returned when a new volume would reuse unknown data left in its directory.
+	VolumeDirectoryNotEmptyErr = stderrors.New("volume directory already exists and isn't empty")
+	// DuplicateLimitIDErr is returned when a new limit keeps colliding with limits of existing volumes.
+	DuplicateLimitIDErr = stderrors.New("limit ID is already used by another volume")
+	// MismatchingFsTypeErr is returned when a directory backed volume requests a filesystem other than the one
+	// of the volumes dir.
+	MismatchingFsTypeErr = stderrors.New("requested fsType doesn't match volumes dir filesystem")
+	// EncryptionNotSupportedErr is returned when an encrypted volume is requested on a filesystem without fscrypt support.
+	EncryptionNotSupportedErr = stderrors.New("volumes dir filesystem doesn't support encryption")
+	// SnapshotNotFoundErr is returned when a volume is to be populated from a snapshot which doesn't exist.
+	SnapshotNotFoundErr = stderrors.New("snapshot doesn't exist")
+	// SourceVolumeNotFoundErr is returned when a volume is to be cloned from a volume which doesn't exist.
+	SourceVolumeNotFoundErr = stderrors.New("source volume doesn't exist")
+	// ExtentSizeHintNotSupportedErr is returned when an extent size hint is requested on a volumes dir which isn't XFS.
+	ExtentSizeHintNotSupportedErr = stderrors.New("volumes dir filesystem doesn't support extent size hints")
+	// ExtentMappingNotSupportedErr is returned when extents of a volume are requested on a filesystem without FIEMAP support.
+	ExtentMappingNotSupportedErr = stderrors.New("volumes dir filesystem doesn't support extent mapping")
+	// EncryptionKeyMismatchErr is returned when a key other than the one an encrypted volume was created with is provided.
+	EncryptionKeyMismatchErr = stderrors.New("encryption key doesn't match the volume key")
+	// TooManyPendingCreationsErr is returned when a volume creation would exceed the limit of concurrent ones.
+	TooManyPendingCreationsErr = stderrors.New("too many volumes are being created")
+)
+
+const (
+	probeFileName = ".probe"
+	// blockFileName is the name of the sparse file backing a block or loop backed volume within its volume directory.
+	blockFileName = "block"
+	// OrphanedDirName is the name of the directory within a volumes dir which reconciliation moves volume and snapshot
+	// directories without a state to. They're kept for an administrator to inspect and remove.
+	OrphanedDirName = "orphaned"
+
+	// DefaultLoopBackingFsType is the filesystem loop backed volumes are formatted with when none is requested.
+	DefaultLoopBackingFsType = "ext4"
+
+	// maxLimitAllocationAttempts bounds how many times a new limit is initialized when it collides with a limit
+	// of an existing volume.
+	maxLimitAllocationAttempts = 5
+)
+
 type VolumeStatistics struct {
 	AvailableBytes  int64
 	TotalBytes      int64
@@ -24,15 +74,235 @@ type VolumeStatistics struct {
 	UsedInodes      int64
 }
 
+// DefaultFilesystemCapacityReservationPercent holds the percentage of raw filesystem size which isn't
+// reported as available because of the filesystem's own fixed overhead.
+var DefaultFilesystemCapacityReservationPercent = map[string]int{
+	// XFS keeps a portion of free blocks reserved for metadata allocations and internal logs.
+	"xfs": 2,
+	// Blocks ext4 reserves for the root user are already excluded from the filesystem size based on statfs.
+	"ext4": 0,
+}
+
+// DefaultUnmountRetryBackoff is used for retrying unmounts failing because the mount is still in use.
+var DefaultUnmountRetryBackoff = wait.Backoff{
+	Steps:    5,
+	Duration: 100 * time.Millisecond,
+	Factor:   2,
+	Jitter:   0.1,
+}
+
+// DefaultCapacityCacheTTL is for how long statistics of the volumes dir filesystem are reused for computing capacity.
+const DefaultCapacityCacheTTL = 1 * time.Second
+
+// DefaultFilesystemRetryBackoff is used for retrying directory operations failing with transient errors.
+var DefaultFilesystemRetryBackoff = wait.Backoff{
+	Steps:    5,
+	Duration: 50 * time.Millisecond,
+	Factor:   2,
+	Jitter:   0.1,
+}
+
+// MountObserver is notified about every mount and unmount made by the volume manager, with how long it took.
+type MountObserver interface {
+	ObserveMount(duration time.Duration, err error)
+	ObserveUnmount(duration time.Duration, err error)
+}
+
+type noopMountObserver struct{}
+
+func (noopMountObserver) ObserveMount(time.Duration, error)   {}
+func (noopMountObserver) ObserveUnmount(time.Duration, error) {}
+
 type VolumeManager struct {
-	volumesDir string
-	mounter    mount.Interface
-	state      *StateManager
-	limiter    limit.Limiter
+	volumesDir                 string
+	nodeName                   string
+	mounter                    mount.Interface
+	state                      StateBackend
+	limiter                    limit.Limiter
+	capacityReservationPercent int
+	reservedCapacityBytes      int64
+	reservedCapacityPercent    int
+	maxTotalProvisionedBytes   int64
+	fsRetryBackoff             wait.Backoff
+	unmountRetryBackoff        wait.Backoff
+	lazyUnmountEnabled         bool
+	bytesPerInode              int64
+	reconcileOnStartup         bool
+	createVolumeRetries        int
+	maxPendingCreations        int
+	capacityCacheTTL           time.Duration
+	mountObserver              MountObserver
+	volumesDirFsType           string
+	rejectMismatchingFsType    bool
+	additionalVolumesDirs      []AdditionalVolumesDir
+	capacityPolicy             CapacityPolicy
+
+	// unrestoredLimits holds IDs of volumes whose limits couldn't be restored on startup, so they aren't enforced.
+	unrestoredLimits map[string]struct{}
+
+	// creatingVolumes holds IDs of volumes being created, whose directories exist before their states are saved.
+	creatingVolumes    map[string]struct{}
+	creatingVolumesMut sync.Mutex
+
+	// volumesDirs holds the main volumes dir followed by the additional ones.
+	volumesDirs []*volumesDirectory
+
+	attachLoopDevice  func(backingFile string) (string, error)
+	detachLoopDevices func(backingFile string) error
+	listLoopDevices   func() (map[string][]string, error)
+	getDeviceIOStats  func(device string) (fs.IOStats, error)
+	getExtentStats    func(dir string) (fs.ExtentStats, error)
+	makeFilesystem    func(path, fsType string) error
+	// Encryption is split into steps so tests can run on filesystems without fscrypt support.
+	supportsEncryption  func(dir string) (bool, error)
+	addEncryptionKey    func(dir string, key []byte) (string, error)
+	setEncryptionPolicy func(dir, keyIdentifier string) error
+	setExtentSizeHint   func(dir string, extentSize uint32) error
+	statfs              func(path string, buf *unix.Statfs_t) error
+	lazyUnmount         func(target string) error
+	remountReadOnly     func(target string) error
+	now                 func() time.Time
 }
 
 type VolumeManagerOption func(v *VolumeManager)
 
+// WithFilesystemRetryBackoff sets the backoff used for retrying directory operations failing with transient errors.
+func WithFilesystemRetryBackoff(backoff wait.Backoff) func(*VolumeManager) {
+	return func(v *VolumeManager) {
+		v.fsRetryBackoff = backoff
+	}
+}
+
+// WithUnmountRetryBackoff sets the backoff used for retrying unmounts failing because the mount is still in use.
+func WithUnmountRetryBackoff(backoff wait.Backoff) func(*VolumeManager) {
+	return func(v *VolumeManager) {
+		v.unmountRetryBackoff = backoff
+	}
+}
+
+// WithLazyUnmount makes unmounts which are still busy after all retries fall back to detaching the mount lazily.
+func WithLazyUnmount(enabled bool) func(*VolumeManager) {
+	return func(v *VolumeManager) {
+		v.lazyUnmountEnabled = enabled
+	}
+}
+
+// WithUnrestoredLimits marks volumes whose limits couldn't be restored by limiters, so they aren't enforced.
+func WithUnrestoredLimits(volumeIDs ...string) func(*VolumeManager) {
+	return func(v *VolumeManager) {
+		for _, volID := range volumeIDs {
+			v.unrestoredLimits[volID] = struct{}{}
+		}
+	}
+}
+
+// WithCapacityReservationPercent sets the percentage of raw filesystem size excluded from the available capacity.
+func WithCapacityReservationPercent(percent int) func(*VolumeManager) {
+	return func(v *VolumeManager) {
+		v.capacityReservationPercent = percent
+	}
+}
+
+// WithReservedCapacity sets the number of bytes kept free for the host, excluded from the available capacity.
+func WithReservedCapacity(bytes int64) func(*VolumeManager) {
+	return func(v *VolumeManager) {
+		v.reservedCapacityBytes = bytes
+	}
+}
+
+// WithReservedCapacityPercent sets the percentage of raw filesystem size kept free for the host,
+// on top of the filesystem's own reservation.
+func WithReservedCapacityPercent(percent int) func(*VolumeManager) {
+	return func(v *VolumeManager) {
+		v.reservedCapacityPercent = percent
+	}
+}
+
+// WithMaxTotalProvisionedBytes caps the sum of sizes of all volumes, regardless of the filesystem size.
+// Zero means there is no cap.
+func WithMaxTotalProvisionedBytes(bytes int64) func(*VolumeManager) {
+	return func(v *VolumeManager) {
+		v.maxTotalProvisionedBytes = bytes
+	}
+}
+
+// WithBytesPerInode makes volumes created without an explicit inode limit be limited to one inode
+// per every bytesPerInode bytes of their capacity. Zero disables deriving inode limits.
+func WithBytesPerInode(bytesPerInode int64) func(*VolumeManager) {
+	return func(v *VolumeManager) {
+		v.bytesPerInode = bytesPerInode
+	}
+}
+
+// WithReconcileOnStartup makes the volume manager remove volume directories and limits which don't belong
+// to any volume when it's created. They are leaked when the driver is killed in the middle of creating
+// or deleting a volume.
+func WithReconcileOnStartup(reconcile bool) func(*VolumeManager) {
+	return func(v *VolumeManager) {
+		v.reconcileOnStartup = reconcile
+	}
+}
+
+// WithCreateVolumeRetries sets how many times a volume creation failing with a transient error is rolled back
+// and attempted again as a whole.
+func WithCreateVolumeRetries(retries int) func(*VolumeManager) {
+	return func(v *VolumeManager) {
+		v.createVolumeRetries = retries
+	}
+}
+
+// WithMaxPendingCreations limits how many volumes can be created concurrently. Zero means no limit.
+func WithMaxPendingCreations(n int) func(*VolumeManager) {
+	return func(v *VolumeManager) {
+		v.maxPendingCreations = n
+	}
+}
+
+// WithCapacityCacheTTL sets for how long statistics of the volumes dir filesystem are reused for computing capacity.
+// Zero disables caching.
+func WithCapacityCacheTTL(ttl time.Duration) func(*VolumeManager) {
+	return func(v *VolumeManager) {
+		v.capacityCacheTTL = ttl
+	}
+}
+
+// WithVolumesDirFilesystem sets the filesystem of the volumes dir, which is recorded in states of directory backed volumes.
+func WithVolumesDirFilesystem(fsType string) func(*VolumeManager) {
+	return func(v *VolumeManager) {
+		v.volumesDirFsType = fsType
+	}
+}
+
+// WithNodeName sets the name of the node, which is recorded in states of created volumes.
+func WithNodeName(nodeName string) func(*VolumeManager) {
+	return func(v *VolumeManager) {
+		v.nodeName = nodeName
+	}
+}
+
+// WithRejectMismatchingFsType makes creation of directory backed volumes requesting a filesystem other than
+// the one of the volumes dir fail, instead of ignoring the requested filesystem.
+func WithRejectMismatchingFsType(reject bool) func(*VolumeManager) {
+	return func(v *VolumeManager) {
+		v.rejectMismatchingFsType = reject
+	}
+}
+
+// WithAdditionalVolumesDirs makes volumes provisioned also in the given directories, besides the main volumes dir.
+// New volumes are provisioned in the directory with the most available capacity.
+func WithAdditionalVolumesDirs(dirs ...AdditionalVolumesDir) func(*VolumeManager) {
+	return func(v *VolumeManager) {
+		v.additionalVolumesDirs = append(v.additionalVolumesDirs, dirs...)
+	}
+}
+
+// WithCapacityPolicy sets how available capacity of multiple volumes dirs is reported.
+func WithCapacityPolicy(policy CapacityPolicy) func(*VolumeManager) {
+	return func(v *VolumeManager) {
+		v.capacityPolicy = policy
+	}
+}
+
 func WithLimiter(limiter limit.Limiter) func(*VolumeManager) {
 	return func(v *VolumeManager) {
 		v.limiter = limiter
@@ -45,217 +315,1980 @@ func WithMounter(mounter mount.Interface) func(*VolumeManager) {
 	}
 }
 
-func NewVolumeManager(volumesDir string, sm *StateManager, options ...VolumeManagerOption) (*VolumeManager, error) {
-	v := &VolumeManager{
-		volumesDir: volumesDir,
+// WithStatfs sets the source of filesystem statistics capacity and volume usage are computed from.
+func WithStatfs(statfs func(path string, buf *unix.Statfs_t) error) func(*VolumeManager) {
+	return func(v *VolumeManager) {
+		v.statfs = statfs
+	}
+}
 
-		mounter: mount.New(""),
-		state:   sm,
-		limiter: &limit.NoopLimiter{},
+func NewVolumeManager(volumesDir string, sm StateBackend, options ...VolumeManagerOption) (*VolumeManager, error) {
+	v, err := newVolumeManager(volumesDir, sm, options...)
+	if err != nil {
+		return nil, err
 	}
 
-	for _, option := range options {
-		option(v)
+	err = v.initVolumesDirs()
+	if err != nil {
+		return nil, err
+	}
+
+	quarantinedVolumes, quarantinedSnapshots := v.state.GetQuarantinedVolumeIDs(), v.state.GetQuarantinedSnapshotIDs()
+	if v.reconcileOnStartup && (len(quarantinedVolumes) != 0 || len(quarantinedSnapshots) != 0) {
+		// Limits of volumes with quarantined states are unknown, so any limit could be theirs.
+		klog.Warningf("Skipping reconciliation on startup, states of volumes %q and snapshots %q are quarantined", quarantinedVolumes, quarantinedSnapshots)
+	} else if v.reconcileOnStartup {
+		for _, d := range v.volumesDirs {
+			err := v.reconcile(d)
+			if err != nil {
+				return nil, fmt.Errorf("can't reconcile volumes dir %q: %w", d.path, err)
+			}
+		}
+	}
+
+	for _, d := range v.volumesDirs {
+		v.refreshUntrackedUsage(d)
 	}
 
 	return v, nil
 }
 
-func (v *VolumeManager) CreateVolume(volID, name string, capacity int64, volAccessType AccessType) error {
-	availableCapacity, err := v.GetAvailableCapacity()
+// InspectCapacity returns the available capacity of the volumes dirs as NewVolumeManager with the same options would
+// compute it, without changing anything on disk, e.g. to inspect volumes dirs of a running driver. Volumes dirs
+// aren't assigned IDs and states are anchored to them only in memory.
+func InspectCapacity(volumesDir string, sm StateBackend, options ...VolumeManagerOption) (int64, error) {
+	v, err := newVolumeManager(volumesDir, sm, options...)
 	if err != nil {
-		return fmt.Errorf("requested volume capacity of %dB exceedes available one (%dB)", capacity, availableCapacity)
+		return 0, err
 	}
 
-	path := v.getVolumePath(volID)
+	err = v.buildVolumesDirs()
+	if err != nil {
+		return 0, err
+	}
 
-	if !slices.Contains(v.SupportedAccessTypes(), volAccessType) {
-		return fmt.Errorf("unsupported access type %v", volAccessType)
+	err = v.assignVolumesDirIDs(readVolumesDirID)
+	if err != nil {
+		return 0, err
 	}
 
-	klog.V(2).InfoS("Creating volume directory", "path", path)
-	err = os.Mkdir(path, 0770)
-	if err != nil && !os.IsExist(err) {
-		return fmt.Errorf("can't create volume directory at %q: %w", path, err)
+	var volumes []VolumeState
+	for _, vs := range v.state.GetVolumes() {
+		v.anchorVolume(&vs)
+		volumes = append(volumes, vs)
 	}
 
-	limitID, err := v.limiter.NewLimit(path)
-	if err != nil {
-		errs := []error{
-			fmt.Errorf("can't init new limit: %w", err),
-		}
+	var snapshots []SnapshotState
+	for _, ss := range v.state.GetSnapshots() {
+		v.anchorSnapshot(&ss)
+		snapshots = append(snapshots, ss)
+	}
 
-		rmErr := os.Remove(path)
-		if rmErr != nil {
-			errs = append(errs, fmt.Errorf("can't remove volume directory: %w", rmErr))
+	for _, d := range v.volumesDirs {
+		usage, err := v.getUntrackedUsage(d)
+		if err != nil {
+			return 0, err
 		}
+		d.untrackedUsage.Store(usage)
+	}
 
-		return errors.NewAggregate(errs)
+	breakdown, err := v.getCapacityBreakdown(volumes, snapshots)
+	if err != nil {
+		return 0, err
 	}
 
-	klog.V(2).InfoS("New limit initialized", "limitID", limitID, "path", path)
+	return max(0, breakdown.AvailableBytes()), nil
+}
 
-	volumeState := &VolumeState{
-		Name:    name,
-		ID:      volID,
-		LimitID: limitID,
-		Size:    capacity,
+// newVolumeManager returns a volume manager with the options applied, before its volumes dirs are set up.
+func newVolumeManager(volumesDir string, sm StateBackend, options ...VolumeManagerOption) (*VolumeManager, error) {
+	v := &VolumeManager{
+		volumesDir: volumesDir,
+
+		mounter:             mount.New(""),
+		state:               sm,
+		limiter:             &limit.NoopLimiter{},
+		fsRetryBackoff:      DefaultFilesystemRetryBackoff,
+		unmountRetryBackoff: DefaultUnmountRetryBackoff,
+		capacityCacheTTL:    DefaultCapacityCacheTTL,
+		capacityPolicy:      MaxCapacityPolicy,
+		mountObserver:       noopMountObserver{},
+		unrestoredLimits:    map[string]struct{}{},
+		creatingVolumes:     map[string]struct{}{},
+
+		attachLoopDevice:  fs.AttachLoopDevice,
+		detachLoopDevices: fs.DetachLoopDevices,
+		listLoopDevices:   fs.GetLoopDevicesByBackingFile,
+		getDeviceIOStats:  fs.GetBlockDeviceIOStats,
+		getExtentStats:    fs.GetExtentStats,
+		makeFilesystem:    fs.MakeFilesystem,
+
+		supportsEncryption:  fs.SupportsEncryption,
+		addEncryptionKey:    fs.AddEncryptionKey,
+		setEncryptionPolicy: fs.SetEncryptionPolicy,
+		setExtentSizeHint:   setExtentSizeHint,
+
+		statfs:          unix.Statfs,
+		lazyUnmount:     fs.LazyUnmount,
+		remountReadOnly: fs.RemountReadOnly,
+		now:             time.Now,
 	}
 
-	err = v.state.SaveVolumeState(volumeState)
-	if err != nil {
-		errs := []error{
-			fmt.Errorf("failed to save volume state: %w", err),
-		}
+	for _, option := range options {
+		option(v)
+	}
 
-		removeDirErr := os.Remove(path)
-		if removeDirErr != nil {
-			errs = append(errs, fmt.Errorf("failed to remove volume directory: %w", removeDirErr))
-		}
+	if v.capacityReservationPercent < 0 || v.capacityReservationPercent >= 100 {
+		return nil, fmt.Errorf("capacity reservation percent must be within [0, 100) range, got %d", v.capacityReservationPercent)
+	}
 
-		removeLimitErr := v.limiter.RemoveLimit(limitID)
-		if removeLimitErr != nil {
-			errs = append(errs, fmt.Errorf("failed to remove volume limit: %w", removeLimitErr))
-		}
+	if v.reservedCapacityBytes < 0 {
+		return nil, fmt.Errorf("reserved capacity can't be negative, got %d", v.reservedCapacityBytes)
+	}
 
-		return errors.NewAggregate(errs)
+	if v.reservedCapacityPercent < 0 || v.reservedCapacityPercent > 100 {
+		return nil, fmt.Errorf("reserved capacity percent must be within [0, 100] range, got %d", v.reservedCapacityPercent)
 	}
 
-	err = v.limiter.SetLimit(limitID, capacity)
-	if err != nil {
-		errs := []error{
-			fmt.Errorf("failed to save volume state: %w", err),
-		}
+	if v.maxTotalProvisionedBytes < 0 {
+		return nil, fmt.Errorf("max total provisioned bytes can't be negative, got %d", v.maxTotalProvisionedBytes)
+	}
 
-		removeDirErr := os.Remove(path)
-		if removeDirErr != nil {
-			errs = append(errs, fmt.Errorf("failed to remove volume directory: %w", removeDirErr))
-		}
+	if v.bytesPerInode < 0 {
+		return nil, fmt.Errorf("bytes per inode can't be negative, got %d", v.bytesPerInode)
+	}
 
-		removeLimitErr := v.limiter.RemoveLimit(limitID)
-		if removeLimitErr != nil {
-			errs = append(errs, fmt.Errorf("failed to remove volume limit: %w", removeLimitErr))
-		}
+	if v.rejectMismatchingFsType && len(v.volumesDirFsType) == 0 {
+		return nil, fmt.Errorf("volumes dir filesystem has to be known to reject mismatching fsType")
+	}
 
-		removeStateFileErr := v.state.DeleteVolumeState(volID)
-		if removeStateFileErr != nil {
-			errs = append(errs, fmt.Errorf("failed to remove volume state file: %w", removeStateFileErr))
-		}
+	if v.capacityCacheTTL < 0 {
+		return nil, fmt.Errorf("capacity cache TTL can't be negative, got %v", v.capacityCacheTTL)
+	}
 
-		return errors.NewAggregate(errs)
+	if v.createVolumeRetries < 0 {
+		return nil, fmt.Errorf("create volume retries can't be negative, got %d", v.createVolumeRetries)
 	}
 
-	return nil
-}
+	if v.capacityPolicy != MaxCapacityPolicy && v.capacityPolicy != SumCapacityPolicy {
+		return nil, fmt.Errorf("unsupported capacity policy %q", v.capacityPolicy)
+	}
 
-func (v *VolumeManager) DeleteVolume(volID string) error {
-	vs := v.state.GetVolumeStateByID(volID)
+	return v, nil
+}
 
-	path := v.getVolumePath(volID)
-	err := os.RemoveAll(path)
-	if err != nil && !os.IsNotExist(err) {
-		return fmt.Errorf("can't delete mount of volume %q at %q: %w", volID, path, err)
+// reconcile moves volume and snapshot directories without a state aside and removes limits which aren't used by any
+// volume from the volumes dir.
+func (v *VolumeManager) reconcile(d *volumesDirectory) error {
+	// Directories of volumes having a state are never moved, even when the state places them in another volumes dir.
+	// Limits are checked only against volumes of the volumes dir, as every volumes dir has a limiter of its own.
+	volumes := v.state.GetVolumes()
+	volumeIDs := make(map[string]struct{}, len(volumes))
+	for _, vs := range volumes {
+		volumeIDs[vs.ID] = struct{}{}
 	}
-	if err == nil {
-		klog.V(2).InfoS("Removed volume directory", "volume", volID, "path", path)
+	for _, id := range v.state.GetQuarantinedVolumeIDs() {
+		volumeIDs[id] = struct{}{}
 	}
 
-	if vs != nil {
-		err = v.limiter.RemoveLimit(vs.LimitID)
-		if err != nil {
-			return fmt.Errorf("can't delete state of volume %q: %w", volID, err)
-		}
-		klog.V(2).InfoS("Removed limit", "limitID", vs.LimitID)
+	dirVolumes := v.getVolumesInDir(d, volumes)
+	limitIDs := make(map[uint32]struct{}, len(dirVolumes))
+	for _, vs := range dirVolumes {
+		limitIDs[vs.LimitID] = struct{}{}
 	}
 
-	err = v.state.DeleteVolumeState(volID)
+	entries, err := os.ReadDir(d.path)
 	if err != nil {
-		return fmt.Errorf("can't delete state of volume %q: %w", volID, err)
+		return fmt.Errorf("can't read directory %q: %w", d.path, err)
 	}
-	klog.V(2).InfoS("Removed volume state file", "volume", volID)
 
-	return nil
-}
+	var errs []error
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
 
-func (v *VolumeManager) GetAvailableCapacity() (int64, error) {
-	var stat unix.Statfs_t
-	err := unix.Statfs(v.volumesDir, &stat)
+		if _, ok := volumeIDs[e.Name()]; ok {
+			continue
+		}
+
+		// Volume IDs are UUIDs, anything else in the volumes dir doesn't belong to the driver.
+		_, err = uuid.Parse(e.Name())
+		if err != nil {
+			klog.V(2).InfoS("Skipping unknown directory in volumes dir", "name", e.Name())
+			continue
+		}
+
+		path := filepath.Join(d.path, e.Name())
+		err = v.removeOrphanedLimit(d, e.Name(), path)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		err = v.moveOrphanedDirectory(d, path)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("can't move orphaned volume directory %q aside: %w", e.Name(), err))
+		}
+	}
+
+	existingLimitIDs, err := d.limiter.ListLimitIDs()
 	if err != nil {
-		return 0, fmt.Errorf("can't check statfs of %q: %w", v.volumesDir, err)
+		errs = append(errs, fmt.Errorf("can't list limits: %w", err))
+		return errors.NewAggregate(errs)
 	}
 
-	// Reserve space for 1 more volume metadata to return max allocatable space.
-	metadataSize := (len(v.state.GetVolumes()) + 1) * MetadataFileMaxSize
-	capacity := stat.Bsize*int64(stat.Blocks) - v.state.GetTotalVolumesSize() - int64(metadataSize)
+	for _, limitID := range existingLimitIDs {
+		if _, ok := limitIDs[limitID]; ok {
+			continue
+		}
 
-	return capacity, nil
-}
+		klog.V(2).InfoS("Removing leaked limit", "limitID", limitID, "volumesDir", d.path)
+		err = d.limiter.RemoveLimit(limitID)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("can't remove leaked limit %d: %w", limitID, err))
+		}
+	}
 
-func (v *VolumeManager) GetVolumeStatistics(volumePath string) (*VolumeStatistics, error) {
-	statfs := &unix.Statfs_t{}
-	err := unix.Statfs(volumePath, statfs)
+	err = v.reconcileSnapshots(d)
 	if err != nil {
-		err = fmt.Errorf("can't get statfs on path %q: %w", volumePath, err)
-		return nil, err
+		errs = append(errs, err)
 	}
 
-	return &VolumeStatistics{
-		AvailableBytes:  int64(statfs.Bavail) * statfs.Bsize,
-		TotalBytes:      int64(statfs.Blocks) * statfs.Bsize,
-		UsedBytes:       (int64(statfs.Blocks) - int64(statfs.Bfree)) * statfs.Bsize,
-		AvailableInodes: int64(statfs.Ffree),
-		TotalInodes:     int64(statfs.Files),
-		UsedInodes:      int64(statfs.Files) - int64(statfs.Ffree),
-	}, nil
+	return errors.NewAggregate(errs)
 }
 
-func (v *VolumeManager) Mount(volumeID, targetPath, fsType string, mountOptions []string) error {
-	path := v.getVolumePath(volumeID)
-
-	err := os.MkdirAll(targetPath, 0770)
+// moveOrphanedDirectory moves the directory without a state to the orphaned dir of the volumes dir. A directory loses
+// its state also when the state can't be read, so its data is kept for an administrator to decide about.
+func (v *VolumeManager) moveOrphanedDirectory(d *volumesDirectory, path string) error {
+	orphanedDir := filepath.Join(d.path, OrphanedDirName)
+	err := os.Mkdir(orphanedDir, 0770)
 	if err != nil && !os.IsExist(err) {
-		return fmt.Errorf("can't create target path at %q: %w", targetPath, err)
+		return fmt.Errorf("can't create directory %q: %w", orphanedDir, err)
 	}
 
-	klog.V(2).InfoS("Mounting volume directory", "path", path, "targetPath", targetPath)
-	err = v.mounter.Mount(path, targetPath, fsType, mountOptions)
+	target := filepath.Join(orphanedDir, filepath.Base(path))
+	_, err = os.Lstat(target)
+	if err == nil {
+		return fmt.Errorf("%q is already taken by a directory moved aside earlier", target)
+	}
+	if !os.IsNotExist(err) {
+		return fmt.Errorf("can't stat %q: %w", target, err)
+	}
+
+	err = os.Rename(path, target)
 	if err != nil {
-		return fmt.Errorf("can't mount device %q at %q: %w", path, targetPath, err)
+		return fmt.Errorf("can't rename %q to %q: %w", path, target, err)
 	}
+	klog.V(2).InfoS("Moved orphaned directory aside", "path", path, "target", target)
 
 	return nil
 }
 
-func (v *VolumeManager) Unmount(targetPath string) error {
-	err := v.mounter.Unmount(targetPath)
+// CreateVolumeOptions describe a new volume beyond its ID, name and capacity. Zero value is a directory backed
+// mount volume.
+type CreateVolumeOptions struct {
+	AccessType AccessType
+	// BackingMode defaults to DirectoryBacking.
+	BackingMode BackingMode
+	// FsType is the filesystem loop backed mount volumes are formatted with, DefaultLoopBackingFsType when it's empty.
+	// Directory backed mount volumes are provisioned in a volumes dir having it, when there is one.
+	FsType string
+	// InodeLimit is derived from the capacity when it's zero, if bytes per inode ratio is configured.
+	InodeLimit     uint64
+	ExtentSizeHint uint32
+	Attributes     VolumeAttributes
+	// EncryptionKey encrypts the volume directory using fscrypt, when it's set.
+	EncryptionKey []byte
+	// Source is the content the volume is populated with, if it has any.
+	Source ContentSource
+}
+
+// CreateVolume provisions a new volume, nil options create a directory backed mount volume. It fails with
+// TooManyPendingCreationsErr when the limit of concurrent creations is reached.
+func (v *VolumeManager) CreateVolume(volID, name string, capacity int64, options *CreateVolumeOptions) error {
+	o := CreateVolumeOptions{}
+	if options != nil {
+		o = *options
+	}
+	if len(o.BackingMode) == 0 {
+		o.BackingMode = DirectoryBacking
+	}
+
+	err := ValidateVolumeID(volID)
 	if err != nil {
-		return fmt.Errorf("failed to unmount target path at %q: %w", targetPath, err)
+		return err
 	}
 
-	err = os.Remove(targetPath)
-	if err != nil && !os.IsNotExist(err) {
-		return fmt.Errorf("failed to remove target path at %q: %w", targetPath, err)
+	err = v.startCreatingVolume(volID)
+	if err != nil {
+		return err
 	}
+	defer v.finishCreatingVolume(volID)
 
-	return nil
-}
+	// Even a failed creation can leave files behind, so capacity is always computed from fresh statistics afterwards.
+	defer v.invalidateStatfsCache()
 
-func (v *VolumeManager) SupportedAccessTypes() []AccessType {
-	return []AccessType{MountAccess}
-}
+	// Every failed attempt rolls back what it created, so the next one starts from scratch.
+	backoff := v.fsRetryBackoff
+	for attempt := 1; ; attempt++ {
+		err = v.createVolume(volID, name, capacity, &o)
+		if err == nil || !fs.IsTransientError(err) || attempt > v.createVolumeRetries {
+			return err
+		}
 
-func (v *VolumeManager) SupportedFilesystems() []string {
-	return []string{"", "xfs"}
+		delay := backoff.Step()
+		klog.V(2).InfoS("Retrying volume creation after transient error", "volumeID", volID, "attempt", attempt, "error", err, "delay", delay)
+		time.Sleep(delay)
+	}
 }
 
-func (v *VolumeManager) GetVolumeStateByID(id string) *VolumeState {
-	return v.state.GetVolumeStateByID(id)
-}
+func (v *VolumeManager) createVolume(volID, name string, capacity int64, o *CreateVolumeOptions) error {
+	var err error
 
-func (v *VolumeManager) GetVolumeStateByName(name string) *VolumeState {
-	return v.state.GetVolumeStateByName(name)
+	volAccessType, backingMode, fsType := o.AccessType, o.BackingMode, o.FsType
+	inodeLimit, extentSizeHint, encryptionKey, source := o.InodeLimit, o.ExtentSizeHint, o.EncryptionKey, o.Source
+
+	if backingMode != DirectoryBacking && backingMode != LoopBacking {
+		return fmt.Errorf("unsupported backing mode %q", backingMode)
+	}
+
+	if volAccessType == BlockAccess && backingMode == LoopBacking {
+		return fmt.Errorf("block volumes are always backed by a file, they can't be loop backed")
+	}
+
+	if !slices.Contains(v.SupportedAccessTypes(), volAccessType) {
+		return fmt.Errorf("unsupported access type %v", volAccessType)
+	}
+
+	if len(encryptionKey) != 0 && (volAccessType != MountAccess || backingMode != DirectoryBacking) {
+		return fmt.Errorf("only directory backed mount volumes can be encrypted")
+	}
+
+	populatingSrc, err := v.getPopulatingSource(source, capacity, volAccessType, backingMode)
+	if err != nil {
+		return err
+	}
+
+	// Creation retried after a failure stays in the volumes dir it was started in.
+	var dir *volumesDirectory
+	existingVs := v.state.GetVolumeStateByID(volID)
+	if existingVs != nil {
+		dir = v.getVolumesDirOf(existingVs)
+		if dir == nil {
+			return fmt.Errorf("volume %q is in volumes dir %q which isn't configured: %w", volID, existingVs.VolumesDir, VolumesDirUnavailableErr)
+		}
+	} else {
+		dir, err = v.selectVolumesDir(capacity, volAccessType, backingMode, fsType)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Filesystem of directory backed volumes is the one of the volumes dir.
+	if backingMode == DirectoryBacking {
+		if v.rejectMismatchingFsType && volAccessType == MountAccess && len(fsType) != 0 && fsType != dir.fsType {
+			return fmt.Errorf("can't create volume with %q fsType on %q volumes dir: %w", fsType, dir.fsType, MismatchingFsTypeErr)
+		}
+
+		fsType = ""
+		if volAccessType == MountAccess {
+			fsType = dir.fsType
+		}
+	} else if len(fsType) == 0 {
+		fsType = DefaultLoopBackingFsType
+	}
+
+	if len(encryptionKey) != 0 {
+		supported, err := v.supportsEncryption(dir.path)
+		if err != nil {
+			return fmt.Errorf("can't check encryption support of volumes dir %q: %w", dir.path, err)
+		}
+
+		if !supported {
+			return fmt.Errorf("can't create encrypted volume in %q: %w", dir.path, EncryptionNotSupportedErr)
+		}
+	}
+
+	if extentSizeHint != 0 {
+		err = v.validateExtentSizeHint(dir, extentSizeHint)
+		if err != nil {
+			return err
+		}
+	}
+
+	path := filepath.Join(dir.path, volID)
+
+	klog.V(2).InfoS("Creating volume directory", "path", path)
+	err = v.retryFilesystemOperation(func() error {
+		return os.Mkdir(path, 0770)
+	})
+	if err != nil {
+		if !os.IsExist(err) {
+			return fmt.Errorf("can't create volume directory at %q: %w", path, err)
+		}
+
+		// Directory left empty by an interrupted creation can be reused, unknown data can't.
+		if existingVs == nil {
+			empty, err := isDirectoryEmpty(path)
+			if err != nil {
+				return fmt.Errorf("can't check whether existing volume directory %q is empty: %w", path, err)
+			}
+
+			if !empty {
+				return fmt.Errorf("can't create volume at %q: %w", path, VolumeDirectoryNotEmptyErr)
+			}
+		}
+	}
+
+	// Policy can be set only on an empty directory, so it's done before anything is created in it.
+	var encryptionKeyIdentifier string
+	if len(encryptionKey) != 0 {
+		encryptionKeyIdentifier, err = v.encryptDirectory(path, encryptionKey)
+		if err != nil {
+			errs := []error{
+				fmt.Errorf("can't encrypt volume directory: %w", err),
+			}
+
+			rmErr := v.removeDirectory(path)
+			if rmErr != nil {
+				errs = append(errs, fmt.Errorf("can't remove volume directory: %w", rmErr))
+			}
+
+			return errors.NewAggregate(errs)
+		}
+
+		klog.V(2).InfoS("Volume directory encrypted", "path", path, "keyIdentifier", encryptionKeyIdentifier)
+	}
+
+	// Hint is inherited only by files created after it's set.
+	if extentSizeHint != 0 {
+		err = v.setExtentSizeHint(path, extentSizeHint)
+		if err != nil {
+			errs := []error{
+				fmt.Errorf("can't set extent size hint of volume directory: %w", err),
+			}
+
+			rmErr := v.removeDirectory(path)
+			if rmErr != nil {
+				errs = append(errs, fmt.Errorf("can't remove volume directory: %w", rmErr))
+			}
+
+			return errors.NewAggregate(errs)
+		}
+
+		klog.V(2).InfoS("Volume directory extent size hint set", "path", path, "extentSizeHint", extentSizeHint)
+	}
+
+	limitID, err := v.newUniqueLimit(dir, volID, path)
+	if err != nil {
+		errs := []error{
+			fmt.Errorf("can't init new limit: %w", err),
+		}
+
+		rmErr := v.removeDirectory(path)
+		if rmErr != nil {
+			errs = append(errs, fmt.Errorf("can't remove volume directory: %w", rmErr))
+		}
+
+		return errors.NewAggregate(errs)
+	}
+
+	klog.V(2).InfoS("New limit initialized", "limitID", limitID, "path", path)
+
+	if inodeLimit == 0 && v.bytesPerInode > 0 {
+		inodeLimit = uint64(capacity / v.bytesPerInode)
+	}
+
+	// Data is copied only once the limit is set up, so it's accounted to the volume.
+	if populatingSrc != nil {
+		err = v.populateVolume(path, populatingSrc, volAccessType, capacity)
+		if err != nil {
+			errs := []error{
+				fmt.Errorf("can't populate volume from %s: %w", populatingSrc.description, err),
+			}
+
+			removeDirErr := v.removeVolumeDirectory(path)
+			if removeDirErr != nil {
+				errs = append(errs, fmt.Errorf("failed to remove volume directory: %w", removeDirErr))
+			}
+
+			removeLimitErr := dir.limiter.RemoveLimit(limitID)
+			if removeLimitErr != nil {
+				errs = append(errs, fmt.Errorf("failed to remove volume limit: %w", removeLimitErr))
+			}
+
+			return errors.NewAggregate(errs)
+		}
+	} else if volAccessType == BlockAccess || backingMode == LoopBacking {
+		// Backing file is created within the volume directory so it inherits the directory project quota.
+		blockFilePath := filepath.Join(path, blockFileName)
+		err = v.createBlockFile(blockFilePath, capacity)
+		if err == nil && backingMode == LoopBacking {
+			err = v.makeFilesystem(blockFilePath, fsType)
+		}
+		if err != nil {
+			errs := []error{
+				fmt.Errorf("can't create volume backing file: %w", err),
+			}
+
+			removeDirErr := v.removeVolumeDirectory(path)
+			if removeDirErr != nil {
+				errs = append(errs, fmt.Errorf("failed to remove volume directory: %w", removeDirErr))
+			}
+
+			removeLimitErr := dir.limiter.RemoveLimit(limitID)
+			if removeLimitErr != nil {
+				errs = append(errs, fmt.Errorf("failed to remove volume limit: %w", removeLimitErr))
+			}
+
+			return errors.NewAggregate(errs)
+		}
+	}
+
+	volumeState := &VolumeState{
+		Name:                    name,
+		ID:                      volID,
+		LimitID:                 limitID,
+		Size:                    capacity,
+		AccessType:              volAccessType,
+		InodeLimit:              inodeLimit,
+		EnforcementMode:         dir.limiter.EnforcementMode(),
+		BackingMode:             backingMode,
+		FsType:                  fsType,
+		VolumesDir:              dir.path,
+		VolumesDirID:            dir.id,
+		VolumeAttributes:        o.Attributes,
+		EncryptionKeyIdentifier: encryptionKeyIdentifier,
+		SourceSnapshotID:        source.SnapshotID,
+		SourceVolumeID:          source.VolumeID,
+		ExtentSizeHint:          extentSizeHint,
+		CreationTime:            v.now().UTC(),
+		NodeName:                v.nodeName,
+	}
+
+	err = v.state.SaveVolumeState(volumeState)
+	if err != nil {
+		errs := []error{
+			fmt.Errorf("failed to save volume state: %w", err),
+		}
+
+		removeDirErr := v.removeVolumeDirectory(path)
+		if removeDirErr != nil {
+			errs = append(errs, fmt.Errorf("failed to remove volume directory: %w", removeDirErr))
+		}
+
+		removeLimitErr := dir.limiter.RemoveLimit(limitID)
+		if removeLimitErr != nil {
+			errs = append(errs, fmt.Errorf("failed to remove volume limit: %w", removeLimitErr))
+		}
+
+		return errors.NewAggregate(errs)
+	}
+
+	err = dir.limiter.SetLimit(limitID, capacity, inodeLimit)
+	if err != nil {
+		errs := []error{
+			fmt.Errorf("failed to set volume limit: %w", err),
+		}
+
+		removeDirErr := v.removeVolumeDirectory(path)
+		if removeDirErr != nil {
+			errs = append(errs, fmt.Errorf("failed to remove volume directory: %w", removeDirErr))
+		}
+
+		removeLimitErr := dir.limiter.RemoveLimit(limitID)
+		if removeLimitErr != nil {
+			errs = append(errs, fmt.Errorf("failed to remove volume limit: %w", removeLimitErr))
+		}
+
+		removeStateFileErr := v.state.DeleteVolumeState(volID)
+		if removeStateFileErr != nil {
+			errs = append(errs, fmt.Errorf("failed to remove volume state file: %w", removeStateFileErr))
+		}
+
+		return errors.NewAggregate(errs)
+	}
+
+	v.logEffectiveCapacity(dir, volID, capacity)
+
+	return nil
+}
+
+// newUniqueLimit initializes a new limit of the volume directory which isn't used by any other volume.
+// Limiters never hand out limits in use, it guards against their bugs and limits allocated outside the driver.
+func (v *VolumeManager) newUniqueLimit(dir *volumesDirectory, volID, path string) (uint32, error) {
+	for attempt := 1; attempt <= maxLimitAllocationAttempts; attempt++ {
+		limitID, err := dir.limiter.NewLimit(path)
+		if err != nil {
+			return 0, err
+		}
+
+		// Zero limit is shared by all volumes which aren't limited.
+		if limitID == 0 {
+			return limitID, nil
+		}
+
+		// Every volumes dir has a limiter of its own, so only its volumes can collide.
+		vs := v.getVolumeStateByLimitID(dir, limitID)
+		if vs == nil || vs.ID == volID {
+			return limitID, nil
+		}
+
+		// The colliding limit belongs to the other volume, so it's left in place, only the new directory is
+		// detached from it.
+		klog.ErrorS(DuplicateLimitIDErr, "New limit collides with an existing volume", "limitID", limitID, "volume", vs.ID, "path", path, "attempt", attempt)
+		err = dir.limiter.DiscardLimit(limitID, path)
+		if err != nil {
+			return 0, fmt.Errorf("can't discard limit %d colliding with volume %q: %w", limitID, vs.ID, err)
+		}
+	}
+
+	return 0, fmt.Errorf("can't get a unique limit in %d attempts: %w", maxLimitAllocationAttempts, DuplicateLimitIDErr)
+}
+
+// getVolumeStateByLimitID returns the state of a volume in the volumes dir using the limit, or nil when none uses it.
+func (v *VolumeManager) getVolumeStateByLimitID(dir *volumesDirectory, limitID uint32) *VolumeState {
+	for _, vs := range v.getVolumesInDir(dir, v.state.GetVolumes()) {
+		if vs.LimitID == limitID {
+			return &vs
+		}
+	}
+
+	return nil
+}
+
+// validateExtentSizeHint rejects hints the filesystem of the volumes dir can't set. XFS requires the hint to be
+// a multiple of its block size, which differs between volumes dirs, so hints are rejected the same way as on other
+// filesystems.
+func (v *VolumeManager) validateExtentSizeHint(dir *volumesDirectory, extentSizeHint uint32) error {
+	if dir.fsType != "xfs" {
+		return fmt.Errorf("can't create volume with extent size hint in %q volumes dir: %w", dir.fsType, ExtentSizeHintNotSupportedErr)
+	}
+
+	var stat unix.Statfs_t
+	err := v.statfs(dir.path, &stat)
+	if err != nil {
+		return fmt.Errorf("can't check statfs of volumes dir %q: %w", dir.path, err)
+	}
+
+	if stat.Bsize > 0 && int64(extentSizeHint)%stat.Bsize != 0 {
+		return fmt.Errorf("can't create volume with extent size hint %d which isn't a multiple of %d block size of volumes dir %q: %w", extentSizeHint, stat.Bsize, dir.path, ExtentSizeHintNotSupportedErr)
+	}
+
+	return nil
+}
+
+// logEffectiveCapacity informs when the capacity isn't a multiple of filesystem block size,
+// as volume usage is accounted in whole blocks and the enforced limit slightly differs from the requested one.
+func (v *VolumeManager) logEffectiveCapacity(dir *volumesDirectory, volID string, capacity int64) {
+	if !klog.V(2).Enabled() {
+		return
+	}
+
+	var stat unix.Statfs_t
+	err := v.statfs(dir.path, &stat)
+	if err != nil {
+		klog.ErrorS(err, "Can't check statfs of volumes dir", "path", dir.path)
+		return
+	}
+
+	if stat.Bsize <= 0 || capacity%stat.Bsize == 0 {
+		return
+	}
+
+	klog.V(2).InfoS("Volume capacity isn't a multiple of filesystem block size", "volumeID", volID, "blockSize", stat.Bsize, "requestedCapacity", capacity, "effectiveCapacity", capacity/stat.Bsize*stat.Bsize)
+}
+
+// ExpandVolume grows the volume limit and persists the new volume size. Inode limit stays unchanged.
+func (v *VolumeManager) ExpandVolume(volID string, capacity int64) error {
+	vs := v.state.GetVolumeStateByID(volID)
+	if vs == nil {
+		return fmt.Errorf("volume %q doesn't exist", volID)
+	}
+
+	if capacity < vs.Size {
+		return fmt.Errorf("can't shrink volume %q from %dB to %dB", volID, vs.Size, capacity)
+	}
+
+	// Backing file and the loop device exposing it, and filesystem of a loop backed volume, would have to be grown
+	// on the node as well.
+	if vs.HasBackingFile() {
+		return fmt.Errorf("can't expand volume %q backed by a file", volID)
+	}
+
+	dir := v.getVolumesDirOf(vs)
+	if dir == nil {
+		return fmt.Errorf("volume %q is in volumes dir %q which isn't configured: %w", volID, vs.VolumesDir, VolumesDirUnavailableErr)
+	}
+
+	err := dir.limiter.SetLimit(vs.LimitID, capacity, vs.InodeLimit)
+	if err != nil {
+		return fmt.Errorf("can't set limit of volume %q: %w", volID, err)
+	}
+	klog.V(2).InfoS("Volume limit expanded", "volume", volID, "limitID", vs.LimitID, "oldCapacity", vs.Size, "capacity", capacity)
+
+	expandedVs := *vs
+	expandedVs.Size = capacity
+
+	err = v.state.SaveVolumeState(&expandedVs)
+	if err != nil {
+		errs := []error{
+			fmt.Errorf("failed to save volume state: %w", err),
+		}
+
+		restoreLimitErr := dir.limiter.SetLimit(vs.LimitID, vs.Size, vs.InodeLimit)
+		if restoreLimitErr != nil {
+			errs = append(errs, fmt.Errorf("failed to restore volume limit: %w", restoreLimitErr))
+		}
+
+		return errors.NewAggregate(errs)
+	}
+
+	return nil
+}
+
+// encryptDirectory adds the key to the filesystem of the empty directory and encrypts the directory with it.
+// It returns the identifier of the key.
+func (v *VolumeManager) encryptDirectory(path string, key []byte) (string, error) {
+	keyIdentifier, err := v.addEncryptionKey(path, key)
+	if err != nil {
+		return "", err
+	}
+
+	err = v.setEncryptionPolicy(path, keyIdentifier)
+	if err != nil {
+		return "", err
+	}
+
+	return keyIdentifier, nil
+}
+
+// ProvisionEncryptionKey adds the key of an encrypted volume to its filesystem, so its data can be accessed.
+// Key is added until its filesystem is unmounted, which unlocks the volume for every subsequent publish too.
+func (v *VolumeManager) ProvisionEncryptionKey(volID string, key []byte) error {
+	vs := v.state.GetVolumeStateByID(volID)
+	if vs == nil {
+		return fmt.Errorf("volume %q not found", volID)
+	}
+
+	if !vs.IsEncrypted() {
+		return fmt.Errorf("volume %q isn't encrypted", volID)
+	}
+
+	keyIdentifier, err := v.addEncryptionKey(vs.VolumePath(v.volumesDir), key)
+	if err != nil {
+		return fmt.Errorf("can't add encryption key of volume %q: %w", volID, err)
+	}
+
+	if keyIdentifier != vs.EncryptionKeyIdentifier {
+		return fmt.Errorf("can't provision encryption key of volume %q: %w", volID, EncryptionKeyMismatchErr)
+	}
+
+	return nil
+}
+
+func (v *VolumeManager) DeleteVolume(volID string) error {
+	// Volume directory is removed recursively, so the ID mustn't point anywhere else than into the volumes dir.
+	err := ValidateVolumeID(volID)
+	if err != nil {
+		return err
+	}
+
+	defer v.invalidateStatfsCache()
+
+	vs := v.state.GetVolumeStateByID(volID)
+
+	var dir *volumesDirectory
+	if vs != nil {
+		dir = v.getVolumesDirOf(vs)
+		if dir == nil {
+			return fmt.Errorf("volume %q is in volumes dir %q which isn't configured: %w", volID, vs.VolumesDir, VolumesDirUnavailableErr)
+		}
+	} else {
+		dir = v.findVolumesDirOfOrphan(volID)
+	}
+
+	path := filepath.Join(dir.path, volID)
+
+	if vs != nil && vs.HasBackingFile() {
+		blockFilePath := v.getBlockFilePath(volID)
+		_, err := os.Stat(blockFilePath)
+		if err == nil {
+			err = v.detachLoopDevices(blockFilePath)
+			if err != nil {
+				return fmt.Errorf("can't detach loop devices of volume %q: %w", volID, err)
+			}
+		} else if !os.IsNotExist(err) {
+			return fmt.Errorf("can't stat backing file of volume %q: %w", volID, err)
+		}
+	}
+
+	// Directory without a state file is orphaned, its limit has to be found on the directory itself.
+	// The limit is removed before the directory, so it isn't lost when the deletion is retried.
+	if vs == nil {
+		err := v.removeOrphanedLimit(dir, volID, path)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = v.removeVolumeDirectory(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("can't delete mount of volume %q at %q: %w", volID, path, err)
+	}
+	if err == nil {
+		klog.V(2).InfoS("Removed volume directory", "volume", volID, "path", path)
+	}
+
+	// Directory of a volume without a state, e.g. a quarantined one, is accounted for as untracked.
+	if vs == nil {
+		v.refreshUntrackedUsage(dir)
+	}
+
+	if vs != nil {
+		err = dir.limiter.RemoveLimit(vs.LimitID)
+		if err != nil {
+			return fmt.Errorf("can't remove limit of volume %q: %w", volID, err)
+		}
+		klog.V(2).InfoS("Removed limit", "limitID", vs.LimitID)
+	}
+
+	err = v.state.DeleteVolumeState(volID)
+	if err != nil {
+		return fmt.Errorf("can't delete state of volume %q: %w", volID, err)
+	}
+	klog.V(2).InfoS("Removed volume state file", "volume", volID)
+
+	return nil
+}
+
+// ForceDeleteStep is the outcome of a single step of a forced volume deletion.
+type ForceDeleteStep struct {
+	Name string `json:"name"`
+	// Skipped is set when there was nothing for the step to remove.
+	Skipped bool   `json:"skipped,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// ForceDeleteResult describes what a forced volume deletion removed.
+type ForceDeleteResult struct {
+	VolumeID string `json:"volumeID"`
+	// HadState is set when the volume had a state, otherwise its directory and limit were looked up as orphaned.
+	HadState bool              `json:"hadState"`
+	Steps    []ForceDeleteStep `json:"steps"`
+}
+
+// Succeeded returns whether all steps of the deletion succeeded.
+func (r *ForceDeleteResult) Succeeded() bool {
+	for _, s := range r.Steps {
+		if len(s.Error) != 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (r *ForceDeleteResult) addStep(name string, skipped bool, err error) {
+	step := ForceDeleteStep{
+		Name:    name,
+		Skipped: skipped,
+	}
+	if err != nil {
+		step.Error = err.Error()
+	}
+
+	r.Steps = append(r.Steps, step)
+}
+
+// ForceDeleteVolume removes everything left of the volume, its loop devices, limit, directory and state,
+// attempting every step even when the previous ones failed. It's meant for volumes whose regular deletion
+// can't complete, so unlike DeleteVolume it never stops at the first failure.
+func (v *VolumeManager) ForceDeleteVolume(volID string) (*ForceDeleteResult, error) {
+	err := ValidateVolumeID(volID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer v.invalidateStatfsCache()
+
+	result := &ForceDeleteResult{
+		VolumeID: volID,
+	}
+
+	vs := v.state.GetVolumeStateByID(volID)
+	result.HadState = vs != nil
+
+	var dir *volumesDirectory
+	var path string
+	var limitErr error
+	if vs != nil {
+		dir = v.getVolumesDirOf(vs)
+		path = vs.VolumePath(v.volumesDir)
+		if dir == nil {
+			limitErr = fmt.Errorf("volume is in volumes dir %q which isn't configured: %w", vs.VolumesDir, VolumesDirUnavailableErr)
+		}
+	} else {
+		dir = v.findVolumesDirOfOrphan(volID)
+		path = filepath.Join(dir.path, volID)
+	}
+
+	_, err = os.Stat(path)
+	dirExists := err == nil
+	if err != nil && !os.IsNotExist(err) {
+		klog.ErrorS(err, "Can't stat volume directory, attempting its removal anyway", "path", path)
+		dirExists = true
+	}
+
+	blockFilePath := filepath.Join(path, blockFileName)
+	_, err = os.Stat(blockFilePath)
+	switch {
+	case os.IsNotExist(err):
+		result.addStep("detachLoopDevices", true, nil)
+	case err != nil:
+		result.addStep("detachLoopDevices", false, fmt.Errorf("can't stat backing file %q: %w", blockFilePath, err))
+	default:
+		result.addStep("detachLoopDevices", false, v.detachLoopDevices(blockFilePath))
+	}
+
+	// Orphaned limit is found on the directory, so it's removed first.
+	switch {
+	case limitErr != nil:
+		result.addStep("removeLimit", false, limitErr)
+	case vs != nil:
+		result.addStep("removeLimit", false, dir.limiter.RemoveLimit(vs.LimitID))
+	case !dirExists:
+		result.addStep("removeLimit", true, nil)
+	default:
+		result.addStep("removeLimit", false, v.removeOrphanedLimit(dir, volID, path))
+	}
+
+	if dirExists {
+		result.addStep("removeDirectory", false, v.removeVolumeDirectory(path))
+		if vs == nil {
+			v.refreshUntrackedUsage(dir)
+		}
+	} else {
+		result.addStep("removeDirectory", true, nil)
+	}
+
+	if vs != nil {
+		result.addStep("removeState", false, v.state.DeleteVolumeState(volID))
+	} else {
+		result.addStep("removeState", true, nil)
+	}
+
+	return result, nil
+}
+
+func (v *VolumeManager) removeOrphanedLimit(dir *volumesDirectory, volID, path string) error {
+	_, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("can't stat directory of volume %q: %w", volID, err)
+	}
+
+	limitID, err := dir.limiter.GetLimitID(path)
+	if err != nil {
+		return fmt.Errorf("can't get limit of orphaned volume %q directory: %w", volID, err)
+	}
+	klog.V(2).InfoS("Found orphaned volume directory", "volume", volID, "path", path, "limitID", limitID)
+
+	if limitID == 0 {
+		return nil
+	}
+
+	err = dir.limiter.RemoveLimit(limitID)
+	if err != nil {
+		return fmt.Errorf("can't remove limit of orphaned volume %q: %w", volID, err)
+	}
+	klog.V(2).InfoS("Removed limit of orphaned volume", "volume", volID, "limitID", limitID)
+
+	return nil
+}
+
+// CapacityBreakdown holds the components the available capacity is computed from.
+type CapacityBreakdown struct {
+	// TotalBytes is the raw size of the filesystem.
+	TotalBytes int64 `json:"totalBytes"`
+	// ReservedBytes is the part of the filesystem excluded from provisioning,
+	// covering both the filesystem's own overhead and the capacity kept free for the host.
+	ReservedBytes int64 `json:"reservedBytes"`
+	// CommittedBytes is the sum of sizes of provisioned volumes, each rounded up to the filesystem block size.
+	CommittedBytes int64 `json:"committedBytes"`
+	// SnapshotBytes is the sum of sizes of snapshots, which are as big as their source volume was, each rounded up
+	// to the filesystem block size.
+	SnapshotBytes int64 `json:"snapshotBytes"`
+	// UsedBytes is taken by provisioned volumes on top of their sizes, like their state files.
+	UsedBytes int64 `json:"usedBytes"`
+	// PendingBytes is set aside for metadata of the next provisioned volume.
+	PendingBytes int64 `json:"pendingBytes"`
+	// ProvisioningBudgetBytes caps CommittedBytes regardless of the filesystem size, zero means there is no cap.
+	ProvisioningBudgetBytes int64 `json:"provisioningBudgetBytes,omitempty"`
+}
+
+// AvailableBytes returns the capacity a new volume can be provisioned with.
+// It's negative when the reservation exceeds the free space.
+func (b CapacityBreakdown) AvailableBytes() int64 {
+	available := b.TotalBytes - b.ReservedBytes - b.CommittedBytes - b.SnapshotBytes - b.UsedBytes - b.PendingBytes
+	if b.ProvisioningBudgetBytes > 0 {
+		available = min(available, b.RemainingProvisioningBudgetBytes())
+	}
+
+	return available
+}
+
+// RemainingProvisioningBudgetBytes returns how many bytes can still be provisioned within the provisioning budget.
+// It's meaningful only when the budget is set.
+func (b CapacityBreakdown) RemainingProvisioningBudgetBytes() int64 {
+	return b.ProvisioningBudgetBytes - b.CommittedBytes
+}
+
+// WithVolume returns the breakdown expected after a volume of the given size is provisioned.
+func (b CapacityBreakdown) WithVolume(size int64) CapacityBreakdown {
+	b.CommittedBytes += size
+	b.UsedBytes += MetadataFileMaxSize
+	return b
+}
+
+// GetCapacityBreakdown returns the capacity breakdown of the volumes dir with the most available capacity,
+// or the sum of breakdowns of all volumes dirs when the capacity policy is SumCapacityPolicy.
+func (v *VolumeManager) GetCapacityBreakdown() (CapacityBreakdown, error) {
+	return v.getCapacityBreakdown(v.state.GetVolumes(), v.state.GetSnapshots())
+}
+
+func (v *VolumeManager) getCapacityBreakdown(volumes []VolumeState, snapshots []SnapshotState) (CapacityBreakdown, error) {
+	var breakdown CapacityBreakdown
+	for i, d := range v.volumesDirs {
+		dirBreakdown, err := v.getVolumesDirCapacityBreakdown(d, volumes, snapshots)
+		if err != nil {
+			return CapacityBreakdown{}, err
+		}
+
+		switch {
+		case i == 0:
+			breakdown = dirBreakdown
+		case v.capacityPolicy == SumCapacityPolicy:
+			breakdown.TotalBytes += dirBreakdown.TotalBytes
+			breakdown.ReservedBytes += dirBreakdown.ReservedBytes
+			breakdown.CommittedBytes += dirBreakdown.CommittedBytes
+			breakdown.SnapshotBytes += dirBreakdown.SnapshotBytes
+			breakdown.UsedBytes += dirBreakdown.UsedBytes
+			breakdown.PendingBytes += dirBreakdown.PendingBytes
+		case dirBreakdown.AvailableBytes() > breakdown.AvailableBytes():
+			breakdown = dirBreakdown
+		}
+	}
+
+	// Budget of the sum is shared by all volumes, the same as with a single volumes dir.
+	if v.capacityPolicy == SumCapacityPolicy && v.maxTotalProvisionedBytes > 0 {
+		breakdown.ProvisioningBudgetBytes = v.provisioningBudgetOf(breakdown.CommittedBytes)
+	}
+
+	return breakdown, nil
+}
+
+// QuotaStats is the quota accounting of a volumes dir filesystem as reported by the kernel, together with limits
+// of the volumes recorded in their states. Mismatch between them means limits drifted from the states.
+type QuotaStats struct {
+	VolumesDir string `json:"volumesDir"`
+	// Kernel is nil when the filesystem doesn't account quotas.
+	Kernel *limit.QuotaStats `json:"kernel,omitempty"`
+	// LimitedVolumes is the number of volumes of the volumes dir having a limit.
+	LimitedVolumes int `json:"limitedVolumes"`
+	// LimitedVolumesBytes is the sum of sizes of volumes of the volumes dir having a limit.
+	LimitedVolumesBytes int64 `json:"limitedVolumesBytes"`
+}
+
+// GetQuotaStats returns quota statistics of every volumes dir, starting with the main one.
+func (v *VolumeManager) GetQuotaStats() ([]QuotaStats, error) {
+	volumes := v.state.GetVolumes()
+
+	var stats []QuotaStats
+	for _, d := range v.volumesDirs {
+		kernelStats, err := d.limiter.QuotaStats()
+		if err != nil {
+			return nil, fmt.Errorf("can't get quota statistics of volumes dir %q: %w", d.path, err)
+		}
+
+		dirStats := QuotaStats{
+			VolumesDir: d.path,
+			Kernel:     kernelStats,
+		}
+		for _, vs := range v.getVolumesInDir(d, volumes) {
+			if vs.LimitID == 0 {
+				continue
+			}
+
+			dirStats.LimitedVolumes++
+			dirStats.LimitedVolumesBytes += vs.Size
+		}
+
+		stats = append(stats, dirStats)
+	}
+
+	return stats, nil
+}
+
+// ExceedsProvisioningBudget returns whether provisioning a volume of the given size would make the sum of sizes
+// of all volumes exceed the provisioning budget, together with the remaining budget.
+func (v *VolumeManager) ExceedsProvisioningBudget(capacity int64) (bool, int64) {
+	if v.maxTotalProvisionedBytes == 0 {
+		return false, 0
+	}
+
+	remaining := v.maxTotalProvisionedBytes - v.state.GetTotalVolumesSize()
+	return capacity > remaining, max(0, remaining)
+}
+
+func (v *VolumeManager) GetAvailableCapacity() (int64, error) {
+	breakdown, err := v.GetCapacityBreakdown()
+	if err != nil {
+		return 0, err
+	}
+
+	// Reservation can exceed what's left once the host uses its reserve, which isn't an error on its own.
+	return max(0, breakdown.AvailableBytes()), nil
+}
+
+func (v *VolumeManager) GetVolumeStatistics(volumePath string) (*VolumeStatistics, error) {
+	statfs := &unix.Statfs_t{}
+	err := v.statfs(volumePath, statfs)
+	if err != nil {
+		err = fmt.Errorf("can't get statfs on path %q: %w", volumePath, err)
+		return nil, err
+	}
+
+	return &VolumeStatistics{
+		AvailableBytes:  int64(statfs.Bavail) * statfs.Bsize,
+		TotalBytes:      int64(statfs.Blocks) * statfs.Bsize,
+		UsedBytes:       (int64(statfs.Blocks) - int64(statfs.Bfree)) * statfs.Bsize,
+		AvailableInodes: int64(statfs.Ffree),
+		TotalInodes:     int64(statfs.Files),
+		UsedInodes:      int64(statfs.Files) - int64(statfs.Ffree),
+	}, nil
+}
+
+// Stage bind mounts the volume directory at stagingPath, loop backed volumes have their filesystem
+// mounted there instead, using mountOptions. Staging an already staged volume is a no-op.
+func (v *VolumeManager) Stage(volumeID, stagingPath string, mountOptions []string) error {
+	err := v.checkVolumesDirAvailable(volumeID)
+	if err != nil {
+		return err
+	}
+
+	path := v.getVolumePath(volumeID)
+
+	err = v.retryFilesystemOperation(func() error {
+		return os.MkdirAll(stagingPath, 0770)
+	})
+	if err != nil && !os.IsExist(err) {
+		return fmt.Errorf("can't create staging path at %q: %w", stagingPath, err)
+	}
+
+	notMountPoint, err := v.mounter.IsLikelyNotMountPoint(stagingPath)
+	if err != nil {
+		return fmt.Errorf("can't check whether staging path %q is a mount point: %w", stagingPath, err)
+	}
+
+	if !notMountPoint {
+		klog.V(4).InfoS("Volume is already staged", "volumeID", volumeID, "stagingPath", stagingPath)
+		return nil
+	}
+
+	vs := v.state.GetVolumeStateByID(volumeID)
+	if vs != nil && vs.IsLoopBacked() {
+		blockFilePath := v.getBlockFilePath(volumeID)
+		device, err := v.attachLoopDevice(blockFilePath)
+		if err != nil {
+			return fmt.Errorf("can't attach loop device to %q: %w", blockFilePath, err)
+		}
+		klog.V(2).InfoS("Loop device attached", "volume", volumeID, "device", device, "path", blockFilePath)
+
+		klog.V(2).InfoS("Staging loop backed volume", "device", device, "fsType", vs.FsType, "stagingPath", stagingPath)
+		err = v.mount(device, stagingPath, vs.FsType, mountOptions)
+		if err != nil {
+			return fmt.Errorf("can't mount device %q at %q: %w", device, stagingPath, err)
+		}
+
+		return nil
+	}
+
+	klog.V(2).InfoS("Staging volume directory", "path", path, "stagingPath", stagingPath)
+	err = v.mount(path, stagingPath, "", []string{"bind"})
+	if err != nil {
+		return fmt.Errorf("can't mount %q at %q: %w", path, stagingPath, err)
+	}
+
+	return nil
+}
+
+// Unstage unmounts the staging path. Unstaging a volume which isn't staged is a no-op.
+func (v *VolumeManager) Unstage(stagingPath string) error {
+	notMountPoint, err := v.mounter.IsLikelyNotMountPoint(stagingPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("can't check whether staging path %q is a mount point: %w", stagingPath, err)
+	}
+
+	if !notMountPoint {
+		err = v.unmount(stagingPath)
+		if err != nil {
+			return fmt.Errorf("failed to unmount staging path at %q: %w", stagingPath, err)
+		}
+	}
+
+	err = v.removeDirectory(stagingPath)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove staging path at %q: %w", stagingPath, err)
+	}
+
+	return nil
+}
+
+// Publish bind mounts the staged volume at targetPath. Publishing a volume which is already published is a no-op.
+func (v *VolumeManager) Publish(stagingPath, targetPath, fsType string, mountOptions []string) error {
+	err := v.retryFilesystemOperation(func() error {
+		return os.MkdirAll(targetPath, 0770)
+	})
+	if err != nil && !os.IsExist(err) {
+		return fmt.Errorf("can't create target path at %q: %w", targetPath, err)
+	}
+
+	published, err := v.isBindMountedFrom(stagingPath, targetPath)
+	if err != nil {
+		return err
+	}
+
+	if published {
+		klog.V(4).InfoS("Volume is already published", "stagingPath", stagingPath, "targetPath", targetPath)
+		return nil
+	}
+
+	klog.V(2).InfoS("Mounting staged volume", "stagingPath", stagingPath, "targetPath", targetPath)
+	err = v.mount(stagingPath, targetPath, fsType, mountOptions)
+	if err != nil {
+		return fmt.Errorf("can't mount %q at %q: %w", stagingPath, targetPath, err)
+	}
+
+	return nil
+}
+
+// PublishVolumeDirectory bind mounts the volume directory at targetPath, for volumes which aren't staged.
+func (v *VolumeManager) PublishVolumeDirectory(volumeID, targetPath string, mountOptions []string) error {
+	err := v.checkVolumesDirAvailable(volumeID)
+	if err != nil {
+		return err
+	}
+
+	path := v.getVolumePath(volumeID)
+
+	err = v.retryFilesystemOperation(func() error {
+		return os.MkdirAll(targetPath, 0770)
+	})
+	if err != nil && !os.IsExist(err) {
+		return fmt.Errorf("can't create target path at %q: %w", targetPath, err)
+	}
+
+	// The volume directory isn't a mount point of its own, so the target can't be checked for being mounted from it.
+	notMountPoint, err := v.mounter.IsLikelyNotMountPoint(targetPath)
+	if err != nil {
+		return fmt.Errorf("can't check whether target path %q is a mount point: %w", targetPath, err)
+	}
+
+	if !notMountPoint {
+		klog.V(4).InfoS("Volume is already published", "volumeID", volumeID, "targetPath", targetPath)
+		return nil
+	}
+
+	klog.V(2).InfoS("Mounting volume directory", "path", path, "targetPath", targetPath)
+	err = v.mount(path, targetPath, "", mountOptions)
+	if err != nil {
+		return fmt.Errorf("can't mount %q at %q: %w", path, targetPath, err)
+	}
+
+	return nil
+}
+
+// PublishBlockVolume exposes the block volume backing file as a loop device bind mounted at targetPath.
+func (v *VolumeManager) PublishBlockVolume(volumeID, targetPath string, mountOptions []string) error {
+	vs := v.state.GetVolumeStateByID(volumeID)
+	if vs == nil {
+		return fmt.Errorf("volume %q doesn't exist", volumeID)
+	}
+
+	if vs.AccessType != BlockAccess {
+		return fmt.Errorf("volume %q isn't a block volume", volumeID)
+	}
+
+	blockFilePath := v.getBlockFilePath(volumeID)
+	device, err := v.attachLoopDevice(blockFilePath)
+	if err != nil {
+		return fmt.Errorf("can't attach loop device to %q: %w", blockFilePath, err)
+	}
+	klog.V(2).InfoS("Loop device attached", "volume", volumeID, "device", device, "path", blockFilePath)
+
+	err = v.retryFilesystemOperation(func() error {
+		return os.MkdirAll(filepath.Dir(targetPath), 0770)
+	})
+	if err != nil && !os.IsExist(err) {
+		return fmt.Errorf("can't create parent directory of target path %q: %w", targetPath, err)
+	}
+
+	// Source of a bind mounted device can't be told from the mount table, any mount at the target is considered ours.
+	isMountPoint, err := v.mounter.IsMountPoint(targetPath)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("can't check whether target path %q is a mount point: %w", targetPath, err)
+	}
+
+	if isMountPoint {
+		klog.V(4).InfoS("Block volume is already published", "volume", volumeID, "targetPath", targetPath)
+		return nil
+	}
+
+	// Bind mount of a device requires a file as the mount point.
+	f, err := os.OpenFile(targetPath, os.O_CREATE|os.O_RDWR, 0660)
+	if err != nil {
+		return fmt.Errorf("can't create target file at %q: %w", targetPath, err)
+	}
+	err = f.Close()
+	if err != nil {
+		return fmt.Errorf("can't close target file at %q: %w", targetPath, err)
+	}
+
+	klog.V(2).InfoS("Mounting block device", "device", device, "targetPath", targetPath)
+	err = v.mount(device, targetPath, "", mountOptions)
+	if err != nil {
+		return fmt.Errorf("can't mount device %q at %q: %w", device, targetPath, err)
+	}
+
+	return nil
+}
+
+// Unmount unmounts and removes the target path. Unmounting a target which isn't mounted is a no-op.
+func (v *VolumeManager) Unmount(targetPath string) error {
+	isMountPoint, err := v.mounter.IsMountPoint(targetPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("can't check whether target path %q is a mount point: %w", targetPath, err)
+	}
+
+	if isMountPoint {
+		err = v.unmount(targetPath)
+		if err != nil {
+			return fmt.Errorf("failed to unmount target path at %q: %w", targetPath, err)
+		}
+	}
+
+	err = v.removeDirectory(targetPath)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove target path at %q: %w", targetPath, err)
+	}
+
+	return nil
+}
+
+// kubeletVolumeData is the part of metadata kubelet stores next to every CSI volume mount of a pod.
+type kubeletVolumeData struct {
+	DriverName   string `json:"driverName"`
+	VolumeHandle string `json:"volumeHandle"`
+}
+
+// podMount is a mount of a volume published by the driver to a pod.
+type podMount struct {
+	volumeID   string
+	targetPath string
+}
+
+// listPodMounts returns mounts under the kubelet pods dir of volumes published by the driver. Mounts whose volume
+// metadata can't be read are left out and their errors are returned together.
+func (v *VolumeManager) listPodMounts(podsDir, driverName string) ([]podMount, error) {
+	mountPoints, err := v.mounter.List()
+	if err != nil {
+		return nil, fmt.Errorf("can't list mount points: %w", err)
+	}
+
+	podsDir = filepath.Clean(podsDir) + string(filepath.Separator)
+
+	var errs []error
+	var mounts []podMount
+	for _, mp := range mountPoints {
+		if !strings.HasPrefix(mp.Path, podsDir) {
+			continue
+		}
+
+		// Kubelet stores volume metadata next to the mount directory of a CSI volume.
+		volDataPath := filepath.Join(filepath.Dir(mp.Path), "vol_data.json")
+		data, err := os.ReadFile(volDataPath)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				errs = append(errs, fmt.Errorf("can't read volume data %q: %w", volDataPath, err))
+			}
+			continue
+		}
+
+		volData := kubeletVolumeData{}
+		err = json.Unmarshal(data, &volData)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("can't parse volume data %q: %w", volDataPath, err))
+			continue
+		}
+
+		if volData.DriverName != driverName {
+			continue
+		}
+
+		mounts = append(mounts, podMount{
+			volumeID:   volData.VolumeHandle,
+			targetPath: mp.Path,
+		})
+	}
+
+	return mounts, errors.NewAggregate(errs)
+}
+
+// SweepOrphanedMounts lazily unmounts mounts under the kubelet pods dir of volumes published by the driver,
+// which no longer exist. They are left behind when pods are force deleted. Mounts of volumes with quarantined states
+// are kept. It returns the number of swept mounts.
+func (v *VolumeManager) SweepOrphanedMounts(podsDir, driverName string) (int, error) {
+	mounts, err := v.listPodMounts(podsDir, driverName)
+	var errs []error
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	// Volumes whose states couldn't be read still exist, so their mounts are still in use.
+	quarantinedIDs := v.state.GetQuarantinedVolumeIDs()
+
+	swept := 0
+	for _, m := range mounts {
+		if v.state.GetVolumeStateByID(m.volumeID) != nil {
+			continue
+		}
+
+		if slices.Contains(quarantinedIDs, m.volumeID) {
+			klog.V(2).InfoS("Skipping mount of volume with quarantined state", "volumeID", m.volumeID, "targetPath", m.targetPath)
+			continue
+		}
+
+		klog.V(2).InfoS("Sweeping orphaned mount of unknown volume", "volumeID", m.volumeID, "targetPath", m.targetPath)
+		err = v.observeUnmount(func() error {
+			return v.lazyUnmount(m.targetPath)
+		})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("can't unmount orphaned mount %q: %w", m.targetPath, err))
+			continue
+		}
+		swept++
+	}
+
+	return swept, errors.NewAggregate(errs)
+}
+
+// GetPublishedTargetPaths returns target paths under the kubelet pods dir existing volumes are published to, keyed
+// by volume IDs. Target paths whose volume metadata can't be read are left out and their errors are returned together.
+func (v *VolumeManager) GetPublishedTargetPaths(podsDir, driverName string) (map[string][]string, error) {
+	mounts, err := v.listPodMounts(podsDir, driverName)
+
+	targetPaths := map[string][]string{}
+	for _, m := range mounts {
+		if v.state.GetVolumeStateByID(m.volumeID) == nil {
+			continue
+		}
+
+		targetPaths[m.volumeID] = append(targetPaths[m.volumeID], m.targetPath)
+	}
+
+	return targetPaths, err
+}
+
+// GetVolumeIOStats returns IO statistics of the loop device exposing the volume backing file. Directory backed
+// volumes share the device of the volumes dir, so nil is returned for them, as well as when no device is attached.
+func (v *VolumeManager) GetVolumeIOStats(volumeID string) (*fs.IOStats, error) {
+	vs := v.state.GetVolumeStateByID(volumeID)
+	if vs == nil {
+		return nil, fmt.Errorf("volume %q doesn't exist", volumeID)
+	}
+
+	if !vs.HasBackingFile() {
+		return nil, nil
+	}
+
+	loopDevices, err := v.listLoopDevices()
+	if err != nil {
+		return nil, fmt.Errorf("can't list loop devices: %w", err)
+	}
+
+	return v.getVolumeIOStats(vs, loopDevices)
+}
+
+// GetVolumesIOStats returns IO statistics of all volumes exposed through loop devices, keyed by their IDs. Loop
+// devices are listed only once, so it's meant for collecting statistics of every volume at once, like on a scrape.
+// Statistics of volumes which couldn't be read are left out and their errors are returned together.
+func (v *VolumeManager) GetVolumesIOStats() (map[string]fs.IOStats, error) {
+	loopDevices, err := v.listLoopDevices()
+	if err != nil {
+		return nil, fmt.Errorf("can't list loop devices: %w", err)
+	}
+
+	var errs []error
+	volumesStats := map[string]fs.IOStats{}
+	for _, vs := range v.state.GetVolumes() {
+		if !vs.HasBackingFile() {
+			continue
+		}
+
+		stats, err := v.getVolumeIOStats(&vs, loopDevices)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		if stats != nil {
+			volumesStats[vs.ID] = *stats
+		}
+	}
+
+	return volumesStats, errors.NewAggregate(errs)
+}
+
+func (v *VolumeManager) getVolumeIOStats(vs *VolumeState, loopDevices map[string][]string) (*fs.IOStats, error) {
+	devices := loopDevices[v.getBlockFilePath(vs.ID)]
+	if len(devices) == 0 {
+		return nil, nil
+	}
+
+	stats, err := v.getDeviceIOStats(devices[0])
+	if err != nil {
+		return nil, fmt.Errorf("can't get IO statistics of volume %q: %w", vs.ID, err)
+	}
+
+	return &stats, nil
+}
+
+// GetVolumeExtentStats returns how fragmented files of the volume directory are. The extent map of every file
+// is read, so it's meant for diagnostics only.
+func (v *VolumeManager) GetVolumeExtentStats(volumeID string) (*fs.ExtentStats, error) {
+	vs := v.state.GetVolumeStateByID(volumeID)
+	if vs == nil {
+		return nil, fmt.Errorf("volume %q doesn't exist", volumeID)
+	}
+
+	path := vs.VolumePath(v.volumesDir)
+	stats, err := v.getExtentStats(path)
+	if err != nil {
+		if fs.IsExtentMappingUnsupportedError(err) {
+			return nil, fmt.Errorf("can't get extents of volume %q: %w", volumeID, ExtentMappingNotSupportedErr)
+		}
+		return nil, fmt.Errorf("can't get extents of volume %q: %w", volumeID, err)
+	}
+
+	return &stats, nil
+}
+
+// HasUnrestoredLimit returns whether the limit of the volume couldn't be restored on startup, so it isn't enforced.
+func (v *VolumeManager) HasUnrestoredLimit(volID string) bool {
+	_, ok := v.unrestoredLimits[volID]
+	return ok
+}
+
+// CheckLimiter verifies limiters can read limits of volumes dir filesystems.
+func (v *VolumeManager) CheckLimiter() error {
+	for _, d := range v.volumesDirs {
+		_, err := d.limiter.GetLimitID(d.path)
+		if err != nil {
+			return fmt.Errorf("can't get limit ID of volumes dir %q: %w", d.path, err)
+		}
+	}
+
+	return nil
+}
+
+// Probe verifies that volumes dirs are writable by writing and removing a probe file in each of them,
+// and that statistics of their filesystems are plausible.
+func (v *VolumeManager) Probe() error {
+	for _, d := range v.volumesDirs {
+		err := probeDirectory(d.path)
+		if err != nil {
+			return err
+		}
+
+		// Writable volumes dir is still unusable when its capacity can't be computed.
+		_, err = v.statVolumesDir(d)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func probeDirectory(dir string) (err error) {
+	probePath := filepath.Join(dir, probeFileName)
+
+	f, err := os.Create(probePath)
+	if err != nil {
+		return fmt.Errorf("can't create probe file %q: %w", probePath, err)
+	}
+
+	defer func() {
+		removeErr := os.Remove(probePath)
+		if removeErr != nil {
+			err = errors.NewAggregate([]error{err, fmt.Errorf("can't remove probe file %q: %w", probePath, removeErr)})
+		}
+	}()
+
+	_, err = f.Write([]byte(probeFileName))
+	if err == nil {
+		err = f.Sync()
+	}
+
+	closeErr := f.Close()
+	if err != nil || closeErr != nil {
+		return fmt.Errorf("can't write probe file %q: %w", probePath, errors.NewAggregate([]error{err, closeErr}))
+	}
+
+	return nil
+}
+
+func (v *VolumeManager) SupportedAccessTypes() []AccessType {
+	return []AccessType{MountAccess, BlockAccess}
+}
+
+func (v *VolumeManager) SupportedFilesystems() []string {
+	return []string{"", "xfs", "ext4"}
+}
+
+func (v *VolumeManager) GetVolumeStateByID(id string) *VolumeState {
+	return v.state.GetVolumeStateByID(id)
+}
+
+// GetVolumesOfOtherNodes returns volumes which were created on a node of a different name than the current one.
+// Their topology follows the current node name, so it no longer matches the node they were provisioned for.
+func (v *VolumeManager) GetVolumesOfOtherNodes() []VolumeState {
+	if len(v.nodeName) == 0 {
+		return nil
+	}
+
+	var volumes []VolumeState
+	for _, vs := range v.state.GetVolumes() {
+		// Node name wasn't recorded for volumes created by older versions.
+		if len(vs.NodeName) != 0 && vs.NodeName != v.nodeName {
+			volumes = append(volumes, vs)
+		}
+	}
+
+	return volumes
+}
+
+func (v *VolumeManager) GetVolumeStateByName(name string) *VolumeState {
+	return v.state.GetVolumeStateByName(name)
+}
+
+// SetMountObserver makes the observer notified about every following mount and unmount.
+// It has to be set before the volume manager is used.
+func (v *VolumeManager) SetMountObserver(observer MountObserver) {
+	v.mountObserver = observer
+}
+
+func (v *VolumeManager) VolumesDir() string {
+	return v.volumesDir
+}
+
+func (v *VolumeManager) GetVolumes() []VolumeState {
+	return v.state.GetVolumes()
+}
+
+func (v *VolumeManager) GetTotalVolumesSize() int64 {
+	return v.state.GetTotalVolumesSize()
+}
+
+// Close releases resources held by the volume manager.
+func (v *VolumeManager) Close() error {
+	var errs []error
+	for _, d := range v.volumesDirs {
+		err := d.limiter.Close()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("can't close limiter of volumes dir %q: %w", d.path, err))
+		}
+	}
+
+	return errors.NewAggregate(errs)
+}
+
+func (v *VolumeManager) retryFilesystemOperation(fn func() error) error {
+	return fs.RetryOnTransientError(v.fsRetryBackoff, fn)
+}
+
+// isBindMountedFrom returns whether target is already a bind mount of source.
+// It fails when target is a mount point of anything else.
+// IsLikelyNotMountPoint can't be used, bind mounts within the same filesystem aren't detected by it.
+func (v *VolumeManager) isBindMountedFrom(source, target string) (bool, error) {
+	isMountPoint, err := v.mounter.IsMountPoint(target)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("can't check whether %q is a mount point: %w", target, err)
+	}
+
+	if !isMountPoint {
+		return false, nil
+	}
+
+	refs, err := v.mounter.GetMountRefs(target)
+	if err != nil {
+		return false, fmt.Errorf("can't get mount references of %q: %w", target, err)
+	}
+
+	if !slices.Contains(refs, source) {
+		return false, fmt.Errorf("%q is already mounted from a different source than %q", target, source)
+	}
+
+	return true, nil
+}
+
+// mount mounts source at target, reporting how long it took to the mount observer.
+// Read-only bind mounts are verified to be read-only, so the volume can't be written to through them.
+func (v *VolumeManager) mount(source, target, fsType string, options []string) error {
+	start := time.Now()
+	err := v.mounter.Mount(source, target, fsType, options)
+	v.mountObserver.ObserveMount(time.Since(start), err)
+	if err != nil {
+		return err
+	}
+
+	if slices.Contains(options, "bind") && slices.Contains(options, "ro") {
+		err = v.ensureReadOnly(target)
+		if err != nil {
+			// Writable mount mustn't be left behind for the caller to consider published on retry.
+			errs := []error{err}
+
+			umountErr := v.unmount(target)
+			if umountErr != nil {
+				errs = append(errs, fmt.Errorf("can't unmount %q: %w", target, umountErr))
+			}
+
+			return errors.NewAggregate(errs)
+		}
+	}
+
+	return nil
+}
+
+// ensureReadOnly remounts the bind mount at target read-only unless it already is, as a bind mount
+// inherits the flags of its source until it's remounted with its own.
+func (v *VolumeManager) ensureReadOnly(target string) error {
+	mountPoints, err := v.mounter.List()
+	if err != nil {
+		return fmt.Errorf("can't list mount points: %w", err)
+	}
+
+	// Mount table lists targets with symlinks resolved.
+	path, err := filepath.EvalSymlinks(target)
+	if err != nil {
+		path = target
+	}
+
+	// Mounts stacked over each other are listed in the order they were mounted, the last one is visible.
+	var opts []string
+	found := false
+	for _, mp := range mountPoints {
+		if mp.Path == path {
+			opts = mp.Opts
+			found = true
+		}
+	}
+	if !found {
+		return fmt.Errorf("can't find mount point %q", target)
+	}
+
+	if slices.Contains(opts, "ro") {
+		return nil
+	}
+
+	klog.V(2).InfoS("Remounting bind mount read-only", "target", target)
+	err = v.remountReadOnly(target)
+	if err != nil {
+		return err
+	}
+
+	return nil
 }
 
+// observeUnmount runs a single unmount, reporting how long it took to the mount observer.
+func (v *VolumeManager) observeUnmount(unmount func() error) error {
+	start := time.Now()
+	err := unmount()
+	v.mountObserver.ObserveUnmount(time.Since(start), err)
+
+	return err
+}
+
+// unmount retries unmounting while the mount is busy, which happens when a process is still
+// finishing its work on a volume during pod teardown.
+func (v *VolumeManager) unmount(target string) error {
+	backoff := v.unmountRetryBackoff
+	for {
+		err := v.observeUnmount(func() error {
+			return v.mounter.Unmount(target)
+		})
+		if err == nil || !fs.IsBusyError(err) {
+			return err
+		}
+
+		if backoff.Steps <= 1 {
+			if !v.lazyUnmountEnabled {
+				return err
+			}
+
+			klog.InfoS("Mount is still busy, unmounting lazily", "target", target, "error", err)
+			lazyErr := v.observeUnmount(func() error {
+				return v.lazyUnmount(target)
+			})
+			if lazyErr != nil {
+				return errors.NewAggregate([]error{err, lazyErr})
+			}
+			return nil
+		}
+
+		delay := backoff.Step()
+		klog.V(4).InfoS("Retrying busy unmount", "target", target, "error", err, "delay", delay)
+		time.Sleep(delay)
+	}
+}
+
+func (v *VolumeManager) removeDirectory(path string) error {
+	return v.retryFilesystemOperation(func() error {
+		return os.Remove(path)
+	})
+}
+
+// removeVolumeDirectory removes the volume directory together with its content.
+func (v *VolumeManager) removeVolumeDirectory(path string) error {
+	return v.retryFilesystemOperation(func() error {
+		return os.RemoveAll(path)
+	})
+}
+
+func (v *VolumeManager) createBlockFile(blockFilePath string, capacity int64) error {
+	f, err := os.OpenFile(blockFilePath, os.O_CREATE|os.O_EXCL|os.O_RDWR, 0660)
+	if err != nil {
+		return fmt.Errorf("can't create file %q: %w", blockFilePath, err)
+	}
+
+	// Truncate makes a sparse file, blocks are allocated only when written.
+	err = f.Truncate(capacity)
+	closeErr := f.Close()
+	if err != nil || closeErr != nil {
+		return fmt.Errorf("can't resize file %q to %dB: %w", blockFilePath, capacity, errors.NewAggregate([]error{err, closeErr}))
+	}
+	klog.V(2).InfoS("Created block volume backing file", "path", blockFilePath, "size", capacity)
+
+	return nil
+}
+
+// getBlockFilesOverallocation returns how many bytes volume backing files allocated on top of their size.
+// Sizes are already accounted for, but filesystems may need extra blocks for mapping extents of large files.
+func (v *VolumeManager) getBlockFilesOverallocation(volumes []VolumeState) int64 {
+	var overallocation int64
+	for _, vs := range volumes {
+		if !vs.HasBackingFile() {
+			continue
+		}
+
+		blockFilePath := filepath.Join(vs.VolumePath(v.volumesDir), blockFileName)
+		var stat unix.Stat_t
+		err := unix.Stat(blockFilePath, &stat)
+		if err != nil {
+			klog.ErrorS(err, "Can't stat volume backing file", "path", blockFilePath)
+			continue
+		}
+
+		// Stat blocks are always 512B units.
+		allocated := stat.Blocks * 512
+		if allocated > vs.Size {
+			overallocation += allocated - vs.Size
+		}
+	}
+
+	return overallocation
+}
+
+func (v *VolumeManager) getBlockFilePath(volID string) string {
+	return filepath.Join(v.getVolumePath(volID), blockFileName)
+}
+
+// ValidateVolumeID rejects volume IDs which would resolve to a path outside the volumes dir when joined with it.
+func ValidateVolumeID(volID string) error {
+	if len(volID) == 0 {
+		return fmt.Errorf("volume ID can't be empty")
+	}
+
+	if volID == "." || volID == ".." {
+		return fmt.Errorf("volume ID %q can't be a dot segment", volID)
+	}
+
+	if strings.ContainsAny(volID, "/\\\x00") {
+		return fmt.Errorf("volume ID %q can't contain path separators or NUL characters", volID)
+	}
+
+	return nil
+}
+
+// getVolumePath returns the path of the volume directory within the volumes dir the volume lives in.
+// Volumes without a state are looked up in all volumes dirs.
 func (v *VolumeManager) getVolumePath(volID string) string {
-	return filepath.Join(v.volumesDir, volID)
+	vs := v.state.GetVolumeStateByID(volID)
+	if vs != nil {
+		return vs.VolumePath(v.volumesDir)
+	}
+
+	return filepath.Join(v.findVolumesDirOfOrphan(volID).path, volID)
+}
+
+func isDirectoryEmpty(path string) (bool, error) {
+	d, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		closeErr := d.Close()
+		if closeErr != nil {
+			klog.ErrorS(closeErr, "Failed to close directory", "directory", path)
+		}
+	}()
+
+	_, err = d.Readdirnames(1)
+	if err == io.EOF {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return false, nil
+}
+
+// setExtentSizeHint sets the XFS extent size hint of the directory, inherited by files created in it.
+func setExtentSizeHint(dir string, extentSize uint32) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("can't open directory %q: %w", dir, err)
+	}
+	defer func() {
+		closeErr := d.Close()
+		if closeErr != nil {
+			klog.ErrorS(closeErr, "Failed to close directory", "directory", dir)
+		}
+	}()
+
+	return fxattrs.SetExtentSizeHint(d, extentSize)
 }
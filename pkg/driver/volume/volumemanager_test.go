@@ -0,0 +1,2343 @@
+// Copyright (c) 2023 ScyllaDB.
+
+package volume
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/scylladb/local-csi-driver/pkg/driver/limit"
+	"github.com/scylladb/local-csi-driver/pkg/util/fs"
+	"github.com/scylladb/local-csi-driver/pkg/util/slices"
+	"golang.org/x/sys/unix"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/mount-utils"
+)
+
+type fakeLimiter struct {
+	limit.NoopLimiter
+
+	closeCalls     int
+	byteLimits     map[uint32]int64
+	inodeLimits    map[uint32]uint64
+	directoryLimit map[string]uint32
+	removedLimits  []uint32
+	// discardedLimits are limit IDs passed to DiscardLimit, keyed by the directory.
+	discardedLimits map[string][]uint32
+	limitIDs        []uint32
+	// newLimitIDs are handed out by NewLimit in order.
+	newLimitIDs []uint32
+	// setLimitErrs are returned by SetLimit in order, before it starts succeeding.
+	setLimitErrs []error
+	// removeLimitErr is returned by every RemoveLimit call.
+	removeLimitErr error
+
+	enforcementMode limit.EnforcementMode
+	quotaStats      *limit.QuotaStats
+}
+
+func (l *fakeLimiter) NewLimit(directory string) (uint32, error) {
+	if len(l.newLimitIDs) == 0 {
+		return 0, nil
+	}
+
+	limitID := l.newLimitIDs[0]
+	l.newLimitIDs = l.newLimitIDs[1:]
+	return limitID, nil
+}
+
+func (l *fakeLimiter) ListLimitIDs() ([]uint32, error) {
+	return l.limitIDs, nil
+}
+
+func (l *fakeLimiter) QuotaStats() (*limit.QuotaStats, error) {
+	return l.quotaStats, nil
+}
+
+func (l *fakeLimiter) EnforcementMode() limit.EnforcementMode {
+	if len(l.enforcementMode) == 0 {
+		return limit.HardEnforcement
+	}
+
+	return l.enforcementMode
+}
+
+func (l *fakeLimiter) GetLimitID(directory string) (uint32, error) {
+	return l.directoryLimit[directory], nil
+}
+
+func (l *fakeLimiter) RemoveLimit(limitID uint32) error {
+	if l.removeLimitErr != nil {
+		return l.removeLimitErr
+	}
+
+	l.removedLimits = append(l.removedLimits, limitID)
+	return nil
+}
+
+func (l *fakeLimiter) DiscardLimit(limitID uint32, directory string) error {
+	if l.discardedLimits == nil {
+		l.discardedLimits = map[string][]uint32{}
+	}
+	l.discardedLimits[directory] = append(l.discardedLimits[directory], limitID)
+	return nil
+}
+
+func (l *fakeLimiter) SetLimit(limitID uint32, capacityBytes int64, inodeLimit uint64) error {
+	if len(l.setLimitErrs) != 0 {
+		err := l.setLimitErrs[0]
+		l.setLimitErrs = l.setLimitErrs[1:]
+		return err
+	}
+
+	if l.byteLimits == nil {
+		l.byteLimits = map[uint32]int64{}
+	}
+	l.byteLimits[limitID] = capacityBytes
+
+	if l.inodeLimits == nil {
+		l.inodeLimits = map[uint32]uint64{}
+	}
+	l.inodeLimits[limitID] = inodeLimit
+	return nil
+}
+
+func (l *fakeLimiter) Close() error {
+	l.closeCalls++
+	return nil
+}
+
+func newTestVolumeManager(t *testing.T, options ...VolumeManagerOption) *VolumeManager {
+	t.Helper()
+
+	volumesDir := t.TempDir()
+
+	sm, err := NewStateManager(volumesDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	options = append([]VolumeManagerOption{WithMounter(mount.NewFakeMounter(nil))}, options...)
+	vm, err := NewVolumeManager(volumesDir, sm, options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return vm
+}
+
+func TestVolumeManagerCloseClosesLimiter(t *testing.T) {
+	t.Parallel()
+
+	fl := &fakeLimiter{}
+	vm := newTestVolumeManager(t, WithLimiter(fl))
+
+	err := vm.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fl.closeCalls != 1 {
+		t.Errorf("expected limiter to be closed once, got %d calls", fl.closeCalls)
+	}
+}
+
+// fakeStatfs returns a statfs source reporting the provided filesystem statistics for any path.
+func fakeStatfs(stat unix.Statfs_t) func(string, *unix.Statfs_t) error {
+	return func(_ string, buf *unix.Statfs_t) error {
+		*buf = stat
+		return nil
+	}
+}
+
+func TestGetAvailableCapacityAppliesFilesystemReservation(t *testing.T) {
+	t.Parallel()
+
+	stat := unix.Statfs_t{
+		Bsize:  4096,
+		Blocks: 1024 * 1024,
+		Bfree:  1024 * 1024,
+		Bavail: 1024 * 1024,
+	}
+
+	for fsType, percent := range DefaultFilesystemCapacityReservationPercent {
+		t.Run(fsType, func(t *testing.T) {
+			t.Parallel()
+
+			vm := newTestVolumeManager(t)
+			vm.statfs = fakeStatfs(stat)
+			reservedVm, err := NewVolumeManager(vm.volumesDir, vm.state, WithCapacityReservationPercent(percent))
+			if err != nil {
+				t.Fatal(err)
+			}
+			reservedVm.statfs = fakeStatfs(stat)
+
+			capacity, err := vm.GetAvailableCapacity()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			reservedCapacity, err := reservedVm.GetAvailableCapacity()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			expectedReservation := stat.Bsize * int64(stat.Blocks) * int64(percent) / 100
+			if capacity-reservedCapacity != expectedReservation {
+				t.Errorf("expected %d%% reservation of %d bytes, got %d", percent, expectedReservation, capacity-reservedCapacity)
+			}
+		})
+	}
+}
+
+func TestGetAvailableCapacityUsesFilesystemStatistics(t *testing.T) {
+	t.Parallel()
+
+	const blockSize = 4096
+
+	tt := []struct {
+		name             string
+		stat             unix.Statfs_t
+		volumeSize       int64
+		expectedCapacity int64
+	}{
+		{
+			name: "empty filesystem",
+			stat: unix.Statfs_t{
+				Bsize:  blockSize,
+				Blocks: 1000,
+				Bfree:  1000,
+				Bavail: 1000,
+			},
+			expectedCapacity: 1000*blockSize - MetadataFileMaxSize,
+		},
+		{
+			name: "blocks reserved for root are excluded",
+			stat: unix.Statfs_t{
+				Bsize:  blockSize,
+				Blocks: 1000,
+				Bfree:  1000,
+				Bavail: 950,
+			},
+			expectedCapacity: 950*blockSize - MetadataFileMaxSize,
+		},
+		{
+			name: "used blocks don't reduce capacity on top of volume sizes",
+			stat: unix.Statfs_t{
+				Bsize:  blockSize,
+				Blocks: 1000,
+				Bfree:  500,
+				Bavail: 450,
+			},
+			volumeSize:       100 * blockSize,
+			expectedCapacity: 950*blockSize - 100*blockSize - 2*MetadataFileMaxSize,
+		},
+		{
+			name: "sub-block volume size is rounded up to the block size",
+			stat: unix.Statfs_t{
+				Bsize:  blockSize,
+				Blocks: 1000,
+				Bfree:  1000,
+				Bavail: 1000,
+			},
+			volumeSize:       1000,
+			expectedCapacity: 999*blockSize - 2*MetadataFileMaxSize,
+		},
+		{
+			name: "non-block-aligned volume size is rounded up to the block size",
+			stat: unix.Statfs_t{
+				Bsize:  blockSize,
+				Blocks: 1000,
+				Bfree:  1000,
+				Bavail: 1000,
+			},
+			volumeSize:       100*blockSize + 1,
+			expectedCapacity: 899*blockSize - 2*MetadataFileMaxSize,
+		},
+		{
+			name: "metadata reservation exceeding free space is clamped",
+			stat: unix.Statfs_t{
+				Bsize:  blockSize,
+				Blocks: 1,
+				Bfree:  1,
+				Bavail: 1,
+			},
+			volumeSize:       blockSize,
+			expectedCapacity: 0,
+		},
+	}
+
+	for i := range tt {
+		tc := tt[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			vm := newTestVolumeManager(t)
+
+			if tc.volumeSize != 0 {
+				err := vm.CreateVolume("id", "name", tc.volumeSize, nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// Filesystem statistics are replaced only once the volume exists, as it wouldn't fit in a full filesystem.
+			vm.statfs = fakeStatfs(tc.stat)
+
+			capacity, err := vm.GetAvailableCapacity()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if capacity != tc.expectedCapacity {
+				t.Errorf("expected available capacity %d, got %d", tc.expectedCapacity, capacity)
+			}
+		})
+	}
+}
+
+func TestGetAvailableCapacityCachesFilesystemStatistics(t *testing.T) {
+	t.Parallel()
+
+	const ttl = time.Second
+
+	stat := unix.Statfs_t{
+		Bsize:  4096,
+		Blocks: 1000,
+		Bfree:  1000,
+		Bavail: 1000,
+	}
+
+	vm := newTestVolumeManager(t, WithCapacityCacheTTL(ttl))
+	now := time.Now()
+	vm.now = func() time.Time {
+		return now
+	}
+
+	statfsCalls := 0
+	vm.statfs = func(_ string, buf *unix.Statfs_t) error {
+		statfsCalls++
+		*buf = stat
+		return nil
+	}
+
+	getAvailableCapacity := func() {
+		t.Helper()
+
+		_, err := vm.GetAvailableCapacity()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	getAvailableCapacity()
+	getAvailableCapacity()
+	if statfsCalls != 1 {
+		t.Errorf("expected 1 statfs call within TTL, got %d", statfsCalls)
+	}
+
+	now = now.Add(ttl)
+	getAvailableCapacity()
+	if statfsCalls != 2 {
+		t.Errorf("expected 2 statfs calls after TTL expired, got %d", statfsCalls)
+	}
+
+	err := vm.CreateVolume("volume-id", "volume", 4096, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	getAvailableCapacity()
+	if statfsCalls != 3 {
+		t.Errorf("expected 3 statfs calls after volume creation, got %d", statfsCalls)
+	}
+
+	err = vm.DeleteVolume("volume-id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	getAvailableCapacity()
+	if statfsCalls != 4 {
+		t.Errorf("expected 4 statfs calls after volume deletion, got %d", statfsCalls)
+	}
+}
+
+func TestGetAvailableCapacityWithoutCache(t *testing.T) {
+	t.Parallel()
+
+	vm := newTestVolumeManager(t, WithCapacityCacheTTL(0))
+
+	statfsCalls := 0
+	vm.statfs = func(_ string, buf *unix.Statfs_t) error {
+		statfsCalls++
+		*buf = unix.Statfs_t{Bsize: 4096, Blocks: 1000, Bfree: 1000, Bavail: 1000}
+		return nil
+	}
+
+	for i := 0; i < 2; i++ {
+		_, err := vm.GetAvailableCapacity()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if statfsCalls != 2 {
+		t.Errorf("expected every call to check statfs, got %d calls", statfsCalls)
+	}
+}
+
+func TestGetVolumeStatisticsUsesFilesystemStatistics(t *testing.T) {
+	t.Parallel()
+
+	const blockSize = 4096
+
+	stat := unix.Statfs_t{
+		Bsize:  blockSize,
+		Blocks: 1000,
+		Bfree:  600,
+		Bavail: 550,
+		Files:  100,
+		Ffree:  70,
+	}
+
+	var statfsPath string
+	vm := newTestVolumeManager(t, WithStatfs(func(path string, buf *unix.Statfs_t) error {
+		statfsPath = path
+		*buf = stat
+		return nil
+	}))
+
+	volumePath := filepath.Join(t.TempDir(), "volume")
+	stats, err := vm.GetVolumeStatistics(volumePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if statfsPath != volumePath {
+		t.Errorf("expected statfs of %q, got %q", volumePath, statfsPath)
+	}
+
+	expected := &VolumeStatistics{
+		AvailableBytes:  550 * blockSize,
+		TotalBytes:      1000 * blockSize,
+		UsedBytes:       400 * blockSize,
+		AvailableInodes: 70,
+		TotalInodes:     100,
+		UsedInodes:      30,
+	}
+	if !reflect.DeepEqual(stats, expected) {
+		t.Errorf("expected volume statistics %#v, got %#v", expected, stats)
+	}
+}
+
+func TestGetVolumeStatisticsFailsWhenStatfsFails(t *testing.T) {
+	t.Parallel()
+
+	vm := newTestVolumeManager(t, WithStatfs(func(string, *unix.Statfs_t) error {
+		return syscall.EIO
+	}))
+
+	_, err := vm.GetVolumeStatistics(t.TempDir())
+	if !errors.Is(err, syscall.EIO) {
+		t.Errorf("expected %v error, got %v", syscall.EIO, err)
+	}
+}
+
+func TestCapacityBreakdownAccountsForProvisionedVolume(t *testing.T) {
+	t.Parallel()
+
+	vm := newTestVolumeManager(t)
+
+	before, err := vm.GetCapacityBreakdown()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const volumeSize = 1024 * 1024
+	err = vm.CreateVolume("volume-id", "volume", volumeSize, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	after, err := vm.GetCapacityBreakdown()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := before.WithVolume(volumeSize)
+	if !reflect.DeepEqual(after, expected) {
+		t.Errorf("expected capacity breakdown %#v, got %#v", expected, after)
+	}
+
+	availableCapacity, err := vm.GetAvailableCapacity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if availableCapacity != after.AvailableBytes() {
+		t.Errorf("expected available capacity %d, got %d", after.AvailableBytes(), availableCapacity)
+	}
+}
+
+func TestCapacityBreakdownAccountsForSnapshots(t *testing.T) {
+	t.Parallel()
+
+	vm := newTestVolumeManager(t)
+
+	const volumeSize = 1024 * 1024
+	err := vm.CreateVolume("volume-id", "volume", volumeSize, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before, err := vm.GetCapacityBreakdown()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = vm.CreateSnapshot("snapshot-id", "snapshot", "volume-id")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	after, err := vm.GetCapacityBreakdown()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := before
+	expected.SnapshotBytes += volumeSize
+	expected.UsedBytes += MetadataFileMaxSize
+	if !reflect.DeepEqual(after, expected) {
+		t.Errorf("expected capacity breakdown %#v, got %#v", expected, after)
+	}
+
+	err = vm.DeleteSnapshot("snapshot-id")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	after, err = vm.GetCapacityBreakdown()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(after, before) {
+		t.Errorf("expected capacity breakdown %#v once snapshot is deleted, got %#v", before, after)
+	}
+}
+
+func TestCapacityBreakdownAccountsForQuarantinedVolumes(t *testing.T) {
+	t.Parallel()
+
+	vm := newTestVolumeManager(t)
+
+	before, err := vm.GetCapacityBreakdown()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const dataSize = 256 * 1024
+	volumePath := filepath.Join(vm.volumesDir, "garbage-uuid")
+	err = os.Mkdir(volumePath, 0770)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(volumePath, "data"), bytes.Repeat([]byte{1}, dataSize), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(vm.volumesDir, "garbage-uuid.json"), []byte("not a json"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sm, err := NewStateManager(vm.volumesDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restoredVm, err := NewVolumeManager(vm.volumesDir, sm, WithMounter(mount.NewFakeMounter(nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	after, err := restoredVm.GetCapacityBreakdown()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := after.UsedBytes - before.UsedBytes; got < dataSize {
+		t.Errorf("expected directory of quarantined volume to take at least %d used bytes, got %d", dataSize, got)
+	}
+
+	// Usage is computed on startup, not by every capacity computation.
+	err = os.WriteFile(filepath.Join(volumePath, "more-data"), bytes.Repeat([]byte{1}, dataSize), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cached, err := restoredVm.GetCapacityBreakdown()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cached.UsedBytes != after.UsedBytes {
+		t.Errorf("expected used bytes %d computed on startup, got %d", after.UsedBytes, cached.UsedBytes)
+	}
+
+	err = restoredVm.DeleteVolume("garbage-uuid")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deleted, err := restoredVm.GetCapacityBreakdown()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted.UsedBytes != before.UsedBytes {
+		t.Errorf("expected used bytes %d once the quarantined volume is deleted, got %d", before.UsedBytes, deleted.UsedBytes)
+	}
+}
+
+func TestGetAvailableCapacityAppliesReservedCapacity(t *testing.T) {
+	t.Parallel()
+
+	vm := newTestVolumeManager(t)
+
+	breakdown, err := vm.GetCapacityBreakdown()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tt := []struct {
+		name             string
+		options          []VolumeManagerOption
+		expectedCapacity int64
+	}{
+		{
+			name:             "bytes",
+			options:          []VolumeManagerOption{WithReservedCapacity(1024 * 1024)},
+			expectedCapacity: breakdown.AvailableBytes() - 1024*1024,
+		},
+		{
+			name:             "percent",
+			options:          []VolumeManagerOption{WithReservedCapacityPercent(10)},
+			expectedCapacity: breakdown.AvailableBytes() - breakdown.TotalBytes*10/100,
+		},
+		{
+			name:             "reservation exceeding free space",
+			options:          []VolumeManagerOption{WithReservedCapacityPercent(100)},
+			expectedCapacity: 0,
+		},
+	}
+
+	for i := range tt {
+		tc := tt[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			reservedVm, err := NewVolumeManager(vm.volumesDir, vm.state, tc.options...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			capacity, err := reservedVm.GetAvailableCapacity()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if capacity != tc.expectedCapacity {
+				t.Errorf("expected available capacity %d, got %d", tc.expectedCapacity, capacity)
+			}
+		})
+	}
+}
+
+func TestNewVolumeManagerRejectsInvalidCapacityReservation(t *testing.T) {
+	t.Parallel()
+
+	vm := newTestVolumeManager(t)
+	for _, percent := range []int{-1, 100} {
+		_, err := NewVolumeManager(vm.volumesDir, vm.state, WithCapacityReservationPercent(percent))
+		if err == nil {
+			t.Errorf("expected error for %d%% reservation, got nil", percent)
+		}
+	}
+
+	for _, percent := range []int{-1, 101} {
+		_, err := NewVolumeManager(vm.volumesDir, vm.state, WithReservedCapacityPercent(percent))
+		if err == nil {
+			t.Errorf("expected error for %d%% reserved capacity, got nil", percent)
+		}
+	}
+
+	_, err := NewVolumeManager(vm.volumesDir, vm.state, WithReservedCapacity(-1))
+	if err == nil {
+		t.Errorf("expected error for negative reserved capacity, got nil")
+	}
+}
+
+func TestBlockVolumeLifecycle(t *testing.T) {
+	t.Parallel()
+
+	const capacity = 10 * 1024 * 1024
+
+	fakeMounter := mount.NewFakeMounter(nil)
+	vm := newTestVolumeManager(t, WithMounter(fakeMounter))
+
+	var attachedFiles, detachedFiles []string
+	vm.attachLoopDevice = func(backingFile string) (string, error) {
+		attachedFiles = append(attachedFiles, backingFile)
+		return "/dev/loop42", nil
+	}
+	vm.detachLoopDevices = func(backingFile string) error {
+		detachedFiles = append(detachedFiles, backingFile)
+		return nil
+	}
+
+	err := vm.CreateVolume("id", "name", capacity, &CreateVolumeOptions{AccessType: BlockAccess})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blockFilePath := filepath.Join(vm.volumesDir, "id", blockFileName)
+	var stat unix.Stat_t
+	err = unix.Stat(blockFilePath, &stat)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stat.Size != capacity {
+		t.Errorf("expected backing file of %d bytes, got %d", capacity, stat.Size)
+	}
+
+	if stat.Blocks*512 >= capacity {
+		t.Errorf("expected sparse backing file, got %d bytes allocated", stat.Blocks*512)
+	}
+
+	vs := vm.GetVolumeStateByID("id")
+	if vs == nil || vs.AccessType != BlockAccess {
+		t.Fatalf("expected block volume state, got %#v", vs)
+	}
+
+	targetPath := filepath.Join(t.TempDir(), "publish", "id")
+	err = vm.PublishBlockVolume("id", targetPath, []string{"bind"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(attachedFiles, []string{blockFilePath}) {
+		t.Errorf("expected loop device attached to %q, got %q", blockFilePath, attachedFiles)
+	}
+
+	fi, err := os.Stat(targetPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !fi.Mode().IsRegular() {
+		t.Errorf("expected target path to be a regular file, got %v", fi.Mode())
+	}
+
+	mountPoints, err := fakeMounter.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedMountPoints := []mount.MountPoint{
+		{Device: "/dev/loop42", Path: targetPath, Opts: []string{"bind"}},
+	}
+	if !reflect.DeepEqual(mountPoints, expectedMountPoints) {
+		t.Errorf("expected mount points %#v, got %#v", expectedMountPoints, mountPoints)
+	}
+
+	err = vm.DeleteVolume("id")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(detachedFiles, []string{blockFilePath}) {
+		t.Errorf("expected loop devices of %q to be detached, got %q", blockFilePath, detachedFiles)
+	}
+
+	_, err = os.Stat(filepath.Join(vm.volumesDir, "id"))
+	if !os.IsNotExist(err) {
+		t.Errorf("expected volume directory to be removed, got %v", err)
+	}
+}
+
+func TestLoopBackedVolumeLifecycle(t *testing.T) {
+	t.Parallel()
+
+	const capacity = 10 * 1024 * 1024
+
+	fakeMounter := mount.NewFakeMounter(nil)
+	vm := newTestVolumeManager(t, WithMounter(fakeMounter))
+
+	var formattedFiles, attachedFiles, detachedFiles []string
+	vm.makeFilesystem = func(path, fsType string) error {
+		if fsType != "xfs" {
+			t.Errorf("expected backing file to be formatted with xfs, got %q", fsType)
+		}
+		formattedFiles = append(formattedFiles, path)
+		return nil
+	}
+	vm.attachLoopDevice = func(backingFile string) (string, error) {
+		attachedFiles = append(attachedFiles, backingFile)
+		return "/dev/loop42", nil
+	}
+	vm.detachLoopDevices = func(backingFile string) error {
+		detachedFiles = append(detachedFiles, backingFile)
+		return nil
+	}
+
+	err := vm.CreateVolume("id", "name", capacity, &CreateVolumeOptions{BackingMode: LoopBacking, FsType: "xfs"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blockFilePath := filepath.Join(vm.volumesDir, "id", blockFileName)
+	if !reflect.DeepEqual(formattedFiles, []string{blockFilePath}) {
+		t.Errorf("expected %q to be formatted, got %q", blockFilePath, formattedFiles)
+	}
+
+	vs := vm.GetVolumeStateByID("id")
+	if vs == nil || !vs.IsLoopBacked() || vs.FsType != "xfs" {
+		t.Fatalf("expected loop backed xfs volume state, got %#v", vs)
+	}
+
+	err = vm.ExpandVolume("id", 2*capacity)
+	if err == nil {
+		t.Errorf("expected error expanding loop backed volume")
+	}
+
+	stagingPath := filepath.Join(t.TempDir(), "staging")
+	seLinuxContext := `context="system_u:object_r:container_file_t:s0:c1,c2"`
+	err = vm.Stage("id", stagingPath, []string{seLinuxContext})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(attachedFiles, []string{blockFilePath}) {
+		t.Errorf("expected loop device attached to %q, got %q", blockFilePath, attachedFiles)
+	}
+
+	mountPoints, err := fakeMounter.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedMountPoints := []mount.MountPoint{
+		{Device: "/dev/loop42", Path: stagingPath, Type: "xfs", Opts: []string{seLinuxContext}},
+	}
+	if !reflect.DeepEqual(mountPoints, expectedMountPoints) {
+		t.Errorf("expected mount points %#v, got %#v", expectedMountPoints, mountPoints)
+	}
+
+	err = vm.Unstage(stagingPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = vm.DeleteVolume("id")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(detachedFiles, []string{blockFilePath}) {
+		t.Errorf("expected loop devices of %q to be detached, got %q", blockFilePath, detachedFiles)
+	}
+}
+
+func TestCreateVolumeRejectsMismatchingFsType(t *testing.T) {
+	t.Parallel()
+
+	vm := newTestVolumeManager(t, WithVolumesDirFilesystem("ext4"), WithRejectMismatchingFsType(true))
+	vm.makeFilesystem = func(string, string) error {
+		return nil
+	}
+
+	err := vm.CreateVolume("directory", "directory", 1024*1024, &CreateVolumeOptions{FsType: "xfs"})
+	if !errors.Is(err, MismatchingFsTypeErr) {
+		t.Errorf("expected %v error, got %v", MismatchingFsTypeErr, err)
+	}
+
+	_, err = os.Stat(filepath.Join(vm.volumesDir, "directory"))
+	if !os.IsNotExist(err) {
+		t.Errorf("expected no volume directory to be created, got %v", err)
+	}
+
+	err = vm.CreateVolume("loop", "loop", 1024*1024, &CreateVolumeOptions{BackingMode: LoopBacking, FsType: "xfs"})
+	if err != nil {
+		t.Errorf("expected loop backed volume to have a filesystem of its own, got %v", err)
+	}
+
+	err = vm.CreateVolume("block", "block", 1024*1024, &CreateVolumeOptions{AccessType: BlockAccess})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vs := vm.GetVolumeStateByID("block"); vs.FsType != "" {
+		t.Errorf("expected block volume without a filesystem, got %q", vs.FsType)
+	}
+}
+
+func TestNewVolumeManagerRequiresVolumesDirFilesystemToRejectMismatchingFsType(t *testing.T) {
+	t.Parallel()
+
+	vm := newTestVolumeManager(t)
+	_, err := NewVolumeManager(vm.volumesDir, vm.state, WithRejectMismatchingFsType(true))
+	if err == nil {
+		t.Errorf("expected an error")
+	}
+}
+
+func TestCreateVolumeRejectsLoopBackedBlockVolume(t *testing.T) {
+	t.Parallel()
+
+	vm := newTestVolumeManager(t)
+
+	err := vm.CreateVolume("id", "name", 1024, &CreateVolumeOptions{AccessType: BlockAccess, BackingMode: LoopBacking})
+	if err == nil {
+		t.Errorf("expected error creating loop backed block volume")
+	}
+
+	if vs := vm.GetVolumeStateByID("id"); vs != nil {
+		t.Errorf("expected no volume to be created, got %#v", vs)
+	}
+}
+
+func TestPublishBlockVolumeRejectsMountVolume(t *testing.T) {
+	t.Parallel()
+
+	vm := newTestVolumeManager(t)
+
+	err := vm.CreateVolume("id", "name", 1024, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = vm.PublishBlockVolume("id", filepath.Join(t.TempDir(), "target"), []string{"bind"})
+	if err == nil {
+		t.Errorf("expected error publishing mount volume as block volume, got nil")
+	}
+}
+
+// readOnlyIgnoringMounter mimics the kernel ignoring the read-only flag of newly created bind mounts.
+type readOnlyIgnoringMounter struct {
+	*mount.FakeMounter
+}
+
+func (m *readOnlyIgnoringMounter) Mount(source, target, fsType string, options []string) error {
+	var opts []string
+	for _, o := range options {
+		if o != "ro" {
+			opts = append(opts, o)
+		}
+	}
+
+	return m.FakeMounter.Mount(source, target, fsType, opts)
+}
+
+func TestPublishVolumeDirectoryRemountsReadOnly(t *testing.T) {
+	t.Parallel()
+
+	tt := []struct {
+		name               string
+		mountOptions       []string
+		ignoreReadOnly     bool
+		remountErr         error
+		expectedRemount    bool
+		expectedErr        bool
+		expectedMountPoint bool
+	}{
+		{
+			name:               "read-write bind mount",
+			mountOptions:       []string{"bind"},
+			ignoreReadOnly:     true,
+			expectedMountPoint: true,
+		},
+		{
+			name:               "read-only bind mount",
+			mountOptions:       []string{"bind", "ro"},
+			expectedMountPoint: true,
+		},
+		{
+			name:               "read-only bind mount created writable",
+			mountOptions:       []string{"bind", "ro"},
+			ignoreReadOnly:     true,
+			expectedRemount:    true,
+			expectedMountPoint: true,
+		},
+		{
+			name:            "failed remount",
+			mountOptions:    []string{"bind", "ro"},
+			ignoreReadOnly:  true,
+			remountErr:      syscall.EPERM,
+			expectedRemount: true,
+			expectedErr:     true,
+		},
+	}
+
+	for i := range tt {
+		tc := tt[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			fakeMounter := mount.NewFakeMounter(nil)
+			var mounter mount.Interface = fakeMounter
+			if tc.ignoreReadOnly {
+				mounter = &readOnlyIgnoringMounter{FakeMounter: fakeMounter}
+			}
+
+			vm := newTestVolumeManager(t, WithMounter(mounter))
+
+			var remounted []string
+			vm.remountReadOnly = func(target string) error {
+				remounted = append(remounted, target)
+				return tc.remountErr
+			}
+
+			err := vm.CreateVolume("id", "name", 1024, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			targetPath := filepath.Join(t.TempDir(), "target")
+			err = vm.PublishVolumeDirectory("id", targetPath, tc.mountOptions)
+			if tc.expectedErr != (err != nil) {
+				t.Fatalf("expected error %v, got %v", tc.expectedErr, err)
+			}
+
+			if tc.expectedRemount != slices.Contains(remounted, targetPath) {
+				t.Errorf("expected remount of %q %v, got remounts %v", targetPath, tc.expectedRemount, remounted)
+			}
+
+			mountPoints, err := fakeMounter.List()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.expectedMountPoint != (len(mountPoints) != 0) {
+				t.Errorf("expected mount point %v, got %v", tc.expectedMountPoint, mountPoints)
+			}
+		})
+	}
+}
+
+func TestCreateVolumeInodeLimit(t *testing.T) {
+	t.Parallel()
+
+	tt := []struct {
+		name               string
+		capacity           int64
+		bytesPerInode      int64
+		inodeLimit         uint64
+		expectedInodeLimit uint64
+	}{
+		{
+			name:               "unlimited by default",
+			capacity:           10 * 1024,
+			expectedInodeLimit: 0,
+		},
+		{
+			name:               "derived from bytes per inode",
+			capacity:           10 * 1024,
+			bytesPerInode:      1024,
+			expectedInodeLimit: 10,
+		},
+		{
+			name:               "explicit limit takes precedence",
+			capacity:           10 * 1024,
+			bytesPerInode:      1024,
+			inodeLimit:         42,
+			expectedInodeLimit: 42,
+		},
+		{
+			name:               "large capacity with a strict explicit limit",
+			capacity:           1 << 30,
+			bytesPerInode:      1024,
+			inodeLimit:         10,
+			expectedInodeLimit: 10,
+		},
+		{
+			name:               "small capacity with a generous explicit limit",
+			capacity:           10 * 1024,
+			bytesPerInode:      1024,
+			inodeLimit:         1 << 20,
+			expectedInodeLimit: 1 << 20,
+		},
+	}
+
+	for i := range tt {
+		tc := tt[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			fl := &fakeLimiter{}
+			vm := newTestVolumeManager(t, WithLimiter(fl), WithBytesPerInode(tc.bytesPerInode))
+
+			err := vm.CreateVolume("id", "name", tc.capacity, &CreateVolumeOptions{InodeLimit: tc.inodeLimit})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			vs := vm.GetVolumeStateByID("id")
+			if vs.InodeLimit != tc.expectedInodeLimit {
+				t.Errorf("expected %d inode limit in volume state, got %d", tc.expectedInodeLimit, vs.InodeLimit)
+			}
+
+			if fl.inodeLimits[vs.LimitID] != tc.expectedInodeLimit {
+				t.Errorf("expected %d inode limit to be set, got %d", tc.expectedInodeLimit, fl.inodeLimits[vs.LimitID])
+			}
+
+			// Byte limit is the capacity regardless of the inode limit.
+			if fl.byteLimits[vs.LimitID] != tc.capacity {
+				t.Errorf("expected %d bytes limit to be set, got %d", tc.capacity, fl.byteLimits[vs.LimitID])
+			}
+
+			err = vm.ExpandVolume("id", 2*tc.capacity)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if fl.inodeLimits[vs.LimitID] != tc.expectedInodeLimit {
+				t.Errorf("expected %d inode limit to be kept on expansion, got %d", tc.expectedInodeLimit, fl.inodeLimits[vs.LimitID])
+			}
+
+			if fl.byteLimits[vs.LimitID] != 2*tc.capacity {
+				t.Errorf("expected %d bytes limit to be set on expansion, got %d", 2*tc.capacity, fl.byteLimits[vs.LimitID])
+			}
+
+			// Limits are restored on startup from the volume state, so both have to survive reloading it.
+			sm, err := NewStateManager(vm.VolumesDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			reloaded := sm.GetVolumeStateByID("id")
+			if reloaded.Size != 2*tc.capacity || reloaded.InodeLimit != tc.expectedInodeLimit {
+				t.Errorf("expected reloaded state to have %d bytes and %d inodes limits, got %d and %d", 2*tc.capacity, tc.expectedInodeLimit, reloaded.Size, reloaded.InodeLimit)
+			}
+		})
+	}
+}
+
+func TestDeleteVolumeRemovesLimitOfOrphanedDirectory(t *testing.T) {
+	t.Parallel()
+
+	fl := &fakeLimiter{}
+	vm := newTestVolumeManager(t, WithLimiter(fl))
+
+	// Volume directory left behind without its state file.
+	path := filepath.Join(vm.volumesDir, "orphaned")
+	err := os.MkdirAll(filepath.Join(path, "data"), 0770)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fl.directoryLimit = map[string]uint32{
+		path: 42,
+	}
+
+	err = vm.DeleteVolume("orphaned")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = os.Stat(path)
+	if !os.IsNotExist(err) {
+		t.Errorf("expected orphaned directory to be removed, got %v", err)
+	}
+
+	if !reflect.DeepEqual(fl.removedLimits, []uint32{42}) {
+		t.Errorf("expected orphaned directory limit to be removed, got %v", fl.removedLimits)
+	}
+}
+
+func TestNewVolumeManagerReconcilesVolumesDir(t *testing.T) {
+	t.Parallel()
+
+	volumesDir := t.TempDir()
+
+	sm, err := NewStateManager(volumesDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vs := &VolumeState{
+		Name:    "volume",
+		ID:      "8b6f3c1e-5c3a-4a4e-9a57-0b0e6c1f7f1a",
+		LimitID: 1,
+		Size:    1024,
+	}
+	err = sm.SaveVolumeState(vs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	volumePath := filepath.Join(volumesDir, vs.ID)
+	orphanedPath := filepath.Join(volumesDir, "0e2b5a3c-7f8d-4c1e-b6a9-3d4e5f6a7b8c")
+	unknownPath := filepath.Join(volumesDir, "lost+found")
+	for _, p := range []string{volumePath, orphanedPath, unknownPath} {
+		err = os.Mkdir(p, 0770)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	newLimiter := func() *fakeLimiter {
+		return &fakeLimiter{
+			directoryLimit: map[string]uint32{
+				orphanedPath: 2,
+			},
+			limitIDs: []uint32{1, 3},
+		}
+	}
+
+	fl := newLimiter()
+	_, err = NewVolumeManager(volumesDir, sm, WithMounter(mount.NewFakeMounter(nil)), WithLimiter(fl))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(fl.removedLimits) != 0 {
+		t.Errorf("expected no limits to be removed without reconciliation, got %v", fl.removedLimits)
+	}
+	_, err = os.Stat(orphanedPath)
+	if err != nil {
+		t.Errorf("expected orphaned directory to be kept without reconciliation: %v", err)
+	}
+
+	fl = newLimiter()
+	_, err = NewVolumeManager(volumesDir, sm, WithMounter(mount.NewFakeMounter(nil)), WithLimiter(fl), WithReconcileOnStartup(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = os.Stat(orphanedPath)
+	if !os.IsNotExist(err) {
+		t.Errorf("expected orphaned directory to be moved away, got %v", err)
+	}
+
+	_, err = os.Stat(filepath.Join(volumesDir, OrphanedDirName, filepath.Base(orphanedPath)))
+	if err != nil {
+		t.Errorf("expected orphaned directory to be moved aside: %v", err)
+	}
+
+	for _, p := range []string{volumePath, unknownPath} {
+		_, err = os.Stat(p)
+		if err != nil {
+			t.Errorf("expected %q to be kept: %v", p, err)
+		}
+	}
+
+	expectedRemovedLimits := []uint32{2, 3}
+	if !reflect.DeepEqual(fl.removedLimits, expectedRemovedLimits) {
+		t.Errorf("expected removed limits %v, got %v", expectedRemovedLimits, fl.removedLimits)
+	}
+}
+
+func TestNewVolumeManagerDoesntReconcileWithQuarantinedStates(t *testing.T) {
+	t.Parallel()
+
+	volumesDir := t.TempDir()
+
+	// Directory of a volume whose state was quarantined looks like an orphan, and its limit like a leaked one.
+	quarantinedPath := filepath.Join(volumesDir, "0e2b5a3c-7f8d-4c1e-b6a9-3d4e5f6a7b8c")
+	err := os.Mkdir(quarantinedPath, 0770)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(quarantinedPath+".json", []byte("not a json"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sm, err := NewStateManager(volumesDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fl := &fakeLimiter{
+		directoryLimit: map[string]uint32{
+			quarantinedPath: 1,
+		},
+		limitIDs: []uint32{1},
+	}
+	_, err = NewVolumeManager(volumesDir, sm, WithMounter(mount.NewFakeMounter(nil)), WithLimiter(fl), WithReconcileOnStartup(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(fl.removedLimits) != 0 {
+		t.Errorf("expected no limits to be removed, got %v", fl.removedLimits)
+	}
+
+	_, err = os.Stat(quarantinedPath)
+	if err != nil {
+		t.Errorf("expected directory of quarantined volume to be kept: %v", err)
+	}
+}
+
+func TestUnmountRetriesBusyMount(t *testing.T) {
+	t.Parallel()
+
+	busyErr := fmt.Errorf("unmount failed: exit status 32\nOutput: umount: /target: target is busy")
+
+	tt := []struct {
+		name                string
+		unmountErrs         []error
+		lazyUnmount         bool
+		expectedAttempts    int
+		expectedLazyUnmount bool
+		expectedErr         bool
+	}{
+		{
+			name:             "succeeds after busy attempt",
+			unmountErrs:      []error{syscall.EBUSY},
+			expectedAttempts: 2,
+		},
+		{
+			name:             "recognizes busy error reported by umount command",
+			unmountErrs:      []error{busyErr, busyErr},
+			expectedAttempts: 3,
+		},
+		{
+			name:             "gives up when retries are exhausted",
+			unmountErrs:      []error{syscall.EBUSY, syscall.EBUSY, syscall.EBUSY},
+			expectedAttempts: 3,
+			expectedErr:      true,
+		},
+		{
+			name:                "falls back to lazy unmount when retries are exhausted",
+			unmountErrs:         []error{syscall.EBUSY, syscall.EBUSY, syscall.EBUSY},
+			lazyUnmount:         true,
+			expectedAttempts:    3,
+			expectedLazyUnmount: true,
+		},
+		{
+			name:             "doesn't retry errors other than busy",
+			unmountErrs:      []error{syscall.EPERM},
+			lazyUnmount:      true,
+			expectedAttempts: 1,
+			expectedErr:      true,
+		},
+	}
+
+	for i := range tt {
+		tc := tt[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			targetPath := filepath.Join(t.TempDir(), "target")
+			err := os.Mkdir(targetPath, 0770)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			attempts := 0
+			mounter := mount.NewFakeMounter([]mount.MountPoint{{Device: "/dev/volume", Path: targetPath}})
+			mounter.UnmountFunc = func(string) error {
+				attempts++
+				if attempts <= len(tc.unmountErrs) {
+					return tc.unmountErrs[attempts-1]
+				}
+				return nil
+			}
+
+			vm := newTestVolumeManager(
+				t,
+				WithMounter(mounter),
+				WithUnmountRetryBackoff(wait.Backoff{Steps: 3, Duration: time.Millisecond, Factor: 2}),
+				WithLazyUnmount(tc.lazyUnmount),
+			)
+
+			lazyUnmounted := false
+			vm.lazyUnmount = func(string) error {
+				lazyUnmounted = true
+				return nil
+			}
+
+			err = vm.Unmount(targetPath)
+			if tc.expectedErr != (err != nil) {
+				t.Errorf("expected error %v, got %v", tc.expectedErr, err)
+			}
+
+			if attempts != tc.expectedAttempts {
+				t.Errorf("expected %d unmount attempts, got %d", tc.expectedAttempts, attempts)
+			}
+
+			if lazyUnmounted != tc.expectedLazyUnmount {
+				t.Errorf("expected lazy unmount %v, got %v", tc.expectedLazyUnmount, lazyUnmounted)
+			}
+		})
+	}
+}
+
+func TestValidateVolumeID(t *testing.T) {
+	t.Parallel()
+
+	tt := []struct {
+		name        string
+		volumeID    string
+		expectedErr bool
+	}{
+		{
+			name:     "uuid",
+			volumeID: "8b6f3c1e-5c3a-4a4e-9a57-0b0e6c1f7f1a",
+		},
+		{
+			name:     "dots within the ID",
+			volumeID: "volume..1",
+		},
+		{
+			name:        "empty",
+			volumeID:    "",
+			expectedErr: true,
+		},
+		{
+			name:        "current directory",
+			volumeID:    ".",
+			expectedErr: true,
+		},
+		{
+			name:        "parent directory",
+			volumeID:    "..",
+			expectedErr: true,
+		},
+		{
+			name:        "traversal",
+			volumeID:    "../etc",
+			expectedErr: true,
+		},
+		{
+			name:        "nested path",
+			volumeID:    "a/b",
+			expectedErr: true,
+		},
+		{
+			name:        "absolute path",
+			volumeID:    "/etc",
+			expectedErr: true,
+		},
+		{
+			name:        "backslash",
+			volumeID:    `..\etc`,
+			expectedErr: true,
+		},
+		{
+			name:        "NUL character",
+			volumeID:    "volume\x00",
+			expectedErr: true,
+		},
+	}
+
+	for i := range tt {
+		tc := tt[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := ValidateVolumeID(tc.volumeID)
+			if tc.expectedErr != (err != nil) {
+				t.Errorf("expected error %v, got %v", tc.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestDeleteVolumeRejectsPathTraversal(t *testing.T) {
+	t.Parallel()
+
+	vm := newTestVolumeManager(t)
+
+	outsidePath := filepath.Join(filepath.Dir(vm.volumesDir), "outside")
+	err := os.Mkdir(outsidePath, 0770)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = vm.DeleteVolume("../outside")
+	if err == nil {
+		t.Errorf("expected an error deleting volume ID pointing outside of volumes dir")
+	}
+
+	_, err = os.Stat(outsidePath)
+	if err != nil {
+		t.Errorf("expected directory outside of volumes dir to be kept: %v", err)
+	}
+}
+
+func TestSweepOrphanedMounts(t *testing.T) {
+	t.Parallel()
+
+	const driverName = "local.csi.scylladb.com"
+
+	vm := newTestVolumeManager(t)
+
+	volumeID := "8b6f3c1e-5c3a-4a4e-9a57-0b0e6c1f7f1a"
+	err := vm.CreateVolume(volumeID, "volume", 1024, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	podsDir := t.TempDir()
+	newPodMount := func(podUID, volumeHandle, driverName string) string {
+		volumeDir := filepath.Join(podsDir, podUID, "volumes", "kubernetes.io~csi", "pv")
+		err := os.MkdirAll(filepath.Join(volumeDir, "mount"), 0770)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		data := fmt.Sprintf(`{"driverName":%q,"volumeHandle":%q}`, driverName, volumeHandle)
+		err = os.WriteFile(filepath.Join(volumeDir, "vol_data.json"), []byte(data), 0640)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return filepath.Join(volumeDir, "mount")
+	}
+
+	// Volume whose state can't be read is still there, it's just unknown to the driver.
+	quarantinedVolumeID := "0e2b5a3c-7f8d-4c1e-b6a9-3d4e5f6a7b8c"
+	err = os.WriteFile(filepath.Join(vm.volumesDir, quarantinedVolumeID+".json"), []byte("not a json"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm.state, err = NewStateManager(vm.volumesDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	orphanedMount := newPodMount("orphaned", "deleted-volume", driverName)
+	existingVolumeMount := newPodMount("existing", volumeID, driverName)
+	quarantinedVolumeMount := newPodMount("quarantined", quarantinedVolumeID, driverName)
+	otherDriverMount := newPodMount("other-driver", "deleted-volume", "other.csi.k8s.io")
+	outsideMount := filepath.Join(t.TempDir(), "mount")
+
+	mounter := mount.NewFakeMounter([]mount.MountPoint{
+		{Device: "/dev/volumes", Path: orphanedMount},
+		{Device: "/dev/volumes", Path: existingVolumeMount},
+		{Device: "/dev/volumes", Path: quarantinedVolumeMount},
+		{Device: "/dev/other", Path: otherDriverMount},
+		{Device: "/dev/outside", Path: outsideMount},
+	})
+	vm.mounter = mounter
+	vm.lazyUnmount = mounter.Unmount
+
+	swept, err := vm.SweepOrphanedMounts(podsDir, driverName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if swept != 1 {
+		t.Errorf("expected 1 swept mount, got %d", swept)
+	}
+
+	mountPoints, err := mounter.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mountPaths []string
+	for _, mp := range mountPoints {
+		mountPaths = append(mountPaths, mp.Path)
+	}
+
+	expectedMountPaths := []string{existingVolumeMount, quarantinedVolumeMount, otherDriverMount, outsideMount}
+	if !reflect.DeepEqual(mountPaths, expectedMountPaths) {
+		t.Errorf("expected %v mount points to be left, got %v", expectedMountPaths, mountPaths)
+	}
+
+	swept, err = vm.SweepOrphanedMounts(podsDir, driverName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if swept != 0 {
+		t.Errorf("expected no mounts to be swept once they're clean, got %d", swept)
+	}
+}
+
+func TestGetPublishedTargetPaths(t *testing.T) {
+	t.Parallel()
+
+	const driverName = "local.csi.scylladb.com"
+
+	vm := newTestVolumeManager(t)
+
+	volumeID := "8b6f3c1e-5c3a-4a4e-9a57-0b0e6c1f7f1a"
+	err := vm.CreateVolume(volumeID, "volume", 1024, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	podsDir := t.TempDir()
+	newPodMount := func(podUID, volumeHandle, driverName string) string {
+		volumeDir := filepath.Join(podsDir, podUID, "volumes", "kubernetes.io~csi", "pv")
+		err := os.MkdirAll(filepath.Join(volumeDir, "mount"), 0770)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		data := fmt.Sprintf(`{"driverName":%q,"volumeHandle":%q}`, driverName, volumeHandle)
+		err = os.WriteFile(filepath.Join(volumeDir, "vol_data.json"), []byte(data), 0640)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return filepath.Join(volumeDir, "mount")
+	}
+
+	firstMount := newPodMount("first", volumeID, driverName)
+	secondMount := newPodMount("second", volumeID, driverName)
+	orphanedMount := newPodMount("orphaned", "deleted-volume", driverName)
+	otherDriverMount := newPodMount("other-driver", volumeID, "other.csi.k8s.io")
+
+	vm.mounter = mount.NewFakeMounter([]mount.MountPoint{
+		{Device: "/dev/volumes", Path: firstMount},
+		{Device: "/dev/volumes", Path: orphanedMount},
+		{Device: "/dev/other", Path: otherDriverMount},
+		{Device: "/dev/volumes", Path: secondMount},
+	})
+
+	targetPaths, err := vm.GetPublishedTargetPaths(podsDir, driverName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedTargetPaths := map[string][]string{
+		volumeID: {firstMount, secondMount},
+	}
+	if !reflect.DeepEqual(targetPaths, expectedTargetPaths) {
+		t.Errorf("expected %v target paths, got %v", expectedTargetPaths, targetPaths)
+	}
+}
+
+func TestCreateVolumeRejectsNonEmptyExistingDirectory(t *testing.T) {
+	t.Parallel()
+
+	vm := newTestVolumeManager(t)
+
+	plantedID := "0e2b5a3c-7f8d-4c1e-b6a9-3d4e5f6a7b8c"
+	plantedPath := filepath.Join(vm.volumesDir, plantedID)
+	err := os.Mkdir(plantedPath, 0770)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plantedFile := filepath.Join(plantedPath, "data")
+	err = os.WriteFile(plantedFile, []byte("restored from backup"), 0640)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = vm.CreateVolume(plantedID, "planted", 1024, nil)
+	if !errors.Is(err, VolumeDirectoryNotEmptyErr) {
+		t.Errorf("expected %v error, got %v", VolumeDirectoryNotEmptyErr, err)
+	}
+
+	if vm.GetVolumeStateByID(plantedID) != nil {
+		t.Errorf("expected no volume to be created")
+	}
+
+	_, err = os.Stat(plantedFile)
+	if err != nil {
+		t.Errorf("expected planted data to be kept: %v", err)
+	}
+
+	emptyID := "8b6f3c1e-5c3a-4a4e-9a57-0b0e6c1f7f1a"
+	err = os.Mkdir(filepath.Join(vm.volumesDir, emptyID), 0770)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = vm.CreateVolume(emptyID, "empty", 1024, nil)
+	if err != nil {
+		t.Errorf("expected empty existing directory to be reused, got %v", err)
+	}
+}
+
+func TestCreateVolumeRetriesTransientFailures(t *testing.T) {
+	t.Parallel()
+
+	tt := []struct {
+		name            string
+		retries         int
+		setLimitErrs    []error
+		expectedErr     error
+		expectedRemoved []uint32
+	}{
+		{
+			name:            "transient failures within the retry budget are rolled back and retried",
+			retries:         2,
+			setLimitErrs:    []error{syscall.EBUSY, syscall.EAGAIN},
+			expectedRemoved: []uint32{1, 2},
+		},
+		{
+			name:            "transient failures exceeding the retry budget fail the creation",
+			retries:         1,
+			setLimitErrs:    []error{syscall.EBUSY, syscall.EBUSY},
+			expectedErr:     syscall.EBUSY,
+			expectedRemoved: []uint32{1, 2},
+		},
+		{
+			name:            "non-transient failure isn't retried",
+			retries:         2,
+			setLimitErrs:    []error{syscall.EIO},
+			expectedErr:     syscall.EIO,
+			expectedRemoved: []uint32{1},
+		},
+	}
+
+	for i := range tt {
+		tc := tt[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			fl := &fakeLimiter{
+				newLimitIDs:  []uint32{1, 2, 3},
+				setLimitErrs: tc.setLimitErrs,
+			}
+			vm := newTestVolumeManager(t,
+				WithLimiter(fl),
+				WithCreateVolumeRetries(tc.retries),
+				WithFilesystemRetryBackoff(wait.Backoff{Steps: 1}),
+			)
+
+			err := vm.CreateVolume("id", "name", 1024, nil)
+			if !errors.Is(err, tc.expectedErr) {
+				t.Fatalf("expected error %v, got %v", tc.expectedErr, err)
+			}
+
+			if !reflect.DeepEqual(fl.removedLimits, tc.expectedRemoved) {
+				t.Errorf("expected limits of failed attempts %v to be removed, got %v", tc.expectedRemoved, fl.removedLimits)
+			}
+
+			vs := vm.GetVolumeStateByID("id")
+			_, statErr := os.Stat(filepath.Join(vm.volumesDir, "id"))
+			if tc.expectedErr != nil {
+				if vs != nil {
+					t.Errorf("expected no volume state after failed creation, got %#v", vs)
+				}
+				if !os.IsNotExist(statErr) {
+					t.Errorf("expected volume directory to be removed, got %v", statErr)
+				}
+				return
+			}
+
+			if vs == nil || vs.LimitID != uint32(len(tc.setLimitErrs)+1) {
+				t.Errorf("expected volume with limit of the last attempt, got %#v", vs)
+			}
+			if statErr != nil {
+				t.Errorf("expected volume directory to exist: %v", statErr)
+			}
+		})
+	}
+}
+
+// blockingLimiter blocks setting limits until release is closed.
+type blockingLimiter struct {
+	limit.NoopLimiter
+
+	started chan struct{}
+	release chan struct{}
+}
+
+func (l *blockingLimiter) SetLimit(uint32, int64, uint64) error {
+	l.started <- struct{}{}
+	<-l.release
+	return nil
+}
+
+func TestCreateVolumeLimitsPendingCreations(t *testing.T) {
+	t.Parallel()
+
+	const maxPendingCreations = 2
+
+	bl := &blockingLimiter{
+		// Room for the creation accepted after the pending ones, which nothing waits for.
+		started: make(chan struct{}, maxPendingCreations+1),
+		release: make(chan struct{}),
+	}
+	vm := newTestVolumeManager(t, WithLimiter(bl), WithMaxPendingCreations(maxPendingCreations))
+
+	createVolume := func(volID string) error {
+		return vm.CreateVolume(volID, volID, 1024, nil)
+	}
+
+	var wg sync.WaitGroup
+	pendingErrs := make([]error, maxPendingCreations)
+	for i := range maxPendingCreations {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			pendingErrs[i] = createVolume(fmt.Sprintf("pending-%d", i))
+		}()
+		<-bl.started
+	}
+
+	err := createVolume("rejected")
+	if !errors.Is(err, TooManyPendingCreationsErr) {
+		t.Errorf("expected %v error while creations are pending, got %v", TooManyPendingCreationsErr, err)
+	}
+
+	_, err = os.Stat(filepath.Join(vm.volumesDir, "rejected"))
+	if !os.IsNotExist(err) {
+		t.Errorf("expected rejected volume directory not to be created, got %v", err)
+	}
+
+	close(bl.release)
+	wg.Wait()
+
+	for i, err := range pendingErrs {
+		if err != nil {
+			t.Errorf("expected pending creation %d to succeed, got %v", i, err)
+		}
+	}
+
+	err = createVolume("accepted")
+	if err != nil {
+		t.Errorf("expected creation to succeed after pending ones finished, got %v", err)
+	}
+}
+
+func TestCreateVolumeRecordsEnforcementMode(t *testing.T) {
+	t.Parallel()
+
+	tt := []struct {
+		name    string
+		limiter limit.Limiter
+		mode    limit.EnforcementMode
+	}{
+		{
+			name:    "hard limits",
+			limiter: &fakeLimiter{enforcementMode: limit.HardEnforcement},
+			mode:    limit.HardEnforcement,
+		},
+		{
+			name:    "soft limits",
+			limiter: &fakeLimiter{enforcementMode: limit.SoftEnforcement},
+			mode:    limit.SoftEnforcement,
+		},
+		{
+			name:    "no limits",
+			limiter: &limit.NoopLimiter{},
+			mode:    limit.NoEnforcement,
+		},
+	}
+
+	for i := range tt {
+		tc := tt[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			vm := newTestVolumeManager(t, WithLimiter(tc.limiter))
+
+			err := vm.CreateVolume("id", "name", 1024, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			vs := vm.GetVolumeStateByID("id")
+			if vs.EnforcementMode != tc.mode {
+				t.Errorf("expected %q enforcement mode, got %q", tc.mode, vs.EnforcementMode)
+			}
+
+			// Enforcement mode is persisted with the volume state.
+			sm, err := NewStateManager(vm.volumesDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			vs = sm.GetVolumeStateByID("id")
+			if vs == nil || vs.EnforcementMode != tc.mode {
+				t.Errorf("expected %q enforcement mode to be persisted, got %#v", tc.mode, vs)
+			}
+		})
+	}
+}
+
+func TestCreateVolumeRetriesDuplicateLimitID(t *testing.T) {
+	t.Parallel()
+
+	tt := []struct {
+		name              string
+		newLimitIDs       []uint32
+		expectedErr       error
+		expectedLimitID   uint32
+		expectedDiscarded []uint32
+	}{
+		{
+			name:            "unique limit",
+			newLimitIDs:     []uint32{2},
+			expectedLimitID: 2,
+		},
+		{
+			name:              "limit colliding once",
+			newLimitIDs:       []uint32{1, 3},
+			expectedLimitID:   3,
+			expectedDiscarded: []uint32{1},
+		},
+		{
+			name:              "limit colliding on every attempt",
+			newLimitIDs:       []uint32{1, 1, 1, 1, 1, 4},
+			expectedErr:       DuplicateLimitIDErr,
+			expectedDiscarded: []uint32{1, 1, 1, 1, 1},
+		},
+	}
+
+	for i := range tt {
+		tc := tt[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			fl := &fakeLimiter{
+				newLimitIDs: []uint32{1},
+			}
+			vm := newTestVolumeManager(t, WithLimiter(fl))
+
+			err := vm.CreateVolume("existing", "existing", 1024, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			fl.newLimitIDs = tc.newLimitIDs
+			err = vm.CreateVolume("id", "name", 1024, nil)
+			if !errors.Is(err, tc.expectedErr) {
+				t.Fatalf("expected %v error, got %v", tc.expectedErr, err)
+			}
+
+			discarded := fl.discardedLimits[filepath.Join(vm.volumesDir, "id")]
+			if !reflect.DeepEqual(discarded, tc.expectedDiscarded) {
+				t.Errorf("expected discarded limits %v, got %v", tc.expectedDiscarded, discarded)
+			}
+
+			if tc.expectedErr != nil {
+				if vs := vm.GetVolumeStateByID("id"); vs != nil {
+					t.Errorf("expected no volume state, got %#v", vs)
+				}
+
+				_, err = os.Stat(filepath.Join(vm.volumesDir, "id"))
+				if !os.IsNotExist(err) {
+					t.Errorf("expected volume directory to be removed, got %v", err)
+				}
+
+				if len(fl.removedLimits) != 0 {
+					t.Errorf("expected limit of the existing volume not to be removed, got removed %v", fl.removedLimits)
+				}
+				return
+			}
+
+			vs := vm.GetVolumeStateByID("id")
+			if vs == nil || vs.LimitID != tc.expectedLimitID {
+				t.Errorf("expected volume with %d limit ID, got %#v", tc.expectedLimitID, vs)
+			}
+		})
+	}
+}
+
+func TestCreateVolumeEncryptsVolumeDirectory(t *testing.T) {
+	t.Parallel()
+
+	key := bytes.Repeat([]byte{1}, 64)
+	otherKey := bytes.Repeat([]byte{2}, 64)
+
+	tt := []struct {
+		name        string
+		supported   bool
+		expectedErr error
+	}{
+		{
+			name:      "filesystem supporting encryption",
+			supported: true,
+		},
+		{
+			name:        "filesystem without encryption support",
+			supported:   false,
+			expectedErr: EncryptionNotSupportedErr,
+		},
+	}
+
+	for i := range tt {
+		tc := tt[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			vm := newTestVolumeManager(t)
+
+			encryptedDirs := map[string]string{}
+			vm.supportsEncryption = func(string) (bool, error) {
+				return tc.supported, nil
+			}
+			vm.addEncryptionKey = func(dir string, key []byte) (string, error) {
+				return fmt.Sprintf("%x", key[:16]), nil
+			}
+			vm.setEncryptionPolicy = func(dir, keyIdentifier string) error {
+				encryptedDirs[dir] = keyIdentifier
+				return nil
+			}
+
+			err := vm.CreateVolume("id", "name", 1024, &CreateVolumeOptions{EncryptionKey: key})
+			if !errors.Is(err, tc.expectedErr) {
+				t.Fatalf("expected %v, got %v", tc.expectedErr, err)
+			}
+
+			path := filepath.Join(vm.volumesDir, "id")
+			if tc.expectedErr != nil {
+				_, err = os.Stat(path)
+				if !os.IsNotExist(err) {
+					t.Errorf("expected no volume directory, got %v", err)
+				}
+				return
+			}
+
+			expectedIdentifier := fmt.Sprintf("%x", key[:16])
+			if encryptedDirs[path] != expectedIdentifier {
+				t.Errorf("expected volume directory encrypted with %q key, got %v", expectedIdentifier, encryptedDirs)
+			}
+
+			vs := vm.GetVolumeStateByID("id")
+			if vs.EncryptionKeyIdentifier != expectedIdentifier {
+				t.Errorf("expected %q key identifier recorded, got %q", expectedIdentifier, vs.EncryptionKeyIdentifier)
+			}
+
+			err = vm.ProvisionEncryptionKey("id", key)
+			if err != nil {
+				t.Errorf("expected no error provisioning volume key, got %v", err)
+			}
+
+			err = vm.ProvisionEncryptionKey("id", otherKey)
+			if !errors.Is(err, EncryptionKeyMismatchErr) {
+				t.Errorf("expected %v, got %v", EncryptionKeyMismatchErr, err)
+			}
+		})
+	}
+}
+
+func TestCreateVolumeSetsExtentSizeHint(t *testing.T) {
+	t.Parallel()
+
+	tt := []struct {
+		name        string
+		fsType      string
+		blockSize   int64
+		expectedErr error
+	}{
+		{
+			name:      "xfs volumes dir",
+			fsType:    "xfs",
+			blockSize: 4096,
+		},
+		{
+			name:        "xfs volumes dir with block size the hint isn't a multiple of",
+			fsType:      "xfs",
+			blockSize:   2 * 1024 * 1024,
+			expectedErr: ExtentSizeHintNotSupportedErr,
+		},
+		{
+			name:        "ext4 volumes dir",
+			fsType:      "ext4",
+			blockSize:   4096,
+			expectedErr: ExtentSizeHintNotSupportedErr,
+		},
+	}
+
+	for i := range tt {
+		tc := tt[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			vm := newTestVolumeManager(t, WithVolumesDirFilesystem(tc.fsType), WithStatfs(func(path string, buf *unix.Statfs_t) error {
+				err := unix.Statfs(path, buf)
+				buf.Bsize = tc.blockSize
+				return err
+			}))
+
+			hints := map[string]uint32{}
+			vm.setExtentSizeHint = func(dir string, extentSize uint32) error {
+				hints[dir] = extentSize
+				return nil
+			}
+
+			err := vm.CreateVolume("id", "name", 1024, &CreateVolumeOptions{ExtentSizeHint: 1024 * 1024})
+			if !errors.Is(err, tc.expectedErr) {
+				t.Fatalf("expected %v, got %v", tc.expectedErr, err)
+			}
+
+			path := filepath.Join(vm.volumesDir, "id")
+			if tc.expectedErr != nil {
+				_, err = os.Stat(path)
+				if !os.IsNotExist(err) {
+					t.Errorf("expected no volume directory, got %v", err)
+				}
+				return
+			}
+
+			if hints[path] != 1024*1024 {
+				t.Errorf("expected extent size hint of volume directory set, got %v", hints)
+			}
+
+			vs := vm.GetVolumeStateByID("id")
+			if vs.ExtentSizeHint != 1024*1024 {
+				t.Errorf("expected extent size hint %d recorded, got %d", 1024*1024, vs.ExtentSizeHint)
+			}
+		})
+	}
+}
+
+func TestGetVolumeIOStats(t *testing.T) {
+	t.Parallel()
+
+	vm := newTestVolumeManager(t)
+
+	devices := map[string][]string{}
+	vm.listLoopDevices = func() (map[string][]string, error) {
+		return devices, nil
+	}
+	vm.getDeviceIOStats = func(device string) (fs.IOStats, error) {
+		if device != "/dev/loop42" {
+			t.Errorf("expected statistics of /dev/loop42, got %q", device)
+		}
+		return fs.IOStats{ReadOperations: 1, ReadBytes: 512, WriteOperations: 2, WriteBytes: 1024}, nil
+	}
+
+	err := vm.CreateVolume("directory", "directory", 1024, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = vm.CreateVolume("block", "block", 1024, &CreateVolumeOptions{AccessType: BlockAccess})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := vm.GetVolumeIOStats("directory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats != nil {
+		t.Errorf("expected no statistics of directory backed volume, got %#v", stats)
+	}
+
+	stats, err = vm.GetVolumeIOStats("block")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats != nil {
+		t.Errorf("expected no statistics of volume without attached loop device, got %#v", stats)
+	}
+
+	devices[vm.getBlockFilePath("block")] = []string{"/dev/loop42"}
+	stats, err = vm.GetVolumeIOStats("block")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedStats := &fs.IOStats{ReadOperations: 1, ReadBytes: 512, WriteOperations: 2, WriteBytes: 1024}
+	if !reflect.DeepEqual(stats, expectedStats) {
+		t.Errorf("expected statistics %#v, got %#v", expectedStats, stats)
+	}
+
+	_, err = vm.GetVolumeIOStats("unknown")
+	if err == nil {
+		t.Errorf("expected error for unknown volume")
+	}
+
+	volumesStats, err := vm.GetVolumesIOStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedVolumesStats := map[string]fs.IOStats{"block": *expectedStats}
+	if !reflect.DeepEqual(volumesStats, expectedVolumesStats) {
+		t.Errorf("expected statistics of volumes %#v, got %#v", expectedVolumesStats, volumesStats)
+	}
+}
+
+func TestForceDeleteVolume(t *testing.T) {
+	t.Parallel()
+
+	const volumeID = "8b6f3c1e-5c3a-4a4e-9a57-0b0e6c1f7f1a"
+
+	tt := []struct {
+		name                  string
+		hasState              bool
+		hasDirectory          bool
+		removeLimitErr        error
+		expectedSteps         []ForceDeleteStep
+		expectedRemovedLimits []uint32
+	}{
+		{
+			name:         "volume with state and directory",
+			hasState:     true,
+			hasDirectory: true,
+			expectedSteps: []ForceDeleteStep{
+				{Name: "detachLoopDevices", Skipped: true},
+				{Name: "removeLimit"},
+				{Name: "removeDirectory"},
+				{Name: "removeState"},
+			},
+			expectedRemovedLimits: []uint32{1},
+		},
+		{
+			name:     "state without directory",
+			hasState: true,
+			expectedSteps: []ForceDeleteStep{
+				{Name: "detachLoopDevices", Skipped: true},
+				{Name: "removeLimit"},
+				{Name: "removeDirectory", Skipped: true},
+				{Name: "removeState"},
+			},
+			expectedRemovedLimits: []uint32{1},
+		},
+		{
+			name:         "directory without state",
+			hasDirectory: true,
+			expectedSteps: []ForceDeleteStep{
+				{Name: "detachLoopDevices", Skipped: true},
+				{Name: "removeLimit"},
+				{Name: "removeDirectory"},
+				{Name: "removeState", Skipped: true},
+			},
+			expectedRemovedLimits: []uint32{2},
+		},
+		{
+			name: "nothing left",
+			expectedSteps: []ForceDeleteStep{
+				{Name: "detachLoopDevices", Skipped: true},
+				{Name: "removeLimit", Skipped: true},
+				{Name: "removeDirectory", Skipped: true},
+				{Name: "removeState", Skipped: true},
+			},
+		},
+		{
+			name:           "failing limit removal doesn't stop the deletion",
+			hasState:       true,
+			hasDirectory:   true,
+			removeLimitErr: fmt.Errorf("quotactl failed"),
+			expectedSteps: []ForceDeleteStep{
+				{Name: "detachLoopDevices", Skipped: true},
+				{Name: "removeLimit", Error: "quotactl failed"},
+				{Name: "removeDirectory"},
+				{Name: "removeState"},
+			},
+		},
+	}
+
+	for i := range tt {
+		tc := tt[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			fl := &fakeLimiter{removeLimitErr: tc.removeLimitErr}
+			vm := newTestVolumeManager(t, WithLimiter(fl))
+
+			volumePath := filepath.Join(vm.VolumesDir(), volumeID)
+			fl.directoryLimit = map[string]uint32{
+				volumePath: 2,
+			}
+
+			if tc.hasState {
+				err := vm.state.SaveVolumeState(&VolumeState{
+					Name:    "volume",
+					ID:      volumeID,
+					LimitID: 1,
+					Size:    1024,
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if tc.hasDirectory {
+				err := os.Mkdir(volumePath, 0770)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				err = os.WriteFile(filepath.Join(volumePath, "data"), []byte("data"), 0660)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			result, err := vm.ForceDeleteVolume(volumeID)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if result.HadState != tc.hasState {
+				t.Errorf("expected result to report state %v, got %v", tc.hasState, result.HadState)
+			}
+
+			if !reflect.DeepEqual(result.Steps, tc.expectedSteps) {
+				t.Errorf("expected steps %#v, got %#v", tc.expectedSteps, result.Steps)
+			}
+
+			if result.Succeeded() != (tc.removeLimitErr == nil) {
+				t.Errorf("expected success to be %v, got %v", tc.removeLimitErr == nil, result.Succeeded())
+			}
+
+			if !reflect.DeepEqual(fl.removedLimits, tc.expectedRemovedLimits) {
+				t.Errorf("expected removed limits %v, got %v", tc.expectedRemovedLimits, fl.removedLimits)
+			}
+
+			_, err = os.Stat(volumePath)
+			if !os.IsNotExist(err) {
+				t.Errorf("expected volume directory to be removed, got %v", err)
+			}
+
+			if vm.GetVolumeStateByID(volumeID) != nil {
+				t.Errorf("expected volume state to be removed")
+			}
+		})
+	}
+}
+
+func TestGetVolumesOfOtherNodes(t *testing.T) {
+	t.Parallel()
+
+	volumesDir := t.TempDir()
+
+	newVolumeManager := func(nodeName string) *VolumeManager {
+		t.Helper()
+
+		sm, err := NewStateManager(volumesDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		vm, err := NewVolumeManager(volumesDir, sm, WithMounter(mount.NewFakeMounter(nil)), WithNodeName(nodeName))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return vm
+	}
+
+	// Volume created without a node name stands for volumes of older versions.
+	vm := newVolumeManager("")
+	err := vm.CreateVolume("legacy-volume-id", "legacy-volume", 4096, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vm = newVolumeManager("node-a")
+	err = vm.CreateVolume("volume-id", "volume", 4096, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if nodeName := vm.GetVolumeStateByID("volume-id").NodeName; nodeName != "node-a" {
+		t.Errorf("expected node name %q recorded in volume state, got %q", "node-a", nodeName)
+	}
+
+	if volumes := vm.GetVolumesOfOtherNodes(); len(volumes) != 0 {
+		t.Errorf("expected no volumes of other nodes, got %#v", volumes)
+	}
+
+	// Renamed node loads the same volumes.
+	vm = newVolumeManager("node-b")
+	volumes := vm.GetVolumesOfOtherNodes()
+	if len(volumes) != 1 || volumes[0].ID != "volume-id" {
+		t.Errorf("expected only volume %q to be of another node, got %#v", "volume-id", volumes)
+	}
+}
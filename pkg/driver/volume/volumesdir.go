@@ -0,0 +1,611 @@
+// Copyright (c) 2023 ScyllaDB.
+
+package volume
+
+import (
+	stderrors "errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/scylladb/local-csi-driver/pkg/driver/limit"
+	"github.com/scylladb/local-csi-driver/pkg/util/fs"
+	"github.com/scylladb/local-csi-driver/pkg/util/uuid"
+	"golang.org/x/sys/unix"
+	"k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/klog/v2"
+)
+
+// VolumesDirIDFileName is the name of the file within every volumes dir holding its ID. The ID tells volumes dirs
+// apart regardless of the path they're mounted at.
+const VolumesDirIDFileName = ".volumes-dir-id"
+
+// InsufficientCapacityErr is returned when a new volume doesn't fit in any volumes dir.
+var InsufficientCapacityErr = stderrors.New("no volumes dir has enough available capacity")
+
+// VolumesDirUnavailableErr is returned for volumes whose volumes dir isn't configured, e.g. when its disk isn't mounted.
+var VolumesDirUnavailableErr = stderrors.New("volumes dir of the volume isn't available")
+
+// ImplausibleFilesystemStatsErr is returned when statistics of the volumes dir filesystem can't describe real storage,
+// e.g. when the volumes dir is on a pseudo-filesystem because the disk isn't mounted at it.
+var ImplausibleFilesystemStatsErr = stderrors.New("implausible volumes dir filesystem statistics")
+
+// CapacityPolicy selects how available capacity of multiple volumes dirs is reported.
+type CapacityPolicy string
+
+const (
+	// MaxCapacityPolicy reports capacity of the volumes dir with the most of it available,
+	// which is the biggest volume that can be provisioned.
+	MaxCapacityPolicy CapacityPolicy = "max"
+	// SumCapacityPolicy reports capacity available in all volumes dirs together.
+	// Single volume can't span multiple volumes dirs, so a volume of the reported size may not fit in any of them.
+	SumCapacityPolicy CapacityPolicy = "sum"
+)
+
+// AdditionalVolumesDir describes a directory volumes are provisioned in besides the main volumes dir,
+// usually on another disk. Volume states are always kept in the main volumes dir.
+type AdditionalVolumesDir struct {
+	Path string
+	// FsType is the filesystem of the directory.
+	FsType string
+	// Limiter enforces limits of volumes within the directory.
+	Limiter limit.Limiter
+	// CapacityReservationPercent is the percentage of raw filesystem size excluded from the available capacity.
+	CapacityReservationPercent int
+}
+
+// volumesDirectory is a directory volumes are provisioned in, with a limiter of its own.
+type volumesDirectory struct {
+	path string
+	// id is read from the VolumesDirIDFileName file of the directory.
+	id                         string
+	fsType                     string
+	limiter                    limit.Limiter
+	capacityReservationPercent int
+
+	// untrackedUsage is the disk usage of directories without a known size, walking them on every capacity
+	// computation would defeat caching of filesystem statistics.
+	untrackedUsage atomic.Int64
+
+	// statfsMut guards the cached statistics of the directory filesystem.
+	statfsMut      sync.Mutex
+	cachedStatfs   unix.Statfs_t
+	statfsCached   bool
+	statfsCachedAt time.Time
+}
+
+// initVolumesDirs sets up the main volumes dir followed by the additional ones.
+func (v *VolumeManager) initVolumesDirs() error {
+	err := v.buildVolumesDirs()
+	if err != nil {
+		return err
+	}
+
+	return v.identifyVolumesDirs()
+}
+
+// buildVolumesDirs validates the additional volumes dirs and puts them after the main one, not touching any of them.
+func (v *VolumeManager) buildVolumesDirs() error {
+	v.volumesDirs = []*volumesDirectory{
+		{
+			path:                       v.volumesDir,
+			fsType:                     v.volumesDirFsType,
+			limiter:                    v.limiter,
+			capacityReservationPercent: v.capacityReservationPercent,
+		},
+	}
+
+	paths := map[string]struct{}{
+		filepath.Clean(v.volumesDir): {},
+	}
+	for _, d := range v.additionalVolumesDirs {
+		path := filepath.Clean(d.Path)
+		if _, ok := paths[path]; ok {
+			return fmt.Errorf("volumes dir %q is specified more than once", d.Path)
+		}
+		paths[path] = struct{}{}
+
+		if d.CapacityReservationPercent < 0 || d.CapacityReservationPercent >= 100 {
+			return fmt.Errorf("capacity reservation percent of volumes dir %q must be within [0, 100) range, got %d", d.Path, d.CapacityReservationPercent)
+		}
+
+		if v.rejectMismatchingFsType && len(d.FsType) == 0 {
+			return fmt.Errorf("filesystem of volumes dir %q has to be known to reject mismatching fsType", d.Path)
+		}
+
+		limiter := d.Limiter
+		if limiter == nil {
+			limiter = &limit.NoopLimiter{}
+		}
+
+		v.volumesDirs = append(v.volumesDirs, &volumesDirectory{
+			path:                       path,
+			fsType:                     d.FsType,
+			limiter:                    limiter,
+			capacityReservationPercent: d.CapacityReservationPercent,
+		})
+	}
+
+	return nil
+}
+
+// AnchorVolumesDirs assigns IDs to the volumes dirs and anchors volume and snapshot states to them, like
+// NewVolumeManager does. Limiters restore limits of the volumes states place in their volumes dir, so it's called
+// before they're created, for volumes of a volumes dir mounted at another path to get their limits restored.
+// The first path is the main volumes dir.
+func AnchorVolumesDirs(sm StateBackend, paths []string) error {
+	if len(paths) == 0 {
+		return fmt.Errorf("no volumes dir to anchor volumes to")
+	}
+
+	v := &VolumeManager{
+		volumesDir: paths[0],
+		state:      sm,
+	}
+	for i, path := range paths {
+		// Additional volumes dirs are matched by their clean paths.
+		if i != 0 {
+			path = filepath.Clean(path)
+		}
+		v.volumesDirs = append(v.volumesDirs, &volumesDirectory{path: path})
+	}
+
+	return v.identifyVolumesDirs()
+}
+
+// identifyVolumesDirs reads IDs of the volumes dirs and anchors volume and snapshot states to them.
+func (v *VolumeManager) identifyVolumesDirs() error {
+	err := v.assignVolumesDirIDs(readOrCreateVolumesDirID)
+	if err != nil {
+		return err
+	}
+
+	v.anchorVolumesToVolumesDirs()
+	v.anchorSnapshotsToVolumesDirs()
+
+	return nil
+}
+
+// assignVolumesDirIDs sets IDs of the volumes dirs to the ones readID returns for their paths.
+// Volumes dirs can be left without an ID, when readID returns an empty one.
+func (v *VolumeManager) assignVolumesDirIDs(readID func(path string) (string, error)) error {
+	ids := make(map[string]string, len(v.volumesDirs))
+	for _, d := range v.volumesDirs {
+		id, err := readID(d.path)
+		if err != nil {
+			return err
+		}
+
+		if len(id) == 0 {
+			continue
+		}
+
+		// Copied ID file would make volumes of one volumes dir found in the other one.
+		if path, ok := ids[id]; ok {
+			return fmt.Errorf("volumes dirs %q and %q have the same ID %q", path, d.path, id)
+		}
+		ids[id] = d.path
+		d.id = id
+	}
+
+	return nil
+}
+
+// readVolumesDirID returns the ID of the volumes dir, or an empty one when the directory has none.
+func readVolumesDirID(path string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(path, VolumesDirIDFileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", fmt.Errorf("can't read ID of volumes dir %q: %w", path, err)
+	}
+
+	id, err := uuid.Parse(strings.TrimSpace(string(data)))
+	if err != nil {
+		return "", fmt.Errorf("can't parse ID of volumes dir %q: %w", path, err)
+	}
+
+	return id.String(), nil
+}
+
+// readOrCreateVolumesDirID returns the ID of the volumes dir, a new one is generated when the directory has none.
+func readOrCreateVolumesDirID(path string) (_ string, err error) {
+	id, err := readVolumesDirID(path)
+	if err != nil || len(id) != 0 {
+		return id, err
+	}
+
+	idPath := filepath.Join(path, VolumesDirIDFileName)
+	id = uuid.MustRandom().String()
+	f, err := os.CreateTemp(path, VolumesDirIDFileName+tempStateFileInfix+"*")
+	if err != nil {
+		return "", fmt.Errorf("can't create temporary ID file of volumes dir %q: %w", path, err)
+	}
+	defer func() {
+		if err != nil {
+			removeErr := os.Remove(f.Name())
+			if removeErr != nil && !os.IsNotExist(removeErr) {
+				err = errors.NewAggregate([]error{err, removeErr})
+			}
+		}
+	}()
+
+	_, err = f.WriteString(id + "\n")
+	if err == nil {
+		err = f.Sync()
+	}
+	closeErr := f.Close()
+	if err != nil || closeErr != nil {
+		return "", fmt.Errorf("can't write temporary ID file %q: %w", f.Name(), errors.NewAggregate([]error{err, closeErr}))
+	}
+
+	err = os.Rename(f.Name(), idPath)
+	if err != nil {
+		return "", fmt.Errorf("can't rename temporary ID file %q to %q: %w", f.Name(), idPath, err)
+	}
+
+	err = syncDir(path)
+	if err != nil {
+		return "", fmt.Errorf("can't sync volumes dir %q: %w", path, err)
+	}
+
+	klog.InfoS("Assigned ID to volumes dir", "path", path, "id", id)
+
+	return id, nil
+}
+
+// getVolumesDirByID returns the volumes dir with the ID, or nil when it isn't configured.
+func (v *VolumeManager) getVolumesDirByID(id string) *volumesDirectory {
+	for _, d := range v.volumesDirs {
+		if d.id == id {
+			return d
+		}
+	}
+
+	return nil
+}
+
+// anchorVolumesToVolumesDirs records IDs of volumes dirs in states of volumes created before they were recorded, and
+// updates paths of volumes whose volumes dir is mounted elsewhere than it used to be. Volumes whose volumes dir isn't
+// configured are left as they are, they're unavailable until it's configured again.
+func (v *VolumeManager) anchorVolumesToVolumesDirs() {
+	for _, vs := range v.state.GetVolumes() {
+		d, changed := v.anchorVolume(&vs)
+		if !changed {
+			continue
+		}
+
+		err := v.state.SaveVolumeState(&vs)
+		if err != nil {
+			klog.ErrorS(err, "Can't record volumes dir in volume state", "volumeID", vs.ID, "volumesDir", d.path, "volumesDirID", d.id)
+		}
+	}
+}
+
+// anchorVolume records the ID of the volumes dir in the volume state or updates its path, when the volumes dir moved.
+// It returns the volumes dir of the volume, if any, and whether the state changed.
+func (v *VolumeManager) anchorVolume(vs *VolumeState) (*volumesDirectory, bool) {
+	d := v.getVolumesDirOf(vs)
+	switch {
+	case d != nil && vs.VolumesDirID == d.id:
+		return d, false
+	case d != nil:
+		vs.VolumesDirID = d.id
+	case len(vs.VolumesDirID) != 0 && v.getVolumesDirByID(vs.VolumesDirID) != nil:
+		d = v.getVolumesDirByID(vs.VolumesDirID)
+		klog.InfoS("Volumes dir of volume moved", "volumeID", vs.ID, "volumesDirID", d.id, "from", vs.VolumesDir, "to", d.path)
+		vs.VolumesDir = d.path
+	default:
+		klog.ErrorS(VolumesDirUnavailableErr, "Volume is unavailable", "volumeID", vs.ID, "volumesDir", vs.VolumesDir, "volumesDirID", vs.VolumesDirID)
+		return nil, false
+	}
+
+	return d, true
+}
+
+// anchorSnapshotsToVolumesDirs is like anchorVolumesToVolumesDirs, for snapshots.
+func (v *VolumeManager) anchorSnapshotsToVolumesDirs() {
+	for _, ss := range v.state.GetSnapshots() {
+		d, changed := v.anchorSnapshot(&ss)
+		if !changed {
+			continue
+		}
+
+		err := v.state.SaveSnapshotState(&ss)
+		if err != nil {
+			klog.ErrorS(err, "Can't record volumes dir in snapshot state", "snapshotID", ss.ID, "volumesDir", d.path, "volumesDirID", d.id)
+		}
+	}
+}
+
+// anchorSnapshot is like anchorVolume, for snapshots.
+func (v *VolumeManager) anchorSnapshot(ss *SnapshotState) (*volumesDirectory, bool) {
+	d := v.getVolumesDirOfSnapshot(ss)
+	switch {
+	case d != nil && ss.VolumesDirID == d.id:
+		return d, false
+	case d != nil:
+		ss.VolumesDirID = d.id
+	case len(ss.VolumesDirID) != 0 && v.getVolumesDirByID(ss.VolumesDirID) != nil:
+		d = v.getVolumesDirByID(ss.VolumesDirID)
+		klog.InfoS("Volumes dir of snapshot moved", "snapshotID", ss.ID, "volumesDirID", d.id, "from", ss.VolumesDir, "to", d.path)
+		ss.VolumesDir = d.path
+	default:
+		klog.ErrorS(VolumesDirUnavailableErr, "Snapshot is unavailable", "snapshotID", ss.ID, "volumesDir", ss.VolumesDir, "volumesDirID", ss.VolumesDirID)
+		return nil, false
+	}
+
+	return d, true
+}
+
+// getVolumesDirOf returns the volumes dir the volume lives in, or nil when it isn't configured. Volumes having
+// the ID of their volumes dir recorded are never found in another volumes dir mounted at the path they recorded.
+func (v *VolumeManager) getVolumesDirOf(vs *VolumeState) *volumesDirectory {
+	for _, d := range v.volumesDirs {
+		if len(vs.VolumesDirID) != 0 && vs.VolumesDirID != d.id {
+			continue
+		}
+
+		if vs.IsInVolumesDir(d.path, v.volumesDir) {
+			return d
+		}
+	}
+
+	return nil
+}
+
+// checkVolumesDirAvailable returns an error when the volume has a state, but its volumes dir isn't configured.
+func (v *VolumeManager) checkVolumesDirAvailable(volID string) error {
+	vs := v.state.GetVolumeStateByID(volID)
+	if vs != nil && v.getVolumesDirOf(vs) == nil {
+		return fmt.Errorf("volume %q is in volumes dir %q which isn't configured: %w", volID, vs.VolumesDir, VolumesDirUnavailableErr)
+	}
+
+	return nil
+}
+
+// getVolumesDirOfSnapshot returns the volumes dir the snapshot lives in, or nil when it isn't configured.
+func (v *VolumeManager) getVolumesDirOfSnapshot(ss *SnapshotState) *volumesDirectory {
+	for _, d := range v.volumesDirs {
+		if len(ss.VolumesDirID) != 0 && ss.VolumesDirID != d.id {
+			continue
+		}
+
+		if filepath.Clean(ss.VolumesDir) == filepath.Clean(d.path) {
+			return d
+		}
+	}
+
+	return nil
+}
+
+// findVolumesDirOfOrphan returns the volumes dir holding a directory of the volume which has no state,
+// defaulting to the main volumes dir when there is none.
+func (v *VolumeManager) findVolumesDirOfOrphan(volID string) *volumesDirectory {
+	for _, d := range v.volumesDirs {
+		_, err := os.Stat(filepath.Join(d.path, volID))
+		if err == nil {
+			return d
+		}
+	}
+
+	return v.volumesDirs[0]
+}
+
+// getVolumesInDir returns volumes which live in the volumes dir.
+func (v *VolumeManager) getVolumesInDir(d *volumesDirectory, volumes []VolumeState) []VolumeState {
+	var dirVolumes []VolumeState
+	for _, vs := range volumes {
+		if v.getVolumesDirOf(&vs) == d {
+			dirVolumes = append(dirVolumes, vs)
+		}
+	}
+
+	return dirVolumes
+}
+
+// statVolumesDir returns statistics of the volumes dir filesystem, reusing the last ones for capacityCacheTTL.
+func (v *VolumeManager) statVolumesDir(d *volumesDirectory) (unix.Statfs_t, error) {
+	d.statfsMut.Lock()
+	defer d.statfsMut.Unlock()
+
+	if d.statfsCached && v.now().Sub(d.statfsCachedAt) < v.capacityCacheTTL {
+		return d.cachedStatfs, nil
+	}
+
+	var stat unix.Statfs_t
+	err := v.statfs(d.path, &stat)
+	if err != nil {
+		return unix.Statfs_t{}, fmt.Errorf("can't check statfs of %q: %w", d.path, err)
+	}
+
+	// Capacity computed from such statistics would be nonsense, so they're never reported or cached.
+	err = validateFilesystemStats(&stat)
+	if err != nil {
+		return unix.Statfs_t{}, fmt.Errorf("volumes dir %q is most likely misconfigured, e.g. it's not on a mounted disk: %w", d.path, err)
+	}
+
+	d.cachedStatfs = stat
+	d.statfsCached = true
+	d.statfsCachedAt = v.now()
+
+	return stat, nil
+}
+
+func validateFilesystemStats(stat *unix.Statfs_t) error {
+	if stat.Blocks == 0 || stat.Bsize <= 0 {
+		return fmt.Errorf("filesystem has %d blocks of %d bytes: %w", stat.Blocks, stat.Bsize, ImplausibleFilesystemStatsErr)
+	}
+
+	if stat.Bfree > stat.Blocks || stat.Bavail > stat.Blocks {
+		return fmt.Errorf("filesystem has %d free and %d available blocks out of %d: %w", stat.Bfree, stat.Bavail, stat.Blocks, ImplausibleFilesystemStatsErr)
+	}
+
+	return nil
+}
+
+// invalidateStatfsCache makes the next capacity computation check statistics of volumes dir filesystems again.
+func (v *VolumeManager) invalidateStatfsCache() {
+	for _, d := range v.volumesDirs {
+		d.statfsMut.Lock()
+		d.statfsCached = false
+		d.statfsMut.Unlock()
+	}
+}
+
+// getVolumesDirCapacityBreakdown returns the capacity breakdown of a single volumes dir. Volume states and metadata
+// of the next volume are accounted for in the main volumes dir, which holds them. Provisioning budget is shared
+// by all volumes dirs, so the one of each dir is what's left of it after volumes in other dirs.
+func (v *VolumeManager) getVolumesDirCapacityBreakdown(d *volumesDirectory, volumes []VolumeState, snapshots []SnapshotState) (CapacityBreakdown, error) {
+	stat, err := v.statVolumesDir(d)
+	if err != nil {
+		return CapacityBreakdown{}, err
+	}
+
+	// Blocks which are free but not available to unprivileged users are reserved for root and can't be provisioned.
+	// Used blocks are still counted in, because volume data is already accounted for by volume sizes.
+	rootReservedBlocks := stat.Bfree - min(stat.Bavail, stat.Bfree)
+	totalSize := stat.Bsize * int64(stat.Blocks-rootReservedBlocks)
+
+	// Filesystems allocate whole blocks, so every volume can take up to its size rounded up to the block size.
+	dirVolumes := v.getVolumesInDir(d, volumes)
+	var committed int64
+	for _, vs := range dirVolumes {
+		committed += roundUpToBlockSize(vs.Size, stat.Bsize)
+	}
+
+	// Reflinked snapshot data stops being shared as the source volume is written to, so snapshots are accounted for
+	// as if they held a full copy of it.
+	var snapshotSize int64
+	for _, ss := range snapshots {
+		if v.getVolumesDirOfSnapshot(&ss) == d {
+			snapshotSize += roundUpToBlockSize(ss.Size, stat.Bsize)
+		}
+	}
+
+	breakdown := CapacityBreakdown{
+		TotalBytes:     totalSize,
+		ReservedBytes:  totalSize*int64(d.capacityReservationPercent+v.reservedCapacityPercent)/100 + v.reservedCapacityBytes,
+		CommittedBytes: committed,
+		SnapshotBytes:  snapshotSize,
+		UsedBytes:      v.getBlockFilesOverallocation(dirVolumes) + d.untrackedUsage.Load(),
+	}
+
+	if d == v.volumesDirs[0] {
+		breakdown.UsedBytes += int64((len(volumes) + len(snapshots)) * MetadataFileMaxSize)
+		// Reserve space for 1 more volume metadata to return max allocatable space.
+		breakdown.PendingBytes = MetadataFileMaxSize
+	}
+
+	if v.maxTotalProvisionedBytes > 0 {
+		breakdown.ProvisioningBudgetBytes = v.provisioningBudgetOf(committed)
+	}
+
+	return breakdown, nil
+}
+
+// refreshUntrackedUsage computes how many bytes directories without a known size take in the volumes dir. Those are
+// directories of volumes with quarantined states and the ones moved aside by reconciliation, they're accounted for
+// by what they really use. They only change on startup and when they're deleted, so it's computed only then.
+// The previous usage is kept when it can't be computed.
+func (v *VolumeManager) refreshUntrackedUsage(d *volumesDirectory) {
+	usage, err := v.getUntrackedUsage(d)
+	if err != nil {
+		klog.ErrorS(err, "Can't compute disk usage of untracked directories, keeping the previous one", "volumesDir", d.path, "usage", d.untrackedUsage.Load())
+		return
+	}
+
+	d.untrackedUsage.Store(usage)
+}
+
+func (v *VolumeManager) getUntrackedUsage(d *volumesDirectory) (int64, error) {
+	paths := []string{filepath.Join(d.path, OrphanedDirName)}
+	for _, id := range v.state.GetQuarantinedVolumeIDs() {
+		paths = append(paths, filepath.Join(d.path, id))
+	}
+
+	var usage int64
+	for _, p := range paths {
+		_, err := os.Lstat(p)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return 0, fmt.Errorf("can't stat untracked directory %q: %w", p, err)
+		}
+
+		dirUsage, err := fs.GetDiskUsage(p)
+		if err != nil {
+			return 0, fmt.Errorf("can't get disk usage of untracked directory %q: %w", p, err)
+		}
+		usage += dirUsage
+	}
+
+	return usage, nil
+}
+
+// provisioningBudgetOf returns the provisioning budget of a breakdown with the committed bytes. Budget caps requested
+// volume sizes, so it's grown by the block rounding of the committed bytes for the remaining budget to stay exact.
+func (v *VolumeManager) provisioningBudgetOf(committed int64) int64 {
+	return v.maxTotalProvisionedBytes - v.state.GetTotalVolumesSize() + committed
+}
+
+// roundUpToBlockSize returns the size rounded up to a multiple of the filesystem block size.
+func roundUpToBlockSize(size, blockSize int64) int64 {
+	if blockSize <= 0 {
+		return size
+	}
+
+	return (size + blockSize - 1) / blockSize * blockSize
+}
+
+// selectVolumesDir returns the volumes dir with the most available capacity a new volume can be provisioned in.
+// Directory backed mount volumes requesting an fsType are provisioned only in volumes dirs having that filesystem.
+// When there is none, the fsType is ignored, unless mismatching fsTypes are rejected.
+func (v *VolumeManager) selectVolumesDir(capacity int64, volAccessType AccessType, backingMode BackingMode, fsType string) (*volumesDirectory, error) {
+	volumes := v.state.GetVolumes()
+	snapshots := v.state.GetSnapshots()
+
+	candidates := v.volumesDirs
+	if backingMode == DirectoryBacking && volAccessType == MountAccess && len(fsType) != 0 {
+		var matching []*volumesDirectory
+		for _, d := range v.volumesDirs {
+			if d.fsType == fsType {
+				matching = append(matching, d)
+			}
+		}
+
+		switch {
+		case len(matching) != 0:
+			candidates = matching
+		case v.rejectMismatchingFsType:
+			return nil, fmt.Errorf("can't create volume with %q fsType: %w", fsType, MismatchingFsTypeErr)
+		}
+	}
+
+	var selected *volumesDirectory
+	var selectedAvailable int64
+	for _, d := range candidates {
+		breakdown, err := v.getVolumesDirCapacityBreakdown(d, volumes, snapshots)
+		if err != nil {
+			return nil, err
+		}
+
+		available := breakdown.AvailableBytes()
+		if selected == nil || available > selectedAvailable {
+			selected = d
+			selectedAvailable = available
+		}
+	}
+
+	if capacity > selectedAvailable {
+		return nil, fmt.Errorf("requested volume capacity of %dB exceeds the most available in a suitable volumes dir (%dB): %w", capacity, max(0, selectedAvailable), InsufficientCapacityErr)
+	}
+
+	return selected, nil
+}
@@ -0,0 +1,601 @@
+// Copyright (c) 2023 ScyllaDB.
+
+package volume
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"golang.org/x/sys/unix"
+	"k8s.io/mount-utils"
+)
+
+// fakeStatfsByPath returns a statfs source reporting statistics of the filesystem the path is on,
+// keyed by volumes dirs.
+func fakeStatfsByPath(stats map[string]unix.Statfs_t) func(string, *unix.Statfs_t) error {
+	return func(path string, buf *unix.Statfs_t) error {
+		for dir, stat := range stats {
+			if path == dir || filepath.Dir(path) == dir {
+				*buf = stat
+				return nil
+			}
+		}
+
+		return unix.ENOENT
+	}
+}
+
+func newFilesystemStat(blocks uint64) unix.Statfs_t {
+	return unix.Statfs_t{
+		Bsize:  4096,
+		Blocks: blocks,
+		Bfree:  blocks,
+		Bavail: blocks,
+	}
+}
+
+func TestCreateVolumeSelectsVolumesDirWithMostAvailableCapacity(t *testing.T) {
+	t.Parallel()
+
+	mainLimiter := &fakeLimiter{newLimitIDs: []uint32{1}}
+	additionalLimiter := &fakeLimiter{newLimitIDs: []uint32{2}}
+	additionalVolumesDir := t.TempDir()
+
+	vm := newTestVolumeManager(t,
+		WithLimiter(mainLimiter),
+		WithVolumesDirFilesystem("xfs"),
+		WithAdditionalVolumesDirs(AdditionalVolumesDir{
+			Path:    additionalVolumesDir,
+			FsType:  "ext4",
+			Limiter: additionalLimiter,
+		}),
+	)
+	vm.statfs = fakeStatfsByPath(map[string]unix.Statfs_t{
+		vm.volumesDir:        newFilesystemStat(1000),
+		additionalVolumesDir: newFilesystemStat(2000),
+	})
+
+	err := vm.CreateVolume("id", "name", 4096, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vs := vm.GetVolumeStateByID("id")
+	if vs.VolumesDir != additionalVolumesDir {
+		t.Errorf("expected volume in volumes dir %q, got %q", additionalVolumesDir, vs.VolumesDir)
+	}
+	if vs.LimitID != 2 || vs.FsType != "ext4" {
+		t.Errorf("expected volume limited by additional volumes dir limiter on ext4, got limit %d on %q", vs.LimitID, vs.FsType)
+	}
+	if len(mainLimiter.newLimitIDs) != 1 {
+		t.Errorf("expected main volumes dir limiter not to be used")
+	}
+
+	path := filepath.Join(additionalVolumesDir, "id")
+	_, err = os.Stat(path)
+	if err != nil {
+		t.Errorf("expected volume directory at %q: %v", path, err)
+	}
+
+	err = vm.DeleteVolume("id")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = os.Stat(path)
+	if !os.IsNotExist(err) {
+		t.Errorf("expected volume directory to be removed, got %v", err)
+	}
+	if !reflect.DeepEqual(additionalLimiter.removedLimits, []uint32{2}) {
+		t.Errorf("expected limit removed by additional volumes dir limiter, got %v", additionalLimiter.removedLimits)
+	}
+	if len(mainLimiter.removedLimits) != 0 {
+		t.Errorf("expected no limit removed by main volumes dir limiter, got %v", mainLimiter.removedLimits)
+	}
+}
+
+func TestCreateVolumeSelectsVolumesDirOfRequestedFilesystem(t *testing.T) {
+	t.Parallel()
+
+	const blockSize = 4096
+
+	// The xfs volumes dir has less capacity available than the ext4 one.
+	tt := []struct {
+		name                  string
+		fsType                string
+		capacity              int64
+		rejectMismatching     bool
+		expectedErr           error
+		expectedFsType        string
+		expectedAdditionalDir bool
+	}{
+		{
+			name:           "xfs volume lands in the xfs volumes dir despite less available capacity",
+			fsType:         "xfs",
+			capacity:       blockSize,
+			expectedFsType: "xfs",
+		},
+		{
+			name:                  "ext4 volume lands in the ext4 volumes dir",
+			fsType:                "ext4",
+			capacity:              blockSize,
+			expectedFsType:        "ext4",
+			expectedAdditionalDir: true,
+		},
+		{
+			name:                  "volume without fsType lands in the volumes dir with the most available capacity",
+			capacity:              blockSize,
+			expectedFsType:        "ext4",
+			expectedAdditionalDir: true,
+		},
+		{
+			name:        "xfs volume which doesn't fit the xfs volumes dir isn't provisioned in the ext4 one",
+			fsType:      "xfs",
+			capacity:    1500 * blockSize,
+			expectedErr: InsufficientCapacityErr,
+		},
+		{
+			name:                  "fsType no volumes dir has is ignored",
+			fsType:                "btrfs",
+			capacity:              blockSize,
+			expectedFsType:        "ext4",
+			expectedAdditionalDir: true,
+		},
+		{
+			name:              "fsType no volumes dir has is rejected",
+			fsType:            "btrfs",
+			capacity:          blockSize,
+			rejectMismatching: true,
+			expectedErr:       MismatchingFsTypeErr,
+		},
+	}
+
+	for i := range tt {
+		tc := tt[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			additionalVolumesDir := t.TempDir()
+			vm := newTestVolumeManager(t,
+				WithVolumesDirFilesystem("xfs"),
+				WithRejectMismatchingFsType(tc.rejectMismatching),
+				WithAdditionalVolumesDirs(AdditionalVolumesDir{
+					Path:   additionalVolumesDir,
+					FsType: "ext4",
+				}),
+			)
+			vm.statfs = fakeStatfsByPath(map[string]unix.Statfs_t{
+				vm.volumesDir:        newFilesystemStat(1000),
+				additionalVolumesDir: newFilesystemStat(2000),
+			})
+
+			err := vm.CreateVolume("id", "name", tc.capacity, &CreateVolumeOptions{FsType: tc.fsType})
+			if tc.expectedErr != nil {
+				if !errors.Is(err, tc.expectedErr) {
+					t.Fatalf("expected %v, got %v", tc.expectedErr, err)
+				}
+				if vm.GetVolumeStateByID("id") != nil {
+					t.Errorf("expected no volume to be created")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			expectedVolumesDir := vm.volumesDir
+			if tc.expectedAdditionalDir {
+				expectedVolumesDir = additionalVolumesDir
+			}
+
+			vs := vm.GetVolumeStateByID("id")
+			if vs.VolumesDir != expectedVolumesDir || vs.FsType != tc.expectedFsType {
+				t.Errorf("expected volume in %q volumes dir on %q, got %q on %q", expectedVolumesDir, tc.expectedFsType, vs.VolumesDir, vs.FsType)
+			}
+		})
+	}
+}
+
+func TestGetCapacityBreakdownAppliesCapacityPolicy(t *testing.T) {
+	t.Parallel()
+
+	const blockSize = 4096
+
+	tt := []struct {
+		name             string
+		policy           CapacityPolicy
+		expectedCapacity int64
+	}{
+		{
+			name:   "max policy reports the volumes dir with the most available capacity",
+			policy: MaxCapacityPolicy,
+			// Metadata of the next volume is reserved only in the main volumes dir.
+			expectedCapacity: 2000 * blockSize,
+		},
+		{
+			name:             "sum policy reports all volumes dirs together",
+			policy:           SumCapacityPolicy,
+			expectedCapacity: 3000*blockSize - MetadataFileMaxSize,
+		},
+	}
+
+	for i := range tt {
+		tc := tt[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			additionalVolumesDir := t.TempDir()
+			vm := newTestVolumeManager(t,
+				WithCapacityPolicy(tc.policy),
+				WithAdditionalVolumesDirs(AdditionalVolumesDir{Path: additionalVolumesDir}),
+			)
+			vm.statfs = fakeStatfsByPath(map[string]unix.Statfs_t{
+				vm.volumesDir:        newFilesystemStat(1000),
+				additionalVolumesDir: newFilesystemStat(2000),
+			})
+
+			capacity, err := vm.GetAvailableCapacity()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if capacity != tc.expectedCapacity {
+				t.Errorf("expected available capacity %d, got %d", tc.expectedCapacity, capacity)
+			}
+		})
+	}
+}
+
+func TestCreateVolumeFailsWhenVolumeDoesntFitAnyVolumesDir(t *testing.T) {
+	t.Parallel()
+
+	additionalVolumesDir := t.TempDir()
+	vm := newTestVolumeManager(t,
+		WithCapacityPolicy(SumCapacityPolicy),
+		WithAdditionalVolumesDirs(AdditionalVolumesDir{Path: additionalVolumesDir}),
+	)
+	vm.statfs = fakeStatfsByPath(map[string]unix.Statfs_t{
+		vm.volumesDir:        newFilesystemStat(1000),
+		additionalVolumesDir: newFilesystemStat(1000),
+	})
+
+	capacity, err := vm.GetAvailableCapacity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = vm.CreateVolume("id", "name", capacity, nil)
+	if !errors.Is(err, InsufficientCapacityErr) {
+		t.Errorf("expected %v, got %v", InsufficientCapacityErr, err)
+	}
+}
+
+func TestNewVolumeManagerRestoresVolumesOfAdditionalVolumesDirs(t *testing.T) {
+	t.Parallel()
+
+	volumesDir := t.TempDir()
+	additionalVolumesDir := t.TempDir()
+	statfs := WithStatfs(fakeStatfsByPath(map[string]unix.Statfs_t{
+		volumesDir:           newFilesystemStat(1000),
+		additionalVolumesDir: newFilesystemStat(2000),
+	}))
+	dirs := WithAdditionalVolumesDirs(AdditionalVolumesDir{Path: additionalVolumesDir})
+
+	sm, err := NewStateManager(volumesDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vm, err := NewVolumeManager(volumesDir, sm, WithMounter(mount.NewFakeMounter(nil)), statfs, dirs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = vm.CreateVolume("id", "name", 4096, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restoredSm, err := NewStateManager(volumesDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Volume is unavailable while its volumes dir isn't configured, the rest of the volumes keep working.
+	degradedVm, err := NewVolumeManager(volumesDir, restoredSm, WithMounter(mount.NewFakeMounter(nil)), statfs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = degradedVm.DeleteVolume("id")
+	if !errors.Is(err, VolumesDirUnavailableErr) {
+		t.Errorf("expected %v deleting volume whose volumes dir isn't configured, got %v", VolumesDirUnavailableErr, err)
+	}
+
+	restoredVm, err := NewVolumeManager(volumesDir, restoredSm, WithMounter(mount.NewFakeMounter(nil)), statfs, dirs, WithReconcileOnStartup(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(additionalVolumesDir, "id")
+	_, err = os.Stat(path)
+	if err != nil {
+		t.Errorf("expected volume directory at %q to be kept by reconciliation: %v", path, err)
+	}
+
+	err = restoredVm.DeleteVolume("id")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = os.Stat(path)
+	if !os.IsNotExist(err) {
+		t.Errorf("expected volume directory to be removed, got %v", err)
+	}
+}
+
+func TestNewVolumeManagerRejectsDuplicateVolumesDir(t *testing.T) {
+	t.Parallel()
+
+	volumesDir := t.TempDir()
+	sm, err := NewStateManager(volumesDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = NewVolumeManager(volumesDir, sm, WithAdditionalVolumesDirs(AdditionalVolumesDir{Path: volumesDir + "/"}))
+	if err == nil {
+		t.Errorf("expected error when volumes dir is specified more than once")
+	}
+}
+
+func TestImplausibleFilesystemStatsAreRejected(t *testing.T) {
+	t.Parallel()
+
+	tt := []struct {
+		name        string
+		stat        unix.Statfs_t
+		expectedErr error
+	}{
+		{
+			name: "plausible statistics",
+			stat: newFilesystemStat(1000),
+		},
+		{
+			name:        "zero blocks",
+			stat:        unix.Statfs_t{Bsize: 4096},
+			expectedErr: ImplausibleFilesystemStatsErr,
+		},
+		{
+			name:        "zero block size",
+			stat:        unix.Statfs_t{Blocks: 1000, Bfree: 1000, Bavail: 1000},
+			expectedErr: ImplausibleFilesystemStatsErr,
+		},
+		{
+			name:        "more free blocks than the filesystem has",
+			stat:        unix.Statfs_t{Bsize: 4096, Blocks: 1000, Bfree: 2000, Bavail: 1000},
+			expectedErr: ImplausibleFilesystemStatsErr,
+		},
+	}
+
+	for i := range tt {
+		tc := tt[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			vm := newTestVolumeManager(t, WithStatfs(func(_ string, buf *unix.Statfs_t) error {
+				*buf = tc.stat
+				return nil
+			}))
+
+			_, err := vm.GetAvailableCapacity()
+			if !errors.Is(err, tc.expectedErr) {
+				t.Errorf("expected available capacity error %v, got %v", tc.expectedErr, err)
+			}
+
+			err = vm.Probe()
+			if !errors.Is(err, tc.expectedErr) {
+				t.Errorf("expected probe error %v, got %v", tc.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestNewVolumeManagerFindsVolumesDirsByID(t *testing.T) {
+	t.Parallel()
+
+	volumesDir := t.TempDir()
+	additionalVolumesDir := filepath.Join(t.TempDir(), "disk-a")
+	movedVolumesDir := filepath.Join(t.TempDir(), "disk-b")
+	err := os.Mkdir(additionalVolumesDir, 0770)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	statfs := WithStatfs(fakeStatfsByPath(map[string]unix.Statfs_t{
+		volumesDir:           newFilesystemStat(1000),
+		additionalVolumesDir: newFilesystemStat(2000),
+		movedVolumesDir:      newFilesystemStat(2000),
+	}))
+
+	sm, err := NewStateManager(volumesDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vm, err := NewVolumeManager(volumesDir, sm, WithMounter(mount.NewFakeMounter(nil)), statfs, WithAdditionalVolumesDirs(AdditionalVolumesDir{Path: additionalVolumesDir}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = vm.CreateVolume("id", "name", 4096, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Disk is mounted at another path, while the old one is left empty.
+	err = os.Rename(additionalVolumesDir, movedVolumesDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Mkdir(additionalVolumesDir, 0770)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restoredSm, err := NewStateManager(volumesDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	degradedVm, err := NewVolumeManager(volumesDir, restoredSm, WithMounter(mount.NewFakeMounter(nil)), statfs, WithAdditionalVolumesDirs(AdditionalVolumesDir{Path: additionalVolumesDir}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = degradedVm.Stage("id", filepath.Join(t.TempDir(), "staging"), nil)
+	if !errors.Is(err, VolumesDirUnavailableErr) {
+		t.Errorf("expected %v staging volume whose volumes dir is mounted elsewhere, got %v", VolumesDirUnavailableErr, err)
+	}
+
+	restoredVm, err := NewVolumeManager(volumesDir, restoredSm, WithMounter(mount.NewFakeMounter(nil)), statfs, WithAdditionalVolumesDirs(
+		AdditionalVolumesDir{Path: additionalVolumesDir},
+		AdditionalVolumesDir{Path: movedVolumesDir},
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vs := restoredVm.GetVolumeStateByID("id")
+	expectedPath := filepath.Join(movedVolumesDir, "id")
+	if path := vs.VolumePath(volumesDir); path != expectedPath {
+		t.Errorf("expected volume to be found at %q, got %q", expectedPath, path)
+	}
+
+	err = restoredVm.DeleteVolume("id")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = os.Stat(expectedPath)
+	if !os.IsNotExist(err) {
+		t.Errorf("expected volume directory to be removed, got %v", err)
+	}
+}
+
+func TestInspectCapacityDoesntChangeVolumesDirs(t *testing.T) {
+	t.Parallel()
+
+	volumesDir := t.TempDir()
+	additionalVolumesDir := filepath.Join(t.TempDir(), "disk-a")
+	movedVolumesDir := filepath.Join(t.TempDir(), "disk-b")
+	err := os.Mkdir(additionalVolumesDir, 0770)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	statfs := WithStatfs(fakeStatfsByPath(map[string]unix.Statfs_t{
+		volumesDir:           newFilesystemStat(1000),
+		additionalVolumesDir: newFilesystemStat(2000),
+		movedVolumesDir:      newFilesystemStat(2000),
+	}))
+
+	sm, err := NewStateManager(volumesDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vm, err := NewVolumeManager(volumesDir, sm, WithMounter(mount.NewFakeMounter(nil)), statfs, WithAdditionalVolumesDirs(AdditionalVolumesDir{Path: additionalVolumesDir}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = vm.CreateVolume("id", "name", 4096, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Disk is mounted at another path, while the old one is left empty.
+	err = os.Rename(additionalVolumesDir, movedVolumesDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Mkdir(additionalVolumesDir, 0770)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	readOnlySm, err := NewStateManager(volumesDir, WithReadOnly(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Capacities are summed up, so the volume missing from the moved volumes dir would show up.
+	options := []VolumeManagerOption{
+		statfs,
+		WithCapacityPolicy(SumCapacityPolicy),
+		WithAdditionalVolumesDirs(
+			AdditionalVolumesDir{Path: additionalVolumesDir},
+			AdditionalVolumesDir{Path: movedVolumesDir},
+		),
+	}
+	inspectedCapacity, err := InspectCapacity(volumesDir, readOnlySm, options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = os.Stat(filepath.Join(additionalVolumesDir, VolumesDirIDFileName))
+	if !os.IsNotExist(err) {
+		t.Errorf("expected no ID file in volumes dir without one, got %v", err)
+	}
+
+	restoredSm, err := NewStateManager(volumesDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if vs := restoredSm.GetVolumeStateByID("id"); vs.VolumesDir != additionalVolumesDir {
+		t.Errorf("expected volume state to keep volumes dir %q, got %q", additionalVolumesDir, vs.VolumesDir)
+	}
+
+	// Volume is found in the moved volumes dir by its ID, so the capacity is the one the driver reports.
+	restoredVm, err := NewVolumeManager(volumesDir, restoredSm, append(options, WithMounter(mount.NewFakeMounter(nil)))...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedCapacity, err := restoredVm.GetAvailableCapacity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if inspectedCapacity != expectedCapacity {
+		t.Errorf("expected inspected capacity %d, got %d", expectedCapacity, inspectedCapacity)
+	}
+}
+
+func TestNewVolumeManagerRejectsVolumesDirsWithTheSameID(t *testing.T) {
+	t.Parallel()
+
+	volumesDir := t.TempDir()
+	additionalVolumesDir := t.TempDir()
+	for _, dir := range []string{volumesDir, additionalVolumesDir} {
+		err := os.WriteFile(filepath.Join(dir, VolumesDirIDFileName), []byte("5a5e9a3c-1447-4c1f-9d4a-2b0c1c23a6a1\n"), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sm, err := NewStateManager(volumesDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = NewVolumeManager(volumesDir, sm, WithMounter(mount.NewFakeMounter(nil)), WithAdditionalVolumesDirs(AdditionalVolumesDir{Path: additionalVolumesDir}))
+	if err == nil {
+		t.Errorf("expected error when volumes dirs have the same ID")
+	}
+}
@@ -0,0 +1,226 @@
+// Copyright (c) 2023 ScyllaDB.
+
+package fs
+
+import (
+	stderrors "errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+	"k8s.io/apimachinery/pkg/util/errors"
+)
+
+// CopyDirectory copies the content of src directory into a new dst directory, preserving modes and ownership.
+// Regular files are reflinked when the filesystem supports it, so they share data blocks with the source until
+// either of them is modified, and copied otherwise. Hard links within src are preserved, fifos and sockets are
+// recreated.
+func CopyDirectory(src, dst string) error {
+	return copyTree(src, dst, true)
+}
+
+// CopyDirectoryContent copies the content of src directory into the existing dst directory the same way as
+// CopyDirectory, dst takes over the mode and ownership of src.
+func CopyDirectoryContent(src, dst string) error {
+	return copyTree(src, dst, false)
+}
+
+// inode identifies a file within the filesystem.
+type inode struct {
+	dev uint64
+	ino uint64
+}
+
+func copyTree(src, dst string, createRoot bool) error {
+	// Copies of files having more than one link, so their other links are linked to the copy.
+	linked := map[inode]string{}
+
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return fmt.Errorf("can't get path of %q relative to %q: %w", path, src, err)
+		}
+		target := filepath.Join(dst, rel)
+
+		info, err := d.Info()
+		if err != nil {
+			return fmt.Errorf("can't stat %q: %w", path, err)
+		}
+
+		stat, ok := info.Sys().(*syscall.Stat_t)
+		if ok && !info.IsDir() && stat.Nlink > 1 {
+			id := inode{dev: stat.Dev, ino: stat.Ino}
+			linkedTarget, found := linked[id]
+			if found {
+				err = os.Link(linkedTarget, target)
+				if err != nil {
+					return fmt.Errorf("can't link %q to %q: %w", target, linkedTarget, err)
+				}
+				// Link shares mode and ownership of the copy.
+				return nil
+			}
+			linked[id] = target
+		}
+
+		switch {
+		case info.IsDir() && path == src && !createRoot:
+			// Existing destination only takes over mode and ownership.
+		case info.IsDir():
+			err = os.Mkdir(target, info.Mode().Perm())
+			if err != nil {
+				return fmt.Errorf("can't create directory %q: %w", target, err)
+			}
+		case info.Mode().IsRegular():
+			err = copyFile(path, target, info.Mode().Perm())
+			if err != nil {
+				return err
+			}
+		case info.Mode()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return fmt.Errorf("can't read symlink %q: %w", path, err)
+			}
+
+			err = os.Symlink(link, target)
+			if err != nil {
+				return fmt.Errorf("can't create symlink %q: %w", target, err)
+			}
+		case info.Mode()&fs.ModeNamedPipe != 0:
+			err = unix.Mkfifo(target, uint32(info.Mode().Perm()))
+			if err != nil {
+				return fmt.Errorf("can't create fifo %q: %w", target, err)
+			}
+		case info.Mode()&fs.ModeSocket != 0:
+			// Socket is useless without the process listening on it, but applications expect to find it in place.
+			err = unix.Mknod(target, unix.S_IFSOCK|uint32(info.Mode().Perm()), 0)
+			if err != nil {
+				return fmt.Errorf("can't create socket %q: %w", target, err)
+			}
+		default:
+			return fmt.Errorf("can't copy %q of unsupported type %v", path, info.Mode().Type())
+		}
+
+		// Mode set on creation is subject to umask.
+		if info.Mode()&fs.ModeSymlink == 0 {
+			err = os.Chmod(target, info.Mode()&(fs.ModePerm|fs.ModeSetuid|fs.ModeSetgid|fs.ModeSticky))
+			if err != nil {
+				return fmt.Errorf("can't set mode of %q: %w", target, err)
+			}
+		}
+
+		if ok {
+			err = os.Lchown(target, int(stat.Uid), int(stat.Gid))
+			if err != nil {
+				return fmt.Errorf("can't set ownership of %q: %w", target, err)
+			}
+		}
+
+		return nil
+	})
+}
+
+// copyFile reflinks src file to a new dst file, falling back to copying its content
+// when the filesystem can't share data blocks between files. Holes of src aren't allocated in the copy.
+func copyFile(src, dst string, perm fs.FileMode) (err error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("can't open %q: %w", src, err)
+	}
+	defer func() {
+		closeErr := in.Close()
+		if closeErr != nil {
+			err = errors.NewAggregate([]error{err, closeErr})
+		}
+	}()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, perm)
+	if err != nil {
+		return fmt.Errorf("can't create %q: %w", dst, err)
+	}
+	defer func() {
+		closeErr := out.Close()
+		if closeErr != nil {
+			err = errors.NewAggregate([]error{err, closeErr})
+		}
+	}()
+
+	err = unix.IoctlFileClone(int(out.Fd()), int(in.Fd()))
+	if err == nil {
+		return nil
+	}
+	if !isReflinkUnsupportedError(err) {
+		return fmt.Errorf("can't reflink %q to %q: %w", src, dst, err)
+	}
+
+	err = copySparse(in, out)
+	if err != nil {
+		return fmt.Errorf("can't copy %q to %q: %w", src, dst, err)
+	}
+
+	return nil
+}
+
+// copySparse copies only data regions of in to the same offsets of the empty out file, leaving its holes unallocated,
+// so sparse files like block volume backing files don't grow to their full size. Files of filesystems which can't
+// tell holes apart are copied whole.
+func copySparse(in, out *os.File) error {
+	fi, err := in.Stat()
+	if err != nil {
+		return fmt.Errorf("can't stat source file: %w", err)
+	}
+	size := fi.Size()
+
+	w := io.NewOffsetWriter(out, 0)
+	var offset int64
+	for offset < size {
+		dataOffset, err := unix.Seek(int(in.Fd()), offset, unix.SEEK_DATA)
+		if err != nil {
+			// There is no data past the offset, the rest of the file is a hole.
+			if stderrors.Is(err, unix.ENXIO) {
+				break
+			}
+			if offset == 0 && stderrors.Is(err, unix.EINVAL) {
+				_, err = io.Copy(out, in)
+				return err
+			}
+			return fmt.Errorf("can't seek to data after offset %d: %w", offset, err)
+		}
+
+		holeOffset, err := unix.Seek(int(in.Fd()), dataOffset, unix.SEEK_HOLE)
+		if err != nil {
+			return fmt.Errorf("can't seek to hole after offset %d: %w", dataOffset, err)
+		}
+
+		_, err = w.Seek(dataOffset, io.SeekStart)
+		if err != nil {
+			return err
+		}
+
+		_, err = io.Copy(w, io.NewSectionReader(in, dataOffset, holeOffset-dataOffset))
+		if err != nil {
+			return err
+		}
+
+		offset = holeOffset
+	}
+
+	// Trailing hole isn't written, it's only covered by the file size.
+	return out.Truncate(size)
+}
+
+// isReflinkUnsupportedError returns whether FICLONE failed because the files can't share data blocks,
+// like when the filesystem has no reflink support or the files are on different filesystems.
+func isReflinkUnsupportedError(err error) bool {
+	return stderrors.Is(err, unix.EOPNOTSUPP) ||
+		stderrors.Is(err, unix.ENOTTY) ||
+		stderrors.Is(err, unix.EXDEV) ||
+		stderrors.Is(err, unix.EINVAL)
+}
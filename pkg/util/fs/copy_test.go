@@ -0,0 +1,266 @@
+// Copyright (c) 2023 ScyllaDB.
+
+package fs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestCopyDirectory(t *testing.T) {
+	t.Parallel()
+
+	src := filepath.Join(t.TempDir(), "src")
+	err := os.MkdirAll(filepath.Join(src, "nested"), 0750)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.WriteFile(filepath.Join(src, "nested", "data"), []byte("data"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.Symlink("nested/data", filepath.Join(src, "link"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dst := filepath.Join(t.TempDir(), "dst")
+	err = CopyDirectory(src, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dst, "nested", "data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "data" {
+		t.Errorf("expected copied file content %q, got %q", "data", data)
+	}
+
+	tt := []struct {
+		path         string
+		expectedMode os.FileMode
+	}{
+		{
+			path:         "nested",
+			expectedMode: os.ModeDir | 0750,
+		},
+		{
+			path:         "nested/data",
+			expectedMode: 0600,
+		},
+	}
+	for _, tc := range tt {
+		fi, err := os.Stat(filepath.Join(dst, tc.path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Mode() != tc.expectedMode {
+			t.Errorf("expected %q to have mode %v, got %v", tc.path, tc.expectedMode, fi.Mode())
+		}
+	}
+
+	link, err := os.Readlink(filepath.Join(dst, "link"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if link != "nested/data" {
+		t.Errorf("expected symlink to %q, got %q", "nested/data", link)
+	}
+
+	// Copy is independent of the source.
+	err = os.WriteFile(filepath.Join(src, "nested", "data"), []byte("changed"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err = os.ReadFile(filepath.Join(dst, "nested", "data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "data" {
+		t.Errorf("expected copy to keep content %q, got %q", "data", data)
+	}
+}
+
+func TestCopyDirectoryToExistingDirectory(t *testing.T) {
+	t.Parallel()
+
+	err := CopyDirectory(t.TempDir(), t.TempDir())
+	if err == nil {
+		t.Fatal("expected error when the destination already exists")
+	}
+}
+
+func TestCopyDirectoryContent(t *testing.T) {
+	t.Parallel()
+
+	src := t.TempDir()
+	err := os.WriteFile(filepath.Join(src, "data"), []byte("data"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.Chmod(src, 0750)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dst := t.TempDir()
+	err = CopyDirectoryContent(src, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dst, "data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "data" {
+		t.Errorf("expected copied file content %q, got %q", "data", data)
+	}
+
+	fi, err := os.Stat(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode() != os.ModeDir|0750 {
+		t.Errorf("expected destination to take over mode %v, got %v", os.ModeDir|0750, fi.Mode())
+	}
+}
+
+func TestCopyDirectoryPreservesSpecialFilesAndHardlinks(t *testing.T) {
+	t.Parallel()
+
+	src := filepath.Join(t.TempDir(), "src")
+	err := os.Mkdir(src, 0750)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.WriteFile(filepath.Join(src, "data"), []byte("data"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.Link(filepath.Join(src, "data"), filepath.Join(src, "hardlink"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = unix.Mkfifo(filepath.Join(src, "fifo"), 0640)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = unix.Mknod(filepath.Join(src, "socket"), unix.S_IFSOCK|0660, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Modes are subject to umask on creation.
+	err = os.Chmod(filepath.Join(src, "socket"), 0660)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dst := filepath.Join(t.TempDir(), "dst")
+	err = CopyDirectory(src, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.Stat(filepath.Join(dst, "data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hardlink, err := os.Stat(filepath.Join(dst, "hardlink"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !os.SameFile(data, hardlink) {
+		t.Errorf("expected hard linked files to share an inode in the copy")
+	}
+
+	for name, expectedMode := range map[string]os.FileMode{
+		"fifo":   os.ModeNamedPipe | 0640,
+		"socket": os.ModeSocket | 0660,
+	} {
+		fi, err := os.Lstat(filepath.Join(dst, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Mode() != expectedMode {
+			t.Errorf("expected %q to have mode %v, got %v", name, expectedMode, fi.Mode())
+		}
+	}
+}
+
+func TestCopyDirectoryKeepsFilesSparse(t *testing.T) {
+	t.Parallel()
+
+	src := filepath.Join(t.TempDir(), "src")
+	err := os.Mkdir(src, 0750)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Data lies in between holes, the way it does in a barely used block volume backing file.
+	const size = 64 << 20
+	data := []byte("data")
+	dataOffset := int64(size / 2)
+	f, err := os.Create(filepath.Join(src, "block"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = f.WriteAt(data, dataOffset)
+	if err == nil {
+		err = f.Truncate(size)
+	}
+	closeErr := f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if closeErr != nil {
+		t.Fatal(closeErr)
+	}
+
+	dst := filepath.Join(t.TempDir(), "dst")
+	err = CopyDirectory(src, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := os.ReadFile(filepath.Join(dst, "block"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(content) != size {
+		t.Fatalf("expected copied file of %d bytes, got %d", size, len(content))
+	}
+	if got := string(content[dataOffset : dataOffset+int64(len(data))]); got != string(data) {
+		t.Errorf("expected %q at offset %d, got %q", data, dataOffset, got)
+	}
+	for i, b := range content {
+		if (int64(i) < dataOffset || int64(i) >= dataOffset+int64(len(data))) && b != 0 {
+			t.Fatalf("expected holes to read as zeroes, got %d at offset %d", b, i)
+		}
+	}
+
+	var stat unix.Stat_t
+	err = unix.Stat(filepath.Join(dst, "block"), &stat)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Stat blocks are always 512B units, a fully allocated copy would take the whole size.
+	if allocated := stat.Blocks * 512; allocated >= size/2 {
+		t.Errorf("expected sparse copy to allocate less than %d bytes, got %d", size/2, allocated)
+	}
+}
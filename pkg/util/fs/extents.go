@@ -0,0 +1,118 @@
+// Copyright (c) 2023 ScyllaDB.
+
+package fs
+
+import (
+	stderrors "errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// <uapi/linux/fs.h>
+	fsIocFiemap = 0xc020660b
+)
+
+// fiemap is the header of struct fiemap from <uapi/linux/fiemap.h>. Without room for extents the kernel
+// only counts them, which is all that's needed.
+type fiemap struct {
+	start         uint64
+	length        uint64
+	flags         uint32
+	mappedExtents uint32
+	extentCount   uint32
+	_             uint32
+}
+
+// ExtentStats describes how fragmented files of a directory tree are.
+type ExtentStats struct {
+	// Files is the number of regular files.
+	Files uint64 `json:"files"`
+	// Extents is the number of extents of all files.
+	Extents uint64 `json:"extents"`
+	// FragmentedFiles is the number of files having more than a single extent.
+	FragmentedFiles uint64 `json:"fragmentedFiles"`
+	// MaxFileExtents is the number of extents of the most fragmented file.
+	MaxFileExtents uint64 `json:"maxFileExtents"`
+	// FragmentationFactor is the share of extents beyond a single one per file, in [0, 1) range,
+	// computed the same way as by xfs_db frag command.
+	FragmentationFactor float64 `json:"fragmentationFactor"`
+
+	// nonEmptyFiles is the number of files having any extent, which is the ideal number of extents.
+	nonEmptyFiles uint64
+}
+
+// add accounts a file having the given number of extents.
+func (s *ExtentStats) add(extents uint64) {
+	s.Files++
+	s.Extents += extents
+	if extents > 1 {
+		s.FragmentedFiles++
+	}
+	s.MaxFileExtents = max(s.MaxFileExtents, extents)
+
+	// Files without any extent, e.g. empty ones, are ideal already.
+	if extents != 0 {
+		s.nonEmptyFiles++
+	}
+	if s.Extents != 0 {
+		s.FragmentationFactor = float64(s.Extents-s.nonEmptyFiles) / float64(s.Extents)
+	}
+}
+
+// GetExtentStats counts extents of all regular files under dir using FIEMAP, which reads the extent maps
+// of every file, so it's costly on directories holding many files.
+func GetExtentStats(dir string) (ExtentStats, error) {
+	var stats ExtentStats
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		extents, err := countFileExtents(path)
+		if err != nil {
+			return err
+		}
+		stats.add(uint64(extents))
+
+		return nil
+	})
+	if err != nil {
+		return ExtentStats{}, err
+	}
+
+	return stats, nil
+}
+
+// countFileExtents returns the number of extents of the file.
+func countFileExtents(path string) (uint32, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, fmt.Errorf("can't open %q: %w", path, err)
+	}
+	defer f.Close()
+
+	fm := fiemap{
+		length: ^uint64(0),
+	}
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, f.Fd(), fsIocFiemap, uintptr(unsafe.Pointer(&fm)))
+	if errno != 0 {
+		return 0, fmt.Errorf("can't get extent map of %q: %w", path, errno)
+	}
+
+	return fm.mappedExtents, nil
+}
+
+// IsExtentMappingUnsupportedError returns whether FIEMAP failed because the filesystem doesn't implement it.
+func IsExtentMappingUnsupportedError(err error) bool {
+	return stderrors.Is(err, unix.EOPNOTSUPP) || stderrors.Is(err, unix.ENOTTY)
+}
@@ -0,0 +1,100 @@
+// Copyright (c) 2023 ScyllaDB.
+
+package fs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExtentStatsAdd(t *testing.T) {
+	t.Parallel()
+
+	tt := []struct {
+		name          string
+		fileExtents   []uint64
+		expectedStats ExtentStats
+	}{
+		{
+			name: "no files",
+		},
+		{
+			name:        "unfragmented files",
+			fileExtents: []uint64{1, 1, 0},
+			expectedStats: ExtentStats{
+				Files:          3,
+				Extents:        2,
+				MaxFileExtents: 1,
+			},
+		},
+		{
+			name:        "fragmented files",
+			fileExtents: []uint64{1, 4, 0, 3},
+			expectedStats: ExtentStats{
+				Files:               4,
+				Extents:             8,
+				FragmentedFiles:     2,
+				MaxFileExtents:      4,
+				FragmentationFactor: 0.625,
+			},
+		},
+	}
+
+	for i := range tt {
+		tc := tt[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			var stats ExtentStats
+			for _, e := range tc.fileExtents {
+				stats.add(e)
+			}
+
+			// Only exported statistics are compared.
+			stats.nonEmptyFiles = 0
+			if stats != tc.expectedStats {
+				t.Errorf("expected %#v, got %#v", tc.expectedStats, stats)
+			}
+		})
+	}
+}
+
+func TestGetExtentStats(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	err := os.Mkdir(filepath.Join(dir, "nested"), 0770)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"empty", filepath.Join("nested", "data")} {
+		var data []byte
+		if name != "empty" {
+			data = make([]byte, 4096)
+		}
+
+		err = os.WriteFile(filepath.Join(dir, name), data, 0660)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = os.Symlink("empty", filepath.Join(dir, "symlink"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := GetExtentStats(dir)
+	if IsExtentMappingUnsupportedError(err) {
+		t.Skipf("filesystem of %q doesn't support FIEMAP", dir)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stats.Files != 2 {
+		t.Errorf("expected 2 regular files, got %#v", stats)
+	}
+}
@@ -3,26 +3,151 @@
 package fs
 
 import (
-	"bytes"
 	"fmt"
-	"os/exec"
+	"io/fs"
+	"os"
+	"path/filepath"
 	"strings"
+
+	"golang.org/x/sys/unix"
+	"k8s.io/klog/v2"
+	"k8s.io/mount-utils"
 )
 
+// filesystemMagics maps superblock magic numbers reported by statfs to filesystem names.
+// Ext2 and ext3 share the magic number with ext4, so they're all reported as ext4.
+var filesystemMagics = map[int64]string{
+	unix.XFS_SUPER_MAGIC:       "xfs",
+	unix.EXT4_SUPER_MAGIC:      "ext4",
+	unix.TMPFS_MAGIC:           "tmpfs",
+	unix.BTRFS_SUPER_MAGIC:     "btrfs",
+	unix.OVERLAYFS_SUPER_MAGIC: "overlay",
+	unix.NFS_SUPER_MAGIC:       "nfs",
+}
+
+// GetFilesystem returns the name of the filesystem path is on. Filesystems with an unknown magic number
+// are looked up in the mount table.
 func GetFilesystem(path string) (string, error) {
-	cmd := exec.Command("stat", "-f", "-c", "%T", path)
-	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
-	cmd.Stdout = stdout
-	cmd.Stderr = stderr
-	err := cmd.Run()
+	var stat unix.Statfs_t
+	err := unix.Statfs(path, &stat)
+	if err != nil {
+		return "", fmt.Errorf("can't statfs %q: %w", path, err)
+	}
+
+	fsType, ok := filesystemMagics[int64(stat.Type)]
+	if ok {
+		return fsType, nil
+	}
+
+	fsType, err = getFilesystemFromMountTable(path)
 	if err != nil {
-		return "", fmt.Errorf("can't run stat on %q: %w, stdout: %q, stderr: %q", path, err, stdout.String(), stderr.String())
+		return "", fmt.Errorf("can't get filesystem of %q with unknown magic number %#x: %w", path, stat.Type, err)
+	}
+
+	return fsType, nil
+}
+
+// getFilesystemFromMountTable returns the filesystem type of the mount point path is under.
+func getFilesystemFromMountTable(path string) (string, error) {
+	path, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", fmt.Errorf("can't resolve %q: %w", path, err)
+	}
+
+	path, err = filepath.Abs(path)
+	if err != nil {
+		return "", fmt.Errorf("can't get absolute path of %q: %w", path, err)
+	}
+
+	entries, err := mount.New("").List()
+	if err != nil {
+		return "", fmt.Errorf("can't list mount points: %w", err)
+	}
+
+	// Mount points can be stacked on top of each other, the later one covers the earlier ones.
+	var fsType string
+	longestMatch := -1
+	for _, e := range entries {
+		if !isPathUnder(path, e.Path) || len(e.Path) < longestMatch {
+			continue
+		}
+
+		fsType = e.Type
+		longestMatch = len(e.Path)
 	}
 
-	fsType := strings.TrimSpace(stdout.String())
-	if len(fsType) == 0 {
-		return "", fmt.Errorf("can't get filesystem information about %q", path)
+	if longestMatch < 0 || len(fsType) == 0 {
+		return "", fmt.Errorf("mount point of %q not found", path)
 	}
 
 	return fsType, nil
 }
+
+func isPathUnder(path, dir string) bool {
+	if path == dir || dir == "/" {
+		return true
+	}
+
+	return strings.HasPrefix(path, dir+"/")
+}
+
+// Syncfs writes buffered data of the filesystem path is on to disk.
+func Syncfs(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("can't open %q: %w", path, err)
+	}
+	defer func() {
+		closeErr := f.Close()
+		if closeErr != nil {
+			klog.ErrorS(closeErr, "Failed to close synced path", "path", path)
+		}
+	}()
+
+	err = unix.Syncfs(int(f.Fd()))
+	if err != nil {
+		return fmt.Errorf("can't syncfs %q: %w", path, err)
+	}
+
+	return nil
+}
+
+// GetDiskUsage returns the number of bytes allocated by files within the directory tree, like du does.
+// Files with multiple hardlinks within the tree are counted once.
+func GetDiskUsage(path string) (int64, error) {
+	type inode struct {
+		dev uint64
+		ino uint64
+	}
+	seen := map[inode]struct{}{}
+
+	var usage int64
+	err := filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		var stat unix.Stat_t
+		err = unix.Lstat(p, &stat)
+		if err != nil {
+			return fmt.Errorf("can't stat %q: %w", p, err)
+		}
+
+		if stat.Nlink > 1 && !d.IsDir() {
+			key := inode{dev: stat.Dev, ino: stat.Ino}
+			if _, ok := seen[key]; ok {
+				return nil
+			}
+			seen[key] = struct{}{}
+		}
+
+		// Stat blocks are always 512B units.
+		usage += stat.Blocks * 512
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("can't walk %q: %w", path, err)
+	}
+
+	return usage, nil
+}
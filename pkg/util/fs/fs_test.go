@@ -0,0 +1,177 @@
+// Copyright (c) 2023 ScyllaDB.
+
+package fs
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// mountTestFilesystem mounts a new filesystem of the given type at a temporary directory. Filesystems
+// other than tmpfs are created on a loop device backed by a sparse image.
+func mountTestFilesystem(t *testing.T, fsType string) string {
+	t.Helper()
+
+	if os.Geteuid() != 0 {
+		t.Skip("mounting filesystems requires root")
+	}
+
+	mountPoint := filepath.Join(t.TempDir(), "mnt")
+	err := os.Mkdir(mountPoint, 0770)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	source := fsType
+	if fsType != "tmpfs" {
+		mkfs, err := exec.LookPath("mkfs." + fsType)
+		if err != nil {
+			t.Skipf("mkfs.%s isn't available: %v", fsType, err)
+		}
+
+		image := filepath.Join(t.TempDir(), "image")
+		err = os.WriteFile(image, nil, 0660)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// 300MiB is the minimal size of XFS filesystem.
+		err = os.Truncate(image, 300<<20)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		out, err := exec.Command(mkfs, image).CombinedOutput()
+		if err != nil {
+			t.Fatalf("can't create %s filesystem: %v, output: %s", fsType, err, out)
+		}
+
+		source, err = AttachLoopDevice(image)
+		if err != nil {
+			t.Skipf("can't attach loop device: %v", err)
+		}
+		t.Cleanup(func() {
+			err := DetachLoopDevices(image)
+			if err != nil {
+				t.Error(err)
+			}
+		})
+	}
+
+	err = unix.Mount(source, mountPoint, fsType, 0, "")
+	if err != nil {
+		t.Skipf("can't mount %s filesystem: %v", fsType, err)
+	}
+	t.Cleanup(func() {
+		err := unix.Unmount(mountPoint, 0)
+		if err != nil {
+			t.Error(err)
+		}
+	})
+
+	return mountPoint
+}
+
+func TestGetFilesystem(t *testing.T) {
+	t.Parallel()
+
+	tt := []struct {
+		name           string
+		fsType         string
+		expectedFsType string
+	}{
+		{
+			name:           "tmpfs",
+			fsType:         "tmpfs",
+			expectedFsType: "tmpfs",
+		},
+		{
+			name:           "xfs",
+			fsType:         "xfs",
+			expectedFsType: "xfs",
+		},
+		{
+			name:           "ext4",
+			fsType:         "ext4",
+			expectedFsType: "ext4",
+		},
+	}
+
+	for i := range tt {
+		tc := tt[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			mountPoint := mountTestFilesystem(t, tc.fsType)
+
+			dir := filepath.Join(mountPoint, "dir")
+			err := os.Mkdir(dir, 0770)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, p := range []string{mountPoint, dir} {
+				fsType, err := GetFilesystem(p)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if fsType != tc.expectedFsType {
+					t.Errorf("expected %q filesystem at %q, got %q", tc.expectedFsType, p, fsType)
+				}
+
+				fsType, err = getFilesystemFromMountTable(p)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if fsType != tc.fsType {
+					t.Errorf("expected %q filesystem in mount table at %q, got %q", tc.fsType, p, fsType)
+				}
+			}
+		})
+	}
+}
+
+func TestGetFilesystemOfNonExistentPath(t *testing.T) {
+	t.Parallel()
+
+	_, err := GetFilesystem(filepath.Join(t.TempDir(), "non-existent"))
+	if err == nil {
+		t.Error("expected an error getting filesystem of non-existent path")
+	}
+}
+
+func TestGetDiskUsage(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	err := os.Mkdir(filepath.Join(dir, "nested"), 0750)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const fileSize = 64 * 1024
+	filePath := filepath.Join(dir, "nested", "file")
+	err = os.WriteFile(filePath, make([]byte, fileSize), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.Link(filePath, filepath.Join(dir, "link"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	usage, err := GetDiskUsage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Filesystems may allocate extra blocks, but a hardlinked file mustn't be counted twice.
+	if usage < fileSize || usage >= 2*fileSize {
+		t.Errorf("expected usage of a single %d bytes file plus directories, got %d", fileSize, usage)
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright (c) 2023 ScyllaDB.
+
+package fs
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// EncryptionKeySize is the size of fscrypt master keys, the one AES-256-XTS contents encryption requires.
+const EncryptionKeySize = unix.FSCRYPT_MAX_KEY_SIZE
+
+// SupportsEncryption returns whether the filesystem of dir supports fscrypt encryption.
+// Ext4 supports it only when the filesystem has the encrypt feature enabled.
+func SupportsEncryption(dir string) (bool, error) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	arg := unix.FscryptGetPolicyExArg{
+		Size: uint64(len(unix.FscryptGetPolicyExArg{}.Policy)),
+	}
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, f.Fd(), unix.FS_IOC_GET_ENCRYPTION_POLICY_EX, uintptr(unsafe.Pointer(&arg)))
+	switch {
+	case errno == 0, errors.Is(errno, unix.ENODATA):
+		return true, nil
+	case errors.Is(errno, unix.EOPNOTSUPP), errors.Is(errno, unix.ENOTTY):
+		return false, nil
+	default:
+		return false, fmt.Errorf("can't get encryption policy of %q: %w", dir, errno)
+	}
+}
+
+// AddEncryptionKey adds the master key to the filesystem of dir, making directories encrypted with it accessible.
+// It returns the hex encoded key identifier the kernel derives from the key. Adding a key again is a no-op.
+func AddEncryptionKey(dir string, key []byte) (string, error) {
+	if len(key) != EncryptionKeySize {
+		return "", fmt.Errorf("encryption key must be %d bytes long, got %d", EncryptionKeySize, len(key))
+	}
+
+	f, err := os.Open(dir)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	// The raw key follows the argument struct.
+	argSize := unsafe.Sizeof(unix.FscryptAddKeyArg{})
+	buf := make([]byte, argSize+uintptr(len(key)))
+	defer clear(buf)
+
+	arg := (*unix.FscryptAddKeyArg)(unsafe.Pointer(&buf[0]))
+	arg.Key_spec.Type = unix.FSCRYPT_KEY_SPEC_TYPE_IDENTIFIER
+	arg.Raw_size = uint32(len(key))
+	copy(buf[argSize:], key)
+
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, f.Fd(), unix.FS_IOC_ADD_ENCRYPTION_KEY, uintptr(unsafe.Pointer(&buf[0])))
+	if errno != 0 {
+		return "", fmt.Errorf("can't add encryption key to filesystem of %q: %w", dir, errno)
+	}
+
+	return hex.EncodeToString(arg.Key_spec.U[:unix.FSCRYPT_KEY_IDENTIFIER_SIZE]), nil
+}
+
+// SetEncryptionPolicy encrypts the empty directory dir, together with everything created in it, using the key
+// with the hex encoded identifier. The key has to be added to the filesystem first.
+func SetEncryptionPolicy(dir, keyIdentifier string) error {
+	identifier, err := hex.DecodeString(keyIdentifier)
+	if err != nil || len(identifier) != unix.FSCRYPT_KEY_IDENTIFIER_SIZE {
+		return fmt.Errorf("invalid encryption key identifier %q", keyIdentifier)
+	}
+
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	policy := unix.FscryptPolicyV2{
+		Version:                   unix.FSCRYPT_POLICY_V2,
+		Contents_encryption_mode:  unix.FSCRYPT_MODE_AES_256_XTS,
+		Filenames_encryption_mode: unix.FSCRYPT_MODE_AES_256_CTS,
+		Flags:                     unix.FSCRYPT_POLICY_FLAGS_PAD_32,
+	}
+	copy(policy.Master_key_identifier[:], identifier)
+
+	// The ioctl number encodes the size of v1 policies, the kernel tells them apart by their version.
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, f.Fd(), unix.FS_IOC_SET_ENCRYPTION_POLICY, uintptr(unsafe.Pointer(&policy)))
+	if errno != 0 {
+		return fmt.Errorf("can't set encryption policy of %q: %w", dir, errno)
+	}
+
+	return nil
+}
@@ -0,0 +1,67 @@
+// Copyright (c) 2023 ScyllaDB.
+
+package fs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	sysClassBlockPath = "/sys/class/block"
+
+	// Block device statistics count sectors in 512B units, regardless of the device sector size.
+	statSectorSize = 512
+)
+
+// IOStats holds the number of IO operations and bytes a block device completed since it was set up.
+type IOStats struct {
+	ReadOperations  uint64
+	ReadBytes       uint64
+	WriteOperations uint64
+	WriteBytes      uint64
+}
+
+// GetBlockDeviceIOStats returns IO statistics of the provided block device, e.g. /dev/loop0.
+func GetBlockDeviceIOStats(device string) (IOStats, error) {
+	statPath := filepath.Join(sysClassBlockPath, filepath.Base(device), "stat")
+	data, err := os.ReadFile(statPath)
+	if err != nil {
+		return IOStats{}, fmt.Errorf("can't read block device statistics %q: %w", statPath, err)
+	}
+
+	stats, err := parseBlockDeviceStat(string(data))
+	if err != nil {
+		return IOStats{}, fmt.Errorf("can't parse block device statistics %q: %w", statPath, err)
+	}
+
+	return stats, nil
+}
+
+// parseBlockDeviceStat parses the stat file of a block device.
+// https://www.kernel.org/doc/Documentation/block/stat.txt
+func parseBlockDeviceStat(data string) (IOStats, error) {
+	fields := strings.Fields(data)
+	if len(fields) < 7 {
+		return IOStats{}, fmt.Errorf("expected at least 7 fields, got %d", len(fields))
+	}
+
+	values := make([]uint64, 7)
+	for i := range values {
+		v, err := strconv.ParseUint(fields[i], 10, 64)
+		if err != nil {
+			return IOStats{}, fmt.Errorf("can't parse field %d: %w", i, err)
+		}
+		values[i] = v
+	}
+
+	return IOStats{
+		ReadOperations:  values[0],
+		ReadBytes:       values[2] * statSectorSize,
+		WriteOperations: values[4],
+		WriteBytes:      values[6] * statSectorSize,
+	}, nil
+}
@@ -0,0 +1,62 @@
+// Copyright (c) 2023 ScyllaDB.
+
+package fs
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseBlockDeviceStat(t *testing.T) {
+	t.Parallel()
+
+	tt := []struct {
+		name          string
+		data          string
+		expectedStats IOStats
+		expectedErr   bool
+	}{
+		{
+			name: "stat of a device",
+			data: "     120        3     4096       15      240        0     8192       30        0       40       45        0        0        0        0        0        0\n",
+			expectedStats: IOStats{
+				ReadOperations:  120,
+				ReadBytes:       4096 * 512,
+				WriteOperations: 240,
+				WriteBytes:      8192 * 512,
+			},
+		},
+		{
+			name:        "truncated stat",
+			data:        "120 3 4096",
+			expectedErr: true,
+		},
+		{
+			name:        "non-numeric field",
+			data:        "120 3 many 15 240 0 8192",
+			expectedErr: true,
+		},
+	}
+
+	for i := range tt {
+		tc := tt[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			stats, err := parseBlockDeviceStat(tc.data)
+			if tc.expectedErr {
+				if err == nil {
+					t.Errorf("expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(stats, tc.expectedStats) {
+				t.Errorf("expected %#v, got %#v", tc.expectedStats, stats)
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright (c) 2023 ScyllaDB.
+
+package fs
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+const sysBlockPath = "/sys/block"
+
+func runLosetup(args ...string) (string, error) {
+	cmd := exec.Command("losetup", args...)
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	err := cmd.Run()
+	if err != nil {
+		return "", fmt.Errorf("can't run losetup %q: %w, stdout: %q, stderr: %q", args, err, stdout.String(), stderr.String())
+	}
+
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// FindLoopDevices returns loop devices backed by the provided file.
+func FindLoopDevices(backingFile string) ([]string, error) {
+	out, err := runLosetup("--associated", backingFile, "--noheadings", "--output", "NAME")
+	if err != nil {
+		return nil, err
+	}
+
+	return strings.Fields(out), nil
+}
+
+// GetLoopDevicesByBackingFile returns attached loop devices keyed by paths of their backing files. Unlike
+// FindLoopDevices it reads sysfs without running losetup, so it's cheap enough to be called on every metrics scrape.
+func GetLoopDevicesByBackingFile() (map[string][]string, error) {
+	return getLoopDevicesByBackingFile(sysBlockPath)
+}
+
+func getLoopDevicesByBackingFile(sysBlockDir string) (map[string][]string, error) {
+	// Only attached loop devices have a backing file.
+	paths, err := filepath.Glob(filepath.Join(sysBlockDir, "loop*", "loop", "backing_file"))
+	if err != nil {
+		return nil, fmt.Errorf("can't list loop devices in %q: %w", sysBlockDir, err)
+	}
+
+	devices := map[string][]string{}
+	for _, p := range paths {
+		data, err := os.ReadFile(p)
+		if err != nil {
+			// The device was detached meanwhile.
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, fmt.Errorf("can't read backing file of loop device %q: %w", p, err)
+		}
+
+		backingFile := strings.TrimSuffix(string(data), "\n")
+		device := filepath.Join("/dev", filepath.Base(filepath.Dir(filepath.Dir(p))))
+		devices[backingFile] = append(devices[backingFile], device)
+	}
+
+	return devices, nil
+}
+
+// AttachLoopDevice returns a loop device backed by the provided file, setting up a new one when there is none.
+func AttachLoopDevice(backingFile string) (string, error) {
+	devices, err := FindLoopDevices(backingFile)
+	if err != nil {
+		return "", fmt.Errorf("can't find loop devices of %q: %w", backingFile, err)
+	}
+
+	if len(devices) != 0 {
+		return devices[0], nil
+	}
+
+	device, err := runLosetup("--find", "--show", "--direct-io=on", backingFile)
+	if err != nil {
+		return "", fmt.Errorf("can't attach loop device to %q: %w", backingFile, err)
+	}
+
+	return device, nil
+}
+
+// DetachLoopDevices detaches all loop devices backed by the provided file.
+func DetachLoopDevices(backingFile string) error {
+	devices, err := FindLoopDevices(backingFile)
+	if err != nil {
+		return fmt.Errorf("can't find loop devices of %q: %w", backingFile, err)
+	}
+
+	for _, device := range devices {
+		_, err = runLosetup("--detach", device)
+		if err != nil {
+			return fmt.Errorf("can't detach loop device %q: %w", device, err)
+		}
+	}
+
+	return nil
+}
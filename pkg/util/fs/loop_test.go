@@ -0,0 +1,55 @@
+// Copyright (c) 2023 ScyllaDB.
+
+package fs
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestGetLoopDevicesByBackingFile(t *testing.T) {
+	t.Parallel()
+
+	sysBlockDir := t.TempDir()
+
+	backingFiles := map[string]string{
+		"loop0": "/mnt/volumes/a/block\n",
+		"loop1": "/mnt/volumes/b/block\n",
+		"loop2": "/mnt/volumes/a/block\n",
+	}
+	for device, backingFile := range backingFiles {
+		dir := filepath.Join(sysBlockDir, device, "loop")
+		err := os.MkdirAll(dir, 0770)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = os.WriteFile(filepath.Join(dir, "backing_file"), []byte(backingFile), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Detached loop devices and other block devices have no backing file.
+	for _, device := range []string{"loop3", "sda"} {
+		err := os.Mkdir(filepath.Join(sysBlockDir, device), 0770)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	devices, err := getLoopDevicesByBackingFile(sysBlockDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string][]string{
+		"/mnt/volumes/a/block": {"/dev/loop0", "/dev/loop2"},
+		"/mnt/volumes/b/block": {"/dev/loop1"},
+	}
+	if !reflect.DeepEqual(devices, expected) {
+		t.Errorf("expected loop devices %v, got %v", expected, devices)
+	}
+}
@@ -0,0 +1,28 @@
+// Copyright (c) 2023 ScyllaDB.
+
+package fs
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+// MakeFilesystem formats the file or device at path with a new filesystem of the provided type,
+// overwriting any existing one.
+func MakeFilesystem(path, fsType string) error {
+	var args []string
+	switch fsType {
+	case "ext4":
+		args = append(args, "-F")
+	case "xfs":
+		args = append(args, "-f")
+	}
+	args = append(args, path)
+
+	out, err := exec.Command("mkfs."+fsType, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("can't make %s filesystem on %q: %w, output: %q", fsType, path, err, out)
+	}
+
+	return nil
+}
@@ -0,0 +1,98 @@
+// Copyright (c) 2023 ScyllaDB.
+
+package fs
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+	"k8s.io/mount-utils"
+)
+
+const (
+	mountInfoPath = "/proc/self/mountinfo"
+)
+
+// GetBackingMountEntry returns the mount table entry of the filesystem mounted at the provided mount point.
+// When the mount point is a bind mount, the entry of the mount it was bound from is returned, so the device
+// and options describe the filesystem backing it rather than the bind mount.
+func GetBackingMountEntry(mountPoint string) (mount.MountPoint, error) {
+	infos, err := mount.ParseMountInfo(mountInfoPath)
+	if err != nil {
+		return mount.MountPoint{}, fmt.Errorf("can't parse mount info at %q: %w", mountInfoPath, err)
+	}
+
+	// Mounts stacked on the same mount point are listed in the order they were mounted, the last one is visible.
+	var target *mount.MountInfo
+	for i := range infos {
+		if infos[i].MountPoint == mountPoint {
+			target = &infos[i]
+		}
+	}
+
+	if target == nil {
+		return mount.MountPoint{}, fmt.Errorf("mount entry for mountPoint %q not found", mountPoint)
+	}
+
+	backing := *target
+	if target.Root != "/" {
+		for _, info := range infos {
+			if info.Major == target.Major && info.Minor == target.Minor && info.Root == "/" {
+				backing = info
+				break
+			}
+		}
+	}
+
+	opts := make([]string, 0, len(backing.MountOptions)+len(backing.SuperOptions))
+	opts = append(opts, backing.MountOptions...)
+	opts = append(opts, backing.SuperOptions...)
+
+	return mount.MountPoint{
+		Device: backing.Source,
+		Path:   backing.MountPoint,
+		Type:   backing.FsType,
+		Opts:   opts,
+	}, nil
+}
+
+// IsBusyError returns true when err is caused by a mount being in use.
+// The umount command only reports it in its output, so it's matched as well.
+func IsBusyError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		return errno == syscall.EBUSY
+	}
+
+	msg := err.Error()
+	return strings.Contains(msg, "target is busy") || strings.Contains(msg, "device is busy")
+}
+
+// RemountReadOnly makes the bind mount at target read-only. Flags of bind mounts apply only on remount,
+// the ones passed when the bind mount is created are ignored by the kernel.
+func RemountReadOnly(target string) error {
+	err := unix.Mount("", target, "", unix.MS_REMOUNT|unix.MS_BIND|unix.MS_RDONLY, "")
+	if err != nil {
+		return fmt.Errorf("can't remount %q read-only: %w", target, err)
+	}
+
+	return nil
+}
+
+// LazyUnmount detaches the mount from the filesystem hierarchy right away,
+// the kernel cleans it up once it's no longer in use.
+func LazyUnmount(target string) error {
+	err := unix.Unmount(target, unix.MNT_DETACH)
+	if err != nil {
+		return fmt.Errorf("can't lazily unmount %q: %w", target, err)
+	}
+
+	return nil
+}
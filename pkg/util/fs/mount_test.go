@@ -0,0 +1,75 @@
+// Copyright (c) 2023 ScyllaDB.
+
+package fs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestGetBackingMountEntryOfBindMount(t *testing.T) {
+	t.Parallel()
+
+	mountPoint := mountTestFilesystem(t, "tmpfs")
+
+	source := filepath.Join(mountPoint, "volumes")
+	err := os.Mkdir(source, 0770)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	volumesDir := filepath.Join(t.TempDir(), "volumes")
+	err = os.Mkdir(volumesDir, 0770)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = unix.Mount(source, volumesDir, "", unix.MS_BIND, "")
+	if err != nil {
+		t.Skipf("can't bind mount %q: %v", source, err)
+	}
+	t.Cleanup(func() {
+		err := unix.Unmount(volumesDir, 0)
+		if err != nil {
+			t.Error(err)
+		}
+	})
+
+	entry, err := GetBackingMountEntry(volumesDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if entry.Path != mountPoint {
+		t.Errorf("expected backing mount at %q, got %q", mountPoint, entry.Path)
+	}
+
+	if entry.Type != "tmpfs" {
+		t.Errorf("expected tmpfs filesystem, got %q", entry.Type)
+	}
+
+	if entry.Device != "tmpfs" {
+		t.Errorf("expected tmpfs device, got %q", entry.Device)
+	}
+
+	entry, err = GetBackingMountEntry(mountPoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if entry.Path != mountPoint {
+		t.Errorf("expected mount at %q, got %q", mountPoint, entry.Path)
+	}
+}
+
+func TestGetBackingMountEntryOfNonMountPoint(t *testing.T) {
+	t.Parallel()
+
+	_, err := GetBackingMountEntry(t.TempDir())
+	if err == nil {
+		t.Errorf("expected error for directory which isn't a mount point")
+	}
+}
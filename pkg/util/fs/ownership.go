@@ -0,0 +1,98 @@
+// Copyright (c) 2023 ScyllaDB.
+
+package fs
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+const (
+	groupOwnershipMask = 0660
+	groupExecMask      = 0110
+)
+
+// SetGroupOwnership makes path owned by the group and writable by it, the same way kubelet applies fsGroup.
+// Directories get the setgid bit, so files created in them inherit the group. Unless recursive, only path
+// itself is changed and content which exists already keeps its ownership. Content isn't walked either when path
+// already has the group and mode, like with kubelet's OnRootMismatch fsGroupChangePolicy, as it was changed before.
+func SetGroupOwnership(path string, gid int, recursive bool) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return fmt.Errorf("can't stat %q: %w", path, err)
+	}
+
+	if !recursive {
+		return setGroupOwnership(path, info, gid)
+	}
+
+	if hasGroupOwnership(info, gid) {
+		return nil
+	}
+
+	// Path is changed last, so content is walked again when changing it fails midway.
+	err = filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if p == path {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return fmt.Errorf("can't stat %q: %w", p, err)
+		}
+
+		return setGroupOwnership(p, info, gid)
+	})
+	if err != nil {
+		return err
+	}
+
+	return setGroupOwnership(path, info, gid)
+}
+
+// hasGroupOwnership returns whether the file is owned by the group and has the mode setGroupOwnership sets.
+func hasGroupOwnership(info fs.FileInfo, gid int) bool {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || int(stat.Gid) != gid {
+		return false
+	}
+
+	mode := info.Mode()
+	return mode|groupOwnershipMask|groupExecMask|fs.ModeSetgid == mode
+}
+
+func setGroupOwnership(path string, info fs.FileInfo, gid int) error {
+	err := os.Lchown(path, -1, gid)
+	if err != nil {
+		return fmt.Errorf("can't set group of %q: %w", path, err)
+	}
+
+	// Mode of a symlink is ignored, chmod would change its target.
+	if info.Mode()&fs.ModeSymlink != 0 {
+		return nil
+	}
+
+	mask := fs.FileMode(groupOwnershipMask)
+	if info.IsDir() {
+		mask |= fs.ModeSetgid | groupExecMask
+	}
+
+	mode := info.Mode() | mask
+	if mode == info.Mode() {
+		return nil
+	}
+
+	err = os.Chmod(path, mode&(fs.ModePerm|fs.ModeSetuid|fs.ModeSetgid|fs.ModeSticky))
+	if err != nil {
+		return fmt.Errorf("can't set mode of %q: %w", path, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,49 @@
+// Copyright (c) 2023 ScyllaDB.
+
+package fs
+
+import (
+	"errors"
+	"syscall"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+)
+
+var transientErrnos = []syscall.Errno{
+	syscall.EBUSY,
+	syscall.EINTR,
+	syscall.EAGAIN,
+}
+
+// IsTransientError returns true when err is caused by a condition which is likely to go away on its own.
+// Aggregated errors are transient when any of them is.
+func IsTransientError(err error) bool {
+	for _, e := range transientErrnos {
+		if errors.Is(err, e) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// RetryOnTransientError calls fn until it succeeds, fails with a non-transient error,
+// or the backoff steps are exhausted. The last error is returned as is.
+func RetryOnTransientError(backoff wait.Backoff, fn func() error) error {
+	for {
+		err := fn()
+		if err == nil || !IsTransientError(err) {
+			return err
+		}
+
+		if backoff.Steps <= 1 {
+			return err
+		}
+
+		delay := backoff.Step()
+		klog.V(4).InfoS("Retrying filesystem operation after transient error", "error", err, "delay", delay)
+		time.Sleep(delay)
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright (c) 2023 ScyllaDB.
+
+package fs
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+func TestRetryOnTransientError(t *testing.T) {
+	t.Parallel()
+
+	backoff := wait.Backoff{
+		Steps:    3,
+		Duration: time.Millisecond,
+		Factor:   2,
+	}
+
+	tt := []struct {
+		name             string
+		errs             []error
+		expectedAttempts int
+		expectedErr      error
+	}{
+		{
+			name:             "succeeds at first attempt",
+			errs:             []error{nil},
+			expectedAttempts: 1,
+		},
+		{
+			name: "retries EBUSY until success",
+			errs: []error{
+				&os.PathError{Op: "remove", Path: "/volume", Err: syscall.EBUSY},
+				&os.PathError{Op: "remove", Path: "/volume", Err: syscall.EBUSY},
+				nil,
+			},
+			expectedAttempts: 3,
+		},
+		{
+			name: "retries EINTR until success",
+			errs: []error{
+				fmt.Errorf("can't remove: %w", syscall.EINTR),
+				nil,
+			},
+			expectedAttempts: 2,
+		},
+		{
+			name: "gives up when steps are exhausted",
+			errs: []error{
+				syscall.EBUSY,
+				syscall.EBUSY,
+				syscall.EBUSY,
+				nil,
+			},
+			expectedAttempts: 3,
+			expectedErr:      syscall.EBUSY,
+		},
+		{
+			name: "doesn't retry non-transient errors",
+			errs: []error{
+				syscall.EACCES,
+				nil,
+			},
+			expectedAttempts: 1,
+			expectedErr:      syscall.EACCES,
+		},
+	}
+
+	for i := range tt {
+		tc := tt[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			attempts := 0
+			err := RetryOnTransientError(backoff, func() error {
+				err := tc.errs[attempts]
+				attempts++
+				return err
+			})
+
+			if err != tc.expectedErr {
+				t.Errorf("expected error %v, got %v", tc.expectedErr, err)
+			}
+
+			if attempts != tc.expectedAttempts {
+				t.Errorf("expected %d attempts, got %d", tc.expectedAttempts, attempts)
+			}
+		})
+	}
+}
@@ -11,14 +11,16 @@ func Contains[T comparable](arr []T, elem T) bool {
 	return false
 }
 
+// Unique returns elements of the slice without duplicates, in the order of their first occurrence.
 func Unique[T comparable](slice []T) []T {
 	m := make(map[T]struct{}, len(slice))
+	u := make([]T, 0, len(slice))
 	for _, i := range slice {
-		m[i] = struct{}{}
-	}
+		if _, ok := m[i]; ok {
+			continue
+		}
 
-	u := make([]T, 0, len(slice))
-	for i := range m {
+		m[i] = struct{}{}
 		u = append(u, i)
 	}
 
@@ -3,6 +3,7 @@
 package slices_test
 
 import (
+	"reflect"
 	"testing"
 
 	"github.com/scylladb/local-csi-driver/pkg/util/slices"
@@ -44,3 +45,11 @@ func TestContains(t *testing.T) {
 		})
 	}
 }
+
+func TestUnique(t *testing.T) {
+	got := slices.Unique([]string{"bind", "ro", "bind", "nodev", "ro"})
+	expected := []string{"bind", "ro", "nodev"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v got %v", expected, got)
+	}
+}
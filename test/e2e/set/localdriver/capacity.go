@@ -4,17 +4,18 @@ package localdriver
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"time"
 
 	g "github.com/onsi/ginkgo/v2"
 	o "github.com/onsi/gomega"
 	"github.com/scylladb/local-csi-driver/pkg/driver"
-	"github.com/scylladb/local-csi-driver/pkg/driver/volume"
 	corev1 "k8s.io/api/core/v1"
 	storagev1 "k8s.io/api/storage/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
 	kubeframework "k8s.io/kubernetes/test/e2e/framework"
 	e2epod "k8s.io/kubernetes/test/e2e/framework/pod"
 	e2evolume "k8s.io/kubernetes/test/e2e/framework/volume"
@@ -97,6 +98,12 @@ var _ = g.Describe("Node Capacity", func() {
 			prePodCapacities[nodeName] = csc.Capacity
 		}
 
+		g.By("Reading capacity served by the driver on every node")
+		preProvisioningStatuses := make(map[string]*driver.CapacityStatus, len(nodesInCluster.Items))
+		for _, node := range nodesInCluster.Items {
+			preProvisioningStatuses[node.Name] = getCapacityStatus(ctx, f, driverNamespace, node.Name)
+		}
+
 		testPod := makePodSpec(testConfig, "", *volResource.VolSource)
 
 		g.By(fmt.Sprintf("Creating test pod %s with volume", testPod.Name))
@@ -153,10 +160,17 @@ var _ = g.Describe("Node Capacity", func() {
 		diff := prePodCapacities[nodeNameWhereTestPodLanded].DeepCopy()
 		diff.Sub(*postPodCapacity)
 
-		// Node capacity should be reduced by requested volume size and space reserved for next volume metadata.
-		expectedDiff := volResource.Pvc.Spec.Resources.Requests[corev1.ResourceStorage]
-		expectedDiff.Add(*resource.NewQuantity(volume.MetadataFileMaxSize, resource.DecimalSI))
-		o.Expect(diff.Equal(expectedDiff)).To(o.BeTrue())
+		// Node capacity should be reduced by whatever the driver accounts for the provisioned volume.
+		preProvisioningStatus := preProvisioningStatuses[nodeNameWhereTestPodLanded]
+		postProvisioningStatus := getCapacityStatus(ctx, f, driverNamespace, nodeNameWhereTestPodLanded)
+
+		requestedSize := volResource.Pvc.Spec.Resources.Requests[corev1.ResourceStorage]
+		o.Expect(postProvisioningStatus.VolumesCount - preProvisioningStatus.VolumesCount).To(o.Equal(1))
+		o.Expect(postProvisioningStatus.ProvisionedBytes - preProvisioningStatus.ProvisionedBytes).To(o.Equal(requestedSize.Value()))
+
+		expectedDiff := resource.NewQuantity(preProvisioningStatus.AvailableBytes-postProvisioningStatus.AvailableBytes, resource.BinarySI)
+		o.Expect(expectedDiff.Sign()).To(o.Equal(1), "expected capacity served by the driver to decrease, got %#v and %#v", preProvisioningStatus, postProvisioningStatus)
+		o.Expect(diff.Equal(*expectedDiff)).To(o.BeTrue(), "expected capacity to decrease by %s, got %s", expectedDiff, &diff)
 
 		g.By("Checking if capacity is aligned when volume is removed")
 
@@ -194,3 +208,28 @@ var _ = g.Describe("Node Capacity", func() {
 		}).WithTimeout(2 * capacityPollInterval).WithPolling(time.Second).Should(o.BeTrue())
 	})
 })
+
+// getDriverPodName returns the name of the driver pod running on the node.
+func getDriverPodName(ctx context.Context, f *kubeframework.Framework, driverNamespace, nodeName string) string {
+	pods, err := f.ClientSet.CoreV1().Pods(driverNamespace).List(ctx, metav1.ListOptions{
+		LabelSelector: "app.kubernetes.io/name=local-csi-driver",
+		FieldSelector: fields.OneTermEqualSelector("spec.nodeName", nodeName).String(),
+	})
+	o.Expect(err).NotTo(o.HaveOccurred())
+	o.Expect(pods.Items).To(o.HaveLen(1), "expected a single driver pod on %q node", nodeName)
+
+	return pods.Items[0].Name
+}
+
+// getCapacityStatus reads capacity served by the admin endpoint of the driver running on the node.
+func getCapacityStatus(ctx context.Context, f *kubeframework.Framework, driverNamespace, nodeName string) *driver.CapacityStatus {
+	podName := getDriverPodName(ctx, f, driverNamespace, nodeName)
+	data, err := f.ClientSet.CoreV1().Pods(driverNamespace).ProxyGet("http", podName, "8081", "/capacity", nil).DoRaw(ctx)
+	o.Expect(err).NotTo(o.HaveOccurred())
+
+	status := &driver.CapacityStatus{}
+	err = json.Unmarshal(data, status)
+	o.Expect(err).NotTo(o.HaveOccurred())
+
+	return status
+}
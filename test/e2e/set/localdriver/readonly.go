@@ -0,0 +1,111 @@
+// Copyright (c) 2023 ScyllaDB.
+
+package localdriver
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	g "github.com/onsi/ginkgo/v2"
+	o "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubeframework "k8s.io/kubernetes/test/e2e/framework"
+	e2epod "k8s.io/kubernetes/test/e2e/framework/pod"
+	e2evolume "k8s.io/kubernetes/test/e2e/framework/volume"
+	storageframework "k8s.io/kubernetes/test/e2e/storage/framework"
+	admissionapi "k8s.io/pod-security-admission/api"
+)
+
+var _ = g.Describe("Read-only volumes", func() {
+	defer g.GinkgoRecover()
+
+	d := &localCsiDriver{}
+
+	f := kubeframework.NewFrameworkWithCustomTimeouts("readonly", storageframework.GetDriverTimeouts(d))
+	f.NamespacePodSecurityEnforceLevel = admissionapi.LevelPrivileged
+
+	g.It("should mount a volume published read-only as read-only", func() {
+		ctx, ctxCancel := context.WithCancel(context.Background())
+		defer ctxCancel()
+
+		frameworkTestConfig := d.PrepareTest(ctx, f)
+
+		testPattern := storageframework.TestPattern{
+			Name:    "readonly",
+			VolType: storageframework.DynamicPV,
+			FsType:  "xfs",
+		}
+		testVolumeSizeRange := e2evolume.SizeRange{Min: fmt.Sprintf("%d", storageframework.MinFileSize)}
+		resource := storageframework.CreateVolumeResource(ctx, d, frameworkTestConfig, testPattern, testVolumeSizeRange)
+		o.Expect(resource.VolSource).NotTo(o.BeNil())
+		o.Expect(resource.VolSource.PersistentVolumeClaim).NotTo(o.BeNil())
+		defer func() {
+			cleanupCtx, cleanupCtxCancel := context.WithCancel(context.Background())
+			defer cleanupCtxCancel()
+			err := resource.CleanupResource(cleanupCtx)
+			o.Expect(err).NotTo(o.HaveOccurred())
+		}()
+
+		testConfig := storageframework.ConvertTestConfig(frameworkTestConfig)
+
+		volSrc := resource.VolSource.DeepCopy()
+		volSrc.PersistentVolumeClaim.ReadOnly = true
+		clientPod := makePodSpec(testConfig, "", *volSrc)
+
+		g.By(fmt.Sprintf("starting %s", clientPod.Name))
+		clientPod, err := f.ClientSet.CoreV1().Pods(testConfig.Namespace).Create(ctx, clientPod, metav1.CreateOptions{})
+		o.Expect(err).NotTo(o.HaveOccurred())
+		defer func() {
+			g.By("deleting test pod")
+			cleanupCtx, cleanupCtxCancel := context.WithCancel(context.Background())
+			defer cleanupCtxCancel()
+			err = e2epod.DeletePodWithWait(cleanupCtx, f.ClientSet, clientPod)
+			o.Expect(err).NotTo(o.HaveOccurred())
+		}()
+
+		err = e2epod.WaitTimeoutForPodRunningInNamespace(ctx, f.ClientSet, clientPod.Name, clientPod.Namespace, f.Timeouts.PodStart)
+		o.Expect(err).NotTo(o.HaveOccurred())
+
+		clientPod, err = f.ClientSet.CoreV1().Pods(testConfig.Namespace).Get(ctx, clientPod.Name, metav1.GetOptions{})
+		o.Expect(err).NotTo(o.HaveOccurred())
+
+		pvc, err := f.ClientSet.CoreV1().PersistentVolumeClaims(testConfig.Namespace).Get(ctx, resource.Pvc.Name, metav1.GetOptions{})
+		o.Expect(err).NotTo(o.HaveOccurred())
+		o.Expect(pvc.Spec.VolumeName).NotTo(o.BeEmpty())
+
+		// Kubelet makes the container mount read-only by itself, so only the mount of the driver tells it honored
+		// the read-only publish.
+		driverNamespace := frameworkTestConfig.DriverNamespace.Name
+		targetPath := filepath.Join("/var/lib/kubelet/pods", string(clientPod.UID), "volumes", "kubernetes.io~csi", pvc.Spec.VolumeName, "mount")
+		g.By(fmt.Sprintf("checking mount options of %s target path on %q node", targetPath, clientPod.Spec.NodeName))
+		mounts, _, err := e2epod.ExecWithOptionsContext(ctx, f, e2epod.ExecOptions{
+			Command:       []string{"cat", "/proc/mounts"},
+			Namespace:     driverNamespace,
+			PodName:       getDriverPodName(ctx, f, driverNamespace, clientPod.Spec.NodeName),
+			ContainerName: "local-csi-driver",
+			CaptureStdout: true,
+			CaptureStderr: true,
+		})
+		o.Expect(err).NotTo(o.HaveOccurred())
+
+		var mountOptions []string
+		for _, line := range strings.Split(mounts, "\n") {
+			fields := strings.Fields(line)
+			if len(fields) < 4 || fields[1] != targetPath {
+				continue
+			}
+			mountOptions = strings.Split(fields[3], ",")
+		}
+		o.Expect(mountOptions).To(o.ContainElement("ro"), "expected %s target path to be mounted read-only", targetPath)
+
+		testFile := filepath.Join(mountPath, "readonly-file")
+
+		g.By(fmt.Sprintf("writing to test file %s", testFile))
+		writeCmd := fmt.Sprintf("echo data > %s", testFile)
+		_, stderr, err := e2epod.ExecShellInPodWithFullOutput(ctx, f, clientPod.Name, writeCmd)
+		o.Expect(err).To(o.HaveOccurred())
+		o.Expect(stderr).To(o.ContainSubstring("Read-only file system"))
+	})
+})
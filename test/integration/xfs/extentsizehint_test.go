@@ -0,0 +1,93 @@
+// Copyright (c) 2023 ScyllaDB.
+
+//go:build integration
+
+package xfs
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/scylladb/local-csi-driver/pkg/driver/limit/xfs"
+	"github.com/scylladb/local-csi-driver/pkg/driver/limit/xfs/fxattrs"
+	"github.com/scylladb/local-csi-driver/pkg/driver/volume"
+)
+
+func getFSXAttrs(t *testing.T, path string) *fxattrs.FSXAttrs {
+	t.Helper()
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err := f.Close()
+		if err != nil {
+			t.Error(err)
+		}
+	}()
+
+	attrs, err := fxattrs.Get(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return attrs
+}
+
+func TestCreateVolumeSetsExtentSizeHint(t *testing.T) {
+	const extentSizeHint = 1024 * 1024
+
+	volumesDir := setupXFS(t)
+
+	sm, err := volume.NewStateManager(volumesDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	limiter, err := xfs.NewXFSLimiter(volumesDir, sm.GetVolumes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vm, err := volume.NewVolumeManager(volumesDir, sm, volume.WithLimiter(limiter), volume.WithVolumesDirFilesystem("xfs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err := vm.Close()
+		if err != nil {
+			t.Error(err)
+		}
+	}()
+
+	err = vm.CreateVolume("id", "name", 16*1024*1024, &volume.CreateVolumeOptions{ExtentSizeHint: extentSizeHint})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	volumePath := vm.GetVolumeStateByID("id").VolumePath(volumesDir)
+	attrs := getFSXAttrs(t, volumePath)
+	if attrs.ExtentSize != extentSizeHint || attrs.Flags&fxattrs.FlagExtentSizeInherit == 0 {
+		t.Errorf("expected volume directory to pass on extent size hint %d, got %d size and %#x flags", extentSizeHint, attrs.ExtentSize, attrs.Flags)
+	}
+
+	filePath := filepath.Join(volumePath, "data")
+	err = os.WriteFile(filePath, []byte("data"), 0660)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	attrs = getFSXAttrs(t, filePath)
+	if attrs.ExtentSize != extentSizeHint || attrs.Flags&fxattrs.FlagExtentSize == 0 {
+		t.Errorf("expected file to inherit extent size hint %d, got %d size and %#x flags", extentSizeHint, attrs.ExtentSize, attrs.Flags)
+	}
+
+	// The default XFS block size is 4Ki.
+	err = vm.CreateVolume("unaligned-id", "unaligned", 16*1024*1024, &volume.CreateVolumeOptions{ExtentSizeHint: 2048})
+	if !errors.Is(err, volume.ExtentSizeHintNotSupportedErr) {
+		t.Errorf("expected %v creating volume with hint which isn't a multiple of block size, got %v", volume.ExtentSizeHintNotSupportedErr, err)
+	}
+}
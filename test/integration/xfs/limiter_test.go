@@ -0,0 +1,233 @@
+// Copyright (c) 2023 ScyllaDB.
+
+//go:build integration
+
+package xfs
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/scylladb/local-csi-driver/pkg/driver/limit/xfs"
+	"github.com/scylladb/local-csi-driver/pkg/driver/limit/xfs/fxattrs"
+	"github.com/scylladb/local-csi-driver/pkg/driver/limit/xfs/quotactl"
+	"github.com/scylladb/local-csi-driver/pkg/driver/volume"
+	"k8s.io/mount-utils"
+)
+
+const (
+	imageSize = 512 * 1024 * 1024
+)
+
+func run(t *testing.T, name string, args ...string) {
+	t.Helper()
+
+	cmd := exec.Command(name, args...)
+	out := &bytes.Buffer{}
+	cmd.Stdout = out
+	cmd.Stderr = out
+	err := cmd.Run()
+	if err != nil {
+		t.Fatalf("can't run %s %q: %v, output: %q", name, args, err, out.String())
+	}
+}
+
+// setupXFS mounts a loopback XFS filesystem with project quotas enabled and returns its mount point.
+func setupXFS(t *testing.T) string {
+	t.Helper()
+
+	if os.Geteuid() != 0 {
+		t.Skip("integration test requires root privileges")
+	}
+
+	_, err := exec.LookPath("mkfs.xfs")
+	if err != nil {
+		t.Skip("integration test requires mkfs.xfs")
+	}
+
+	dir := t.TempDir()
+	imagePath := filepath.Join(dir, "xfs.img")
+	mountPoint := filepath.Join(dir, "volumes")
+
+	f, err := os.Create(imagePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = f.Truncate(imageSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	run(t, "mkfs.xfs", "-q", imagePath)
+
+	err = os.Mkdir(mountPoint, 0770)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	run(t, "mount", "-o", "loop,prjquota", imagePath, mountPoint)
+	t.Cleanup(func() {
+		err := mount.New("").Unmount(mountPoint)
+		if err != nil {
+			t.Errorf("can't unmount %q: %v", mountPoint, err)
+		}
+	})
+
+	return mountPoint
+}
+
+func TestXFSLimiter(t *testing.T) {
+	const capacity = 16 * 1024 * 1024
+
+	volumesDir := setupXFS(t)
+
+	sm, err := volume.NewStateManager(volumesDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	limiter, err := xfs.NewXFSLimiter(volumesDir, sm.GetVolumes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vm, err := volume.NewVolumeManager(volumesDir, sm, volume.WithLimiter(limiter))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err := vm.Close()
+		if err != nil {
+			t.Error(err)
+		}
+	}()
+
+	err = vm.CreateVolume("id", "name", capacity, &volume.CreateVolumeOptions{InodeLimit: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vs := vm.GetVolumeStateByID("id")
+	if vs == nil {
+		t.Fatal("expected volume state to exist")
+	}
+
+	volumePath := vs.VolumePath(volumesDir)
+	vd, err := os.Open(volumePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	projectID, err := fxattrs.GetProjectID(vd)
+	closeErr := vd.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if closeErr != nil {
+		t.Fatal(closeErr)
+	}
+
+	if projectID != vs.LimitID {
+		t.Errorf("expected volume directory project ID %d, got %d", vs.LimitID, projectID)
+	}
+
+	// Writing beyond the volume capacity must be rejected by the quota.
+	f, err := os.Create(filepath.Join(volumePath, "data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunk := make([]byte, 1024*1024)
+	var written int64
+	for written <= capacity {
+		n, err := f.Write(chunk)
+		written += int64(n)
+		if err != nil {
+			if !errors.Is(err, syscall.EDQUOT) {
+				t.Fatalf("expected %v, got %v", syscall.EDQUOT, err)
+			}
+			break
+		}
+	}
+	err = f.Close()
+	if err != nil && !errors.Is(err, syscall.EDQUOT) {
+		t.Fatal(err)
+	}
+
+	if written > capacity {
+		t.Errorf("expected writes to be limited to %d bytes, written %d", capacity, written)
+	}
+
+	limitBytes, limitInodes, err := limiter.GetLimit(vs.LimitID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if limitBytes != capacity {
+		t.Errorf("expected limit of %d bytes, got %d", capacity, limitBytes)
+	}
+
+	if limitInodes != 100 {
+		t.Errorf("expected limit of %d inodes, got %d", 100, limitInodes)
+	}
+
+	usedBytes, usedInodes, err := limiter.GetUsage(vs.LimitID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if usedBytes < written-int64(len(chunk)) || usedBytes > capacity {
+		t.Errorf("expected usage around %d bytes, got %d", written, usedBytes)
+	}
+
+	// The volume directory and the written file.
+	if usedInodes != 2 {
+		t.Errorf("expected %d used inodes, got %d", 2, usedInodes)
+	}
+
+	// Usage reported by the limiter must match the kernel accounting.
+	quota, err := quotactl.GetQuota(volumesDir, quotactl.QuotaTypeProject, vs.LimitID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// XFS quota block units are 512B basic blocks.
+	if int64(quota.BlocksCount<<9) != usedBytes {
+		t.Errorf("expected %d used bytes, kernel accounts %d", usedBytes, quota.BlocksCount<<9)
+	}
+
+	err = vm.ExpandVolume("id", 2*capacity)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	limitBytes, _, err = limiter.GetLimit(vs.LimitID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if limitBytes != 2*capacity {
+		t.Errorf("expected expanded limit of %d bytes, got %d", 2*capacity, limitBytes)
+	}
+
+	err = vm.DeleteVolume("id")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	limitBytes, limitInodes, err = limiter.GetLimit(vs.LimitID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if limitBytes != 0 || limitInodes != 0 {
+		t.Errorf("expected limit to be removed, got limit of %d bytes and %d inodes", limitBytes, limitInodes)
+	}
+}